@@ -0,0 +1,89 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+// Command chacha-bias writes a report of the statistical biases of
+// reduced-round standard ChaCha20, of the device's ChaCha20_24 experiment,
+// and of the two halves of the experiment's change to the quarter round on
+// their own, as measured by the drivers of package cryptanalysis. Like that
+// package, it needs a build with the wg_experimental tag:
+//
+//	go run -tags wg_experimental ./cmd/chacha-bias
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/device/cryptanalysis"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [-samples N] [-seed N] [-o FILE] [DRIVER...]\n", os.Args[0])
+	var names []string
+	for _, d := range cryptanalysis.Drivers() {
+		names = append(names, d.Name)
+	}
+	fmt.Fprintf(os.Stderr, "Drivers: %s\n", strings.Join(names, ", "))
+	flag.PrintDefaults()
+}
+
+func main() {
+	var (
+		samples = flag.Int("samples", 4096, "random inputs sampled per round count")
+		seed    = flag.Uint64("seed", 1, "`seed` of the random inputs")
+		output  = flag.String("o", "", "write the report to `file` instead of standard output")
+	)
+	flag.Usage = usage
+	flag.Parse()
+	drivers, ok := selectDrivers(flag.Args())
+	if *samples < 1 || !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+	err := cryptanalysis.Report(w, cryptanalysis.Targets(), drivers, cryptanalysis.Config{Samples: *samples, Seed: *seed})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// selectDrivers returns the drivers of the given names, or all of them if
+// none are given.
+func selectDrivers(names []string) ([]cryptanalysis.Driver, bool) {
+	all := cryptanalysis.Drivers()
+	if len(names) == 0 {
+		return all, true
+	}
+	var drivers []cryptanalysis.Driver
+	for _, name := range names {
+		i := slices.IndexFunc(all, func(d cryptanalysis.Driver) bool { return d.Name == name })
+		if i < 0 {
+			return nil, false
+		}
+		drivers = append(drivers, all[i])
+	}
+	return drivers, true
+}
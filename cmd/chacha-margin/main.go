@@ -0,0 +1,72 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+// Command chacha-margin writes a report comparing the diffusion of standard
+// ChaCha20 with that of the device's ChaCha20_24 experiment, and of the two
+// halves of the experiment's change to the quarter round on their own, so
+// that the effect of the modified rotations can be told from that of the
+// added increment. Like package chachamargin, it needs a build with the
+// wg_experimental tag:
+//
+//	go run -tags wg_experimental ./cmd/chacha-margin
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+
+	"golang.zx2c4.com/wireguard/device/chachamargin"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [-samples N] [-seed N] [-o FILE]\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+func main() {
+	var (
+		samples = flag.Int("samples", 2048, "random inputs sampled per round count")
+		seed    = flag.Uint64("seed", 1, "`seed` of the random inputs")
+		output  = flag.String("o", "", "write the report to `file` instead of standard output")
+	)
+	flag.Usage = usage
+	flag.Parse()
+	if *samples < 1 || flag.NArg() != 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	rotationsOnly := chachamargin.Modified
+	rotationsOnly.Name = "chacha20_24_rotations_only"
+	rotationsOnly.Increment = 0
+	incrementOnly := chachamargin.Standard
+	incrementOnly.Name = "chacha20_increment_only"
+	incrementOnly.Increment = chachamargin.Modified.Increment
+	variants := []chachamargin.Variant{chachamargin.Standard, chachamargin.Modified, rotationsOnly, incrementOnly}
+
+	out := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+	w := bufio.NewWriter(out)
+	err := chachamargin.Report(w, variants, *samples, *seed)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,122 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+// Command genvectors writes the JSON test vectors of the experimental
+// primitives of the device package, ChaCha20_24, Poly1795 and
+// DoublePoly1305, or verifies files of them, so that other implementations
+// of these algorithms can check that they interoperate. It needs a build
+// with the wg_experimental tag, which alone contains the primitives:
+//
+//	go run -tags wg_experimental ./cmd/genvectors -o device/vectors/testdata
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/device/vectors"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [-seed N] [-o DIR] [ALGORITHM...]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s -verify FILE...\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "Algorithms: %s\n", strings.Join(vectors.Algorithms(), ", "))
+	flag.PrintDefaults()
+}
+
+func main() {
+	var (
+		seed   = flag.Uint64("seed", vectors.DefaultSeed, "`seed` of the inputs of the vectors")
+		output = flag.String("o", "", "write the vectors of each algorithm to a file in `dir` instead of standard output")
+		verify = flag.Bool("verify", false, "verify the vectors of the files given instead of writing any")
+	)
+	flag.Usage = usage
+	flag.Parse()
+
+	var err error
+	if *verify {
+		if flag.NArg() == 0 {
+			usage()
+			os.Exit(2)
+		}
+		err = verifyFiles(flag.Args())
+	} else {
+		algorithms := flag.Args()
+		if len(algorithms) == 0 {
+			algorithms = vectors.Algorithms()
+		}
+		err = generate(algorithms, *seed, *output)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// fileName returns the name of the file of the vectors of algorithm.
+func fileName(algorithm string) string {
+	return strings.ToLower(algorithm) + ".json"
+}
+
+func generate(algorithms []string, seed uint64, dir string) error {
+	if len(algorithms) == 0 {
+		return fmt.Errorf("no algorithms in this build: build with the wg_experimental tag")
+	}
+	for _, name := range algorithms {
+		f, err := vectors.Generate(name, seed)
+		if err != nil {
+			return err
+		}
+		if dir == "" {
+			if err := vectors.Write(os.Stdout, f); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeFile(filepath.Join(dir, fileName(name)), f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeFile(path string, f *vectors.File) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(out)
+	err = vectors.Write(w, f)
+	if err == nil {
+		err = w.Flush()
+	}
+	if errClose := out.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+func verifyFiles(paths []string) error {
+	for _, path := range paths {
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		f, err := vectors.Read(bufio.NewReader(in))
+		in.Close()
+		if err == nil {
+			err = vectors.Verify(f)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		fmt.Printf("%s: %d vectors of %s verified\n", path, len(f.Vectors), f.Algorithm)
+	}
+	return nil
+}
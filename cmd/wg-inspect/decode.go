@@ -0,0 +1,339 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tai64n"
+)
+
+type inspector struct {
+	hasPublic      bool
+	hasPrivate     bool
+	privateKey     device.NoisePrivateKey
+	publicKey      device.NoisePublicKey
+	checker        device.CookieChecker
+	protocol       *protocol // nil for that of standard WireGuard
+	channelBinding [device.HandshakeHashSize]byte
+}
+
+// A protocol is the Noise construction, identifier and handshake hash of
+// the handshakes inspected, as set on a device with noise_construction,
+// noise_identifier and handshake_hash.
+type protocol struct {
+	hasher          device.HandshakeHasher
+	initialChainKey [device.HandshakeHashSize]byte
+	initialHash     [device.HandshakeHashSize]byte
+}
+
+var standardProtocol, _ = newProtocol("", "", "")
+
+// newProtocol derives the initial handshake state the way a device does,
+// with the hash in place of BLAKE2s in the standard construction. Empty
+// strings select the standard values.
+func newProtocol(construction, identifier, hashName string) (*protocol, error) {
+	if construction == "" {
+		construction = device.NoiseConstruction
+	}
+	if identifier == "" {
+		identifier = device.WGIdentifier
+	}
+	if hashName == "" {
+		hashName = device.StandardHandshakeHash
+	}
+	hasher, err := device.LookupHandshakeHasher(hashName)
+	if err != nil {
+		return nil, err
+	}
+	if construction == device.NoiseConstruction {
+		construction = strings.TrimSuffix(device.NoiseConstruction, device.StandardHandshakeHash) + hasher.Name()
+	}
+	p := &protocol{hasher: hasher}
+	p.sum(&p.initialChainKey, []byte(construction))
+	p.sum(&p.initialHash, p.initialChainKey[:], []byte(identifier))
+	return p, nil
+}
+
+func (p *protocol) sum(dst *[device.HandshakeHashSize]byte, in ...[]byte) {
+	h := p.hasher.New()
+	for _, b := range in {
+		h.Write(b)
+	}
+	h.Sum(dst[:0])
+}
+
+func (p *protocol) hmac(dst *[device.HandshakeHashSize]byte, key []byte, in ...[]byte) {
+	h := hmac.New(p.hasher.New, key)
+	for _, b := range in {
+		h.Write(b)
+	}
+	h.Sum(dst[:0])
+}
+
+func (p *protocol) mixHash(hash *[device.HandshakeHashSize]byte, data []byte) {
+	p.sum(hash, hash[:], data)
+}
+
+func (p *protocol) kdf1(t0 *[device.HandshakeHashSize]byte, key, input []byte) {
+	var prk [device.HandshakeHashSize]byte
+	p.hmac(&prk, key, input)
+	p.hmac(t0, prk[:], []byte{0x1})
+}
+
+func (p *protocol) kdf2(t0, t1 *[device.HandshakeHashSize]byte, key, input []byte) {
+	var prk [device.HandshakeHashSize]byte
+	p.hmac(&prk, key, input)
+	p.hmac(t0, prk[:], []byte{0x1})
+	p.hmac(t1, prk[:], t0[:], []byte{0x2})
+}
+
+// setProtocol sets the protocol of the handshakes inspected.
+func (in *inspector) setProtocol(construction, identifier, hashName string) error {
+	p, err := newProtocol(construction, identifier, hashName)
+	if err != nil {
+		return err
+	}
+	in.protocol = p
+	return nil
+}
+
+// setChannelBinding sets the channel binding the initiators were configured
+// with, as set on a peer with channel_binding.
+func (in *inspector) setChannelBinding(binding [device.HandshakeHashSize]byte) {
+	in.channelBinding = binding
+}
+
+func (in *inspector) setPublicKey(pk device.NoisePublicKey) {
+	in.publicKey = pk
+	in.hasPublic = true
+	in.checker.Init(pk)
+}
+
+func (in *inspector) setPrivateKey(sk device.NoisePrivateKey) {
+	var pk device.NoisePublicKey
+	curve25519.ScalarBaseMult((*[32]byte)(&pk), (*[32]byte)(&sk))
+	in.privateKey = sk
+	in.hasPrivate = true
+	in.setPublicKey(pk)
+}
+
+func b64(b []byte) string {
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// inspect returns a one-line description of a UDP payload. Handshake
+// messages are classified by type and minimum size, as those of this fork
+// carry trailers: cipher suite offers and selections, the ML-KEM key and
+// ciphertext of hybrid handshakes, response data and padding.
+func (in *inspector) inspect(b []byte) string {
+	if len(b) < 4 {
+		return fmt.Sprintf("short packet (%d bytes)", len(b))
+	}
+	msgType := binary.LittleEndian.Uint32(b)
+	var s strings.Builder
+	switch {
+	case msgType == device.MessageInitiationType && len(b) >= device.MessageInitiationSize ||
+		msgType == device.MessageInitiationHybridType && len(b) >= device.MessageInitiationHybridSize:
+		var msg device.MessageInitiation
+		b, trailer := b[:device.MessageInitiationSize], b[device.MessageInitiationSize:]
+		binary.Read(bytes.NewReader(b), binary.LittleEndian, &msg)
+		if msgType == device.MessageInitiationHybridType {
+			s.WriteString("hybrid-")
+		}
+		fmt.Fprintf(&s, "initiation sender=%d ephemeral=%s", msg.Sender, b64(msg.Ephemeral[:]))
+		in.describeMACs(&s, b)
+		var chainKey *[device.HandshakeHashSize]byte
+		if in.hasPrivate {
+			chainKey = in.describeInitiation(&s, &msg)
+		}
+		in.describeInitiationTrailer(&s, msgType, b, trailer, chainKey)
+	case msgType == device.MessageResponseType && len(b) >= device.MessageResponseSize ||
+		msgType == device.MessageResponseHybridType && len(b) >= device.MessageResponseHybridSize:
+		var msg device.MessageResponse
+		b, trailer := b[:device.MessageResponseSize], b[device.MessageResponseSize:]
+		binary.Read(bytes.NewReader(b), binary.LittleEndian, &msg)
+		if msgType == device.MessageResponseHybridType {
+			s.WriteString("hybrid-")
+			trailer = trailer[device.MessageHybridResponseTrailerSize:]
+		}
+		fmt.Fprintf(&s, "response sender=%d receiver=%d ephemeral=%s", msg.Sender, msg.Receiver, b64(msg.Ephemeral[:]))
+		in.describeMACs(&s, b)
+		// The selection, response data and padding are sealed to the
+		// initiator, so only their size is known.
+		if len(trailer) != 0 {
+			fmt.Fprintf(&s, " trailer=%d", len(trailer))
+			if len(trailer) >= device.MessageResponseDataOverhead {
+				fmt.Fprintf(&s, " data<=%d", len(trailer)-device.MessageResponseDataOverhead)
+			}
+		}
+	case msgType == device.MessageCookieReplyType && len(b) == device.MessageCookieReplySize:
+		fmt.Fprintf(&s, "cookie-reply receiver=%d", binary.LittleEndian.Uint32(b[4:]))
+	case msgType == device.MessageTransportType && len(b) >= device.MessageTransportSize:
+		receiver := binary.LittleEndian.Uint32(b[device.MessageTransportOffsetReceiver:])
+		counter := binary.LittleEndian.Uint64(b[device.MessageTransportOffsetCounter:])
+		content := len(b) - device.MessageTransportSize
+		if content == 0 {
+			fmt.Fprintf(&s, "keepalive receiver=%d counter=%d", receiver, counter)
+		} else {
+			fmt.Fprintf(&s, "transport receiver=%d counter=%d plaintext<=%d", receiver, counter, content)
+		}
+	case msgType >= device.MessageInitiationType && msgType <= device.MessageTransportType ||
+		msgType == device.MessageInitiationHybridType || msgType == device.MessageResponseHybridType:
+		fmt.Fprintf(&s, "malformed type=%d length=%d", msgType, len(b))
+	default:
+		fmt.Fprintf(&s, "unknown type=%#x length=%d", msgType, len(b))
+	}
+	return s.String()
+}
+
+// describeMACs describes the MACs at the end of b, a handshake message
+// without its trailer.
+func (in *inspector) describeMACs(s *strings.Builder, b []byte) {
+	if in.hasPublic {
+		if in.checker.CheckMAC1(b) {
+			s.WriteString(" mac1=valid")
+		} else {
+			s.WriteString(" mac1=INVALID")
+		}
+	}
+	mac2 := b[len(b)-blake2s.Size128:]
+	if bytes.Equal(mac2, make([]byte, blake2s.Size128)) {
+		s.WriteString(" mac2=none")
+	} else {
+		s.WriteString(" mac2=present")
+	}
+}
+
+// describeInitiation follows the responder side of the handshake far enough
+// to recover the initiator's static key and timestamp, and returns the
+// chaining key after the initiation, or nil if it cannot be decoded.
+func (in *inspector) describeInitiation(s *strings.Builder, msg *device.MessageInitiation) *[device.HandshakeHashSize]byte {
+	p := in.protocol
+	if p == nil {
+		p = standardProtocol
+	}
+	static, timestamp, chainKey, err := p.decodeInitiation(&in.privateKey, &in.publicKey, &in.channelBinding, msg)
+	if err != nil {
+		fmt.Fprintf(s, " decode=%v", err)
+		return nil
+	}
+	fmt.Fprintf(s, " static=%s timestamp=%q", b64(static[:]), timestamp.String())
+	return &chainKey
+}
+
+// describeInitiationTrailer describes the trailer of an initiation b of the
+// given type: the ML-KEM encapsulation key of a hybrid initiation, then a
+// cipher suite offer or padding, or both. Given the chaining key after the
+// initiation, it opens the key and offer; otherwise only the size of the
+// trailer is known.
+func (in *inspector) describeInitiationTrailer(s *strings.Builder, msgType uint32, b, trailer []byte, chainKey *[device.HandshakeHashSize]byte) {
+	p := in.protocol
+	if p == nil {
+		p = standardProtocol
+	}
+	if chainKey == nil {
+		if len(trailer) != 0 {
+			fmt.Fprintf(s, " trailer=%d", len(trailer))
+		}
+		return
+	}
+	var key [device.HandshakeHashSize]byte
+	if msgType == device.MessageInitiationHybridType {
+		sealed := trailer[:device.MessageHybridInitiationTrailerSize]
+		trailer = trailer[device.MessageHybridInitiationTrailerSize:]
+		p.kdf1(&key, chainKey[:], []byte(device.WGLabelHybridInitiation))
+		aead, _ := chacha20poly1305.New(key[:])
+		if _, err := aead.Open(nil, zeroNonce[:], sealed, b); err != nil {
+			s.WriteString(" encapsulation-key=INVALID")
+		} else {
+			s.WriteString(" encapsulation-key=valid")
+		}
+	}
+	if len(trailer) >= device.MessageCipherSuiteOfferSize {
+		p.kdf1(&key, chainKey[:], []byte(device.WGLabelCipherSuiteOffer))
+		aead, _ := chacha20poly1305.New(key[:])
+		if ids, err := aead.Open(nil, zeroNonce[:], trailer[:device.MessageCipherSuiteOfferSize], b); err == nil {
+			fmt.Fprintf(s, " offer=%s", describeOffer(ids))
+			trailer = trailer[device.MessageCipherSuiteOfferSize:]
+		}
+	}
+	if len(trailer) != 0 {
+		fmt.Fprintf(s, " padding=%d", len(trailer))
+	}
+}
+
+// describeOffer returns the names of the suites of the ids of an offer,
+// in order, or their ids for those this build does not know.
+func describeOffer(ids []byte) string {
+	known := make(map[uint32]string)
+	for _, name := range device.CipherSuites() {
+		sum := blake2s.Sum256([]byte(name))
+		known[binary.LittleEndian.Uint32(sum[:])] = name
+	}
+	var names []string
+	for i := 0; i+4 <= len(ids); i += 4 {
+		id := binary.LittleEndian.Uint32(ids[i:])
+		if id == 0 {
+			continue
+		}
+		if name, ok := known[id]; ok {
+			names = append(names, name)
+		} else {
+			names = append(names, fmt.Sprintf("%08x", id))
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+var zeroNonce [chacha20poly1305.NonceSize]byte
+
+func (p *protocol) decodeInitiation(sk *device.NoisePrivateKey, pk *device.NoisePublicKey, binding *[device.HandshakeHashSize]byte, msg *device.MessageInitiation) (static device.NoisePublicKey, timestamp tai64n.Timestamp, chainKey [device.HandshakeHashSize]byte, err error) {
+	var (
+		hash [device.HandshakeHashSize]byte
+		key  [chacha20poly1305.KeySize]byte
+	)
+
+	chainKey = p.initialChainKey
+	hash = p.initialHash
+	p.mixHash(&hash, pk[:])
+	p.mixHash(&hash, msg.Ephemeral[:])
+	p.kdf1(&chainKey, chainKey[:], msg.Ephemeral[:])
+
+	ss, err := curve25519.X25519(sk[:], msg.Ephemeral[:])
+	if err != nil {
+		return static, timestamp, chainKey, err
+	}
+	p.kdf2(&chainKey, &key, chainKey[:], ss)
+	aead, _ := chacha20poly1305.New(key[:])
+	if _, err = aead.Open(static[:0], zeroNonce[:], msg.Static[:], hash[:]); err != nil {
+		return static, timestamp, chainKey, fmt.Errorf("static: %w", err)
+	}
+	p.mixHash(&hash, msg.Static[:])
+	if *binding != ([device.HandshakeHashSize]byte{}) {
+		p.mixHash(&hash, binding[:])
+	}
+
+	ss, err = curve25519.X25519(sk[:], static[:])
+	if err != nil {
+		return static, timestamp, chainKey, err
+	}
+	p.kdf2(&chainKey, &key, chainKey[:], ss)
+	aead, _ = chacha20poly1305.New(key[:])
+	if _, err = aead.Open(timestamp[:0], zeroNonce[:], msg.Timestamp[:], hash[:]); err != nil {
+		return static, timestamp, chainKey, fmt.Errorf("timestamp: %w", err)
+	}
+	return static, timestamp, chainKey, nil
+}
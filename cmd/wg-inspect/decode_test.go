@@ -0,0 +1,280 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func newKeyPair(t *testing.T) (sk device.NoisePrivateKey, pk device.NoisePublicKey) {
+	if _, err := rand.Read(sk[:]); err != nil {
+		t.Fatal(err)
+	}
+	sk[0] &= 248
+	sk[31] = (sk[31] & 127) | 64
+	curve25519.ScalarBaseMult((*[32]byte)(&pk), (*[32]byte)(&sk))
+	return
+}
+
+// initiation returns a marshaled handshake initiation from a fresh device,
+// with MAC1 computed for the given responder.
+func initiation(t *testing.T, responder device.NoisePublicKey) (packet []byte, initiator device.NoisePublicKey) {
+	sk, pk := newKeyPair(t)
+	dev := device.NewDevice(tuntest.NewChannelTUN().TUN(), bindtest.NewChannelBinds()[0], device.NewLogger(device.LogLevelError, ""))
+	t.Cleanup(dev.Close)
+	if err := dev.IpcSet(fmt.Sprintf("private_key=%x\npublic_key=%x\n", sk[:], responder[:])); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := dev.CreateMessageInitiation(dev.LookupPeer(responder))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, msg)
+	packet = buf.Bytes()
+	var gen device.CookieGenerator
+	gen.Init(responder)
+	gen.AddMacs(packet)
+	return packet, pk
+}
+
+func TestInspectInitiation(t *testing.T) {
+	sk, pk := newKeyPair(t)
+	packet, initiator := initiation(t, pk)
+
+	var in inspector
+	if s := in.inspect(packet); !strings.HasPrefix(s, "initiation ") || strings.Contains(s, "mac1=") {
+		t.Errorf("without keys: %q", s)
+	}
+
+	in.setPublicKey(pk)
+	if s := in.inspect(packet); !strings.Contains(s, " mac1=valid") {
+		t.Errorf("with public key: %q", s)
+	}
+
+	in.setPrivateKey(sk)
+	s := in.inspect(packet)
+	if !strings.Contains(s, " static="+b64(initiator[:])) {
+		t.Errorf("with private key, initiator static not decoded: %q", s)
+	}
+
+	_, other := newKeyPair(t)
+	in.setPublicKey(other)
+	if s := in.inspect(packet); !strings.Contains(s, " mac1=INVALID") {
+		t.Errorf("with wrong public key: %q", s)
+	}
+
+	keepalive := make([]byte, device.MessageKeepaliveSize)
+	keepalive[0] = device.MessageTransportType
+	if s := in.inspect(keepalive); !strings.HasPrefix(s, "keepalive ") {
+		t.Errorf("keepalive classified as %q", s)
+	}
+	if s := in.inspect(packet[:100]); !strings.HasPrefix(s, "malformed ") {
+		t.Errorf("truncated initiation classified as %q", s)
+	}
+}
+
+// sentInitiation returns the first initiation a fresh device configured
+// with devCfg sends to the responder, configured as a peer with peerCfg,
+// trailers included.
+func sentInitiation(t *testing.T, responder device.NoisePublicKey, devCfg, peerCfg string) (packet []byte, initiator device.NoisePublicKey) {
+	sk, pk := newKeyPair(t)
+	binds := bindtest.NewChannelBinds()
+	dev := device.NewDevice(tuntest.NewChannelTUN().TUN(), binds[0], device.NewLogger(device.LogLevelSilent, ""))
+	t.Cleanup(dev.Close)
+	cfg := fmt.Sprintf("private_key=%x\n%spublic_key=%x\nendpoint=127.0.0.1:1\n%s", sk[:], devCfg, responder[:], peerCfg)
+	if err := dev.IpcSet(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.Up(); err != nil {
+		t.Fatal(err)
+	}
+	fns, _, err := binds[1].Open(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { binds[1].Close() })
+	if err := dev.LookupPeer(responder).SendHandshakeInitiation(false); err != nil {
+		t.Fatal(err)
+	}
+	bufs := [][]byte{make([]byte, 1<<16)}
+	sizes := make([]int, 1)
+	eps := make([]conn.Endpoint, 1)
+	if _, err := fns[0](bufs, sizes, eps); err != nil {
+		t.Fatal(err)
+	}
+	return bufs[0][:sizes[0]], pk
+}
+
+func TestInspectInitiationTrailers(t *testing.T) {
+	sk, pk := newKeyPair(t)
+	var binding [32]byte
+	binding[0] = 1
+	tests := []struct {
+		name    string
+		devCfg  string
+		peerCfg string
+		setup   func(in *inspector) error
+		prefix  string
+		want    []string
+	}{
+		{
+			name:    "offer",
+			peerCfg: "cipher_suite_offer=AES256GCM,ChaCha20Poly1305\n",
+			prefix:  "initiation ",
+			want:    []string{" offer=AES256GCM,ChaCha20Poly1305"},
+		},
+		{
+			name:    "padded offer",
+			devCfg:  "handshake_padding=512\n",
+			peerCfg: "cipher_suite_offer=AES256GCM\n",
+			prefix:  "initiation ",
+			want:    []string{" offer=AES256GCM", fmt.Sprintf(" padding=%d", 512-device.MessageInitiationOfferSize)},
+		},
+		{
+			name:    "hybrid",
+			devCfg:  "",
+			peerCfg: "pq_mode=hybrid\n",
+			prefix:  "hybrid-initiation ",
+			want:    []string{" encapsulation-key=valid"},
+		},
+		{
+			name:   "protocol",
+			devCfg: "noise_construction=test construction\nnoise_identifier=test identifier\n",
+			setup: func(in *inspector) error {
+				return in.setProtocol("test construction", "test identifier", "")
+			},
+			prefix: "initiation ",
+		},
+		{
+			name:   "hash",
+			devCfg: "handshake_hash=SHA256\n",
+			setup: func(in *inspector) error {
+				return in.setProtocol("", "", "SHA256")
+			},
+			prefix: "initiation ",
+		},
+		{
+			name:    "channel binding",
+			peerCfg: fmt.Sprintf("channel_binding=%x\n", binding[:]),
+			setup: func(in *inspector) error {
+				in.setChannelBinding(binding)
+				return nil
+			},
+			prefix: "initiation ",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			packet, initiator := sentInitiation(t, pk, tt.devCfg, tt.peerCfg)
+			var in inspector
+			if s := in.inspect(packet); !strings.HasPrefix(s, tt.prefix) || strings.Contains(s, " trailer=") != (len(tt.want) != 0) {
+				t.Errorf("without keys: %q", s)
+			}
+			in.setPrivateKey(sk)
+			if tt.setup != nil {
+				if s := in.inspect(packet); !strings.Contains(s, " decode=") {
+					t.Errorf("decoded without protocol: %q", s)
+				}
+				if err := tt.setup(&in); err != nil {
+					t.Fatal(err)
+				}
+			}
+			s := in.inspect(packet)
+			if !strings.HasPrefix(s, tt.prefix) || !strings.Contains(s, " mac1=valid") || !strings.Contains(s, " static="+b64(initiator[:])) {
+				t.Errorf("with private key: %q", s)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(s, want) {
+					t.Errorf("with private key, %q missing: %q", want, s)
+				}
+			}
+		})
+	}
+}
+
+func TestInspectResponseTrailers(t *testing.T) {
+	var in inspector
+	for _, tt := range []struct {
+		msgType uint32
+		size    int
+		want    string
+	}{
+		{device.MessageResponseType, device.MessageResponseSize, "response "},
+		{device.MessageResponseType, device.MessageResponseSelectionSize, "response "},
+		{device.MessageResponseType, device.MessageResponseSelectionSize + device.MessageResponseDataOverhead + 8, "response "},
+		{device.MessageResponseHybridType, device.MessageResponseHybridSize, "hybrid-response "},
+		{device.MessageResponseHybridType, device.MessageResponseHybridSize + device.MessageResponseDataOverhead + 8, "hybrid-response "},
+		{device.MessageResponseHybridType, device.MessageResponseSize, "malformed "},
+	} {
+		packet := make([]byte, tt.size)
+		binary.LittleEndian.PutUint32(packet, tt.msgType)
+		s := in.inspect(packet)
+		trailer := tt.size - device.MessageResponseSize
+		if tt.msgType == device.MessageResponseHybridType {
+			trailer -= device.MessageHybridResponseTrailerSize
+		}
+		if !strings.HasPrefix(s, tt.want) || strings.Contains(s, " mac2=present") ||
+			trailer > 0 && !strings.Contains(s, fmt.Sprintf(" trailer=%d data<=%d", trailer, trailer-device.MessageResponseDataOverhead)) {
+			t.Errorf("type %d of %d bytes classified as %q", tt.msgType, tt.size, s)
+		}
+	}
+}
+
+func TestPcapReader(t *testing.T) {
+	payload := []byte{device.MessageTransportType, 0, 0, 0, 1, 0, 0, 0}
+	udp := binary.BigEndian.AppendUint16(nil, 51820)
+	udp = binary.BigEndian.AppendUint16(udp, 51821)
+	udp = binary.BigEndian.AppendUint16(udp, uint16(8+len(payload)))
+	udp = append(udp, 0, 0)
+	udp = append(udp, payload...)
+	ip := []byte{0x45, 0, 0, byte(20 + len(udp)), 0, 0, 0x40, 0, 64, 17, 0, 0, 10, 0, 0, 1, 10, 0, 0, 2}
+	ip = append(ip, udp...)
+	frame := append(make([]byte, 12), 0x08, 0x00)
+	frame = append(frame, ip...)
+
+	var file bytes.Buffer
+	hdr := make([]byte, pcapHeaderSize)
+	binary.LittleEndian.PutUint32(hdr[0:], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint32(hdr[20:], linkTypeEther)
+	file.Write(hdr)
+	rec := make([]byte, pcapRecordSize)
+	binary.LittleEndian.PutUint32(rec[0:], 1700000000)
+	binary.LittleEndian.PutUint32(rec[8:], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(rec[12:], uint32(len(frame)))
+	file.Write(rec)
+	file.Write(frame)
+
+	r, err := newPcapReader(&file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkt, err := r.next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkt.src.String() != "10.0.0.1:51820" || pkt.dst.String() != "10.0.0.2:51821" || !bytes.Equal(pkt.payload, payload) {
+		t.Errorf("decoded %v -> %v %x", pkt.src, pkt.dst, pkt.payload)
+	}
+	if !pkt.time.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("decoded time %v", pkt.time)
+	}
+	if _, err := r.next(); err != errPcapEOF {
+		t.Errorf("expected end of capture, got %v", err)
+	}
+}
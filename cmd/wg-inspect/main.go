@@ -0,0 +1,211 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+// Command wg-inspect classifies WireGuard messages read from a pcap file or a
+// UDP socket, validates MAC1 against a responder's public key, and, given the
+// responder's private key, decrypts the static key and timestamp carried in
+// handshake initiations, along with the cipher suite offer and ML-KEM key
+// they may carry. Handshakes of a non-standard Noise construction,
+// identifier, handshake hash or channel binding are decoded with the same
+// values given with -construction, -identifier, -hash and -binding. It is
+// intended for debugging interoperability, not
+// for use on production traffic.
+//
+// Given a session transcript recorded by a device, wg-inspect instead
+// verifies the transport packets of the capture against it, recomputing
+// their MACs and ciphertexts under the transcript's cipher suites or the one
+// chosen with -suite.
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [-pub KEY] [-priv KEY] [-construction NAME] [-identifier NAME] [-hash NAME] [-binding KEY] (-r FILE.pcap | -l ADDR:PORT)\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s -transcript FILE [-suite NAME] -r FILE.pcap\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// parseKey accepts a 32-byte key in either the base64 form used by wg(8) or
+// the hex form used by the configuration protocol.
+func parseKey(dst *[32]byte, s string) error {
+	var b []byte
+	var err error
+	switch len(s) {
+	case base64.StdEncoding.EncodedLen(len(dst)):
+		b, err = base64.StdEncoding.DecodeString(s)
+	case hex.EncodedLen(len(dst)):
+		b, err = hex.DecodeString(s)
+	default:
+		return errors.New("key must be 32 bytes in base64 or hex")
+	}
+	if err != nil {
+		return err
+	}
+	copy(dst[:], b)
+	return nil
+}
+
+func main() {
+	var (
+		pcapFile     = flag.String("r", "", "read packets from pcap `file`")
+		listenAddr   = flag.String("l", "", "read packets from a UDP socket bound to `address`")
+		publicKey    = flag.String("pub", "", "responder public `key`, for MAC1 validation")
+		privateKey   = flag.String("priv", "", "responder private `key`, for decoding initiations (implies -pub)")
+		transcript   = flag.String("transcript", "", "verify the capture against the session transcript `file`")
+		suite        = flag.String("suite", "", "cipher `suite` to verify the transcript under, instead of its own")
+		construction = flag.String("construction", "", "Noise `construction` of the handshakes, if not the standard one")
+		identifier   = flag.String("identifier", "", "Noise `identifier` of the handshakes, if not the standard one")
+		hashName     = flag.String("hash", "", "handshake hash `name`, if not BLAKE2s: one of "+strings.Join(device.HandshakeHashers(), ", "))
+		binding      = flag.String("binding", "", "channel `binding` of the initiators, if any, in base64 or hex")
+	)
+	flag.Usage = usage
+	flag.Parse()
+	if (*pcapFile == "") == (*listenAddr == "") || flag.NArg() != 0 ||
+		(*transcript != "" && *pcapFile == "") || (*suite != "" && *transcript == "") {
+		usage()
+		os.Exit(2)
+	}
+
+	if *transcript != "" {
+		ok, err := verifyTranscript(*transcript, *suite, *pcapFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	var in inspector
+	if err := in.setProtocol(*construction, *identifier, *hashName); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid protocol: %v\n", err)
+		os.Exit(2)
+	}
+	if *binding != "" {
+		var b [32]byte
+		if err := parseKey(&b, *binding); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid channel binding: %v\n", err)
+			os.Exit(2)
+		}
+		in.setChannelBinding(b)
+	}
+	if *privateKey != "" {
+		var sk device.NoisePrivateKey
+		if err := parseKey((*[32]byte)(&sk), *privateKey); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid private key: %v\n", err)
+			os.Exit(2)
+		}
+		in.setPrivateKey(sk)
+	} else if *publicKey != "" {
+		var pk device.NoisePublicKey
+		if err := parseKey((*[32]byte)(&pk), *publicKey); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid public key: %v\n", err)
+			os.Exit(2)
+		}
+		in.setPublicKey(pk)
+	}
+
+	var err error
+	if *pcapFile != "" {
+		err = inspectPcap(&in, *pcapFile)
+	} else {
+		err = inspectSocket(&in, *listenAddr)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func inspectPcap(in *inspector, path string) error {
+	return readPcap(path, func(pkt pcapPacket) {
+		fmt.Printf("%s %v -> %v %s\n", pkt.time.Format("15:04:05.000000"), pkt.src, pkt.dst, in.inspect(pkt.payload))
+	})
+}
+
+// readPcap calls fn with each UDP datagram of the capture at path.
+func readPcap(path string, fn func(pcapPacket)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r, err := newPcapReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for {
+		pkt, err := r.next()
+		if err != nil {
+			if errors.Is(err, errPcapEOF) {
+				return nil
+			}
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if pkt.payload == nil {
+			continue
+		}
+		fn(pkt)
+	}
+}
+
+func verifyTranscript(path, suite, pcapPath string) (ok bool, err error) {
+	var packets [][]byte
+	err = readPcap(pcapPath, func(pkt pcapPacket) {
+		packets = append(packets, bytes.Clone(pkt.payload))
+	})
+	if err != nil {
+		return false, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	v, err := device.VerifyTranscript(f, suite, packets)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", path, err)
+	}
+	fmt.Printf("sessions %d, packets %d, verified %d, missing %d, mismatched %d, MAC failures %d, ciphertext mismatches %d\n",
+		v.Sessions, v.Packets, v.Verified, v.Missing, v.Mismatched, v.MACFailures, v.CiphertextMismatches)
+	if v.End == "" {
+		fmt.Println("transcript has no end; it may be incomplete")
+	}
+	return v.OK(), nil
+}
+
+func inspectSocket(in *inspector, addr string) error {
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return err
+	}
+	c, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	buf := make([]byte, 1<<16)
+	for {
+		n, src, err := c.ReadFromUDPAddrPort(buf)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%v %s\n", src, in.inspect(buf[:n]))
+	}
+}
@@ -0,0 +1,174 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+)
+
+/* A minimal reader for the classic libpcap file format, sufficient to
+ * pull UDP payloads out of captures made with tcpdump or Wireshark. The
+ * pcapng format is not supported; convert with "editcap -F pcap".
+ */
+
+const (
+	linkTypeNull   = 0
+	linkTypeEther  = 1
+	linkTypeRaw    = 101
+	linkTypeSLL    = 113
+	linkTypeSLL2   = 276
+	pcapHeaderSize = 24
+	pcapRecordSize = 16
+)
+
+var errPcapEOF = errors.New("end of capture")
+
+type pcapReader struct {
+	r        io.Reader
+	order    binary.ByteOrder
+	nano     bool
+	linkType uint32
+	buf      []byte
+}
+
+type pcapPacket struct {
+	time    time.Time
+	src     netip.AddrPort
+	dst     netip.AddrPort
+	payload []byte // nil if the record is not a UDP datagram
+}
+
+func newPcapReader(r io.Reader) (*pcapReader, error) {
+	var hdr [pcapHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("reading pcap header: %w", err)
+	}
+	p := &pcapReader{r: r}
+	switch binary.LittleEndian.Uint32(hdr[:]) {
+	case 0xa1b2c3d4:
+		p.order = binary.LittleEndian
+	case 0xa1b23c4d:
+		p.order, p.nano = binary.LittleEndian, true
+	case 0xd4c3b2a1:
+		p.order = binary.BigEndian
+	case 0x4d3cb2a1:
+		p.order, p.nano = binary.BigEndian, true
+	default:
+		return nil, errors.New("not a pcap file")
+	}
+	p.linkType = p.order.Uint32(hdr[20:]) & 0x0fffffff
+	switch p.linkType {
+	case linkTypeNull, linkTypeEther, linkTypeRaw, linkTypeSLL, linkTypeSLL2:
+	default:
+		return nil, fmt.Errorf("unsupported link type %d", p.linkType)
+	}
+	return p, nil
+}
+
+func (p *pcapReader) next() (pkt pcapPacket, err error) {
+	var rec [pcapRecordSize]byte
+	if _, err = io.ReadFull(p.r, rec[:]); err != nil {
+		if err == io.EOF {
+			err = errPcapEOF
+		}
+		return
+	}
+	secs, frac := p.order.Uint32(rec[0:]), p.order.Uint32(rec[4:])
+	if !p.nano {
+		frac *= 1000
+	}
+	pkt.time = time.Unix(int64(secs), int64(frac))
+	capLen := p.order.Uint32(rec[8:])
+	if capLen > 1<<18 {
+		return pkt, fmt.Errorf("record length %d too large", capLen)
+	}
+	if cap(p.buf) < int(capLen) {
+		p.buf = make([]byte, capLen)
+	}
+	frame := p.buf[:capLen]
+	if _, err = io.ReadFull(p.r, frame); err != nil {
+		return
+	}
+
+	var etherType uint16
+	switch p.linkType {
+	case linkTypeNull:
+		if len(frame) < 4 {
+			return pkt, nil
+		}
+		frame = frame[4:]
+	case linkTypeEther:
+		if len(frame) < 14 {
+			return pkt, nil
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame[12:]), frame[14:]
+		for etherType == 0x8100 || etherType == 0x88a8 {
+			if len(frame) < 4 {
+				return pkt, nil
+			}
+			etherType, frame = binary.BigEndian.Uint16(frame[2:]), frame[4:]
+		}
+	case linkTypeSLL:
+		if len(frame) < 16 {
+			return pkt, nil
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame[14:]), frame[16:]
+	case linkTypeSLL2:
+		if len(frame) < 20 {
+			return pkt, nil
+		}
+		etherType, frame = binary.BigEndian.Uint16(frame[0:]), frame[20:]
+	}
+	if etherType != 0 && etherType != 0x0800 && etherType != 0x86dd {
+		return pkt, nil
+	}
+	pkt.src, pkt.dst, pkt.payload = parseUDP(frame)
+	return pkt, nil
+}
+
+// parseUDP extracts the addresses and payload of an unfragmented UDP datagram
+// from an IPv4 or IPv6 packet.
+func parseUDP(b []byte) (src, dst netip.AddrPort, payload []byte) {
+	if len(b) < 1 {
+		return
+	}
+	var srcIP, dstIP netip.Addr
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) < 20 {
+			return
+		}
+		ihl := int(b[0]&0x0f) * 4
+		if b[9] != 17 || ihl < 20 || len(b) < ihl || binary.BigEndian.Uint16(b[6:])&0x3fff != 0 {
+			return
+		}
+		srcIP, dstIP = netip.AddrFrom4([4]byte(b[12:16])), netip.AddrFrom4([4]byte(b[16:20]))
+		b = b[ihl:]
+	case 6:
+		if len(b) < 40 || b[6] != 17 {
+			return
+		}
+		srcIP, dstIP = netip.AddrFrom16([16]byte(b[8:24])), netip.AddrFrom16([16]byte(b[24:40]))
+		b = b[40:]
+	default:
+		return
+	}
+	if len(b) < 8 {
+		return
+	}
+	length := int(binary.BigEndian.Uint16(b[4:]))
+	if length < 8 || length > len(b) {
+		return
+	}
+	src = netip.AddrPortFrom(srcIP, binary.BigEndian.Uint16(b[0:]))
+	dst = netip.AddrPortFrom(dstIP, binary.BigEndian.Uint16(b[2:]))
+	return src, dst, b[8:length]
+}
@@ -0,0 +1,302 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package conn
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+/* DTLS encapsulation
+ *
+ * Some networks pass only traffic recognizable as belonging to the TLS
+ * family. A DTLSBind wraps the datagrams of another Bind in DTLS 1.3
+ * application data records, sent to gateways that unwrap them: each
+ * endpoint datagrams are sent to is taken to be such a gateway, and gets a
+ * DTLS association of its own, set up on first use. Datagrams sent during
+ * the handshake are held, up to dtlsPendingSize of them, until it
+ * completes. Records come only from gateways with an association; others
+ * are dropped.
+ *
+ * The standard library has no DTLS, so the record layer and the handshake
+ * are left to a DTLSClient provided by the embedder. When the bind is
+ * closed, as it is when the device roams to another network, it keeps the
+ * resumption state of each association, and the association with the
+ * same gateway after reopening resumes that session instead of going
+ * through a full handshake. An association failing is closed the same way,
+ * and set up again by the next datagram sent to its gateway.
+ *
+ * Records are larger than the datagrams they carry by the client's
+ * Overhead, which a DTLSBind reports as a BindOverhead so that the device
+ * can account for it against the MTU.
+ */
+
+// dtlsPendingSize is the number of datagrams held for a gateway while the
+// handshake with it is in progress.
+const dtlsPendingSize = 16
+
+// A DTLSClient creates the DTLS 1.3 associations of a DTLSBind.
+type DTLSClient interface {
+	// NewAssociation starts a handshake with the gateway at ep, resuming
+	// session, the state returned by Session of an earlier association
+	// with it, if not nil. Handshake records, and retransmissions of them,
+	// are sent with send.
+	NewAssociation(ep Endpoint, session []byte, send func(record []byte) error) (DTLSAssociation, error)
+
+	// Overhead returns the largest number of bytes a record adds to the
+	// datagram it carries.
+	Overhead() int
+}
+
+// A DTLSAssociation is a DTLS 1.3 association with a gateway. It must be
+// safe for concurrent use.
+type DTLSAssociation interface {
+	// Established reports whether the handshake completed, so that
+	// datagrams can be sealed.
+	Established() bool
+
+	// Seal appends an application data record carrying datagram to dst.
+	Seal(dst, datagram []byte) ([]byte, error)
+
+	// Open processes a record received from the gateway and appends the
+	// datagram it carries, if any, to dst. It reports false for records
+	// carrying none, such as those of the handshake, and returns an error
+	// only if the association failed.
+	Open(dst, record []byte) (out []byte, ok bool, err error)
+
+	// Session returns the state to resume the association with, or nil if
+	// there is none.
+	Session() []byte
+
+	Close() error
+}
+
+// BindOverhead is implemented by Bind objects that add bytes of their own
+// to each datagram, such as those encapsulating datagrams in another
+// protocol. The overhead comes at the expense of the MTU of the tunnel.
+type BindOverhead interface {
+	Overhead() int
+}
+
+// DTLSBind is a Bind wrapping the datagrams of another in DTLS records.
+type DTLSBind struct {
+	inner  Bind
+	client DTLSClient
+
+	mu           sync.Mutex
+	open         bool
+	associations map[string]*dtlsAssociation // by gateway
+	sessions     map[string][]byte           // resumption state by gateway, kept across Close
+}
+
+type dtlsAssociation struct {
+	DTLSAssociation
+	ep Endpoint
+
+	mu      sync.Mutex
+	pending [][]byte // datagrams sent before the handshake completed
+}
+
+var (
+	_ Bind         = (*DTLSBind)(nil)
+	_ BindOverhead = (*DTLSBind)(nil)
+)
+
+// NewDTLSBind returns a DTLSBind sending the records of associations made by
+// client through inner.
+func NewDTLSBind(inner Bind, client DTLSClient) *DTLSBind {
+	return &DTLSBind{
+		inner:    inner,
+		client:   client,
+		sessions: make(map[string][]byte),
+	}
+}
+
+func (b *DTLSBind) Open(port uint16) ([]ReceiveFunc, uint16, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.open {
+		return nil, 0, ErrBindAlreadyOpen
+	}
+	fns, actualPort, err := b.inner.Open(port)
+	if err != nil {
+		return nil, 0, err
+	}
+	b.open = true
+	b.associations = make(map[string]*dtlsAssociation)
+	wrapped := make([]ReceiveFunc, len(fns))
+	for i, fn := range fns {
+		wrapped[i] = b.makeReceiveFunc(fn)
+	}
+	return wrapped, actualPort, nil
+}
+
+func (b *DTLSBind) makeReceiveFunc(fn ReceiveFunc) ReceiveFunc {
+	var record []byte
+	return func(bufs [][]byte, sizes []int, eps []Endpoint) (int, error) {
+		for {
+			n, err := fn(bufs, sizes, eps)
+			if err != nil {
+				return 0, err
+			}
+			kept := 0
+			for i := range n {
+				a := b.association(eps[i])
+				if a == nil {
+					continue
+				}
+				record = append(record[:0], bufs[i][:sizes[i]]...)
+				established := a.Established()
+				out, ok, err := a.Open(bufs[kept][:0], record)
+				if err != nil {
+					b.drop(a)
+					continue
+				}
+				if !established && a.Established() {
+					b.flush(a)
+				}
+				if !ok || len(out) > len(bufs[kept]) {
+					continue
+				}
+				sizes[kept], eps[kept] = len(out), eps[i]
+				kept++
+			}
+			if kept != 0 {
+				return kept, nil
+			}
+		}
+	}
+}
+
+// association returns the association with the gateway at ep, or nil if
+// there is none.
+func (b *DTLSBind) association(ep Endpoint) *dtlsAssociation {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.associations[ep.DstToString()]
+}
+
+// associate returns the association with the gateway at ep, starting one
+// if there is none.
+func (b *DTLSBind) associate(ep Endpoint) (*dtlsAssociation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return nil, net.ErrClosed
+	}
+	gateway := ep.DstToString()
+	if a := b.associations[gateway]; a != nil {
+		return a, nil
+	}
+	send := func(record []byte) error {
+		return b.inner.Send([][]byte{record}, ep)
+	}
+	association, err := b.client.NewAssociation(ep, b.sessions[gateway], send)
+	if err != nil {
+		return nil, err
+	}
+	a := &dtlsAssociation{DTLSAssociation: association, ep: ep}
+	b.associations[gateway] = a
+	return a, nil
+}
+
+// drop closes a failed association, keeping its resumption state.
+func (b *DTLSBind) drop(a *dtlsAssociation) {
+	b.mu.Lock()
+	gateway := a.ep.DstToString()
+	if b.associations[gateway] == a {
+		delete(b.associations, gateway)
+		b.saveSession(gateway, a)
+	}
+	b.mu.Unlock()
+	a.Close()
+}
+
+func (b *DTLSBind) saveSession(gateway string, a *dtlsAssociation) {
+	if session := a.Session(); session != nil {
+		b.sessions[gateway] = session
+	}
+}
+
+// flush sends the datagrams held for an association whose handshake just
+// completed.
+func (b *DTLSBind) flush(a *dtlsAssociation) {
+	a.mu.Lock()
+	pending := a.pending
+	a.pending = nil
+	a.mu.Unlock()
+	if len(pending) != 0 {
+		b.sealAndSend(a, pending)
+	}
+}
+
+func (b *DTLSBind) sealAndSend(a *dtlsAssociation, bufs [][]byte) error {
+	records := make([][]byte, len(bufs))
+	for i, buf := range bufs {
+		record, err := a.Seal(make([]byte, 0, len(buf)+b.client.Overhead()), buf)
+		if err != nil {
+			return err
+		}
+		records[i] = record
+	}
+	return b.inner.Send(records, a.ep)
+}
+
+func (b *DTLSBind) Close() error {
+	b.mu.Lock()
+	if !b.open {
+		b.mu.Unlock()
+		return nil
+	}
+	associations := b.associations
+	for gateway, a := range associations {
+		b.saveSession(gateway, a)
+	}
+	b.open, b.associations = false, nil
+	b.mu.Unlock()
+
+	var errs []error
+	for _, a := range associations {
+		errs = append(errs, a.Close())
+	}
+	errs = append(errs, b.inner.Close())
+	return errors.Join(errs...)
+}
+
+func (b *DTLSBind) SetMark(mark uint32) error { return b.inner.SetMark(mark) }
+
+func (b *DTLSBind) BatchSize() int { return b.inner.BatchSize() }
+
+// Overhead returns the number of bytes records add to datagrams.
+func (b *DTLSBind) Overhead() int { return b.client.Overhead() }
+
+func (b *DTLSBind) Send(bufs [][]byte, ep Endpoint) error {
+	a, err := b.associate(ep)
+	if err != nil {
+		return err
+	}
+	if !a.Established() {
+		a.mu.Lock()
+		for _, buf := range bufs {
+			if len(a.pending) == dtlsPendingSize {
+				a.pending = a.pending[1:]
+			}
+			a.pending = append(a.pending, append([]byte(nil), buf...))
+		}
+		a.mu.Unlock()
+		// The handshake may have completed in the meantime.
+		if a.Established() {
+			b.flush(a)
+		}
+		return nil
+	}
+	return b.sealAndSend(a, bufs)
+}
+
+func (b *DTLSBind) ParseEndpoint(s string) (Endpoint, error) {
+	return b.inner.ParseEndpoint(s)
+}
@@ -0,0 +1,137 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package conn
+
+import (
+	"bytes"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeDTLSClient makes associations with a toy record layer: handshake
+// records are "hello" or "done", and application data records are the
+// datagram after a 0x17 byte.
+type fakeDTLSClient struct {
+	mu       sync.Mutex
+	sessions [][]byte // session passed to each association, in order
+}
+
+type fakeDTLSAssociation struct {
+	established atomic.Bool
+}
+
+func (c *fakeDTLSClient) NewAssociation(ep Endpoint, session []byte, send func(record []byte) error) (DTLSAssociation, error) {
+	c.mu.Lock()
+	c.sessions = append(c.sessions, session)
+	c.mu.Unlock()
+	return new(fakeDTLSAssociation), send([]byte("hello"))
+}
+
+func (c *fakeDTLSClient) Overhead() int { return 1 }
+
+func (a *fakeDTLSAssociation) Established() bool { return a.established.Load() }
+
+func (a *fakeDTLSAssociation) Seal(dst, datagram []byte) ([]byte, error) {
+	return append(append(dst, 0x17), datagram...), nil
+}
+
+func (a *fakeDTLSAssociation) Open(dst, record []byte) ([]byte, bool, error) {
+	if bytes.Equal(record, []byte("done")) {
+		a.established.Store(true)
+		return dst, false, nil
+	}
+	if len(record) == 0 || record[0] != 0x17 {
+		return dst, false, nil
+	}
+	return append(dst, record[1:]...), true, nil
+}
+
+func (a *fakeDTLSAssociation) Session() []byte {
+	if !a.established.Load() {
+		return nil
+	}
+	return []byte("ticket")
+}
+
+func (a *fakeDTLSAssociation) Close() error { return nil }
+
+func TestDTLSBind(t *testing.T) {
+	local, gateway := netip.MustParseAddrPort("192.0.2.1:51820"), netip.MustParseAddrPort("192.0.2.2:443")
+	var sent [][]byte
+	inner := NewHostBind(HostIO{
+		Send: func(bufs [][]byte, dst netip.AddrPort) error {
+			for _, buf := range bufs {
+				sent = append(sent, append([]byte(nil), buf...))
+			}
+			return nil
+		},
+	})
+	client := new(fakeDTLSClient)
+	bind := NewDTLSBind(inner, client)
+	if bind.Overhead() != 1 {
+		t.Errorf("overhead = %d", bind.Overhead())
+	}
+	fns, _, err := bind.Open(local.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ep, err := bind.ParseEndpoint(gateway.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Datagrams sent during the handshake wait for it.
+	if err := bind.Send([][]byte{[]byte("initiation")}, ep); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 1 || string(sent[0]) != "hello" {
+		t.Fatalf("sent during handshake: %q", sent)
+	}
+	inner.Deliver([]byte("\x17stray"), netip.MustParseAddrPort("192.0.2.3:443"))
+	inner.Deliver([]byte("done"), gateway)
+	inner.Deliver([]byte("\x17response"), gateway)
+
+	bufs := make([][]byte, bind.BatchSize())
+	for i := range bufs {
+		bufs[i] = make([]byte, 64)
+	}
+	sizes := make([]int, len(bufs))
+	eps := make([]Endpoint, len(bufs))
+	n, err := fns[0](bufs, sizes, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || string(bufs[0][:sizes[0]]) != "response" || eps[0].DstToString() != gateway.String() {
+		t.Fatalf("received %d datagrams: %q", n, bufs[0][:sizes[0]])
+	}
+	if len(sent) != 2 || string(sent[1]) != "\x17initiation" {
+		t.Fatalf("held datagram not sent after handshake: %q", sent)
+	}
+	if err := bind.Send([][]byte{[]byte("keepalive")}, ep); err != nil {
+		t.Fatal(err)
+	}
+	if string(sent[len(sent)-1]) != "\x17keepalive" {
+		t.Errorf("sent %q", sent[len(sent)-1])
+	}
+
+	// Reopened, as after roaming, the association resumes the session.
+	if err := bind.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bind.Send([][]byte{[]byte("closed")}, ep); err == nil {
+		t.Error("sent through closed bind")
+	}
+	if _, _, err := bind.Open(local.Port()); err != nil {
+		t.Fatal(err)
+	}
+	defer bind.Close()
+	bind.Send([][]byte{[]byte("initiation")}, ep)
+	if len(client.sessions) != 2 || client.sessions[0] != nil || string(client.sessions[1]) != "ticket" {
+		t.Errorf("sessions = %q", client.sessions)
+	}
+}
@@ -0,0 +1,180 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package conn
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+)
+
+// HostIO is the UDP I/O provided by the host environment of a HostBind,
+// such as JavaScript glue in a browser or the embedder of a wasip1 module.
+type HostIO struct {
+	// Listen is called by Open with the requested port, zero for any, and
+	// returns the port listened on. If nil, the requested port is reported.
+	Listen func(port uint16) (actualPort uint16, err error)
+
+	// Send transmits each of bufs as one datagram to dst.
+	Send func(bufs [][]byte, dst netip.AddrPort) error
+
+	// Close is called by Close, if not nil.
+	Close func() error
+}
+
+// hostQueueSize is the number of delivered datagrams a HostBind buffers
+// before dropping them.
+const hostQueueSize = 1024
+
+// HostBind is a Bind whose datagrams are sent with host-provided callbacks
+// and received through Deliver, for platforms without usable sockets such
+// as js/wasm and wasip1.
+type HostBind struct {
+	io HostIO
+
+	mu       sync.Mutex
+	incoming chan hostDatagram // nil while closed
+	closed   chan struct{}
+}
+
+type hostDatagram struct {
+	packet []byte
+	src    netip.AddrPort
+}
+
+// HostEndpoint is the Endpoint of a HostBind.
+type HostEndpoint struct {
+	netip.AddrPort
+}
+
+var (
+	_ Bind     = (*HostBind)(nil)
+	_ Endpoint = (*HostEndpoint)(nil)
+)
+
+// NewHostBind returns a HostBind using io.
+func NewHostBind(io HostIO) *HostBind {
+	return &HostBind{io: io}
+}
+
+func (b *HostBind) Open(port uint16) ([]ReceiveFunc, uint16, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.incoming != nil {
+		return nil, 0, ErrBindAlreadyOpen
+	}
+	if b.io.Send == nil {
+		return nil, 0, errors.New("no host I/O to send datagrams with")
+	}
+	if b.io.Listen != nil {
+		var err error
+		if port, err = b.io.Listen(port); err != nil {
+			return nil, 0, err
+		}
+	}
+	b.incoming = make(chan hostDatagram, hostQueueSize)
+	b.closed = make(chan struct{})
+	return []ReceiveFunc{b.makeReceiveFunc(b.incoming, b.closed)}, port, nil
+}
+
+func (b *HostBind) makeReceiveFunc(incoming chan hostDatagram, closed chan struct{}) ReceiveFunc {
+	return func(bufs [][]byte, sizes []int, eps []Endpoint) (int, error) {
+		var d hostDatagram
+		select {
+		case <-closed:
+			return 0, net.ErrClosed
+		case d = <-incoming:
+		}
+		n := 0
+		for {
+			sizes[n] = copy(bufs[n], d.packet)
+			eps[n] = &HostEndpoint{d.src}
+			n++
+			if n == len(bufs) {
+				return n, nil
+			}
+			select {
+			case d = <-incoming:
+			default:
+				return n, nil
+			}
+		}
+	}
+}
+
+// Deliver queues a copy of a datagram received by the host from src. It
+// reports false if the datagram was dropped, because the bind is closed or
+// its queue is full.
+func (b *HostBind) Deliver(packet []byte, src netip.AddrPort) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.incoming == nil {
+		return false
+	}
+	select {
+	case b.incoming <- hostDatagram{append([]byte(nil), packet...), src}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (b *HostBind) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.incoming == nil {
+		return nil
+	}
+	close(b.closed)
+	b.incoming, b.closed = nil, nil
+	if b.io.Close != nil {
+		return b.io.Close()
+	}
+	return nil
+}
+
+func (b *HostBind) SetMark(mark uint32) error { return nil }
+
+func (b *HostBind) BatchSize() int { return IdealBatchSize }
+
+func (b *HostBind) Send(bufs [][]byte, ep Endpoint) error {
+	e, ok := ep.(*HostEndpoint)
+	if !ok {
+		return ErrWrongEndpointType
+	}
+	b.mu.Lock()
+	open := b.incoming != nil
+	b.mu.Unlock()
+	if !open {
+		return net.ErrClosed
+	}
+	return b.io.Send(bufs, e.AddrPort)
+}
+
+func (b *HostBind) ParseEndpoint(s string) (Endpoint, error) {
+	e, err := netip.ParseAddrPort(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEndpoint, err)
+	}
+	return &HostEndpoint{e}, nil
+}
+
+func (e *HostEndpoint) ClearSrc() {}
+
+func (e *HostEndpoint) SrcToString() string { return "" }
+
+func (e *HostEndpoint) DstToString() string { return e.AddrPort.String() }
+
+func (e *HostEndpoint) DstToBytes() []byte {
+	b, _ := e.AddrPort.MarshalBinary()
+	return b
+}
+
+func (e *HostEndpoint) DstIP() netip.Addr { return e.AddrPort.Addr() }
+
+func (e *HostEndpoint) SrcIP() netip.Addr { return netip.Addr{} }
@@ -0,0 +1,86 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package conn
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestHostBind(t *testing.T) {
+	addrs := [2]netip.AddrPort{netip.MustParseAddrPort("192.0.2.1:51820"), netip.MustParseAddrPort("192.0.2.2:51820")}
+	var binds [2]*HostBind
+	for i := range binds {
+		from := addrs[i]
+		binds[i] = NewHostBind(HostIO{
+			Listen: func(port uint16) (uint16, error) { return from.Port(), nil },
+			Send: func(bufs [][]byte, dst netip.AddrPort) error {
+				if dst != addrs[i^1] {
+					return errors.New("no route to host")
+				}
+				for _, buf := range bufs {
+					binds[i^1].Deliver(buf, from)
+				}
+				return nil
+			},
+		})
+	}
+	fns, port, err := binds[1].Open(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port != 51820 || len(fns) != 1 {
+		t.Fatalf("Open = %d functions, port %d", len(fns), port)
+	}
+	if _, _, err := binds[0].Open(0); err != nil {
+		t.Fatal(err)
+	}
+	defer binds[0].Close()
+
+	ep, err := binds[0].ParseEndpoint(addrs[1].String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets := [][]byte{[]byte("first"), []byte("second"), []byte("third")}
+	if err := binds[0].Send(packets, ep); err != nil {
+		t.Fatal(err)
+	}
+	bufs := make([][]byte, binds[1].BatchSize())
+	for i := range bufs {
+		bufs[i] = make([]byte, 64)
+	}
+	sizes := make([]int, len(bufs))
+	eps := make([]Endpoint, len(bufs))
+	n, err := fns[0](bufs, sizes, eps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != len(packets) {
+		t.Fatalf("received %d packets in one batch, want %d", n, len(packets))
+	}
+	for i, packet := range packets {
+		if !bytes.Equal(bufs[i][:sizes[i]], packet) || eps[i].DstToString() != addrs[0].String() {
+			t.Errorf("packet %d = %q from %s", i, bufs[i][:sizes[i]], eps[i].DstToString())
+		}
+	}
+
+	if err := binds[0].Send(packets, &StdNetEndpoint{AddrPort: addrs[1]}); !errors.Is(err, ErrWrongEndpointType) {
+		t.Errorf("Send to foreign endpoint = %v", err)
+	}
+	binds[1].Close()
+	if _, err := fns[0](bufs, sizes, eps); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("receive after Close = %v", err)
+	}
+	if binds[1].Deliver(packets[0], addrs[0]) {
+		t.Error("delivered to closed bind")
+	}
+	if _, _, err := NewHostBind(HostIO{}).Open(0); err == nil {
+		t.Error("opened bind without host I/O")
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"runtime"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"syscall"
 
 	"golang.org/x/net/ipv4"
@@ -21,7 +22,8 @@ import (
 )
 
 var (
-	_ Bind = (*StdNetBind)(nil)
+	_ Bind              = (*StdNetBind)(nil)
+	_ BindContextOpener = (*StdNetBind)(nil)
 )
 
 // StdNetBind implements Bind for all platforms. While Windows has its own Bind
@@ -40,14 +42,20 @@ type StdNetBind struct {
 	ipv6TxOffload bool
 	ipv6RxOffload bool
 
-	// these two fields are not guarded by mu
+	// these three fields are not guarded by mu
 	udpAddrPool sync.Pool
 	msgsPool    sync.Pool
+	icmpHandler atomic.Pointer[func(ICMPError)]
 
 	blackhole4 bool
 	blackhole6 bool
 }
 
+// Clone returns a new, unopened StdNetBind.
+func (s *StdNetBind) Clone() Bind {
+	return NewStdNetBind()
+}
+
 func NewStdNetBind() Bind {
 	return &StdNetBind{
 		udpAddrPool: sync.Pool{
@@ -90,7 +98,7 @@ var (
 func (*StdNetBind) ParseEndpoint(s string) (Endpoint, error) {
 	e, err := netip.ParseAddrPort(s)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEndpoint, err)
 	}
 	return &StdNetEndpoint{
 		AddrPort: e,
@@ -119,8 +127,8 @@ func (e *StdNetEndpoint) DstToString() string {
 	return e.AddrPort.String()
 }
 
-func listenNet(network string, port int) (*net.UDPConn, int, error) {
-	conn, err := listenConfig().ListenPacket(context.Background(), network, ":"+strconv.Itoa(port))
+func listenNet(ctx context.Context, network string, port int) (*net.UDPConn, int, error) {
+	conn, err := listenConfig().ListenPacket(ctx, network, ":"+strconv.Itoa(port))
 	if err != nil {
 		return nil, 0, err
 	}
@@ -138,6 +146,11 @@ func listenNet(network string, port int) (*net.UDPConn, int, error) {
 }
 
 func (s *StdNetBind) Open(uport uint16) ([]ReceiveFunc, uint16, error) {
+	return s.OpenContext(context.Background(), uport)
+}
+
+// OpenContext is Open, giving up when ctx is done.
+func (s *StdNetBind) OpenContext(ctx context.Context, uport uint16) ([]ReceiveFunc, uint16, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -156,21 +169,25 @@ again:
 	var v4pc *ipv4.PacketConn
 	var v6pc *ipv6.PacketConn
 
-	v4conn, port, err = listenNet("udp4", port)
+	v4conn, port, err = listenNet(ctx, "udp4", port)
 	if err != nil && !errors.Is(err, syscall.EAFNOSUPPORT) {
 		return nil, 0, err
 	}
 
-	// Listen on the same port as we're using for ipv4.
-	v6conn, port, err = listenNet("udp6", port)
-	if uport == 0 && errors.Is(err, syscall.EADDRINUSE) && tries < 100 {
-		v4conn.Close()
-		tries++
-		goto again
-	}
-	if err != nil && !errors.Is(err, syscall.EAFNOSUPPORT) {
-		v4conn.Close()
-		return nil, 0, err
+	// Listen on the same port as we're using for ipv4. The simulated
+	// network of js and wasip1 has one port space for both families, which
+	// the ipv4 listener already holds.
+	if runtime.GOARCH != "wasm" {
+		v6conn, port, err = listenNet(ctx, "udp6", port)
+		if uport == 0 && errors.Is(err, syscall.EADDRINUSE) && tries < 100 && ctx.Err() == nil {
+			v4conn.Close()
+			tries++
+			goto again
+		}
+		if err != nil && !errors.Is(err, syscall.EAFNOSUPPORT) {
+			v4conn.Close()
+			return nil, 0, err
+		}
 	}
 	var fns []ReceiveFunc
 	if v4conn != nil {
@@ -194,10 +211,46 @@ again:
 	if len(fns) == 0 {
 		return nil, 0, syscall.EAFNOSUPPORT
 	}
+	if s.icmpHandler.Load() != nil {
+		if err := s.setRecvErrLocked(true); err != nil {
+			s.closeLocked()
+			return nil, 0, err
+		}
+	}
 
 	return fns, uint16(port), nil
 }
 
+// SetICMPErrorHandler implements BindICMPErrors. ICMP errors are only
+// reported on Linux; elsewhere it returns errors.ErrUnsupported.
+func (s *StdNetBind) SetICMPErrorHandler(fn func(ICMPError)) error {
+	if !icmpErrorsSupported {
+		return errors.ErrUnsupported
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if fn == nil {
+		s.icmpHandler.Store(nil)
+	} else {
+		s.icmpHandler.Store(&fn)
+	}
+	return s.setRecvErrLocked(fn != nil)
+}
+
+func (s *StdNetBind) setRecvErrLocked(enable bool) error {
+	if s.ipv4 != nil {
+		if err := setRecvErr(s.ipv4, false, enable); err != nil {
+			return err
+		}
+	}
+	if s.ipv6 != nil {
+		if err := setRecvErr(s.ipv6, true, enable); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *StdNetBind) putMessages(msgs *[]ipv6.Message) {
 	for i := range *msgs {
 		(*msgs)[i].OOB = (*msgs)[i].OOB[:0]
@@ -279,13 +332,23 @@ func (s *StdNetBind) receiveIP(
 
 func (s *StdNetBind) makeReceiveIPv4(pc *ipv4.PacketConn, conn *net.UDPConn, rxOffload bool) ReceiveFunc {
 	return func(bufs [][]byte, sizes []int, eps []Endpoint) (n int, err error) {
-		return s.receiveIP(pc, conn, rxOffload, bufs, sizes, eps)
+		for {
+			n, err = s.receiveIP(pc, conn, rxOffload, bufs, sizes, eps)
+			if err == nil || !s.drainICMPErrors(conn, err) {
+				return n, err
+			}
+		}
 	}
 }
 
 func (s *StdNetBind) makeReceiveIPv6(pc *ipv6.PacketConn, conn *net.UDPConn, rxOffload bool) ReceiveFunc {
 	return func(bufs [][]byte, sizes []int, eps []Endpoint) (n int, err error) {
-		return s.receiveIP(pc, conn, rxOffload, bufs, sizes, eps)
+		for {
+			n, err = s.receiveIP(pc, conn, rxOffload, bufs, sizes, eps)
+			if err == nil || !s.drainICMPErrors(conn, err) {
+				return n, err
+			}
+		}
 	}
 }
 
@@ -301,7 +364,10 @@ func (s *StdNetBind) BatchSize() int {
 func (s *StdNetBind) Close() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.closeLocked()
+}
 
+func (s *StdNetBind) closeLocked() error {
 	var err1, err2 error
 	if s.ipv4 != nil {
 		err1 = s.ipv4.Close()
@@ -411,13 +477,19 @@ retry:
 
 func (s *StdNetBind) send(conn *net.UDPConn, pc batchWriter, msgs []ipv6.Message) error {
 	var (
-		n     int
-		err   error
-		start int
+		n       int
+		err     error
+		start   int
+		drained bool
 	)
 	if runtime.GOOS == "linux" || runtime.GOOS == "android" {
 		for {
 			n, err = pc.WriteBatch(msgs[start:], 0)
+			// A queued ICMP error fails the send once.
+			if err != nil && !drained && s.drainICMPErrors(conn, err) {
+				drained = true
+				continue
+			}
 			if err != nil || n == len(msgs[start:]) {
 				break
 			}
@@ -1,7 +1,9 @@
 package conn
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"net"
 	"testing"
 
@@ -27,6 +29,19 @@ func TestStdNetBindReceiveFuncAfterClose(t *testing.T) {
 	}
 }
 
+func TestOpenContext(t *testing.T) {
+	bind := NewStdNetBind()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := OpenContext(ctx, bind, 0); !errors.Is(err, context.Canceled) {
+		t.Fatalf("open with cancelled context: %v", err)
+	}
+	if _, _, err := OpenContext(context.Background(), bind, 0); err != nil {
+		t.Fatal(err)
+	}
+	bind.Close()
+}
+
 func mockSetGSOSize(control *[]byte, gsoSize uint16) {
 	*control = (*control)[:cap(*control)]
 	binary.LittleEndian.PutUint16(*control, gsoSize)
@@ -248,3 +263,14 @@ func Test_splitCoalescedMessages(t *testing.T) {
 		})
 	}
 }
+
+func TestParseEndpointInvalid(t *testing.T) {
+	for _, bind := range []Bind{NewStdNetBind(), NewHostBind(HostIO{})} {
+		if _, err := bind.ParseEndpoint("nowhere"); !errors.Is(err, ErrInvalidEndpoint) {
+			t.Errorf("%T.ParseEndpoint = %v, want ErrInvalidEndpoint", bind, err)
+		}
+		if _, err := bind.ParseEndpoint("192.0.2.1:51820"); err != nil {
+			t.Errorf("%T.ParseEndpoint: %v", bind, err)
+		}
+	}
+}
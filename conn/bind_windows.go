@@ -7,6 +7,7 @@ package conn
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
 	"net"
 	"net/netip"
@@ -86,6 +87,11 @@ func NewWinRingBind() Bind {
 	return new(WinRingBind)
 }
 
+// Clone returns a new, unopened WinRingBind.
+func (bind *WinRingBind) Clone() Bind {
+	return NewWinRingBind()
+}
+
 type WinRingEndpoint struct {
 	family uint16
 	data   [30]byte
@@ -97,6 +103,14 @@ var (
 )
 
 func (*WinRingBind) ParseEndpoint(s string) (Endpoint, error) {
+	e, err := parseWinRingEndpoint(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidEndpoint, err)
+	}
+	return e, nil
+}
+
+func parseWinRingEndpoint(s string) (*WinRingEndpoint, error) {
 	host, port, err := net.SplitHostPort(s)
 	if err != nil {
 		return nil, err
@@ -7,6 +7,7 @@
 package conn
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/netip"
@@ -29,8 +30,9 @@ type ReceiveFunc func(packets [][]byte, sizes []int, eps []Endpoint) (n int, err
 
 // A Bind listens on a port for both IPv6 and IPv4 UDP traffic.
 //
-// A Bind interface may also be a PeekLookAtSocketFd or BindSocketToInterface,
-// depending on the platform-specific implementation.
+// A Bind interface may also be a PeekLookAtSocketFd, BindSocketToInterface,
+// BindCloner, BindContextOpener or BindContextParser, depending on the
+// platform-specific implementation.
 type Bind interface {
 	// Open puts the Bind into a listening state on a given port and reports the actual
 	// port that it bound to. Passing zero results in a random selection.
@@ -64,6 +66,48 @@ type BindSocketToInterface interface {
 	BindSocketToInterface6(interfaceIndex uint32, blackhole bool) error
 }
 
+// BindCloner is implemented by Bind objects that can create fresh, unopened
+// instances of the same kind. Devices use it to listen on more than one port.
+type BindCloner interface {
+	Clone() Bind
+}
+
+// BindContextOpener is implemented by Bind objects whose Open can be given
+// up on, such as those resolving names or waiting for a network.
+type BindContextOpener interface {
+	OpenContext(ctx context.Context, port uint16) (fns []ReceiveFunc, actualPort uint16, err error)
+}
+
+// BindContextParser is implemented by Bind objects whose ParseEndpoint can be
+// given up on, such as those resolving host names.
+type BindContextParser interface {
+	ParseEndpointContext(ctx context.Context, s string) (Endpoint, error)
+}
+
+// OpenContext opens bind like Bind.Open, giving up when ctx is done if bind
+// is a BindContextOpener.
+func OpenContext(ctx context.Context, bind Bind, port uint16) (fns []ReceiveFunc, actualPort uint16, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+	if opener, ok := bind.(BindContextOpener); ok {
+		return opener.OpenContext(ctx, port)
+	}
+	return bind.Open(port)
+}
+
+// ParseEndpointContext parses s like Bind.ParseEndpoint, giving up when ctx
+// is done if bind is a BindContextParser.
+func ParseEndpointContext(ctx context.Context, bind Bind, s string) (Endpoint, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if parser, ok := bind.(BindContextParser); ok {
+		return parser.ParseEndpointContext(ctx, s)
+	}
+	return bind.ParseEndpoint(s)
+}
+
 // PeekLookAtSocketFd is implemented by Bind objects that support having their
 // file descriptor peeked at. Used by wireguard-android.
 type PeekLookAtSocketFd interface {
@@ -87,6 +131,7 @@ type Endpoint interface {
 var (
 	ErrBindAlreadyOpen   = errors.New("bind is already open")
 	ErrWrongEndpointType = errors.New("endpoint type does not correspond with bind type")
+	ErrInvalidEndpoint   = errors.New("invalid endpoint") // wrapped by errors from Bind.ParseEndpoint
 )
 
 func (fn ReceiveFunc) PrettyName() string {
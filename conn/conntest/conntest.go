@@ -0,0 +1,388 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package conntest provides an in-memory network of conn.Binds whose
+// conditions tests can degrade: datagrams can be lost, delayed, limited in
+// bandwidth, or dropped between binds partitioned from each other. It lets
+// programs embedding WireGuard devices test them against realistic network
+// conditions without sockets.
+//
+// Each Bind of a Network has an address of its own, and is reached at that
+// address and the port it was opened with, as its Endpoint reports.
+// Datagrams sent to an address and port no bind is open at are dropped, as
+// UDP would drop them.
+package conntest
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+const (
+	QueueSize = 256 << 10 // bytes of datagrams a bind holds back for its bandwidth before dropping more
+
+	receiveQueueSize = 1024 // datagrams delivered to a bind and not yet received
+	firstPort        = 49152
+)
+
+// A Network connects the Binds created from it.
+type Network struct {
+	mu         sync.Mutex
+	rand       *rand.Rand
+	loss       float64
+	latency    time.Duration
+	jitter     time.Duration
+	bandwidth  int64 // bits per second; zero for unlimited
+	partitions map[[2]*Bind]bool
+	binds      map[netip.AddrPort]*Bind // open ones
+	addrs      int
+	seq        uint64
+	stats      Stats
+}
+
+// Stats counts the datagrams of a Network.
+type Stats struct {
+	Sent        uint64 // by any bind
+	Delivered   uint64 // to the queue of the bind they were sent to
+	Lost        uint64 // to the loss rate
+	Partitioned uint64 // between binds partitioned from each other
+	Overflowed  uint64 // for the queue of the sending bind or the receiving one being full
+	Unreachable uint64 // for no bind being open at their destination
+}
+
+// NewNetwork returns a Network without loss, latency or bandwidth limits.
+func NewNetwork() *Network {
+	return &Network{
+		rand:       rand.New(rand.NewPCG(rand.Uint64(), rand.Uint64())),
+		partitions: make(map[[2]*Bind]bool),
+		binds:      make(map[netip.AddrPort]*Bind),
+	}
+}
+
+// SetSeed seeds the randomness of loss and jitter, for reproducible tests.
+func (n *Network) SetSeed(seed uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.rand = rand.New(rand.NewPCG(seed, seed))
+}
+
+// SetLoss makes the network drop each datagram with probability rate, from
+// 0 for none to 1 for all.
+func (n *Network) SetLoss(rate float64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.loss = min(max(rate, 0), 1)
+}
+
+// SetLatency delays each datagram by latency plus a random duration of up to
+// jitter, which may reorder datagrams.
+func (n *Network) SetLatency(latency, jitter time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.latency, n.jitter = max(latency, 0), max(jitter, 0)
+}
+
+// SetBandwidth limits each bind to sending bitsPerSecond, or lifts the limit
+// if zero. Datagrams wait to be sent in turn, and are dropped while the
+// datagrams waiting before them take more than QueueSize bytes.
+func (n *Network) SetBandwidth(bitsPerSecond int64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.bandwidth = max(bitsPerSecond, 0)
+}
+
+// Partition drops all datagrams between a and b, in both directions, until
+// Heal is called for them.
+func (n *Network) Partition(a, b *Bind) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.partitions[bindPair(a, b)] = true
+}
+
+// Heal lets datagrams between a and b through again.
+func (n *Network) Heal(a, b *Bind) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.partitions, bindPair(a, b))
+}
+
+func bindPair(a, b *Bind) [2]*Bind {
+	if a.addr.Less(b.addr) {
+		return [2]*Bind{a, b}
+	}
+	return [2]*Bind{b, a}
+}
+
+// Stats returns the counts of datagrams so far.
+func (n *Network) Stats() Stats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.stats
+}
+
+// NewBind returns a closed Bind with an address of its own on the network.
+func (n *Network) NewBind() *Bind {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.addrs++
+	b := &Bind{net: n, addr: netip.AddrFrom4([4]byte{10, byte(n.addrs >> 16), byte(n.addrs >> 8), byte(n.addrs)})}
+	b.timer = time.AfterFunc(time.Hour, b.deliverDue)
+	b.timer.Stop()
+	return b
+}
+
+// A Bind is a conn.Bind on a Network.
+type Bind struct {
+	net  *Network
+	addr netip.Addr
+
+	// Guarded by net.mu.
+	port      uint16
+	incoming  chan datagram // nil while closed
+	closed    chan struct{}
+	busyUntil time.Time    // when the datagrams waiting for its bandwidth are sent
+	queued    int          // bytes of those datagrams
+	pending   datagramHeap // sent to it, still in flight
+	timer     *time.Timer  // delivering pending when the first of them is due
+}
+
+type datagram struct {
+	packet []byte
+	src    netip.AddrPort
+	due    time.Time
+	seq    uint64 // keeps datagrams due at once in order
+	size   int    // bytes of the queue of the sender it took
+	sender *Bind
+}
+
+// Endpoint is the conn.Endpoint of a Bind.
+type Endpoint struct {
+	netip.AddrPort
+}
+
+var (
+	_ conn.Bind     = (*Bind)(nil)
+	_ conn.Endpoint = (*Endpoint)(nil)
+)
+
+// Addr returns the address of the bind.
+func (b *Bind) Addr() netip.Addr {
+	return b.addr
+}
+
+// Endpoint returns the address and port the bind is reached at while open.
+func (b *Bind) Endpoint() netip.AddrPort {
+	b.net.mu.Lock()
+	defer b.net.mu.Unlock()
+	return netip.AddrPortFrom(b.addr, b.port)
+}
+
+func (b *Bind) Open(port uint16) ([]conn.ReceiveFunc, uint16, error) {
+	n := b.net
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if b.incoming != nil {
+		return nil, 0, conn.ErrBindAlreadyOpen
+	}
+	if port == 0 {
+		port = uint16(firstPort + n.rand.IntN(1<<16-firstPort))
+	}
+	b.port = port
+	b.incoming = make(chan datagram, receiveQueueSize)
+	b.closed = make(chan struct{})
+	n.binds[netip.AddrPortFrom(b.addr, port)] = b
+	return []conn.ReceiveFunc{b.makeReceiveFunc(b.incoming, b.closed)}, port, nil
+}
+
+func (b *Bind) makeReceiveFunc(incoming chan datagram, closed chan struct{}) conn.ReceiveFunc {
+	return func(bufs [][]byte, sizes []int, eps []conn.Endpoint) (int, error) {
+		var d datagram
+		select {
+		case <-closed:
+			return 0, net.ErrClosed
+		case d = <-incoming:
+		}
+		n := 0
+		for {
+			sizes[n] = copy(bufs[n], d.packet)
+			eps[n] = &Endpoint{d.src}
+			n++
+			if n == len(bufs) {
+				return n, nil
+			}
+			select {
+			case d = <-incoming:
+			default:
+				return n, nil
+			}
+		}
+	}
+}
+
+// Close closes the bind, dropping the datagrams in flight to it.
+func (b *Bind) Close() error {
+	n := b.net
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if b.incoming == nil {
+		return nil
+	}
+	close(b.closed)
+	b.incoming, b.closed = nil, nil
+	delete(n.binds, netip.AddrPortFrom(b.addr, b.port))
+	b.timer.Stop()
+	for _, d := range b.pending {
+		d.sender.queued -= d.size
+	}
+	n.stats.Unreachable += uint64(len(b.pending))
+	b.pending = nil
+	return nil
+}
+
+func (b *Bind) SetMark(mark uint32) error { return nil }
+
+func (b *Bind) BatchSize() int { return conn.IdealBatchSize }
+
+func (b *Bind) Send(bufs [][]byte, ep conn.Endpoint) error {
+	e, ok := ep.(*Endpoint)
+	if !ok {
+		return conn.ErrWrongEndpointType
+	}
+	n := b.net
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if b.incoming == nil {
+		return net.ErrClosed
+	}
+	now := time.Now()
+	src := netip.AddrPortFrom(b.addr, b.port)
+	for _, buf := range bufs {
+		n.stats.Sent++
+		dst := n.binds[e.AddrPort]
+		switch {
+		case dst == nil:
+			n.stats.Unreachable++
+			continue
+		case n.partitions[bindPair(b, dst)]:
+			n.stats.Partitioned++
+			continue
+		case n.loss > 0 && n.rand.Float64() < n.loss:
+			n.stats.Lost++
+			continue
+		}
+
+		d := datagram{packet: append([]byte(nil), buf...), src: src, due: now, sender: b}
+		if n.bandwidth > 0 {
+			if b.busyUntil.Before(now) {
+				b.busyUntil, b.queued = now, 0
+			}
+			if b.queued+len(buf) > QueueSize {
+				n.stats.Overflowed++
+				continue
+			}
+			b.busyUntil = b.busyUntil.Add(time.Duration(int64(len(buf)) * 8 * int64(time.Second) / n.bandwidth))
+			b.queued += len(buf)
+			d.due, d.size = b.busyUntil, len(buf)
+		}
+		d.due = d.due.Add(n.latency)
+		if n.jitter > 0 {
+			d.due = d.due.Add(time.Duration(n.rand.Int64N(int64(n.jitter))))
+		}
+		n.seq++
+		d.seq = n.seq
+		dst.schedule(d, now)
+	}
+	return nil
+}
+
+// schedule delivers d when it is due.
+func (b *Bind) schedule(d datagram, now time.Time) {
+	if !d.due.After(now) && len(b.pending) == 0 {
+		b.deliver(d)
+		return
+	}
+	heap.Push(&b.pending, d)
+	if b.pending[0].seq == d.seq {
+		b.timer.Reset(d.due.Sub(now))
+	}
+}
+
+// deliverDue delivers the datagrams in flight to the bind that are due.
+func (b *Bind) deliverDue() {
+	n := b.net
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	now := time.Now()
+	for len(b.pending) > 0 && !b.pending[0].due.After(now) {
+		b.deliver(heap.Pop(&b.pending).(datagram))
+	}
+	if len(b.pending) > 0 {
+		b.timer.Reset(b.pending[0].due.Sub(now))
+	}
+}
+
+func (b *Bind) deliver(d datagram) {
+	n := b.net
+	d.sender.queued -= d.size
+	select {
+	case b.incoming <- d:
+		n.stats.Delivered++
+	default:
+		n.stats.Overflowed++
+	}
+}
+
+func (b *Bind) ParseEndpoint(s string) (conn.Endpoint, error) {
+	e, err := netip.ParseAddrPort(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", conn.ErrInvalidEndpoint, err)
+	}
+	return &Endpoint{e}, nil
+}
+
+func (e *Endpoint) ClearSrc() {}
+
+func (e *Endpoint) SrcToString() string { return "" }
+
+func (e *Endpoint) DstToString() string { return e.AddrPort.String() }
+
+func (e *Endpoint) DstToBytes() []byte {
+	b, _ := e.AddrPort.MarshalBinary()
+	return b
+}
+
+func (e *Endpoint) DstIP() netip.Addr { return e.AddrPort.Addr() }
+
+func (e *Endpoint) SrcIP() netip.Addr { return netip.Addr{} }
+
+// datagramHeap orders datagrams by when they are due.
+type datagramHeap []datagram
+
+func (h datagramHeap) Len() int { return len(h) }
+
+func (h datagramHeap) Less(i, j int) bool {
+	if h[i].due.Equal(h[j].due) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].due.Before(h[j].due)
+}
+
+func (h datagramHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *datagramHeap) Push(x any) { *h = append(*h, x.(datagram)) }
+
+func (h *datagramHeap) Pop() any {
+	old := *h
+	d := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return d
+}
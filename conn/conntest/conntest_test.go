@@ -0,0 +1,217 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package conntest
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+type testBind struct {
+	*Bind
+	ep      conn.Endpoint
+	receive conn.ReceiveFunc
+}
+
+func openBinds(t *testing.T, n *Network, count int) []testBind {
+	t.Helper()
+	binds := make([]testBind, count)
+	for i := range binds {
+		b := n.NewBind()
+		fns, _, err := b.Open(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { b.Close() })
+		ep, err := b.ParseEndpoint(b.Endpoint().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		binds[i] = testBind{b, ep, fns[0]}
+	}
+	return binds
+}
+
+// receiveAll receives datagrams until want of them arrived or timeout passed.
+func (b testBind) receiveAll(want int, timeout time.Duration) [][]byte {
+	var got [][]byte
+	bufs := make([][]byte, conn.IdealBatchSize)
+	for i := range bufs {
+		bufs[i] = make([]byte, 2048)
+	}
+	sizes := make([]int, len(bufs))
+	eps := make([]conn.Endpoint, len(bufs))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for len(got) < want {
+			n, err := b.receive(bufs, sizes, eps)
+			if err != nil {
+				return
+			}
+			for i := range n {
+				got = append(got, append([]byte(nil), bufs[i][:sizes[i]]...))
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		b.Close()
+		<-done
+	}
+	return got
+}
+
+func TestDelivery(t *testing.T) {
+	n := NewNetwork()
+	binds := openBinds(t, n, 2)
+	a, b := binds[0], binds[1]
+	if err := a.Send([][]byte{{1}, {2}, {3}}, b.ep); err != nil {
+		t.Fatal(err)
+	}
+	got := b.receiveAll(3, time.Second)
+	if len(got) != 3 || got[0][0] != 1 || got[2][0] != 3 {
+		t.Fatalf("received %v", got)
+	}
+	if stats := n.Stats(); stats.Sent != 3 || stats.Delivered != 3 {
+		t.Errorf("stats %+v", stats)
+	}
+
+	if err := a.Send([][]byte{{1}}, &Endpoint{a.Endpoint()}); err != nil {
+		t.Fatal(err)
+	}
+	c := n.NewBind()
+	if err := a.Send([][]byte{{1}}, &Endpoint{c.Endpoint()}); err != nil {
+		t.Fatal(err)
+	}
+	if stats := n.Stats(); stats.Unreachable != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+	if err := c.Send([][]byte{{1}}, a.ep); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("send on closed bind: %v", err)
+	}
+	if _, _, err := a.Open(0); !errors.Is(err, conn.ErrBindAlreadyOpen) {
+		t.Errorf("reopen: %v", err)
+	}
+	if _, err := a.ParseEndpoint("10.0.0.1"); !errors.Is(err, conn.ErrInvalidEndpoint) {
+		t.Errorf("parse without port: %v", err)
+	}
+}
+
+func TestLoss(t *testing.T) {
+	n := NewNetwork()
+	n.SetSeed(1)
+	n.SetLoss(0.25)
+	binds := openBinds(t, n, 2)
+	a, b := binds[0], binds[1]
+	for range 1000 {
+		a.Send([][]byte{{0}}, b.ep)
+	}
+	stats := n.Stats()
+	if stats.Lost < 200 || stats.Lost > 300 || stats.Lost+stats.Delivered != 1000 {
+		t.Errorf("stats %+v", stats)
+	}
+	if got := b.receiveAll(int(stats.Delivered), time.Second); len(got) != int(stats.Delivered) {
+		t.Errorf("received %d of %d", len(got), stats.Delivered)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	n := NewNetwork()
+	n.SetLatency(50*time.Millisecond, 0)
+	binds := openBinds(t, n, 2)
+	a, b := binds[0], binds[1]
+	start := time.Now()
+	for i := range 10 {
+		a.Send([][]byte{{byte(i)}}, b.ep)
+	}
+	got := b.receiveAll(10, time.Second)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("received after %v", elapsed)
+	}
+	for i, packet := range got {
+		if packet[0] != byte(i) {
+			t.Fatalf("received out of order: %v", got)
+		}
+	}
+	if len(got) != 10 {
+		t.Errorf("received %d", len(got))
+	}
+
+	// Jitter delivers all datagrams in time, in whatever order.
+	n.SetLatency(10*time.Millisecond, 20*time.Millisecond)
+	for i := range 100 {
+		a.Send([][]byte{{byte(i)}}, b.ep)
+	}
+	if got := b.receiveAll(100, time.Second); len(got) != 100 {
+		t.Errorf("received %d with jitter", len(got))
+	}
+}
+
+func TestBandwidth(t *testing.T) {
+	n := NewNetwork()
+	n.SetBandwidth(8 << 20) // 1 MiB/s
+	binds := openBinds(t, n, 2)
+	a, b := binds[0], binds[1]
+	packet := make([]byte, 1024)
+	start := time.Now()
+	for range 100 {
+		a.Send([][]byte{packet}, b.ep)
+	}
+	got := b.receiveAll(100, 2*time.Second)
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("100 KiB at 1 MiB/s received after %v", elapsed)
+	}
+	if len(got) != 100 {
+		t.Errorf("received %d", len(got))
+	}
+
+	// More than the queue holds overflows.
+	for range QueueSize/len(packet) + 10 {
+		a.Send([][]byte{packet}, b.ep)
+	}
+	if stats := n.Stats(); stats.Overflowed < 10 {
+		t.Errorf("stats %+v", stats)
+	}
+}
+
+func TestPartition(t *testing.T) {
+	n := NewNetwork()
+	binds := openBinds(t, n, 3)
+	a, b, c := binds[0], binds[1], binds[2]
+	n.Partition(b.Bind, a.Bind)
+	a.Send([][]byte{{1}}, b.ep)
+	b.Send([][]byte{{1}}, a.ep)
+	a.Send([][]byte{{2}}, c.ep)
+	if stats := n.Stats(); stats.Partitioned != 2 || stats.Delivered != 1 {
+		t.Errorf("stats %+v", stats)
+	}
+	n.Heal(a.Bind, b.Bind)
+	a.Send([][]byte{{3}}, b.ep)
+	if got := b.receiveAll(1, time.Second); len(got) != 1 || got[0][0] != 3 {
+		t.Errorf("received %v after healing", got)
+	}
+}
+
+func TestCloseInFlight(t *testing.T) {
+	n := NewNetwork()
+	n.SetLatency(time.Hour, 0)
+	binds := openBinds(t, n, 2)
+	a, b := binds[0], binds[1]
+	a.Send([][]byte{{1}, {2}}, b.ep)
+	b.Close()
+	if stats := n.Stats(); stats.Unreachable != 2 || stats.Delivered != 0 {
+		t.Errorf("stats %+v", stats)
+	}
+	if _, err := b.receive(make([][]byte, 1), make([]int, 1), make([]conn.Endpoint, 1)); !errors.Is(err, net.ErrClosed) {
+		t.Errorf("receive on closed bind: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package conn
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+// An ICMPError is an ICMP or ICMPv6 error returned by the network for a
+// datagram a Bind sent.
+type ICMPError struct {
+	Dst      netip.AddrPort // destination of the datagram
+	Offender netip.Addr     // host that returned the error, if known
+	IPv6     bool           // whether Type and Code are those of ICMPv6
+	Type     uint8
+	Code     uint8
+}
+
+// Unreachable reports whether the error is a destination unreachable
+// message, other than one asking for fragmentation.
+func (e ICMPError) Unreachable() bool {
+	if e.IPv6 {
+		return e.Type == 1
+	}
+	return e.Type == 3 && e.Code != 4
+}
+
+// Prohibited reports whether the error says communication with the
+// destination is administratively prohibited.
+func (e ICMPError) Prohibited() bool {
+	if e.IPv6 {
+		return e.Type == 1 && e.Code == 1
+	}
+	return e.Type == 3 && (e.Code == 9 || e.Code == 10 || e.Code == 13)
+}
+
+// PortUnreachable reports whether the error says no one listens on the
+// destination port.
+func (e ICMPError) PortUnreachable() bool {
+	if e.IPv6 {
+		return e.Type == 1 && e.Code == 4
+	}
+	return e.Type == 3 && e.Code == 3
+}
+
+func (e ICMPError) String() string {
+	var kind string
+	switch {
+	case e.PortUnreachable():
+		kind = "port unreachable"
+	case e.Prohibited():
+		kind = "administratively prohibited"
+	case e.Unreachable():
+		kind = "unreachable"
+	default:
+		kind = "error"
+	}
+	proto := "ICMP"
+	if e.IPv6 {
+		proto = "ICMPv6"
+	}
+	s := fmt.Sprintf("%s %s (type %d, code %d) for %v", proto, kind, e.Type, e.Code, e.Dst)
+	if e.Offender.IsValid() {
+		s += " from " + e.Offender.String()
+	}
+	return s
+}
+
+// BindICMPErrors is implemented by Bind objects that can report ICMP errors
+// returned for the datagrams they send.
+type BindICMPErrors interface {
+	// SetICMPErrorHandler sets the function called with each ICMP error
+	// received, from the goroutine sending or receiving at the time, or
+	// nil to stop reporting them. It takes effect on open sockets and
+	// persists across Close and Open.
+	SetICMPErrorHandler(fn func(ICMPError)) error
+}
+
+var _ BindICMPErrors = (*StdNetBind)(nil)
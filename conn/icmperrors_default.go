@@ -0,0 +1,23 @@
+//go:build !linux
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package conn
+
+import (
+	"errors"
+	"net"
+)
+
+const icmpErrorsSupported = false
+
+func setRecvErr(conn *net.UDPConn, is6, enable bool) error {
+	return errors.ErrUnsupported
+}
+
+func (s *StdNetBind) drainICMPErrors(conn *net.UDPConn, err error) bool {
+	return false
+}
@@ -0,0 +1,132 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package conn
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const icmpErrorsSupported = true
+
+// sockExtendedErrSize is the size of struct sock_extended_err, which the
+// address of the offender follows.
+const sockExtendedErrSize = 16
+
+// setRecvErr enables or disables queueing ICMP errors on conn. While
+// enabled, the kernel also fails the next send or receive on the socket
+// with the error, which drainICMPErrors recovers from.
+func setRecvErr(conn *net.UDPConn, is6, enable bool) error {
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	value := 0
+	if enable {
+		value = 1
+	}
+	var sockErr error
+	err = rc.Control(func(fd uintptr) {
+		if is6 {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_RECVERR, value)
+		} else {
+			sockErr = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_RECVERR, value)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// drainICMPErrors reads the error queue of conn after a send or receive
+// failed with err, passing the ICMP errors in it to the handler. It reports
+// whether the queue held any, in which case err came from it and the
+// operation can be retried.
+func (s *StdNetBind) drainICMPErrors(conn *net.UDPConn, err error) bool {
+	handler := s.icmpHandler.Load()
+	var errno syscall.Errno
+	if handler == nil || !errors.As(err, &errno) {
+		return false
+	}
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		return false
+	}
+	var (
+		drained bool
+		buf     [1]byte
+		oob     [128]byte
+	)
+	// Control rather than Read, which would wait for a receive blocked on
+	// the socket.
+	rc.Control(func(fd uintptr) {
+		for {
+			_, oobn, _, from, err := unix.Recvmsg(int(fd), buf[:], oob[:], unix.MSG_ERRQUEUE|unix.MSG_DONTWAIT)
+			if err != nil {
+				return
+			}
+			drained = true
+			if e, ok := parseICMPError(oob[:oobn], from); ok {
+				(*handler)(e)
+			}
+		}
+	})
+	return drained
+}
+
+// parseICMPError parses the control messages of an entry of the error queue
+// for the datagram sent to dst.
+func parseICMPError(oob []byte, dst unix.Sockaddr) (ICMPError, bool) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return ICMPError{}, false
+	}
+	for _, msg := range msgs {
+		if !(msg.Header.Level == unix.SOL_IP && msg.Header.Type == unix.IP_RECVERR) &&
+			!(msg.Header.Level == unix.SOL_IPV6 && msg.Header.Type == unix.IPV6_RECVERR) {
+			continue
+		}
+		data := msg.Data
+		if len(data) < sockExtendedErrSize {
+			continue
+		}
+		origin := data[4]
+		if origin != unix.SO_EE_ORIGIN_ICMP && origin != unix.SO_EE_ORIGIN_ICMP6 {
+			return ICMPError{}, false
+		}
+		e := ICMPError{
+			IPv6: origin == unix.SO_EE_ORIGIN_ICMP6,
+			Type: data[5],
+			Code: data[6],
+		}
+		if offender := data[sockExtendedErrSize:]; len(offender) >= 2 {
+			switch binary.NativeEndian.Uint16(offender) {
+			case unix.AF_INET:
+				if len(offender) >= 8 {
+					e.Offender = netip.AddrFrom4([4]byte(offender[4:8]))
+				}
+			case unix.AF_INET6:
+				if len(offender) >= 24 {
+					e.Offender = netip.AddrFrom16([16]byte(offender[8:24])).Unmap()
+				}
+			}
+		}
+		switch sa := dst.(type) {
+		case *unix.SockaddrInet4:
+			e.Dst = netip.AddrPortFrom(netip.AddrFrom4(sa.Addr), uint16(sa.Port))
+		case *unix.SockaddrInet6:
+			e.Dst = netip.AddrPortFrom(netip.AddrFrom16(sa.Addr).Unmap(), uint16(sa.Port))
+		}
+		return e, true
+	}
+	return ICMPError{}, false
+}
@@ -0,0 +1,71 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package conn
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestStdNetBindICMPErrors(t *testing.T) {
+	// A port that was just free, so that datagrams to it draw a port
+	// unreachable error from the loopback.
+	l, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := l.LocalAddr().(*net.UDPAddr).AddrPort()
+	l.Close()
+
+	bind := NewStdNetBind().(*StdNetBind)
+	errs := make(chan ICMPError, 8)
+	if err := bind.SetICMPErrorHandler(func(e ICMPError) { errs <- e }); err != nil {
+		t.Fatal(err)
+	}
+	fns, _, err := bind.Open(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan error, len(fns))
+	for _, fn := range fns {
+		go func() {
+			bufs := [][]byte{make([]byte, 1500)}
+			_, err := fn(bufs, make([]int, 1), make([]Endpoint, 1))
+			received <- err
+		}()
+	}
+
+	dst := &StdNetEndpoint{AddrPort: netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), closed.Port())}
+	var e ICMPError
+	deadline := time.After(5 * time.Second)
+wait:
+	for {
+		// The error fails whichever of the next send and receive comes
+		// first, and either must recover from it.
+		if err := bind.Send([][]byte{[]byte("ping")}, dst); err != nil {
+			t.Fatalf("Send = %v", err)
+		}
+		select {
+		case e = <-errs:
+			break wait
+		case <-time.After(50 * time.Millisecond):
+		case <-deadline:
+			t.Fatal("no ICMP error reported")
+		}
+	}
+	if !e.PortUnreachable() || !e.Unreachable() || e.Prohibited() || e.Dst != dst.AddrPort {
+		t.Errorf("error = %v", e)
+	}
+	bind.Close()
+	for range fns {
+		if err := <-received; !errors.Is(err, net.ErrClosed) {
+			t.Errorf("receive = %v", err)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+/* Core affinity
+ *
+ * By default the scheduler moves the TUN reader and the UDP receive
+ * goroutines between cores at will, and received packets are decrypted by
+ * whichever worker takes them from the shared decryption queue, so a packet
+ * and the state it touches bounce between the caches of several cores on
+ * its way through the device. With core affinity enabled, each of these
+ * goroutines takes a core slot of its own and, where the platform allows
+ * it, locks itself to an OS thread pinned to the slot's core: the TUN
+ * reader to the first core the process may run on, and the receive
+ * goroutines to the following ones in turn. The receive goroutines then
+ * decrypt their transport packets themselves instead of queueing them for
+ * the decryption workers, so a packet stays on the core it was received on
+ * until it reaches its peer's sequential receiver.
+ *
+ * Goroutines notice the option changing at their next batch. Each slot
+ * counts the packets and bytes handled on it, to tell whether the layout
+ * spreads the load; on platforms without thread affinity the slots are
+ * kept and counted but not pinned.
+ */
+
+// CoreStats are the statistics of a core slot taken by the TUN reader or a
+// UDP receive goroutine with core affinity enabled.
+type CoreStats struct {
+	Role    string // "tun" or "udp"
+	CPU     int    // core the slot is pinned to
+	Pinned  bool   // whether a goroutine is pinned to the core now
+	Packets uint64 // packets read from the TUN device or received from the bind
+	Bytes   uint64
+}
+
+const (
+	coreRoleTUN = "tun"
+	coreRoleUDP = "udp"
+)
+
+type coreSlot struct {
+	role    string
+	cpu     int
+	inUse   bool // guarded by the device's affinity mutex
+	pinned  atomic.Bool
+	packets atomic.Uint64
+	bytes   atomic.Uint64
+}
+
+type coreAffinity struct {
+	enabled atomic.Bool
+	sync.Mutex
+	cpus  []int // cores the process may run on, in order
+	slots []*coreSlot
+}
+
+// SetCoreAffinity enables or disables core affinity of the TUN reader and
+// the UDP receive goroutines.
+func (device *Device) SetCoreAffinity(enabled bool) {
+	device.affinity.enabled.Store(enabled)
+}
+
+// CoreAffinity reports whether core affinity is enabled.
+func (device *Device) CoreAffinity() bool {
+	return device.affinity.enabled.Load()
+}
+
+// CoreStats returns the statistics of the core slots taken so far.
+func (device *Device) CoreStats() []CoreStats {
+	a := &device.affinity
+	a.Lock()
+	defer a.Unlock()
+	stats := make([]CoreStats, len(a.slots))
+	for i, slot := range a.slots {
+		stats[i] = CoreStats{
+			Role:    slot.role,
+			CPU:     slot.cpu,
+			Pinned:  slot.pinned.Load(),
+			Packets: slot.packets.Load(),
+			Bytes:   slot.bytes.Load(),
+		}
+	}
+	return stats
+}
+
+// takeSlot returns a free slot of role, adding one on the next core if none
+// is free. The first slot, on the first core, is kept for the TUN reader.
+func (a *coreAffinity) takeSlot(role string) *coreSlot {
+	a.Lock()
+	defer a.Unlock()
+	if a.slots == nil {
+		a.cpus = allowedCPUs()
+		a.slots = []*coreSlot{{role: coreRoleTUN, cpu: a.cpus[0]}}
+	}
+	for _, slot := range a.slots {
+		if slot.role == role && !slot.inUse {
+			slot.inUse = true
+			return slot
+		}
+	}
+	slot := &coreSlot{role: role, cpu: a.cpus[len(a.slots)%len(a.cpus)], inUse: true}
+	a.slots = append(a.slots, slot)
+	return slot
+}
+
+// A coreBinding pins the goroutine owning it to the core of a slot while
+// core affinity is enabled.
+type coreBinding struct {
+	device *Device
+	role   string
+	slot   *coreSlot // nil while core affinity is disabled
+	locked bool      // whether the goroutine is locked to a pinned thread
+}
+
+// update takes or releases the binding's slot as core affinity was enabled
+// or disabled since the last call, and reports whether it is enabled.
+func (b *coreBinding) update() bool {
+	enabled := b.device.affinity.enabled.Load()
+	if enabled == (b.slot != nil) {
+		return enabled
+	}
+	if !enabled {
+		b.release()
+		return false
+	}
+	b.slot = b.device.affinity.takeSlot(b.role)
+	runtime.LockOSThread()
+	if err := pinThread(b.slot.cpu); err != nil {
+		runtime.UnlockOSThread()
+		b.device.log.Verbosef("Routine: %s - not pinned to core %d: %v", b.role, b.slot.cpu, err)
+		return true
+	}
+	b.locked = true
+	b.slot.pinned.Store(true)
+	return true
+}
+
+// count credits the binding's slot, if any, with packets of sizes.
+func (b *coreBinding) count(sizes []int) {
+	if b.slot == nil {
+		return
+	}
+	var bytes uint64
+	for _, size := range sizes {
+		bytes += uint64(size)
+	}
+	b.slot.packets.Add(uint64(len(sizes)))
+	b.slot.bytes.Add(bytes)
+}
+
+// release unpins the goroutine and frees its slot.
+func (b *coreBinding) release() {
+	if b.slot == nil {
+		return
+	}
+	if b.locked {
+		unpinThread()
+		runtime.UnlockOSThread()
+		b.locked = false
+		b.slot.pinned.Store(false)
+	}
+	a := &b.device.affinity
+	a.Lock()
+	b.slot.inUse = false
+	a.Unlock()
+	b.slot = nil
+}
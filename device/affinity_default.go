@@ -0,0 +1,28 @@
+//go:build !linux
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"errors"
+	"runtime"
+)
+
+// allowedCPUs returns the cores the process may run on, in order.
+func allowedCPUs() []int {
+	cpus := make([]int, runtime.NumCPU())
+	for i := range cpus {
+		cpus[i] = i
+	}
+	return cpus
+}
+
+func pinThread(cpu int) error {
+	return errors.ErrUnsupported
+}
+
+func unpinThread() {}
@@ -0,0 +1,60 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"runtime"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// processCPUs returns the affinity mask the process started with.
+var processCPUs = sync.OnceValues(func() (unix.CPUSet, error) {
+	var set unix.CPUSet
+	err := unix.SchedGetaffinity(0, &set)
+	return set, err
+})
+
+// allowedCPUs returns the cores the process may run on, in order.
+func allowedCPUs() []int {
+	set, err := processCPUs()
+	if err != nil {
+		return defaultCPUs()
+	}
+	var cpus []int
+	for cpu := 0; cpu < len(set)*64; cpu++ {
+		if set.IsSet(cpu) {
+			cpus = append(cpus, cpu)
+		}
+	}
+	if len(cpus) == 0 {
+		return defaultCPUs()
+	}
+	return cpus
+}
+
+// pinThread pins the calling thread to cpu.
+func pinThread(cpu int) error {
+	var set unix.CPUSet
+	set.Set(cpu)
+	return unix.SchedSetaffinity(0, &set)
+}
+
+// unpinThread lets the calling thread run on the cores of the process again.
+func unpinThread() {
+	if set, err := processCPUs(); err == nil {
+		unix.SchedSetaffinity(0, &set)
+	}
+}
+
+func defaultCPUs() []int {
+	cpus := make([]int, runtime.NumCPU())
+	for i := range cpus {
+		cpus[i] = i
+	}
+	return cpus
+}
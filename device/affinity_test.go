@@ -0,0 +1,74 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCoreAffinity(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	for i := range pair {
+		if err := pair[i].dev.IpcSet("core_affinity=true\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Goroutines take their slots at their next batch, and received
+	// packets are decrypted inline from then on.
+	for range 4 {
+		pair.Send(t, Ping, nil)
+		pair.Send(t, Pong, nil)
+	}
+
+	status := pair[0].dev.Status()
+	if !status.CoreAffinity {
+		t.Error("core affinity not enabled")
+	}
+	roles := make(map[string]CoreStats)
+	for _, core := range status.Cores {
+		if core.Packets == 0 {
+			continue
+		}
+		roles[core.Role] = core
+		if runtime.GOOS == "linux" && !core.Pinned {
+			t.Errorf("%s slot on core %d not pinned", core.Role, core.CPU)
+		}
+	}
+	if roles[coreRoleTUN].Packets == 0 || roles[coreRoleUDP].Packets == 0 {
+		t.Errorf("cores = %+v", status.Cores)
+	}
+	if status.Cores[0].Role != coreRoleTUN || status.Cores[0].CPU != allowedCPUs()[0] {
+		t.Errorf("first slot = %+v", status.Cores[0])
+	}
+
+	cfg, err := pair[0].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(cfg, "\ncore_affinity=true\n") || !strings.Contains(cfg, "\ncore_stats=tun:") {
+		t.Errorf("get output lacks core affinity:\n%s", cfg)
+	}
+
+	// Disabled, the goroutines give up their slots at their next batch.
+	for i := range pair {
+		pair[i].dev.SetCoreAffinity(false)
+	}
+	for range 2 {
+		pair.Send(t, Ping, nil)
+		pair.Send(t, Pong, nil)
+	}
+	if err := pair[0].dev.IpcSet("core_affinity=maybe\n"); err == nil {
+		t.Error("invalid core_affinity accepted")
+	}
+	for _, core := range pair[0].dev.CoreStats() {
+		if core.Role == coreRoleTUN && core.Pinned {
+			t.Error("TUN reader still pinned")
+		}
+	}
+}
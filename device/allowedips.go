@@ -204,6 +204,41 @@ func (node *trieEntry) lookup(ip []byte) *Peer {
 	return found
 }
 
+// overlapping returns a peer other than except with an entry overlapping
+// ip/cidr, or nil if there is none.
+func (node *trieEntry) overlapping(ip []byte, cidr uint8, except *Peer) *Peer {
+	for node != nil {
+		common := commonBits(node.bits, ip)
+		if node.cidr >= cidr {
+			if common < cidr {
+				return nil
+			}
+			return node.anyPeerExcept(except)
+		}
+		if common < node.cidr {
+			return nil
+		}
+		if node.peer != nil && node.peer != except {
+			return node.peer
+		}
+		node = node.child[node.choose(ip)]
+	}
+	return nil
+}
+
+func (node *trieEntry) anyPeerExcept(except *Peer) *Peer {
+	if node == nil {
+		return nil
+	}
+	if node.peer != nil && node.peer != except {
+		return node.peer
+	}
+	if peer := node.child[0].anyPeerExcept(except); peer != nil {
+		return peer
+	}
+	return node.child[1].anyPeerExcept(except)
+}
+
 type AllowedIPs struct {
 	IPv4  *trieEntry
 	IPv6  *trieEntry
@@ -226,7 +261,10 @@ func (table *AllowedIPs) EntriesForPeer(peer *Peer, cb func(prefix netip.Prefix)
 func (table *AllowedIPs) RemoveByPeer(peer *Peer) {
 	table.mutex.Lock()
 	defer table.mutex.Unlock()
+	table.removeByPeerLocked(peer)
+}
 
+func (table *AllowedIPs) removeByPeerLocked(peer *Peer) {
 	var next *list.Element
 	for elem := peer.trieEntries.Front(); elem != nil; elem = next {
 		next = elem.Next()
@@ -268,7 +306,25 @@ func (table *AllowedIPs) RemoveByPeer(peer *Peer) {
 func (table *AllowedIPs) Insert(prefix netip.Prefix, peer *Peer) {
 	table.mutex.Lock()
 	defer table.mutex.Unlock()
+	table.insertLocked(prefix, peer)
+}
 
+// UpdateForPeer applies a batch of allowed IP changes for a single peer in one
+// critical section: if replace is set, the peer's existing entries are removed
+// first, then each prefix is inserted. Concurrent lookups observe either the old
+// or the new set of entries, never the partially rewritten table in between.
+func (table *AllowedIPs) UpdateForPeer(peer *Peer, replace bool, prefixes []netip.Prefix) {
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+	if replace {
+		table.removeByPeerLocked(peer)
+	}
+	for _, prefix := range prefixes {
+		table.insertLocked(prefix, peer)
+	}
+}
+
+func (table *AllowedIPs) insertLocked(prefix netip.Prefix, peer *Peer) {
 	if prefix.Addr().Is6() {
 		ip := prefix.Addr().As16()
 		parentIndirection{&table.IPv6, 2}.insert(ip[:], uint8(prefix.Bits()), peer)
@@ -280,6 +336,19 @@ func (table *AllowedIPs) Insert(prefix netip.Prefix, peer *Peer) {
 	}
 }
 
+// overlappingPeer returns a peer other than except with an allowed IP
+// overlapping prefix, or nil if there is none.
+func (table *AllowedIPs) overlappingPeer(prefix netip.Prefix, except *Peer) *Peer {
+	table.mutex.RLock()
+	defer table.mutex.RUnlock()
+	if prefix.Addr().Is6() {
+		ip := prefix.Addr().As16()
+		return table.IPv6.overlapping(ip[:], uint8(prefix.Bits()), except)
+	}
+	ip := prefix.Addr().As4()
+	return table.IPv4.overlapping(ip[:], uint8(prefix.Bits()), except)
+}
+
 func (table *AllowedIPs) Lookup(ip []byte) *Peer {
 	table.mutex.RLock()
 	defer table.mutex.RUnlock()
@@ -0,0 +1,288 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"sync"
+)
+
+/* Address assignment is a control message exchange for road-warrior
+ * provisioning. A client peer configured to accept an assignment requests
+ * one after every completed handshake; the server answers with the inner
+ * addresses and DNS servers configured for that client:
+ *
+ *   request:    ControlAssignRequestType
+ *   assignment: ControlAssignmentType | entries...
+ *   entry:      kind (1 byte) | address length (1 byte) | address | bits (addresses only)
+ *
+ * A zero kind ends the list, as transport padding follows the message.
+ * On the server, each assigned address is also allowed from the client as a
+ * host prefix. On the client, each assigned address is allowed from the
+ * server as a host prefix too, and its whole subnet only if the subnet lies
+ * within one the client set with AcceptAssignmentSubnet, so that other hosts
+ * of the inner network are reachable. A server cannot claim addresses
+ * routed to other peers: prefixes overlapping another peer's allowed IPs
+ * are not added.
+ */
+
+const (
+	ControlAssignRequestType = 0x03
+	ControlAssignmentType    = 0x04
+)
+
+const (
+	assignEntryAddress = 1
+	assignEntryDNS     = 2
+)
+
+// An Assignment holds the inner addresses, with the prefix length of their
+// subnet, and DNS servers a server assigns to a client peer.
+type Assignment struct {
+	Addresses []netip.Prefix
+	DNS       []netip.Addr
+}
+
+func (a *Assignment) isEmpty() bool {
+	return len(a.Addresses) == 0 && len(a.DNS) == 0
+}
+
+func (a *Assignment) clone() Assignment {
+	return Assignment{
+		Addresses: slices.Clone(a.Addresses),
+		DNS:       slices.Clone(a.DNS),
+	}
+}
+
+func (a *Assignment) marshal() []byte {
+	b := []byte{ControlAssignmentType}
+	for _, prefix := range a.Addresses {
+		addr := prefix.Addr().AsSlice()
+		b = append(b, assignEntryAddress, byte(len(addr)))
+		b = append(b, addr...)
+		b = append(b, byte(prefix.Bits()))
+	}
+	for _, ip := range a.DNS {
+		addr := ip.AsSlice()
+		b = append(b, assignEntryDNS, byte(len(addr)))
+		b = append(b, addr...)
+	}
+	return b
+}
+
+var errMalformedAssignment = errors.New("malformed assignment")
+
+func (a *Assignment) unmarshal(b []byte) error {
+	*a = Assignment{}
+	for len(b) != 0 && b[0] != 0 {
+		if len(b) < 2 || (b[1] != 4 && b[1] != 16) || len(b) < 2+int(b[1]) {
+			return errMalformedAssignment
+		}
+		kind := b[0]
+		addr, _ := netip.AddrFromSlice(b[2 : 2+b[1]])
+		b = b[2+b[1]:]
+		switch kind {
+		case assignEntryAddress:
+			if len(b) < 1 || int(b[0]) > addr.BitLen() {
+				return errMalformedAssignment
+			}
+			a.Addresses = append(a.Addresses, netip.PrefixFrom(addr, int(b[0])))
+			b = b[1:]
+		case assignEntryDNS:
+			a.DNS = append(a.DNS, addr)
+		default:
+			return errMalformedAssignment
+		}
+	}
+	return nil
+}
+
+type peerAssignment struct {
+	sync.Mutex
+	offer    Assignment     // assigned to the peer, when acting as its server
+	received *Assignment    // assigned by the peer, when acting as its client
+	subnets  []netip.Prefix // allowed IPs added for received
+	accepted []netip.Prefix // ranges within which received subnets are allowed in full
+}
+
+// AssignAddress adds prefix to the addresses assigned to the peer, and allows
+// the address itself from the peer. The prefix length gives the size of the
+// inner subnet the client will route to this device.
+func (peer *Peer) AssignAddress(prefix netip.Prefix) {
+	peer.addAssignedAddress(prefix)
+	peer.device.allowedips.Insert(hostPrefix(prefix), peer)
+}
+
+func (peer *Peer) addAssignedAddress(prefix netip.Prefix) {
+	peer.assignment.Lock()
+	peer.assignment.offer.Addresses = append(peer.assignment.offer.Addresses, prefix)
+	peer.assignment.Unlock()
+}
+
+func hostPrefix(prefix netip.Prefix) netip.Prefix {
+	return netip.PrefixFrom(prefix.Addr(), prefix.Addr().BitLen())
+}
+
+// AssignDNS adds ip to the DNS servers assigned to the peer.
+func (peer *Peer) AssignDNS(ip netip.Addr) {
+	peer.assignment.Lock()
+	peer.assignment.offer.DNS = append(peer.assignment.offer.DNS, ip)
+	peer.assignment.Unlock()
+}
+
+// ClearAssignment removes all addresses and DNS servers assigned to the peer.
+// Allowed IPs added for assigned addresses are left in place.
+func (peer *Peer) ClearAssignment() {
+	peer.assignment.Lock()
+	peer.assignment.offer = Assignment{}
+	peer.assignment.Unlock()
+}
+
+// ReceivedAssignment returns the assignment most recently received from the
+// peer, or nil if none has been received.
+func (peer *Peer) ReceivedAssignment() *Assignment {
+	peer.assignment.Lock()
+	defer peer.assignment.Unlock()
+	if peer.assignment.received == nil {
+		return nil
+	}
+	a := peer.assignment.received.clone()
+	return &a
+}
+
+// AcceptAssignmentSubnet allows the whole subnet of addresses assigned by
+// the peer if it lies within prefix. Addresses outside every such prefix
+// are only allowed from the peer as host prefixes.
+func (peer *Peer) AcceptAssignmentSubnet(prefix netip.Prefix) {
+	peer.assignment.Lock()
+	peer.assignment.accepted = append(peer.assignment.accepted, prefix.Masked())
+	peer.assignment.Unlock()
+}
+
+// ClearAcceptedAssignmentSubnets removes all prefixes added with
+// AcceptAssignmentSubnet. Allowed IPs already added for a received
+// assignment are left in place until the next one.
+func (peer *Peer) ClearAcceptedAssignmentSubnets() {
+	peer.assignment.Lock()
+	peer.assignment.accepted = nil
+	peer.assignment.Unlock()
+}
+
+// AcceptedAssignmentSubnets returns the prefixes added with
+// AcceptAssignmentSubnet.
+func (peer *Peer) AcceptedAssignmentSubnets() []netip.Prefix {
+	peer.assignment.Lock()
+	defer peer.assignment.Unlock()
+	return slices.Clone(peer.assignment.accepted)
+}
+
+// requestAssignment asks the peer for an assignment if the peer is
+// configured to accept one.
+func (peer *Peer) requestAssignment() {
+	if !peer.acceptAssignment.Load() {
+		return
+	}
+	peer.sendControl([]byte{ControlAssignRequestType})
+}
+
+func (peer *Peer) handleAssignRequest() {
+	peer.assignment.Lock()
+	if peer.assignment.offer.isEmpty() {
+		peer.assignment.Unlock()
+		return
+	}
+	msg := peer.assignment.offer.marshal()
+	peer.assignment.Unlock()
+	if len(msg) > MaxContentSize {
+		peer.device.log.Errorf("%v - Assignment too large to send", peer)
+		return
+	}
+	peer.device.log.Verbosef("%v - Sending address assignment", peer)
+	peer.sendControl(msg)
+}
+
+func (peer *Peer) handleAssignment(msg []byte) {
+	if !peer.acceptAssignment.Load() {
+		return
+	}
+	var a Assignment
+	if err := a.unmarshal(msg); err != nil {
+		peer.device.log.Verbosef("%v - Received invalid address assignment: %v", peer, err)
+		return
+	}
+
+	peer.assignment.Lock()
+	changed := peer.assignment.received == nil ||
+		!slices.Equal(a.Addresses, peer.assignment.received.Addresses) || !slices.Equal(a.DNS, peer.assignment.received.DNS)
+	if changed {
+		var subnets []netip.Prefix
+		for _, prefix := range a.Addresses {
+			subnet := hostPrefix(prefix)
+			if peer.assignmentSubnetAcceptedLocked(prefix.Masked()) {
+				subnet = prefix.Masked()
+			}
+			if other := peer.device.allowedips.overlappingPeer(subnet, peer); other != nil {
+				peer.device.log.Errorf("%v - Not allowing assigned %v, which overlaps the allowed IPs of %v", peer, subnet, other)
+				continue
+			}
+			subnets = append(subnets, subnet)
+		}
+		peer.replaceAssignedSubnetsLocked(subnets)
+		peer.assignment.received = &a
+	}
+	peer.assignment.Unlock()
+
+	if changed {
+		peer.device.log.Verbosef("%v - Received address assignment", peer)
+		peer.device.notifyObservers("address_assigned", peer)
+	}
+}
+
+// assignmentSubnetAcceptedLocked reports whether subnet lies within a prefix
+// added with AcceptAssignmentSubnet.
+func (peer *Peer) assignmentSubnetAcceptedLocked(subnet netip.Prefix) bool {
+	for _, accepted := range peer.assignment.accepted {
+		if accepted.Bits() <= subnet.Bits() && accepted.Contains(subnet.Addr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// replaceAssignedSubnetsLocked swaps the allowed IPs added for a previous
+// assignment for subnets, leaving other allowed IPs of the peer untouched.
+func (peer *Peer) replaceAssignedSubnetsLocked(subnets []netip.Prefix) {
+	var prefixes []netip.Prefix
+	peer.device.allowedips.EntriesForPeer(peer, func(prefix netip.Prefix) bool {
+		if !slices.Contains(peer.assignment.subnets, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+		return true
+	})
+	var added []netip.Prefix
+	for _, subnet := range subnets {
+		if !slices.Contains(prefixes, subnet) {
+			prefixes = append(prefixes, subnet)
+			added = append(added, subnet)
+		}
+	}
+	peer.device.allowedips.UpdateForPeer(peer, true, prefixes)
+	peer.assignment.subnets = added
+}
+
+// setAcceptAssignment enables or disables accepting assignments from the
+// peer, withdrawing the allowed IPs of any received assignment when disabled.
+func (peer *Peer) setAcceptAssignment(accept bool) {
+	peer.assignment.Lock()
+	defer peer.assignment.Unlock()
+	peer.acceptAssignment.Store(accept)
+	if !accept && peer.assignment.received != nil {
+		peer.replaceAssignedSubnetsLocked(nil)
+		peer.assignment.received = nil
+	}
+}
@@ -0,0 +1,143 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/hex"
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAssignmentMarshal(t *testing.T) {
+	a := Assignment{
+		Addresses: []netip.Prefix{netip.MustParsePrefix("10.0.0.2/24"), netip.MustParsePrefix("fd00::2/64")},
+		DNS:       []netip.Addr{netip.MustParseAddr("10.0.0.53")},
+	}
+	msg := a.marshal()
+	var b Assignment
+	if err := b.unmarshal(msg[1:]); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(a.Addresses, b.Addresses) || !slices.Equal(a.DNS, b.DNS) {
+		t.Errorf("got %+v, want %+v", b, a)
+	}
+	if err := b.unmarshal(msg[1 : len(msg)-1]); err == nil {
+		t.Error("truncated assignment accepted")
+	}
+}
+
+func TestAddressAssignment(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	server, client := pair[0].dev, pair[1].dev
+	serverPub, clientPub := server.staticIdentity.publicKey, client.staticIdentity.publicKey
+
+	if err := server.IpcSet(uapiCfg(
+		"public_key", hex.EncodeToString(clientPub[:]),
+		"assign_address", "10.0.0.2/24",
+		"assign_dns", "10.0.0.53",
+	)); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.IpcSet(uapiCfg(
+		"public_key", hex.EncodeToString(serverPub[:]),
+		"accept_assignment", "true",
+		"accept_assignment_subnet", "10.0.0.0/16",
+	)); err != nil {
+		t.Fatal(err)
+	}
+	pair.Send(t, Ping, nil)
+
+	peer := client.LookupPeer(serverPub)
+	var a *Assignment
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if a = peer.ReceivedAssignment(); a != nil {
+			break
+		}
+	}
+	if a == nil {
+		t.Fatal("no assignment received")
+	}
+	if len(a.Addresses) != 1 || a.Addresses[0] != netip.MustParsePrefix("10.0.0.2/24") ||
+		len(a.DNS) != 1 || a.DNS[0] != netip.MustParseAddr("10.0.0.53") {
+		t.Errorf("unexpected assignment: %+v", a)
+	}
+	if got := client.allowedips.Lookup([]byte{10, 0, 0, 1}); got != peer {
+		t.Error("assigned subnet not allowed from server on client")
+	}
+	if got := server.allowedips.Lookup([]byte{10, 0, 0, 2}); got != server.LookupPeer(clientPub) {
+		t.Error("assigned address not allowed from client on server")
+	}
+
+	if cfg, err := client.IpcGet(); err != nil || !strings.Contains(cfg, "accept_assignment_subnet=10.0.0.0/16\n") {
+		t.Errorf("get output lacks the accepted subnet: %v\n%s", err, cfg)
+	}
+
+	peer.setAcceptAssignment(false)
+	if got := client.allowedips.Lookup([]byte{10, 0, 0, 1}); got != nil {
+		t.Error("assigned subnet still allowed after disabling")
+	}
+	if got := client.allowedips.Lookup([]byte{1, 0, 0, 1}); got != peer {
+		t.Error("configured allowed IP lost")
+	}
+}
+
+func TestAssignmentSubnets(t *testing.T) {
+	dev := randDevice(t)
+	defer dev.Close()
+	sk, err := newPrivateKey()
+	assertNil(t, err)
+	server, err := dev.NewPeer(sk.publicKey())
+	assertNil(t, err)
+	sk, err = newPrivateKey()
+	assertNil(t, err)
+	other, err := dev.NewPeer(sk.publicKey())
+	assertNil(t, err)
+	dev.allowedips.Insert(netip.MustParsePrefix("10.1.2.0/24"), other)
+	server.setAcceptAssignment(true)
+
+	assign := func(prefixes ...string) {
+		a := Assignment{}
+		for _, prefix := range prefixes {
+			a.Addresses = append(a.Addresses, netip.MustParsePrefix(prefix))
+		}
+		server.handleAssignment(a.marshal()[1:])
+	}
+	allowed := func(ip string, want *Peer) {
+		t.Helper()
+		if got := dev.allowedips.Lookup(netip.MustParseAddr(ip).AsSlice()); got != want {
+			t.Errorf("%s allowed from %v, want %v", ip, got, want)
+		}
+	}
+
+	// Without an accepted subnet, only the assigned addresses are allowed.
+	assign("10.0.0.2/24", "192.168.0.2/0")
+	allowed("10.0.0.2", server)
+	allowed("10.0.0.1", nil)
+	allowed("192.168.0.2", server)
+	allowed("8.8.8.8", nil)
+
+	// Subnets within an accepted one are allowed in full, unless they
+	// overlap the allowed IPs of another peer.
+	server.AcceptAssignmentSubnet(netip.MustParsePrefix("10.0.0.0/8"))
+	assign("10.0.0.2/24", "10.1.0.2/16", "192.168.0.2/24")
+	allowed("10.0.0.1", server)
+	allowed("10.1.0.2", nil)
+	allowed("10.1.2.1", other)
+	allowed("192.168.0.2", server)
+	allowed("192.168.0.1", nil)
+
+	// A host prefix within another peer's allowed IPs is not taken either.
+	assign("10.1.2.3/32")
+	allowed("10.1.2.3", other)
+	allowed("10.0.0.1", nil)
+	if a := server.ReceivedAssignment(); a == nil || len(a.Addresses) != 1 {
+		t.Errorf("unexpected assignment: %+v", a)
+	}
+}
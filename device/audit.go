@@ -0,0 +1,70 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"net"
+	"time"
+)
+
+// An AuditEntry records a change to a setting that makes the device deviate
+// from standard WireGuard, such as the Noise construction or identifier.
+type AuditEntry struct {
+	Time    time.Time
+	Caller  string // "api" for in-process callers, otherwise the UAPI peer
+	Setting string
+	Value   string
+}
+
+// recordAudit appends an entry to the device's audit trail. The trail is
+// append-only; once MaxAuditEntries have been recorded, further entries are
+// counted but not kept.
+func (device *Device) recordAudit(caller, setting, value string) {
+	device.audit.Lock()
+	defer device.audit.Unlock()
+	if len(device.audit.entries) >= MaxAuditEntries {
+		device.audit.dropped++
+		return
+	}
+	device.audit.entries = append(device.audit.entries, AuditEntry{
+		Time:    time.Now(),
+		Caller:  caller,
+		Setting: setting,
+		Value:   value,
+	})
+}
+
+// AuditTrail returns a copy of the device's audit trail, oldest first, along
+// with the number of entries that were discarded after it filled up.
+func (device *Device) AuditTrail() (entries []AuditEntry, dropped uint64) {
+	device.audit.Lock()
+	defer device.audit.Unlock()
+	return append([]AuditEntry(nil), device.audit.entries...), device.audit.dropped
+}
+
+func uapiCallerAddr(socket net.Conn) string {
+	if addr := socket.RemoteAddr(); addr != nil && addr.String() != "" {
+		return "uapi " + addr.String()
+	}
+	return "uapi"
+}
+
+// isExperimentalLocked reports whether any non-standard setting is active.
+// The caller must hold device.staticIdentity and device.peers.
+func (device *Device) isExperimentalLocked() bool {
+	if device.staticIdentity.construction != NoiseConstruction ||
+		device.staticIdentity.identifier != WGIdentifier || device.handshakeHash() != blake2sHandshakeHash ||
+		device.cipherSuite().Experimental ||
+		device.CookieMAC() != CookieMACBLAKE2s {
+		return true
+	}
+	for _, peer := range device.peers.keyMap {
+		if peer.cipherSuite().Experimental {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.zx2c4.com/wireguard/ipc"
+)
+
+/* The benchmark runs the transport AEAD and the handshake's Diffie-Hellman
+ * on throwaway keys, sized and parallelized like the live device: packets
+ * are as large as the TUN MTU and the parallel run uses as many workers as
+ * the device may start under load. It never touches peers or their keys, so
+ * it can be run on a device that is passing traffic, though the numbers
+ * will then be lower.
+ */
+
+// A BenchmarkResult holds the measurements of Device.Benchmark.
+type BenchmarkResult struct {
+	Construction     string        // Noise construction used by handshakes
+	Workers          int           // crypto workers used for the parallel run
+	PacketSize       int           // plaintext bytes per packet
+	SealRate         float64       // bytes per second encrypted by one worker
+	OpenRate         float64       // bytes per second decrypted by one worker
+	ParallelSealRate float64       // bytes per second encrypted by all workers
+	DHRate           float64       // X25519 operations per second by one worker
+	Duration         time.Duration // total time taken
+}
+
+// Benchmark measures the crypto throughput of this machine for the device's
+// configuration. It takes about four times BenchmarkDuration.
+func (device *Device) Benchmark() BenchmarkResult {
+	return device.benchmark(BenchmarkDuration)
+}
+
+func (device *Device) benchmark(d time.Duration) BenchmarkResult {
+	start := time.Now()
+	device.staticIdentity.RLock()
+	res := BenchmarkResult{
+		Construction: device.staticIdentity.construction,
+		Workers:      device.limits.MaxCryptoWorkers,
+		PacketSize:   int(device.tun.mtu.Load()),
+	}
+	device.staticIdentity.RUnlock()
+	if res.PacketSize <= 0 {
+		res.PacketSize = DefaultMTU
+	}
+
+	res.SealRate = benchmarkSeal(d, res.PacketSize)
+	res.OpenRate = benchmarkOpen(d, res.PacketSize)
+	var wg sync.WaitGroup
+	rates := make([]float64, res.Workers)
+	for i := range rates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rates[i] = benchmarkSeal(d, res.PacketSize)
+		}()
+	}
+	wg.Wait()
+	for _, rate := range rates {
+		res.ParallelSealRate += rate
+	}
+	res.DHRate = benchmarkDH(d)
+	res.Duration = time.Since(start)
+	return res
+}
+
+// benchmarkRate calls op until d has passed and returns the units it
+// reports per second.
+func benchmarkRate(d time.Duration, op func() int) float64 {
+	var units int
+	start := time.Now()
+	for time.Since(start) < d {
+		units += op()
+	}
+	return float64(units) / time.Since(start).Seconds()
+}
+
+func benchmarkAEAD(size int) (s *benchmarkSealer, plaintext []byte) {
+	var key [chacha20poly1305.KeySize]byte
+	rand.Read(key[:])
+	a, _ := chacha20poly1305.New(key[:])
+	plaintext = make([]byte, size)
+	rand.Read(plaintext)
+	return &benchmarkSealer{aead: a, buf: make([]byte, 0, size+chacha20poly1305.Overhead)}, plaintext
+}
+
+// benchmarkSealer seals with transport nonces, a counter in the last eight
+// bytes.
+type benchmarkSealer struct {
+	aead    cipher.AEAD
+	nonce   [chacha20poly1305.NonceSize]byte
+	counter uint64
+	buf     []byte
+}
+
+func (s *benchmarkSealer) seal(plaintext []byte) []byte {
+	binary.LittleEndian.PutUint64(s.nonce[4:], s.counter)
+	s.counter++
+	return s.aead.Seal(s.buf[:0], s.nonce[:], plaintext, nil)
+}
+
+func benchmarkSeal(d time.Duration, size int) float64 {
+	s, plaintext := benchmarkAEAD(size)
+	return benchmarkRate(d, func() int {
+		s.seal(plaintext)
+		return size
+	})
+}
+
+func benchmarkOpen(d time.Duration, size int) float64 {
+	s, plaintext := benchmarkAEAD(size)
+	ciphertext := append([]byte(nil), s.seal(plaintext)...)
+	out := make([]byte, 0, size)
+	return benchmarkRate(d, func() int {
+		if _, err := s.aead.Open(out[:0], s.nonce[:], ciphertext, nil); err != nil {
+			panic(err)
+		}
+		return size
+	})
+}
+
+func benchmarkDH(d time.Duration) float64 {
+	sk, err := newPrivateKey()
+	if err != nil {
+		return 0
+	}
+	peer, err := newPrivateKey()
+	if err != nil {
+		return 0
+	}
+	pk := peer.publicKey()
+	return benchmarkRate(d, func() int {
+		sk.sharedSecret(pk)
+		return 1
+	})
+}
+
+// ipcBenchmark runs Benchmark and writes its results as key=value lines.
+func (device *Device) ipcBenchmark(w io.Writer) error {
+	res := device.Benchmark()
+	if _, err := fmt.Fprintf(w, "construction=%s\nworkers=%d\npacket_size=%d\nseal_bytes_per_sec=%.0f\nopen_bytes_per_sec=%.0f\nparallel_seal_bytes_per_sec=%.0f\ndh_ops_per_sec=%.0f\nduration_nsec=%d\n",
+		res.Construction, res.Workers, res.PacketSize, res.SealRate, res.OpenRate, res.ParallelSealRate, res.DHRate, res.Duration.Nanoseconds()); err != nil {
+		return ipcErrorf(ipc.IpcErrorIO, "failed to write output: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+/* BLAKE3
+ *
+ * A portable implementation of the default hash mode of BLAKE3, with
+ * 32-byte output, for the BLAKE3 handshake hash experiment; no module this
+ * one depends on has one. Input is split into 1024-byte chunks of 64-byte
+ * blocks, each chunk compressed into a chaining value, and the chaining
+ * values merged pairwise into a binary tree whose root is the hash. Keyed
+ * hashing, key derivation and extended output are left out, as the
+ * handshake uses none of them.
+ */
+
+func init() {
+	registerCryptoPrimitive(CryptoPrimitive{Name: "BLAKE3", Rounds: 7, Use: "handshake hash experiment", Source: CryptoSourceInPackage, Origin: "blake3.go"})
+	RegisterHandshakeHasher(NewHandshakeHasher("BLAKE3", newBLAKE3))
+}
+
+const (
+	blake3Size      = 32
+	blake3BlockSize = 64
+	blake3ChunkSize = 1024
+
+	blake3ChunkStart = 1 << 0
+	blake3ChunkEnd   = 1 << 1
+	blake3Parent     = 1 << 2
+	blake3Root       = 1 << 3
+)
+
+var blake3IV = [8]uint32{0x6a09e667, 0xbb67ae85, 0x3c6ef372, 0xa54ff53a, 0x510e527f, 0x9b05688c, 0x1f83d9ab, 0x5be0cd19}
+
+var blake3Permutation = [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+
+func blake3G(s *[16]uint32, a, b, c, d int, mx, my uint32) {
+	s[a] += s[b] + mx
+	s[d] = bits.RotateLeft32(s[d]^s[a], -16)
+	s[c] += s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -12)
+	s[a] += s[b] + my
+	s[d] = bits.RotateLeft32(s[d]^s[a], -8)
+	s[c] += s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -7)
+}
+
+// blake3Compress returns the state of compressing block into the chaining
+// value cv: its first eight words are the next chaining value.
+func blake3Compress(cv *[8]uint32, block *[16]uint32, counter uint64, blockLen, flags uint32) [16]uint32 {
+	s := [16]uint32{
+		cv[0], cv[1], cv[2], cv[3], cv[4], cv[5], cv[6], cv[7],
+		blake3IV[0], blake3IV[1], blake3IV[2], blake3IV[3],
+		uint32(counter), uint32(counter >> 32), blockLen, flags,
+	}
+	m := *block
+	for round := range 7 {
+		blake3G(&s, 0, 4, 8, 12, m[0], m[1])
+		blake3G(&s, 1, 5, 9, 13, m[2], m[3])
+		blake3G(&s, 2, 6, 10, 14, m[4], m[5])
+		blake3G(&s, 3, 7, 11, 15, m[6], m[7])
+		blake3G(&s, 0, 5, 10, 15, m[8], m[9])
+		blake3G(&s, 1, 6, 11, 12, m[10], m[11])
+		blake3G(&s, 2, 7, 8, 13, m[12], m[13])
+		blake3G(&s, 3, 4, 9, 14, m[14], m[15])
+		if round < 6 {
+			var permuted [16]uint32
+			for i, j := range blake3Permutation {
+				permuted[i] = m[j]
+			}
+			m = permuted
+		}
+	}
+	for i := range 8 {
+		s[i] ^= s[i+8]
+		s[i+8] ^= cv[i]
+	}
+	return s
+}
+
+// A blake3Output is a node of the tree, compressed into a chaining value
+// unless it is the root.
+type blake3Output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o *blake3Output) chainingValue() (cv [8]uint32) {
+	s := blake3Compress(&o.cv, &o.block, o.counter, o.blockLen, o.flags)
+	copy(cv[:], s[:8])
+	return
+}
+
+func (o *blake3Output) root(dst []byte) []byte {
+	s := blake3Compress(&o.cv, &o.block, 0, o.blockLen, o.flags|blake3Root)
+	for _, w := range s[:8] {
+		dst = binary.LittleEndian.AppendUint32(dst, w)
+	}
+	return dst
+}
+
+func blake3ParentOutput(left, right *[8]uint32) *blake3Output {
+	o := &blake3Output{cv: blake3IV, blockLen: blake3BlockSize, flags: blake3Parent}
+	copy(o.block[:8], left[:])
+	copy(o.block[8:], right[:])
+	return o
+}
+
+// A blake3Chunk is the state of the chunk being hashed.
+type blake3Chunk struct {
+	cv         [8]uint32
+	counter    uint64 // of the chunk
+	block      [blake3BlockSize]byte
+	blockLen   int
+	compressed int // blocks
+}
+
+func (c *blake3Chunk) reset(counter uint64) {
+	*c = blake3Chunk{cv: blake3IV, counter: counter}
+}
+
+func (c *blake3Chunk) len() int {
+	return c.compressed*blake3BlockSize + c.blockLen
+}
+
+func (c *blake3Chunk) startFlag() uint32 {
+	if c.compressed == 0 {
+		return blake3ChunkStart
+	}
+	return 0
+}
+
+func (c *blake3Chunk) blockWords() (words [16]uint32) {
+	for i := range words {
+		words[i] = binary.LittleEndian.Uint32(c.block[i*4:])
+	}
+	return
+}
+
+// write takes input up to the end of the chunk, keeping its last block,
+// which may be the end of the chunk, uncompressed.
+func (c *blake3Chunk) write(input []byte) {
+	for len(input) > 0 {
+		if c.blockLen == blake3BlockSize {
+			words := c.blockWords()
+			s := blake3Compress(&c.cv, &words, c.counter, blake3BlockSize, c.startFlag())
+			copy(c.cv[:], s[:8])
+			c.compressed++
+			c.block = [blake3BlockSize]byte{}
+			c.blockLen = 0
+		}
+		n := copy(c.block[c.blockLen:], input)
+		c.blockLen += n
+		input = input[n:]
+	}
+}
+
+func (c *blake3Chunk) output() *blake3Output {
+	return &blake3Output{
+		cv:       c.cv,
+		block:    c.blockWords(),
+		counter:  c.counter,
+		blockLen: uint32(c.blockLen),
+		flags:    c.startFlag() | blake3ChunkEnd,
+	}
+}
+
+// A blake3Hash is a BLAKE3 hash.Hash.
+type blake3Hash struct {
+	chunk blake3Chunk
+	stack [][8]uint32 // chaining values of the subtrees of whole chunks so far
+}
+
+func newBLAKE3() hash.Hash {
+	h := new(blake3Hash)
+	h.Reset()
+	return h
+}
+
+func (h *blake3Hash) Size() int      { return blake3Size }
+func (h *blake3Hash) BlockSize() int { return blake3BlockSize }
+
+func (h *blake3Hash) Reset() {
+	h.chunk.reset(0)
+	h.stack = h.stack[:0]
+}
+
+func (h *blake3Hash) Write(input []byte) (int, error) {
+	n := len(input)
+	for len(input) > 0 {
+		// A full chunk joins the tree only once more input shows it is
+		// not the root.
+		if h.chunk.len() == blake3ChunkSize {
+			cv := h.chunk.output().chainingValue()
+			total := h.chunk.counter + 1
+			for total&1 == 0 {
+				cv = blake3ParentOutput(&h.stack[len(h.stack)-1], &cv).chainingValue()
+				h.stack = h.stack[:len(h.stack)-1]
+				total >>= 1
+			}
+			h.stack = append(h.stack, cv)
+			h.chunk.reset(h.chunk.counter + 1)
+		}
+		take := min(blake3ChunkSize-h.chunk.len(), len(input))
+		h.chunk.write(input[:take])
+		input = input[take:]
+	}
+	return n, nil
+}
+
+func (h *blake3Hash) Sum(b []byte) []byte {
+	o := h.chunk.output()
+	for i := len(h.stack) - 1; i >= 0; i-- {
+		cv := o.chainingValue()
+		o = blake3ParentOutput(&h.stack[i], &cv)
+	}
+	return o.root(b)
+}
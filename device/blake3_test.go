@@ -0,0 +1,64 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// Vectors from the BLAKE3 reference test_vectors.json, of inputs whose byte
+// i is i%251.
+var blake3Vectors = []struct {
+	length int
+	hash   string
+}{
+	{0, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+	{1, "2d3adedff11b61f14c886e35afa036736dcd87a74d27b5c1510225d0f592e213"},
+	{1024, "42214739f095a406f3fc83deb889744ac00df831c10daa55189b5d121c855af7"},
+	{1025, "d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444"},
+	{2048, "e776b6028c7cd22a4d0ba182a8bf62205d2ef576467e838ed6f2529b85fba24a"},
+	{102400, "bc3e3d41a1146b069abffad3c0d44860cf664390afce4d9661f7902e7943e085"},
+}
+
+func TestBLAKE3(t *testing.T) {
+	for _, v := range blake3Vectors {
+		input := make([]byte, v.length)
+		for i := range input {
+			input[i] = byte(i % 251)
+		}
+		h := newBLAKE3()
+		// In uneven pieces, across block and chunk boundaries.
+		for rest := input; len(rest) > 0; {
+			n := min(len(rest), 1+len(rest)%97)
+			h.Write(rest[:n])
+			rest = rest[n:]
+		}
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != v.hash {
+			t.Errorf("%d bytes: %s, want %s", v.length, sum, v.hash)
+		}
+		h.Reset()
+		h.Write(input)
+		if sum := hex.EncodeToString(h.Sum(nil)); sum != v.hash {
+			t.Errorf("%d bytes after reset: %s, want %s", v.length, sum, v.hash)
+		}
+	}
+}
+
+func TestBLAKE3Handshake(t *testing.T) {
+	pair := genTestPair(t, false)
+	for i := range pair {
+		assertNil(t, pair[i].dev.IpcSet("handshake_hash=BLAKE3\n"))
+		pair[i].dev.LookupPeer(pair[1-i].dev.staticIdentity.publicKey).ExpireCurrentKeypairs()
+	}
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+	if name := pair[0].dev.HandshakeHash(); name != "BLAKE3" {
+		t.Errorf("handshake hash %s", name)
+	}
+}
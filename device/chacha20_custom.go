@@ -1,43 +1,103 @@
+//go:build wg_experimental
+
 // Package device provides a custom ChaCha20 implementation with 24 rounds and a 16-byte nonce for experimentation.
 package device
 
 import (
+	"crypto/subtle"
 	"encoding/binary"
-	"fmt"
+	"errors"
+	"math/bits"
 )
 
 const (
-	chachaRounds = 24
+	chachaRounds  = 24
 	chachaKeySize = 32
-	chachaNonceSize = 16
 )
 
-// quarterRound is the ChaCha20 quarter round function.
+func init() {
+	registerCryptoPrimitive(CryptoPrimitive{Name: "ChaCha20_24", Variant: "128-bit nonce, modified quarter round", Rounds: chachaRounds, Use: "experimental", Source: CryptoSourceInPackage, Origin: "chacha20_custom.go"})
+}
+
+// ChaCha20x24Increment is what the modified quarter round of ChaCha20_24
+// adds to d after its first rotation, which standard ChaCha20 does not.
+const ChaCha20x24Increment = 1
+
+// chacha20x24Rotations are the left rotations of the four steps of the
+// modified quarter round of ChaCha20_24.
+var chacha20x24Rotations = [4]int{10, 14, 6, 9}
+
+// ChaCha20x24Rotations returns the left rotations of the four steps of the
+// modified quarter round of ChaCha20_24, where standard ChaCha20 rotates by
+// 16, 12, 8 and 7.
+func ChaCha20x24Rotations() [4]int {
+	return chacha20x24Rotations
+}
+
+// ChaChaQuarterRound is the quarter round of ChaCha on the words a, b, c
+// and d of x, with the given left rotations of its four steps and increment
+// added to d after the first. It is exported for the analyses of the
+// chachamargin and cryptanalysis packages, so that they evaluate the very
+// quarter round that ChaCha20_24 uses.
+func ChaChaQuarterRound(x *[16]uint32, a, b, c, d int, rotations *[4]int, increment uint32) {
+	xa, xb, xc, xd := x[a], x[b], x[c], x[d]
+
+	xa += xb
+	xd = bits.RotateLeft32(xd^xa, rotations[0]) + increment
+
+	xc += xd
+	xb = bits.RotateLeft32(xb^xc, rotations[1])
+
+	xa += xb
+	xd = bits.RotateLeft32(xd^xa, rotations[2])
+
+	xc += xd
+	xb = bits.RotateLeft32(xb^xc, rotations[3])
+
+	x[a], x[b], x[c], x[d] = xa, xb, xc, xd
+}
+
+// quarterRound is the modified quarter round of ChaCha20_24.
 func quarterRound(x *[16]uint32, a, b, c, d int) {
-	x[a] += x[b]
-	x[d] ^= x[a]
-	x[d] = (x[d] << 10) | (x[d] >> (32 - 10))
-	x[d] += 1
-
-	x[c] += x[d]
-	x[b] ^= x[c]
-	x[b] = (x[b] << 14) | (x[b] >> (32 - 14))
-
-	x[a] += x[b]
-	x[d] ^= x[a]
-	x[d] = (x[d] << 6) | (x[d] >> (32 - 6))
-
-	x[c] += x[d]
-	x[b] ^= x[c]
-	x[b] = (x[b] << 9) | (x[b] >> (32 - 9))
+	ChaChaQuarterRound(x, a, b, c, d, &chacha20x24Rotations, ChaCha20x24Increment)
 }
 
-// chachaBlock24 produces a 64-byte keystream block using 24 rounds and a 16-byte nonce.
 func chachaBlock24(key *[32]byte, nonce *[16]byte, counter uint32, out *[64]byte) {
-	if len(nonce) != 16 {
-		panic(fmt.Sprintf("nonce must be 16 bytes, got %d", len(nonce)))
+	chachaBlockN(chachaRounds, key, nonce, counter, out)
+}
+
+// chachaBlockN is chachaBlock24 with the given even number of rounds.
+func chachaBlockN(rounds int, key *[32]byte, nonce *[16]byte, counter uint32, out *[64]byte) {
+	chachaBlockNTraced(rounds, key, nonce, counter, out, nil)
+}
+
+// chachaBlocksNx4Generic produces the four keystream blocks of rounds
+// rounds from counter on, which must not wrap.
+func chachaBlocksNx4Generic(rounds int, key *[32]byte, nonce *[16]byte, counter uint32, out *[4 * 64]byte) {
+	for i := range 4 {
+		chachaBlockN(rounds, key, nonce, counter+uint32(i), (*[64]byte)(out[i*64:]))
+	}
+}
+
+// A chachaTrace records the state matrices of a block as its rounds go.
+type chachaTrace struct {
+	states [][16]uint32
+	n      int // states recorded, or that would have been with room for them
+}
+
+func (t *chachaTrace) record(x *[16]uint32) {
+	if t == nil {
+		return
 	}
-	fmt.Printf("DEBUG: nonce len: %d\n", len(nonce))
+	if t.n < len(t.states) {
+		t.states[t.n] = *x
+	}
+	t.n++
+}
+
+// chachaBlockNTraced is chachaBlockN, recording the state before the
+// first round and after each round into trace in builds with chachaTracing.
+func chachaBlockNTraced(rounds int, key *[32]byte, nonce *[16]byte, counter uint32, out *[64]byte, trace *chachaTrace) {
 	var x [16]uint32
 	// Constants
 	x[0] = 0x61707865
@@ -50,28 +110,31 @@ func chachaBlock24(key *[32]byte, nonce *[16]byte, counter uint32, out *[64]byte
 	}
 	// 16-byte nonce (mapped to x[11] through x[14])
 	for i := 0; i < 4; i++ {
-		start := i * 4
-		end := (i + 1) * 4
-		if end > len(nonce) {
-			panic(fmt.Sprintf("nonce slice out of bounds: start=%d end=%d len=%d", start, end, len(nonce)))
-		}
-		fmt.Printf("DEBUG: nonce[%d:%d] (len=%d)\n", start, end, len(nonce))
-		x[11+i] = binary.LittleEndian.Uint32(nonce[start:end])
+		x[11+i] = binary.LittleEndian.Uint32(nonce[i*4:])
 	}
 	// Counter (mapped to x[15])
 	x[15] = counter
 	orig := x
-	for i := 0; i < chachaRounds; i += 2 {
+	if chachaTracing {
+		trace.record(&x)
+	}
+	for i := 0; i < rounds; i += 2 {
 		// Column rounds
 		quarterRound(&x, 0, 4, 8, 12)
 		quarterRound(&x, 1, 5, 9, 13)
 		quarterRound(&x, 2, 6, 10, 14)
 		quarterRound(&x, 3, 7, 11, 15)
+		if chachaTracing {
+			trace.record(&x)
+		}
 		// Diagonal rounds
 		quarterRound(&x, 0, 5, 10, 15)
 		quarterRound(&x, 1, 6, 11, 12)
 		quarterRound(&x, 2, 7, 8, 13)
 		quarterRound(&x, 3, 4, 9, 14)
+		if chachaTracing {
+			trace.record(&x)
+		}
 	}
 	for i := 0; i < 16; i++ {
 		x[i] += orig[i]
@@ -79,20 +142,91 @@ func chachaBlock24(key *[32]byte, nonce *[16]byte, counter uint32, out *[64]byte
 	}
 }
 
-// EncryptChaCha20_24 encrypts plaintext using ChaCha20 with 24 rounds and a 16-byte nonce.
-func EncryptChaCha20_24(key *[32]byte, nonce *[16]byte, counter uint32, plaintext []byte) []byte {
-	var block [64]byte
-	ciphertext := make([]byte, len(plaintext))
-	for i := 0; i < len(plaintext); i += 64 {
-		chachaBlock24(key, nonce, counter, &block)
-		blockSize := 64
-		if len(plaintext)-i < 64 {
-			blockSize = len(plaintext) - i
+// A ChaCha20x24Cipher is a ChaCha20_24 keystream, used like the
+// unauthenticated ciphers of golang.org/x/crypto/chacha20: it implements
+// cipher.Stream, and keeps the rest of a block for the next call when one
+// ends within it.
+type ChaCha20x24Cipher struct {
+	key      [chachaKeySize]byte
+	nonce    [chachaNonceSize]byte
+	rounds   int    // of the block function
+	counter  uint32 // of the next block to generate
+	overflow bool   // the counter wrapped
+	buf      [64]byte
+	len      int // bytes at the end of buf not used yet
+}
+
+// NewChaCha20x24Cipher returns a ChaCha20_24 keystream with the given 32-byte
+// key and 16-byte nonce, starting at block 0.
+func NewChaCha20x24Cipher(key, nonce []byte) (*ChaCha20x24Cipher, error) {
+	return NewChaCha20x24CipherRounds(key, nonce, chachaRounds)
+}
+
+// NewChaCha20x24CipherRounds is NewChaCha20x24Cipher with the given number
+// of rounds in place of 24, which must be even and positive, for measuring
+// how the round count trades security margin for speed.
+func NewChaCha20x24CipherRounds(key, nonce []byte, rounds int) (*ChaCha20x24Cipher, error) {
+	if rounds <= 0 || rounds%2 != 0 {
+		return nil, errors.New("chacha20x24: rounds must be even and positive")
+	}
+	if len(key) != chachaKeySize {
+		return nil, errors.New("chacha20x24: wrong key size")
+	}
+	if len(nonce) != chachaNonceSize {
+		return nil, errors.New("chacha20x24: wrong nonce size")
+	}
+	c := &ChaCha20x24Cipher{rounds: rounds}
+	copy(c.key[:], key)
+	copy(c.nonce[:], nonce)
+	return c, nil
+}
+
+// SetCounter makes the next call of XORKeyStream continue the keystream
+// from block counter, as if 64 times counter bytes had been XORed so far.
+// As in golang.org/x/crypto/chacha20, it panics if counter is less than that
+// of the next block not yet begun, to prevent keystream reuse.
+func (c *ChaCha20x24Cipher) SetCounter(counter uint32) {
+	if c.overflow || counter < c.counter {
+		panic("chacha20x24: SetCounter attempted to rollback counter")
+	}
+	c.counter, c.len = counter, 0
+}
+
+// XORKeyStream XORs each byte of src with a byte of the keystream into dst.
+// Dst and src must overlap entirely or not at all. It panics if dst is
+// shorter than src, or if the keystream would run past block 2^32-1.
+func (c *ChaCha20x24Cipher) XORKeyStream(dst, src []byte) {
+	if len(src) == 0 {
+		return
+	}
+	if len(dst) < len(src) {
+		panic("chacha20x24: output smaller than input")
+	}
+	if c.len > 0 {
+		n := subtle.XORBytes(dst, src, c.buf[len(c.buf)-c.len:])
+		c.len -= n
+		dst, src = dst[n:], src[n:]
+	}
+	for len(src) > 0 {
+		if c.overflow {
+			panic("chacha20x24: counter overflow")
 		}
-		for j := 0; j < blockSize; j++ {
-			ciphertext[i+j] = plaintext[i+j] ^ block[j]
+		// With NEON, four blocks at a time while src takes them whole and
+		// the counter does not wrap within them.
+		if chachaHasNEON && len(src) >= 4*64 && c.counter <= 1<<32-4 {
+			var blocks [4 * 64]byte
+			chachaBlocksNx4(c.rounds, &c.key, &c.nonce, c.counter, &blocks)
+			c.counter += 4
+			c.overflow = c.counter == 0
+			subtle.XORBytes(dst, src[:len(blocks)], blocks[:])
+			dst, src = dst[len(blocks):], src[len(blocks):]
+			continue
 		}
-		counter++
+		chachaBlockN(c.rounds, &c.key, &c.nonce, c.counter, &c.buf)
+		c.counter++
+		c.overflow = c.counter == 0
+		n := subtle.XORBytes(dst, src, c.buf[:])
+		c.len = len(c.buf) - n
+		dst, src = dst[n:], src[n:]
 	}
-	return ciphertext
-} 
\ No newline at end of file
+}
@@ -0,0 +1,27 @@
+//go:build wg_experimental && arm64 && !purego
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import "golang.org/x/sys/cpu"
+
+// chachaHasNEON selects the four-block keystream of ChaCha20_24.
+var chachaHasNEON = cpu.ARM64.HasASIMD
+
+// chachaBlocksNx4NEON is chachaBlocksNx4Generic in NEON assembly, with
+// each block in a lane of the vector registers.
+//
+//go:noescape
+func chachaBlocksNx4NEON(rounds int, key *[32]byte, nonce *[16]byte, counter uint32, out *[4 * 64]byte)
+
+func chachaBlocksNx4(rounds int, key *[32]byte, nonce *[16]byte, counter uint32, out *[4 * 64]byte) {
+	if chachaHasNEON {
+		chachaBlocksNx4NEON(rounds, key, nonce, counter, out)
+		return
+	}
+	chachaBlocksNx4Generic(rounds, key, nonce, counter, out)
+}
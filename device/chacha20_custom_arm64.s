@@ -0,0 +1,200 @@
+//go:build wg_experimental && arm64 && !purego
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+#include "textflag.h"
+
+// Lane k of each of V0-V15 holds a state word of block k; V16-V19 are
+// scratch for the rotations; V30 holds the counter offsets of the blocks and
+// V31 the ones the modified quarter round adds.
+
+DATA chachaCounterOffsets<>+0x00(SB)/4, $0
+DATA chachaCounterOffsets<>+0x04(SB)/4, $1
+DATA chachaCounterOffsets<>+0x08(SB)/4, $2
+DATA chachaCounterOffsets<>+0x0c(SB)/4, $3
+GLOBL chachaCounterOffsets<>(SB), RODATA|NOPTR, $16
+
+// ROTL sets d to t rotated left by n bits.
+#define ROTL(n, t, d) \
+	VSHL $n, t.S4, d.S4     \
+	VSRI $(32-n), t.S4, d.S4
+
+// QUARTERROUND4 is quarterRound on four sets of state words at once, of
+// all four blocks.
+#define QUARTERROUND4(a0, b0, c0, d0, a1, b1, c1, d1, a2, b2, c2, d2, a3, b3, c3, d3) \
+	VADD  b0.S4, a0.S4, a0.S4    \
+	VADD  b1.S4, a1.S4, a1.S4    \
+	VADD  b2.S4, a2.S4, a2.S4    \
+	VADD  b3.S4, a3.S4, a3.S4    \
+	VEOR  a0.B16, d0.B16, V16.B16 \
+	VEOR  a1.B16, d1.B16, V17.B16 \
+	VEOR  a2.B16, d2.B16, V18.B16 \
+	VEOR  a3.B16, d3.B16, V19.B16 \
+	ROTL(10, V16, d0)            \
+	ROTL(10, V17, d1)            \
+	ROTL(10, V18, d2)            \
+	ROTL(10, V19, d3)            \
+	VADD  V31.S4, d0.S4, d0.S4   \
+	VADD  V31.S4, d1.S4, d1.S4   \
+	VADD  V31.S4, d2.S4, d2.S4   \
+	VADD  V31.S4, d3.S4, d3.S4   \
+	VADD  d0.S4, c0.S4, c0.S4    \
+	VADD  d1.S4, c1.S4, c1.S4    \
+	VADD  d2.S4, c2.S4, c2.S4    \
+	VADD  d3.S4, c3.S4, c3.S4    \
+	VEOR  c0.B16, b0.B16, V16.B16 \
+	VEOR  c1.B16, b1.B16, V17.B16 \
+	VEOR  c2.B16, b2.B16, V18.B16 \
+	VEOR  c3.B16, b3.B16, V19.B16 \
+	ROTL(14, V16, b0)            \
+	ROTL(14, V17, b1)            \
+	ROTL(14, V18, b2)            \
+	ROTL(14, V19, b3)            \
+	VADD  b0.S4, a0.S4, a0.S4    \
+	VADD  b1.S4, a1.S4, a1.S4    \
+	VADD  b2.S4, a2.S4, a2.S4    \
+	VADD  b3.S4, a3.S4, a3.S4    \
+	VEOR  a0.B16, d0.B16, V16.B16 \
+	VEOR  a1.B16, d1.B16, V17.B16 \
+	VEOR  a2.B16, d2.B16, V18.B16 \
+	VEOR  a3.B16, d3.B16, V19.B16 \
+	ROTL(6, V16, d0)             \
+	ROTL(6, V17, d1)             \
+	ROTL(6, V18, d2)             \
+	ROTL(6, V19, d3)             \
+	VADD  d0.S4, c0.S4, c0.S4    \
+	VADD  d1.S4, c1.S4, c1.S4    \
+	VADD  d2.S4, c2.S4, c2.S4    \
+	VADD  d3.S4, c3.S4, c3.S4    \
+	VEOR  c0.B16, b0.B16, V16.B16 \
+	VEOR  c1.B16, b1.B16, V17.B16 \
+	VEOR  c2.B16, b2.B16, V18.B16 \
+	VEOR  c3.B16, b3.B16, V19.B16 \
+	ROTL(9, V16, b0)             \
+	ROTL(9, V17, b1)             \
+	ROTL(9, V18, b2)             \
+	ROTL(9, V19, b3)
+
+// LOADSTATE broadcasts the initial state words but the counter into
+// v0-v14, and the counter into R2.
+#define LOADSTATE(v0, v1, v2, v3, v4, v5, v6, v7, v8, v9, v10, v11, v12, v13, v14) \
+	MOVW  $0x61707865, R4 \
+	VDUP  R4, v0.S4       \
+	MOVW  $0x3320646e, R4 \
+	VDUP  R4, v1.S4       \
+	MOVW  $0x79622d32, R4 \
+	VDUP  R4, v2.S4       \
+	MOVW  $0x6b206574, R4 \
+	VDUP  R4, v3.S4       \
+	MOVWU 0(R0), R4       \
+	VDUP  R4, v4.S4       \
+	MOVWU 4(R0), R4       \
+	VDUP  R4, v5.S4       \
+	MOVWU 8(R0), R4       \
+	VDUP  R4, v6.S4       \
+	MOVWU 12(R0), R4      \
+	VDUP  R4, v7.S4       \
+	MOVWU 16(R0), R4      \
+	VDUP  R4, v8.S4       \
+	MOVWU 20(R0), R4      \
+	VDUP  R4, v9.S4       \
+	MOVWU 24(R0), R4      \
+	VDUP  R4, v10.S4      \
+	MOVWU 0(R1), R4       \
+	VDUP  R4, v11.S4      \
+	MOVWU 4(R1), R4       \
+	VDUP  R4, v12.S4      \
+	MOVWU 8(R1), R4       \
+	VDUP  R4, v13.S4      \
+	MOVWU 12(R1), R4      \
+	VDUP  R4, v14.S4
+
+// func chachaBlocksNx4NEON(rounds int, key *[32]byte, nonce *[16]byte, counter uint32, out *[4 * 64]byte)
+TEXT ·chachaBlocksNx4NEON(SB), NOSPLIT, $0-40
+	MOVD  rounds+0(FP), R5
+	MOVD  key+8(FP), R0
+	MOVD  nonce+16(FP), R1
+	MOVWU counter+24(FP), R2
+	MOVD  out+32(FP), R3
+
+	MOVD $chachaCounterOffsets<>(SB), R4
+	VLD1 (R4), [V30.S4]
+	MOVW $1, R4
+	VDUP R4, V31.S4
+
+	LOADSTATE(V0, V1, V2, V3, V4, V5, V6, V7, V8, V9, V10, V11, V12, V13, V14)
+	VDUP R2, V15.S4
+	VADD V30.S4, V15.S4, V15.S4
+
+	// R5 counts down the double rounds.
+	LSR $1, R5
+
+rounds:
+	QUARTERROUND4(V0, V4, V8, V12, V1, V5, V9, V13, V2, V6, V10, V14, V3, V7, V11, V15)
+	QUARTERROUND4(V0, V5, V10, V15, V1, V6, V11, V12, V2, V7, V8, V13, V3, V4, V9, V14)
+	SUB  $1, R5
+	CBNZ R5, rounds
+
+	// Add the initial state back, in V16-V31.
+	VDUP R2, V28.S4
+	VADD V30.S4, V28.S4, V28.S4
+	LOADSTATE(V16, V17, V18, V19, V20, V21, V22, V23, V24, V25, V26, V27, V29, V30, V31)
+	VADD V16.S4, V0.S4, V0.S4
+	VADD V17.S4, V1.S4, V1.S4
+	VADD V18.S4, V2.S4, V2.S4
+	VADD V19.S4, V3.S4, V3.S4
+	VADD V20.S4, V4.S4, V4.S4
+	VADD V21.S4, V5.S4, V5.S4
+	VADD V22.S4, V6.S4, V6.S4
+	VADD V23.S4, V7.S4, V7.S4
+	VADD V24.S4, V8.S4, V8.S4
+	VADD V25.S4, V9.S4, V9.S4
+	VADD V26.S4, V10.S4, V10.S4
+	VADD V27.S4, V11.S4, V11.S4
+	VADD V29.S4, V12.S4, V12.S4
+	VADD V30.S4, V13.S4, V13.S4
+	VADD V31.S4, V14.S4, V14.S4
+	VADD V28.S4, V15.S4, V15.S4
+
+	// Transpose, so that V4k-V4k+3 hold block k.
+	VZIP1 V1.S4, V0.S4, V16.S4
+	VZIP2 V1.S4, V0.S4, V17.S4
+	VZIP1 V3.S4, V2.S4, V18.S4
+	VZIP2 V3.S4, V2.S4, V19.S4
+	VZIP1 V5.S4, V4.S4, V20.S4
+	VZIP2 V5.S4, V4.S4, V21.S4
+	VZIP1 V7.S4, V6.S4, V22.S4
+	VZIP2 V7.S4, V6.S4, V23.S4
+	VZIP1 V9.S4, V8.S4, V24.S4
+	VZIP2 V9.S4, V8.S4, V25.S4
+	VZIP1 V11.S4, V10.S4, V26.S4
+	VZIP2 V11.S4, V10.S4, V27.S4
+	VZIP1 V13.S4, V12.S4, V28.S4
+	VZIP2 V13.S4, V12.S4, V29.S4
+	VZIP1 V15.S4, V14.S4, V30.S4
+	VZIP2 V15.S4, V14.S4, V31.S4
+	VZIP1 V18.D2, V16.D2, V0.D2
+	VZIP2 V18.D2, V16.D2, V4.D2
+	VZIP1 V19.D2, V17.D2, V8.D2
+	VZIP2 V19.D2, V17.D2, V12.D2
+	VZIP1 V22.D2, V20.D2, V1.D2
+	VZIP2 V22.D2, V20.D2, V5.D2
+	VZIP1 V23.D2, V21.D2, V9.D2
+	VZIP2 V23.D2, V21.D2, V13.D2
+	VZIP1 V26.D2, V24.D2, V2.D2
+	VZIP2 V26.D2, V24.D2, V6.D2
+	VZIP1 V27.D2, V25.D2, V10.D2
+	VZIP2 V27.D2, V25.D2, V14.D2
+	VZIP1 V30.D2, V28.D2, V3.D2
+	VZIP2 V30.D2, V28.D2, V7.D2
+	VZIP1 V31.D2, V29.D2, V11.D2
+	VZIP2 V31.D2, V29.D2, V15.D2
+
+	VST1.P [V0.B16, V1.B16, V2.B16, V3.B16], 64(R3)
+	VST1.P [V4.B16, V5.B16, V6.B16, V7.B16], 64(R3)
+	VST1.P [V8.B16, V9.B16, V10.B16, V11.B16], 64(R3)
+	VST1   [V12.B16, V13.B16, V14.B16, V15.B16], (R3)
+	RET
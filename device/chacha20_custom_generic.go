@@ -0,0 +1,15 @@
+//go:build wg_experimental && (!arm64 || purego)
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+// chachaHasNEON selects the four-block keystream of ChaCha20_24.
+const chachaHasNEON = false
+
+func chachaBlocksNx4(rounds int, key *[32]byte, nonce *[16]byte, counter uint32, out *[4 * 64]byte) {
+	chachaBlocksNx4Generic(rounds, key, nonce, counter, out)
+}
@@ -1,11 +1,14 @@
+//go:build wg_experimental
+
 package device
 
 import (
+	"bytes"
 	"crypto/rand"
+	"crypto/subtle"
 	"fmt"
-	"testing"
 	"golang.org/x/crypto/chacha20"
-	"time"
+	"testing"
 )
 
 func TestCustomChaCha20_24_vs_Standard(t *testing.T) {
@@ -27,27 +30,13 @@ func TestCustomChaCha20_24_vs_Standard(t *testing.T) {
 	stdCipher.SetCounter(0)
 	stdCipher.XORKeyStream(stdOut, plaintext[:])
 
-	iters := 10000
-	startStd := time.Now()
-	for i := 0; i < iters; i++ {
-		stdCipher, _ := chacha20.NewUnauthenticatedCipher(key[:], nonce12[:])
-		stdCipher.SetCounter(0)
-		stdCipher.XORKeyStream(stdOut, plaintext[:])
-	}
-	elapsedStd := time.Since(startStd)
-
 	// Custom 24-round, 16-byte nonce
-	customOut := EncryptChaCha20_24(&key, &nonce16, 0, plaintext[:])
-	startCustom := time.Now()
-	for i := 0; i < iters; i++ {
-		_ = EncryptChaCha20_24(&key, &nonce16, 0, plaintext[:])
+	customOut := make([]byte, len(plaintext))
+	customCipher, err := NewChaCha20x24Cipher(key[:], nonce16[:])
+	if err != nil {
+		t.Fatalf("Failed to create custom chacha20 cipher: %v", err)
 	}
-	elapsedCustom := time.Since(startCustom)
-
-	fmt.Printf("Standard ChaCha20 (20 rounds, 12-byte nonce) output: %x...\n", stdOut[:16])
-	fmt.Printf("Custom ChaCha20 (24 rounds, 16-byte nonce) output: %x...\n", customOut[:16])
-	fmt.Printf("Standard ChaCha20 time: %v for %d iterations\n", elapsedStd, iters)
-	fmt.Printf("Custom ChaCha20_24 time: %v for %d iterations\n", elapsedCustom, iters)
+	customCipher.XORKeyStream(customOut, plaintext[:])
 
 	// Academic comparison: Ensure outputs are different
 	if len(stdOut) != len(customOut) {
@@ -59,14 +48,193 @@ func TestCustomChaCha20_24_vs_Standard(t *testing.T) {
 }
 
 func TestSimpleCustomChaCha20_24(t *testing.T) {
-	key := [32]byte{1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16,17,18,19,20,21,22,23,24,25,26,27,28,29,30,31,32}
-	nonce := [16]byte{101,102,103,104,105,106,107,108,109,110,111,112,113,114,115,116}
+	key := [32]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32}
+	nonce := [16]byte{101, 102, 103, 104, 105, 106, 107, 108, 109, 110, 111, 112, 113, 114, 115, 116}
 	plaintext := []byte("hello world")
 
-	ciphertext := EncryptChaCha20_24(&key, &nonce, 0, plaintext)
-	decrypted := EncryptChaCha20_24(&key, &nonce, 0, ciphertext)
+	ciphertext := make([]byte, len(plaintext))
+	c, _ := NewChaCha20x24Cipher(key[:], nonce[:])
+	c.XORKeyStream(ciphertext, plaintext)
+	decrypted := make([]byte, len(ciphertext))
+	c, _ = NewChaCha20x24Cipher(key[:], nonce[:])
+	c.XORKeyStream(decrypted, ciphertext)
 
 	if string(decrypted) != string(plaintext) {
 		t.Fatalf("decrypted text does not match original: got %q, want %q", decrypted, plaintext)
 	}
-} 
\ No newline at end of file
+}
+
+func TestChaCha20x24Cipher(t *testing.T) {
+	key := make([]byte, chachaKeySize)
+	nonce := make([]byte, chachaNonceSize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	nonce[3] = 9
+	const counter = 5
+	src := make([]byte, 1000)
+	for i := range src {
+		src[i] = byte(i * 7)
+	}
+
+	// The keystream is that of the block function from the counter on.
+	want := make([]byte, len(src))
+	var block [64]byte
+	for i := range want {
+		if i%64 == 0 {
+			chachaBlock24((*[32]byte)(key), (*[16]byte)(nonce), counter+uint32(i/64), &block)
+		}
+		want[i] = src[i] ^ block[i%64]
+	}
+	whole, _ := NewChaCha20x24Cipher(key, nonce)
+	whole.SetCounter(counter)
+	got := make([]byte, len(src))
+	whole.XORKeyStream(got, src)
+	if !bytes.Equal(got, want) {
+		t.Fatal("keystream differs from the block function")
+	}
+
+	// Calls ending within a block continue where the last one left off,
+	// also in place.
+	for _, chunk := range []int{1, 7, 63, 64, 65, 200} {
+		c, _ := NewChaCha20x24Cipher(key, nonce)
+		c.SetCounter(counter)
+		got := append([]byte(nil), src...)
+		for i := 0; i < len(got); i += chunk {
+			end := min(i+chunk, len(got))
+			c.XORKeyStream(got[i:end], got[i:end])
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("keystream in chunks of %d differs", chunk)
+		}
+	}
+
+	// Skipping ahead is allowed, going back is not.
+	c, _ := NewChaCha20x24Cipher(key, nonce)
+	c.XORKeyStream(got[:10], src[:10])
+	c.SetCounter(counter)
+	c.XORKeyStream(got[:64], src[:64])
+	if !bytes.Equal(got[:64], want[:64]) {
+		t.Error("keystream after SetCounter differs")
+	}
+	for name, f := range map[string]func(){
+		"rollback":      func() { c.SetCounter(counter) },
+		"short output":  func() { c.XORKeyStream(got[:1], src[:2]) },
+		"counter wraps": func() { c.SetCounter(1<<32 - 1); c.XORKeyStream(got[:65], src[:65]) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s did not panic", name)
+				}
+			}()
+			f()
+		}()
+	}
+
+	if _, err := NewChaCha20x24Cipher(key[:16], nonce); err == nil {
+		t.Error("short key accepted")
+	}
+	if _, err := NewChaCha20x24Cipher(key, nonce[:12]); err == nil {
+		t.Error("12-byte nonce accepted")
+	}
+
+	// Other round counts give other keystreams, those of chachaBlockN.
+	for _, rounds := range []int{2, 8, 12, 20, chachaRounds} {
+		c, err := NewChaCha20x24CipherRounds(key, nonce, rounds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetCounter(counter)
+		c.XORKeyStream(got, src)
+		if rounds != chachaRounds && bytes.Equal(got, want) {
+			t.Errorf("keystream of %d rounds equal to that of %d", rounds, chachaRounds)
+		}
+		chachaBlockN(rounds, (*[32]byte)(key), (*[16]byte)(nonce), counter+1, &block)
+		if !bytes.Equal(got[64:128], xorBytes(src[64:128], block[:])) {
+			t.Errorf("keystream of %d rounds differs from the block function", rounds)
+		}
+	}
+	for _, rounds := range []int{-2, 0, 7} {
+		if _, err := NewChaCha20x24CipherRounds(key, nonce, rounds); err == nil {
+			t.Errorf("%d rounds accepted", rounds)
+		}
+	}
+}
+
+func xorBytes(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	subtle.XORBytes(out, a, b)
+	return out
+}
+
+func TestChaChaBlocksNx4(t *testing.T) {
+	if !chachaHasNEON {
+		t.Log("no NEON, testing the portable blocks against themselves")
+	}
+	var key [32]byte
+	var nonce [16]byte
+	for i := 0; i < 1000; i++ {
+		rand.Read(key[:])
+		rand.Read(nonce[:])
+		counter := uint32(i) * 0x410003
+		if i%10 == 0 {
+			counter = 1<<32 - 4
+		}
+		rounds := []int{chachaRounds, 2, 8, 12, 20}[i%5]
+		var got, want [4 * 64]byte
+		chachaBlocksNx4(rounds, &key, &nonce, counter, &got)
+		chachaBlocksNx4Generic(rounds, &key, &nonce, counter, &want)
+		if got != want {
+			t.Fatalf("%d rounds, key %x, nonce %x, counter %d: got %x, want %x", rounds, key, nonce, counter, got, want)
+		}
+	}
+
+	// The stream runs through the last blocks before the counter wraps.
+	src := make([]byte, 8*64)
+	want := make([]byte, len(src))
+	for i := range 8 {
+		chachaBlock24(&key, &nonce, 1<<32-8+uint32(i), (*[64]byte)(want[i*64:]))
+	}
+	c, _ := NewChaCha20x24Cipher(key[:], nonce[:])
+	c.SetCounter(1<<32 - 8)
+	got := make([]byte, len(src))
+	c.XORKeyStream(got, src)
+	if !bytes.Equal(got, want) {
+		t.Error("keystream up to the counter wrapping differs")
+	}
+}
+
+func BenchmarkChaChas(b *testing.B) {
+	key := make([]byte, chachaKeySize)
+	rand.Read(key)
+	for _, size := range []int{64, 1420, 8192} {
+		src := make([]byte, size)
+		dst := make([]byte, size)
+		b.Run(fmt.Sprintf("ChaCha20/size=%d", size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				c, _ := chacha20.NewUnauthenticatedCipher(key, make([]byte, chacha20.NonceSize))
+				c.XORKeyStream(dst, src)
+			}
+		})
+		b.Run(fmt.Sprintf("ChaCha20x24/size=%d", size), func(b *testing.B) {
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				c, _ := NewChaCha20x24Cipher(key, make([]byte, chachaNonceSize))
+				c.XORKeyStream(dst, src)
+			}
+		})
+		b.Run(fmt.Sprintf("ChaCha20x24Scalar/size=%d", size), func(b *testing.B) {
+			var block [64]byte
+			var nonce [chachaNonceSize]byte
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				for j := 0; j < size; j += len(block) {
+					chachaBlock24((*[32]byte)(key), &nonce, uint32(j/len(block)), &block)
+					subtle.XORBytes(dst[j:], src[j:], block[:])
+				}
+			}
+		})
+	}
+}
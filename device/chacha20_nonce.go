@@ -0,0 +1,54 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import "encoding/binary"
+
+/* Transport nonces of the ChaCha20_24 experiment
+ *
+ * The 24-round ChaCha variant takes a 16-byte nonce, where transport messages
+ * carry only a 64-bit counter. Its transport nonce is thus derived from what
+ * both ends of a session know of each message, laid out as:
+ *
+ *	bytes 0-7    counter, little endian, as in the transport header
+ *	bytes 8-11   receiver index, little endian, as in the transport header
+ *	byte  12     chacha24FromInitiator or chacha24FromResponder
+ *	bytes 13-15  zero
+ *
+ * Within a session, the counter never repeats for a key. The receiver index
+ * and direction make nonces unique across the sessions alive at once and
+ * across the two directions of a session as well, so that keystream is not
+ * reused even if keys were.
+ */
+
+const (
+	chachaNonceSize = 16
+
+	chacha24FromInitiator = 1 // sent by the initiator of the session
+	chacha24FromResponder = 2 // sent by the responder of the session
+)
+
+// chacha24TransportNonce returns the ChaCha20_24 nonce of the transport
+// message with the given counter and receiver index, sent by the initiator
+// of its session if fromInitiator.
+func chacha24TransportNonce(counter uint64, receiverIndex uint32, fromInitiator bool) (nonce [chachaNonceSize]byte) {
+	binary.LittleEndian.PutUint64(nonce[0:], counter)
+	binary.LittleEndian.PutUint32(nonce[8:], receiverIndex)
+	nonce[12] = chacha24FromResponder
+	if fromInitiator {
+		nonce[12] = chacha24FromInitiator
+	}
+	return
+}
+
+// chacha24Nonce returns the ChaCha20_24 nonce of the transport message with
+// the given counter, sent with the keypair if sending, or else received.
+func (keypair *Keypair) chacha24Nonce(counter uint64, sending bool) [chachaNonceSize]byte {
+	if sending {
+		return chacha24TransportNonce(counter, keypair.remoteIndex, keypair.isInitiator)
+	}
+	return chacha24TransportNonce(counter, keypair.localIndex, !keypair.isInitiator)
+}
@@ -0,0 +1,69 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestChaCha24TransportNonceLayout(t *testing.T) {
+	nonce := chacha24TransportNonce(0x0807060504030201, 0x0c0b0a09, true)
+	want := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, chacha24FromInitiator, 0, 0, 0}
+	if !bytes.Equal(nonce[:], want) {
+		t.Errorf("nonce = %x, want %x", nonce, want)
+	}
+	if nonce := chacha24TransportNonce(0, 0, false); nonce[12] != chacha24FromResponder {
+		t.Errorf("responder nonce = %x", nonce)
+	}
+}
+
+func TestChaCha24NonceUniqueness(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	peer0 := pair[0].dev.LookupPeer(pair[1].dev.staticIdentity.publicKey)
+	peer1 := pair[1].dev.LookupPeer(pair[0].dev.staticIdentity.publicKey)
+
+	const counters = 1000
+	seen := make(map[[chachaNonceSize]byte]string)
+	for session := range 3 {
+		if session > 0 {
+			// Let the initiation get a newer timestamp than the last one.
+			time.Sleep(50 * time.Millisecond)
+			peer0.ExpireCurrentKeypairs()
+			peer1.ExpireCurrentKeypairs()
+		}
+		pair.Send(t, Ping, nil)
+		keypair0, keypair1 := peer0.keypairs.Current(), peer1.keypairs.Current()
+		if keypair0.isInitiator == keypair1.isInitiator {
+			t.Fatal("both ends of the session are initiators or responders")
+		}
+		for counter := range uint64(counters) {
+			// Both ends derive the same nonce for each message.
+			for _, dir := range []struct {
+				name             string
+				sender, receiver *Keypair
+			}{
+				{"0->1", keypair0, keypair1},
+				{"1->0", keypair1, keypair0},
+			} {
+				nonce := dir.sender.chacha24Nonce(counter, true)
+				if received := dir.receiver.chacha24Nonce(counter, false); received != nonce {
+					t.Fatalf("session %d, %s, counter %d: sent with nonce %x, received with %x", session, dir.name, counter, nonce, received)
+				}
+				// No nonce repeats across directions and sessions.
+				if prev, ok := seen[nonce]; ok {
+					t.Fatalf("session %d, %s, counter %d: nonce %x already used by %s", session, dir.name, counter, nonce, prev)
+				}
+				seen[nonce] = dir.name
+			}
+		}
+	}
+	if len(seen) != 3*2*counters {
+		t.Errorf("%d distinct nonces, want %d", len(seen), 3*2*counters)
+	}
+}
@@ -0,0 +1,34 @@
+//go:build wg_experimental && wg_debug
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+/* Round states of the ChaCha20_24 experiment
+ *
+ * Experimental builds with the wg_debug tag can record the state matrix of a
+ * ChaCha20_24 block as it goes through its rounds, for cryptanalysis
+ * experiments on the modified quarter round. Other builds lack the API, and
+ * the recording is compiled out of the block function.
+ */
+
+// chachaTracing enables the recording of round states in chachaBlockN.
+const chachaTracing = true
+
+// ChaCha20_24RoundStates is the number of state matrices recorded of a
+// ChaCha20_24 block: its input state, and its state after each round.
+const ChaCha20_24RoundStates = chachaRounds + 1
+
+// TraceChaCha20_24 returns the ChaCha20_24 keystream block of key, nonce and
+// counter, recording its state matrices into states: the input state, then
+// the state after each column and each diagonal round, before the input is
+// added back. As many states as fit are recorded, and n is the number there
+// are, ChaCha20_24RoundStates.
+func TraceChaCha20_24(key *[32]byte, nonce *[16]byte, counter uint32, states [][16]uint32) (block [64]byte, n int) {
+	trace := chachaTrace{states: states}
+	chachaBlockNTraced(chachaRounds, key, nonce, counter, &block, &trace)
+	return block, trace.n
+}
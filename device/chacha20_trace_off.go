@@ -0,0 +1,11 @@
+//go:build wg_experimental && !wg_debug
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+// chachaTracing compiles the recording of round states out of chachaBlockN.
+const chachaTracing = false
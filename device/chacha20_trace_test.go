@@ -0,0 +1,70 @@
+//go:build wg_experimental && wg_debug
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestTraceChaCha20_24(t *testing.T) {
+	var key [32]byte
+	var nonce [16]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	for i := range nonce {
+		nonce[i] = byte(0x40 + i)
+	}
+	states := make([][16]uint32, ChaCha20_24RoundStates)
+	block, n := TraceChaCha20_24(&key, &nonce, 7, states)
+	if n != ChaCha20_24RoundStates {
+		t.Fatalf("recorded %d states", n)
+	}
+	want := make([]byte, 64)
+	c, _ := NewChaCha20x24Cipher(key[:], nonce[:])
+	c.SetCounter(7)
+	c.XORKeyStream(want, want)
+	if !bytes.Equal(block[:], want) {
+		t.Fatalf("traced block %x, want %x", block, want)
+	}
+	if states[0][4] != binary.LittleEndian.Uint32(key[:]) || states[0][15] != 7 {
+		t.Errorf("input state %x", states[0])
+	}
+
+	// Each state is the previous one after a column or diagonal round.
+	for i := 1; i < n; i++ {
+		x := states[i-1]
+		if i%2 == 1 {
+			quarterRound(&x, 0, 4, 8, 12)
+			quarterRound(&x, 1, 5, 9, 13)
+			quarterRound(&x, 2, 6, 10, 14)
+			quarterRound(&x, 3, 7, 11, 15)
+		} else {
+			quarterRound(&x, 0, 5, 10, 15)
+			quarterRound(&x, 1, 6, 11, 12)
+			quarterRound(&x, 2, 7, 8, 13)
+			quarterRound(&x, 3, 4, 9, 14)
+		}
+		if x != states[i] {
+			t.Errorf("state %d is not a round after state %d", i, i-1)
+		}
+	}
+	for i := range 16 {
+		if got := binary.LittleEndian.Uint32(block[i*4:]); got != states[n-1][i]+states[0][i] {
+			t.Errorf("block word %d is not the last state plus the input", i)
+		}
+	}
+
+	// A short buffer gets the first states.
+	short := make([][16]uint32, 3)
+	if _, n := TraceChaCha20_24(&key, &nonce, 7, short); n != ChaCha20_24RoundStates || short[2] != states[2] {
+		t.Errorf("short trace recorded %d states, third %x", n, short[2])
+	}
+}
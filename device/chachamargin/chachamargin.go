@@ -0,0 +1,218 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package chachamargin measures how fast ChaCha variants diffuse their input,
+// to compare the security margins of their round counts.
+//
+// For a variant and a number of rounds, Analyze samples the keystream blocks
+// of random inputs and measures the avalanche of flipping each input bit,
+// that is how often each output bit flips with it, and the bias of each
+// output bit. A round count diffuses fully once no avalanche probability and
+// no output bit is further from one half than chance explains, and the
+// margin of a variant is how many times its configured rounds exceed the
+// fewest that diffuse fully. This says nothing about attacks beyond these
+// statistics: a variant failing them is broken, one passing them is merely
+// not obviously so.
+//
+// The variants are built on the quarter round of the device package's
+// ChaCha20_24, ChaChaQuarterRound, so that the analysis is of the code the
+// experiment runs. Like that code, the package is only built with the
+// wg_experimental tag.
+package chachamargin
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"math/rand/v2"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+// A Variant is a ChaCha permutation with the given quarter round.
+type Variant struct {
+	Name      string
+	Rotations [4]int // left rotations of the four steps of the quarter round
+	Increment uint32 // added to d after its first rotation
+	Rounds    int    // rounds the variant is used with
+}
+
+var (
+	// Standard is ChaCha20 as specified in RFC 8439.
+	Standard = Variant{Name: "chacha20", Rotations: [4]int{16, 12, 8, 7}, Rounds: 20}
+	// Modified is the ChaCha20_24 experiment of the device package, with
+	// the rotations and increment of its quarter round.
+	Modified = Variant{Name: "chacha20_24", Rotations: device.ChaCha20x24Rotations(), Increment: device.ChaCha20x24Increment, Rounds: 24}
+)
+
+func (v Variant) quarterRound(x *[16]uint32, a, b, c, d int) {
+	device.ChaChaQuarterRound(x, a, b, c, d, &v.Rotations, v.Increment)
+}
+
+// Permute applies rounds rounds of the variant to x, alternating column and
+// diagonal rounds, starting with a column round.
+func (v Variant) Permute(x *[16]uint32, rounds int) {
+	for i := 0; i < rounds; i++ {
+		if i%2 == 0 {
+			v.quarterRound(x, 0, 4, 8, 12)
+			v.quarterRound(x, 1, 5, 9, 13)
+			v.quarterRound(x, 2, 6, 10, 14)
+			v.quarterRound(x, 3, 7, 11, 15)
+		} else {
+			v.quarterRound(x, 0, 5, 10, 15)
+			v.quarterRound(x, 1, 6, 11, 12)
+			v.quarterRound(x, 2, 7, 8, 13)
+			v.quarterRound(x, 3, 4, 9, 14)
+		}
+	}
+}
+
+// Block returns the keystream block of the input state after rounds rounds,
+// that is the permuted state added to the input.
+func (v Variant) Block(in *[16]uint32, rounds int) (out [16]uint32) {
+	out = *in
+	v.Permute(&out, rounds)
+	for i := range out {
+		out[i] += in[i]
+	}
+	return
+}
+
+// sigma is the constant of the first four words of the state.
+var sigma = [4]uint32{0x61707865, 0x3320646e, 0x79622d32, 0x6b206574}
+
+const (
+	inputBits  = 12 * 32 // key, nonce and counter words
+	outputBits = 16 * 32
+)
+
+// A Result holds the diffusion statistics of a variant after some rounds.
+type Result struct {
+	Variant string
+	Rounds  int
+	Samples int // random inputs sampled
+
+	Avalanche        float64 // mean fraction of output bits flipped by flipping an input bit; one half is ideal
+	MaxAvalancheBias float64 // largest distance from one half of the probability of an input bit flipping an output bit
+	MaxBitBias       float64 // largest distance from one half of the probability of an output bit being set
+
+	// The largest biases expected of a random function from sampling alone.
+	AvalancheNoise float64
+	BitNoise       float64
+}
+
+// Diffused reports whether the biases of r are within what sampling
+// explains: the largest ones within half again their expected value, and
+// the mean avalanche within four standard deviations of one half.
+func (r Result) Diffused() bool {
+	meanNoise := 4 * 0.5 / math.Sqrt(float64(r.Samples*inputBits*outputBits))
+	return math.Abs(r.Avalanche-0.5) <= meanNoise && r.MaxAvalancheBias <= 1.5*r.AvalancheNoise && r.MaxBitBias <= 1.5*r.BitNoise
+}
+
+// noiseFloor returns the expected largest distance from one half of cells
+// frequencies each sampled samples times from a fair coin.
+func noiseFloor(cells, samples int) float64 {
+	return 0.5 * math.Sqrt(2*math.Log(2*float64(cells))/float64(samples))
+}
+
+// Analyze measures the diffusion of rounds rounds of v over samples random
+// inputs from a generator seeded with seed.
+func Analyze(v Variant, rounds, samples int, seed uint64) Result {
+	rng := rand.New(rand.NewPCG(seed, uint64(rounds)))
+	flips := make([]int, inputBits*outputBits)
+	var ones [outputBits]int
+	var flipped int
+	for range samples {
+		var in [16]uint32
+		copy(in[:4], sigma[:])
+		for i := 4; i < 16; i++ {
+			in[i] = rng.Uint32()
+		}
+		out := v.Block(&in, rounds)
+		for i := range outputBits {
+			ones[i] += int(out[i/32] >> (i % 32) & 1)
+		}
+		for bit := range inputBits {
+			flippedIn := in
+			flippedIn[4+bit/32] ^= 1 << (bit % 32)
+			flippedOut := v.Block(&flippedIn, rounds)
+			row := flips[bit*outputBits:]
+			for w := range flippedOut {
+				diff := flippedOut[w] ^ out[w]
+				flipped += bits.OnesCount32(diff)
+				for diff != 0 {
+					row[w*32+bits.TrailingZeros32(diff)]++
+					diff &= diff - 1
+				}
+			}
+		}
+	}
+
+	r := Result{
+		Variant:        v.Name,
+		Rounds:         rounds,
+		Samples:        samples,
+		Avalanche:      float64(flipped) / float64(samples*inputBits*outputBits),
+		AvalancheNoise: noiseFloor(inputBits*outputBits, samples),
+		BitNoise:       noiseFloor(outputBits, samples),
+	}
+	for _, n := range flips {
+		r.MaxAvalancheBias = max(r.MaxAvalancheBias, math.Abs(float64(n)/float64(samples)-0.5))
+	}
+	for _, n := range ones {
+		r.MaxBitBias = max(r.MaxBitBias, math.Abs(float64(n)/float64(samples)-0.5))
+	}
+	return r
+}
+
+// DiffusionRounds returns the fewest rounds, up to v.Rounds, after which v
+// diffuses fully over samples inputs, the results of each round count
+// analyzed, and whether any diffused fully.
+func DiffusionRounds(v Variant, samples int, seed uint64) (rounds int, results []Result, ok bool) {
+	for n := 1; n <= v.Rounds; n++ {
+		r := Analyze(v, n, samples, seed)
+		results = append(results, r)
+		if r.Diffused() {
+			return n, results, true
+		}
+	}
+	return 0, results, false
+}
+
+// Report writes a report comparing the diffusion of variants over samples
+// inputs, with a line of statistics for each round count analyzed and a
+// summary of the margin of each variant.
+func Report(w io.Writer, variants []Variant, samples int, seed uint64) error {
+	if _, err := fmt.Fprintf(w, "# ChaCha diffusion report: %d samples, seed %d\n", samples, seed); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "# variant rounds avalanche max_avalanche_bias max_bit_bias avalanche_noise bit_noise diffused\n")
+	type summary struct {
+		v      Variant
+		rounds int
+		ok     bool
+	}
+	var summaries []summary
+	for _, v := range variants {
+		rounds, results, ok := DiffusionRounds(v, samples, seed)
+		for _, r := range results {
+			fmt.Fprintf(w, "%s %d %.6f %.6f %.6f %.6f %.6f %t\n", r.Variant, r.Rounds, r.Avalanche, r.MaxAvalancheBias, r.MaxBitBias, r.AvalancheNoise, r.BitNoise, r.Diffused())
+		}
+		summaries = append(summaries, summary{v, rounds, ok})
+	}
+	for _, s := range summaries {
+		if !s.ok {
+			fmt.Fprintf(w, "# %s: rotations %v, increment %d: no full diffusion within %d rounds\n", s.v.Name, s.v.Rotations, s.v.Increment, s.v.Rounds)
+			continue
+		}
+		fmt.Fprintf(w, "# %s: rotations %v, increment %d: full diffusion after %d rounds, margin %.2fx at %d rounds\n",
+			s.v.Name, s.v.Rotations, s.v.Increment, s.rounds, float64(s.v.Rounds)/float64(s.rounds), s.v.Rounds)
+	}
+	_, err := fmt.Fprintf(w, "# note: statistical diffusion only; passing says nothing of resistance to cryptanalysis\n")
+	return err
+}
@@ -0,0 +1,102 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package chachamargin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+func blockBytes(out [16]uint32) []byte {
+	b := make([]byte, 64)
+	for i, w := range out {
+		binary.LittleEndian.PutUint32(b[i*4:], w)
+	}
+	return b
+}
+
+func TestStandardMatchesChaCha20(t *testing.T) {
+	var key [32]byte
+	var nonce [12]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	for i := range nonce {
+		nonce[i] = byte(0x40 + i)
+	}
+	const counter = 7
+	want := make([]byte, 64)
+	c, err := chacha20.NewUnauthenticatedCipher(key[:], nonce[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetCounter(counter)
+	c.XORKeyStream(want, want)
+
+	var in [16]uint32
+	copy(in[:4], sigma[:])
+	for i := range 8 {
+		in[4+i] = binary.LittleEndian.Uint32(key[i*4:])
+	}
+	in[12] = counter
+	for i := range 3 {
+		in[13+i] = binary.LittleEndian.Uint32(nonce[i*4:])
+	}
+	if got := blockBytes(Standard.Block(&in, Standard.Rounds)); !bytes.Equal(got, want) {
+		t.Errorf("standard block %x, want %x", got, want)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	for _, v := range []Variant{Standard, Modified} {
+		if r := Analyze(v, 1, 64, 1); r.Diffused() || r.MaxAvalancheBias != 0.5 {
+			t.Errorf("%s after one round: %+v", v.Name, r)
+		}
+		if r := Analyze(v, v.Rounds, 64, 1); !r.Diffused() {
+			t.Errorf("%s after %d rounds: %+v", v.Name, v.Rounds, r)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	var b strings.Builder
+	if err := Report(&b, []Variant{Standard, Modified}, 64, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"\nchacha20 1 ", "\nchacha20_24 1 ", "\n# chacha20: ", "\n# chacha20_24: "} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("report lacks %q:\n%s", line, b.String())
+		}
+	}
+}
+
+func BenchmarkPermute(b *testing.B) {
+	for _, v := range []Variant{Standard, Modified} {
+		b.Run(v.Name, func(b *testing.B) {
+			var x [16]uint32
+			copy(x[:4], sigma[:])
+			for range b.N {
+				v.Permute(&x, v.Rounds)
+			}
+		})
+	}
+}
+
+func BenchmarkAnalyze(b *testing.B) {
+	for _, v := range []Variant{Standard, Modified} {
+		b.Run(v.Name, func(b *testing.B) {
+			for range b.N {
+				Analyze(v, 4, 16, 1)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package chachamargin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+func TestModifiedMatchesDevice(t *testing.T) {
+	var key [32]byte
+	var nonce [16]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	for i := range nonce {
+		nonce[i] = byte(0x40 + i)
+	}
+	const counter = 7
+	want := make([]byte, 64)
+	c, err := device.NewChaCha20x24Cipher(key[:], nonce[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetCounter(counter)
+	c.XORKeyStream(want, want)
+
+	// The device lays out its state with the nonce from word 11 on.
+	var in [16]uint32
+	copy(in[:4], sigma[:])
+	for i := range 8 {
+		in[4+i] = binary.LittleEndian.Uint32(key[i*4:])
+	}
+	for i := range 4 {
+		in[11+i] = binary.LittleEndian.Uint32(nonce[i*4:])
+	}
+	in[15] = counter
+	if got := blockBytes(Modified.Block(&in, Modified.Rounds)); !bytes.Equal(got, want) {
+		t.Errorf("modified block %x, want %x", got, want)
+	}
+}
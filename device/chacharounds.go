@@ -0,0 +1,118 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+/* ChaCha rounds experiment
+ *
+ * Suites whose cipher is one of the modified ChaCha variants, such as
+ * ChaCha20x24Poly1795, can run it with another number of rounds than their
+ * own, so that the throughput of a live tunnel can be measured against the
+ * round count. With the experiment set, the sessions of those suites start
+ * with the device's round count, and sessions of other suites are left as
+ * they are. Both ends of a session must be set to the same count, or its
+ * packets fail to authenticate; round counts are not negotiated and are
+ * not recorded in session transcripts.
+ *
+ * Counts below MinChaChaRounds leave no security margin against the known
+ * attacks on reduced-round ChaCha and are refused unless forced, for
+ * measurements only.
+ */
+
+const (
+	MinChaChaRounds = 8  // fewest rounds set without forcing
+	MaxChaChaRounds = 24 // most rounds, those of ChaCha20_24
+)
+
+type deviceChaChaRounds struct {
+	rounds atomic.Int32 // zero for those of each suite
+	force  atomic.Bool
+}
+
+// SetChaChaRounds sets the number of ChaCha rounds of the sessions of the
+// suites that can vary theirs, an even number up to MaxChaChaRounds, or
+// zero for the suites' own. Counts below MinChaChaRounds are refused unless
+// forced with SetChaChaRoundsForce. The current keypairs of those suites
+// are expired if the count changes, so that the next handshakes start
+// sessions with it.
+func (device *Device) SetChaChaRounds(rounds int) error {
+	if rounds != 0 && !chachaRoundsSupported() {
+		return errors.New("ChaCha rounds require a build with the wg_experimental tag")
+	}
+	switch {
+	case rounds < 0 || rounds > MaxChaChaRounds || rounds%2 != 0:
+		return fmt.Errorf("ChaCha rounds %d not an even number up to %d", rounds, MaxChaChaRounds)
+	case rounds != 0 && rounds < MinChaChaRounds && !device.chacha.force.Load():
+		return fmt.Errorf("ChaCha rounds %d below %d without force", rounds, MinChaChaRounds)
+	}
+	if int(device.chacha.rounds.Swap(int32(rounds))) == rounds {
+		return nil
+	}
+	device.peers.RLock()
+	defer device.peers.RUnlock()
+	for _, peer := range device.peers.keyMap {
+		if peer.hasChaChaRoundsKeypair() {
+			peer.ExpireCurrentKeypairs()
+		}
+	}
+	return nil
+}
+
+// ChaChaRounds returns the number of rounds set with SetChaChaRounds.
+func (device *Device) ChaChaRounds() int {
+	return int(device.chacha.rounds.Load())
+}
+
+// SetChaChaRoundsForce sets whether SetChaChaRounds takes counts below
+// MinChaChaRounds. Clearing it leaves a count already set as it is.
+func (device *Device) SetChaChaRoundsForce(force bool) {
+	device.chacha.force.Store(force)
+}
+
+// ChaChaRoundsForce returns whether counts below MinChaChaRounds are taken.
+func (device *Device) ChaChaRoundsForce() bool {
+	return device.chacha.force.Load()
+}
+
+// chachaRoundsSupported returns whether a registered suite can vary its
+// number of rounds.
+func chachaRoundsSupported() bool {
+	for _, name := range CipherSuites() {
+		if LookupCipherSuite(name).NewRounds != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// newSessionAEAD returns an AEAD of the suite with the key for a session,
+// with the device's number of ChaCha rounds if set and the suite can vary
+// its own.
+func (device *Device) newSessionAEAD(suite *CipherSuite, key []byte) (AEADSuite, error) {
+	if rounds := device.ChaChaRounds(); rounds != 0 && suite.NewRounds != nil {
+		return suite.NewRounds(key, rounds)
+	}
+	return suite.New(key)
+}
+
+// hasChaChaRoundsKeypair returns whether the current or next keypair of the
+// peer is of a suite that can vary its number of rounds.
+func (peer *Peer) hasChaChaRoundsKeypair() bool {
+	keypairs := &peer.keypairs
+	keypairs.RLock()
+	defer keypairs.RUnlock()
+	for _, keypair := range []*Keypair{keypairs.current, keypairs.next.Load()} {
+		if keypair != nil && keypair.suite.NewRounds != nil {
+			return true
+		}
+	}
+	return false
+}
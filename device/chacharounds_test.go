@@ -0,0 +1,69 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestChaChaRounds(t *testing.T) {
+	dev := randDevice(t)
+	defer dev.Close()
+	if !chachaRoundsSupported() {
+		if err := dev.SetChaChaRounds(12); err == nil || !strings.Contains(err.Error(), "wg_experimental") {
+			t.Errorf("set rounds without a suite to vary them: %v", err)
+		}
+		assertNil(t, dev.SetChaChaRounds(0))
+		return
+	}
+
+	for _, rounds := range []int{8, 12, 20, 24, 0} {
+		if err := dev.IpcSet("chacha_rounds=" + strconv.Itoa(rounds) + "\n"); err != nil {
+			t.Errorf("%d rounds: %v", rounds, err)
+		} else if dev.ChaChaRounds() != rounds {
+			t.Errorf("%d rounds set as %d", rounds, dev.ChaChaRounds())
+		}
+	}
+	for _, value := range []string{"6", "2", "13", "26", "-8", "eight"} {
+		if err := dev.IpcSet("chacha_rounds=" + value + "\n"); err == nil {
+			t.Errorf("set rounds %s", value)
+		}
+	}
+
+	// Forcing takes counts below the minimum.
+	assertNil(t, dev.IpcSet("chacha_rounds_force=true\nchacha_rounds=4\n"))
+	if dev.ChaChaRounds() != 4 || !dev.ChaChaRoundsForce() {
+		t.Fatalf("forced rounds %d, force %v", dev.ChaChaRounds(), dev.ChaChaRoundsForce())
+	}
+	cfg, err := dev.IpcGet()
+	assertNil(t, err)
+	if !strings.Contains(cfg, "\nchacha_rounds=4\n") || !strings.Contains(cfg, "\nchacha_rounds_force=true\n") {
+		t.Errorf("get output lacks the rounds:\n%s", cfg)
+	}
+	if err := dev.IpcSet("chacha_rounds_force=maybe\n"); err == nil {
+		t.Error("set force to an invalid value")
+	}
+	assertNil(t, dev.IpcSet("chacha_rounds_force=false\n"))
+	if err := dev.SetChaChaRounds(6); err == nil {
+		t.Error("set rounds below the minimum once no longer forced")
+	}
+
+	force, rounds := true, 2
+	assertNil(t, dev.Configure(Config{ChaChaRoundsForce: &force, ChaChaRounds: &rounds}))
+	if status := dev.Status(); status.ChaChaRounds != 2 {
+		t.Errorf("status rounds %d", status.ChaChaRounds)
+	}
+	var found bool
+	entries, _ := dev.AuditTrail()
+	for _, entry := range entries {
+		found = found || entry.Setting == "chacha_rounds" && entry.Value == "2"
+	}
+	if !found {
+		t.Error("rounds not audited")
+	}
+}
@@ -0,0 +1,777 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/ipc"
+)
+
+// Config is a typed equivalent of a UAPI "set" operation, modeled after
+// wgctrl's wgtypes.Config, for programs that embed a Device in-process.
+// Nil pointer fields are left unchanged.
+type Config struct {
+	PrivateKey          *NoisePrivateKey
+	ListenPort          *int
+	ListenPorts         []int // primary port followed by additional ports; overrides ListenPort
+	KnockSecret         *[32]byte
+	FirewallMark        *int
+	NoiseConstruction   *string
+	NoiseIdentifier     *string
+	HandshakeHash       *string // name of a registered hash of new handshakes
+	Quarantine          *QuarantinePolicy
+	PacketTrace         *PacketTracePolicy
+	Timestamps          *TimestampPolicy
+	IndexShard          *IndexShard
+	HandshakePadding    *int    // size handshake messages are padded to; zero for none
+	CipherSuite         *string // name of a registered suite for new sessions of peers not pinned to one
+	CookieMAC           *CookieMAC
+	KeystreamPrecompute *int // counters whose keystream is precomputed for each keypair; zero for none
+	ChaChaRounds        *int // ChaCha rounds of suites that can vary theirs; zero for their own
+	ChaChaRoundsForce   *bool
+	CoreAffinity        *bool
+	ICMPErrors          *ICMPErrorPolicy
+	LogLevels           map[string]int // by log subsystem, or for all of them under ""
+	NestedAddress       *netip.Addr    // address in the tunnel of another device in the process; the zero Addr for none
+	ReplacePeers        bool
+	Peers               []PeerConfig
+}
+
+// PeerConfig is a typed equivalent of the peer section of a UAPI "set"
+// operation, modeled after wgctrl's wgtypes.PeerConfig.
+// Nil pointer fields are left unchanged.
+type PeerConfig struct {
+	PublicKey                   NoisePublicKey
+	Remove                      bool
+	UpdateOnly                  bool
+	PresharedKey                *NoisePresharedKey
+	PSKRotator                  *PSKRotator // derives preshared keys in place of PresharedKey, unless its root is zero
+	ChannelBinding              *[32]byte
+	MAC1PublicKey               *NoisePublicKey // key MACs are computed with in place of PublicKey; zero for PublicKey
+	Endpoint                    *netip.AddrPort
+	PersistentKeepaliveInterval *time.Duration
+	MSSClampMTU                 *int
+	MaxQueueAge                 *time.Duration // longest packets may wait in queues; zero for no limit
+	PacingRate                  *int64         // in bits per second, or PacingAuto
+	StagedQueueSize             *int           // packets held while awaiting a session; zero for the device's limit
+	StagedEvictionPolicy        *StagedEvictionPolicy
+	InboundLimit                *InboundLimit
+	EncryptionWeight            *int  // weight of the peer's share of saturated encryption workers; zero for the default
+	EagerKeyErasure             *bool // discard the previous keypair once its successor is confirmed, zeroing its keys where the suite allows
+	EndpointFallback            *bool
+	SwitchPolicy                *SwitchPolicy
+	CipherSuite                 *string   // name of a registered suite for new sessions
+	CipherSuiteOffer            *[]string // suites to negotiate in handshakes, in order of preference; empty to not negotiate
+	PQMode                      *PQMode
+	ResponseData                *[]byte
+	Assignment                  *Assignment // replaces the addresses and DNS servers assigned to the peer
+	AcceptAssignment            *bool
+	AcceptAssignmentSubnets     *[]netip.Prefix // replaces the ranges within which assigned subnets are allowed in full
+	Name                        *string
+	Metadata                    *[]byte // attached after verification; empty detaches it
+	ReplaceAllowedIPs           bool
+	AllowedIPs                  []netip.Prefix
+}
+
+// DeviceStatus is a typed equivalent of a UAPI "get" operation, modeled
+// after wgctrl's wgtypes.Device.
+type DeviceStatus struct {
+	PrivateKey          NoisePrivateKey
+	PublicKey           NoisePublicKey
+	ListenPort          int
+	ListenPorts         []PortStatus // per-port statistics, if listening on more than one port
+	KnockSecret         [32]byte
+	FirewallMark        int
+	NoiseConstruction   string
+	NoiseIdentifier     string
+	HandshakeHash       string
+	Experimental        bool // non-standard settings are active; see AuditTrail
+	Cookie              CookieStats
+	Congestion          CongestionStats
+	WorkerCrashes       uint64 // panics recovered by workers
+	EncryptionScheduled uint64 // batches of packets held back for their peer's share of saturated encryption workers
+	Quarantine          QuarantinePolicy
+	PacketTrace         PacketTracePolicy
+	Timestamps          TimestampPolicy
+	IndexShard          IndexShard
+	HandshakePadding    int
+	CipherSuite         string    // suite of peers not pinned to one
+	CookieMAC           CookieMAC // MAC of mac1, mac2 and cookies
+	BindOverhead        int       // bytes the bind adds to each datagram, such as DTLS records
+	KeystreamPrecompute int
+	Keystream           KeystreamStats
+	ChaChaRounds        int
+	CoreAffinity        bool
+	Cores               []CoreStats // core slots taken with core affinity enabled
+	ICMPErrors          ICMPErrorPolicy
+	LogLevels           map[string]int // by log subsystem
+	NestedAddress       netip.Addr
+	NestedHandoffs      uint64 // datagrams received from other devices in memory
+	Peers               []PeerStatus
+}
+
+// PeerStatus is a typed equivalent of the peer section of a UAPI "get"
+// operation, modeled after wgctrl's wgtypes.Peer.
+type PeerStatus struct {
+	PublicKey                   NoisePublicKey
+	PresharedKey                NoisePresharedKey
+	PSKRotator                  PSKRotator
+	ChannelBinding              [32]byte
+	MAC1PublicKey               NoisePublicKey
+	Endpoint                    string
+	PersistentKeepaliveInterval time.Duration
+	MSSClampMTU                 int
+	MaxQueueAge                 time.Duration
+	StaleDrops                  uint64 // packets discarded for exceeding MaxQueueAge
+	PacingRate                  int64
+	PacingEstimate              int64 // estimated bottleneck bandwidth in bits per second, if pacing automatically
+	StagedQueueSize             int
+	StagedEvictionPolicy        StagedEvictionPolicy
+	StagedDrops                 map[StagedEvictionPolicy]uint64 // staged packets dropped by each policy that dropped any
+	InboundLimit                InboundLimit
+	InboundRateLimited          uint64 // packets dropped for the packet or byte rates of InboundLimit
+	InboundFailedLimited        uint64 // packets dropped for the failed rate of InboundLimit
+	EncryptionWeight            int
+	PacketSizes                 PacketSizeStats
+	Reorder                     ReorderStats
+	KeyUsage                    KeyUsageForecast
+	WorkerCrashes               uint64 // panics recovered by workers processing its packets
+	EagerKeyErasure             bool
+	EndpointFallback            bool
+	SwitchPolicy                SwitchPolicy
+	EndpointRTTs                map[string]time.Duration // smoothed round-trip times, if switching is enabled
+	CipherSuite                 string                   // suite the peer is pinned to
+	ActiveCipherSuite           string                   // suite of the current session, if any
+	CipherSuiteOffer            []string                 // suites negotiated in handshakes, if any
+	PQMode                      PQMode                   // whether handshakes are post-quantum hybrid ones
+	HybridSession               bool                     // current session derived with an ML-KEM shared secret
+	ActiveTagSize               int                      // bytes of the tags of the current session, if any
+	ActiveSecurityBits          int                      // integrity level of the current session's suite, if any
+	DecryptFailures             uint64                   // transport packets that failed to decrypt
+	ReplayHits                  uint64                   // transport packets rejected by the replay filter
+	MalformedPackets            uint64                   // decrypted packets with an invalid or disallowed inner packet
+	QuarantinedUntil            time.Time                // end of the peer's quarantine, if quarantined
+	ICMPErrors                  uint64                   // destination unreachable errors received for its endpoint
+	PathUnreachableUntil        time.Time                // when the mark of its path as unreachable expires, if marked
+	ResponseData                []byte
+	ReceivedResponseData        []byte
+	Assignment                  Assignment
+	AcceptAssignment            bool
+	AcceptAssignmentSubnets     []netip.Prefix
+	ReceivedAssignment          *Assignment
+	Name                        string
+	Metadata                    []byte
+	MetadataRejections          uint64 // handshakes dropped because the metadata verifier failed
+	LastHandshakeTime           time.Time
+	ReceiveBytes                int64
+	TransmitBytes               int64
+	AllowedIPs                  []netip.Prefix
+	ProtocolVersion             int
+}
+
+// Configure applies cfg to the device without going through the text-based
+// configuration protocol. It has the same semantics as IpcSet, and returns
+// the same *IPCError values on failure.
+func (device *Device) Configure(cfg Config) (err error) {
+	return device.ConfigureContext(context.Background(), cfg)
+}
+
+// ConfigureContext is Configure, giving up when ctx is done. The peers
+// configured until then stay configured.
+func (device *Device) ConfigureContext(ctx context.Context, cfg Config) (err error) {
+	if cfg.PrivateKey != nil || cfg.ListenPort != nil || cfg.ListenPorts != nil || cfg.FirewallMark != nil ||
+		cfg.NoiseConstruction != nil || cfg.NoiseIdentifier != nil || cfg.HandshakeHash != nil || cfg.NestedAddress != nil ||
+		cfg.ReplacePeers {
+		device.ipcMutex.Lock()
+		defer device.ipcMutex.Unlock()
+	} else {
+		device.ipcMutex.RLock()
+		defer device.ipcMutex.RUnlock()
+	}
+
+	defer func() {
+		if err != nil {
+			device.log.Errorf("%v", err)
+		}
+	}()
+
+	if err := device.configureDevice(ctx, &cfg); err != nil {
+		return err
+	}
+
+	peer := new(ipcSetPeer)
+	defer peer.release()
+	for i := range cfg.Peers {
+		if err := ctx.Err(); err != nil {
+			return ipcErrorf(ipc.IpcErrorIO, "configuration given up: %w", err)
+		}
+		if err := device.configurePeer(ctx, peer, &cfg.Peers[i]); err != nil {
+			return err
+		}
+		peer.handlePostConfig()
+	}
+	return nil
+}
+
+func (device *Device) configureDevice(ctx context.Context, cfg *Config) error {
+	if cfg.PrivateKey != nil {
+		sk := *cfg.PrivateKey
+		if !sk.IsZero() {
+			sk.clamp()
+		}
+		device.log.Verbosef("API: Updating private key")
+		device.SetPrivateKey(sk)
+	}
+
+	if cfg.KnockSecret != nil {
+		device.log.Verbosef("API: Updating knock secret")
+		device.SetKnockSecret(*cfg.KnockSecret)
+	}
+
+	if cfg.ListenPorts != nil {
+		if len(cfg.ListenPorts) == 0 || len(cfg.ListenPorts) > MaxListenPorts {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid number of listen ports: %d", len(cfg.ListenPorts))
+		}
+		ports := make([]string, len(cfg.ListenPorts))
+		for i, port := range cfg.ListenPorts {
+			ports[i] = strconv.Itoa(port)
+		}
+		parsed, err := parseListenPorts(strings.Join(ports, ","))
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid listen ports: %w", err)
+		}
+		device.log.Verbosef("API: Updating listen ports")
+		if err := device.setListenPorts(ctx, parsed); err != nil {
+			return ipcErrorf(ipc.IpcErrorPortInUse, "failed to set listen ports: %w", err)
+		}
+	} else if cfg.ListenPort != nil {
+		port := *cfg.ListenPort
+		if port < 0 || port > 0xffff {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid listen port: %d", port)
+		}
+		device.log.Verbosef("API: Updating listen port")
+
+		device.net.Lock()
+		device.net.port = uint16(port)
+		device.net.Unlock()
+
+		if err := device.bindUpdate(ctx); err != nil {
+			return ipcErrorf(ipc.IpcErrorPortInUse, "failed to set listen port: %w", err)
+		}
+	}
+
+	if cfg.FirewallMark != nil {
+		mark := *cfg.FirewallMark
+		if mark < 0 || uint64(mark) > 0xffffffff {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid fwmark: %d", mark)
+		}
+		device.log.Verbosef("API: Updating fwmark")
+		if err := device.BindSetMark(uint32(mark)); err != nil {
+			return ipcErrorf(ipc.IpcErrorPortInUse, "failed to update fwmark: %w", err)
+		}
+	}
+
+	if cfg.NoiseConstruction != nil || cfg.NoiseIdentifier != nil {
+		device.log.Verbosef("API: Updating Noise construction and identifier")
+		device.staticIdentity.RLock()
+		construction, identifier := device.staticIdentity.construction, device.staticIdentity.identifier
+		device.staticIdentity.RUnlock()
+		if cfg.NoiseConstruction != nil {
+			construction = *cfg.NoiseConstruction
+		}
+		if cfg.NoiseIdentifier != nil {
+			identifier = *cfg.NoiseIdentifier
+		}
+		device.SetProtocolIdentifier(construction, identifier)
+		if cfg.NoiseConstruction != nil {
+			device.recordAudit("api", "noise_construction", construction)
+		}
+		if cfg.NoiseIdentifier != nil {
+			device.recordAudit("api", "noise_identifier", identifier)
+		}
+	}
+
+	if cfg.HandshakeHash != nil {
+		device.log.Verbosef("API: Updating handshake hash")
+		if err := device.SetHandshakeHash(*cfg.HandshakeHash); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid handshake hash: %w", err)
+		}
+		device.recordAudit("api", "handshake_hash", *cfg.HandshakeHash)
+	}
+
+	if cfg.Quarantine != nil {
+		device.log.Verbosef("API: Updating quarantine policy")
+		if err := device.SetQuarantinePolicy(*cfg.Quarantine); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid quarantine policy: %w", err)
+		}
+	}
+
+	if cfg.PacketTrace != nil {
+		device.log.Verbosef("API: Updating packet trace policy")
+		if err := device.SetPacketTracePolicy(*cfg.PacketTrace); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid packet trace policy: %w", err)
+		}
+	}
+
+	if cfg.Timestamps != nil {
+		device.log.Verbosef("API: Updating timestamp policy")
+		if err := device.SetTimestampPolicy(*cfg.Timestamps); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid timestamp policy: %w", err)
+		}
+	}
+
+	if cfg.IndexShard != nil {
+		device.log.Verbosef("API: Updating index shard")
+		if err := device.SetIndexShard(*cfg.IndexShard); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid index shard: %w", err)
+		}
+	}
+
+	if cfg.HandshakePadding != nil {
+		device.log.Verbosef("API: Updating handshake padding")
+		if err := device.SetHandshakePadding(*cfg.HandshakePadding); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid handshake padding: %w", err)
+		}
+	}
+
+	if cfg.CipherSuite != nil {
+		device.log.Verbosef("API: Updating cipher suite")
+		if err := device.SetCipherSuite(*cfg.CipherSuite); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set cipher suite: %w", err)
+		}
+		device.recordAudit("api", "cipher_suite", *cfg.CipherSuite)
+	}
+
+	if cfg.CookieMAC != nil {
+		device.log.Verbosef("API: Updating cookie MAC")
+		if err := device.SetCookieMAC(*cfg.CookieMAC); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid cookie MAC: %w", err)
+		}
+		device.recordAudit("api", "cookie_mac", cfg.CookieMAC.String())
+	}
+
+	if cfg.KeystreamPrecompute != nil {
+		device.log.Verbosef("API: Updating keystream precomputation")
+		if err := device.SetKeystreamPrecompute(*cfg.KeystreamPrecompute); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid keystream precomputation: %w", err)
+		}
+	}
+
+	if cfg.ChaChaRoundsForce != nil {
+		device.log.Verbosef("API: Updating ChaCha rounds force")
+		device.SetChaChaRoundsForce(*cfg.ChaChaRoundsForce)
+		device.recordAudit("api", "chacha_rounds_force", strconv.FormatBool(*cfg.ChaChaRoundsForce))
+	}
+
+	if cfg.ChaChaRounds != nil {
+		device.log.Verbosef("API: Updating ChaCha rounds")
+		if err := device.SetChaChaRounds(*cfg.ChaChaRounds); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid ChaCha rounds: %w", err)
+		}
+		device.recordAudit("api", "chacha_rounds", strconv.Itoa(*cfg.ChaChaRounds))
+	}
+
+	if cfg.CoreAffinity != nil {
+		device.log.Verbosef("API: Updating core affinity")
+		device.SetCoreAffinity(*cfg.CoreAffinity)
+	}
+
+	if cfg.LogLevels != nil {
+		device.log.Verbosef("API: Updating log levels")
+		// Setting all subsystems first leaves those given their own.
+		if level, ok := cfg.LogLevels[""]; ok {
+			if err := device.SetLogLevel("", level); err != nil {
+				return ipcErrorf(ipc.IpcErrorInvalid, "invalid log level: %w", err)
+			}
+		}
+		for subsystem, level := range cfg.LogLevels {
+			if subsystem == "" {
+				continue
+			}
+			if err := device.SetLogLevel(subsystem, level); err != nil {
+				return ipcErrorf(ipc.IpcErrorInvalid, "invalid log level: %w", err)
+			}
+		}
+	}
+
+	if cfg.ICMPErrors != nil {
+		device.log.Verbosef("API: Updating ICMP error policy")
+		if err := device.SetICMPErrorPolicy(*cfg.ICMPErrors); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid ICMP error policy: %w", err)
+		}
+	}
+
+	if cfg.NestedAddress != nil {
+		device.log.Verbosef("API: Updating nested address")
+		if err := device.setNestedAddress(ctx, *cfg.NestedAddress); err != nil {
+			return ipcErrorf(ipc.IpcErrorPortInUse, "failed to set nested address: %w", err)
+		}
+	}
+
+	if cfg.ReplacePeers {
+		device.log.Verbosef("API: Removing all peers")
+		device.RemoveAllPeers()
+	}
+	return nil
+}
+
+func (device *Device) configurePeer(ctx context.Context, peer *ipcSetPeer, cfg *PeerConfig) error {
+	if err := device.beginPeerConfig(peer, cfg.PublicKey); err != nil {
+		return err
+	}
+
+	if cfg.UpdateOnly && peer.created && !peer.dummy {
+		device.RemovePeer(peer.handshake.remoteStatic)
+		peer.Peer = &Peer{}
+		peer.dummy = true
+	}
+	if cfg.Remove {
+		if !peer.dummy {
+			device.log.Verbosef("%v - API: Removing", peer.Peer)
+			device.RemovePeer(peer.handshake.remoteStatic)
+		}
+		peer.Peer = &Peer{}
+		peer.dummy = true
+	}
+	if peer.dummy {
+		return nil
+	}
+
+	if cfg.PresharedKey != nil {
+		device.log.Verbosef("%v - API: Updating preshared key", peer.Peer)
+		peer.handshake.mutex.Lock()
+		peer.handshake.presharedKey = *cfg.PresharedKey
+		peer.handshake.mutex.Unlock()
+	}
+
+	if cfg.PSKRotator != nil {
+		device.log.Verbosef("%v - API: Updating preshared key rotation", peer.Peer)
+		if err := peer.SetPSKRotator(*cfg.PSKRotator); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set preshared key rotation: %w", err)
+		}
+	}
+
+	if cfg.ChannelBinding != nil {
+		device.log.Verbosef("%v - API: Updating channel binding", peer.Peer)
+		peer.SetChannelBinding(*cfg.ChannelBinding)
+	}
+
+	if cfg.MAC1PublicKey != nil {
+		device.log.Verbosef("%v - API: Updating MAC1 public key", peer.Peer)
+		peer.SetMAC1PublicKey(*cfg.MAC1PublicKey)
+	}
+
+	if cfg.Endpoint != nil {
+		device.log.Verbosef("%v - API: Updating endpoint", peer.Peer)
+		endpoint, err := conn.ParseEndpointContext(ctx, device.net.bind, cfg.Endpoint.String())
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set endpoint %v: %w", cfg.Endpoint, err)
+		}
+		peer.endpoint.Lock()
+		old := peer.endpoint.val
+		peer.endpoint.val = endpoint
+		peer.emitEndpointChangedLocked(old)
+		peer.endpoint.Unlock()
+	}
+
+	if cfg.PersistentKeepaliveInterval != nil {
+		device.log.Verbosef("%v - API: Updating persistent keepalive interval", peer.Peer)
+		secs := *cfg.PersistentKeepaliveInterval / time.Second
+		if secs < 0 || secs > 0xffff {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid persistent keepalive interval: %v", *cfg.PersistentKeepaliveInterval)
+		}
+		old := peer.persistentKeepaliveInterval.Swap(uint32(secs))
+		peer.pkaOn = old == 0 && secs != 0
+	}
+
+	if cfg.MSSClampMTU != nil {
+		device.log.Verbosef("%v - API: Updating MSS clamping MTU", peer.Peer)
+		mtu := *cfg.MSSClampMTU
+		if mtu < 0 || mtu > 0xffff || (mtu != 0 && mtu < MinMSSClampMTU) {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid MSS clamping MTU: %d", mtu)
+		}
+		peer.mssClampMTU.Store(uint32(mtu))
+	}
+
+	if cfg.MaxQueueAge != nil {
+		device.log.Verbosef("%v - API: Updating maximum queue age", peer.Peer)
+		if err := peer.SetMaxQueueAge(*cfg.MaxQueueAge); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid maximum queue age: %w", err)
+		}
+	}
+
+	if cfg.PacingRate != nil {
+		device.log.Verbosef("%v - API: Updating pacing rate", peer.Peer)
+		if err := peer.SetPacingRate(*cfg.PacingRate); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid pacing rate: %w", err)
+		}
+	}
+
+	if cfg.StagedQueueSize != nil || cfg.StagedEvictionPolicy != nil {
+		device.log.Verbosef("%v - API: Updating staged queue", peer.Peer)
+		size, policy := peer.StagedQueue()
+		if cfg.StagedQueueSize != nil {
+			size = *cfg.StagedQueueSize
+		}
+		if cfg.StagedEvictionPolicy != nil {
+			policy = *cfg.StagedEvictionPolicy
+		}
+		if err := peer.SetStagedQueue(size, policy); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid staged queue: %w", err)
+		}
+	}
+
+	if cfg.InboundLimit != nil {
+		device.log.Verbosef("%v - API: Updating inbound limit", peer.Peer)
+		if err := peer.SetInboundLimit(*cfg.InboundLimit); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid inbound limit: %w", err)
+		}
+	}
+
+	if cfg.EncryptionWeight != nil {
+		device.log.Verbosef("%v - API: Updating encryption weight", peer.Peer)
+		if err := peer.SetEncryptionWeight(*cfg.EncryptionWeight); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid encryption weight: %w", err)
+		}
+	}
+
+	if cfg.EagerKeyErasure != nil {
+		device.log.Verbosef("%v - API: Updating eager key erasure", peer.Peer)
+		peer.eagerKeyErasure.Store(*cfg.EagerKeyErasure)
+	}
+
+	if cfg.ResponseData != nil {
+		device.log.Verbosef("%v - API: Updating response data", peer.Peer)
+		if err := peer.SetResponseData(*cfg.ResponseData); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set response data: %w", err)
+		}
+	}
+
+	if cfg.Assignment != nil {
+		device.log.Verbosef("%v - API: Updating assignment", peer.Peer)
+		peer.ClearAssignment()
+		for _, prefix := range cfg.Assignment.Addresses {
+			peer.addAssignedAddress(prefix)
+			peer.allowedIPs = append(peer.allowedIPs, hostPrefix(prefix))
+		}
+		for _, ip := range cfg.Assignment.DNS {
+			peer.AssignDNS(ip)
+		}
+	}
+
+	if cfg.AcceptAssignment != nil {
+		device.log.Verbosef("%v - API: Updating accept assignment", peer.Peer)
+		peer.setAcceptAssignment(*cfg.AcceptAssignment)
+	}
+
+	if cfg.AcceptAssignmentSubnets != nil {
+		device.log.Verbosef("%v - API: Updating accepted assignment subnets", peer.Peer)
+		peer.ClearAcceptedAssignmentSubnets()
+		for _, prefix := range *cfg.AcceptAssignmentSubnets {
+			peer.AcceptAssignmentSubnet(prefix)
+		}
+	}
+
+	if cfg.EndpointFallback != nil {
+		device.log.Verbosef("%v - API: Updating endpoint fallback", peer.Peer)
+		peer.setEndpointFallback(*cfg.EndpointFallback)
+	}
+
+	if cfg.SwitchPolicy != nil && !peer.dummy {
+		device.log.Verbosef("%v - API: Updating endpoint switching policy", peer.Peer)
+		if err := peer.SetSwitchPolicy(*cfg.SwitchPolicy); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid endpoint switching policy: %w", err)
+		}
+	}
+
+	if cfg.CipherSuite != nil {
+		device.log.Verbosef("%v - API: Updating cipher suite", peer.Peer)
+		if err := peer.SetCipherSuite(*cfg.CipherSuite); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set cipher suite: %w", err)
+		}
+		device.recordAudit("api", "cipher_suite", fmt.Sprintf("%x %s", cfg.PublicKey[:], *cfg.CipherSuite))
+	}
+
+	if cfg.CipherSuiteOffer != nil {
+		device.log.Verbosef("%v - API: Updating cipher suite offer", peer.Peer)
+		if err := peer.SetCipherSuiteOffer(*cfg.CipherSuiteOffer); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set cipher suite offer: %w", err)
+		}
+	}
+
+	if cfg.PQMode != nil {
+		device.log.Verbosef("%v - API: Updating post-quantum mode", peer.Peer)
+		if err := peer.SetPQMode(*cfg.PQMode); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set post-quantum mode: %w", err)
+		}
+	}
+
+	if cfg.Name != nil {
+		device.log.Verbosef("%v - API: Updating name", peer.Peer)
+		if err := peer.SetName(*cfg.Name); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid name %q: %w", *cfg.Name, err)
+		}
+	}
+
+	if cfg.Metadata != nil && !peer.dummy {
+		device.log.Verbosef("%v - API: Updating metadata", peer.Peer)
+		if err := peer.SetMetadata(*cfg.Metadata); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set metadata: %w", err)
+		}
+	}
+
+	if cfg.ReplaceAllowedIPs {
+		device.log.Verbosef("%v - API: Removing all allowedips", peer.Peer)
+		peer.replaceAllowedIPs = true
+	}
+	if len(cfg.AllowedIPs) > 0 {
+		device.log.Verbosef("%v - API: Adding allowedips", peer.Peer)
+		peer.allowedIPs = append(peer.allowedIPs, cfg.AllowedIPs...)
+	}
+	return nil
+}
+
+// Status returns a typed snapshot of the device configuration and peer
+// statistics, equivalent to the output of IpcGet.
+func (device *Device) Status() *DeviceStatus {
+	device.ipcMutex.RLock()
+	defer device.ipcMutex.RUnlock()
+
+	device.net.RLock()
+	defer device.net.RUnlock()
+
+	device.staticIdentity.RLock()
+	defer device.staticIdentity.RUnlock()
+
+	device.peers.RLock()
+	defer device.peers.RUnlock()
+
+	status := &DeviceStatus{
+		PrivateKey:          device.staticIdentity.privateKey,
+		PublicKey:           device.staticIdentity.publicKey,
+		ListenPort:          int(device.net.port),
+		ListenPorts:         device.portStatusLocked(),
+		KnockSecret:         device.KnockSecret(),
+		FirewallMark:        int(device.net.fwmark),
+		NoiseConstruction:   device.staticIdentity.construction,
+		NoiseIdentifier:     device.staticIdentity.identifier,
+		HandshakeHash:       device.handshakeHash().Name(),
+		Experimental:        device.isExperimentalLocked(),
+		Cookie:              device.CookieStats(),
+		Congestion:          device.CongestionStats(),
+		WorkerCrashes:       device.WorkerCrashes(),
+		EncryptionScheduled: device.EncryptionScheduled(),
+		Quarantine:          device.QuarantinePolicy(),
+		PacketTrace:         device.PacketTracePolicy(),
+		Timestamps:          device.TimestampPolicy(),
+		IndexShard:          device.IndexShard(),
+		HandshakePadding:    device.HandshakePadding(),
+		CipherSuite:         device.CipherSuite(),
+		CookieMAC:           device.CookieMAC(),
+		BindOverhead:        device.BindOverhead(),
+		KeystreamPrecompute: device.KeystreamPrecompute(),
+		Keystream:           device.KeystreamStats(),
+		ChaChaRounds:        device.ChaChaRounds(),
+		CoreAffinity:        device.CoreAffinity(),
+		LogLevels:           device.LogLevels(),
+		Cores:               device.CoreStats(),
+		ICMPErrors:          device.ICMPErrorPolicy(),
+		NestedAddress:       device.nested.addr,
+		NestedHandoffs:      device.NestedHandoffs(),
+		Peers:               make([]PeerStatus, 0, len(device.peers.keyMap)),
+	}
+
+	for _, peer := range device.peers.keyMap {
+		ps := PeerStatus{
+			PersistentKeepaliveInterval: time.Duration(peer.persistentKeepaliveInterval.Load()) * time.Second,
+			MSSClampMTU:                 int(peer.mssClampMTU.Load()),
+			MaxQueueAge:                 peer.MaxQueueAge(),
+			StaleDrops:                  peer.StaleDrops(),
+			PacingRate:                  peer.PacingRate(),
+			PacingEstimate:              peer.PacingEstimate(),
+			EagerKeyErasure:             peer.eagerKeyErasure.Load(),
+			EndpointFallback:            peer.endpointFallback.Load(),
+			SwitchPolicy:                peer.SwitchPolicy(),
+			CipherSuite:                 peer.CipherSuite(),
+			ActiveCipherSuite:           peer.ActiveCipherSuite(),
+			CipherSuiteOffer:            peer.CipherSuiteOffer(),
+			PQMode:                      peer.PQMode(),
+			HybridSession:               peer.HybridSession(),
+			DecryptFailures:             peer.quarantine.decryptFailures.Load(),
+			ReplayHits:                  peer.quarantine.replayHits.Load(),
+			MalformedPackets:            peer.quarantine.malformedPackets.Load(),
+			QuarantinedUntil:            peer.QuarantinedUntil(),
+			ICMPErrors:                  peer.ICMPErrors(),
+			PathUnreachableUntil:        peer.PathUnreachableUntil(),
+			ReceivedResponseData:        peer.ResponseData(),
+			AcceptAssignment:            peer.acceptAssignment.Load(),
+			AcceptAssignmentSubnets:     peer.AcceptedAssignmentSubnets(),
+			ReceivedAssignment:          peer.ReceivedAssignment(),
+			Name:                        peer.Name(),
+			Metadata:                    peer.Metadata(),
+			MetadataRejections:          peer.metadataRejections.Load(),
+			InboundLimit:                peer.InboundLimit(),
+			EncryptionWeight:            peer.EncryptionWeight(),
+			PacketSizes:                 peer.PacketSizes(),
+			Reorder:                     peer.ReorderStats(),
+			KeyUsage:                    peer.KeyUsageForecast(),
+			WorkerCrashes:               peer.WorkerCrashes(),
+			ReceiveBytes:                int64(peer.rxBytes.Load()),
+			TransmitBytes:               int64(peer.txBytes.Load()),
+			ProtocolVersion:             1,
+		}
+		ps.InboundRateLimited, ps.InboundFailedLimited = peer.InboundLimited()
+		if keypair := peer.keypairs.Current(); keypair != nil {
+			ps.ActiveTagSize, ps.ActiveSecurityBits = keypair.suite.Overhead(), keypair.suite.Security()
+		}
+		peer.handshake.mutex.RLock()
+		ps.PublicKey = peer.handshake.remoteStatic
+		ps.PresharedKey = peer.handshake.presharedKey
+		ps.PSKRotator = peer.handshake.pskRotator
+		ps.ChannelBinding = peer.handshake.channelBinding
+		ps.MAC1PublicKey = peer.mac1PublicKey
+		peer.handshake.mutex.RUnlock()
+		peer.assignment.Lock()
+		ps.Assignment = peer.assignment.offer.clone()
+		peer.assignment.Unlock()
+		if ps.SwitchPolicy.ProbeInterval != 0 {
+			ps.EndpointRTTs = peer.EndpointRTTs()
+		}
+		ps.StagedQueueSize, ps.StagedEvictionPolicy = peer.StagedQueue()
+		for policy := range numEvictionPolicies {
+			if n := peer.StagedDrops(policy); n != 0 {
+				if ps.StagedDrops == nil {
+					ps.StagedDrops = make(map[StagedEvictionPolicy]uint64)
+				}
+				ps.StagedDrops[policy] = n
+			}
+		}
+		if data := peer.responseData.send.Load(); data != nil {
+			ps.ResponseData = append([]byte(nil), (*data)...)
+		}
+		peer.endpoint.Lock()
+		if peer.endpoint.val != nil {
+			ps.Endpoint = peer.endpoint.val.DstToString()
+		}
+		peer.endpoint.Unlock()
+		if nano := peer.lastHandshakeNano.Load(); nano != 0 {
+			ps.LastHandshakeTime = time.Unix(0, nano)
+		}
+		device.allowedips.EntriesForPeer(peer, func(prefix netip.Prefix) bool {
+			ps.AllowedIPs = append(ps.AllowedIPs, prefix)
+			return true
+		})
+		status.Peers = append(status.Peers, ps)
+	}
+	return status
+}
@@ -0,0 +1,113 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"fmt"
+	"math/rand"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func TestConfigureTypedAPI(t *testing.T) {
+	var sks [2]NoisePrivateKey
+	for i := range sks {
+		if _, err := rand.Read(sks[i][:]); err != nil {
+			t.Fatal(err)
+		}
+		sks[i].clamp()
+	}
+	binds := bindtest.NewChannelBinds()
+	var pair testPair
+	for i := range pair {
+		p := &pair[i]
+		p.tun = tuntest.NewChannelTUN()
+		p.ip = netip.AddrFrom4([4]byte{1, 0, 0, byte(i + 1)})
+		p.dev = NewDevice(p.tun.TUN(), binds[i], NewLogger(LogLevelError, fmt.Sprintf("dev%d: ", i)))
+		t.Cleanup(p.dev.Close)
+
+		port := 0
+		err := p.dev.Configure(Config{
+			PrivateKey:   &sks[i],
+			ListenPort:   &port,
+			ReplacePeers: true,
+			Peers: []PeerConfig{{
+				PublicKey:         sks[i^1].publicKey(),
+				ReplaceAllowedIPs: true,
+				AllowedIPs:        []netip.Prefix{netip.PrefixFrom(netip.AddrFrom4([4]byte{1, 0, 0, byte((i ^ 1) + 1)}), 32)},
+			}},
+		})
+		if err != nil {
+			t.Fatalf("failed to configure device %d: %v", i, err)
+		}
+		if err := p.dev.Up(); err != nil {
+			t.Fatalf("failed to bring up device %d: %v", i, err)
+		}
+	}
+	for i := range pair {
+		endpoint := netip.AddrPortFrom(netip.AddrFrom4([4]byte{127, 0, 0, 1}), pair[i^1].dev.net.port)
+		err := pair[i].dev.Configure(Config{Peers: []PeerConfig{{
+			PublicKey:  sks[i^1].publicKey(),
+			UpdateOnly: true,
+			Endpoint:   &endpoint,
+		}}})
+		if err != nil {
+			t.Fatalf("failed to set endpoint on device %d: %v", i, err)
+		}
+	}
+
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+
+	keepalive := 25 * time.Second
+	err := pair[0].dev.Configure(Config{Peers: []PeerConfig{{
+		PublicKey:                   sks[1].publicKey(),
+		PersistentKeepaliveInterval: &keepalive,
+	}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := pair[0].dev.Status()
+	if status.PublicKey != sks[0].publicKey() {
+		t.Errorf("status public key = %x, want %x", status.PublicKey, sks[0].publicKey())
+	}
+	if len(status.Peers) != 1 {
+		t.Fatalf("status has %d peers, want 1", len(status.Peers))
+	}
+	ps := status.Peers[0]
+	if ps.PublicKey != sks[1].publicKey() {
+		t.Errorf("peer public key = %x, want %x", ps.PublicKey, sks[1].publicKey())
+	}
+	if ps.PersistentKeepaliveInterval != 25*time.Second {
+		t.Errorf("peer keepalive = %v, want 25s", ps.PersistentKeepaliveInterval)
+	}
+	if len(ps.AllowedIPs) != 1 || ps.AllowedIPs[0].String() != "1.0.0.2/32" {
+		t.Errorf("peer allowed IPs = %v, want [1.0.0.2/32]", ps.AllowedIPs)
+	}
+	if ps.LastHandshakeTime.IsZero() || ps.ReceiveBytes == 0 || ps.TransmitBytes == 0 {
+		t.Errorf("peer has no traffic statistics after ping: %+v", ps)
+	}
+
+	// UpdateOnly must not create unknown peers.
+	var unknown NoisePublicKey
+	unknown[0] = 1
+	if err := pair[0].dev.Configure(Config{Peers: []PeerConfig{{PublicKey: unknown, UpdateOnly: true}}}); err != nil {
+		t.Fatal(err)
+	}
+	if pair[0].dev.LookupPeer(unknown) != nil {
+		t.Error("UpdateOnly created a new peer")
+	}
+
+	bad := 1 << 16
+	if err := pair[0].dev.Configure(Config{ListenPort: &bad}); err == nil {
+		t.Error("out of range listen port was accepted")
+	}
+}
@@ -0,0 +1,119 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+/* Send path congestion
+ *
+ * When the socket runs out of buffer space, sends fail with EAGAIN or
+ * ENOBUFS and the packets they carried are lost after having been encrypted.
+ * Once CongestionThreshold sends in a row fail that way, the device is
+ * congested for CongestionHoldTime, renewed by every further failure. While
+ * congested, the TUN reader waits CongestionBackoff before each read, so that
+ * packets queue, and are eventually dropped, in the TUN device before any
+ * work is spent on them; and it marks the ECN-capable packets it reads as
+ * having experienced congestion, so that their senders slow down as they
+ * would for a congested router.
+ */
+
+// CongestionStats describes how congestion of the send path has been
+// engaging.
+type CongestionStats struct {
+	Congested    bool
+	SendFailures uint64 // sends that failed for lack of socket buffer space
+	Backoffs     uint64 // TUN reads delayed while congested
+	Marked       uint64 // packets marked as having experienced congestion
+}
+
+func (stats CongestionStats) isZero() bool {
+	return stats == CongestionStats{}
+}
+
+type congestionState struct {
+	failures     atomic.Int32 // consecutive sends that failed for lack of buffer space
+	until        atomic.Int64 // Unix time in nanoseconds until which the device is congested
+	sendFailures atomic.Uint64
+	backoffs     atomic.Uint64
+	marked       atomic.Uint64
+}
+
+// isCongestionError reports whether a send failed for lack of buffer space.
+func isCongestionError(err error) bool {
+	return errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.ENOBUFS)
+}
+
+// noteSendResult records the outcome of a send to the bind.
+func (device *Device) noteSendResult(err error) {
+	c := &device.congestion
+	if err == nil {
+		if c.failures.Load() != 0 {
+			c.failures.Store(0)
+		}
+		return
+	}
+	if !isCongestionError(err) {
+		return
+	}
+	c.sendFailures.Add(1)
+	if c.failures.Add(1) >= CongestionThreshold {
+		if !device.congested() {
+			device.log.transport.Verbosef("Send path congested: %v", err)
+		}
+		c.until.Store(time.Now().Add(CongestionHoldTime).UnixNano())
+	}
+}
+
+// congested reports whether sends recently failed for lack of buffer space.
+func (device *Device) congested() bool {
+	return time.Now().UnixNano() < device.congestion.until.Load()
+}
+
+// CongestionStats returns statistics of send path congestion.
+func (device *Device) CongestionStats() CongestionStats {
+	c := &device.congestion
+	return CongestionStats{
+		Congested:    device.congested(),
+		SendFailures: c.sendFailures.Load(),
+		Backoffs:     c.backoffs.Load(),
+		Marked:       c.marked.Load(),
+	}
+}
+
+// markCongestionExperienced sets the ECN field of an ECN-capable IP packet
+// to CE, reporting whether it did.
+func markCongestionExperienced(packet []byte) bool {
+	const ecnCE = 0x3
+	switch packet[0] >> 4 {
+	case 4:
+		ecn := packet[1] & 0x3
+		if ecn == 0 || ecn == ecnCE {
+			return false
+		}
+		// Incremental checksum update, per RFC 1624, equation 3.
+		old := binary.BigEndian.Uint16(packet[0:2])
+		packet[1] |= ecnCE
+		sum := uint32(^binary.BigEndian.Uint16(packet[10:12])) + uint32(^old) + uint32(binary.BigEndian.Uint16(packet[0:2]))
+		sum = sum&0xffff + sum>>16
+		sum = sum&0xffff + sum>>16
+		binary.BigEndian.PutUint16(packet[10:12], ^uint16(sum))
+		return true
+	case 6:
+		ecn := packet[1] >> 4 & 0x3
+		if ecn == 0 || ecn == ecnCE {
+			return false
+		}
+		packet[1] |= ecnCE << 4
+		return true
+	}
+	return false
+}
@@ -0,0 +1,104 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+// ipv4HeaderSum returns the internet checksum of an IPv4 header, which is
+// zero if the header checksum is valid.
+func ipv4HeaderSum(header []byte) uint16 {
+	var sum uint32
+	for i := 0; i < len(header); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(header[i:]))
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+func TestMarkCongestionExperienced(t *testing.T) {
+	ping := func(ecn byte) []byte {
+		packet := tuntest.Ping(netip.AddrFrom4([4]byte{1, 0, 0, 1}), netip.AddrFrom4([4]byte{1, 0, 0, 2}))
+		packet[1] |= ecn
+		binary.BigEndian.PutUint16(packet[10:12], 0)
+		binary.BigEndian.PutUint16(packet[10:12], ipv4HeaderSum(packet[:20]))
+		return packet
+	}
+	for _, tt := range []struct {
+		ecn, want byte
+		marked    bool
+	}{
+		{0, 0, false},
+		{1, 3, true},
+		{2, 3, true},
+		{3, 3, false},
+	} {
+		packet := ping(tt.ecn)
+		if marked := markCongestionExperienced(packet); marked != tt.marked {
+			t.Errorf("IPv4 ECN %d: marked = %t", tt.ecn, marked)
+		}
+		if ecn := packet[1] & 3; ecn != tt.want {
+			t.Errorf("IPv4 ECN %d: marked as %d, want %d", tt.ecn, ecn, tt.want)
+		}
+		if sum := ipv4HeaderSum(packet[:20]); sum != 0 {
+			t.Errorf("IPv4 ECN %d: header checksum off by %#04x", tt.ecn, sum)
+		}
+
+		packet = make([]byte, 40)
+		packet[0], packet[1] = 0x60|0xb, tt.ecn<<4|0x8 // DSCP 46, flow label 0x8....
+		if marked := markCongestionExperienced(packet); marked != tt.marked {
+			t.Errorf("IPv6 ECN %d: marked = %t", tt.ecn, marked)
+		}
+		if packet[0] != 0x6b || packet[1] != tt.want<<4|0x8 {
+			t.Errorf("IPv6 ECN %d: marked as %x", tt.ecn, packet[:2])
+		}
+	}
+}
+
+func TestCongestion(t *testing.T) {
+	dev := randDevice(t)
+	defer dev.Close()
+
+	enobufs := &net.OpError{Op: "write", Net: "udp", Err: os.NewSyscallError("sendmsg", syscall.ENOBUFS)}
+	for range CongestionThreshold - 1 {
+		dev.noteSendResult(enobufs)
+	}
+	dev.noteSendResult(nil)
+	dev.noteSendResult(os.ErrClosed)
+	for range CongestionThreshold - 1 {
+		dev.noteSendResult(syscall.EAGAIN)
+	}
+	if dev.congested() {
+		t.Fatal("congested without consecutive failures")
+	}
+	dev.noteSendResult(enobufs)
+	if !dev.congested() {
+		t.Fatal("not congested after consecutive failures")
+	}
+
+	stats := dev.CongestionStats()
+	if want := (CongestionStats{Congested: true, SendFailures: 2*CongestionThreshold - 1}); stats != want {
+		t.Errorf("stats = %+v, want %+v", stats, want)
+	}
+	cfg, err := dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(cfg, "congested=true\n") {
+		t.Errorf("get output lacks congestion state:\n%s", cfg)
+	}
+}
@@ -27,14 +27,88 @@ const (
 )
 
 const (
-	MinMessageSize = MessageKeepaliveSize                  // minimum size of transport message (keepalive)
-	MaxMessageSize = MaxSegmentSize                        // maximum size of transport message
-	MaxContentSize = MaxSegmentSize - MessageTransportSize // maximum size of transport message content
+	MinMessageSize = MinMessageTransportSize                                  // minimum size of transport message (keepalive)
+	MaxMessageSize = MaxSegmentSize                                           // maximum size of transport message
+	MaxContentSize = MaxSegmentSize - MessageTransportHeaderSize - MaxTagSize // maximum size of transport message content, with any suite's tag
 )
 
 /* Implementation constants */
 
 const (
-	UnderLoadAfterTime = time.Second // how long does the device remain under load after detected
-	MaxPeers           = 1 << 16     // maximum number of configured peers
+	UnderLoadAfterTime  = time.Second      // how long does the device remain under load after detected
+	MaxPeers            = 1 << 16          // maximum number of configured peers
+	ObserveInterval     = time.Second      // interval between state snapshots sent to UAPI observers
+	MinMSSClampMTU      = 576              // smallest per-peer MTU accepted for TCP MSS clamping
+	MaxPeerNameLength   = 64               // maximum length in bytes of a peer's display name
+	MaxAuditEntries     = 1024             // maximum number of audit trail entries kept
+	WorkerIdleTimeout   = time.Second      // how long surplus crypto workers wait for work before exiting
+	PingTimeout         = RekeyTimeout     // how long a UAPI ping_peer waits for the echo reply
+	MaxListenPorts      = 16               // maximum number of simultaneous listening ports
+	KnockWindow         = 30 * time.Second // maximum clock difference accepted for knock timestamps
+	KnockLifetime       = RejectAfterTime  // how long a knock or accepted initiation authorizes its source
+	MaxKnockSources     = 4096             // maximum number of authorized knock sources and remembered nonces
+	MaxLoadTransitions  = 64               // maximum number of under load transitions kept for cookie statistics
+	MaxResponseDataSize = 256              // maximum size of data attached to a handshake response
+
+	MaxEndpointHistory          = 4                // maximum number of previous endpoints kept per peer for fallback
+	EndpointHistoryLifetime     = RejectAfterTime  // how long a previous endpoint remains eligible for fallback
+	MinSwitchProbeInterval      = time.Second      // shortest interval between endpoint switching probe rounds
+	DefaultSwitchMinImprovement = 20               // default percentage by which a candidate endpoint must be faster
+	DefaultSwitchDwell          = 30 * time.Second // default time a candidate endpoint must stay faster before switching
+
+	BenchmarkDuration = 200 * time.Millisecond // how long each measurement of a UAPI benchmark runs
+	HandshakeTimeout  = 3 * RekeyTimeout       // how long a UAPI handshake_peer waits without a given timeout
+
+	DefaultQuarantineWindow   = 10 * time.Second // default interval over which a peer's invalid packets are counted
+	DefaultQuarantineCooldown = time.Minute      // default time a quarantined peer's packets are dropped
+
+	MinPacingRate          = 100_000                // lowest configurable pacing rate in bits per second
+	PacingQuantum          = time.Millisecond       // data sent back to back when pacing, at least two packets
+	PacingSamples          = 10                     // delivery rates over which the bottleneck bandwidth is estimated
+	DeliveryReportInterval = 100 * time.Millisecond // shortest interval between delivery reports to an automatically pacing peer
+	DeliveryReportLifetime = 2 * time.Second        // how long a delivery request asks for reports
+
+	ReplicationInterval = 100 * time.Millisecond // interval between session updates sent to a standby
+	ReplicationLease    = 1 << 20                // messages a primary may send with a replicated session beyond its send counter
+	MaxReplicationFrame = 1 << 24                // maximum size of a frame of the replication stream
+
+	CongestionThreshold = 8                      // consecutive sends failing for lack of buffer space that congest the device
+	CongestionHoldTime  = 100 * time.Millisecond // how long the device remains congested after a failed send
+	CongestionBackoff   = time.Millisecond       // how long the TUN reader waits before each read while congested
+
+	MTUWatchWindow            = 10 * time.Second // interval over which signs of MTU misconfiguration are counted
+	MTUWatchMinPackets        = 32               // full-size packets sent in a window before retransmits are judged
+	MTUWatchRetransmitPercent = 5                // percentage of full-size packets retransmitted that suggests a too large MTU
+	MTUWatchSegments          = 64               // full-size TCP segments remembered per peer to detect retransmits
+
+	KeyUsageSampleInterval = time.Second      // shortest interval between measurements of a keypair's sending rate
+	RekeyForecastLead      = 3 * RekeyTimeout // how long before a keypair is forecast to run out of messages a rekey starts
+
+	InboundLimitBurst = time.Second // traffic at a peer's inbound rates its token buckets hold
+
+	DefaultPageSize = 1000  // peers listed by a page of a paginated get, unless asked otherwise
+	MaxPageSize     = 10000 // maximum peers listed by a page of a paginated get
+
+	MinTagSize = 8  // bytes of the shortest transport tag a cipher suite may truncate to
+	MaxTagSize = 32 // bytes of the longest transport tag a cipher suite may add, that of DoublePoly1305
+
+	MaxTranscriptWindow  = time.Hour // longest window a session transcript may record
+	MaxTranscriptPackets = 1 << 20   // packets after which a session transcript ends early
+
+	DefaultEncryptionWeight = 100  // weight of a peer's share of saturated encryption workers, unless configured
+	MaxEncryptionWeight     = 1000 // maximum weight of a peer's share of saturated encryption workers
+
+	MaxHandshakePadding = 1024 // maximum size handshake messages may be padded to
+
+	MaxKeystreamPrecompute  = 1024 // maximum counters whose keystream is precomputed for each keypair
+	KeystreamPrecomputeSize = 256  // bytes of content of the packets sealed with precomputed keystream
+
+	ICMPUnreachableTimeout = 10 * time.Second // how long an ICMP error marks the path to a peer unreachable
+	ICMPReplyInterval      = time.Millisecond // minimum time between ICMP errors written to the TUN device
+	QueueICMPErrorSize     = 64               // ICMP errors awaiting handling; more are dropped
+
+	MaxPeerMetadataSize = 4096 // maximum size of the metadata attached to a peer
+
+	PipelineInterval   = 250 * time.Millisecond // interval between blocks sent to UAPI pipeline observers
+	MaxPacketTraceSize = 1 << 16                // maximum number of packet decision records kept
 )
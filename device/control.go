@@ -0,0 +1,149 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+/* Control messages are carried inside transport messages, encrypted and
+ * authenticated with the session keys exactly like tunneled packets. They
+ * are told apart from IP packets by a zero version nibble in the first
+ * byte of the plaintext, which holds the control message type. Peers that
+ * do not understand control messages drop them as packets with an invalid
+ * IP version, so senders must tolerate receiving no answer.
+ */
+
+const (
+	ControlEchoRequestType = 0x01
+	ControlEchoReplyType   = 0x02
+)
+
+const (
+	ControlEchoSize = 1 + 8 // type, identifier
+)
+
+type peerPings struct {
+	sync.Mutex
+	waiting map[uint64]chan struct{}
+}
+
+// sendControl queues a control message for transmission to the peer,
+// initiating a handshake first if there is no current session.
+func (peer *Peer) sendControl(msg []byte) bool {
+	return peer.sendControlTo(msg, nil)
+}
+
+// sendControlTo is like sendControl, but sends the message to endpoint
+// rather than the peer's current endpoint if endpoint is not nil.
+func (peer *Peer) sendControlTo(msg []byte, endpoint conn.Endpoint) bool {
+	if !peer.isRunning.Load() {
+		return false
+	}
+	elem := peer.device.NewOutboundElement()
+	elem.packet = elem.buffer[MessageTransportHeaderSize : MessageTransportHeaderSize+len(msg)]
+	copy(elem.packet, msg)
+	elemsContainer := peer.device.GetOutboundElementsContainer()
+	elemsContainer.elems = append(elemsContainer.elems, elem)
+	elemsContainer.endpoint = endpoint
+	peer.StagePackets(elemsContainer)
+	peer.SendStagedPackets()
+	return true
+}
+
+// handleControl processes a decrypted control message from the peer.
+func (peer *Peer) handleControl(msg []byte) {
+	switch msg[0] {
+	case ControlEchoRequestType:
+		if len(msg) < ControlEchoSize {
+			return
+		}
+		var reply [ControlEchoSize]byte
+		reply[0] = ControlEchoReplyType
+		copy(reply[1:], msg[1:ControlEchoSize])
+		peer.device.log.transport.Verbosef("%v - Replying to echo request", peer)
+		peer.sendControl(reply[:])
+
+	case ControlEchoReplyType:
+		if len(msg) < ControlEchoSize {
+			return
+		}
+		id := binary.LittleEndian.Uint64(msg[1:])
+		peer.pings.Lock()
+		if done, ok := peer.pings.waiting[id]; ok {
+			delete(peer.pings.waiting, id)
+			close(done)
+		}
+		peer.pings.Unlock()
+
+	case ControlAssignRequestType:
+		peer.handleAssignRequest()
+
+	case ControlAssignmentType:
+		peer.handleAssignment(msg[1:])
+
+	case ControlDeliveryRequestType:
+		peer.handleDeliveryRequest()
+
+	case ControlDeliveryReportType:
+		if len(msg) < ControlDeliveryReportSize {
+			return
+		}
+		peer.handleDeliveryReport(msg)
+	}
+}
+
+// Ping sends an echo request to the peer's WireGuard layer and waits for the
+// reply, returning the round-trip time. If no session is established, the
+// measurement includes the time taken to complete a handshake.
+func (peer *Peer) Ping(timeout time.Duration) (time.Duration, error) {
+	return peer.ping(timeout, nil)
+}
+
+// ping sends the echo request of Ping to endpoint, or to the peer's current
+// endpoint if endpoint is nil.
+func (peer *Peer) ping(timeout time.Duration, endpoint conn.Endpoint) (time.Duration, error) {
+	var msg [ControlEchoSize]byte
+	msg[0] = ControlEchoRequestType
+	if _, err := rand.Read(msg[1:]); err != nil {
+		return 0, err
+	}
+	id := binary.LittleEndian.Uint64(msg[1:])
+	done := make(chan struct{})
+
+	peer.pings.Lock()
+	if peer.pings.waiting == nil {
+		peer.pings.waiting = make(map[uint64]chan struct{})
+	}
+	peer.pings.waiting[id] = done
+	peer.pings.Unlock()
+	defer func() {
+		peer.pings.Lock()
+		delete(peer.pings.waiting, id)
+		peer.pings.Unlock()
+	}()
+
+	start := time.Now()
+	if !peer.sendControlTo(msg[:], endpoint) {
+		return 0, ErrPeerNotRunning
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return time.Since(start), nil
+	case <-timer.C:
+		return 0, fmt.Errorf("%w waiting for echo reply", ErrTimeout)
+	case <-peer.device.closed:
+		return 0, ErrDeviceClosed
+	}
+}
@@ -17,6 +17,7 @@ import (
 
 type CookieChecker struct {
 	sync.RWMutex
+	mac  CookieMAC
 	mac1 struct {
 		key [blake2s.Size]byte
 	}
@@ -29,6 +30,7 @@ type CookieChecker struct {
 
 type CookieGenerator struct {
 	sync.RWMutex
+	mac  CookieMAC
 	mac1 struct {
 		key [blake2s.Size]byte
 	}
@@ -75,10 +77,7 @@ func (st *CookieChecker) CheckMAC1(msg []byte) bool {
 	smac1 := smac2 - blake2s.Size128
 
 	var mac1 [blake2s.Size128]byte
-
-	mac, _ := blake2s.New128(st.mac1.key[:])
-	mac.Write(msg[:smac1])
-	mac.Sum(mac1[:0])
+	st.mac.sum(&mac1, st.mac1.key[:], msg[:smac1])
 
 	return hmac.Equal(mac1[:], msg[smac1:smac2])
 }
@@ -94,22 +93,14 @@ func (st *CookieChecker) CheckMAC2(msg, src []byte) bool {
 	// derive cookie key
 
 	var cookie [blake2s.Size128]byte
-	func() {
-		mac, _ := blake2s.New128(st.mac2.secret[:])
-		mac.Write(src)
-		mac.Sum(cookie[:0])
-	}()
+	st.mac.sum(&cookie, st.mac2.secret[:], src)
 
 	// calculate mac of packet (including mac1)
 
 	smac2 := len(msg) - blake2s.Size128
 
 	var mac2 [blake2s.Size128]byte
-	func() {
-		mac, _ := blake2s.New128(cookie[:])
-		mac.Write(msg[:smac2])
-		mac.Sum(mac2[:0])
-	}()
+	st.mac.sum(&mac2, cookie[:], msg[:smac2])
 
 	return hmac.Equal(mac2[:], msg[smac2:])
 }
@@ -139,11 +130,7 @@ func (st *CookieChecker) CreateReply(
 	// derive cookie
 
 	var cookie [blake2s.Size128]byte
-	func() {
-		mac, _ := blake2s.New128(st.mac2.secret[:])
-		mac.Write(src)
-		mac.Sum(cookie[:0])
-	}()
+	st.mac.sum(&cookie, st.mac2.secret[:], src)
 
 	// encrypt cookie
 
@@ -226,11 +213,7 @@ func (st *CookieGenerator) AddMacs(msg []byte) {
 
 	// set mac1
 
-	func() {
-		mac, _ := blake2s.New128(st.mac1.key[:])
-		mac.Write(msg[:smac1])
-		mac.Sum(mac1[:0])
-	}()
+	st.mac.sum((*[blake2s.Size128]byte)(mac1), st.mac1.key[:], msg[:smac1])
 	copy(st.mac2.lastMAC1[:], mac1)
 	st.mac2.hasLastMAC1 = true
 
@@ -240,9 +223,23 @@ func (st *CookieGenerator) AddMacs(msg []byte) {
 		return
 	}
 
-	func() {
-		mac, _ := blake2s.New128(st.mac2.cookie[:])
-		mac.Write(msg[:smac2])
-		mac.Sum(mac2[:0])
-	}()
+	st.mac.sum((*[blake2s.Size128]byte)(mac2), st.mac2.cookie[:], msg[:smac2])
+}
+
+// setMAC sets the MAC mac1 is checked and cookies are made with.
+func (st *CookieChecker) setMAC(mac CookieMAC) {
+	st.Lock()
+	defer st.Unlock()
+	st.mac = mac
+}
+
+// setMAC sets the MAC mac1 and mac2 are computed with. A cookie received
+// before is of no use with another MAC, and is forgotten.
+func (st *CookieGenerator) setMAC(mac CookieMAC) {
+	st.Lock()
+	defer st.Unlock()
+	if st.mac != mac {
+		st.mac = mac
+		st.mac2.cookieSet = time.Time{}
+	}
 }
@@ -6,7 +6,13 @@
 package device
 
 import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"strings"
 	"testing"
+
+	"golang.org/x/crypto/blake2s"
 )
 
 func TestCookieMAC1(t *testing.T) {
@@ -188,3 +194,174 @@ func TestCookieMAC1(t *testing.T) {
 		0x7d, 0xa1, 0xd5, 0x85, 0x6d, 0xf0, 0x1b, 0xaa,
 	})
 }
+
+func TestCookieMAC1PublicKey(t *testing.T) {
+	dev1 := randDevice(t)
+	dev2 := randDevice(t)
+	defer dev1.Close()
+	defer dev2.Close()
+
+	pk2 := dev2.staticIdentity.privateKey.publicKey()
+	peer2, err := dev2.NewPeer(dev1.staticIdentity.privateKey.publicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := dev1.NewPeer(pk2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer.Start()
+	peer2.Start()
+
+	frontSk, err := newPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	front := frontSk.publicKey()
+	var frontChecker CookieChecker
+	frontChecker.Init(front)
+
+	initiation := func() []byte {
+		msg, err := dev1.CreateMessageInitiation(peer)
+		assertNil(t, err)
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.LittleEndian, msg)
+		packet := buf.Bytes()
+		peer.cookieGenerator.AddMacs(packet)
+		return packet
+	}
+
+	packet := initiation()
+	if !dev2.cookieChecker.CheckMAC1(packet) || frontChecker.CheckMAC1(packet) {
+		t.Fatal("MAC1 not computed with the peer's public key by default")
+	}
+
+	hexFront := hex.EncodeToString(front[:])
+	if err := dev1.IpcSet(uapiCfg("public_key", hex.EncodeToString(pk2[:]), "mac1_public_key", hexFront)); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := dev1.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(cfg, "mac1_public_key="+hexFront+"\n") {
+		t.Errorf("get output lacks MAC1 public key:\n%s", cfg)
+	}
+
+	// The front end checks MAC1, and the peer still completes the handshake.
+	packet = initiation()
+	if !frontChecker.CheckMAC1(packet) {
+		t.Fatal("MAC1 not computed with the MAC1 public key")
+	}
+	if dev2.cookieChecker.CheckMAC1(packet) {
+		t.Fatal("MAC1 computed with the peer's public key despite override")
+	}
+	var msg MessageInitiation
+	assertNil(t, binary.Read(bytes.NewReader(packet), binary.LittleEndian, &msg))
+	if dev2.ConsumeMessageInitiation(&msg) == nil {
+		t.Fatal("initiation with fronted MAC1 rejected by the Noise handshake")
+	}
+
+	// Cookie replies from the front end are decrypted with its key.
+	src := []byte{192, 168, 13, 37, 10, 10, 10}
+	reply, err := frontChecker.CreateReply(packet, 1337, src)
+	assertNil(t, err)
+	if !peer.cookieGenerator.ConsumeReply(reply) {
+		t.Fatal("cookie reply from the front end rejected")
+	}
+
+	peer.SetMAC1PublicKey(NoisePublicKey{})
+	if packet := initiation(); !dev2.cookieChecker.CheckMAC1(packet) {
+		t.Fatal("MAC1 not computed with the peer's public key after reset")
+	}
+}
+
+func TestCookieMACSipHash(t *testing.T) {
+	var (
+		generator CookieGenerator
+		checker   CookieChecker
+	)
+	sk, err := newPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk := sk.publicKey()
+	generator.Init(pk)
+	checker.Init(pk)
+	checker.setMAC(CookieMACSipHash)
+
+	msg := make([]byte, MessageInitiationSize)
+	generator.AddMacs(msg)
+	if checker.CheckMAC1(msg) {
+		t.Fatal("BLAKE2s MAC1 accepted by SipHash checker")
+	}
+	generator.setMAC(CookieMACSipHash)
+	generator.AddMacs(msg)
+	if !checker.CheckMAC1(msg) {
+		t.Fatal("SipHash MAC1 rejected")
+	}
+
+	// Cookies and mac2 follow the MAC too.
+	src := []byte{192, 168, 13, 37, 10, 10, 10}
+	reply, err := checker.CreateReply(msg, 1337, src)
+	assertNil(t, err)
+	if !generator.ConsumeReply(reply) {
+		t.Fatal("cookie reply rejected")
+	}
+	generator.AddMacs(msg)
+	if !checker.CheckMAC2(msg, src) {
+		t.Fatal("SipHash MAC2 rejected")
+	}
+	generator.setMAC(CookieMACBLAKE2s)
+	mac2 := msg[len(msg)-blake2s.Size128:]
+	clear(mac2)
+	generator.AddMacs(msg)
+	if !isZero(mac2) {
+		t.Fatal("cookie kept across a change of MAC")
+	}
+}
+
+func TestDeviceCookieMAC(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	mac := CookieMACSipHash
+	if err := pair[0].dev.Configure(Config{CookieMAC: &mac}); err != nil {
+		t.Fatal(err)
+	}
+	if err := pair[1].dev.IpcSet("cookie_mac=siphash\n"); err != nil {
+		t.Fatal(err)
+	}
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+
+	status := pair[0].dev.Status()
+	if status.CookieMAC != CookieMACSipHash || !status.Experimental {
+		t.Errorf("status cookie MAC %v, experimental %v", status.CookieMAC, status.Experimental)
+	}
+	cfg, err := pair[1].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i := strings.Index(cfg, "\ncookie_mac=siphash\n"); i < 0 || i > strings.Index(cfg, "public_key=") {
+		t.Errorf("get output lacks the cookie MAC:\n%s", cfg)
+	}
+	for i := range pair {
+		if entries, _ := pair[i].dev.AuditTrail(); len(entries) != 1 || entries[0].Setting != "cookie_mac" || entries[0].Value != "siphash" {
+			t.Errorf("unexpected audit trail %+v", entries)
+		}
+	}
+
+	if err := pair[0].dev.IpcSet("cookie_mac=blake3\n"); err == nil {
+		t.Error("set unknown cookie MAC")
+	}
+	mac = numCookieMACs
+	if err := pair[0].dev.Configure(Config{CookieMAC: &mac}); err == nil {
+		t.Error("configured invalid cookie MAC")
+	}
+	if err := pair[0].dev.IpcSet("cookie_mac=blake2s\n"); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, _ := pair[0].dev.IpcGet(); strings.Contains(cfg, "\ncookie_mac=") {
+		t.Errorf("get output lists the standard cookie MAC:\n%s", cfg)
+	}
+}
@@ -0,0 +1,93 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"fmt"
+
+	"golang.org/x/crypto/blake2s"
+)
+
+/* Cookie MAC experiment
+ *
+ * The mac1 and mac2 fields of handshake messages, and the cookies mac2 is
+ * keyed with, are 128-bit keyed BLAKE2s MACs. Responders under load check
+ * both before any Diffie-Hellman, so the cost of the MAC bounds how many
+ * handshakes per second a device can turn away. The cookie MAC setting of a
+ * device swaps in another MAC of the same size for all three, to measure
+ * that cost:
+ *
+ *	blake2s   keyed BLAKE2s-128, as in standard WireGuard
+ *	siphash   SipHash-2-4 with 128-bit output, under the first 16 bytes
+ *	          of the 32-byte mac1 key or cookie secret
+ *
+ * SipHash is a fast PRF for short inputs rather than a MAC designed for
+ * adversarial use at this size, and a device with a non-standard cookie MAC
+ * interoperates only with peers set to the same one, so the setting is for
+ * experiments between such peers, and audited. BLAKE3 is not offered: the
+ * implementation in blake3.go, built only with the wg_experimental tag,
+ * leaves out the keyed mode a MAC would be built on.
+ */
+
+// A CookieMAC is the MAC computing mac1, mac2 and cookies.
+type CookieMAC int
+
+const (
+	CookieMACBLAKE2s CookieMAC = iota // keyed BLAKE2s-128, the standard
+	CookieMACSipHash                  // SipHash-2-4-128
+	numCookieMACs
+)
+
+var cookieMACNames = [numCookieMACs]string{"blake2s", "siphash"}
+
+func (mac CookieMAC) String() string {
+	if mac >= 0 && mac < numCookieMACs {
+		return cookieMACNames[mac]
+	}
+	return fmt.Sprintf("CookieMAC(%d)", int(mac))
+}
+
+// ParseCookieMAC returns the MAC named s, as printed by CookieMAC.String.
+func ParseCookieMAC(s string) (CookieMAC, error) {
+	for mac, name := range cookieMACNames {
+		if s == name {
+			return CookieMAC(mac), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown cookie MAC %q", s)
+}
+
+// sum computes in out the MAC of msg under key, which is 16 or 32 bytes.
+func (mac CookieMAC) sum(out *[blake2s.Size128]byte, key, msg []byte) {
+	if mac == CookieMACSipHash {
+		sipHash128(out, key[:sipHashKeySize], msg)
+		return
+	}
+	h, _ := blake2s.New128(key)
+	h.Write(msg)
+	h.Sum(out[:0])
+}
+
+// SetCookieMAC sets the MAC of the mac1 and mac2 fields and cookies of the
+// handshake messages the device and its peers send and check.
+func (device *Device) SetCookieMAC(mac CookieMAC) error {
+	if mac < 0 || mac >= numCookieMACs {
+		return fmt.Errorf("invalid cookie MAC %v", mac)
+	}
+	device.peers.RLock()
+	defer device.peers.RUnlock()
+	device.cookieMAC.Store(int32(mac))
+	device.cookieChecker.setMAC(mac)
+	for _, peer := range device.peers.keyMap {
+		peer.cookieGenerator.setMAC(mac)
+	}
+	return nil
+}
+
+// CookieMAC returns the MAC set with SetCookieMAC.
+func (device *Device) CookieMAC() CookieMAC {
+	return CookieMAC(device.cookieMAC.Load())
+}
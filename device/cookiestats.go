@@ -0,0 +1,88 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A LoadTransition records the device entering or leaving the under load
+// state, in which handshake messages must carry a valid MAC2 cookie.
+type LoadTransition struct {
+	Time      time.Time
+	UnderLoad bool
+}
+
+// CookieStats describes how the cookie mechanism, the DoS defense against
+// handshake floods, has been engaging.
+type CookieStats struct {
+	UnderLoad          bool
+	RepliesSent        uint64 // handshake messages answered with a cookie reply
+	ValidMAC2          uint64 // handshake messages accepted under load with a valid cookie
+	RateLimited        uint64 // handshake messages with a valid cookie dropped by the rate limiter
+	RepliesReceived    uint64 // cookie replies received and decrypted
+	Transitions        []LoadTransition
+	DroppedTransitions uint64 // transitions no longer kept
+}
+
+type cookieCounters struct {
+	repliesSent     atomic.Uint64
+	validMAC2       atomic.Uint64
+	rateLimited     atomic.Uint64
+	repliesReceived atomic.Uint64
+	underLoad       atomic.Bool // last recorded state, readable without the mutex
+
+	load struct {
+		sync.Mutex
+		transitions []LoadTransition
+		dropped     uint64
+	}
+}
+
+// recordLoadState notes the current under load state, logging a transition
+// if it changed. When leaving the state, at is the time it ended.
+func (c *cookieCounters) recordLoadState(underLoad bool, at time.Time) {
+	if c.underLoad.Load() == underLoad {
+		return
+	}
+	c.load.Lock()
+	defer c.load.Unlock()
+	if !c.underLoad.CompareAndSwap(!underLoad, underLoad) {
+		return
+	}
+	if len(c.load.transitions) >= MaxLoadTransitions {
+		copy(c.load.transitions, c.load.transitions[1:])
+		c.load.transitions = c.load.transitions[:len(c.load.transitions)-1]
+		c.load.dropped++
+	}
+	c.load.transitions = append(c.load.transitions, LoadTransition{Time: at, UnderLoad: underLoad})
+}
+
+// CookieStats returns the cookie mechanism counters and the recent history of
+// under load transitions.
+func (device *Device) CookieStats() CookieStats {
+	underLoad := device.IsUnderLoad()
+	c := &device.cookieStats
+	stats := CookieStats{
+		UnderLoad:       underLoad,
+		RepliesSent:     c.repliesSent.Load(),
+		ValidMAC2:       c.validMAC2.Load(),
+		RateLimited:     c.rateLimited.Load(),
+		RepliesReceived: c.repliesReceived.Load(),
+	}
+	c.load.Lock()
+	stats.Transitions = append([]LoadTransition(nil), c.load.transitions...)
+	stats.DroppedTransitions = c.load.dropped
+	c.load.Unlock()
+	return stats
+}
+
+func (s *CookieStats) isZero() bool {
+	return s.RepliesSent == 0 && s.ValidMAC2 == 0 && s.RateLimited == 0 &&
+		s.RepliesReceived == 0 && len(s.Transitions) == 0
+}
@@ -0,0 +1,70 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCookieStats(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	server, client := pair[0].dev, pair[1].dev
+	peer := client.LookupPeer(server.staticIdentity.publicKey)
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+		}
+	}
+
+	// Pretend the server is under load so that initiations need a cookie.
+	server.rate.underLoadUntil.Store(time.Now().Add(time.Minute).UnixNano())
+	peer.SendHandshakeInitiation(false)
+	waitFor("cookie reply", func() bool {
+		return server.CookieStats().RepliesSent == 1 && client.CookieStats().RepliesReceived == 1
+	})
+
+	peer.handshake.mutex.Lock()
+	peer.handshake.lastSentHandshake = time.Now().Add(-(RekeyTimeout + time.Second))
+	peer.handshake.mutex.Unlock()
+	peer.SendHandshakeInitiation(false)
+	waitFor("handshake with cookie", func() bool {
+		return server.CookieStats().ValidMAC2 != 0 && peer.lastHandshakeNano.Load() != 0
+	})
+
+	cfg, err := server.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"under_load=true\n", "cookie_replies_sent=1\n"} {
+		if !strings.Contains(cfg, line) {
+			t.Errorf("get output lacks %q:\n%s", line, cfg)
+		}
+	}
+}
+
+func TestLoadTransitions(t *testing.T) {
+	var c cookieCounters
+	start := time.Now()
+	for i := 0; i < MaxLoadTransitions+2; i++ {
+		c.recordLoadState(i%2 == 0, start.Add(time.Duration(i)*time.Second))
+		c.recordLoadState(i%2 == 0, start.Add(time.Duration(i)*time.Second+time.Millisecond))
+	}
+	c.load.Lock()
+	defer c.load.Unlock()
+	if len(c.load.transitions) != MaxLoadTransitions || c.load.dropped != 2 {
+		t.Fatalf("kept %d transitions, dropped %d", len(c.load.transitions), c.load.dropped)
+	}
+	if first := c.load.transitions[0]; !first.UnderLoad || !first.Time.Equal(start.Add(2*time.Second)) {
+		t.Errorf("unexpected oldest transition: %+v", first)
+	}
+}
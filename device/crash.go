@@ -0,0 +1,67 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"fmt"
+	"runtime/debug"
+)
+
+/* Worker panic recovery
+ *
+ * The handshake, encryption and decryption workers and each peer's
+ * sequential sender and receiver process their work one batch at a time,
+ * and recover from a panic raised while processing a batch, so that an edge
+ * case of an experimental code path, such as a modified MAC, drops a batch
+ * and does not take down the process. Recovery logs the panic with its
+ * stack, counts it for the device and the peer, releases the batch as its
+ * worker would have, and expires the peer's current keypairs, so that the
+ * session the batch belonged to is negotiated anew instead of hitting the
+ * same edge case again. The worker then carries on with the next batch.
+ *
+ * Whatever the worker had done to the batch is abandoned: encryption drops
+ * all of its packets, since some of them may not be encrypted, and a
+ * batch that crashed a sequential routine is not finished.
+ */
+
+// workerCrashed handles r, recovered from a panic of the named routine while
+// processing work for peer, which is nil if unknown.
+func (device *Device) workerCrashed(routine string, r any, peer *Peer) {
+	device.crashes.Add(1)
+	if peer == nil {
+		device.log.Errorf("Routine: %s - recovered from panic: %v\n%s", routine, r, debug.Stack())
+		return
+	}
+	peer.crashes.Add(1)
+	device.log.Errorf("%v - Routine: %s - recovered from panic: %v\n%s", peer, routine, r, debug.Stack())
+	peer.ExpireCurrentKeypairs()
+	device.notifyObservers("worker_crash", peer, "routine="+routine, fmt.Sprintf("panic=%q", fmt.Sprint(r)))
+}
+
+// keypairPeer returns the peer that keypair belongs to, or nil if it is no
+// longer in use.
+func (device *Device) keypairPeer(keypair *Keypair) *Peer {
+	if keypair == nil {
+		return nil
+	}
+	entry := device.indexTable.Lookup(keypair.localIndex)
+	if entry.keypair != keypair {
+		return nil
+	}
+	return entry.peer
+}
+
+// WorkerCrashes returns the number of panics the device's workers recovered
+// from.
+func (device *Device) WorkerCrashes() uint64 {
+	return device.crashes.Load()
+}
+
+// WorkerCrashes returns the number of panics workers recovered from while
+// processing work for the peer.
+func (peer *Peer) WorkerCrashes() uint64 {
+	return peer.crashes.Load()
+}
@@ -0,0 +1,114 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"crypto/cipher"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+// panickingCipherSuite is ChaCha20-Poly1305 that panics on demand.
+const panickingCipherSuite = "TestPanickingChaCha20Poly1305"
+
+var panicSeal, panicOpen atomic.Bool
+
+type panickingAEAD struct {
+	cipher.AEAD
+}
+
+func (a panickingAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if panicSeal.Load() {
+		panic("test seal panic")
+	}
+	return a.AEAD.Seal(dst, nonce, plaintext, additionalData)
+}
+
+func (a panickingAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if panicOpen.Load() {
+		panic("test open panic")
+	}
+	return a.AEAD.Open(dst, nonce, ciphertext, additionalData)
+}
+
+func init() {
+	RegisterCipherSuite(CipherSuite{
+		Name:         panickingCipherSuite,
+		Experimental: true,
+		New: func(key []byte) (AEADSuite, error) {
+			aead, err := chacha20poly1305.New(key)
+			return panickingAEAD{aead}, err
+		},
+	})
+}
+
+func TestWorkerCrashRecovery(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+	time.Sleep(50 * time.Millisecond)
+	suite := panickingCipherSuite
+	for i := range pair {
+		peerKey := pair[i^1].dev.staticIdentity.publicKey
+		if err := pair[i].dev.Configure(Config{Peers: []PeerConfig{{PublicKey: peerKey, CipherSuite: &suite}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+
+	for _, tt := range []struct {
+		name     string
+		trigger  *atomic.Bool
+		crashing int // index of the device whose worker crashes
+	}{
+		{"encryption", &panicSeal, 1},
+		{"decryption", &panicOpen, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dev := pair[tt.crashing].dev
+			peer := dev.LookupPeer(pair[tt.crashing^1].dev.staticIdentity.publicKey)
+			keypair := peer.keypairs.Current()
+			crashes := dev.WorkerCrashes()
+
+			tt.trigger.Store(true)
+			pair[1].tun.Outbound <- tuntest.Ping(pair[0].ip, pair[1].ip)
+			waitFor(t, func() bool { return dev.WorkerCrashes() > crashes })
+			tt.trigger.Store(false)
+			select {
+			case <-pair[0].tun.Inbound:
+				t.Error("packet of crashed batch delivered")
+			default:
+			}
+			if peer.WorkerCrashes() == 0 {
+				t.Error("crash not counted for peer")
+			}
+			if keypair.sendNonce.Load() < RejectAfterMessages {
+				t.Error("keypair of crashed batch not expired")
+			}
+			cfg, err := dev.IpcGet()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(cfg, "\nworker_crashes=") {
+				t.Errorf("get output lacks worker_crashes:\n%s", cfg)
+			}
+
+			// The workers carry on, with a new session.
+			time.Sleep(50 * time.Millisecond)
+			pair.Send(t, Ping, nil)
+			pair.Send(t, Pong, nil)
+			if peer.keypairs.Current() == keypair {
+				t.Error("no new session after crash")
+			}
+		})
+	}
+}
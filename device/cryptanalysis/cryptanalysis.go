@@ -0,0 +1,336 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package cryptanalysis tests reduced-round ChaCha variants for statistical
+// biases, to evaluate the modified quarter round of the device's ChaCha20_24
+// experiment, with its rotations of 10, 14, 6 and 9 and its added increment,
+// against standard ChaCha20.
+//
+// Each driver counts, over random inputs, how often the events of a set of
+// cells happen, such as an output bit of a keystream block flipping with an
+// input bit. Under the hypothesis that the variant is a random function,
+// each cell's count is binomial, and the driver reports the cell furthest
+// from its expected count in standard deviations. A bias is significant when
+// that cell lies beyond the threshold at which the cells of a random
+// function all stay, but with probability Alpha.
+//
+//   - Differential flips each bit of the nonce and counter words, which an
+//     attacker chooses, and counts how often each bit of the keystream block
+//     flips with it.
+//   - Rotational rotates every word of a random state by each amount from 1
+//     to 31 and counts how often each bit of the permuted state is that of
+//     the rotated permutation of the state. Additions, rotations and XORs
+//     alone keep rotational pairs with high probability; constants, such as
+//     the increment, break them.
+//   - Keystream counts the values of each byte of the keystream blocks of
+//     random keys, with a zero nonce and the first 64 counters, for
+//     single-byte biases such as RC4's.
+//
+// A sweep runs a driver from one round on, until the first round count with
+// no significant bias, and the margin of a variant is how many times its
+// configured rounds exceed that count. Like those of package chachamargin,
+// these statistics only find weaknesses; that a variant shows no bias says
+// nothing of attacks beyond them. The variants are those of package
+// chachamargin, and like it the package is only built with the
+// wg_experimental tag.
+package cryptanalysis
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"math/rand/v2"
+
+	"golang.zx2c4.com/wireguard/device/chachamargin"
+)
+
+// Alpha is the probability of a driver reporting a significant bias of a
+// random function.
+const Alpha = 1e-3
+
+// A Target is a ChaCha variant and the layout of its state.
+type Target struct {
+	chachamargin.Variant
+	CounterWord int   // state word of the block counter
+	NonceWords  []int // state words of the nonce
+}
+
+var (
+	// Standard is ChaCha20 as specified in RFC 8439.
+	Standard = Target{Variant: chachamargin.Standard, CounterWord: 12, NonceWords: []int{13, 14, 15}}
+	// Modified is the ChaCha20_24 experiment of the device package, whose
+	// 16-byte nonce comes before its counter.
+	Modified = Target{Variant: chachamargin.Modified, CounterWord: 15, NonceWords: []int{11, 12, 13, 14}}
+	// RotationsOnly is Modified without its increment.
+	RotationsOnly = Target{Variant: withIncrement(chachamargin.Modified, "chacha20_24_rotations_only", 0), CounterWord: 15, NonceWords: []int{11, 12, 13, 14}}
+	// IncrementOnly is Standard with the increment of Modified.
+	IncrementOnly = Target{Variant: withIncrement(chachamargin.Standard, "chacha20_increment_only", chachamargin.Modified.Increment), CounterWord: 12, NonceWords: []int{13, 14, 15}}
+)
+
+func withIncrement(v chachamargin.Variant, name string, increment uint32) chachamargin.Variant {
+	v.Name = name
+	v.Increment = increment
+	return v
+}
+
+// Targets returns Standard, Modified, RotationsOnly and IncrementOnly, so
+// that the effect of each half of the modification can be told apart.
+func Targets() []Target {
+	return []Target{Standard, Modified, RotationsOnly, IncrementOnly}
+}
+
+// sigma is the constant of the first four words of the state.
+var sigma = [4]uint32{0x61707865, 0x3320646e, 0x79622d32, 0x6b206574}
+
+// Config sets how drivers sample.
+type Config struct {
+	Samples int    // random inputs per round count
+	Seed    uint64 // of the random inputs
+}
+
+// A Bias is the count of one cell of a driver.
+type Bias struct {
+	Cell string  // what was counted
+	Bias float64 // ε, the cell's observed probability being p(1+ε) for its probability p of a random function
+	Z    float64 // distance from the expected count, in standard deviations
+}
+
+// A Result is the largest bias a driver found in a target after some rounds.
+type Result struct {
+	Driver    string
+	Target    string
+	Rounds    int
+	Samples   int
+	Cells     int     // biases tested
+	Max       Bias    // of the largest |Z|
+	Threshold float64 // |Z| past which a bias is significant
+}
+
+// Significant reports whether the largest bias of r is past its threshold.
+func (r Result) Significant() bool {
+	return math.Abs(r.Max.Z) > r.Threshold
+}
+
+// counter accumulates the counts of the cells of a driver.
+type counter struct {
+	counts []int
+	trials int     // per cell
+	p      float64 // probability of each cell of a random function
+	cell   func(i int) string
+}
+
+// result returns the Result of the most significant cell of c.
+func (c *counter) result(driver string, t Target, rounds int, cfg Config) Result {
+	r := Result{
+		Driver:  driver,
+		Target:  t.Name,
+		Rounds:  rounds,
+		Samples: cfg.Samples,
+		Cells:   len(c.counts),
+		// Two-sided, with the Bonferroni correction for the many cells.
+		Threshold: math.Sqrt2 * math.Erfcinv(Alpha/float64(len(c.counts))),
+	}
+	mean := float64(c.trials) * c.p
+	sd := math.Sqrt(mean * (1 - c.p))
+	best := -1
+	for i, n := range c.counts {
+		z := (float64(n) - mean) / sd
+		if best < 0 || math.Abs(z) > math.Abs(r.Max.Z) {
+			best = i
+			r.Max.Z = z
+			r.Max.Bias = float64(n)/mean - 1
+		}
+	}
+	r.Max.Cell = c.cell(best)
+	return r
+}
+
+func newRand(cfg Config, rounds int) *rand.Rand {
+	return rand.New(rand.NewPCG(cfg.Seed, uint64(rounds)))
+}
+
+// randomState returns the state of a random key, nonce and counter.
+func randomState(rng *rand.Rand) (x [16]uint32) {
+	copy(x[:4], sigma[:])
+	for i := 4; i < 16; i++ {
+		x[i] = rng.Uint32()
+	}
+	return
+}
+
+// addBits adds the set bits of x to the counts of the 512 bits of a state.
+func addBits(counts []int, x *[16]uint32) {
+	for w, v := range x {
+		for v != 0 {
+			counts[w*32+bits.TrailingZeros32(v)]++
+			v &= v - 1
+		}
+	}
+}
+
+// Differential measures how often each bit of the keystream block of t
+// after rounds rounds flips with each bit of its nonce and counter.
+func Differential(t Target, rounds int, cfg Config) Result {
+	words := append([]int{t.CounterWord}, t.NonceWords...)
+	c := &counter{
+		counts: make([]int, len(words)*32*512),
+		trials: cfg.Samples,
+		p:      0.5,
+		cell: func(i int) string {
+			in, out := i/512, i%512
+			return fmt.Sprintf("w%db%d->w%db%d", words[in/32], in%32, out/32, out%32)
+		},
+	}
+	rng := newRand(cfg, rounds)
+	for range cfg.Samples {
+		in := randomState(rng)
+		out := t.Block(&in, rounds)
+		for bit := range len(words) * 32 {
+			flipped := in
+			flipped[words[bit/32]] ^= 1 << (bit % 32)
+			diff := t.Block(&flipped, rounds)
+			for w := range diff {
+				diff[w] ^= out[w]
+			}
+			addBits(c.counts[bit*512:], &diff)
+		}
+	}
+	return c.result("differential", t, rounds, cfg)
+}
+
+// Rotational measures how often each bit of the state of t permuted by
+// rounds rounds is that of the permutation of the state with every word
+// rotated, for each rotation amount.
+func Rotational(t Target, rounds int, cfg Config) Result {
+	c := &counter{
+		counts: make([]int, 31*512),
+		trials: cfg.Samples,
+		p:      0.5,
+		cell: func(i int) string {
+			return fmt.Sprintf("r%d:w%db%d", i/512+1, i%512/32, i%32)
+		},
+	}
+	rng := newRand(cfg, rounds)
+	for range cfg.Samples {
+		var x [16]uint32
+		for i := range x {
+			x[i] = rng.Uint32()
+		}
+		permuted := x
+		t.Permute(&permuted, rounds)
+		for r := 1; r < 32; r++ {
+			var rotated [16]uint32
+			for i := range x {
+				rotated[i] = bits.RotateLeft32(x[i], r)
+			}
+			t.Permute(&rotated, rounds)
+			var same [16]uint32
+			for i := range same {
+				same[i] = ^(bits.RotateLeft32(permuted[i], r) ^ rotated[i])
+			}
+			addBits(c.counts[(r-1)*512:], &same)
+		}
+	}
+	return c.result("rotational", t, rounds, cfg)
+}
+
+// keystreamBlocks is the number of counters of each key Keystream samples.
+const keystreamBlocks = 64
+
+// Keystream measures how often each byte of the keystream blocks of t after
+// rounds rounds takes each value, for random keys, a zero nonce and
+// counters from zero.
+func Keystream(t Target, rounds int, cfg Config) Result {
+	c := &counter{
+		counts: make([]int, 64*256),
+		trials: cfg.Samples * keystreamBlocks,
+		p:      1.0 / 256,
+		cell: func(i int) string {
+			return fmt.Sprintf("byte%d=%#02x", i/256, i%256)
+		},
+	}
+	rng := newRand(cfg, rounds)
+	for range cfg.Samples {
+		in := randomState(rng)
+		for _, w := range t.NonceWords {
+			in[w] = 0
+		}
+		for n := range keystreamBlocks {
+			in[t.CounterWord] = uint32(n)
+			out := t.Block(&in, rounds)
+			for i := range 64 {
+				c.counts[i*256+int(byte(out[i/4]>>(8*(i%4))))]++
+			}
+		}
+	}
+	return c.result("keystream", t, rounds, cfg)
+}
+
+// A Driver measures one kind of bias of a target after some rounds.
+type Driver struct {
+	Name string
+	Run  func(t Target, rounds int, cfg Config) Result
+}
+
+// Drivers returns the differential, rotational and keystream drivers.
+func Drivers() []Driver {
+	return []Driver{{"differential", Differential}, {"rotational", Rotational}, {"keystream", Keystream}}
+}
+
+// Sweep runs d on t from one round on, up to t.Rounds, until the first
+// round count with no significant bias. It returns the results of each
+// round count run and the last one with a significant bias, or zero if one
+// round shows none.
+func Sweep(t Target, d Driver, cfg Config) (results []Result, biased int) {
+	for rounds := 1; rounds <= t.Rounds; rounds++ {
+		r := d.Run(t, rounds, cfg)
+		results = append(results, r)
+		if !r.Significant() {
+			break
+		}
+		biased = rounds
+	}
+	return
+}
+
+// Report writes a report of sweeping each driver over each target, with a
+// line for each round count run and a summary of the margin each driver
+// leaves each target.
+func Report(w io.Writer, targets []Target, drivers []Driver, cfg Config) error {
+	if cfg.Samples < 1 {
+		return fmt.Errorf("%d samples", cfg.Samples)
+	}
+	if _, err := fmt.Fprintf(w, "# ChaCha bias report: %d samples, seed %d, alpha %g\n", cfg.Samples, cfg.Seed, Alpha); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "# driver target rounds cells bias z threshold cell significant\n")
+	var summaries []string
+	for _, d := range drivers {
+		for _, t := range targets {
+			results, biased := Sweep(t, d, cfg)
+			for _, r := range results {
+				fmt.Fprintf(w, "%s %s %d %d %.6f %.2f %.2f %s %t\n", r.Driver, r.Target, r.Rounds, r.Cells, r.Max.Bias, r.Max.Z, r.Threshold, r.Max.Cell, r.Significant())
+			}
+			var s string
+			switch biased {
+			case 0:
+				s = "no bias after 1 round"
+			case t.Rounds:
+				s = fmt.Sprintf("bias after all %d rounds", t.Rounds)
+			default:
+				s = fmt.Sprintf("bias through %d rounds, margin %.2fx at %d rounds", biased, float64(t.Rounds)/float64(biased+1), t.Rounds)
+			}
+			summaries = append(summaries, fmt.Sprintf("# %s %s: rotations %v, increment %d: %s\n", d.Name, t.Name, t.Rotations, t.Increment, s))
+		}
+	}
+	for _, s := range summaries {
+		io.WriteString(w, s)
+	}
+	_, err := fmt.Fprintf(w, "# note: statistical tests only; no bias says nothing of resistance to cryptanalysis\n")
+	return err
+}
@@ -0,0 +1,108 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package cryptanalysis
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/chacha20"
+)
+
+// layoutBlock returns the keystream block of t for the key, nonce and
+// counter laid out in its state.
+func layoutBlock(t Target, key, nonce []byte, counter uint32) []byte {
+	var in [16]uint32
+	copy(in[:4], sigma[:])
+	for i := range 8 {
+		in[4+i] = binary.LittleEndian.Uint32(key[i*4:])
+	}
+	for i, w := range t.NonceWords {
+		in[w] = binary.LittleEndian.Uint32(nonce[i*4:])
+	}
+	in[t.CounterWord] = counter
+	out := t.Block(&in, t.Rounds)
+	b := make([]byte, 64)
+	for i, w := range out {
+		binary.LittleEndian.PutUint32(b[i*4:], w)
+	}
+	return b
+}
+
+func TestStandardLayout(t *testing.T) {
+	key := make([]byte, chacha20.KeySize)
+	nonce := make([]byte, chacha20.NonceSize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	for i := range nonce {
+		nonce[i] = byte(0x40 + i)
+	}
+	const counter = 7
+	want := make([]byte, 64)
+	c, err := chacha20.NewUnauthenticatedCipher(key, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetCounter(counter)
+	c.XORKeyStream(want, want)
+	if got := layoutBlock(Standard, key, nonce, counter); !bytes.Equal(got, want) {
+		t.Errorf("standard block %x, want %x", got, want)
+	}
+}
+
+func TestDrivers(t *testing.T) {
+	cfg := Config{Samples: 256, Seed: 1}
+	for _, d := range Drivers() {
+		for _, target := range []Target{Standard, Modified} {
+			if r := d.Run(target, 1, cfg); !r.Significant() || r.Driver != d.Name || r.Target != target.Name || r.Max.Cell == "" {
+				t.Errorf("%s of %s after one round: %+v", d.Name, target.Name, r)
+			}
+			if r := d.Run(target, target.Rounds, cfg); r.Significant() {
+				t.Errorf("%s of %s after %d rounds: %+v", d.Name, target.Name, target.Rounds, r)
+			}
+		}
+	}
+
+	// Flipping a nonce bit of the first column flips its output bits after
+	// a single round, with or without the feed-forward, every time.
+	r := Differential(Standard, 1, cfg)
+	if r.Cells != 4*32*512 || (r.Max.Bias != 1 && r.Max.Bias != -1) {
+		t.Errorf("differential after one round: %+v", r)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	cfg := Config{Samples: 256, Seed: 1}
+	results, biased := Sweep(Modified, Drivers()[0], cfg)
+	if biased == 0 || biased >= Modified.Rounds || len(results) != biased+1 {
+		t.Fatalf("biased through %d rounds, %d results", biased, len(results))
+	}
+	for i, r := range results {
+		if r.Rounds != i+1 || r.Significant() != (i < biased) {
+			t.Errorf("result %d: %+v", i, r)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	var b strings.Builder
+	if err := Report(&b, []Target{Standard, Modified}, Drivers(), Config{Samples: 128, Seed: 1}); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"\ndifferential chacha20 1 ", "\nrotational chacha20_24 1 ", "\nkeystream chacha20_24 1 ", "\n# differential chacha20_24: ", "\n# keystream chacha20: "} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("report lacks %q:\n%s", line, b.String())
+		}
+	}
+	if err := Report(&b, Targets(), Drivers(), Config{}); err == nil {
+		t.Error("report of no samples")
+	}
+}
@@ -0,0 +1,38 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package cryptanalysis
+
+import (
+	"bytes"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+func TestModifiedLayout(t *testing.T) {
+	key := make([]byte, 32)
+	nonce := make([]byte, 16)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	for i := range nonce {
+		nonce[i] = byte(0x40 + i)
+	}
+	const counter = 7
+	want := make([]byte, 64)
+	c, err := device.NewChaCha20x24Cipher(key, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetCounter(counter)
+	c.XORKeyStream(want, want)
+	// The nonce takes the place of the last word of the key.
+	if got := layoutBlock(Modified, key, nonce, counter); !bytes.Equal(got, want) {
+		t.Errorf("modified block %x, want %x", got, want)
+	}
+}
@@ -0,0 +1,318 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package cryptobench measures the stream ciphers, MACs and cipher suites of
+// the device package across message sizes, and writes the results as CSV or
+// JSON, so that they can be graphed across commits and machines.
+//
+// A BenchSuite selects what to measure: the message sizes, the round counts
+// of the ciphers, the MAC variants and the cipher suites. Each measurement
+// processes whole messages of one size with one primitive until its duration
+// has passed. Ciphers and MACs run on fresh keys per call, as the data plane
+// keys them per packet, and suites seal with the nonces of transport data.
+// The modified primitives are measured only in builds with the
+// wg_experimental tag, which alone contain them; the suites are those
+// registered with the device package when the suite runs.
+package cryptobench
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/poly1305"
+	"golang.zx2c4.com/wireguard/device"
+)
+
+// A Kind is the sort of primitive a Primitive is.
+type Kind int
+
+const (
+	KindCipher Kind = iota // unauthenticated stream cipher
+	KindMAC                // one-time message authenticator
+	KindSuite              // cipher suite of transport data
+)
+
+var kindNames = [...]string{
+	KindCipher: "cipher",
+	KindMAC:    "mac",
+	KindSuite:  "suite",
+}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+
+// MarshalText encodes the kind as its name, as reports carry it.
+func (k Kind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText decodes the kind a report carries.
+func (k *Kind) UnmarshalText(text []byte) error {
+	for i, name := range kindNames {
+		if name == string(text) {
+			*k = Kind(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("cryptobench: unknown kind %q", text)
+}
+
+// A Primitive is something a BenchSuite measures.
+type Primitive struct {
+	Name   string
+	Kind   Kind
+	Rounds int // of the block function of a cipher, zero for other kinds
+
+	// setup returns a function processing one message of size bytes.
+	setup func(size int) (func(), error)
+}
+
+var primitives []Primitive
+
+// register adds p to the primitives of every BenchSuite. It is called from
+// init only.
+func register(p Primitive) {
+	primitives = append(primitives, p)
+}
+
+func init() {
+	register(Primitive{Name: "ChaCha20", Kind: KindCipher, Rounds: 20, setup: setupChaCha20})
+	register(Primitive{Name: "Poly1305", Kind: KindMAC, setup: setupMAC(func(out *[16]byte, m []byte, key *[32]byte) {
+		poly1305.Sum(out, m, key)
+	})})
+}
+
+func setupChaCha20(size int) (func(), error) {
+	key := randomBytes(chacha20.KeySize)
+	nonce := make([]byte, chacha20.NonceSize)
+	src, dst := randomBytes(size), make([]byte, size)
+	return func() {
+		c, _ := chacha20.NewUnauthenticatedCipher(key, nonce)
+		c.XORKeyStream(dst, src)
+	}, nil
+}
+
+// setupMAC measures a MAC with a 32-byte key and a tag of type T.
+func setupMAC[T any](sum func(out *T, m []byte, key *[32]byte)) func(size int) (func(), error) {
+	return func(size int) (func(), error) {
+		var key [32]byte
+		rand.Read(key[:])
+		msg := randomBytes(size)
+		var out T
+		return func() { sum(&out, msg, &key) }, nil
+	}
+}
+
+// suitePrimitives returns the cipher suites registered with the device
+// package, as primitives.
+func suitePrimitives() []Primitive {
+	var ps []Primitive
+	for _, name := range device.CipherSuites() {
+		suite := device.LookupCipherSuite(name)
+		ps = append(ps, Primitive{Name: name, Kind: KindSuite, setup: func(size int) (func(), error) {
+			aead, err := suite.New(randomBytes(chacha20poly1305.KeySize))
+			if err != nil {
+				return nil, err
+			}
+			nonce := make([]byte, aead.NonceSize())
+			plaintext := randomBytes(size)
+			buf := make([]byte, 0, size+aead.Overhead())
+			var counter uint64
+			return func() {
+				binary.LittleEndian.PutUint64(nonce[len(nonce)-8:], counter)
+				counter++
+				aead.Seal(buf[:0], nonce, plaintext, nil)
+			}, nil
+		}})
+	}
+	return ps
+}
+
+// Primitives returns what a BenchSuite may measure in this build, ciphers
+// first, then MACs, then cipher suites.
+func Primitives() []Primitive {
+	ps := append(slices.Clone(primitives), suitePrimitives()...)
+	slices.SortStableFunc(ps, func(a, b Primitive) int {
+		if a.Kind != b.Kind {
+			return int(a.Kind - b.Kind)
+		}
+		if a.Rounds != b.Rounds {
+			return a.Rounds - b.Rounds
+		}
+		return 0
+	})
+	return ps
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
+
+// DefaultSizes are the message sizes a BenchSuite measures unless told
+// otherwise: powers of four from 64 bytes to 64 KiB, and the largest packet
+// of the default MTU.
+var DefaultSizes = []int{64, 256, 1024, device.DefaultMTU, 4096, 16384, 65536}
+
+// DefaultDuration is how long a BenchSuite measures each primitive at each
+// size unless told otherwise.
+const DefaultDuration = 100 * time.Millisecond
+
+// A BenchSuite is a sweep of primitives across message sizes. Its filters
+// select primitives of their kind by name or round count, and select all of
+// the kind if nil; an empty, non-nil filter selects none.
+type BenchSuite struct {
+	Sizes    []int         // message sizes in bytes, DefaultSizes if nil
+	Rounds   []int         // round counts of the ciphers
+	MACs     []string      // names of the MACs
+	Suites   []string      // names of the cipher suites
+	Duration time.Duration // per primitive and size, DefaultDuration if zero
+}
+
+// A Result is the measurement of a primitive at a message size.
+type Result struct {
+	Primitive   string  `json:"primitive"`
+	Kind        Kind    `json:"kind"`
+	Rounds      int     `json:"rounds,omitempty"`
+	Size        int     `json:"size"`
+	Iterations  int     `json:"iterations"`
+	NsPerOp     float64 `json:"ns_per_op"`
+	BytesPerSec float64 `json:"bytes_per_sec"`
+}
+
+// A Report holds the results of a BenchSuite and what they were measured
+// on.
+type Report struct {
+	Time      time.Time `json:"time"`
+	Revision  string    `json:"revision,omitempty"` // VCS revision of the build, if recorded
+	GoVersion string    `json:"go_version"`
+	GOOS      string    `json:"goos"`
+	GOARCH    string    `json:"goarch"`
+	CPUs      int       `json:"cpus"`
+	Results   []Result  `json:"results"`
+}
+
+// Selected returns the primitives the suite measures. It fails if a filter
+// names a MAC or cipher suite unknown to this build, or a round count no
+// cipher has.
+func (s *BenchSuite) Selected() ([]Primitive, error) {
+	all := Primitives()
+	if err := checkFilter(all, KindCipher, s.Rounds, func(p Primitive) int { return p.Rounds }, "round count %d"); err != nil {
+		return nil, err
+	}
+	if err := checkFilter(all, KindMAC, s.MACs, func(p Primitive) string { return p.Name }, "MAC %q"); err != nil {
+		return nil, err
+	}
+	if err := checkFilter(all, KindSuite, s.Suites, func(p Primitive) string { return p.Name }, "cipher suite %q"); err != nil {
+		return nil, err
+	}
+	var selected []Primitive
+	for _, p := range all {
+		switch {
+		case p.Kind == KindCipher && s.Rounds != nil && !slices.Contains(s.Rounds, p.Rounds):
+		case p.Kind == KindMAC && s.MACs != nil && !slices.Contains(s.MACs, p.Name):
+		case p.Kind == KindSuite && s.Suites != nil && !slices.Contains(s.Suites, p.Name):
+		default:
+			selected = append(selected, p)
+		}
+	}
+	return selected, nil
+}
+
+// checkFilter fails if filter holds a value no primitive of kind has.
+func checkFilter[T comparable](all []Primitive, kind Kind, filter []T, value func(Primitive) T, format string) error {
+	for _, want := range filter {
+		if !slices.ContainsFunc(all, func(p Primitive) bool { return p.Kind == kind && value(p) == want }) {
+			return fmt.Errorf("cryptobench: unknown "+format, want)
+		}
+	}
+	return nil
+}
+
+// Run measures the selected primitives at each size.
+func (s *BenchSuite) Run() (*Report, error) {
+	selected, err := s.Selected()
+	if err != nil {
+		return nil, err
+	}
+	sizes, d := s.Sizes, s.Duration
+	if sizes == nil {
+		sizes = DefaultSizes
+	}
+	if d == 0 {
+		d = DefaultDuration
+	}
+	for _, size := range sizes {
+		if size <= 0 {
+			return nil, fmt.Errorf("cryptobench: invalid message size %d", size)
+		}
+	}
+	report := &Report{
+		Time:      time.Now().UTC(),
+		GoVersion: runtime.Version(),
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		CPUs:      runtime.NumCPU(),
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "vcs.revision" {
+				report.Revision = setting.Value
+			}
+		}
+	}
+	for _, p := range selected {
+		for _, size := range sizes {
+			op, err := p.setup(size)
+			if err != nil {
+				return nil, fmt.Errorf("cryptobench: setting up %s: %w", p.Name, err)
+			}
+			n, elapsed := measure(d, op)
+			report.Results = append(report.Results, Result{
+				Primitive:   p.Name,
+				Kind:        p.Kind,
+				Rounds:      p.Rounds,
+				Size:        size,
+				Iterations:  n,
+				NsPerOp:     float64(elapsed.Nanoseconds()) / float64(n),
+				BytesPerSec: float64(size) * float64(n) / elapsed.Seconds(),
+			})
+		}
+	}
+	if len(report.Results) == 0 {
+		return nil, errors.New("cryptobench: nothing selected")
+	}
+	return report, nil
+}
+
+// measure calls op in growing batches until d has passed, and returns how
+// often it did and how long that took. Checking the time only between
+// batches keeps it out of the measurement of small messages.
+func measure(d time.Duration, op func()) (n int, elapsed time.Duration) {
+	op() // warm up
+	start := time.Now()
+	for batch := 1; ; batch = min(2*batch, 1<<20) {
+		for range batch {
+			op()
+		}
+		n += batch
+		if elapsed = time.Since(start); elapsed >= d {
+			return n, elapsed
+		}
+	}
+}
@@ -0,0 +1,35 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package cryptobench
+
+import "golang.zx2c4.com/wireguard/device"
+
+func init() {
+	register(Primitive{Name: "ChaCha20_24", Kind: KindCipher, Rounds: 24, setup: setupChaCha20x24})
+	register(Primitive{Name: "Poly1305Modified", Kind: KindMAC, setup: setupMAC(device.SumModified)})
+	register(Primitive{Name: "Poly1795", Kind: KindMAC, setup: setupMAC(device.Poly1795Sum)})
+	register(Primitive{Name: "DoublePoly1305", Kind: KindMAC, setup: setupDoublePoly1305})
+}
+
+func setupChaCha20x24(size int) (func(), error) {
+	key := randomBytes(32)
+	nonce := make([]byte, 16)
+	src, dst := randomBytes(size), make([]byte, size)
+	return func() {
+		c, _ := device.NewChaCha20x24Cipher(key, nonce)
+		c.XORKeyStream(dst, src)
+	}, nil
+}
+
+func setupDoublePoly1305(size int) (func(), error) {
+	var key [64]byte
+	copy(key[:], randomBytes(len(key)))
+	msg := randomBytes(size)
+	var out [32]byte
+	return func() { device.DoublePoly1305(&out, msg, &key) }, nil
+}
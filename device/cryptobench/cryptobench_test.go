@@ -0,0 +1,119 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package cryptobench
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+func TestRun(t *testing.T) {
+	s := BenchSuite{Sizes: []int{64, 1024}, Suites: []string{device.StandardCipherSuite}, Duration: time.Millisecond}
+	report, err := s.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	primitives, _ := s.Selected()
+	if len(report.Results) != 2*len(primitives) {
+		t.Fatalf("%d results of %d primitives at 2 sizes", len(report.Results), len(primitives))
+	}
+	var suites int
+	for _, res := range report.Results {
+		if res.Iterations <= 0 || res.NsPerOp <= 0 || res.BytesPerSec <= 0 {
+			t.Errorf("result %+v", res)
+		}
+		if res.Kind == KindSuite {
+			suites++
+		}
+	}
+	if suites != 2 {
+		t.Errorf("%d results of suites, want those of %s only", suites, device.StandardCipherSuite)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteCSV(&buf); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(report.Results)+1 || rows[1][3] != report.Results[0].Primitive || rows[1][6] != "64" {
+		t.Errorf("CSV rows %q", rows)
+	}
+
+	buf.Reset()
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Results) != len(report.Results) || decoded.Results[0] != report.Results[0] || decoded.GOARCH != report.GOARCH {
+		t.Errorf("JSON decoded to %+v", decoded)
+	}
+}
+
+func TestSelected(t *testing.T) {
+	s := BenchSuite{Rounds: []int{20}, MACs: []string{}, Suites: []string{}}
+	selected, err := s.Selected()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(selected) != 1 || selected[0].Name != "ChaCha20" {
+		t.Errorf("selected %+v", selected)
+	}
+
+	// The modified primitives are there iff the experimental suite is.
+	experimental := device.LookupCipherSuite("ChaCha20x24Poly1795") != nil
+	for _, s := range []BenchSuite{
+		{MACs: []string{"Poly1795"}},
+		{Rounds: []int{24}},
+	} {
+		if _, err := s.Selected(); (err == nil) != experimental {
+			t.Errorf("selecting %+v: %v", s, err)
+		}
+	}
+	for _, s := range []BenchSuite{
+		{Rounds: []int{7}},
+		{MACs: []string{"nope"}},
+		{Suites: []string{"nope"}},
+	} {
+		if _, err := s.Selected(); err == nil {
+			t.Errorf("selecting %+v succeeded", s)
+		}
+	}
+	if _, err := (&BenchSuite{Rounds: []int{}, MACs: []string{}, Suites: []string{}}).Run(); err == nil {
+		t.Error("running nothing succeeded")
+	}
+	if _, err := (&BenchSuite{Sizes: []int{0}}).Run(); err == nil {
+		t.Error("running with an empty message size succeeded")
+	}
+}
+
+func BenchmarkPrimitives(b *testing.B) {
+	for _, p := range Primitives() {
+		for _, size := range DefaultSizes {
+			b.Run(fmt.Sprintf("%s/%s/size=%d", p.Kind, p.Name, size), func(b *testing.B) {
+				op, err := p.setup(size)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.SetBytes(int64(size))
+				for i := 0; i < b.N; i++ {
+					op()
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,47 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package cryptobench
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+)
+
+// csvHeader names the columns WriteCSV writes. Each row repeats what the
+// results were measured on, so that the rows of reports of several commits
+// or machines can be concatenated into one table.
+var csvHeader = []string{"revision", "goos", "goarch", "primitive", "kind", "rounds", "size", "iterations", "ns_per_op", "bytes_per_sec"}
+
+// WriteCSV writes the results as CSV, one row per result after a header.
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	for _, res := range r.Results {
+		cw.Write([]string{
+			r.Revision,
+			r.GOOS,
+			r.GOARCH,
+			res.Primitive,
+			res.Kind.String(),
+			strconv.Itoa(res.Rounds),
+			strconv.Itoa(res.Size),
+			strconv.Itoa(res.Iterations),
+			strconv.FormatFloat(res.NsPerOp, 'f', 1, 64),
+			strconv.FormatFloat(res.BytesPerSec, 'f', 0, 64),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the report as an indented JSON object.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(r)
+}
@@ -0,0 +1,138 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.zx2c4.com/wireguard/ipc"
+)
+
+/* The crypto manifest lists every crypto primitive compiled into the binary,
+ * with the variant and round count it is built with and where its code comes
+ * from: the Go standard library, a module dependency at the version the
+ * binary was built with, or a copy or modification in this package. Files
+ * gated by build tags register the primitives they add, so the manifest
+ * reflects the build rather than the source tree. It is the same for all
+ * binaries built from the same source with the same tags and toolchain, and
+ * ends with its BLAKE2s digest, so that auditors of research builds can
+ * compare deployed nodes against a reference build at a glance.
+ */
+
+// A CryptoSource tells where the code of a crypto primitive comes from.
+type CryptoSource string
+
+const (
+	CryptoSourceStdlib    CryptoSource = "stdlib"     // the Go standard library
+	CryptoSourceModule    CryptoSource = "module"     // a module dependency
+	CryptoSourceInPackage CryptoSource = "in-package" // a copy or modification in this package
+)
+
+// A CryptoPrimitive describes a crypto primitive compiled into the binary.
+type CryptoPrimitive struct {
+	Name    string
+	Variant string // how it differs from the standard algorithm, if it does
+	Rounds  int    // zero if not round based
+	Use     string
+	Source  CryptoSource
+	Origin  string // package path, or file of this package
+}
+
+var cryptoPrimitives = []CryptoPrimitive{
+	{Name: "X25519", Use: "handshake Diffie-Hellman", Source: CryptoSourceModule, Origin: "golang.org/x/crypto/curve25519"},
+	{Name: "BLAKE2s-256", Rounds: 10, Use: "handshake hash and KDF, cookie MACs, XChaCha20Poly1305 salts", Source: CryptoSourceModule, Origin: "golang.org/x/crypto/blake2s"},
+	{Name: "HMAC-BLAKE2s", Use: "handshake KDF", Source: CryptoSourceInPackage, Origin: "noise-helpers.go"},
+	{Name: "SHA-256", Use: "handshake hash experiment", Source: CryptoSourceStdlib, Origin: "crypto/sha256"},
+	{Name: "ChaCha20-Poly1305", Rounds: 20, Use: "transport data, handshake, session replication", Source: CryptoSourceModule, Origin: "golang.org/x/crypto/chacha20poly1305"},
+	{Name: "XChaCha20-Poly1305", Rounds: 20, Use: "cookie replies, transport data", Source: CryptoSourceModule, Origin: "golang.org/x/crypto/chacha20poly1305"},
+	{Name: "ML-KEM-768", Use: "post-quantum hybrid handshake", Source: CryptoSourceStdlib, Origin: "crypto/mlkem"},
+	{Name: "SipHash-2-4-128", Use: "cookie MAC experiment", Source: CryptoSourceInPackage, Origin: "siphash.go"},
+	{Name: "CSPRNG", Use: "keys, indices, nonces of cookies", Source: CryptoSourceStdlib, Origin: "crypto/rand"},
+}
+
+// registerCryptoPrimitive adds p to the manifest. It is called from the
+// init functions of files gated by build tags.
+func registerCryptoPrimitive(p CryptoPrimitive) {
+	cryptoPrimitives = append(cryptoPrimitives, p)
+}
+
+// CryptoPrimitives returns the crypto primitives compiled into the binary,
+// sorted by name.
+func CryptoPrimitives() []CryptoPrimitive {
+	primitives := slices.Clone(cryptoPrimitives)
+	slices.SortStableFunc(primitives, func(a, b CryptoPrimitive) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return primitives
+}
+
+// cryptoOrigin qualifies the origin of p with the version of the module or
+// toolchain it was built from.
+func cryptoOrigin(p CryptoPrimitive, info *debug.BuildInfo) string {
+	switch p.Source {
+	case CryptoSourceStdlib:
+		return p.Origin + "@" + runtime.Version()
+	case CryptoSourceInPackage:
+		return "golang.zx2c4.com/wireguard/device/" + p.Origin
+	}
+	if info != nil {
+		for _, dep := range info.Deps {
+			if strings.HasPrefix(p.Origin, dep.Path+"/") {
+				return p.Origin + "@" + dep.Version
+			}
+		}
+	}
+	return p.Origin
+}
+
+// CryptoManifest returns the crypto manifest of the binary, in the format of
+// UAPI get operations.
+func CryptoManifest() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "go_version=%s\n", runtime.Version())
+	info, _ := debug.ReadBuildInfo()
+	if info != nil {
+		for _, setting := range info.Settings {
+			if setting.Key == "-tags" && setting.Value != "" {
+				fmt.Fprintf(&b, "build_tags=%s\n", setting.Value)
+			}
+		}
+	}
+	for _, p := range CryptoPrimitives() {
+		fmt.Fprintf(&b, "primitive=%s\n", p.Name)
+		if p.Variant != "" {
+			fmt.Fprintf(&b, "variant=%s\n", p.Variant)
+		}
+		if p.Rounds != 0 {
+			fmt.Fprintf(&b, "rounds=%d\n", p.Rounds)
+		}
+		fmt.Fprintf(&b, "use=%s\nsource=%s\norigin=%s\n", p.Use, p.Source, cryptoOrigin(p, info))
+	}
+	for _, name := range CipherSuites() {
+		suite := LookupCipherSuite(name)
+		fmt.Fprintf(&b, "cipher_suite=%s\ntag_size=%d\nsecurity_bits=%d\n", name, suite.Overhead(), suite.Security())
+	}
+	for _, name := range HandshakeHashers() {
+		fmt.Fprintf(&b, "handshake_hash=%s\n", name)
+	}
+	digest := blake2s.Sum256([]byte(b.String()))
+	fmt.Fprintf(&b, "manifest_digest=%s\n", hex.EncodeToString(digest[:]))
+	return b.String()
+}
+
+func ipcCryptoManifest(w io.Writer) error {
+	if _, err := io.WriteString(w, CryptoManifest()); err != nil {
+		return ipcErrorf(ipc.IpcErrorIO, "failed to write output: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2s"
+)
+
+func TestCryptoManifest(t *testing.T) {
+	manifest := CryptoManifest()
+	if manifest != CryptoManifest() {
+		t.Fatal("manifest differs between calls")
+	}
+	for _, line := range []string{
+		"primitive=ChaCha20-Poly1305\nrounds=20\n",
+		"primitive=HMAC-BLAKE2s\nuse=handshake KDF\nsource=in-package\norigin=golang.zx2c4.com/wireguard/device/noise-helpers.go\n",
+		"source=stdlib\norigin=crypto/rand@",
+		"cipher_suite=" + StandardCipherSuite + "\n",
+	} {
+		if !strings.Contains(manifest, line) {
+			t.Errorf("manifest lacks %q:\n%s", line, manifest)
+		}
+	}
+
+	// Builds without the wg_experimental tag contain none of the modified
+	// primitives.
+	experimental := LookupCipherSuite("ChaCha20x24Poly1795") != nil
+	for _, name := range []string{"ChaCha20_24", "Poly1795", "DoublePoly1305"} {
+		if strings.Contains(manifest, "primitive="+name+"\n") != experimental {
+			t.Errorf("manifest lists %s: %v, want %v", name, !experimental, experimental)
+		}
+	}
+
+	body, digest, ok := strings.Cut(manifest, "manifest_digest=")
+	sum := blake2s.Sum256([]byte(body))
+	if !ok || digest != hex.EncodeToString(sum[:])+"\n" {
+		t.Errorf("manifest does not end with its digest:\n%s", manifest)
+	}
+
+	dev := randDevice(t)
+	defer dev.Close()
+	client, server := net.Pipe()
+	defer client.Close()
+	go dev.IpcHandle(server)
+	fmt.Fprintf(client, "get=crypto_manifest\n\n")
+	var reply []byte
+	buf := make([]byte, 4096)
+	for !bytes.HasSuffix(reply, []byte("\n\n")) {
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply = append(reply, buf[:n]...)
+	}
+	if want := manifest + "errno=0\n\n"; string(reply) != want {
+		t.Errorf("crypto_manifest reply = %q, want %q", reply, want)
+	}
+}
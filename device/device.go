@@ -6,11 +6,14 @@
 package device
 
 import (
+	"context"
+	"log/slog"
 	"runtime"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"golang.org/x/crypto/blake2s"
 	"golang.zx2c4.com/wireguard/conn"
 	"golang.zx2c4.com/wireguard/ratelimiter"
 	"golang.zx2c4.com/wireguard/rwcancel"
@@ -42,7 +45,9 @@ type Device struct {
 		sync.RWMutex
 		bind          conn.Bind // bind interface
 		netlinkCancel *rwcancel.RWCancel
-		port          uint16 // listening port
+		port          uint16   // listening port
+		ports         []uint16 // additional listening ports
+		listeners     []*portListener
 		fwmark        uint32 // mark value (0 = disabled)
 		brokenRoaming bool
 	}
@@ -51,6 +56,12 @@ type Device struct {
 		sync.RWMutex
 		privateKey NoisePrivateKey
 		publicKey  NoisePublicKey
+
+		construction    string             // Noise protocol name
+		identifier      string             // Noise prologue
+		hash            *handshakeHash     // of handshakes; nil for BLAKE2s
+		initialChainKey [blake2s.Size]byte // derived from construction
+		initialHash     [blake2s.Size]byte // derived from construction and identifier
 	}
 
 	peers struct {
@@ -63,6 +74,8 @@ type Device struct {
 		limiter        ratelimiter.Ratelimiter
 	}
 
+	cookieStats cookieCounters
+
 	allowedips    AllowedIPs
 	indexTable    IndexTable
 	cookieChecker CookieChecker
@@ -73,6 +86,8 @@ type Device struct {
 		messageBuffers            *WaitPool
 		inboundElements           *WaitPool
 		outboundElements          *WaitPool
+		messageInitiations        *WaitPool
+		messageResponses          *WaitPool
 	}
 
 	queue struct {
@@ -81,14 +96,70 @@ type Device struct {
 		handshake  *handshakeQueue
 	}
 
+	workers struct {
+		encryption workerScaler
+		decryption workerScaler
+	}
+
+	encryptionScheduler encryptionScheduler // shares of saturated encryption workers
+	affinity            coreAffinity
+	handshakePadding    atomic.Int32                // size handshake messages are padded to, or zero
+	suite               atomic.Pointer[CipherSuite] // cipher suite of peers not pinned to one; nil for the standard one
+	cookieMAC           atomic.Int32                // CookieMAC
+	keystream           deviceKeystream
+	chacha              deviceChaChaRounds
+	icmpErrors          deviceICMPErrors
+	nested              deviceNested
+	pipeline            devicePipeline
+	packetTrace         packetTrace
+	metadataVerifier    atomic.Pointer[MetadataVerifier]
+
 	tun struct {
 		device tun.Device
 		mtu    atomic.Int32
 	}
 
+	knock knockGate
+
+	quarantine atomic.Pointer[QuarantinePolicy] // nil if quarantine is disabled
+	timestamps atomic.Pointer[TimestampPolicy]  // nil for the zero policy
+
+	replication replication
+	congestion  congestionState
+	crashes     atomic.Uint64 // panics recovered by workers
+
+	stateStore struct {
+		sync.Mutex
+		store     atomic.Pointer[StateStore] // nil without a store
+		stopWatch func()
+	}
+
+	transcript atomic.Pointer[transcript] // nil unless recording a session transcript
+
+	observers struct {
+		sync.Mutex
+		chans map[chan []byte]struct{}
+		count atomic.Int32 // len(chans), readable without the mutex
+	}
+
+	subscribers struct {
+		sync.Mutex
+		chans   map[chan<- Event]struct{}
+		count   atomic.Int32 // len(chans), readable without the mutex
+		dropped atomic.Uint64
+	}
+
+	limits Limits
+
+	audit struct {
+		sync.Mutex
+		entries []AuditEntry
+		dropped uint64
+	}
+
 	ipcMutex sync.RWMutex
 	closed   chan struct{}
-	log      *Logger
+	log      *deviceLog
 }
 
 // deviceState represents the state of a Device.
@@ -133,10 +204,11 @@ func removePeerLocked(device *Device, peer *Peer, key NoisePublicKey) {
 
 	// remove from peer map
 	delete(device.peers.keyMap, key)
+	device.notifyObservers("peer_removed", peer)
 }
 
 // changeState attempts to change the device state to match want.
-func (device *Device) changeState(want deviceState) (err error) {
+func (device *Device) changeState(ctx context.Context, want deviceState) (err error) {
 	device.state.Lock()
 	defer device.state.Unlock()
 	old := device.deviceState()
@@ -150,7 +222,7 @@ func (device *Device) changeState(want deviceState) (err error) {
 		return nil
 	case deviceStateUp:
 		device.state.state.Store(uint32(deviceStateUp))
-		err = device.upLocked()
+		err = device.upLocked(ctx)
 		if err == nil {
 			break
 		}
@@ -168,9 +240,14 @@ func (device *Device) changeState(want deviceState) (err error) {
 
 // upLocked attempts to bring the device up and reports whether it succeeded.
 // The caller must hold device.state.mu and is responsible for updating device.state.state.
-func (device *Device) upLocked() error {
-	if err := device.BindUpdate(); err != nil {
-		device.log.Errorf("Unable to update bind: %v", err)
+func (device *Device) upLocked(ctx context.Context) error {
+	if err := device.RunCryptoSelfTest(); err != nil {
+		device.log.Errorf("Refusing to bring up: %v", err)
+		return err
+	}
+
+	if err := device.bindUpdate(ctx); err != nil {
+		device.log.conn.errorAttrs([]slog.Attr{errorAttr(err)}, "Unable to update bind: %v", err)
 		return err
 	}
 
@@ -195,7 +272,7 @@ func (device *Device) upLocked() error {
 func (device *Device) downLocked() error {
 	err := device.BindClose()
 	if err != nil {
-		device.log.Errorf("Bind close failed: %v", err)
+		device.log.conn.errorAttrs([]slog.Attr{errorAttr(err)}, "Bind close failed: %v", err)
 	}
 
 	device.peers.RLock()
@@ -207,23 +284,35 @@ func (device *Device) downLocked() error {
 }
 
 func (device *Device) Up() error {
-	return device.changeState(deviceStateUp)
+	return device.UpContext(context.Background())
+}
+
+// UpContext is Up, giving up on opening the bind when ctx is done, in which
+// case the device stays down.
+func (device *Device) UpContext(ctx context.Context) error {
+	return device.changeState(ctx, deviceStateUp)
 }
 
 func (device *Device) Down() error {
-	return device.changeState(deviceStateDown)
+	return device.changeState(context.Background(), deviceStateDown)
 }
 
 func (device *Device) IsUnderLoad() bool {
 	// check if currently under load
 	now := time.Now()
-	underLoad := len(device.queue.handshake.c) >= QueueHandshakeSize/8
+	underLoad := len(device.queue.handshake.c) >= device.limits.QueueHandshakeSize/8
 	if underLoad {
+		device.cookieStats.recordLoadState(true, now)
 		device.rate.underLoadUntil.Store(now.Add(UnderLoadAfterTime).UnixNano())
 		return true
 	}
 	// check if recently under load
-	return device.rate.underLoadUntil.Load() > now.UnixNano()
+	until := device.rate.underLoadUntil.Load()
+	if until > now.UnixNano() {
+		return true
+	}
+	device.cookieStats.recordLoadState(false, time.Unix(0, until))
+	return false
 }
 
 func (device *Device) SetPrivateKey(sk NoisePrivateKey) error {
@@ -281,11 +370,58 @@ func (device *Device) SetPrivateKey(sk NoisePrivateKey) error {
 	return nil
 }
 
+// SetProtocolIdentifier replaces the Noise protocol name and prologue that
+// seed every handshake on this device. Empty strings select the standard
+// WireGuard values. Any other value makes the device unable to complete
+// handshakes with standard WireGuard implementations, and with any peer not
+// configured identically; it is meant for private networks that want
+// protocol-level isolation from stock WireGuard endpoints and scanners.
+// Sessions established before the change are unaffected.
+func (device *Device) SetProtocolIdentifier(construction, identifier string) {
+	if construction == "" {
+		construction = NoiseConstruction
+	}
+	if identifier == "" {
+		identifier = WGIdentifier
+	}
+
+	device.staticIdentity.Lock()
+	defer device.staticIdentity.Unlock()
+
+	if construction == device.staticIdentity.construction && identifier == device.staticIdentity.identifier {
+		return
+	}
+	device.setProtocolIdentifierLocked(construction, identifier)
+	if construction != NoiseConstruction || identifier != WGIdentifier {
+		device.log.Errorf("Non-standard Noise construction %q and identifier %q in use; this device is not interoperable with standard WireGuard", construction, identifier)
+	}
+}
+
+// setProtocolIdentifierLocked derives the initial handshake state, with the
+// handshake hash in place of BLAKE2s in the standard construction.
+// The caller must hold device.staticIdentity.
+func (device *Device) setProtocolIdentifierLocked(construction, identifier string) {
+	id := &device.staticIdentity
+	id.construction = construction
+	id.identifier = identifier
+	h := device.handshakeHash()
+	if construction == NoiseConstruction {
+		construction = h.construction
+	}
+	h.sum(&id.initialChainKey, []byte(construction), nil)
+	h.mixHash(&id.initialHash, &id.initialChainKey, []byte(identifier))
+}
+
 func NewDevice(tunDevice tun.Device, bind conn.Bind, logger *Logger) *Device {
+	return newDevice(tunDevice, bind, logger, DefaultLimits())
+}
+
+func newDevice(tunDevice tun.Device, bind conn.Bind, logger *Logger, limits Limits) *Device {
 	device := new(Device)
+	device.limits = limits
 	device.state.state.Store(uint32(deviceStateDown))
 	device.closed = make(chan struct{})
-	device.log = logger
+	device.log = newDeviceLog(logger)
 	device.net.bind = bind
 	device.tun.device = tunDevice
 	mtu, err := device.tun.device.MTU()
@@ -294,6 +430,11 @@ func NewDevice(tunDevice tun.Device, bind conn.Bind, logger *Logger) *Device {
 		mtu = DefaultMTU
 	}
 	device.tun.mtu.Store(int32(mtu))
+	device.checkOverhead(mtu)
+	if err := device.RunCryptoSelfTest(); err != nil {
+		device.log.Errorf("%v; the device will not come up", err)
+	}
+	device.setProtocolIdentifierLocked(NoiseConstruction, WGIdentifier)
 	device.peers.keyMap = make(map[NoisePublicKey]*Peer)
 	device.rate.limiter.Init()
 	device.indexTable.Init()
@@ -302,9 +443,11 @@ func NewDevice(tunDevice tun.Device, bind conn.Bind, logger *Logger) *Device {
 
 	// create queues
 
-	device.queue.handshake = newHandshakeQueue()
-	device.queue.encryption = newOutboundQueue()
-	device.queue.decryption = newInboundQueue()
+	device.queue.handshake = newHandshakeQueue(limits.QueueHandshakeSize)
+	device.queue.encryption = newOutboundQueue(limits.QueueOutboundSize)
+	device.encryptionScheduler.wake = make(chan struct{}, 1)
+	device.queue.decryption = newInboundQueue(limits.QueueInboundSize)
+	device.icmpErrors.queue = make(chan conn.ICMPError, QueueICMPErrorSize)
 
 	// start workers
 
@@ -312,15 +455,16 @@ func NewDevice(tunDevice tun.Device, bind conn.Bind, logger *Logger) *Device {
 	device.state.stopping.Wait()
 	device.queue.encryption.wg.Add(cpus) // One for each RoutineHandshake
 	for i := 0; i < cpus; i++ {
-		go device.RoutineEncryption(i + 1)
-		go device.RoutineDecryption(i + 1)
 		go device.RoutineHandshake(i + 1)
 	}
+	device.workers.encryption.init(limits.MinCryptoWorkers, limits.MaxCryptoWorkers, device.RoutineEncryption)
+	device.workers.decryption.init(limits.MinCryptoWorkers, limits.MaxCryptoWorkers, device.RoutineDecryption)
 
 	device.state.stopping.Add(1)      // RoutineReadFromTUN
 	device.queue.encryption.wg.Add(1) // RoutineReadFromTUN
 	go device.RoutineReadFromTUN()
 	go device.RoutineTUNEventReader()
+	go device.routineICMPErrors()
 
 	return device
 }
@@ -384,6 +528,8 @@ func (device *Device) Close() {
 	// Remove peers before closing queues,
 	// because peers assume that queues are active.
 	device.RemoveAllPeers()
+	device.SetStateStore(nil)
+	device.endTranscript("closed")
 
 	// We kept a reference to the encryption and decryption queues,
 	// in case we started any new peers that might write to them.
@@ -399,6 +545,23 @@ func (device *Device) Close() {
 	close(device.closed)
 }
 
+// CloseContext is Close, waiting for the device to close only until ctx is
+// done, in which case it returns ctx.Err() and the device carries on closing
+// in the background.
+func (device *Device) CloseContext(ctx context.Context) error {
+	closed := make(chan struct{})
+	go func() {
+		device.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (device *Device) Wait() chan struct{} {
 	return device.closed
 }
@@ -428,10 +591,21 @@ func closeBindLocked(device *Device) error {
 	if netc.netlinkCancel != nil {
 		netc.netlinkCancel.Cancel()
 	}
+	for _, l := range netc.listeners {
+		if l.bind != netc.bind {
+			if cerr := l.bind.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+	}
 	if netc.bind != nil {
-		err = netc.bind.Close()
+		if cerr := netc.bind.Close(); cerr != nil {
+			err = cerr
+		}
 	}
+	device.unregisterNestedLocked()
 	netc.stopping.Wait()
+	netc.listeners = nil
 	return err
 }
 
@@ -456,6 +630,14 @@ func (device *Device) BindSetMark(mark uint32) error {
 		if err := device.net.bind.SetMark(mark); err != nil {
 			return err
 		}
+		for _, l := range device.net.listeners {
+			if l.bind == device.net.bind {
+				continue
+			}
+			if err := l.bind.SetMark(mark); err != nil {
+				return err
+			}
+		}
 	}
 
 	// clear cached source addresses
@@ -469,6 +651,11 @@ func (device *Device) BindSetMark(mark uint32) error {
 }
 
 func (device *Device) BindUpdate() error {
+	return device.bindUpdate(context.Background())
+}
+
+// bindUpdate is BindUpdate, giving up on opening the bind when ctx is done.
+func (device *Device) bindUpdate(ctx context.Context) error {
 	device.net.Lock()
 	defer device.net.Unlock()
 
@@ -487,7 +674,7 @@ func (device *Device) BindUpdate() error {
 	var recvFns []conn.ReceiveFunc
 	netc := &device.net
 
-	recvFns, netc.port, err = netc.bind.Open(netc.port)
+	recvFns, netc.port, err = conn.OpenContext(ctx, netc.bind, netc.port)
 	if err != nil {
 		netc.port = 0
 		return err
@@ -508,6 +695,15 @@ func (device *Device) BindUpdate() error {
 		}
 	}
 
+	// open additional ports
+	netc.listeners = []*portListener{{port: netc.port, bind: netc.bind}}
+	extraFns, err := device.openExtraListenersLocked(ctx)
+	if err != nil {
+		closeBindLocked(device)
+		netc.port = 0
+		return err
+	}
+
 	// clear cached source addresses
 	device.peers.RLock()
 	for _, peer := range device.peers.keyMap {
@@ -515,16 +711,24 @@ func (device *Device) BindUpdate() error {
 	}
 	device.peers.RUnlock()
 
+	// receive datagrams handed off by other devices
+	if fn := device.registerNestedLocked(); fn != nil {
+		recvFns = append(recvFns, fn)
+	}
+
 	// start receiving routines
-	device.net.stopping.Add(len(recvFns))
-	device.queue.decryption.wg.Add(len(recvFns)) // each RoutineReceiveIncoming goroutine writes to device.queue.decryption
-	device.queue.handshake.wg.Add(len(recvFns))  // each RoutineReceiveIncoming goroutine writes to device.queue.handshake
-	batchSize := netc.bind.BatchSize()
-	for _, fn := range recvFns {
-		go device.RoutineReceiveIncoming(batchSize, fn)
+	for i, fns := range append([][]conn.ReceiveFunc{recvFns}, extraFns...) {
+		l := netc.listeners[i]
+		device.net.stopping.Add(len(fns))
+		device.queue.decryption.wg.Add(len(fns)) // each RoutineReceiveIncoming goroutine writes to device.queue.decryption
+		device.queue.handshake.wg.Add(len(fns))  // each RoutineReceiveIncoming goroutine writes to device.queue.handshake
+		batchSize := l.bind.BatchSize()
+		for _, fn := range fns {
+			go device.routineReceiveIncoming(l, batchSize, fn)
+		}
 	}
 
-	device.log.Verbosef("UDP bind has been updated")
+	device.log.conn.Verbosef("UDP bind has been updated")
 	return nil
 }
 
@@ -7,14 +7,19 @@ package device
 
 import (
 	"bytes"
+	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
+	"net"
 	"net/netip"
 	"os"
 	"runtime"
 	"runtime/pprof"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -22,6 +27,7 @@ import (
 
 	"golang.zx2c4.com/wireguard/conn"
 	"golang.zx2c4.com/wireguard/conn/bindtest"
+	"golang.zx2c4.com/wireguard/ipc"
 	"golang.zx2c4.com/wireguard/tun"
 	"golang.zx2c4.com/wireguard/tun/tuntest"
 )
@@ -150,13 +156,18 @@ func (pair *testPair) Send(tb testing.TB, ping SendDirection, done chan struct{}
 
 // genTestPair creates a testPair.
 func genTestPair(tb testing.TB, realSocket bool) (pair testPair) {
-	cfg, endpointCfg := genConfigs(tb)
 	var binds [2]conn.Bind
 	if realSocket {
 		binds[0], binds[1] = conn.NewDefaultBind(), conn.NewDefaultBind()
 	} else {
 		binds = bindtest.NewChannelBinds()
 	}
+	return genTestPairBinds(tb, binds)
+}
+
+// genTestPairBinds is genTestPair with the binds of the two devices given.
+func genTestPairBinds(tb testing.TB, binds [2]conn.Bind) (pair testPair) {
+	cfg, endpointCfg := genConfigs(tb)
 	// Bring up a ChannelTun for each config.
 	for i := range pair {
 		p := &pair[i]
@@ -323,6 +334,79 @@ func TestConcurrencySafety(t *testing.T) {
 	close(done)
 }
 
+// TestPeerScopedIpcSet rewrites one peer's allowed IPs in a loop while traffic
+// flows to another peer, and checks that the rewritten peer's routes are never
+// observed half-replaced.
+func TestPeerScopedIpcSet(t *testing.T) {
+	if runtime.GOARCH == "wasm" {
+		t.Skip("spinning goroutines starve the others without preemption")
+	}
+	pair := genTestPair(t, true)
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+
+	var sk NoisePrivateKey
+	if _, err := rand.Read(sk[:]); err != nil {
+		t.Fatal(err)
+	}
+	pk := sk.publicKey()
+	cfg := uapiCfg(
+		"public_key", hex.EncodeToString(pk[:]),
+		"replace_allowed_ips", "true",
+		"allowed_ip", "10.0.0.0/24",
+		"allowed_ip", "10.0.1.0/24",
+		"allowed_ip", "fd00::/64",
+	)
+	dev := pair[0].dev
+	if err := dev.IpcSet(cfg); err != nil {
+		t.Fatal(err)
+	}
+	peerA := dev.LookupPeer(pk)
+	if peerA == nil {
+		t.Fatal("peer A was not created")
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if err := dev.IpcSet(cfg); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		ip := []byte{10, 0, 1, 7}
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			if got := dev.allowedips.Lookup(ip); got != peerA {
+				t.Errorf("lookup of %v during rewrite returned %v, want %v", ip, got, peerA)
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 50; i++ {
+		pair.Send(t, Ping, done)
+		pair.Send(t, Pong, done)
+	}
+	close(done)
+	wg.Wait()
+}
+
 func BenchmarkLatency(b *testing.B) {
 	pair := genTestPair(b, true)
 
@@ -393,6 +477,10 @@ func BenchmarkUAPIGet(b *testing.B) {
 }
 
 func goroutineLeakCheck(t *testing.T) {
+	if runtime.GOOS == "js" {
+		// The runtime starts goroutines of its own to handle JavaScript events.
+		return
+	}
 	goroutines := func() (int, []byte) {
 		p := pprof.Lookup("goroutine")
 		b := new(bytes.Buffer)
@@ -474,3 +562,238 @@ func TestBatchSize(t *testing.T) {
 		t.Errorf("expected batch size %d, got %d", want, got)
 	}
 }
+
+func TestPeerName(t *testing.T) {
+	dev := randDevice(t)
+	defer dev.Close()
+	sk, err := newPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk := sk.publicKey()
+
+	if err := dev.IpcSet(fmt.Sprintf("public_key=%x\nname=alice-laptop\n", pk[:])); err != nil {
+		t.Fatal(err)
+	}
+	if name := dev.LookupPeer(pk).Name(); name != "alice-laptop" {
+		t.Errorf("peer name = %q, want alice-laptop", name)
+	}
+	if uapi, _ := dev.IpcGet(); !bytes.Contains([]byte(uapi), []byte("name=alice-laptop\n")) {
+		t.Errorf("UAPI get does not report peer name:\n%s", uapi)
+	}
+	if status := dev.Status(); len(status.Peers) != 1 || status.Peers[0].Name != "alice-laptop" {
+		t.Errorf("status does not report peer name: %+v", status.Peers)
+	}
+
+	if err := dev.IpcSet(fmt.Sprintf("public_key=%x\nname=bad\x01name\n", pk[:])); err == nil {
+		t.Error("unprintable name was accepted")
+	}
+	if err := dev.IpcSet(fmt.Sprintf("public_key=%x\nname=%s\n", pk[:], bytes.Repeat([]byte("x"), MaxPeerNameLength+1))); err == nil {
+		t.Error("overlong name was accepted")
+	}
+
+	if err := dev.IpcSet(fmt.Sprintf("public_key=%x\nname=\n", pk[:])); err != nil {
+		t.Fatal(err)
+	}
+	if uapi, _ := dev.IpcGet(); bytes.Contains([]byte(uapi), []byte("name=")) {
+		t.Errorf("UAPI get reports cleared peer name:\n%s", uapi)
+	}
+}
+
+func TestBenchmark(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	dev := pair[0].dev
+
+	res := dev.benchmark(time.Millisecond)
+	if res.Construction != NoiseConstruction || res.Workers != dev.limits.MaxCryptoWorkers || res.PacketSize != DefaultMTU {
+		t.Errorf("unexpected configuration in %+v", res)
+	}
+	if res.SealRate <= 0 || res.OpenRate <= 0 || res.ParallelSealRate <= 0 || res.DHRate <= 0 {
+		t.Errorf("missing measurements in %+v", res)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go dev.IpcHandle(server)
+	fmt.Fprintf(client, "benchmark=1\n\n")
+	reply := []byte{'\n'}
+	buf := make([]byte, 256)
+	for !bytes.HasSuffix(reply, []byte("\n\n")) {
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply = append(reply, buf[:n]...)
+	}
+	if !bytes.HasSuffix(reply, []byte("errno=0\n\n")) {
+		t.Fatalf("unexpected benchmark reply %q", reply)
+	}
+	for _, key := range []string{"construction", "workers", "packet_size", "seal_bytes_per_sec", "open_bytes_per_sec", "parallel_seal_bytes_per_sec", "dh_ops_per_sec", "duration_nsec"} {
+		if !bytes.Contains(reply, []byte("\n"+key+"=")) {
+			t.Errorf("benchmark reply lacks %s: %q", key, reply)
+		}
+	}
+}
+
+func TestGetPage(t *testing.T) {
+	dev := randDevice(t)
+	defer dev.Close()
+	var keys []string
+	for range 5 {
+		sk, err := newPrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pk := sk.publicKey()
+		keys = append(keys, hex.EncodeToString(pk[:]))
+		if err := dev.IpcSet(uapiCfg("public_key", keys[len(keys)-1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	slices.Sort(keys)
+
+	var listed []string
+	cursor := ""
+	for page := 0; ; page++ {
+		var buf strings.Builder
+		if err := dev.IpcGetPageOperation(&buf, cursor, 2); err != nil {
+			t.Fatal(err)
+		}
+		if hasDevice := strings.HasPrefix(buf.String(), "private_key="); hasDevice != (page == 0) {
+			t.Errorf("page %d lists device values: %v", page, hasDevice)
+		}
+		cursor = ""
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if key, ok := strings.CutPrefix(line, "public_key="); ok {
+				listed = append(listed, key)
+			} else if next, ok := strings.CutPrefix(line, "next_page="); ok {
+				cursor = next
+			}
+		}
+		if cursor == "" {
+			break
+		}
+		if cursor != listed[len(listed)-1] {
+			t.Fatalf("next page cursor %s is not the last peer listed", cursor)
+		}
+	}
+	if !slices.Equal(listed, keys) {
+		t.Errorf("pages listed %v, want %v", listed, keys)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go dev.IpcHandle(server)
+	for _, tt := range []struct {
+		op    string
+		errno string
+	}{
+		{"get_page=&page_size=3", "errno=0"},
+		{"get_page=" + keys[3], "errno=0"},
+		{"get_page=&page_size=0", fmt.Sprintf("errno=%d", ipc.IpcErrorInvalid)},
+		{"get_page=zz", fmt.Sprintf("errno=%d", ipc.IpcErrorInvalid)},
+	} {
+		fmt.Fprintf(client, "%s\n\n", tt.op)
+		reply := []byte{'\n'}
+		buf := make([]byte, 256)
+		for !bytes.HasSuffix(reply, []byte("\n\n")) {
+			n, err := client.Read(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reply = append(reply, buf[:n]...)
+		}
+		if !bytes.HasSuffix(reply, []byte("\n"+tt.errno+"\n\n")) {
+			t.Errorf("%s: unexpected reply %q", tt.op, reply)
+		}
+		if tt.op == "get_page="+keys[3] && !bytes.Contains(reply, []byte("public_key="+keys[4])) {
+			t.Errorf("%s: last peer not listed: %q", tt.op, reply)
+		}
+	}
+}
+
+func TestContextCancellation(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+	dev := pair[0].dev
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	sk, err := newPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk := sk.publicKey()
+	if err := dev.IpcSetContext(cancelled, uapiCfg("public_key", hex.EncodeToString(pk[:]))); !errors.Is(err, context.Canceled) {
+		t.Errorf("set with cancelled context: %v", err)
+	}
+	if err := dev.ConfigureContext(cancelled, Config{Peers: []PeerConfig{{PublicKey: pk}}}); !errors.Is(err, context.Canceled) {
+		t.Errorf("configure with cancelled context: %v", err)
+	}
+	if dev.LookupPeer(pk) != nil {
+		t.Error("peer added with cancelled context")
+	}
+
+	peer := dev.LookupPeer(pair[1].dev.staticIdentity.publicKey)
+	if _, err := peer.HandshakeContext(cancelled); !errors.Is(err, context.Canceled) {
+		t.Errorf("handshake with cancelled context: %v", err)
+	}
+
+	if err := dev.Down(); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.UpContext(cancelled); !errors.Is(err, context.Canceled) || dev.isUp() {
+		t.Errorf("up with cancelled context: %v, up %v", err, dev.isUp())
+	}
+	if err := dev.UpContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.CloseContext(context.Background()); err != nil || !dev.isClosed() {
+		t.Errorf("close: %v, closed %v", err, dev.isClosed())
+	}
+}
+
+func TestPingPeer(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+
+	var pk NoisePublicKey
+	for key := range pair[0].dev.peers.keyMap {
+		pk = key
+	}
+	rtt, err := pair[0].dev.LookupPeer(pk).Ping(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rtt <= 0 {
+		t.Errorf("ping rtt = %v", rtt)
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go pair[0].dev.IpcHandle(server)
+	fmt.Fprintf(client, "ping_peer=%x\n\n", pk[:])
+	reply := make([]byte, 0, 64)
+	buf := make([]byte, 64)
+	for !bytes.HasSuffix(reply, []byte("\n\n")) {
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply = append(reply, buf[:n]...)
+	}
+	if !bytes.HasPrefix(reply, []byte("rtt_nsec=")) || !bytes.HasSuffix(reply, []byte("errno=0\n\n")) {
+		t.Errorf("unexpected ping_peer reply: %q", reply)
+	}
+
+	select {
+	case packet := <-pair[0].tun.Inbound:
+		t.Errorf("control message delivered to TUN: %x", packet)
+	case packet := <-pair[1].tun.Inbound:
+		t.Errorf("control message delivered to TUN: %x", packet)
+	default:
+	}
+}
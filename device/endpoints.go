@@ -0,0 +1,91 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"log/slog"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+// An endpointRecord is an endpoint a peer has roamed away from, kept so that
+// transmissions can fall back to it if the current endpoint fails. This helps
+// with carrier-grade NATs that alternate between mappings.
+type endpointRecord struct {
+	val      conn.Endpoint
+	lastUsed time.Time
+}
+
+// rememberEndpointLocked records old as a previous endpoint of the peer, most
+// recent first. It must be called with peer.endpoint held.
+func (peer *Peer) rememberEndpointLocked(old conn.Endpoint, now time.Time) {
+	dst := old.DstToString()
+	history := peer.endpoint.history[:0]
+	for _, r := range peer.endpoint.history {
+		if r.val.DstToString() != dst && now.Sub(r.lastUsed) <= EndpointHistoryLifetime {
+			history = append(history, r)
+		}
+	}
+	if len(history) >= MaxEndpointHistory {
+		history = history[:MaxEndpointHistory-1]
+	}
+	peer.endpoint.history = append([]endpointRecord{{val: old, lastUsed: now}}, history...)
+}
+
+// sendToPreviousEndpoints retries buffers on each recent previous endpoint
+// after a transmission to failed has returned err. The first endpoint that
+// works becomes the current one. It must be called with device.net held.
+func (peer *Peer) sendToPreviousEndpoints(buffers [][]byte, failed conn.Endpoint, err error) error {
+	now := time.Now()
+	peer.endpoint.Lock()
+	history := make([]endpointRecord, len(peer.endpoint.history))
+	copy(history, peer.endpoint.history)
+	peer.endpoint.Unlock()
+
+	for _, r := range history {
+		if now.Sub(r.lastUsed) > EndpointHistoryLifetime {
+			break
+		}
+		if peer.device.sendTo(buffers, r.val) != nil {
+			continue
+		}
+		endpoint := r.val.DstToString()
+		peer.device.log.conn.verboseAttrs([]slog.Attr{peerAttr(peer), endpointAttr(endpoint)}, "%v - Sending to %v failed, fell back to previous endpoint %v", peer, failed.DstToString(), endpoint)
+		peer.endpoint.Lock()
+		if peer.endpoint.val == failed {
+			peer.rememberEndpointLocked(failed, now)
+			peer.endpoint.val = r.val
+			peer.emitEndpointChangedLocked(failed)
+		}
+		peer.endpoint.Unlock()
+		return nil
+	}
+	return err
+}
+
+// PreviousEndpoints returns the endpoints recorded for fallback, most recent
+// first.
+func (peer *Peer) PreviousEndpoints() []string {
+	peer.endpoint.Lock()
+	defer peer.endpoint.Unlock()
+	endpoints := make([]string, 0, len(peer.endpoint.history))
+	for _, r := range peer.endpoint.history {
+		endpoints = append(endpoints, r.val.DstToString())
+	}
+	return endpoints
+}
+
+// setEndpointFallback enables or disables endpoint fallback, forgetting any
+// previous endpoints when disabled unless endpoint switching uses them.
+func (peer *Peer) setEndpointFallback(enabled bool) {
+	peer.endpoint.Lock()
+	defer peer.endpoint.Unlock()
+	peer.endpointFallback.Store(enabled)
+	if !peer.keepsEndpointHistory() {
+		peer.endpoint.history = nil
+	}
+}
@@ -0,0 +1,65 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+)
+
+func TestEndpointFallback(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pub1 := pair[1].dev.staticIdentity.publicKey
+	if err := pair[0].dev.IpcSet(uapiCfg(
+		"public_key", hex.EncodeToString(pub1[:]),
+		"endpoint_fallback", "true",
+	)); err != nil {
+		t.Fatal(err)
+	}
+	pair.Send(t, Ping, nil)
+
+	// Roam to an endpoint the bind cannot send to.
+	peer := pair[0].dev.LookupPeer(pub1)
+	working := peer.endpoint.val
+	broken := bindtest.ChannelEndpoint(99)
+	peer.SetEndpointFromPacket(broken)
+	if prev := peer.PreviousEndpoints(); len(prev) == 0 || prev[0] != working.DstToString() {
+		t.Fatalf("unexpected previous endpoints: %v", prev)
+	}
+
+	pair.Send(t, Pong, nil)
+	// The packet may arrive before the sender records the fallback.
+	var current conn.Endpoint
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		peer.endpoint.Lock()
+		current = peer.endpoint.val
+		peer.endpoint.Unlock()
+		if current == working || time.Now().After(deadline) {
+			break
+		}
+	}
+	if current != working {
+		t.Errorf("endpoint is %v, want fallback to %v", current.DstToString(), working.DstToString())
+	}
+	if prev := peer.PreviousEndpoints(); len(prev) == 0 || prev[0] != broken.DstToString() {
+		t.Errorf("unexpected previous endpoints after fallback: %v", prev)
+	}
+
+	if err := pair[0].dev.IpcSet(uapiCfg(
+		"public_key", hex.EncodeToString(pub1[:]),
+		"endpoint_fallback", "false",
+	)); err != nil {
+		t.Fatal(err)
+	}
+	if prev := peer.PreviousEndpoints(); len(prev) != 0 {
+		t.Errorf("previous endpoints kept after disabling fallback: %v", prev)
+	}
+}
@@ -0,0 +1,33 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import "errors"
+
+/* Errors returned by the device wrap one of these where the condition is one
+ * an embedder may want to handle, so that it can be tested for with errors.Is
+ * whichever API reported it. Configuration errors are *IPCError values, as
+ * returned by IpcSet and Configure, that wrap them in turn; endpoints that
+ * fail to parse wrap conn.ErrInvalidEndpoint.
+ */
+
+var (
+	ErrDeviceClosed         = errors.New("device closed")
+	ErrTooManyPeers         = errors.New("too many peers")
+	ErrPeerExists           = errors.New("adding existing peer")
+	ErrPeerNotFound         = errors.New("no such peer")
+	ErrPeerNotRunning       = errors.New("peer is not running")
+	ErrNoEndpoint           = errors.New("no known endpoint for peer")
+	ErrInvalidKey           = errors.New("invalid key")
+	ErrInvalidName          = errors.New("invalid peer name")
+	ErrUnknownCipherSuite   = errors.New("unknown cipher suite")
+	ErrUnknownHandshakeHash = errors.New("unknown handshake hash")
+	ErrCryptoSelfTest       = errors.New("crypto self-test failed")
+	ErrTimeout              = errors.New("timed out")          // a ping or handshake got no answer in time
+	ErrUnderLoad            = errors.New("peer is under load") // a handshake was answered with cookie replies only
+	ErrHandshakeAuth        = errors.New("handshake response failed authentication")
+	ErrTakenOver            = errors.New("standby took over") // replication ended as the standby became active
+)
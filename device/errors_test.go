@@ -0,0 +1,97 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/ipc"
+)
+
+func TestErrors(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	dev := pair[0].dev
+	peer := dev.LookupPeer(pair[1].dev.staticIdentity.publicKey)
+
+	if _, err := dev.NewPeer(peer.handshake.remoteStatic); !errors.Is(err, ErrPeerExists) {
+		t.Errorf("NewPeer of existing peer: %v", err)
+	}
+	if err := peer.SetCipherSuite("nope"); !errors.Is(err, ErrUnknownCipherSuite) {
+		t.Errorf("SetCipherSuite: %v", err)
+	}
+	for name, tag := range taggedCipherSuites {
+		if LookupCipherSuite(name) != nil {
+			continue
+		}
+		if err := peer.SetCipherSuite(name); !errors.Is(err, ErrUnknownCipherSuite) || !strings.Contains(err.Error(), tag) {
+			t.Errorf("SetCipherSuite of %s without its tag: %v", name, err)
+		}
+	}
+	if err := peer.SetName("\x00"); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("SetName: %v", err)
+	}
+
+	tests := []struct {
+		config string
+		want   error
+	}{
+		{"private_key=zz\n", ErrInvalidKey},
+		{"public_key=00\n", ErrInvalidKey},
+		{uapiCfg("public_key", hex.EncodeToString(peer.handshake.remoteStatic[:]), "endpoint", "nowhere"), conn.ErrInvalidEndpoint},
+	}
+	for _, tt := range tests {
+		err := dev.IpcSet(tt.config)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("IpcSet(%q) = %v, want %v", tt.config, err, tt.want)
+		}
+		var ipcErr *IPCError
+		if !errors.As(err, &ipcErr) || ipcErr.ErrorCode() != ipc.IpcErrorInvalid {
+			t.Errorf("IpcSet(%q) = %v, want an IPCError with code %d", tt.config, err, ipc.IpcErrorInvalid)
+		}
+	}
+
+	unknown := NoisePublicKey{1}
+	if err := dev.ipcPingPeer(io.Discard, hex.EncodeToString(unknown[:])); !errors.Is(err, ErrPeerNotFound) {
+		t.Errorf("ipcPingPeer of unknown peer: %v", err)
+	}
+
+	pair[1].dev.rate.underLoadUntil.Store(time.Now().Add(time.Minute).UnixNano())
+	_, err := peer.Handshake(time.Second)
+	pair[1].dev.rate.underLoadUntil.Store(0)
+	var handshakeErr *HandshakeError
+	if !errors.Is(err, ErrUnderLoad) || !errors.As(err, &handshakeErr) {
+		t.Errorf("Handshake under load: %v", err)
+	}
+
+	peer.Stop()
+	if _, err := peer.Ping(time.Second); !errors.Is(err, ErrPeerNotRunning) {
+		t.Errorf("Ping of stopped peer: %v", err)
+	}
+	dev.Close()
+	if _, err := dev.NewPeer(unknown); !errors.Is(err, ErrDeviceClosed) {
+		t.Errorf("NewPeer on closed device: %v", err)
+	}
+}
+
+func TestHandshakeErrorUnwrap(t *testing.T) {
+	for reason, want := range map[HandshakeFailure]error{
+		HandshakeNoResponse:  ErrTimeout,
+		HandshakeCookieLoop:  ErrUnderLoad,
+		HandshakeMACFailure:  ErrHandshakeAuth,
+		HandshakeRateLimited: ErrUnderLoad,
+	} {
+		if err := error(&HandshakeError{Reason: reason}); !errors.Is(err, want) {
+			t.Errorf("%v does not wrap %v", reason, want)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+/* Subscribers are the in-process counterpart of observers: software that
+ * embeds the device passes a channel to Subscribe, and the device sends it
+ * a typed Event as the state of a peer changes, rather than the caller
+ * polling IpcGet or Status for it.
+ *
+ * As with observers, an event is dropped rather than delaying the device
+ * if a subscriber's channel is full, so a subscriber should give its
+ * channel a buffer and drain it promptly; EventsDropped counts what was
+ * lost. The device never closes a subscriber's channel, not even when it
+ * is closed itself.
+ */
+
+// An Event is a change of the state of a peer, one of HandshakeCompleted,
+// KeypairRotated, PeerEndpointChanged, DecryptFailure or PeerExpired.
+type Event interface {
+	Header() EventHeader
+}
+
+// An EventHeader is the part of every Event that says what peer it
+// concerns and when it happened.
+type EventHeader struct {
+	PublicKey NoisePublicKey
+	Name      string // of the peer, if it has one
+	Time      time.Time
+}
+
+func (h EventHeader) Header() EventHeader { return h }
+
+// HandshakeCompleted is sent when a handshake with a peer completes: for
+// the initiator when it receives the response, and for the responder when
+// the first data packet confirms the new keypair. It follows the
+// KeypairRotated event of that keypair.
+type HandshakeCompleted struct {
+	EventHeader
+	Initiator bool   // whether this side initiated the handshake
+	Suite     string // cipher suite of the new session
+}
+
+// KeypairRotated is sent when a new keypair becomes the current one of a
+// peer, the one packets are sent with, and the old one, if any, previous.
+type KeypairRotated struct {
+	EventHeader
+	LocalIndex    uint32 // of the new keypair
+	PreviousIndex uint32 // of the keypair it replaced, or 0 if none
+	Suite         string
+}
+
+// PeerEndpointChanged is sent when the endpoint of a peer changes, whether
+// by configuration, by roaming, by switching to a better path or by falling
+// back to a previous endpoint. From is empty if the peer had none.
+type PeerEndpointChanged struct {
+	EventHeader
+	From, To string
+}
+
+// DecryptFailure is sent when transport packets from a peer fail to
+// authenticate, at most once per batch of received packets.
+type DecryptFailure struct {
+	EventHeader
+	Count    int    // packets of the batch that failed
+	Endpoint string // that the first of them came from
+}
+
+// PeerExpired is sent when all keys of a peer are removed, since no new
+// ones were received in RejectAfterTime*3.
+type PeerExpired struct {
+	EventHeader
+}
+
+// Subscribe adds ch to the channels that events are sent to. Subscribing
+// a channel twice has no further effect.
+func (device *Device) Subscribe(ch chan<- Event) {
+	device.subscribers.Lock()
+	defer device.subscribers.Unlock()
+	if device.subscribers.chans == nil {
+		device.subscribers.chans = make(map[chan<- Event]struct{})
+	}
+	device.subscribers.chans[ch] = struct{}{}
+	device.subscribers.count.Store(int32(len(device.subscribers.chans)))
+}
+
+// Unsubscribe removes ch from the channels that events are sent to. Once
+// it returns, no more events are sent to ch.
+func (device *Device) Unsubscribe(ch chan<- Event) {
+	device.subscribers.Lock()
+	defer device.subscribers.Unlock()
+	delete(device.subscribers.chans, ch)
+	device.subscribers.count.Store(int32(len(device.subscribers.chans)))
+}
+
+// EventsDropped returns the number of events dropped because the channel
+// of a subscriber was full.
+func (device *Device) EventsDropped() uint64 {
+	return device.subscribers.dropped.Load()
+}
+
+// subscribed reports whether there are subscribers, so that callers can
+// skip building events nobody would receive.
+func (device *Device) subscribed() bool {
+	return device.subscribers.count.Load() != 0
+}
+
+func (peer *Peer) eventHeader() EventHeader {
+	return EventHeader{PublicKey: peer.handshake.remoteStatic, Name: peer.Name(), Time: time.Now()}
+}
+
+// emit sends event to every subscriber.
+func (device *Device) emit(event Event) {
+	device.subscribers.Lock()
+	defer device.subscribers.Unlock()
+	for ch := range device.subscribers.chans {
+		select {
+		case ch <- event:
+		default:
+			device.subscribers.dropped.Add(1)
+		}
+	}
+}
+
+// emitKeypairRotatedLocked sends a KeypairRotated event for the current
+// keypair of peer, which replaced previous. It must be called with
+// peer.keypairs held.
+func (peer *Peer) emitKeypairRotatedLocked(previous *Keypair) {
+	if !peer.device.subscribed() {
+		return
+	}
+	current := peer.keypairs.current
+	event := KeypairRotated{EventHeader: peer.eventHeader(), LocalIndex: current.localIndex, Suite: current.suite.Name}
+	if previous != nil {
+		event.PreviousIndex = previous.localIndex
+	}
+	peer.device.emit(event)
+}
+
+// emitDecryptFailure sends a DecryptFailure event for the count elems that
+// failed to decrypt.
+func (peer *Peer) emitDecryptFailure(elems []*QueueInboundElement, count int) {
+	event := DecryptFailure{EventHeader: peer.eventHeader(), Count: count}
+	for _, elem := range elems {
+		if elem.packet == nil {
+			event.Endpoint = elem.endpoint.DstToString()
+			break
+		}
+	}
+	peer.device.emit(event)
+}
+
+// emitEndpointChangedLocked sends a PeerEndpointChanged event if the
+// endpoint of peer is no longer the address of old. It must be called with
+// peer.endpoint held.
+func (peer *Peer) emitEndpointChangedLocked(old conn.Endpoint) {
+	if !peer.device.subscribed() {
+		return
+	}
+	var from, to string
+	if old != nil {
+		from = old.DstToString()
+	}
+	if peer.endpoint.val != nil {
+		to = peer.endpoint.val.DstToString()
+	}
+	if from != to {
+		peer.device.emit(PeerEndpointChanged{EventHeader: peer.eventHeader(), From: from, To: to})
+	}
+}
@@ -0,0 +1,151 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// nextEvent returns the next event of type T from ch, skipping events of
+// other types.
+func nextEvent[T Event](t *testing.T, ch <-chan Event) (event T) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case e := <-ch:
+			if event, ok := e.(T); ok {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("no %T event", event)
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	dev := pair[0].dev
+	peer := dev.LookupPeer(pair[1].dev.staticIdentity.publicKey)
+	sender := pair[1].dev.LookupPeer(dev.staticIdentity.publicKey)
+	var events [2]chan Event
+	for i := range pair {
+		events[i] = make(chan Event, 64)
+		pair[i].dev.Subscribe(events[i])
+		defer pair[i].dev.Unsubscribe(events[i])
+	}
+
+	// A ping makes the second device initiate a handshake, which the first
+	// completes on receiving it.
+	pair.Send(t, Ping, nil)
+	for i := range pair {
+		rotated := nextEvent[KeypairRotated](t, events[i])
+		current := pair[i].dev.LookupPeer(pair[i^1].dev.staticIdentity.publicKey).keypairs.Current()
+		if rotated.LocalIndex != current.localIndex || rotated.PreviousIndex != 0 {
+			t.Errorf("device %d: rotated to %+v, current index %d", i, rotated, current.localIndex)
+		}
+		handshake := nextEvent[HandshakeCompleted](t, events[i])
+		if handshake.Initiator != (i == 1) || handshake.Suite == "" || handshake.Time.IsZero() {
+			t.Errorf("device %d: %+v", i, handshake)
+		}
+		if want := pair[i^1].dev.staticIdentity.publicKey; handshake.PublicKey != want {
+			t.Errorf("device %d: handshake with %x, want %x", i, handshake.PublicKey[:], want[:])
+		}
+	}
+
+	// Transport packets that fail to decrypt are reported once per batch.
+	keypair := sender.keypairs.Current()
+	garbage := make([][]byte, 3)
+	for i := range garbage {
+		packet := make([]byte, MessageTransportSize+16)
+		binary.LittleEndian.PutUint32(packet, MessageTransportType)
+		binary.LittleEndian.PutUint32(packet[MessageTransportOffsetReceiver:], keypair.remoteIndex)
+		binary.LittleEndian.PutUint64(packet[MessageTransportOffsetCounter:], uint64(1<<20+i))
+		garbage[i] = packet
+	}
+	if err := sender.SendBuffers(garbage); err != nil {
+		t.Fatal(err)
+	}
+	failures := 0
+	for failures < len(garbage) {
+		failure := nextEvent[DecryptFailure](t, events[0])
+		if failure.Endpoint == "" {
+			t.Errorf("failure %+v", failure)
+		}
+		failures += failure.Count
+	}
+	if failures != len(garbage) {
+		t.Errorf("reported %d decrypt failures, want %d", failures, len(garbage))
+	}
+
+	// Setting the endpoint to the one the peer has sends nothing.
+	peer.endpoint.Lock()
+	from := peer.endpoint.val.DstToString()
+	peer.endpoint.Unlock()
+	assertNil(t, dev.IpcSet(fmt.Sprintf("public_key=%x\nendpoint=%s\n", sender.device.staticIdentity.publicKey[:], from)))
+	assertNil(t, dev.IpcSet(fmt.Sprintf("public_key=%x\nendpoint=127.0.0.1:1\n", sender.device.staticIdentity.publicKey[:])))
+	if changed := nextEvent[PeerEndpointChanged](t, events[0]); changed.From != from || changed.To != "127.0.0.1:1" {
+		t.Errorf("endpoint changed %+v, want from %s", changed, from)
+	}
+
+	expiredZeroKeyMaterial(peer)
+	if expired := nextEvent[PeerExpired](t, events[0]); expired.PublicKey != peer.handshake.remoteStatic {
+		t.Errorf("expired %+v", expired)
+	}
+}
+
+func TestSubscribeDrops(t *testing.T) {
+	dev := randDevice(t)
+	defer dev.Close()
+	sk, err := newPrivateKey()
+	assertNil(t, err)
+	peer, err := dev.NewPeer(sk.publicKey())
+	assertNil(t, err)
+
+	full := make(chan Event)
+	dev.Subscribe(full)
+	dev.Subscribe(full)
+	expiredZeroKeyMaterial(peer)
+	if n := dev.EventsDropped(); n != 1 {
+		t.Errorf("dropped %d events, want 1", n)
+	}
+
+	dev.Unsubscribe(full)
+	if dev.subscribed() {
+		t.Error("subscribed after unsubscribing")
+	}
+	expiredZeroKeyMaterial(peer)
+	if n := dev.EventsDropped(); n != 1 {
+		t.Errorf("dropped %d events after unsubscribing, want 1", n)
+	}
+}
+
+func TestSubscribeDummyPeerEndpoint(t *testing.T) {
+	dev := randDevice(t)
+	defer dev.Close()
+	events := make(chan Event, 8)
+	dev.Subscribe(events)
+	defer dev.Unsubscribe(events)
+	sk, err := newPrivateKey()
+	assertNil(t, err)
+
+	// Setting the endpoint of a peer that update_only keeps from being
+	// created, or of the device's own key, sets that of a placeholder peer
+	// with no device, and sends nothing.
+	for _, publicKey := range []NoisePublicKey{sk.publicKey(), dev.staticIdentity.publicKey} {
+		assertNil(t, dev.IpcSet(fmt.Sprintf("public_key=%x\nupdate_only=true\nendpoint=127.0.0.1:1234\n", publicKey[:])))
+	}
+	if len(events) != 0 {
+		t.Errorf("sent %+v", <-events)
+	}
+	if dev.LookupPeer(sk.publicKey()) != nil {
+		t.Error("update_only created the peer")
+	}
+}
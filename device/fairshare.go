@@ -0,0 +1,194 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"container/heap"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+/* Weighted fair share of encryption
+ *
+ * Batches of outbound packets are encrypted by workers pulling from one
+ * queue shared by all peers, so once the workers are saturated every peer
+ * waits behind the backlog of the busiest: a management tunnel stalls as
+ * badly as the bulk transfer filling the queue. While the shared queue
+ * holds at least twice as many batches as there are workers, batches are
+ * instead held back per peer and fed to the queue in proportion to the
+ * encryption weights of their peers, by stride scheduling over the packets
+ * of each batch, keeping the shared queue short enough that a batch of a
+ * heavily weighted peer is encrypted soon after it is sent. Peers of equal
+ * weight share the workers evenly whatever their demand, and a peer with
+ * less than its share of traffic is encrypted without waiting for others.
+ *
+ * Packets are still sent in order: the sequential sender of a peer waits
+ * for the encryption of each batch in turn. A peer held back long enough
+ * fills its own outbound queue, holding up the TUN reader as before.
+ */
+
+// strideScale is the pass a weight of one advances by per packet.
+const strideScale = 1 << 20
+
+type encryptionShare struct {
+	weight atomic.Int32 // zero for DefaultEncryptionWeight
+
+	// Protected by the device's encryptionScheduler.
+	pass    uint64
+	pending []*QueueOutboundElementsContainer
+	index   int // in the scheduler's heap, or -1
+}
+
+type encryptionScheduler struct {
+	sync.Mutex
+	peers      shareHeap // peers with pending batches, by pass
+	pass       uint64    // pass of the peer last served
+	dispatcher bool      // whether dispatchEncryption is running
+
+	pending   atomic.Int32  // batches held back
+	scheduled atomic.Uint64 // batches held back since the device was created
+	wake      chan struct{} // signalled by workers taking batches while batches are held back
+}
+
+type shareHeap []*Peer
+
+func (h shareHeap) Len() int { return len(h) }
+func (h shareHeap) Less(i, j int) bool {
+	return h[i].encryptionShare.pass < h[j].encryptionShare.pass
+}
+
+func (h shareHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].encryptionShare.index = i
+	h[j].encryptionShare.index = j
+}
+
+func (h *shareHeap) Push(x any) {
+	peer := x.(*Peer)
+	peer.encryptionShare.index = len(*h)
+	*h = append(*h, peer)
+}
+
+func (h *shareHeap) Pop() any {
+	old := *h
+	peer := old[len(old)-1]
+	old[len(old)-1] = nil
+	peer.encryptionShare.index = -1
+	*h = old[:len(old)-1]
+	return peer
+}
+
+// SetEncryptionWeight sets the weight of the peer's share of the encryption
+// workers while they are saturated, from 1 to MaxEncryptionWeight, or zero
+// for DefaultEncryptionWeight.
+func (peer *Peer) SetEncryptionWeight(weight int) error {
+	if weight < 0 || weight > MaxEncryptionWeight {
+		return fmt.Errorf("encryption weight %d out of range", weight)
+	}
+	peer.encryptionShare.weight.Store(int32(weight))
+	return nil
+}
+
+// EncryptionWeight returns the weight set with SetEncryptionWeight.
+func (peer *Peer) EncryptionWeight() int {
+	if weight := peer.encryptionShare.weight.Load(); weight != 0 {
+		return int(weight)
+	}
+	return DefaultEncryptionWeight
+}
+
+// EncryptionScheduled returns the number of batches of packets held back
+// for their peer's share of the encryption workers, since the device was
+// created.
+func (device *Device) EncryptionScheduled() uint64 {
+	return device.encryptionScheduler.scheduled.Load()
+}
+
+// encryptionBacklog returns the number of batches queued for encryption
+// from which on batches are held back.
+func (device *Device) encryptionBacklog() int {
+	return min(max(2*int(device.workers.encryption.running.Load()), 2), cap(device.queue.encryption.c))
+}
+
+// queueEncryption queues a batch of packets to peer for encryption, holding
+// it back for the peer's share if the encryption workers are saturated. The
+// caller must hold a reference to the encryption queue.
+func (device *Device) queueEncryption(peer *Peer, elemsContainer *QueueOutboundElementsContainer) {
+	s := &device.encryptionScheduler
+	if s.pending.Load() == 0 && len(device.queue.encryption.c) < device.encryptionBacklog() {
+		device.queue.encryption.c <- elemsContainer
+		device.workers.encryption.grow(len(device.queue.encryption.c))
+		return
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	share := &peer.encryptionShare
+	share.pending = append(share.pending, elemsContainer)
+	if share.index < 0 {
+		// A peer that was idle starts at the current pass, rather than
+		// catching up on the share it did not use.
+		share.pass = max(share.pass, s.pass)
+		heap.Push(&s.peers, peer)
+	}
+	s.pending.Add(1)
+	s.scheduled.Add(1)
+	if !s.dispatcher {
+		s.dispatcher = true
+		device.queue.encryption.wg.Add(1) // keep encryption queue open for the dispatcher
+		go device.dispatchEncryption()
+	}
+}
+
+// dispatchEncryption feeds held back batches to the encryption queue in
+// order of their peers' passes, for as long as there are any.
+func (device *Device) dispatchEncryption() {
+	defer device.queue.encryption.wg.Done()
+	s := &device.encryptionScheduler
+	for {
+		for len(device.queue.encryption.c) >= device.encryptionBacklog() {
+			<-s.wake
+		}
+		s.Lock()
+		if len(s.peers) == 0 {
+			s.dispatcher = false
+			s.Unlock()
+			return
+		}
+		peer := s.peers[0]
+		share := &peer.encryptionShare
+		elemsContainer := share.pending[0]
+		share.pending[0] = nil
+		share.pending = share.pending[1:]
+		s.pass = share.pass
+		share.pass += uint64(max(len(elemsContainer.elems), 1)) * strideScale / uint64(peer.EncryptionWeight())
+		if len(share.pending) == 0 {
+			share.pending = nil
+			heap.Pop(&s.peers)
+		} else {
+			heap.Fix(&s.peers, 0)
+		}
+		s.pending.Add(-1)
+		s.Unlock()
+
+		device.queue.encryption.c <- elemsContainer
+		device.workers.encryption.grow(len(device.queue.encryption.c))
+	}
+}
+
+// encryptionTaken tells the dispatcher that an encryption worker is done
+// with a batch, if batches are held back.
+func (device *Device) encryptionTaken() {
+	s := &device.encryptionScheduler
+	if s.pending.Load() == 0 {
+		return
+	}
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
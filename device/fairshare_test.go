@@ -0,0 +1,104 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncryptionShares(t *testing.T) {
+	device := new(Device)
+	device.queue.encryption = newOutboundQueue(64)
+	device.encryptionScheduler.wake = make(chan struct{}, 1)
+	// One worker that is never started, so that the test drains the queue.
+	device.workers.encryption.min, device.workers.encryption.max = 1, 1
+	device.workers.encryption.running.Store(1)
+
+	bulk, management := new(Peer), new(Peer)
+	bulk.encryptionShare.index, management.encryptionShare.index = -1, -1
+	if err := management.SetEncryptionWeight(MaxEncryptionWeight + 1); err == nil {
+		t.Error("weight beyond maximum accepted")
+	}
+	management.SetEncryptionWeight(9 * DefaultEncryptionWeight)
+
+	batches := make(map[*QueueOutboundElementsContainer]*Peer)
+	queue := func(peer *Peer) {
+		c := &QueueOutboundElementsContainer{elems: make([]*QueueOutboundElement, 4)}
+		batches[c] = peer
+		device.queueEncryption(peer, c)
+	}
+	// The bulk peer saturates the queue, then both peers send as much.
+	for range 2 + 20 {
+		queue(bulk)
+	}
+	for range 20 {
+		queue(management)
+	}
+	if scheduled := device.EncryptionScheduled(); scheduled != 40 {
+		t.Errorf("scheduled = %d", scheduled)
+	}
+
+	var order []*Peer
+	for len(order) < len(batches) {
+		select {
+		case c := <-device.queue.encryption.c:
+			order = append(order, batches[c])
+			device.encryptionTaken()
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only %d of %d batches dispatched", len(order), len(batches))
+		}
+	}
+	// Past the two batches queued before saturation, the management peer
+	// gets nine batches for every one of the bulk peer's, so all twenty of
+	// its batches go before the fourth of the bulk peer's.
+	var bulkBefore, managementSeen int
+	for _, peer := range order[2:] {
+		if managementSeen == 20 {
+			break
+		}
+		if peer == management {
+			managementSeen++
+		} else {
+			bulkBefore++
+		}
+	}
+	if bulkBefore > 3 {
+		t.Errorf("%d bulk batches dispatched before the management peer's", bulkBefore)
+	}
+	if pending := device.encryptionScheduler.pending.Load(); pending != 0 {
+		t.Errorf("%d batches still held back", pending)
+	}
+}
+
+func TestEncryptionWeightUAPI(t *testing.T) {
+	pair := genTestPair(t, false)
+	pk := pair[0].dev.staticIdentity.publicKey
+	peer := pair[1].dev.LookupPeer(pk)
+	if weight := peer.EncryptionWeight(); weight != DefaultEncryptionWeight {
+		t.Errorf("default weight = %d", weight)
+	}
+	if err := pair[1].dev.IpcSet(uapiCfg("public_key", hex.EncodeToString(pk[:]), "encryption_weight", "1001")); err == nil {
+		t.Error("weight beyond maximum accepted")
+	}
+	if err := pair[1].dev.IpcSet(uapiCfg("public_key", hex.EncodeToString(pk[:]), "encryption_weight", "900")); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := pair[1].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(cfg, "\nencryption_weight=900\n") {
+		t.Errorf("get output lacks encryption weight:\n%s", cfg)
+	}
+	if status := pair[1].dev.Status(); status.Peers[0].EncryptionWeight != 900 {
+		t.Errorf("status encryption weight = %d", status.Peers[0].EncryptionWeight)
+	}
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+}
@@ -0,0 +1,148 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"testing"
+)
+
+// fuzzFixed returns b cut or zero-padded to n bytes.
+func fuzzFixed(b []byte, n int) []byte {
+	fixed := make([]byte, n)
+	copy(fixed, b)
+	return fixed
+}
+
+// fuzzChunks splits message into chunks of the sizes of splits, the last
+// chunk taking what is left.
+func fuzzChunks(message, splits []byte) [][]byte {
+	var chunks [][]byte
+	for _, size := range splits {
+		n := min(int(size), len(message))
+		chunks = append(chunks, message[:n])
+		message = message[n:]
+	}
+	return append(chunks, message)
+}
+
+func FuzzPoly1795Chunking(f *testing.F) {
+	key := selfTestInput(32, 0x20)
+	for _, size := range []int{0, 1, 23, 24, 25, 95, 96, 97, 200} {
+		message := selfTestInput(size, byte(size))
+		f.Add(key, message, []byte{})
+		f.Add(key, message, []byte{1, 0, 22})
+		f.Add(key, message, []byte{23, 1, 24, 24, 24})
+		f.Add(key, message, []byte{48, 47})
+	}
+	f.Add(bytes.Repeat([]byte{0xff}, 32), bytes.Repeat([]byte{0xff}, 96), []byte{96})
+
+	f.Fuzz(func(t *testing.T, key, message, splits []byte) {
+		k := (*[32]byte)(fuzzFixed(key, 32))
+		var want [Poly1795Size]byte
+		Poly1795Sum(&want, message, k)
+		var vec [Poly1795Size]byte
+		Poly1795SumVec(&vec, message, k)
+		if vec != want {
+			t.Fatal("vectorized tag differs")
+		}
+
+		for _, vectorized := range []bool{false, true} {
+			var mac poly1795MAC
+			mac.init(k)
+			mac.vec = vectorized
+			for _, chunk := range fuzzChunks(message, splits) {
+				mac.Write(chunk)
+			}
+			var got [Poly1795Size]byte
+			mac.Sum(got[:0])
+			if got != want {
+				t.Fatalf("tag of the message written in chunks %v differs, vectorized %v", splits, vectorized)
+			}
+		}
+		hash := New1795(k)
+		for _, chunk := range fuzzChunks(message, splits) {
+			hash.Write(chunk)
+		}
+		if !hash.Verify(want[:]) || !Poly1795Verify(&want, message, k) {
+			t.Fatal("tag failed to verify")
+		}
+		want[0] ^= 1
+		if Poly1795Verify(&want, message, k) {
+			t.Fatal("wrong tag verified")
+		}
+	})
+}
+
+func FuzzChaCha24RoundTrip(f *testing.F) {
+	key, nonce := selfTestInput(chachaKeySize, 0), selfTestInput(chachaNonceSize, 0x40)
+	for _, size := range []int{0, 1, 63, 64, 65, 255, 256, 257, 600} {
+		plaintext := selfTestInput(size, byte(size))
+		f.Add(key, nonce, plaintext, uint32(0), []byte{})
+		f.Add(key, nonce, plaintext, uint32(1), []byte{1, 63, 64, 0, 200})
+		f.Add(key, nonce, plaintext, uint32(1<<32-8), []byte{65, 191})
+	}
+
+	f.Fuzz(func(t *testing.T, key, nonce, plaintext []byte, counter uint32, splits []byte) {
+		key, nonce = fuzzFixed(key, chachaKeySize), fuzzFixed(nonce, chachaNonceSize)
+		if uint64(counter)+uint64(len(plaintext)+63)/64 > 1<<32 {
+			return
+		}
+
+		// Encrypting in one go, in chunks and a block at a time agree.
+		c, err := NewChaCha20x24Cipher(key, nonce)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.SetCounter(counter)
+		ciphertext := make([]byte, len(plaintext))
+		c.XORKeyStream(ciphertext, plaintext)
+		c, _ = NewChaCha20x24Cipher(key, nonce)
+		c.SetCounter(counter)
+		chunked := make([]byte, 0, len(plaintext))
+		for _, chunk := range fuzzChunks(plaintext, splits) {
+			out := make([]byte, len(chunk))
+			c.XORKeyStream(out, chunk)
+			chunked = append(chunked, out...)
+		}
+		if !bytes.Equal(chunked, ciphertext) {
+			t.Fatalf("ciphertext of the plaintext encrypted in chunks %v differs", splits)
+		}
+		for i := 0; i*64 < len(plaintext); i++ {
+			var block [64]byte
+			chachaBlock24((*[32]byte)(key), (*[16]byte)(nonce), counter+uint32(i), &block)
+			end := min(len(plaintext), (i+1)*64)
+			for j := i * 64; j < end; j++ {
+				if ciphertext[j] != plaintext[j]^block[j-i*64] {
+					t.Fatalf("keystream block %d unlike that of the block function", i)
+				}
+			}
+		}
+		c, _ = NewChaCha20x24Cipher(key, nonce)
+		c.SetCounter(counter)
+		c.XORKeyStream(chunked, chunked)
+		if !bytes.Equal(chunked, plaintext) {
+			t.Fatal("decryption differs from the plaintext")
+		}
+
+		// The AEAD opens what it seals, and nothing else.
+		aead, err := NewChaCha20x24Poly1795(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sealed := aead.Seal(nil, nonce, plaintext, splits)
+		opened, err := aead.Open(nil, nonce, sealed, splits)
+		if err != nil || !bytes.Equal(opened, plaintext) {
+			t.Fatalf("failed to open what was sealed: %v", err)
+		}
+		sealed[int(counter)%len(sealed)] ^= 1
+		if _, err := aead.Open(nil, nonce, sealed, splits); err == nil {
+			t.Fatal("opened a corrupted message")
+		}
+	})
+}
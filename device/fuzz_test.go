@@ -0,0 +1,150 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func FuzzHandshakeInitiation(f *testing.F) {
+	dev1 := randDevice(f)
+	dev2 := randDevice(f)
+	f.Cleanup(dev1.Close)
+	f.Cleanup(dev2.Close)
+	if _, err := dev2.NewPeer(dev1.staticIdentity.privateKey.publicKey()); err != nil {
+		f.Fatal(err)
+	}
+	peer, err := dev1.NewPeer(dev2.staticIdentity.privateKey.publicKey())
+	if err != nil {
+		f.Fatal(err)
+	}
+	valid, err := dev1.CreateMessageInitiation(peer)
+	if err != nil {
+		f.Fatal(err)
+	}
+	var packet [MessageInitiationSize]byte
+	valid.marshal(packet[:])
+	peer.cookieGenerator.AddMacs(packet[:])
+
+	f.Add(packet[:], false)
+	f.Add(packet[:], true)
+	f.Add(packet[:MessageInitiationSize-1], false)
+	f.Add(append(bytes.Clone(packet[:]), 0), false)
+	for _, offset := range []int{0, 4, 8, 8 + len(valid.Ephemeral), MessageInitiationSize - 2*16 - 1} {
+		mutated := bytes.Clone(packet[:])
+		mutated[offset] ^= 1
+		f.Add(mutated, true)
+	}
+
+	f.Fuzz(func(t *testing.T, packet []byte, addMACs bool) {
+		// With addMACs, the initiation gets past MAC1 as if its sender knew
+		// the responder's public key, so that its payload is decrypted.
+		if addMACs && len(packet) == MessageInitiationSize {
+			packet = bytes.Clone(packet)
+			peer.cookieGenerator.AddMacs(packet)
+		}
+		var msg MessageInitiation
+		if err := msg.unmarshal(packet); err != nil {
+			if len(packet) == MessageInitiationSize {
+				t.Fatalf("failed to unmarshal an initiation of the right size: %v", err)
+			}
+			return
+		}
+		var marshaled [MessageInitiationSize]byte
+		msg.marshal(marshaled[:])
+		if !bytes.Equal(marshaled[:], packet) {
+			t.Fatal("initiation marshaled unlike it was unmarshaled")
+		}
+		if !dev2.cookieChecker.CheckMAC1(packet) {
+			if addMACs {
+				t.Fatal("MAC1 added failed to verify")
+			}
+			return
+		}
+		if dev2.ConsumeMessageInitiation(&msg) != nil && (msg.Ephemeral != valid.Ephemeral || msg.Static != valid.Static || msg.Timestamp != valid.Timestamp) {
+			t.Fatal("consumed a modified initiation")
+		}
+	})
+}
+
+// fuzzKeypairs returns the keypairs of both ends of a session of each suite
+// registered outside tests, under the same key.
+func fuzzKeypairs(tb testing.TB) (send, receive []*Keypair) {
+	key := selfTestInput(32, 0)
+	for _, name := range CipherSuites() {
+		if strings.HasPrefix(name, "Test") {
+			continue
+		}
+		suite := LookupCipherSuite(name)
+		aead, err := suite.New(key)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		send = append(send, &Keypair{suite: suite, send: aead, receive: aead, isInitiator: true, remoteIndex: 42})
+		receive = append(receive, &Keypair{suite: suite, send: aead, receive: aead, localIndex: 42})
+	}
+	return
+}
+
+// fuzzTransportPacket returns the transport message of the plaintext sent
+// with the keypair with the counter.
+func fuzzTransportPacket(keypair *Keypair, counter uint64, plaintext []byte) []byte {
+	packet := make([]byte, MessageTransportHeaderSize, MessageTransportHeaderSize+len(plaintext)+keypair.suite.Overhead())
+	binary.LittleEndian.PutUint32(packet[0:], MessageTransportType)
+	binary.LittleEndian.PutUint32(packet[MessageTransportOffsetReceiver:], keypair.remoteIndex)
+	binary.LittleEndian.PutUint64(packet[MessageTransportOffsetCounter:], counter)
+	var nonce [maxTransportNonceSize]byte
+	return keypair.send.Seal(packet, keypair.transportNonce(&nonce, counter, true), plaintext, nil)
+}
+
+func FuzzTransportOpen(f *testing.F) {
+	dev := randDevice(f)
+	f.Cleanup(dev.Close)
+	send, receive := fuzzKeypairs(f)
+	for i, keypair := range send {
+		for _, size := range []int{0, 1, 16, 100} {
+			packet := fuzzTransportPacket(keypair, uint64(size)<<32, bytes.Repeat([]byte{byte(size)}, size))
+			f.Add(packet, uint8(i))
+			f.Add(packet[:len(packet)-1], uint8(i))
+		}
+		f.Add(fuzzTransportPacket(keypair, 0, nil)[:MinMessageTransportSize], uint8(i))
+	}
+
+	f.Fuzz(func(t *testing.T, packet []byte, index uint8) {
+		sender, keypair := send[int(index)%len(send)], receive[int(index)%len(receive)]
+		// Only well-formed messages to the keypair's index reach decryption.
+		if len(packet) < MinMessageTransportSize ||
+			binary.LittleEndian.Uint32(packet) != MessageTransportType ||
+			binary.LittleEndian.Uint32(packet[MessageTransportOffsetReceiver:]) != keypair.localIndex {
+			return
+		}
+		received := bytes.Clone(packet)
+		elem := dev.GetInboundElement()
+		elem.packet = packet
+		elem.keypair = keypair
+		elemsContainer := dev.GetInboundElementsContainer()
+		elemsContainer.Lock()
+		elemsContainer.elems = append(elemsContainer.elems, elem)
+		defer dev.PutInboundElementsContainer(elemsContainer)
+		defer dev.PutInboundElement(elem)
+		var nonce [maxTransportNonceSize]byte
+		dev.decryptElems(elemsContainer, &nonce)
+		if elemsContainer.dropped {
+			t.Fatal("decryption crashed")
+		}
+		if elem.packet == nil {
+			return
+		}
+
+		// What opens must be what the sender sealed.
+		if resealed := fuzzTransportPacket(sender, elem.counter, elem.packet); !bytes.Equal(resealed, received) {
+			t.Fatalf("%s opened a transport message its sender did not seal", keypair.suite.Name)
+		}
+	})
+}
@@ -0,0 +1,209 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/blake2s"
+)
+
+/* Handshake hashes
+ *
+ * The Noise handshake hashes its transcript, and derives its keys with the
+ * HKDF of HMAC, with BLAKE2s in standard WireGuard. A device can use
+ * another HandshakeHasher instead, to experiment with handshakes of other
+ * hash functions: SHA256 in every build, BLAKE3 in builds with the
+ * wg_experimental tag, or one an embedder registers. The hash takes the
+ * place of BLAKE2s in the standard Noise construction name, from which the
+ * initial chain key and hash are computed again, and in every step of the
+ * handshake and every key derived from its chain key: those of the session,
+ * and those of suite negotiation, response data and the post-quantum hybrid
+ * exchange. A construction name set with noise_construction is used as
+ * set. MAC1, MAC2 and cookies keep the MAC set with cookie_mac, and the
+ * PSK rotation and knock gate keep BLAKE2s.
+ *
+ * A handshake keeps the hash it started with, so changing it leaves those in
+ * progress and established sessions alone. Both peers must use the same
+ * hash, or their handshakes fail to authenticate.
+ */
+
+const (
+	// StandardHandshakeHash is the name of BLAKE2s, the handshake hash of
+	// standard WireGuard.
+	StandardHandshakeHash = "BLAKE2s"
+
+	// HandshakeHashSize is the size of the hashes and keys of the handshake.
+	HandshakeHashSize = blake2s.Size
+
+	maxHandshakeHashBlockSize = 128
+)
+
+// A HandshakeHasher is a hash function the Noise handshake can use in place
+// of BLAKE2s.
+type HandshakeHasher interface {
+	Name() string   // of the hash in Noise protocol names, such as "SHA256"
+	New() hash.Hash // of HandshakeHashSize-byte sums, in blocks of at most 128 bytes
+}
+
+type handshakeHasher struct {
+	name    string
+	newHash func() hash.Hash
+}
+
+func (h handshakeHasher) Name() string   { return h.name }
+func (h handshakeHasher) New() hash.Hash { return h.newHash() }
+
+// NewHandshakeHasher returns a HandshakeHasher of the given name and hash
+// constructor, for RegisterHandshakeHasher.
+func NewHandshakeHasher(name string, newHash func() hash.Hash) HandshakeHasher {
+	return handshakeHasher{name, newHash}
+}
+
+// A handshakeHash is a registered HandshakeHasher, with the pool of its
+// states and the construction of standard WireGuard with it.
+type handshakeHash struct {
+	HandshakeHasher
+	construction string
+	blockSize    int
+	states       sync.Pool
+}
+
+func newHandshakeHash(hasher HandshakeHasher) *handshakeHash {
+	h := &handshakeHash{
+		HandshakeHasher: hasher,
+		construction:    strings.TrimSuffix(NoiseConstruction, StandardHandshakeHash) + hasher.Name(),
+	}
+	probe := hasher.New()
+	if probe.Size() != HandshakeHashSize || probe.BlockSize() > maxHandshakeHashBlockSize {
+		panic(fmt.Sprintf("device: handshake hash %s has %d-byte sums and %d-byte blocks", hasher.Name(), probe.Size(), probe.BlockSize()))
+	}
+	h.blockSize = probe.BlockSize()
+	h.states.New = func() any {
+		return &hashState{hash: hasher.New(), owner: h}
+	}
+	return h
+}
+
+var blake2sHandshakeHash = newHandshakeHash(NewHandshakeHasher(StandardHandshakeHash, func() hash.Hash {
+	h, _ := blake2s.New256(nil)
+	return h
+}))
+
+var handshakeHashes = struct {
+	sync.RWMutex
+	m map[string]*handshakeHash
+}{m: map[string]*handshakeHash{
+	StandardHandshakeHash: blake2sHandshakeHash,
+	"SHA256":              newHandshakeHash(NewHandshakeHasher("SHA256", sha256.New)),
+}}
+
+// RegisterHandshakeHasher makes hasher available to handshakes. It panics
+// if the name is taken or its sums are not HandshakeHashSize bytes, so that
+// it can be called from init functions.
+func RegisterHandshakeHasher(hasher HandshakeHasher) {
+	if hasher == nil || hasher.Name() == "" {
+		panic("device: invalid handshake hash")
+	}
+	h := newHandshakeHash(hasher)
+	handshakeHashes.Lock()
+	defer handshakeHashes.Unlock()
+	if _, ok := handshakeHashes.m[hasher.Name()]; ok {
+		panic("device: handshake hash " + hasher.Name() + " registered twice")
+	}
+	handshakeHashes.m[hasher.Name()] = h
+}
+
+// taggedHandshakeHashes maps the names of the hashes only builds with a tag
+// register to that tag.
+var taggedHandshakeHashes = map[string]string{
+	"BLAKE3": "wg_experimental",
+}
+
+func lookupHandshakeHash(name string) (*handshakeHash, error) {
+	handshakeHashes.RLock()
+	h := handshakeHashes.m[name]
+	handshakeHashes.RUnlock()
+	if h != nil {
+		return h, nil
+	}
+	if tag, ok := taggedHandshakeHashes[name]; ok {
+		return nil, fmt.Errorf("%w %q: built without the %s tag", ErrUnknownHandshakeHash, name, tag)
+	}
+	return nil, fmt.Errorf("%w %q", ErrUnknownHandshakeHash, name)
+}
+
+// LookupHandshakeHasher returns the registered handshake hash of the given
+// name, for tools that follow the handshakes of a device from outside it.
+func LookupHandshakeHasher(name string) (HandshakeHasher, error) {
+	h, err := lookupHandshakeHash(name)
+	if err != nil {
+		return nil, err
+	}
+	return h.HandshakeHasher, nil
+}
+
+// HandshakeHashers returns the names of all registered handshake hashes,
+// sorted.
+func HandshakeHashers() []string {
+	handshakeHashes.RLock()
+	defer handshakeHashes.RUnlock()
+	names := make([]string, 0, len(handshakeHashes.m))
+	for name := range handshakeHashes.m {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// handshakeHash returns the hash of the device's handshakes.
+func (device *Device) handshakeHash() *handshakeHash {
+	if h := device.staticIdentity.hash; h != nil {
+		return h
+	}
+	return blake2sHandshakeHash
+}
+
+// HandshakeHash returns the name of the hash of the device's handshakes.
+func (device *Device) HandshakeHash() string {
+	device.staticIdentity.RLock()
+	defer device.staticIdentity.RUnlock()
+	return device.handshakeHash().Name()
+}
+
+// SetHandshakeHash sets the hash of the handshakes the device starts from
+// now on, by the name it was registered with, and computes the initial
+// chain key and hash again with it.
+func (device *Device) SetHandshakeHash(name string) error {
+	h, err := lookupHandshakeHash(name)
+	if err != nil {
+		return err
+	}
+	device.staticIdentity.Lock()
+	defer device.staticIdentity.Unlock()
+	if h == device.handshakeHash() {
+		return nil
+	}
+	device.staticIdentity.hash = h
+	device.setProtocolIdentifierLocked(device.staticIdentity.construction, device.staticIdentity.identifier)
+	if h != blake2sHandshakeHash {
+		device.log.Errorf("Non-standard handshake hash %s in use; this device is not interoperable with standard WireGuard", name)
+	}
+	return nil
+}
+
+// handshakeHash returns the hash the handshake started with.
+func (h *Handshake) handshakeHash() *handshakeHash {
+	if h.hasher == nil {
+		return blake2sHandshakeHash
+	}
+	return h.hasher
+}
@@ -0,0 +1,126 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHandshakeHashHMAC(t *testing.T) {
+	h, err := lookupHandshakeHash("SHA256")
+	assertNil(t, err)
+	if h.construction != "Noise_IKpsk2_25519_ChaChaPoly_SHA256" {
+		t.Errorf("construction %s", h.construction)
+	}
+	for _, key := range [][]byte{nil, []byte("key"), make([]byte, 64), make([]byte, 100)} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte("hello "))
+		mac.Write([]byte("world"))
+		var sum [HandshakeHashSize]byte
+		h.hmac(&sum, key, []byte("hello "), []byte("world"))
+		assertEqual(t, sum[:], mac.Sum(nil))
+	}
+}
+
+func TestHandshakeHashLookup(t *testing.T) {
+	names := HandshakeHashers()
+	if !slices.Contains(names, StandardHandshakeHash) || !slices.Contains(names, "SHA256") || !slices.IsSorted(names) {
+		t.Errorf("handshake hashes %v", names)
+	}
+	if _, err := lookupHandshakeHash("MD5"); !errors.Is(err, ErrUnknownHandshakeHash) {
+		t.Errorf("unknown hash: %v", err)
+	}
+	if _, err := lookupHandshakeHash("BLAKE3"); err != nil && !strings.Contains(err.Error(), "wg_experimental") {
+		t.Errorf("BLAKE3 without its tag: %v", err)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("registered BLAKE2s twice")
+		}
+	}()
+	RegisterHandshakeHasher(blake2sHandshakeHash.HandshakeHasher)
+}
+
+func TestHandshakeHashMismatch(t *testing.T) {
+	dev1 := randDevice(t)
+	dev2 := randDevice(t)
+	defer dev1.Close()
+	defer dev2.Close()
+
+	peer1, err := dev2.NewPeer(dev1.staticIdentity.privateKey.publicKey())
+	assertNil(t, err)
+	peer2, err := dev1.NewPeer(dev2.staticIdentity.privateKey.publicKey())
+	assertNil(t, err)
+	peer1.Start()
+	peer2.Start()
+
+	assertNil(t, dev1.SetHandshakeHash("SHA256"))
+	msg1, err := dev1.CreateMessageInitiation(peer2)
+	assertNil(t, err)
+	if dev2.ConsumeMessageInitiation(msg1) != nil {
+		t.Fatal("SHA256 initiation accepted by a BLAKE2s device")
+	}
+
+	assertNil(t, dev2.SetHandshakeHash("SHA256"))
+	msg1, err = dev1.CreateMessageInitiation(peer2)
+	assertNil(t, err)
+	if dev2.ConsumeMessageInitiation(msg1) == nil {
+		t.Fatal("initiation with matching hash was rejected")
+	}
+	msg2, err := dev2.CreateMessageResponse(peer1)
+	assertNil(t, err)
+
+	// A handshake in progress keeps its hash.
+	assertNil(t, dev1.SetHandshakeHash(StandardHandshakeHash))
+	if dev1.ConsumeMessageResponse(msg2) == nil {
+		t.Fatal("response rejected after the hash changed mid-handshake")
+	}
+	assertEqual(t, peer1.handshake.chainKey[:], peer2.handshake.chainKey[:])
+	if dev1.staticIdentity.initialHash != InitialHash || dev1.staticIdentity.initialChainKey != InitialChainKey {
+		t.Error("BLAKE2s did not restore the standard initial state")
+	}
+}
+
+func TestDeviceHandshakeHash(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	name := "SHA256"
+	assertNil(t, pair[0].dev.Configure(Config{HandshakeHash: &name}))
+	assertNil(t, pair[1].dev.IpcSet("handshake_hash=SHA256\n"))
+	for i := range pair {
+		pair[i].dev.LookupPeer(pair[1-i].dev.staticIdentity.publicKey).ExpireCurrentKeypairs()
+	}
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+
+	status := pair[0].dev.Status()
+	if status.HandshakeHash != "SHA256" || !status.Experimental {
+		t.Errorf("status handshake hash %s, experimental %v", status.HandshakeHash, status.Experimental)
+	}
+	cfg, err := pair[1].dev.IpcGet()
+	assertNil(t, err)
+	if !strings.Contains(cfg, "\nhandshake_hash=SHA256\n") {
+		t.Errorf("get output lacks the handshake hash:\n%s", cfg)
+	}
+	for i := range pair {
+		if entries, _ := pair[i].dev.AuditTrail(); len(entries) != 1 || entries[0].Setting != "handshake_hash" || entries[0].Value != "SHA256" {
+			t.Errorf("unexpected audit trail %+v", entries)
+		}
+	}
+
+	if err := pair[0].dev.IpcSet("handshake_hash=MD5\n"); err == nil {
+		t.Error("set unknown handshake hash")
+	}
+	assertNil(t, pair[0].dev.IpcSet("handshake_hash=BLAKE2s\n"))
+	if cfg, _ := pair[0].dev.IpcGet(); strings.Contains(cfg, "\nhandshake_hash=") {
+		t.Errorf("get output lists the standard handshake hash:\n%s", cfg)
+	}
+}
@@ -0,0 +1,190 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/* A handshake requested through Handshake is sent and retransmitted like any
+ * other, but the caller stops waiting at its deadline. Meanwhile, cookie
+ * replies and responses failing authentication that arrive for the peer are
+ * counted, so that the caller learns why it did not complete: a responder
+ * under load first answers with a cookie reply and may then drop initiations
+ * as rate limited, one that keeps answering with cookie replies cannot
+ * validate our cookie, for example behind a NAT changing our address, and
+ * responses failing authentication mean mismatched keys.
+ */
+
+// A HandshakeFailure is the reason a requested handshake did not complete.
+type HandshakeFailure int
+
+const (
+	HandshakeNoResponse  HandshakeFailure = iota // nothing arrived from the peer
+	HandshakeCookieLoop                          // the peer answered with cookie replies only
+	HandshakeMACFailure                          // responses failed authentication, such as with a wrong preshared key
+	HandshakeRateLimited                         // the peer is under load and did not answer after a cookie reply
+)
+
+func (f HandshakeFailure) String() string {
+	switch f {
+	case HandshakeNoResponse:
+		return "no_response"
+	case HandshakeCookieLoop:
+		return "cookie_loop"
+	case HandshakeMACFailure:
+		return "mac_failure"
+	case HandshakeRateLimited:
+		return "rate_limited"
+	}
+	return fmt.Sprintf("HandshakeFailure(%d)", int(f))
+}
+
+// A HandshakeError is returned by Handshake if no handshake completed before
+// the deadline.
+type HandshakeError struct {
+	Reason           HandshakeFailure
+	CookieReplies    int // cookie replies received while waiting
+	InvalidResponses int // handshake responses that failed authentication
+}
+
+func (e *HandshakeError) Error() string {
+	switch e.Reason {
+	case HandshakeCookieLoop:
+		return fmt.Sprintf("handshake did not complete: peer sent %d cookie replies", e.CookieReplies)
+	case HandshakeMACFailure:
+		return fmt.Sprintf("handshake did not complete: %d responses failed authentication", e.InvalidResponses)
+	case HandshakeRateLimited:
+		return "handshake did not complete: peer is under load"
+	}
+	return "handshake did not complete: no response"
+}
+
+// Unwrap returns ErrTimeout, ErrUnderLoad or ErrHandshakeAuth by Reason.
+func (e *HandshakeError) Unwrap() error {
+	switch e.Reason {
+	case HandshakeCookieLoop, HandshakeRateLimited:
+		return ErrUnderLoad
+	case HandshakeMACFailure:
+		return ErrHandshakeAuth
+	}
+	return ErrTimeout
+}
+
+type peerHandshakeWait struct {
+	sync.Mutex
+	done             chan struct{} // closed when a handshake completes; nil without waiters
+	waiters          int
+	cookieReplies    int
+	invalidResponses int
+}
+
+// Handshake sends a handshake initiation to the peer, even if one was sent
+// within RekeyTimeout, and waits until a handshake completes or timeout
+// passes. It returns how long it waited, or a *HandshakeError explaining why
+// no handshake completed.
+func (peer *Peer) Handshake(timeout time.Duration) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return peer.HandshakeContext(ctx)
+}
+
+// HandshakeContext is Handshake, waiting until ctx is done rather than for a
+// timeout. If ctx is cancelled, rather than passing its deadline, it returns
+// ctx.Err().
+func (peer *Peer) HandshakeContext(ctx context.Context) (time.Duration, error) {
+	if !peer.isRunning.Load() {
+		return 0, ErrPeerNotRunning
+	}
+	w := &peer.handshakeWait
+	w.Lock()
+	if w.done == nil {
+		w.done = make(chan struct{})
+		w.cookieReplies, w.invalidResponses = 0, 0
+	}
+	w.waiters++
+	done := w.done
+	w.Unlock()
+	defer func() {
+		w.Lock()
+		w.waiters--
+		if w.waiters == 0 {
+			w.done = nil
+		}
+		w.Unlock()
+	}()
+
+	peer.handshake.mutex.Lock()
+	peer.handshake.lastSentHandshake = time.Now().Add(-(RekeyTimeout + time.Second))
+	peer.handshake.mutex.Unlock()
+	start := time.Now()
+	if err := peer.SendHandshakeInitiation(false); err != nil {
+		return 0, err
+	}
+	select {
+	case <-done:
+		return time.Since(start), nil
+	case <-ctx.Done():
+		if !errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return 0, ctx.Err()
+		}
+	}
+
+	w.Lock()
+	defer w.Unlock()
+	err := &HandshakeError{CookieReplies: w.cookieReplies, InvalidResponses: w.invalidResponses}
+	switch {
+	case w.invalidResponses > 0:
+		err.Reason = HandshakeMACFailure
+	case w.cookieReplies > 1:
+		err.Reason = HandshakeCookieLoop
+	case w.cookieReplies == 1:
+		err.Reason = HandshakeRateLimited
+	default:
+		err.Reason = HandshakeNoResponse
+	}
+	return 0, err
+}
+
+// handshakeCompleted wakes callers of Handshake.
+func (peer *Peer) handshakeCompleted() {
+	w := &peer.handshakeWait
+	w.Lock()
+	if w.done != nil {
+		close(w.done)
+		w.done = nil
+	}
+	w.Unlock()
+}
+
+func (peer *Peer) countCookieReply() {
+	w := &peer.handshakeWait
+	w.Lock()
+	if w.done != nil {
+		w.cookieReplies++
+	}
+	w.Unlock()
+}
+
+// countInvalidResponse counts a handshake response that failed
+// authentication for the peer whose initiation it claims to answer.
+func (device *Device) countInvalidResponse(packet []byte) {
+	peer := device.indexTable.Lookup(binary.LittleEndian.Uint32(packet[8:12])).peer
+	if peer == nil {
+		return
+	}
+	w := &peer.handshakeWait
+	w.Lock()
+	if w.done != nil {
+		w.invalidResponses++
+	}
+	w.Unlock()
+}
@@ -0,0 +1,73 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHandshakeDeadline(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+	pub0, pub1 := pair[0].dev.staticIdentity.publicKey, pair[1].dev.staticIdentity.publicKey
+	peer := pair[0].dev.LookupPeer(pub1)
+
+	if took, err := peer.Handshake(5 * time.Second); err != nil || took <= 0 {
+		t.Fatalf("Handshake = %v, %v", took, err)
+	}
+
+	expectFailure := func(reason HandshakeFailure) {
+		t.Helper()
+		// Let the initiation get a newer timestamp than the last one.
+		time.Sleep(50 * time.Millisecond)
+		_, err := peer.Handshake(500 * time.Millisecond)
+		var herr *HandshakeError
+		if !errors.As(err, &herr) || herr.Reason != reason {
+			t.Errorf("Handshake error = %v, want %v", err, reason)
+		}
+	}
+
+	// The responder mixing in a different preshared key makes its
+	// responses fail authentication.
+	if err := pair[1].dev.IpcSet(uapiCfg("public_key", fmt.Sprintf("%x", pub0[:]), "preshared_key", fmt.Sprintf("%064x", 1))); err != nil {
+		t.Fatal(err)
+	}
+	expectFailure(HandshakeMACFailure)
+	if err := pair[1].dev.IpcSet(uapiCfg("public_key", fmt.Sprintf("%x", pub0[:]), "preshared_key", fmt.Sprintf("%064x", 0))); err != nil {
+		t.Fatal(err)
+	}
+
+	pair[1].dev.rate.underLoadUntil.Store(time.Now().Add(time.Minute).UnixNano())
+	expectFailure(HandshakeRateLimited)
+	pair[1].dev.rate.underLoadUntil.Store(0)
+
+	pair[1].dev.RemovePeer(pub0)
+	expectFailure(HandshakeNoResponse)
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go pair[0].dev.IpcHandle(server)
+	time.Sleep(50 * time.Millisecond)
+	fmt.Fprintf(client, "handshake_peer=%x 1\n\n", pub1[:])
+	reply := make([]byte, 0, 128)
+	buf := make([]byte, 128)
+	for !bytes.HasSuffix(reply, []byte("\n\n")) {
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply = append(reply, buf[:n]...)
+	}
+	if !bytes.HasPrefix(reply, []byte("handshake_error=no_response\n")) || bytes.HasSuffix(reply, []byte("errno=0\n\n")) {
+		t.Errorf("unexpected handshake_peer reply: %q", reply)
+	}
+}
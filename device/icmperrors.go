@@ -0,0 +1,366 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+/* ICMP error translation
+ *
+ * A peer whose endpoint went away is noticed only when handshakes time out,
+ * and until then applications sending through the tunnel wait for replies
+ * that cannot come. Binds that can report ICMP errors returned for their
+ * datagrams (see conn.BindICMPErrors) let the device learn of it sooner.
+ * Tracking them, a destination unreachable error for the current endpoint
+ * of a peer, such as port unreachable or administratively prohibited,
+ * marks the path to the peer unreachable for ICMPUnreachableTimeout: the
+ * peer switches to its most recent previous endpoint if it keeps any (see
+ * endpoints.go), and observers get a path_unreachable event. An
+ * authenticated packet from the peer shows the path works and clears the
+ * mark. Replying as well, packets routed to a peer whose path is marked are
+ * dropped and answered with an ICMP or ICMPv6 unreachable error written
+ * back to the TUN device, at most one per ICMPReplyInterval, so that
+ * applications fail fast instead of timing out. Errors are ignored by
+ * default.
+ */
+
+// An ICMPErrorPolicy selects what a device does with ICMP errors returned
+// for the datagrams it sends.
+type ICMPErrorPolicy int
+
+const (
+	ICMPErrorsIgnore ICMPErrorPolicy = iota // leave the socket's errors unreported
+	ICMPErrorsTrack                         // mark paths unreachable and switch endpoints
+	ICMPErrorsReply                         // also answer packets to unreachable peers with ICMP errors
+	numICMPErrorPolicies
+)
+
+var icmpErrorPolicyNames = [numICMPErrorPolicies]string{"ignore", "track", "reply"}
+
+func (p ICMPErrorPolicy) String() string {
+	if p >= 0 && p < numICMPErrorPolicies {
+		return icmpErrorPolicyNames[p]
+	}
+	return fmt.Sprintf("ICMPErrorPolicy(%d)", int(p))
+}
+
+// ParseICMPErrorPolicy returns the policy named s, as printed by
+// ICMPErrorPolicy.String.
+func ParseICMPErrorPolicy(s string) (ICMPErrorPolicy, error) {
+	for p, name := range icmpErrorPolicyNames {
+		if s == name {
+			return ICMPErrorPolicy(p), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown ICMP error policy %q", s)
+}
+
+type deviceICMPErrors struct {
+	policy    atomic.Int32        // ICMPErrorPolicy
+	lastReply atomic.Int64        // unix nanoseconds of the last ICMP error written to the TUN device
+	queue     chan conn.ICMPError // errors awaiting routineICMPErrors
+}
+
+type peerICMPErrors struct {
+	received         atomic.Uint64 // destination unreachable errors for the peer's endpoint
+	unreachableUntil atomic.Int64  // unix nanoseconds; zero if the path is not marked unreachable
+	prohibited       atomic.Bool   // whether the last error said administratively prohibited
+}
+
+// SetICMPErrorPolicy sets what the device does with ICMP errors. Policies
+// other than ICMPErrorsIgnore need a bind that reports them.
+func (device *Device) SetICMPErrorPolicy(policy ICMPErrorPolicy) error {
+	if policy < 0 || policy >= numICMPErrorPolicies {
+		return fmt.Errorf("invalid ICMP error policy %v", policy)
+	}
+	device.net.RLock()
+	defer device.net.RUnlock()
+	if policy == ICMPErrorsIgnore {
+		device.icmpErrors.policy.Store(int32(policy))
+		for _, bind := range device.bindsLocked() {
+			if b, ok := bind.(conn.BindICMPErrors); ok {
+				b.SetICMPErrorHandler(nil)
+			}
+		}
+		return nil
+	}
+	for _, bind := range device.bindsLocked() {
+		if err := device.reportICMPErrors(bind); err != nil {
+			return err
+		}
+	}
+	device.icmpErrors.policy.Store(int32(policy))
+	return nil
+}
+
+// ICMPErrorPolicy returns the policy set with SetICMPErrorPolicy.
+func (device *Device) ICMPErrorPolicy() ICMPErrorPolicy {
+	return ICMPErrorPolicy(device.icmpErrors.policy.Load())
+}
+
+// bindsLocked returns the primary bind and those of the additional
+// listeners. It must be called with device.net held.
+func (device *Device) bindsLocked() []conn.Bind {
+	binds := []conn.Bind{device.net.bind}
+	for _, l := range device.net.listeners {
+		binds = append(binds, l.bind)
+	}
+	return binds
+}
+
+// reportICMPErrors has bind report ICMP errors to the device.
+func (device *Device) reportICMPErrors(bind conn.Bind) error {
+	b, ok := bind.(conn.BindICMPErrors)
+	if !ok {
+		return errors.New("bind does not report ICMP errors")
+	}
+	return b.SetICMPErrorHandler(device.handleICMPError)
+}
+
+// handleICMPError is the ICMP error handler of the device's binds. It is
+// called from goroutines sending and receiving, which may hold locks the
+// peer lookup takes, so the error is queued for routineICMPErrors. ICMP
+// errors are unauthenticated and anyone can send a flood of them, so those
+// that find the queue full are dropped.
+func (device *Device) handleICMPError(e conn.ICMPError) {
+	if !e.Unreachable() || device.ICMPErrorPolicy() == ICMPErrorsIgnore {
+		return
+	}
+	select {
+	case device.icmpErrors.queue <- e:
+	default:
+	}
+}
+
+// routineICMPErrors handles the queued ICMP errors, one at a time, until
+// the device is closed.
+func (device *Device) routineICMPErrors() {
+	for {
+		select {
+		case e := <-device.icmpErrors.queue:
+			device.markPathsUnreachable(e)
+		case <-device.closed:
+			return
+		}
+	}
+}
+
+// markPathsUnreachable marks the paths of the peers whose current endpoint
+// the error is for.
+func (device *Device) markPathsUnreachable(e conn.ICMPError) {
+	dst := e.Dst.String()
+	var peers []*Peer
+	device.peers.RLock()
+	for _, peer := range device.peers.keyMap {
+		peer.endpoint.Lock()
+		if ep := peer.endpoint.val; ep != nil && ep.DstToString() == dst {
+			peers = append(peers, peer)
+		}
+		peer.endpoint.Unlock()
+	}
+	device.peers.RUnlock()
+	for _, peer := range peers {
+		peer.pathUnreachable(e)
+	}
+}
+
+// pathUnreachable marks the path to the peer unreachable after an ICMP
+// error for its current endpoint, switching to a previous endpoint if it
+// has one.
+func (peer *Peer) pathUnreachable(e conn.ICMPError) {
+	now := time.Now()
+	q := &peer.icmpErrors
+	q.received.Add(1)
+	q.prohibited.Store(e.Prohibited())
+	wasMarked := q.unreachableUntil.Swap(now.Add(ICMPUnreachableTimeout).UnixNano()) > now.UnixNano()
+	if wasMarked {
+		return
+	}
+	dst := e.Dst.String()
+	peer.device.log.conn.verboseAttrs([]slog.Attr{peerAttr(peer), endpointAttr(dst)}, "%v - Endpoint %v unreachable: %v", peer, dst, e)
+	peer.device.notifyObservers("path_unreachable", peer,
+		"endpoint="+dst,
+		fmt.Sprintf("icmp_type=%d", e.Type),
+		fmt.Sprintf("icmp_code=%d", e.Code))
+
+	if !peer.keepsEndpointHistory() {
+		return
+	}
+	peer.endpoint.Lock()
+	current := peer.endpoint.val
+	var to conn.Endpoint
+	if current != nil && current.DstToString() == dst {
+		for _, r := range peer.endpoint.history {
+			if now.Sub(r.lastUsed) <= EndpointHistoryLifetime && r.val.DstToString() != dst {
+				to = r.val
+				break
+			}
+		}
+	}
+	peer.endpoint.Unlock()
+	if to != nil {
+		peer.switchEndpoint(current, &switchDecision{to: to}, now)
+		q.unreachableUntil.Store(0)
+	}
+}
+
+// pathReachable clears the mark of an unreachable path after an
+// authenticated packet from the peer.
+func (peer *Peer) pathReachable() {
+	if peer.icmpErrors.unreachableUntil.Load() != 0 {
+		peer.icmpErrors.unreachableUntil.Store(0)
+	}
+}
+
+// PathUnreachableUntil returns when the mark of the peer's path as
+// unreachable expires, or the zero time if it is not marked.
+func (peer *Peer) PathUnreachableUntil() time.Time {
+	until := peer.icmpErrors.unreachableUntil.Load()
+	if until == 0 || until <= time.Now().UnixNano() {
+		return time.Time{}
+	}
+	return time.Unix(0, until)
+}
+
+// ICMPErrors returns the number of destination unreachable errors received
+// for the peer's endpoint.
+func (peer *Peer) ICMPErrors() uint64 {
+	return peer.icmpErrors.received.Load()
+}
+
+// replyUnreachable answers a packet read from the TUN device for a peer
+// whose path is marked unreachable with an ICMP error written back to the
+// TUN device, if the policy asks for it. It reports whether the packet is
+// to be dropped.
+func (device *Device) replyUnreachable(peer *Peer, packet []byte) bool {
+	if device.ICMPErrorPolicy() != ICMPErrorsReply || peer.PathUnreachableUntil().IsZero() {
+		return false
+	}
+	now := time.Now().UnixNano()
+	last := device.icmpErrors.lastReply.Load()
+	if now-last < int64(ICMPReplyInterval) || !device.icmpErrors.lastReply.CompareAndSwap(last, now) {
+		return true
+	}
+	reply := unreachableReply(packet, peer.icmpErrors.prohibited.Load())
+	if reply == nil {
+		return true
+	}
+	buf := make([]byte, MessageTransportOffsetContent+len(reply))
+	copy(buf[MessageTransportOffsetContent:], reply)
+	if _, err := device.tun.device.Write([][]byte{buf}, MessageTransportOffsetContent); err != nil && !device.isClosed() {
+		device.log.tun.Errorf("Failed to write ICMP error to TUN device: %v", err)
+	}
+	return true
+}
+
+// unreachableReply returns an ICMP or ICMPv6 destination unreachable error
+// for packet, from its destination to its source, or nil if packet must
+// not be answered with one: if it is an error itself, a fragment other than
+// the first, or from no particular address.
+func unreachableReply(packet []byte, prohibited bool) []byte {
+	switch packet[0] >> 4 {
+	case 4:
+		if len(packet) < ipv4.HeaderLen {
+			return nil
+		}
+		ihl := int(packet[0]&0xf) * 4
+		src := packet[12:16]
+		if binary.BigEndian.Uint16(packet[6:])&0x1fff != 0 || src[0] == 0 || src[0] >= 224 {
+			return nil
+		}
+		if packet[9] == 1 && len(packet) > ihl {
+			switch packet[ihl] {
+			case 3, 4, 5, 11, 12: // errors
+				return nil
+			}
+		}
+		// RFC 1812: quote as much as fits in 576 bytes.
+		quote := packet[:min(len(packet), 576-ipv4.HeaderLen-8)]
+		reply := make([]byte, ipv4.HeaderLen+8+len(quote))
+		reply[0] = 0x45
+		binary.BigEndian.PutUint16(reply[2:], uint16(len(reply)))
+		reply[8] = 64 // TTL
+		reply[9] = 1  // ICMP
+		copy(reply[12:16], packet[16:20])
+		copy(reply[16:20], src)
+		binary.BigEndian.PutUint16(reply[10:], ^internetChecksum(reply[:ipv4.HeaderLen], 0))
+		icmp := reply[ipv4.HeaderLen:]
+		icmp[0] = 3 // destination unreachable
+		icmp[1] = 1 // host unreachable
+		if prohibited {
+			icmp[1] = 13 // communication administratively prohibited
+		}
+		copy(icmp[8:], quote)
+		binary.BigEndian.PutUint16(icmp[2:], ^internetChecksum(icmp, 0))
+		return reply
+
+	case 6:
+		if len(packet) < ipv6.HeaderLen {
+			return nil
+		}
+		src := packet[8:24]
+		if isZero(src) || src[0] == 0xff {
+			return nil
+		}
+		if packet[6] == 58 && len(packet) > ipv6.HeaderLen && packet[ipv6.HeaderLen] < 128 { // errors
+			return nil
+		}
+		// RFC 4443: quote as much as fits in the minimum MTU.
+		quote := packet[:min(len(packet), 1280-ipv6.HeaderLen-8)]
+		reply := make([]byte, ipv6.HeaderLen+8+len(quote))
+		reply[0] = 0x60
+		binary.BigEndian.PutUint16(reply[4:], uint16(8+len(quote)))
+		reply[6] = 58 // ICMPv6
+		reply[7] = 64 // hop limit
+		copy(reply[8:24], packet[24:40])
+		copy(reply[24:40], src)
+		icmp := reply[ipv6.HeaderLen:]
+		icmp[0] = 1 // destination unreachable
+		icmp[1] = 3 // address unreachable
+		if prohibited {
+			icmp[1] = 1 // communication administratively prohibited
+		}
+		copy(icmp[8:], quote)
+		var pseudo [40]byte
+		copy(pseudo[:32], reply[8:40])
+		binary.BigEndian.PutUint32(pseudo[32:], uint32(len(icmp)))
+		pseudo[39] = 58
+		binary.BigEndian.PutUint16(icmp[2:], ^internetChecksum(icmp, internetChecksumNoFold(pseudo[:], 0)))
+		return reply
+	}
+	return nil
+}
+
+func internetChecksumNoFold(b []byte, sum uint32) uint32 {
+	for len(b) >= 2 {
+		sum += uint32(binary.BigEndian.Uint16(b))
+		b = b[2:]
+	}
+	if len(b) == 1 {
+		sum += uint32(b[0]) << 8
+	}
+	return sum
+}
+
+// internetChecksum returns the RFC 1071 sum of b, starting from sum, before
+// it is complemented.
+func internetChecksum(b []byte, sum uint32) uint16 {
+	sum = internetChecksumNoFold(b, sum)
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return uint16(sum)
+}
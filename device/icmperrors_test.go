@@ -0,0 +1,170 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+// icmpBind is a bind that keeps the ICMP error handler set on it, for the
+// test to call.
+type icmpBind struct {
+	conn.Bind
+	mu      sync.Mutex
+	handler func(conn.ICMPError)
+}
+
+func (b *icmpBind) SetICMPErrorHandler(fn func(conn.ICMPError)) error {
+	b.mu.Lock()
+	b.handler = fn
+	b.mu.Unlock()
+	return nil
+}
+
+func (b *icmpBind) report(e conn.ICMPError) {
+	b.mu.Lock()
+	handler := b.handler
+	b.mu.Unlock()
+	if handler != nil {
+		handler(e)
+	}
+}
+
+func TestICMPErrorTranslation(t *testing.T) {
+	goroutineLeakCheck(t)
+	binds := bindtest.NewChannelBinds()
+	bind := &icmpBind{Bind: binds[1]}
+	pair := genTestPairBinds(t, [2]conn.Bind{binds[0], bind})
+	if err := pair[0].dev.IpcSet("icmp_errors=track\n"); err == nil {
+		t.Error("ICMP error policy accepted for a bind not reporting errors")
+	}
+	if err := pair[1].dev.IpcSet("icmp_errors=reply\n"); err != nil {
+		t.Fatal(err)
+	}
+	pair.Send(t, Ping, nil)
+
+	peer := pair[1].dev.LookupPeer(pair[0].dev.staticIdentity.publicKey)
+	peer.endpoint.Lock()
+	dst := netip.MustParseAddrPort(peer.endpoint.val.DstToString())
+	peer.endpoint.Unlock()
+	bind.report(conn.ICMPError{Dst: dst, Type: 3, Code: 13})
+	waitFor(t, func() bool { return !peer.PathUnreachableUntil().IsZero() })
+
+	// Packets to the peer are answered with an ICMP error.
+	pair[1].tun.Outbound <- tuntest.Ping(pair[0].ip, pair[1].ip)
+	select {
+	case reply := <-pair[1].tun.Inbound:
+		if reply[9] != 1 || reply[20] != 3 || reply[21] != 13 ||
+			netip.AddrFrom4([4]byte(reply[12:16])) != pair[0].ip || netip.AddrFrom4([4]byte(reply[16:20])) != pair[1].ip {
+			t.Errorf("reply = %x", reply)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no ICMP error written to the TUN device")
+	}
+	cfg, err := pair[1].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"\nicmp_errors=reply\n", "\nicmp_errors_received=1\n", "\npath_unreachable_until_sec="} {
+		if !strings.Contains(cfg, line) {
+			t.Errorf("get output lacks %q:\n%s", line, cfg)
+		}
+	}
+	if status := pair[1].dev.Status(); status.ICMPErrors != ICMPErrorsReply || status.Peers[0].ICMPErrors != 1 {
+		t.Errorf("status ICMP errors = %v, %d", status.ICMPErrors, status.Peers[0].ICMPErrors)
+	}
+
+	// A packet from the peer clears the mark.
+	pair.Send(t, Pong, nil)
+	if !peer.PathUnreachableUntil().IsZero() {
+		t.Error("path still marked unreachable")
+	}
+	pair.Send(t, Ping, nil)
+
+	if err := pair[1].dev.IpcSet("icmp_errors=ignore\n"); err != nil {
+		t.Fatal(err)
+	}
+	if bind.handler != nil {
+		t.Error("handler left set after ignoring errors")
+	}
+}
+
+func TestICMPErrorFlood(t *testing.T) {
+	goroutineLeakCheck(t)
+	binds := bindtest.NewChannelBinds()
+	bind := &icmpBind{Bind: binds[1]}
+	pair := genTestPairBinds(t, [2]conn.Bind{binds[0], bind})
+	if err := pair[1].dev.IpcSet("icmp_errors=track\n"); err != nil {
+		t.Fatal(err)
+	}
+	pair.Send(t, Ping, nil)
+
+	dev := pair[1].dev
+	peer := dev.LookupPeer(pair[0].dev.staticIdentity.publicKey)
+	peer.endpoint.Lock()
+	dst := netip.MustParseAddrPort(peer.endpoint.val.DstToString())
+	peer.endpoint.Unlock()
+
+	// With the worker held up, errors beyond the queue are dropped rather
+	// than piling up.
+	const flood = 10 * QueueICMPErrorSize
+	dev.peers.Lock()
+	for i := 0; i < flood; i++ {
+		bind.report(conn.ICMPError{Dst: dst, Type: 3, Code: 3})
+	}
+	if n := len(dev.icmpErrors.queue); n != QueueICMPErrorSize {
+		t.Errorf("%d errors queued, want %d", n, QueueICMPErrorSize)
+	}
+	dev.peers.Unlock()
+	waitFor(t, func() bool { return len(dev.icmpErrors.queue) == 0 })
+	if n := peer.ICMPErrors(); n == 0 || n > QueueICMPErrorSize+1 {
+		t.Errorf("%d of %d errors handled", n, flood)
+	}
+}
+
+func TestUnreachableReply(t *testing.T) {
+	src, dst := netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")
+	packet := tuntest.Ping(dst, src)
+	reply := unreachableReply(packet, false)
+	if len(reply) != 28+len(packet) || reply[20] != 3 || reply[21] != 1 {
+		t.Fatalf("reply = %x", reply)
+	}
+	if internetChecksum(reply[:20], 0) != 0xffff || internetChecksum(reply[20:], 0) != 0xffff {
+		t.Error("invalid IPv4 checksums")
+	}
+	if unreachableReply(reply, false) != nil {
+		t.Error("ICMP error answered")
+	}
+
+	// A UDP datagram without payload.
+	packet = make([]byte, 48)
+	packet[0], packet[5], packet[6], packet[7] = 0x60, 8, 17, 64
+	src6, dst6 := netip.MustParseAddr("fd00::1").As16(), netip.MustParseAddr("fd00::2").As16()
+	copy(packet[8:], src6[:])
+	copy(packet[24:], dst6[:])
+	reply = unreachableReply(packet, true)
+	if len(reply) != 48+len(packet) || reply[40] != 1 || reply[41] != 1 || [16]byte(reply[24:40]) != src6 {
+		t.Fatalf("reply = %x", reply)
+	}
+	var pseudo [40]byte
+	copy(pseudo[:32], reply[8:40])
+	pseudo[35] = byte(len(reply) - 40)
+	pseudo[39] = 58
+	if internetChecksum(reply[40:], internetChecksumNoFold(pseudo[:], 0)) != 0xffff {
+		t.Error("invalid ICMPv6 checksum")
+	}
+	if unreachableReply(reply, false) != nil {
+		t.Error("ICMPv6 error answered")
+	}
+}
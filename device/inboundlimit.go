@@ -0,0 +1,160 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/* Per-peer inbound limits
+ *
+ * The handshake ratelimiter does nothing about transport packets, so a peer
+ * whose key is compromised, or anyone who learned one of its receiver
+ * indices, can keep the decryption workers of a shared concentrator busy
+ * with its packets alone. With an inbound limit set, a peer's transport
+ * packets are metered by two token buckets: one for the packets and bytes
+ * that decrypted, and one for the packets that failed decryption. Both
+ * refill at their rate and hold InboundLimitBurst worth of it.
+ *
+ * Whether a packet decrypts is only known after the work the limit is to
+ * save, so the buckets are charged by the sequential receiver as outcomes
+ * become known, and the packets of a peer are dropped before decryption
+ * while either of its buckets is empty. A bucket is never drawn further
+ * below empty than it holds when full, so a peer recovers within at most
+ * twice InboundLimitBurst of staying within its limits. Unlike quarantine,
+ * packets that fail decryption count against the peer, so invalid packets
+ * sent with its receiver index get its valid packets dropped too, which is
+ * the price of not decrypting them.
+ */
+
+// An InboundLimit limits the rate of transport packets received from a
+// peer. Zero rates are unlimited.
+type InboundLimit struct {
+	PacketRate int   // packets per second that decrypt
+	ByteRate   int64 // bytes per second of packets that decrypt
+	FailedRate int   // packets per second that fail decryption
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill adds the tokens accrued at rate per second since the last refill.
+func (b *tokenBucket) refill(rate float64, now time.Time) {
+	capacity := rate * InboundLimitBurst.Seconds()
+	b.tokens = min(b.tokens+rate*now.Sub(b.last).Seconds(), capacity)
+	b.last = now
+}
+
+// charge takes n tokens, going below empty by no more than a full bucket.
+func (b *tokenBucket) charge(rate float64, n float64, now time.Time) {
+	b.refill(rate, now)
+	b.tokens = max(b.tokens-n, -rate*InboundLimitBurst.Seconds())
+}
+
+type peerInboundLimit struct {
+	limit atomic.Pointer[InboundLimit] // nil if unlimited
+
+	sync.Mutex
+	packets, bytes, failed tokenBucket
+
+	rateLimited   atomic.Uint64 // packets dropped for the packet or byte rates
+	failedLimited atomic.Uint64 // packets dropped for the failed rate
+}
+
+// SetInboundLimit sets the limit of transport packets received from the
+// peer, refilling its buckets.
+func (peer *Peer) SetInboundLimit(limit InboundLimit) error {
+	if limit.PacketRate < 0 || limit.ByteRate < 0 || limit.FailedRate < 0 {
+		return fmt.Errorf("negative inbound rate")
+	}
+	l := &peer.inboundLimit
+	l.Lock()
+	defer l.Unlock()
+	if limit == (InboundLimit{}) {
+		l.limit.Store(nil)
+		return nil
+	}
+	now := time.Now()
+	burst := InboundLimitBurst.Seconds()
+	l.packets = tokenBucket{float64(limit.PacketRate) * burst, now}
+	l.bytes = tokenBucket{float64(limit.ByteRate) * burst, now}
+	l.failed = tokenBucket{float64(limit.FailedRate) * burst, now}
+	l.limit.Store(&limit)
+	return nil
+}
+
+// InboundLimit returns the limit set with SetInboundLimit.
+func (peer *Peer) InboundLimit() InboundLimit {
+	if limit := peer.inboundLimit.limit.Load(); limit != nil {
+		return *limit
+	}
+	return InboundLimit{}
+}
+
+// InboundLimited returns the numbers of transport packets received from the
+// peer that were dropped for its packet or byte rates, and for its failed
+// rate.
+func (peer *Peer) InboundLimited() (rate, failed uint64) {
+	return peer.inboundLimit.rateLimited.Load(), peer.inboundLimit.failedLimited.Load()
+}
+
+// admitInbound reports whether a transport packet received from the peer is
+// to be decrypted.
+func (peer *Peer) admitInbound() bool {
+	l := &peer.inboundLimit
+	limit := l.limit.Load()
+	if limit == nil {
+		return true
+	}
+	now := time.Now()
+	l.Lock()
+	defer l.Unlock()
+	if limit.FailedRate != 0 {
+		if l.failed.refill(float64(limit.FailedRate), now); l.failed.tokens <= 0 {
+			l.failedLimited.Add(1)
+			return false
+		}
+	}
+	if limit.PacketRate != 0 {
+		l.packets.refill(float64(limit.PacketRate), now)
+	}
+	if limit.ByteRate != 0 {
+		l.bytes.refill(float64(limit.ByteRate), now)
+	}
+	if (limit.PacketRate != 0 && l.packets.tokens <= 0) || (limit.ByteRate != 0 && l.bytes.tokens <= 0) {
+		l.rateLimited.Add(1)
+		return false
+	}
+	return true
+}
+
+// chargeInbound charges the buckets of the peer's inbound limit for received
+// transport packets, of which packets totaling bytes decrypted and failed
+// did not.
+func (peer *Peer) chargeInbound(packets int, bytes uint64, failed int) {
+	l := &peer.inboundLimit
+	limit := l.limit.Load()
+	if limit == nil {
+		return
+	}
+	now := time.Now()
+	l.Lock()
+	defer l.Unlock()
+	if limit.PacketRate != 0 && packets != 0 {
+		l.packets.charge(float64(limit.PacketRate), float64(packets), now)
+	}
+	if limit.ByteRate != 0 && bytes != 0 {
+		l.bytes.charge(float64(limit.ByteRate), float64(bytes), now)
+	}
+	if limit.FailedRate != 0 && failed != 0 {
+		l.failed.charge(float64(limit.FailedRate), float64(failed), now)
+	}
+}
@@ -0,0 +1,101 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func TestInboundLimitBuckets(t *testing.T) {
+	var peer Peer
+	if !peer.admitInbound() {
+		t.Fatal("unlimited peer limited")
+	}
+	if err := peer.SetInboundLimit(InboundLimit{PacketRate: -1}); err == nil {
+		t.Error("negative rate accepted")
+	}
+
+	if err := peer.SetInboundLimit(InboundLimit{PacketRate: 100, FailedRate: 10}); err != nil {
+		t.Fatal(err)
+	}
+	peer.chargeInbound(101, 1<<20, 0)
+	if peer.admitInbound() {
+		t.Error("admitted beyond packet rate")
+	}
+	if rate, failed := peer.InboundLimited(); rate != 1 || failed != 0 {
+		t.Errorf("limited = %d, %d", rate, failed)
+	}
+
+	// Failures drain their own bucket, by no more than a full one.
+	peer.SetInboundLimit(InboundLimit{PacketRate: 100, FailedRate: 10})
+	peer.chargeInbound(0, 0, 1000)
+	if peer.inboundLimit.failed.tokens < -10 {
+		t.Errorf("failed bucket drawn to %f", peer.inboundLimit.failed.tokens)
+	}
+	if peer.admitInbound() {
+		t.Error("admitted beyond failed rate")
+	}
+	if _, failed := peer.InboundLimited(); failed != 1 {
+		t.Errorf("failed limited = %d", failed)
+	}
+	peer.inboundLimit.failed.last = time.Now().Add(-2 * InboundLimitBurst)
+	if !peer.admitInbound() {
+		t.Error("not admitted after failed bucket refilled")
+	}
+
+	peer.SetInboundLimit(InboundLimit{})
+	if peer.InboundLimit() != (InboundLimit{}) || !peer.admitInbound() {
+		t.Error("limit not cleared")
+	}
+}
+
+func TestInboundLimit(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pk := pair[1].dev.staticIdentity.publicKey
+	if err := pair[0].dev.IpcSet(uapiCfg(
+		"public_key", hex.EncodeToString(pk[:]),
+		"inbound_packet_rate", "1",
+	)); err != nil {
+		t.Fatal(err)
+	}
+	peer := pair[0].dev.LookupPeer(pk)
+	if limit := peer.InboundLimit(); limit != (InboundLimit{PacketRate: 1}) {
+		t.Fatalf("limit = %+v", limit)
+	}
+
+	// A bucket of one packet admits one more while it is not below empty.
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Ping, nil)
+	pair[1].tun.Outbound <- tuntest.Ping(pair[0].ip, pair[1].ip)
+	waitFor(t, func() bool {
+		rate, _ := peer.InboundLimited()
+		return rate != 0
+	})
+	select {
+	case <-pair[0].tun.Inbound:
+		t.Error("packet beyond inbound limit delivered")
+	default:
+	}
+
+	cfg, err := pair[0].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"inbound_packet_rate=1", "inbound_byte_rate=0", "inbound_rate_limited=1", "inbound_failed_limited=0"} {
+		if !strings.Contains(cfg, "\n"+line+"\n") {
+			t.Errorf("get output lacks %s:\n%s", line, cfg)
+		}
+	}
+	if status := pair[0].dev.Status(); status.Peers[0].InboundRateLimited != 1 {
+		t.Errorf("status inbound rate limited = %d", status.Peers[0].InboundRateLimited)
+	}
+}
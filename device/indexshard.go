@@ -0,0 +1,97 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import "fmt"
+
+/* Receiver index sharding
+ *
+ * Every message after the handshake initiation carries the index its
+ * receiver chose for the session, so a stateless UDP load balancer in front
+ * of several devices can route those messages to the backend that chose the
+ * index, provided it can tell from the index alone. An index shard makes the
+ * top bits of every index the device chooses hold its shard identifier; the
+ * remaining bits stay random. Indices are little endian on the wire, so the
+ * identifier is found in the top bits of the last byte of the receiver index
+ * field, at offset 7 of cookie replies and transport messages, and offset 11
+ * of handshake responses.
+ *
+ * Initiations carry no receiver index and must be routed by other means,
+ * such as by source address. Changing the shard only affects indices chosen
+ * afterwards.
+ */
+
+// MaxIndexShardBits bounds the bits of receiver indices given to a shard
+// identifier, keeping enough random bits for the device's sessions.
+const MaxIndexShardBits = 16
+
+// An IndexShard puts an identifier in the top Bits bits of each receiver
+// index chosen by the device. The zero shard leaves indices fully random.
+type IndexShard struct {
+	Bits int
+	ID   uint32
+}
+
+func (shard IndexShard) String() string {
+	return fmt.Sprintf("%d/%d", shard.ID, shard.Bits)
+}
+
+// ParseIndexShard parses a shard in the "id/bits" form printed by String.
+func ParseIndexShard(s string) (shard IndexShard, err error) {
+	var rest string
+	if n, _ := fmt.Sscanf(s, "%d/%d%s", &shard.ID, &shard.Bits, &rest); n != 2 {
+		return IndexShard{}, fmt.Errorf("invalid index shard %q", s)
+	}
+	if err := shard.validate(); err != nil {
+		return IndexShard{}, err
+	}
+	return shard, nil
+}
+
+func (shard IndexShard) validate() error {
+	if shard.Bits < 0 || shard.Bits > MaxIndexShardBits {
+		return fmt.Errorf("index shard of %d bits out of range", shard.Bits)
+	}
+	if uint64(shard.ID) >= 1<<shard.Bits {
+		return fmt.Errorf("index shard identifier %d does not fit in %d bits", shard.ID, shard.Bits)
+	}
+	return nil
+}
+
+// Contains reports whether index is one a device with the shard chooses.
+func (shard IndexShard) Contains(index uint32) bool {
+	return shard.Bits == 0 || index>>(32-shard.Bits) == shard.ID
+}
+
+// apply replaces the top bits of a random index with the shard identifier.
+func (shard IndexShard) apply(index uint32) uint32 {
+	if shard.Bits == 0 {
+		return index
+	}
+	shift := 32 - shard.Bits
+	return index&(1<<shift-1) | shard.ID<<shift
+}
+
+// SetIndexShard sets the shard of the receiver indices the device chooses.
+func (device *Device) SetIndexShard(shard IndexShard) error {
+	if err := shard.validate(); err != nil {
+		return err
+	}
+	if shard.Bits == 0 {
+		device.indexTable.shard.Store(nil)
+		return nil
+	}
+	device.indexTable.shard.Store(&shard)
+	return nil
+}
+
+// IndexShard returns the shard set with SetIndexShard.
+func (device *Device) IndexShard() IndexShard {
+	if shard := device.indexTable.shard.Load(); shard != nil {
+		return *shard
+	}
+	return IndexShard{}
+}
@@ -0,0 +1,70 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseIndexShard(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want IndexShard
+		ok   bool
+	}{
+		{"0/0", IndexShard{}, true},
+		{"5/4", IndexShard{Bits: 4, ID: 5}, true},
+		{"65535/16", IndexShard{Bits: 16, ID: 65535}, true},
+		{"16/4", IndexShard{}, false},
+		{"0/17", IndexShard{}, false},
+		{"-1/4", IndexShard{}, false},
+		{"5", IndexShard{}, false},
+		{"5/4x", IndexShard{}, false},
+	} {
+		shard, err := ParseIndexShard(tt.s)
+		if (err == nil) != tt.ok || shard != tt.want {
+			t.Errorf("ParseIndexShard(%q) = %v, %v", tt.s, shard, err)
+		}
+	}
+}
+
+func TestIndexShard(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	shards := [2]IndexShard{{Bits: 4, ID: 0xa}, {Bits: 12, ID: 0x123}}
+	for i := range pair {
+		if err := pair[i].dev.IpcSet(uapiCfg("index_shard", shards[i].String())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg, err := pair[1].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(cfg, "index_shard=291/12\n") {
+		t.Errorf("get output lacks index shard:\n%s", cfg)
+	}
+
+	pair.Send(t, Ping, nil)
+	for i := range pair {
+		peer := pair[i].dev.LookupPeer(pair[1-i].dev.staticIdentity.publicKey)
+		keypair := peer.keypairs.Current()
+		if !shards[i].Contains(keypair.localIndex) {
+			t.Errorf("device %d chose index %08x outside shard %v", i, keypair.localIndex, shards[i])
+		}
+		if !shards[1-i].Contains(keypair.remoteIndex) {
+			t.Errorf("device %d was given index %08x outside shard %v", i, keypair.remoteIndex, shards[1-i])
+		}
+	}
+
+	if err := pair[1].dev.SetIndexShard(IndexShard{}); err != nil {
+		t.Fatal(err)
+	}
+	if pair[1].dev.indexTable.shard.Load() != nil {
+		t.Error("zero shard not cleared")
+	}
+}
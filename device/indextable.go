@@ -9,6 +9,7 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"sync"
+	"sync/atomic"
 )
 
 type IndexTableEntry struct {
@@ -20,6 +21,7 @@ type IndexTableEntry struct {
 type IndexTable struct {
 	sync.RWMutex
 	table map[uint32]IndexTableEntry
+	shard atomic.Pointer[IndexShard] // nil for fully random indices
 }
 
 func randUint32() (uint32, error) {
@@ -63,6 +65,9 @@ func (table *IndexTable) NewIndexForHandshake(peer *Peer, handshake *Handshake)
 		if err != nil {
 			return index, err
 		}
+		if shard := table.shard.Load(); shard != nil {
+			index = shard.apply(index)
+		}
 
 		// check if index used
 
@@ -91,6 +96,20 @@ func (table *IndexTable) NewIndexForHandshake(peer *Peer, handshake *Handshake)
 	}
 }
 
+// insertKeypair maps index to keypair, provided the index is free.
+func (table *IndexTable) insertKeypair(index uint32, peer *Peer, keypair *Keypair) bool {
+	table.Lock()
+	defer table.Unlock()
+	if _, ok := table.table[index]; ok {
+		return false
+	}
+	table.table[index] = IndexTableEntry{
+		peer:    peer,
+		keypair: keypair,
+	}
+	return true
+}
+
 func (table *IndexTable) Lookup(id uint32) IndexTableEntry {
 	table.RLock()
 	defer table.RUnlock()
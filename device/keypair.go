@@ -6,11 +6,14 @@
 package device
 
 import (
-	"crypto/cipher"
+	"errors"
+	"reflect"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unsafe"
 
+	"golang.org/x/crypto/chacha20poly1305"
 	"golang.zx2c4.com/wireguard/replay"
 )
 
@@ -23,13 +26,18 @@ import (
 
 type Keypair struct {
 	sendNonce    atomic.Uint64
-	send         cipher.AEAD
-	receive      cipher.AEAD
+	suite        *CipherSuite
+	hybrid       bool // derived with an ML-KEM shared secret
+	send         AEADSuite
+	receive      AEADSuite
 	replayFilter replay.Filter
 	isInitiator  bool
 	created      time.Time
 	localIndex   uint32
 	remoteIndex  uint32
+	lease        *keypairLease   // nil unless derived while replicating to a standby
+	keystream    *keystreamCache // nil unless precomputing keystream
+	usage        keypairUsage
 }
 
 type Keypairs struct {
@@ -45,8 +53,154 @@ func (kp *Keypairs) Current() *Keypair {
 	return kp.current
 }
 
+/* Eager key erasure
+ *
+ * Peers in eager key erasure mode discard the previous keypair as soon as
+ * a packet confirms its successor, and zero the keys of its AEADs rather
+ * than leave them to the garbage collector. To that end the AEADs of their
+ * sessions are wrapped in an erasableAEAD, which holds the bytes of the
+ * key of the AEAD it wraps. Those can be found for the ChaCha20-Poly1305
+ * of x/crypto, which the standard suite uses, and for the suites of this
+ * package that implement keyedAEAD. Other suites keep their keys in memory
+ * out of reach, as crypto/aes does, so the keys of their sessions, and of
+ * sessions begun before the mode was set, are only discarded, and not
+ * zeroed. Copies of keys sent to a replication standby are not erased
+ * either.
+ */
+
+// A keyedAEAD is an AEAD that hands out the bytes of its key, for eager key
+// erasure to zero.
+type keyedAEAD interface {
+	keyBytes() []byte
+}
+
+var errKeyErased = errors.New("key erased")
+
+// chacha20poly1305Type is the type of the AEADs of chacha20poly1305.New, if
+// it is a pointer to a struct of only the key, as it has been so far, or
+// nil if a new version of x/crypto changes that.
+var chacha20poly1305Type = func() reflect.Type {
+	aead, _ := chacha20poly1305.New(make([]byte, chacha20poly1305.KeySize))
+	t := reflect.TypeOf(aead)
+	if t.Kind() != reflect.Pointer || t.Elem().Kind() != reflect.Struct || t.Elem().NumField() != 1 ||
+		t.Elem().Field(0).Type != reflect.TypeOf([chacha20poly1305.KeySize]byte{}) {
+		return nil
+	}
+	return t
+}()
+
+// aeadKeyBytes returns the bytes of the key that aead uses, or nil if they
+// cannot be found.
+func aeadKeyBytes(aead AEADSuite) []byte {
+	if keyed, ok := aead.(keyedAEAD); ok {
+		return keyed.keyBytes()
+	}
+	if v := reflect.ValueOf(aead); chacha20poly1305Type != nil && v.Type() == chacha20poly1305Type {
+		key := v.Elem().Field(0)
+		return unsafe.Slice((*byte)(unsafe.Pointer(key.UnsafeAddr())), key.Len())
+	}
+	return nil
+}
+
+// An erasableAEAD is an AEAD whose key it can zero. Its lock keeps erase
+// from zeroing the key while a message is sealed or opened with it. Once
+// erased, it opens nothing, and seals messages as zeros, which no peer
+// accepts, rather than under a zeroed key.
+type erasableAEAD struct {
+	AEADSuite
+	key    []byte // of AEADSuite
+	mu     sync.RWMutex
+	erased bool
+}
+
+// newErasableAEAD wraps aead in an erasableAEAD, or returns it as it is if
+// its key cannot be found.
+func newErasableAEAD(aead AEADSuite) AEADSuite {
+	key := aeadKeyBytes(aead)
+	if key == nil {
+		return aead
+	}
+	return &erasableAEAD{AEADSuite: aead, key: key}
+}
+
+func (a *erasableAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.erased {
+		ret, out := sliceForAppend(dst, len(plaintext)+a.Overhead())
+		clear(out)
+		return ret
+	}
+	return a.AEADSuite.Seal(dst, nonce, plaintext, additionalData)
+}
+
+func (a *erasableAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.erased {
+		return nil, errKeyErased
+	}
+	return a.AEADSuite.Open(dst, nonce, ciphertext, additionalData)
+}
+
+func (a *erasableAEAD) erase() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	clear(a.key)
+	a.erased = true
+}
+
+// makeErasable wraps the AEADs of a new keypair of a peer in eager key
+// erasure mode, so that erase can zero their keys.
+func (keypair *Keypair) makeErasable() {
+	keypair.send = newErasableAEAD(keypair.send)
+	keypair.receive = newErasableAEAD(keypair.receive)
+}
+
+// erase zeroes the keys of the keypair's AEADs, and reports whether both
+// could be zeroed.
+func (keypair *Keypair) erase() bool {
+	zeroed := true
+	for _, aead := range []AEADSuite{keypair.send, keypair.receive} {
+		if erasable, ok := aead.(*erasableAEAD); ok {
+			erasable.erase()
+		} else {
+			zeroed = false
+		}
+	}
+	return zeroed
+}
+
+// erasePreviousKeypair discards the previous keypair, provided that received,
+// the keypair of an authenticated incoming packet, is the current one, and
+// zeroes its keys where it can. It is used by peers in eager key erasure
+// mode, which give up tolerance of packets reordered across a rekey in
+// exchange for a shorter forward secrecy window.
+func (peer *Peer) erasePreviousKeypair(received *Keypair) {
+	keypairs := &peer.keypairs
+	keypairs.RLock()
+	erase := keypairs.previous != nil && keypairs.current == received
+	keypairs.RUnlock()
+	if !erase {
+		return
+	}
+	keypairs.Lock()
+	defer keypairs.Unlock()
+	if keypairs.previous != nil && keypairs.current == received {
+		previous := keypairs.previous
+		peer.device.DeleteKeypair(previous)
+		keypairs.previous = nil
+		if previous.erase() {
+			peer.device.log.handshake.Verbosef("%v - Erased the keys of the previous keypair", peer)
+		} else {
+			peer.device.log.handshake.Verbosef("%v - Discarded the previous keypair, whose keys cannot be zeroed", peer)
+		}
+	}
+}
+
 func (device *Device) DeleteKeypair(key *Keypair) {
 	if key != nil {
 		device.indexTable.Delete(key.localIndex)
+		key.stopKeystream()
 	}
 }
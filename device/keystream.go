@@ -0,0 +1,251 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/poly1305"
+)
+
+/* Keystream precomputation
+ *
+ * The counters of the transport messages a keypair sends are known ahead
+ * of time, and so is the ChaCha20 keystream they are encrypted with. With
+ * precomputation on, each keypair of the standard suite has a worker that
+ * fills a ring of slots with the keystream of the next counters: keystream
+ * block 0, whose first 32 bytes are the one-time Poly1305 key, and enough
+ * blocks after it for KeystreamPrecomputeSize bytes of content. Sealing a
+ * packet whose slot is ready only takes an XOR and the MAC, which cuts the
+ * latency of small packets, such as those of voice calls, that arrive one
+ * at a time. Larger packets, and those whose slot is not ready, are sealed
+ * as usual; the result is the same either way.
+ *
+ * The worker fills slots only while the encryption queue is empty, so that
+ * it spends otherwise idle cycles and does not compete with encryption
+ * workers under load, and sleeps until the next packet is sealed. Slots
+ * hold keystream for at most the number of counters the ring has past the
+ * current one and are zeroed as they are used. When a keypair stops being
+ * the one packets are sent with, on rekeying or expiry, its worker stops
+ * and zeroes the slots left.
+ */
+
+// KeystreamStats counts packets of keypairs with precomputed keystream.
+type KeystreamStats struct {
+	Hits   uint64 // packets sealed with precomputed keystream
+	Misses uint64 // packets sealed without, for their size or their slot not being ready
+}
+
+func (stats KeystreamStats) isZero() bool {
+	return stats == KeystreamStats{}
+}
+
+type deviceKeystream struct {
+	slots  atomic.Int32 // per keypair, or zero for no precomputation
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// SetKeystreamPrecompute sets the number of counters whose keystream is
+// precomputed for each keypair, up to MaxKeystreamPrecompute, or zero to
+// precompute none. It takes effect with the next sessions.
+func (device *Device) SetKeystreamPrecompute(slots int) error {
+	if slots < 0 || slots > MaxKeystreamPrecompute {
+		return fmt.Errorf("keystream precomputation of %d slots out of range", slots)
+	}
+	device.keystream.slots.Store(int32(slots))
+	return nil
+}
+
+// KeystreamPrecompute returns the number of slots set with
+// SetKeystreamPrecompute.
+func (device *Device) KeystreamPrecompute() int {
+	return int(device.keystream.slots.Load())
+}
+
+// KeystreamStats returns the counts of packets sealed with and without
+// precomputed keystream.
+func (device *Device) KeystreamStats() KeystreamStats {
+	return KeystreamStats{
+		Hits:   device.keystream.hits.Load(),
+		Misses: device.keystream.misses.Load(),
+	}
+}
+
+const (
+	keystreamSlotEmpty uint32 = iota
+	keystreamSlotFilling
+	keystreamSlotReady
+	keystreamSlotTaken
+)
+
+const (
+	keystreamBlockSize = 64                                           // bytes of a ChaCha20 block
+	keystreamSlotSize  = keystreamBlockSize + KeystreamPrecomputeSize // block 0 and the blocks of KeystreamPrecomputeSize bytes of content
+)
+
+type keystreamSlot struct {
+	state   atomic.Uint32
+	counter uint64
+	stream  [keystreamSlotSize]byte
+}
+
+// A keystreamCache is the ring of precomputed keystream of a keypair.
+type keystreamCache struct {
+	key    [chacha20poly1305.KeySize]byte
+	slots  []keystreamSlot
+	wake   chan struct{}
+	done   chan struct{} // closed to stop the worker
+	exited chan struct{} // closed once the worker has zeroed the slots
+	stop   sync.Once
+}
+
+// startKeystream starts precomputing the keystream of the keypair, whose
+// send key is key, if the device is set to and the keypair is of the
+// standard suite.
+func (device *Device) startKeystream(keypair *Keypair, key *[chacha20poly1305.KeySize]byte) {
+	slots := device.KeystreamPrecompute()
+	if slots == 0 || keypair.suite.Name != StandardCipherSuite {
+		return
+	}
+	c := &keystreamCache{
+		key:    *key,
+		slots:  make([]keystreamSlot, slots),
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	keypair.keystream = c
+	c.wake <- struct{}{}
+	go c.run(device, keypair)
+}
+
+// stopKeystream stops precomputing the keystream of the keypair, if it is,
+// and has its slots zeroed.
+func (keypair *Keypair) stopKeystream() {
+	if keypair != nil && keypair.keystream != nil {
+		keypair.keystream.stop.Do(func() { close(keypair.keystream.done) })
+	}
+}
+
+func (c *keystreamCache) run(device *Device, keypair *Keypair) {
+	defer close(c.exited)
+	defer c.zero()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-device.closed:
+			return
+		case <-c.wake:
+		}
+		c.fill(device, keypair)
+	}
+}
+
+// fill precomputes the keystream of the counters of the ring from the
+// keypair's next one, until the ring is full or encryption is busy.
+func (c *keystreamCache) fill(device *Device, keypair *Keypair) {
+	var nonce [chacha20poly1305.NonceSize]byte
+	n := uint64(len(c.slots))
+	base := keypair.sendNonce.Load()
+	for i := range n {
+		counter := base + i
+		if counter >= RejectAfterMessages || len(device.queue.encryption.c) != 0 {
+			return
+		}
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+		slot := &c.slots[counter%n]
+		state := slot.state.Load()
+		// Keystream of a counter taken but not yet sealed is left be.
+		if state == keystreamSlotReady && slot.counter+n > base {
+			continue
+		}
+		if (state != keystreamSlotEmpty && state != keystreamSlotReady) || !slot.state.CompareAndSwap(state, keystreamSlotFilling) {
+			continue
+		}
+		binary.LittleEndian.PutUint64(nonce[4:], counter)
+		stream, _ := chacha20.NewUnauthenticatedCipher(c.key[:], nonce[:])
+		clear(slot.stream[:])
+		stream.XORKeyStream(slot.stream[:], slot.stream[:])
+		slot.counter = counter
+		slot.state.Store(keystreamSlotReady)
+	}
+}
+
+// zero zeroes the key and the slots not being used to seal a packet, which
+// the sealer zeroes.
+func (c *keystreamCache) zero() {
+	setZero(c.key[:])
+	for i := range c.slots {
+		slot := &c.slots[i]
+		if slot.state.CompareAndSwap(keystreamSlotReady, keystreamSlotFilling) {
+			setZero(slot.stream[:])
+			slot.state.Store(keystreamSlotEmpty)
+		}
+	}
+}
+
+// seal appends to dst the sealed plaintext of the transport message with
+// the counter, as the standard suite would seal it, if its keystream is
+// ready, and wakes the worker. The keystream of the counter is zeroed
+// whether it is used or not.
+func (c *keystreamCache) seal(dst []byte, counter uint64, plaintext []byte) ([]byte, bool) {
+	defer func() {
+		select {
+		case c.wake <- struct{}{}:
+		default:
+		}
+	}()
+	slot := &c.slots[counter%uint64(len(c.slots))]
+	if !slot.state.CompareAndSwap(keystreamSlotReady, keystreamSlotTaken) {
+		return nil, false
+	}
+	defer slot.state.Store(keystreamSlotEmpty)
+	defer setZero(slot.stream[:])
+	if slot.counter != counter || len(plaintext) > KeystreamPrecomputeSize {
+		return nil, false
+	}
+
+	ret, out := sliceForAppend(dst, len(plaintext)+poly1305.TagSize)
+	ciphertext := out[:len(plaintext)]
+	subtle.XORBytes(ciphertext, plaintext, slot.stream[keystreamBlockSize:])
+	mac := poly1305.New((*[32]byte)(slot.stream[:32]))
+	var pad [16]byte
+	mac.Write(ciphertext)
+	mac.Write(pad[:(16-len(ciphertext)%16)%16])
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(ciphertext)))
+	mac.Write(lengths[:])
+	mac.Sum(ciphertext[len(ciphertext):len(ciphertext)])
+	return ret, true
+}
+
+// sealPrecomputed seals the plaintext of the transport message of the
+// keypair with the counter into dst with precomputed keystream, if the
+// keypair has its keystream ready.
+func (device *Device) sealPrecomputed(keypair *Keypair, dst []byte, counter uint64, plaintext []byte) ([]byte, bool) {
+	if keypair.keystream == nil {
+		return nil, false
+	}
+	sealed, ok := keypair.keystream.seal(dst, counter, plaintext)
+	if ok {
+		device.keystream.hits.Add(1)
+	} else {
+		device.keystream.misses.Add(1)
+	}
+	return sealed, ok
+}
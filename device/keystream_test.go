@@ -0,0 +1,129 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func TestKeystreamSeal(t *testing.T) {
+	dev := randDevice(t)
+	defer dev.Close()
+	assertNil(t, dev.SetKeystreamPrecompute(8))
+	var key [chacha20poly1305.KeySize]byte
+	for i := range key {
+		key[i] = byte(i)
+	}
+	aead, _ := chacha20poly1305.New(key[:])
+	keypair := &Keypair{suite: LookupCipherSuite(StandardCipherSuite)}
+	keypair.sendNonce.Store(100)
+	dev.startKeystream(keypair, &key)
+	defer keypair.stopKeystream()
+	c := keypair.keystream
+	ready := func(counter uint64) bool {
+		slot := &c.slots[counter%uint64(len(c.slots))]
+		return slot.state.Load() == keystreamSlotReady && slot.counter == counter
+	}
+	deadline := time.Now().Add(time.Second)
+	for !ready(107) && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	var nonce [maxTransportNonceSize]byte
+	for i, size := range []int{0, 1, 16, 100, KeystreamPrecomputeSize} {
+		counter := keypair.sendNonce.Add(1) - 1
+		if !ready(counter) {
+			t.Fatalf("keystream of counter %d not ready", counter)
+		}
+		plaintext := bytes.Repeat([]byte{byte(i)}, size)
+		header := []byte("transport header")
+		want := aead.Seal(append([]byte(nil), header...), transportNonce(&nonce, chacha20poly1305.NonceSize, counter, 0, true), plaintext, nil)
+		buf := append(header, plaintext...)
+		sealed, ok := dev.sealPrecomputed(keypair, buf[:len(header)], counter, buf[len(header):])
+		if !ok || !bytes.Equal(sealed, want) {
+			t.Fatalf("sealed %d bytes with counter %d unlike the standard suite", size, counter)
+		}
+		if ready(counter) {
+			t.Fatalf("slot of counter %d not emptied once used", counter)
+		}
+	}
+	if _, ok := dev.sealPrecomputed(keypair, nil, 106, make([]byte, KeystreamPrecomputeSize+1)); ok {
+		t.Error("sealed a packet larger than the keystream precomputed")
+	}
+	if _, ok := dev.sealPrecomputed(keypair, nil, 99, nil); ok {
+		t.Error("sealed with the keystream of another counter")
+	}
+	if stats := dev.KeystreamStats(); stats != (KeystreamStats{Hits: 5, Misses: 2}) {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	// Stopping zeroes the keystream left.
+	keypair.stopKeystream()
+	<-c.exited
+	for i := range c.slots {
+		if c.slots[i].state.Load() != keystreamSlotEmpty || !isZero(c.slots[i].stream[:]) {
+			t.Fatal("keystream kept after stopping")
+		}
+	}
+	if !isZero(c.key[:]) {
+		t.Error("key kept after stopping")
+	}
+}
+
+func TestDeviceKeystreamPrecompute(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	for i := range pair {
+		if err := pair[i].dev.IpcSet("keystream_precompute=32\n"); err != nil {
+			t.Fatal(err)
+		}
+		pair[i].dev.LookupPeer(pair[i^1].dev.staticIdentity.publicKey).ExpireCurrentKeypairs()
+	}
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+	peer := pair[0].dev.LookupPeer(pair[1].dev.staticIdentity.publicKey)
+	keypair := peer.keypairs.Current()
+	if keypair.keystream == nil {
+		t.Fatal("session without precomputed keystream")
+	}
+	for i := 0; i < 20 && pair[0].dev.KeystreamStats().Hits == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		pair.Send(t, Pong, nil)
+	}
+	if pair[0].dev.KeystreamStats().Hits == 0 {
+		t.Error("no packets sealed with precomputed keystream")
+	}
+
+	status := pair[0].dev.Status()
+	if status.KeystreamPrecompute != 32 || status.Keystream.isZero() {
+		t.Errorf("unexpected status %d, %+v", status.KeystreamPrecompute, status.Keystream)
+	}
+	cfg, err := pair[0].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(cfg, "\nkeystream_precompute=32\n") || !strings.Contains(cfg, "\nkeystream_hits=") {
+		t.Errorf("get output lacks keystream precomputation:\n%s", cfg)
+	}
+
+	// Rekeying stops precomputation for the old keypair.
+	peer.ExpireCurrentKeypairs()
+	time.Sleep(50 * time.Millisecond)
+	pair.Send(t, Pong, nil)
+	select {
+	case <-keypair.keystream.done:
+	default:
+		t.Error("precomputation of the old keypair not stopped")
+	}
+	if err := pair[0].dev.IpcSet("keystream_precompute=2000\n"); err == nil {
+		t.Error("set keystream precomputation beyond the maximum")
+	}
+}
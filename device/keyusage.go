@@ -0,0 +1,90 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+/* Keypair usage forecasting
+ *
+ * Besides rekeying after RekeyAfterMessages, a peer forecasts when its
+ * current keypair will run out of messages at the rate it is sending with
+ * it, and initiates a handshake once that is no more than RekeyForecastLead
+ * away, so that the next keypair is live before RejectAfterMessages is hit
+ * rather than being negotiated while sending stalls at the limit. The rate
+ * is measured by the peer's sequential sender at most every
+ * KeyUsageSampleInterval, and smoothed across samples.
+ */
+
+type keypairUsage struct {
+	// Owned by the peer's sequential sender.
+	sampleNonce   uint64
+	sampleTime    time.Time
+	forecastRekey bool // a rekey was due by the forecast
+
+	rate atomic.Uint64 // float64 bits of the messages sent per second
+}
+
+// A KeyUsageForecast describes how fast a peer is using up its current
+// keypair.
+type KeyUsageForecast struct {
+	Rate        float64       // messages sent per second with the keypair
+	Remaining   uint64        // messages the keypair may still send
+	TimeToLimit time.Duration // time until the keypair may send no more, at Rate
+	Margin      time.Duration // TimeToLimit beyond RekeyForecastLead; a rekey is due once it is not positive
+}
+
+// sample records that the keypair had sent nonce messages by now, and
+// reports whether a rekey is due by the forecast.
+func (usage *keypairUsage) sample(nonce uint64, now time.Time) bool {
+	if nonce >= RejectAfterMessages {
+		return false // expired
+	}
+	if usage.sampleTime.IsZero() {
+		usage.sampleNonce, usage.sampleTime = nonce, now
+		return false
+	}
+	if elapsed := now.Sub(usage.sampleTime); elapsed >= KeyUsageSampleInterval {
+		rate := float64(nonce-usage.sampleNonce) / elapsed.Seconds()
+		if old := math.Float64frombits(usage.rate.Load()); old != 0 {
+			rate = (old + rate) / 2
+		}
+		usage.rate.Store(math.Float64bits(rate))
+		usage.sampleNonce, usage.sampleTime = nonce, now
+	}
+	return usage.forecast(nonce).Margin <= 0
+}
+
+// forecast returns the forecast of the keypair having sent nonce messages.
+func (usage *keypairUsage) forecast(nonce uint64) KeyUsageForecast {
+	f := KeyUsageForecast{
+		Rate:        math.Float64frombits(usage.rate.Load()),
+		TimeToLimit: math.MaxInt64,
+	}
+	if nonce < RejectAfterMessages {
+		f.Remaining = RejectAfterMessages - nonce
+	}
+	if f.Rate > 0 {
+		if seconds := float64(f.Remaining) / f.Rate; seconds < float64(math.MaxInt64)/float64(time.Second) {
+			f.TimeToLimit = time.Duration(seconds * float64(time.Second))
+		}
+	}
+	f.Margin = f.TimeToLimit - RekeyForecastLead
+	return f
+}
+
+// KeyUsageForecast returns the forecast of the peer's current keypair, or
+// the zero forecast if it has none.
+func (peer *Peer) KeyUsageForecast() KeyUsageForecast {
+	keypair := peer.keypairs.Current()
+	if keypair == nil {
+		return KeyUsageForecast{}
+	}
+	return keypair.usage.forecast(keypair.sendNonce.Load())
+}
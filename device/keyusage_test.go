@@ -0,0 +1,77 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyUsageForecast(t *testing.T) {
+	var usage keypairUsage
+	start := time.Now()
+	if usage.sample(0, start) {
+		t.Fatal("rekey due without a rate")
+	}
+	if f := usage.forecast(0); f.Rate != 0 || f.TimeToLimit != math.MaxInt64 || f.Remaining != RejectAfterMessages {
+		t.Errorf("forecast without a rate = %+v", f)
+	}
+
+	// A rate at which the keypair lasts well beyond the lead.
+	const slow = 1 << 40
+	if usage.sample(slow, start.Add(time.Second)) {
+		t.Error("rekey due at a slow rate")
+	}
+	f := usage.forecast(slow)
+	if f.Rate != slow || f.Remaining != RejectAfterMessages-slow || f.Margin != f.TimeToLimit-RekeyForecastLead || f.Margin <= 0 {
+		t.Errorf("forecast at a slow rate = %+v", f)
+	}
+
+	// Samples closer together than the interval keep the rate.
+	if usage.sample(2*slow, start.Add(time.Second+KeyUsageSampleInterval/2)); usage.forecast(0).Rate != slow {
+		t.Error("rate measured within a sample interval")
+	}
+
+	// The rate is smoothed, and a rekey is due once the keypair is forecast
+	// to run out within the lead.
+	const fast = 1 << 62
+	if !usage.sample(slow+fast, start.Add(2*time.Second)) {
+		t.Errorf("rekey not due by forecast %+v", usage.forecast(slow+fast))
+	}
+	if rate := usage.forecast(0).Rate; rate != (slow+fast)/2 {
+		t.Errorf("smoothed rate = %f", rate)
+	}
+	if usage.sample(RejectAfterMessages, start.Add(3*time.Second)) {
+		t.Error("rekey due for expired keypair")
+	}
+}
+
+func TestKeyUsageStatus(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+	peer := pair[0].dev.LookupPeer(pair[1].dev.staticIdentity.publicKey)
+	if f := peer.KeyUsageForecast(); f.Rate != 0 || f.Remaining == 0 {
+		t.Errorf("forecast before any rate measurement = %+v", f)
+	}
+
+	peer.keypairs.Current().usage.rate.Store(math.Float64bits(1e6))
+	status := pair[0].dev.Status()
+	if f := status.Peers[0].KeyUsage; f.Rate != 1e6 || f.Margin != f.TimeToLimit-RekeyForecastLead {
+		t.Errorf("status forecast = %+v", f)
+	}
+	cfg, err := pair[0].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"key_send_rate=1000000", "key_exhaustion_forecast=", "key_rekey_margin="} {
+		if !strings.Contains(cfg, "\n"+key) {
+			t.Errorf("get output lacks %s:\n%s", key, cfg)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/blake2s"
+)
+
+/* Single-packet authorization
+ *
+ * When a knock secret is configured, handshake initiations are dropped
+ * silently unless their source address has recently sent a valid knock:
+ *
+ *   type (5, little endian uint32) | unix nanoseconds (big endian uint64) |
+ *   random nonce (16 bytes) | HMAC-BLAKE2s(secret, preceding 28 bytes)
+ *
+ * Knocks must be timestamped within KnockWindow of the local clock and are
+ * accepted only once. An address stays authorized for KnockLifetime after
+ * its last knock or accepted initiation, so established peers can rekey
+ * without knocking again.
+ */
+
+const (
+	MessageKnockType = 5
+	MessageKnockSize = 4 + 8 + 16 + blake2s.Size
+)
+
+type knockGate struct {
+	sync.Mutex
+	enabled atomic.Bool // secret is nonzero, readable without the mutex
+	secret  [32]byte
+	allowed map[netip.Addr]time.Time
+	seen    map[[16]byte]time.Time // nonces of knocks within KnockWindow
+}
+
+// NewKnock returns a knock packet for a responder configured with secret.
+// Clients send it from the address they will initiate handshakes from.
+func NewKnock(secret [32]byte) []byte {
+	packet := make([]byte, MessageKnockSize)
+	binary.LittleEndian.PutUint32(packet[0:4], MessageKnockType)
+	binary.BigEndian.PutUint64(packet[4:12], uint64(time.Now().UnixNano()))
+	rand.Read(packet[12:28])
+	var mac [blake2s.Size]byte
+	HMAC1(&mac, secret[:], packet[:28])
+	copy(packet[28:], mac[:])
+	return packet
+}
+
+// SendKnock sends a knock packet for secret to the peer's current endpoint.
+func (peer *Peer) SendKnock(secret [32]byte) error {
+	if isZero(secret[:]) {
+		return errors.New("knock secret is zero")
+	}
+	return peer.SendBuffers([][]byte{NewKnock(secret)})
+}
+
+// SetKnockSecret enables the knock gate for incoming handshake initiations,
+// or disables it if secret is zero.
+func (device *Device) SetKnockSecret(secret [32]byte) {
+	gate := &device.knock
+	gate.Lock()
+	defer gate.Unlock()
+	gate.secret = secret
+	gate.allowed = nil
+	gate.seen = nil
+	if !isZero(secret[:]) {
+		gate.allowed = make(map[netip.Addr]time.Time)
+		gate.seen = make(map[[16]byte]time.Time)
+	}
+	gate.enabled.Store(gate.allowed != nil)
+}
+
+// KnockSecret returns the secret set with SetKnockSecret.
+func (device *Device) KnockSecret() [32]byte {
+	device.knock.Lock()
+	defer device.knock.Unlock()
+	return device.knock.secret
+}
+
+// consumeKnock validates a knock packet and authorizes its source address.
+func (gate *knockGate) consumeKnock(packet []byte, src netip.Addr) bool {
+	if !gate.enabled.Load() || len(packet) != MessageKnockSize {
+		return false
+	}
+	gate.Lock()
+	defer gate.Unlock()
+	if gate.allowed == nil {
+		return false
+	}
+
+	var mac [blake2s.Size]byte
+	HMAC1(&mac, gate.secret[:], packet[:28])
+	if !hmac.Equal(mac[:], packet[28:]) {
+		return false
+	}
+
+	now := time.Now()
+	sent := time.Unix(0, int64(binary.BigEndian.Uint64(packet[4:12])))
+	if sent.Before(now.Add(-KnockWindow)) || sent.After(now.Add(KnockWindow)) {
+		return false
+	}
+	var nonce [16]byte
+	copy(nonce[:], packet[12:28])
+	if _, replayed := gate.seen[nonce]; replayed {
+		return false
+	}
+
+	if len(gate.seen) >= MaxKnockSources || len(gate.allowed) >= MaxKnockSources {
+		gate.pruneLocked(now)
+		if len(gate.seen) >= MaxKnockSources || len(gate.allowed) >= MaxKnockSources {
+			return false
+		}
+	}
+	gate.seen[nonce] = now
+	gate.allowed[src.Unmap()] = now
+	return true
+}
+
+// pruneLocked forgets expired nonces and authorizations.
+func (gate *knockGate) pruneLocked(now time.Time) {
+	for nonce, t := range gate.seen {
+		if now.Sub(t) > 2*KnockWindow {
+			delete(gate.seen, nonce)
+		}
+	}
+	for addr, t := range gate.allowed {
+		if now.Sub(t) > KnockLifetime {
+			delete(gate.allowed, addr)
+		}
+	}
+}
+
+// admit reports whether a handshake initiation from src may be processed.
+func (gate *knockGate) admit(src netip.Addr) bool {
+	if !gate.enabled.Load() {
+		return true
+	}
+	gate.Lock()
+	defer gate.Unlock()
+	if gate.allowed == nil {
+		return true
+	}
+	t, ok := gate.allowed[src.Unmap()]
+	return ok && time.Since(t) <= KnockLifetime
+}
+
+// refresh extends the authorization of src after an accepted initiation.
+func (gate *knockGate) refresh(src netip.Addr) {
+	if !gate.enabled.Load() {
+		return
+	}
+	gate.Lock()
+	defer gate.Unlock()
+	if gate.allowed == nil {
+		return
+	}
+	if _, ok := gate.allowed[src.Unmap()]; ok {
+		gate.allowed[src.Unmap()] = time.Now()
+	}
+}
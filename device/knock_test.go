@@ -0,0 +1,88 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestKnockGate(t *testing.T) {
+	var secret [32]byte
+	secret[0] = 1
+	src := netip.MustParseAddr("192.0.2.1")
+
+	var dev Device
+	dev.SetKnockSecret(secret)
+	gate := &dev.knock
+	if gate.admit(src) {
+		t.Fatal("initiation admitted without a knock")
+	}
+
+	var wrong [32]byte
+	wrong[0] = 2
+	if gate.consumeKnock(NewKnock(wrong), src) {
+		t.Fatal("knock with the wrong secret accepted")
+	}
+
+	stale := NewKnock(secret)
+	binary.BigEndian.PutUint64(stale[4:12], uint64(time.Now().Add(-2*KnockWindow).UnixNano()))
+	HMAC1((*[32]byte)(stale[28:]), secret[:], stale[:28])
+	if gate.consumeKnock(stale, src) {
+		t.Fatal("stale knock accepted")
+	}
+
+	knock := NewKnock(secret)
+	if !gate.consumeKnock(knock, src) {
+		t.Fatal("valid knock rejected")
+	}
+	if gate.consumeKnock(knock, netip.MustParseAddr("192.0.2.2")) {
+		t.Fatal("replayed knock accepted")
+	}
+	if !gate.admit(src) {
+		t.Fatal("initiation not admitted after knock")
+	}
+	if gate.admit(netip.MustParseAddr("192.0.2.2")) {
+		t.Fatal("initiation admitted from another address")
+	}
+
+	dev.SetKnockSecret([32]byte{})
+	if !gate.admit(netip.MustParseAddr("192.0.2.2")) {
+		t.Fatal("initiation dropped with the gate disabled")
+	}
+}
+
+func TestKnockHandshake(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	var secret [32]byte
+	secret[31] = 0x5a
+	if err := pair[0].dev.IpcSet(uapiCfg("knock_secret", hex.EncodeToString(secret[:]))); err != nil {
+		t.Fatal(err)
+	}
+
+	pub0 := pair[0].dev.staticIdentity.publicKey
+	peer := pair[1].dev.LookupPeer(pub0)
+	peer.SendHandshakeInitiation(false)
+	time.Sleep(100 * time.Millisecond)
+	if peer.lastHandshakeNano.Load() != 0 {
+		t.Fatal("handshake completed without a knock")
+	}
+
+	// Allow an immediate retry rather than waiting for RekeyTimeout.
+	peer.handshake.mutex.Lock()
+	peer.handshake.lastSentHandshake = time.Now().Add(-(RekeyTimeout + time.Second))
+	peer.handshake.mutex.Unlock()
+
+	if err := peer.SendKnock(secret); err != nil {
+		t.Fatal(err)
+	}
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+}
@@ -0,0 +1,137 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// Limits bounds the peers, queues and preallocated buffers of a Device.
+// Small embedded systems may want to lower them; concentrators serving very
+// many peers may want to raise them.
+type Limits struct {
+	MaxPeers                   int    // maximum number of configured peers
+	QueueStagedSize            int    // per-peer packets held while awaiting a handshake
+	QueueOutboundSize          int    // batches awaiting encryption, per device and per peer
+	QueueInboundSize           int    // batches awaiting decryption, per device and per peer
+	QueueHandshakeSize         int    // handshake messages awaiting processing
+	PreallocatedBuffersPerPool uint32 // if nonzero, caps each buffer pool at this many items
+	MinCryptoWorkers           int    // encryption and decryption workers always running
+	MaxCryptoWorkers           int    // encryption and decryption workers running under load
+}
+
+// Link-time overrides of the platform defaults, for programs that cannot
+// call NewDeviceWithLimits. For example:
+//
+//	go build -ldflags '-X golang.zx2c4.com/wireguard/device.linkMaxPeers=131072'
+//
+// Invalid values cause a panic at initialization.
+var (
+	linkMaxPeers                   string
+	linkQueueStagedSize            string
+	linkQueueOutboundSize          string
+	linkQueueInboundSize           string
+	linkQueueHandshakeSize         string
+	linkPreallocatedBuffersPerPool string
+	linkMinCryptoWorkers           string
+	linkMaxCryptoWorkers           string
+)
+
+var defaultLimits Limits
+
+func init() {
+	defaultLimits = Limits{
+		MaxPeers:                   MaxPeers,
+		QueueStagedSize:            QueueStagedSize,
+		QueueOutboundSize:          QueueOutboundSize,
+		QueueInboundSize:           QueueInboundSize,
+		QueueHandshakeSize:         QueueHandshakeSize,
+		PreallocatedBuffersPerPool: uint32(PreallocatedBuffersPerPool),
+		MinCryptoWorkers:           1,
+		MaxCryptoWorkers:           runtime.NumCPU(),
+	}
+	overrides := []struct {
+		name  string
+		value string
+		dst   *int
+	}{
+		{"linkMaxPeers", linkMaxPeers, &defaultLimits.MaxPeers},
+		{"linkQueueStagedSize", linkQueueStagedSize, &defaultLimits.QueueStagedSize},
+		{"linkQueueOutboundSize", linkQueueOutboundSize, &defaultLimits.QueueOutboundSize},
+		{"linkQueueInboundSize", linkQueueInboundSize, &defaultLimits.QueueInboundSize},
+		{"linkQueueHandshakeSize", linkQueueHandshakeSize, &defaultLimits.QueueHandshakeSize},
+		{"linkMinCryptoWorkers", linkMinCryptoWorkers, &defaultLimits.MinCryptoWorkers},
+		{"linkMaxCryptoWorkers", linkMaxCryptoWorkers, &defaultLimits.MaxCryptoWorkers},
+	}
+	for _, o := range overrides {
+		if o.value == "" {
+			continue
+		}
+		v, err := strconv.Atoi(o.value)
+		if err != nil {
+			panic(fmt.Sprintf("device: invalid link-time %s: %v", o.name, err))
+		}
+		*o.dst = v
+	}
+	if linkPreallocatedBuffersPerPool != "" {
+		v, err := strconv.ParseUint(linkPreallocatedBuffersPerPool, 10, 32)
+		if err != nil {
+			panic(fmt.Sprintf("device: invalid link-time linkPreallocatedBuffersPerPool: %v", err))
+		}
+		defaultLimits.PreallocatedBuffersPerPool = uint32(v)
+	}
+	if err := defaultLimits.Validate(); err != nil {
+		panic(fmt.Sprintf("device: invalid link-time limits: %v", err))
+	}
+}
+
+// DefaultLimits returns the limits used by NewDevice: the platform defaults,
+// adjusted by any link-time overrides.
+func DefaultLimits() Limits {
+	return defaultLimits
+}
+
+// Validate reports whether the limits are within the range this package
+// supports.
+func (l Limits) Validate() error {
+	check := func(name string, v, lo, hi int) error {
+		if v < lo || v > hi {
+			return fmt.Errorf("%s %d out of range [%d, %d]", name, v, lo, hi)
+		}
+		return nil
+	}
+	for _, err := range []error{
+		check("MaxPeers", l.MaxPeers, 1, 1<<24),
+		check("QueueStagedSize", l.QueueStagedSize, 1, 1<<16),
+		check("QueueOutboundSize", l.QueueOutboundSize, 1, 1<<20),
+		check("QueueInboundSize", l.QueueInboundSize, 1, 1<<20),
+		check("QueueHandshakeSize", l.QueueHandshakeSize, 8, 1<<20),
+		check("MinCryptoWorkers", l.MinCryptoWorkers, 1, 1<<10),
+		check("MaxCryptoWorkers", l.MaxCryptoWorkers, l.MinCryptoWorkers, 1<<10),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	if l.PreallocatedBuffersPerPool > 1<<24 {
+		return fmt.Errorf("PreallocatedBuffersPerPool %d out of range [0, %d]", l.PreallocatedBuffersPerPool, 1<<24)
+	}
+	return nil
+}
+
+// NewDeviceWithLimits is like NewDevice, but uses the given limits rather
+// than DefaultLimits.
+func NewDeviceWithLimits(tunDevice tun.Device, bind conn.Bind, logger *Logger, limits Limits) (*Device, error) {
+	if err := limits.Validate(); err != nil {
+		return nil, err
+	}
+	return newDevice(tunDevice, bind, logger, limits), nil
+}
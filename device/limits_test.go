@@ -0,0 +1,93 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func TestNewDeviceWithLimits(t *testing.T) {
+	if err := DefaultLimits().Validate(); err != nil {
+		t.Fatalf("default limits invalid: %v", err)
+	}
+
+	limits := DefaultLimits()
+	limits.MaxPeers = 2
+	limits.QueueHandshakeSize = 16
+	dev, err := NewDeviceWithLimits(tuntest.NewChannelTUN().TUN(), conn.NewDefaultBind(), NewLogger(LogLevelError, ""), limits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	if cap(dev.queue.handshake.c) != 16 {
+		t.Errorf("handshake queue capacity = %d, want 16", cap(dev.queue.handshake.c))
+	}
+	for i := 0; i < 3; i++ {
+		sk, err := newPrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = dev.NewPeer(sk.publicKey())
+		if i < 2 && err != nil {
+			t.Fatalf("peer %d: %v", i, err)
+		}
+		if i == 2 && err == nil {
+			t.Fatal("peer beyond MaxPeers was created")
+		}
+	}
+
+	limits.QueueInboundSize = 0
+	if _, err := NewDeviceWithLimits(tuntest.NewChannelTUN().TUN(), conn.NewDefaultBind(), NewLogger(LogLevelError, ""), limits); err == nil {
+		t.Error("zero inbound queue size was accepted")
+	}
+}
+
+func TestWorkerScaler(t *testing.T) {
+	c := make(chan int, 16)
+	done := make(chan struct{}, 16)
+	var w workerScaler
+	w.init(1, 3, func(id int) {
+		idle := time.NewTimer(WorkerIdleTimeout)
+		defer idle.Stop()
+		for {
+			if _, ok := nextWork(&w, c, idle); !ok {
+				done <- struct{}{}
+				return
+			}
+		}
+	})
+	if n := w.running.Load(); n != 1 {
+		t.Fatalf("%d workers running after init, want 1", n)
+	}
+	w.grow(0)
+	if n := w.running.Load(); n != 1 {
+		t.Fatalf("%d workers running without backlog, want 1", n)
+	}
+	for i := 0; i < 4; i++ {
+		w.grow(8)
+	}
+	if n := w.running.Load(); n != 3 {
+		t.Fatalf("%d workers running under load, want 3", n)
+	}
+
+	// Surplus workers exit once idle, leaving the minimum.
+	for i := 0; i < 2; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * WorkerIdleTimeout):
+			t.Fatal("surplus worker did not exit")
+		}
+	}
+	if n := w.running.Load(); n != 1 {
+		t.Fatalf("%d workers running after idling, want 1", n)
+	}
+	close(c)
+	<-done
+}
@@ -6,8 +6,14 @@
 package device
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
+	"strings"
+	"time"
 )
 
 // A Logger provides logging for a Device.
@@ -15,9 +21,20 @@ import (
 // They must be safe for concurrent use.
 // They do not require a trailing newline in the format.
 // If nil, that level of logging will be silent.
+//
+// If Handler is not nil, the device logs to it instead, as structured
+// records with the subsystem that logged them and their attributes, and
+// Verbosef and Errorf are only there for other users of the Logger.
 type Logger struct {
 	Verbosef func(format string, args ...any)
 	Errorf   func(format string, args ...any)
+	Handler  slog.Handler
+
+	// Loggers of NewLogger and NewJSONLogger keep what their level
+	// discards, so that the device's levels can be raised past it.
+	level   int
+	leveled bool
+	logf    [2]func(format string, args ...any) // verbose and error, not discarded
 }
 
 // Log levels for use with NewLogger.
@@ -34,15 +51,296 @@ func DiscardLogf(format string, args ...any) {}
 // It logs at the specified log level and above.
 // It decorates log lines with the log level, date, time, and prepend.
 func NewLogger(level int, prepend string) *Logger {
-	logger := &Logger{DiscardLogf, DiscardLogf}
+	logger := &Logger{Verbosef: DiscardLogf, Errorf: DiscardLogf, level: level, leveled: true}
 	logf := func(prefix string) func(string, ...any) {
 		return log.New(os.Stdout, prefix+": "+prepend, log.Ldate|log.Ltime).Printf
 	}
+	logger.logf = [2]func(string, ...any){logf("DEBUG"), logf("ERROR")}
 	if level >= LogLevelVerbose {
-		logger.Verbosef = logf("DEBUG")
+		logger.Verbosef = logger.logf[0]
 	}
 	if level >= LogLevelError {
-		logger.Errorf = logf("ERROR")
+		logger.Errorf = logger.logf[1]
 	}
 	return logger
 }
+
+// NewJSONLogger constructs a Logger that writes records to w as JSON
+// objects, one per line, with the time, the level, the message, the
+// subsystem and the attributes of each record. It logs at the specified
+// log level and above. Its Verbosef and Errorf log records of the device
+// subsystem.
+func NewJSONLogger(w io.Writer, level int) *Logger {
+	h := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})
+	logger := &Logger{Verbosef: DiscardLogf, Errorf: DiscardLogf, Handler: h, level: level, leveled: true}
+	deviceHandler := h.WithAttrs([]slog.Attr{slog.String(logSubsystemKey, LogSubsystemDevice)})
+	logf := func(level slog.Level) func(string, ...any) {
+		return func(format string, args ...any) {
+			deviceHandler.Handle(context.Background(), slog.NewRecord(time.Now(), level, fmt.Sprintf(format, args...), 0))
+		}
+	}
+	if level >= LogLevelVerbose {
+		logger.Verbosef = logf(slog.LevelDebug)
+	}
+	if level >= LogLevelError {
+		logger.Errorf = logf(slog.LevelError)
+	}
+	return logger
+}
+
+/* Log subsystems
+ *
+ * The device logs each record from one of its subsystems: the handshake,
+ * the transport of data packets, the bind and its endpoints, the TUN
+ * device, and the device itself for the rest, such as configuration. Each
+ * subsystem has its own level, set with SetLogLevel or the log_level UAPI
+ * key while the device runs, from that of NewLogger or NewJSONLogger, or
+ * verbose for other Loggers. A level more verbose than a Logger's own is
+ * cut by the Logger, except for those of NewLogger and NewJSONLogger.
+ */
+
+const (
+	LogSubsystemDevice    = "device"
+	LogSubsystemHandshake = "handshake"
+	LogSubsystemTransport = "transport"
+	LogSubsystemConn      = "conn"
+	LogSubsystemTun       = "tun"
+)
+
+// LogSubsystems returns the names of the subsystems of the device's log.
+func LogSubsystems() []string {
+	return []string{LogSubsystemDevice, LogSubsystemHandshake, LogSubsystemTransport, LogSubsystemConn, LogSubsystemTun}
+}
+
+// logSubsystemKey is the key of the subsystem attribute of each record.
+const logSubsystemKey = "subsystem"
+
+// Keys of the attributes of records about a peer, an endpoint or an error,
+// which hold the values that their messages also give.
+const (
+	logPeerKey     = "peer"
+	logEndpointKey = "endpoint"
+	logErrorKey    = "error"
+)
+
+// A logPeer is a peer as the value of an attribute, formatted only if the
+// record is handled.
+type logPeer struct{ *Peer }
+
+func (p logPeer) LogValue() slog.Value { return slog.StringValue(p.String()) }
+
+func peerAttr(peer *Peer) slog.Attr { return slog.Any(logPeerKey, logPeer{peer}) }
+
+func endpointAttr(endpoint string) slog.Attr { return slog.String(logEndpointKey, endpoint) }
+
+func errorAttr(err error) slog.Attr { return slog.String(logErrorKey, err.Error()) }
+
+// levelSilent is above the level of every record.
+const levelSilent = slog.LevelError + 4
+
+var logLevelNames = []string{LogLevelSilent: "silent", LogLevelError: "error", LogLevelVerbose: "verbose"}
+
+func slogLevel(level int) slog.Level {
+	switch {
+	case level >= LogLevelVerbose:
+		return slog.LevelDebug
+	case level == LogLevelError:
+		return slog.LevelError
+	}
+	return levelSilent
+}
+
+func parseLogLevel(name string) (int, error) {
+	switch name {
+	case "verbose", "debug":
+		return LogLevelVerbose, nil
+	case "error":
+		return LogLevelError, nil
+	case "silent":
+		return LogLevelSilent, nil
+	}
+	return 0, fmt.Errorf("invalid log level %q", name)
+}
+
+// parseLogLevelSetting parses a value of the log_level UAPI key, a level
+// for all subsystems or a subsystem and its level, as in "handshake:verbose".
+func parseLogLevelSetting(value string) (subsystem string, level int, err error) {
+	subsystem, name, ok := strings.Cut(value, ":")
+	if !ok {
+		subsystem, name = "", value
+	} else if subsystem == "" {
+		return "", 0, fmt.Errorf("invalid log level %q", value)
+	}
+	level, err = parseLogLevel(name)
+	return
+}
+
+// A subsystemLog logs the records of one subsystem from its level on.
+type subsystemLog struct {
+	handler slog.Handler // with the subsystem attribute
+	level   slog.LevelVar
+	initial slog.Level // of the Logger, left out of get output
+}
+
+func (l *subsystemLog) enabled(level slog.Level) bool {
+	return level >= l.level.Level() && l.handler.Enabled(context.Background(), level)
+}
+
+func (l *subsystemLog) log(level slog.Level, msg string, attrs ...slog.Attr) {
+	r := slog.NewRecord(time.Now(), level, msg, 0)
+	r.AddAttrs(attrs...)
+	l.handler.Handle(context.Background(), r)
+}
+
+func (l *subsystemLog) Verbosef(format string, args ...any) {
+	if l.enabled(slog.LevelDebug) {
+		l.log(slog.LevelDebug, fmt.Sprintf(format, args...))
+	}
+}
+
+func (l *subsystemLog) Errorf(format string, args ...any) {
+	if l.enabled(slog.LevelError) {
+		l.log(slog.LevelError, fmt.Sprintf(format, args...))
+	}
+}
+
+// verboseAttrs and errorAttrs are Verbosef and Errorf for records with the
+// attributes attrs, so that structured handlers get the values of the
+// message apart from it. The message is the same as without them, and all
+// that Printf-style Loggers log.
+func (l *subsystemLog) verboseAttrs(attrs []slog.Attr, format string, args ...any) {
+	if l.enabled(slog.LevelDebug) {
+		l.log(slog.LevelDebug, fmt.Sprintf(format, args...), attrs...)
+	}
+}
+
+func (l *subsystemLog) errorAttrs(attrs []slog.Attr, format string, args ...any) {
+	if l.enabled(slog.LevelError) {
+		l.log(slog.LevelError, fmt.Sprintf(format, args...), attrs...)
+	}
+}
+
+// A deviceLog is the log of a device, logging the records of the device
+// subsystem itself and those of each of the others from their fields.
+type deviceLog struct {
+	subsystemLog
+	handshake subsystemLog
+	transport subsystemLog
+	conn      subsystemLog
+	tun       subsystemLog
+}
+
+func newDeviceLog(logger *Logger) *deviceLog {
+	var h slog.Handler
+	switch {
+	case logger.Handler != nil:
+		h = logger.Handler
+	case logger.leveled:
+		h = &printfHandler{logf: logger.logf}
+	default:
+		h = &printfHandler{logf: [2]func(string, ...any){logger.Verbosef, logger.Errorf}}
+	}
+	level := LogLevelVerbose
+	if logger.leveled {
+		level = logger.level
+	}
+	l := new(deviceLog)
+	for _, name := range LogSubsystems() {
+		s := l.subsystem(name)
+		s.handler = h.WithAttrs([]slog.Attr{slog.String(logSubsystemKey, name)})
+		s.initial = slogLevel(level)
+		s.level.Set(s.initial)
+	}
+	return l
+}
+
+// subsystem returns the log of the named subsystem, or nil if there is
+// none of that name.
+func (l *deviceLog) subsystem(name string) *subsystemLog {
+	switch name {
+	case LogSubsystemDevice:
+		return &l.subsystemLog
+	case LogSubsystemHandshake:
+		return &l.handshake
+	case LogSubsystemTransport:
+		return &l.transport
+	case LogSubsystemConn:
+		return &l.conn
+	case LogSubsystemTun:
+		return &l.tun
+	}
+	return nil
+}
+
+// SetLogLevel sets the level of the named subsystem of the device's log,
+// or of all of them if subsystem is empty, to one of the levels of
+// NewLogger.
+func (device *Device) SetLogLevel(subsystem string, level int) error {
+	if level < LogLevelSilent || level > LogLevelVerbose {
+		return fmt.Errorf("invalid log level %d", level)
+	}
+	names := LogSubsystems()
+	if subsystem != "" {
+		if device.log.subsystem(subsystem) == nil {
+			return fmt.Errorf("unknown log subsystem %q", subsystem)
+		}
+		names = []string{subsystem}
+	}
+	for _, name := range names {
+		device.log.subsystem(name).level.Set(slogLevel(level))
+	}
+	return nil
+}
+
+// LogLevel returns the level of the named subsystem of the device's log,
+// or -1 if there is none of that name.
+func (device *Device) LogLevel(subsystem string) int {
+	s := device.log.subsystem(subsystem)
+	if s == nil {
+		return -1
+	}
+	switch s.level.Level() {
+	case slog.LevelDebug:
+		return LogLevelVerbose
+	case slog.LevelError:
+		return LogLevelError
+	}
+	return LogLevelSilent
+}
+
+// LogLevels returns the level of each subsystem of the device's log.
+func (device *Device) LogLevels() map[string]int {
+	levels := make(map[string]int)
+	for _, name := range LogSubsystems() {
+		levels[name] = device.LogLevel(name)
+	}
+	return levels
+}
+
+// A printfHandler hands the messages of records to the Printf-style
+// functions of a Logger, so that their lines are those of before records
+// had subsystems.
+type printfHandler struct {
+	logf [2]func(format string, args ...any) // verbose and error
+}
+
+func (h *printfHandler) logfOf(level slog.Level) func(string, ...any) {
+	if level >= slog.LevelError {
+		return h.logf[1]
+	}
+	return h.logf[0]
+}
+
+func (h *printfHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.logfOf(level) != nil
+}
+
+func (h *printfHandler) Handle(_ context.Context, r slog.Record) error {
+	if logf := h.logfOf(r.Level); logf != nil {
+		logf("%s", r.Message)
+	}
+	return nil
+}
+
+func (h *printfHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+func (h *printfHandler) WithGroup(string) slog.Handler      { return h }
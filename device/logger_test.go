@@ -0,0 +1,194 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+// logBuffer collects the lines of a Logger.
+type logBuffer struct {
+	sync.Mutex
+	bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.Write(p)
+}
+
+func (b *logBuffer) logf(format string, args ...any) {
+	fmt.Fprintf(b, format+"\n", args...)
+}
+
+// lines returns the lines logged since its last call that contain marker.
+func (b *logBuffer) lines(marker string) (lines []string) {
+	b.Lock()
+	defer b.Unlock()
+	for _, line := range strings.Split(b.String(), "\n") {
+		if strings.Contains(line, marker) {
+			lines = append(lines, line)
+		}
+	}
+	b.Reset()
+	return
+}
+
+func newLogDevice(t *testing.T, logger *Logger) *Device {
+	dev := NewDevice(tuntest.NewChannelTUN().TUN(), bindtest.NewChannelBinds()[0], logger)
+	t.Cleanup(dev.Close)
+	return dev
+}
+
+func TestJSONLogger(t *testing.T) {
+	var buf logBuffer
+	dev := newLogDevice(t, NewJSONLogger(&buf, LogLevelError))
+	dev.log.handshake.Verbosef("marker %d", 1)
+	dev.log.handshake.Errorf("marker %d", 2)
+	lines := buf.lines("marker")
+	if len(lines) != 1 {
+		t.Fatalf("logged %q", lines)
+	}
+	var record struct {
+		Time, Level, Msg, Subsystem string
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Time == "" || record.Level != "ERROR" || record.Msg != "marker 2" || record.Subsystem != LogSubsystemHandshake {
+		t.Errorf("record %+v", record)
+	}
+
+	// The level of NewJSONLogger is the initial one, and can be raised.
+	assertNil(t, dev.IpcSet("log_level=handshake:verbose\n"))
+	dev.log.handshake.Verbosef("marker 3")
+	dev.log.transport.Verbosef("marker 4")
+	if lines := buf.lines("marker"); len(lines) != 1 || !strings.Contains(lines[0], `"level":"DEBUG","msg":"marker 3","subsystem":"handshake"`) {
+		t.Errorf("logged %q", lines)
+	}
+}
+
+func TestJSONLoggerAttrs(t *testing.T) {
+	var buf logBuffer
+	logger := NewJSONLogger(&buf, LogLevelVerbose)
+	dev := newLogDevice(t, logger)
+	sk, err := newPrivateKey()
+	assertNil(t, err)
+	peer, err := dev.NewPeer(sk.publicKey())
+	assertNil(t, err)
+	decode := func(line string) (record struct{ Msg, Subsystem, Peer, Endpoint, Error string }) {
+		t.Helper()
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	// The values of a message are attributes as well.
+	buf.lines("")
+	if err := peer.SendHandshakeInitiation(false); !errors.Is(err, ErrNoEndpoint) {
+		t.Fatalf("SendHandshakeInitiation = %v, want ErrNoEndpoint", err)
+	}
+	lines := buf.lines("Failed to send handshake initiation")
+	if len(lines) != 1 {
+		t.Fatalf("logged %q", lines)
+	}
+	if r := decode(lines[0]); r.Subsystem != LogSubsystemHandshake || r.Peer != peer.String() || r.Error != ErrNoEndpoint.Error() {
+		t.Errorf("record %+v", r)
+	}
+	dev.SendHandshakeCookie(&QueueHandshakeElement{packet: make([]byte, MessageInitiationSize), endpoint: bindtest.ChannelEndpoint(7)})
+	lines = buf.lines("Sending cookie response")
+	if len(lines) != 1 {
+		t.Fatalf("logged %q", lines)
+	}
+	if r := decode(lines[0]); r.Endpoint != "127.0.0.1:7" || r.Peer != "" {
+		t.Errorf("record %+v", r)
+	}
+
+	// The Logger's own functions log records of the device subsystem.
+	logger.Verbosef("marker %d", 1)
+	if lines := buf.lines("marker"); len(lines) != 1 || decode(lines[0]).Subsystem != LogSubsystemDevice {
+		t.Errorf("logged %q", lines)
+	}
+}
+
+func TestLogLevels(t *testing.T) {
+	var buf logBuffer
+	dev := newLogDevice(t, &Logger{Verbosef: buf.logf, Errorf: buf.logf})
+	for _, name := range LogSubsystems() {
+		if level := dev.LogLevel(name); level != LogLevelVerbose {
+			t.Errorf("%s at level %d", name, level)
+		}
+	}
+	logAll := func() {
+		dev.log.Verbosef("marker device")
+		dev.log.handshake.Verbosef("marker handshake")
+		dev.log.transport.Verbosef("marker transport")
+		dev.log.conn.Verbosef("marker conn")
+		dev.log.tun.Errorf("marker tun")
+	}
+	logAll()
+	// The lines of Printf-style functions are only the messages.
+	if got := strings.Join(buf.lines("marker"), ","); got != "marker device,marker handshake,marker transport,marker conn,marker tun" {
+		t.Errorf("logged %s", got)
+	}
+
+	assertNil(t, dev.IpcSet("log_level=error\nlog_level=conn:verbose\nlog_level=tun:silent\n"))
+	logAll()
+	if got := strings.Join(buf.lines("marker"), ","); got != "marker conn" {
+		t.Errorf("logged %s", got)
+	}
+	cfg, err := dev.IpcGet()
+	assertNil(t, err)
+	for _, line := range []string{"log_level=device:error", "log_level=handshake:error", "log_level=tun:silent"} {
+		if !strings.Contains("\n"+cfg, "\n"+line+"\n") {
+			t.Errorf("get output lacks %s:\n%s", line, cfg)
+		}
+	}
+	if strings.Contains(cfg, "log_level=conn:") {
+		t.Errorf("get output lists the conn level of the Logger:\n%s", cfg)
+	}
+
+	for _, value := range []string{"loud", "handshake:", "nosuch:verbose", ":error"} {
+		if err := dev.IpcSet("log_level=" + value + "\n"); err == nil {
+			t.Errorf("set log level %q", value)
+		}
+	}
+
+	assertNil(t, dev.Configure(Config{LogLevels: map[string]int{"": LogLevelSilent, LogSubsystemTransport: LogLevelVerbose}}))
+	status := dev.Status()
+	if status.LogLevels[LogSubsystemTransport] != LogLevelVerbose || status.LogLevels[LogSubsystemConn] != LogLevelSilent {
+		t.Errorf("status log levels %v", status.LogLevels)
+	}
+	if err := dev.Configure(Config{LogLevels: map[string]int{LogSubsystemTun: 7}}); err == nil {
+		t.Error("configured an invalid log level")
+	}
+}
+
+func TestNewLoggerLevel(t *testing.T) {
+	dev := newLogDevice(t, NewLogger(LogLevelError, ""))
+	for _, name := range LogSubsystems() {
+		if level := dev.LogLevel(name); level != LogLevelError {
+			t.Errorf("%s at level %d", name, level)
+		}
+	}
+	if cfg, _ := dev.IpcGet(); strings.Contains(cfg, "log_level=") {
+		t.Errorf("get output lists the levels of the Logger:\n%s", cfg)
+	}
+	if dev.LogLevel("nosuch") != -1 {
+		t.Error("level of an unknown subsystem")
+	}
+}
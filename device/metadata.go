@@ -0,0 +1,98 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"errors"
+	"fmt"
+)
+
+/* Peer metadata
+ *
+ * Each peer can carry an opaque metadata blob, such as a signed attestation
+ * of the organizational identity behind its public key, which the device
+ * stores and reports but does not interpret. A device with a metadata
+ * verifier checks the metadata of a peer when it is attached, rejecting
+ * metadata the verifier fails, and again each time the peer is admitted to
+ * a session: an authenticated handshake initiation or response from a peer
+ * whose metadata the verifier fails, or who has none that it accepts, is
+ * dropped. Verifying on every handshake lets attestations that expire or
+ * are revoked take effect without reconfiguring the peer.
+ */
+
+// ErrMetadataRejected is returned, wrapping the error of the verifier, for
+// metadata the metadata verifier of the device fails.
+var ErrMetadataRejected = errors.New("metadata rejected")
+
+// A MetadataVerifier verifies the metadata attached to the peer with the
+// given public key, which is nil for a peer without any. It is called from
+// the goroutines processing handshakes, and must not block.
+type MetadataVerifier func(publicKey NoisePublicKey, metadata []byte) error
+
+// SetMetadataVerifier sets the function verifying the metadata of peers, or
+// nil to admit peers regardless of their metadata. Metadata attached before
+// it is set is verified at the next handshake with its peer.
+func (device *Device) SetMetadataVerifier(fn MetadataVerifier) {
+	if fn == nil {
+		device.metadataVerifier.Store(nil)
+		return
+	}
+	device.metadataVerifier.Store(&fn)
+}
+
+// Metadata returns the metadata attached to the peer, or nil if there is
+// none.
+func (peer *Peer) Metadata() []byte {
+	if metadata := peer.metadata.Load(); metadata != nil {
+		return append([]byte(nil), (*metadata)...)
+	}
+	return nil
+}
+
+// SetMetadata attaches metadata to the peer, replacing any attached before,
+// after verifying it with the metadata verifier of the device, if any. Empty
+// metadata detaches it.
+func (peer *Peer) SetMetadata(metadata []byte) error {
+	if len(metadata) > MaxPeerMetadataSize {
+		return errors.New("metadata too long")
+	}
+	if len(metadata) == 0 {
+		peer.metadata.Store(nil)
+		return nil
+	}
+	metadata = append([]byte(nil), metadata...)
+	if err := peer.verifyMetadata(metadata); err != nil {
+		return err
+	}
+	peer.metadata.Store(&metadata)
+	return nil
+}
+
+func (peer *Peer) verifyMetadata(metadata []byte) error {
+	verifier := peer.device.metadataVerifier.Load()
+	if verifier == nil {
+		return nil
+	}
+	if err := (*verifier)(peer.handshake.remoteStatic, metadata); err != nil {
+		return fmt.Errorf("%w: %w", ErrMetadataRejected, err)
+	}
+	return nil
+}
+
+// admitMetadata reports whether the metadata of the peer admits it to a
+// session.
+func (peer *Peer) admitMetadata() bool {
+	var metadata []byte
+	if m := peer.metadata.Load(); m != nil {
+		metadata = *m
+	}
+	if err := peer.verifyMetadata(metadata); err != nil {
+		peer.metadataRejections.Add(1)
+		peer.device.log.handshake.Verbosef("%v - Handshake dropped: %v", peer, err)
+		return false
+	}
+	return true
+}
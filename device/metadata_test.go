@@ -0,0 +1,79 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPeerMetadata(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pub0 := pair[0].dev.staticIdentity.publicKey
+	attestation := []byte("org=example;signature=valid")
+
+	var verified NoisePublicKey
+	pair[1].dev.SetMetadataVerifier(func(pk NoisePublicKey, metadata []byte) error {
+		if !bytes.HasSuffix(metadata, []byte(";signature=valid")) {
+			return errors.New("bad signature")
+		}
+		verified = pk
+		return nil
+	})
+
+	peer := pair[1].dev.LookupPeer(pub0)
+	if peer.admitMetadata() {
+		t.Fatal("peer without metadata admitted")
+	}
+	if n := peer.metadataRejections.Load(); n != 1 {
+		t.Errorf("%d metadata rejections, want 1", n)
+	}
+	err := pair[1].dev.IpcSet(uapiCfg(
+		"public_key", hex.EncodeToString(pub0[:]),
+		"metadata", hex.EncodeToString([]byte("org=example;signature=forged")),
+	))
+	if !errors.Is(err, ErrMetadataRejected) {
+		t.Errorf("forged metadata: got error %v, want %v", err, ErrMetadataRejected)
+	}
+	if err := peer.SetMetadata(make([]byte, MaxPeerMetadataSize+1)); err == nil {
+		t.Error("oversized metadata accepted")
+	}
+	if err := pair[1].dev.IpcSet(uapiCfg(
+		"public_key", hex.EncodeToString(pub0[:]),
+		"metadata", hex.EncodeToString(attestation),
+	)); err != nil {
+		t.Fatal(err)
+	}
+	if verified != pub0 {
+		t.Errorf("verifier called for %x, want %x", verified[:], pub0[:])
+	}
+
+	// The second device admits the first both as responder and initiator.
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+
+	if got := peer.Metadata(); !bytes.Equal(got, attestation) {
+		t.Errorf("metadata %q, want %q", got, attestation)
+	}
+	cfg, err := pair[1].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(cfg, "metadata="+hex.EncodeToString(attestation)+"\n") {
+		t.Errorf("get output lacks metadata:\n%s", cfg)
+	}
+
+	if err := peer.SetMetadata(nil); err != nil {
+		t.Fatal(err)
+	}
+	if peer.Metadata() != nil || peer.admitMetadata() {
+		t.Error("peer admitted after its metadata was detached")
+	}
+}
@@ -0,0 +1,87 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/binary"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	tcpHeaderLen    = 20
+	tcpFlagSYN      = 0x02
+	tcpOptionEnd    = 0
+	tcpOptionNop    = 1
+	tcpOptionMSS    = 2
+	tcpOptionMSSLen = 4
+)
+
+// clampMSS lowers the MSS option of a TCP SYN or SYN-ACK in packet so that
+// segments in either direction fit within mtu, fixing up the TCP checksum.
+// Other packets, including fragments and IPv6 packets carrying extension
+// headers, are left untouched.
+func clampMSS(packet []byte, mtu uint32) {
+	var tcp []byte
+	var overhead uint32
+	switch packet[0] >> 4 {
+	case 4:
+		if len(packet) < ipv4.HeaderLen {
+			return
+		}
+		ihl := int(packet[0]&0x0f) * 4
+		if packet[9] != 6 || ihl < ipv4.HeaderLen || len(packet) < ihl ||
+			binary.BigEndian.Uint16(packet[6:])&0x1fff != 0 {
+			return
+		}
+		tcp, overhead = packet[ihl:], ipv4.HeaderLen+tcpHeaderLen
+	case 6:
+		if len(packet) < ipv6.HeaderLen || packet[6] != 6 {
+			return
+		}
+		tcp, overhead = packet[ipv6.HeaderLen:], ipv6.HeaderLen+tcpHeaderLen
+	default:
+		return
+	}
+	if mtu <= overhead || len(tcp) < tcpHeaderLen || tcp[13]&tcpFlagSYN == 0 {
+		return
+	}
+	maxMSS := uint16(min(mtu-overhead, 0xffff))
+
+	dataOffset := int(tcp[12]>>4) * 4
+	if dataOffset < tcpHeaderLen || dataOffset > len(tcp) {
+		return
+	}
+	options := tcp[tcpHeaderLen:dataOffset]
+	for i := 0; i < len(options); {
+		switch options[i] {
+		case tcpOptionEnd:
+			return
+		case tcpOptionNop:
+			i++
+			continue
+		}
+		if i+1 >= len(options) || options[i+1] < 2 || i+int(options[i+1]) > len(options) {
+			return
+		}
+		if options[i] == tcpOptionMSS && options[i+1] == tcpOptionMSSLen {
+			field := options[i+2 : i+4]
+			mss := binary.BigEndian.Uint16(field)
+			if mss <= maxMSS {
+				return
+			}
+			binary.BigEndian.PutUint16(field, maxMSS)
+			// Incremental checksum update, per RFC 1624, equation 3.
+			sum := uint32(^binary.BigEndian.Uint16(tcp[16:])) + uint32(^mss) + uint32(maxMSS)
+			sum = (sum & 0xffff) + (sum >> 16)
+			sum = (sum & 0xffff) + (sum >> 16)
+			binary.BigEndian.PutUint16(tcp[16:], ^uint16(sum))
+			return
+		}
+		i += int(options[i+1])
+	}
+}
@@ -0,0 +1,123 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func tcpChecksum(pseudo, tcp []byte) uint16 {
+	var sum uint32
+	add := func(b []byte) {
+		for i := 0; i+1 < len(b); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(b[i:]))
+		}
+		if len(b)%2 == 1 {
+			sum += uint32(b[len(b)-1]) << 8
+		}
+	}
+	add(pseudo)
+	add(tcp)
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
+
+// synPacket returns an IPv4 or IPv6 TCP packet with the given flags, carrying
+// a NOP, window scale and MSS option, with a valid checksum.
+func synPacket(v6 bool, flags byte, mss uint16) (packet []byte, pseudo []byte) {
+	tcp := []byte{
+		0x30, 0x39, 0x00, 0x50, // ports
+		0, 0, 0, 1, // seq
+		0, 0, 0, 0, // ack
+		0x80, flags, 0xff, 0xff, // data offset 32, flags, window
+		0, 0, 0, 0, // checksum, urgent
+		tcpOptionNop, 3, 3, 7, // nop, window scale
+		tcpOptionMSS, tcpOptionMSSLen, byte(mss >> 8), byte(mss),
+		tcpOptionNop, tcpOptionNop, tcpOptionNop, tcpOptionEnd,
+	}
+	var ip []byte
+	if v6 {
+		ip = make([]byte, 40)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(tcp)))
+		ip[6], ip[7] = 6, 64
+		ip[23], ip[39] = 1, 2
+		pseudo = append(append([]byte{}, ip[8:40]...), 0, 0, 0, byte(len(tcp)), 0, 0, 0, 6)
+	} else {
+		ip = []byte{0x45, 0, 0, byte(20 + len(tcp)), 0, 0, 0x40, 0, 64, 6, 0, 0, 10, 0, 0, 1, 10, 0, 0, 2}
+		pseudo = append(append([]byte{}, ip[12:20]...), 0, 6, 0, byte(len(tcp)))
+	}
+	binary.BigEndian.PutUint16(tcp[16:], tcpChecksum(pseudo, tcp))
+	return append(ip, tcp...), pseudo
+}
+
+func TestClampMSS(t *testing.T) {
+	for _, v6 := range []bool{false, true} {
+		hdr, want := 20, uint16(1400-40)
+		if v6 {
+			hdr, want = 40, 1400-60
+		}
+
+		packet, pseudo := synPacket(v6, tcpFlagSYN, 1460)
+		clampMSS(packet, 1400)
+		tcp := packet[hdr:]
+		if mss := binary.BigEndian.Uint16(tcp[26:]); mss != want {
+			t.Errorf("v6=%v: clamped MSS = %d, want %d", v6, mss, want)
+		}
+		if tcpChecksum(pseudo, tcp) != 0 {
+			t.Errorf("v6=%v: checksum invalid after clamping", v6)
+		}
+
+		packet, _ = synPacket(v6, tcpFlagSYN|0x10, 1200)
+		orig := append([]byte{}, packet...)
+		clampMSS(packet, 1400)
+		if !bytes.Equal(packet, orig) {
+			t.Errorf("v6=%v: MSS already below limit was modified", v6)
+		}
+
+		packet, _ = synPacket(v6, 0x10, 1460)
+		orig = append([]byte{}, packet...)
+		clampMSS(packet, 1400)
+		if !bytes.Equal(packet, orig) {
+			t.Errorf("v6=%v: non-SYN segment was modified", v6)
+		}
+	}
+}
+
+func TestMSSClampUAPI(t *testing.T) {
+	dev := randDevice(t)
+	defer dev.Close()
+	sk, err := newPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk := sk.publicKey()
+	if err := dev.IpcSet(fmt.Sprintf("public_key=%x\nmss_clamp_mtu=1380\n", pk[:])); err != nil {
+		t.Fatal(err)
+	}
+	uapi, err := dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(uapi, "mss_clamp_mtu=1380\n") {
+		t.Errorf("UAPI get does not report MSS clamping MTU:\n%s", uapi)
+	}
+	if err := dev.IpcSet(fmt.Sprintf("public_key=%x\nmss_clamp_mtu=100\n", pk[:])); err == nil {
+		t.Error("MSS clamping MTU below minimum was accepted")
+	}
+	if err := dev.IpcSet(fmt.Sprintf("public_key=%x\nmss_clamp_mtu=0\n", pk[:])); err != nil {
+		t.Fatal(err)
+	}
+	if uapi, _ = dev.IpcGet(); strings.Contains(uapi, "mss_clamp_mtu=") {
+		t.Errorf("UAPI get reports disabled MSS clamping:\n%s", uapi)
+	}
+}
@@ -0,0 +1,243 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+/* Cipher suite negotiation lets two peers of this fork agree on the suite of
+ * each session in its handshake, instead of both being pinned to the same
+ * one. A peer with a cipher suite offer configured appends the offer to its
+ * initiations, and a responder with an offer configured for the initiator
+ * answers with the suite selected in a trailer of its response:
+ *
+ *   initiation (148 bytes) | ChaCha20Poly1305(offer key, ZeroNonce, ids, initiation)
+ *   response (92 bytes) | ChaCha20Poly1305(selection key, ZeroNonce, id, response | sealed offer) | response data
+ *
+ * The ids of the offer are those of up to MaxCipherSuiteOffer suites in the
+ * initiator's order of preference, zero-filled to eight 32-bit words, and
+ * the id of a suite is the first word of the BLAKE2s hash of its name. The
+ * responder selects the first suite of the offer that its own offer for the
+ * initiator also holds, or the standard suite if none is. The offer key is
+ * derived from the chaining key after the initiation and the selection key
+ * from the final one, so only the two peers can read either, and the
+ * selection authenticates the offer it answers, so that a suite cannot be
+ * negotiated from an offer changed in flight.
+ *
+ * A session uses the suite selected if its handshake negotiated one, and the
+ * suite the peer is pinned to otherwise: when either side has no offer
+ * configured, or the responder does not implement negotiation. Stock peers
+ * drop initiations carrying an offer, so retransmissions of a handshake
+ * alternate between initiations with and without one, and a handshake with
+ * a stock peer completes with its first retry, in the standard wire format.
+ */
+
+const (
+	WGLabelCipherSuiteOffer     = "cipher suite offer"
+	WGLabelCipherSuiteSelection = "cipher suite selection"
+)
+
+const (
+	MaxCipherSuiteOffer             = 8                                                     // suites an offer may hold
+	MessageCipherSuiteOfferSize     = MaxCipherSuiteOffer*4 + chacha20poly1305.Overhead     // size of the offer trailing an initiation
+	MessageCipherSuiteSelectionSize = 4 + chacha20poly1305.Overhead                         // size of the selection trailing a response
+	MessageInitiationOfferSize      = MessageInitiationSize + MessageCipherSuiteOfferSize   // size of an unpadded initiation with an offer
+	MessageResponseSelectionSize    = MessageResponseSize + MessageCipherSuiteSelectionSize // size of a response with a selection and no data
+)
+
+// cipherSuiteNegotiation is the state of the negotiation of the handshake
+// in progress.
+type cipherSuiteNegotiation struct {
+	offer   []byte         // sealed offer sent or received with the initiation, nil if none
+	offered []*CipherSuite // suites the initiator offered, on its side
+	suite   *CipherSuite   // suite selected for the session, nil for the pinned one
+}
+
+// cipherSuiteID returns the id that offers and selections name the suite by.
+func cipherSuiteID(suite *CipherSuite) uint32 {
+	sum := blake2s.Sum256([]byte(suite.Name))
+	return binary.LittleEndian.Uint32(sum[:])
+}
+
+// SetCipherSuiteOffer sets the suites negotiated with the peer, in order of
+// preference, from the next handshake on: initiations to the peer offer
+// them, and offers in initiations from the peer are answered with the first
+// of theirs that is also one of these. An empty offer turns negotiation off.
+func (peer *Peer) SetCipherSuiteOffer(names []string) error {
+	if len(names) == 0 {
+		peer.suiteOffer.Store(nil)
+		return nil
+	}
+	if len(names) > MaxCipherSuiteOffer {
+		return fmt.Errorf("cipher suite offer of %d suites, at most %d are allowed", len(names), MaxCipherSuiteOffer)
+	}
+	suites := make([]*CipherSuite, 0, len(names))
+	for _, name := range names {
+		suite := LookupCipherSuite(name)
+		if suite == nil {
+			return unknownCipherSuiteError(name)
+		}
+		id := cipherSuiteID(suite)
+		if id == 0 || slices.ContainsFunc(suites, func(s *CipherSuite) bool { return cipherSuiteID(s) == id }) {
+			return fmt.Errorf("cipher suite %q offered twice", name)
+		}
+		if err := suite.selfTest(); err != nil {
+			return err
+		}
+		suites = append(suites, suite)
+	}
+	peer.suiteOffer.Store(&suites)
+	return nil
+}
+
+// CipherSuiteOffer returns the names of the suites negotiated with the peer,
+// or nil if negotiation is off.
+func (peer *Peer) CipherSuiteOffer() []string {
+	suites := peer.suiteOffer.Load()
+	if suites == nil {
+		return nil
+	}
+	names := make([]string, len(*suites))
+	for i, suite := range *suites {
+		names[i] = suite.Name
+	}
+	return names
+}
+
+// negotiationKey derives the key of the offer or selection named by label
+// from the current chaining key.
+func (peer *Peer) negotiationKey(key *[blake2s.Size]byte, label string) {
+	peer.handshake.handshakeHash().kdf1(key, peer.handshake.chainKey[:], []byte(label))
+}
+
+// sealCipherSuiteOffer appends the configured offer, if any, to an
+// initiation created but not yet sent. Only the first of each two attempts
+// of a handshake carries one, so that stock peers answer the others.
+func (peer *Peer) sealCipherSuiteOffer(packet []byte) []byte {
+	handshake := &peer.handshake
+	handshake.mutex.Lock()
+	defer handshake.mutex.Unlock()
+	handshake.negotiation = cipherSuiteNegotiation{}
+	suites := peer.suiteOffer.Load()
+	if suites == nil || peer.timers.handshakeAttempts.Load()%2 != 0 || handshake.state != handshakeInitiationCreated {
+		return packet
+	}
+
+	var ids [MaxCipherSuiteOffer * 4]byte
+	for i, suite := range *suites {
+		binary.LittleEndian.PutUint32(ids[i*4:], cipherSuiteID(suite))
+	}
+	var key [blake2s.Size]byte
+	peer.negotiationKey(&key, WGLabelCipherSuiteOffer)
+	aead, _ := chacha20poly1305.New(key[:])
+	setZero(key[:])
+	n := len(packet)
+	packet = aead.Seal(packet, ZeroNonce[:], ids[:], packet[:MessageInitiationSize])
+	handshake.negotiation.offer = append([]byte(nil), packet[n:]...)
+	handshake.negotiation.offered = *suites
+	return packet
+}
+
+// openCipherSuiteOffer reads the offer in the trailer of a consumed
+// initiation, if it carries one, and selects the suite of the session if
+// the peer has an offer configured. The offer is kept either way, as it
+// tells that the initiator reads the trailers of responses. A trailer that is not an offer, such as
+// padding, is ignored.
+func (peer *Peer) openCipherSuiteOffer(packet, trailer []byte) {
+	handshake := &peer.handshake
+	handshake.mutex.Lock()
+	defer handshake.mutex.Unlock()
+	handshake.negotiation = cipherSuiteNegotiation{}
+	ours := peer.suiteOffer.Load()
+	if len(trailer) < MessageCipherSuiteOfferSize || handshake.state != handshakeInitiationConsumed {
+		return
+	}
+	offer := trailer[:MessageCipherSuiteOfferSize]
+
+	var key [blake2s.Size]byte
+	peer.negotiationKey(&key, WGLabelCipherSuiteOffer)
+	aead, _ := chacha20poly1305.New(key[:])
+	setZero(key[:])
+	ids, err := aead.Open(nil, ZeroNonce[:], offer, packet)
+	if err != nil {
+		return
+	}
+	handshake.negotiation.offer = append([]byte(nil), offer...)
+	if ours == nil {
+		return
+	}
+	handshake.negotiation.suite = LookupCipherSuite(StandardCipherSuite)
+	for i := 0; i < len(ids); i += 4 {
+		id := binary.LittleEndian.Uint32(ids[i:])
+		if j := slices.IndexFunc(*ours, func(s *CipherSuite) bool { return cipherSuiteID(s) == id }); id != 0 && j >= 0 {
+			handshake.negotiation.suite = (*ours)[j]
+			break
+		}
+	}
+}
+
+// sealCipherSuiteSelection appends the selection of the suite of the
+// session to a response created but not yet turned into a session, if its
+// initiation was answered.
+func (peer *Peer) sealCipherSuiteSelection(packet []byte) []byte {
+	handshake := &peer.handshake
+	handshake.mutex.RLock()
+	defer handshake.mutex.RUnlock()
+	suite := handshake.negotiation.suite
+	if suite == nil || handshake.state != handshakeResponseCreated {
+		return packet
+	}
+
+	var id [4]byte
+	binary.LittleEndian.PutUint32(id[:], cipherSuiteID(suite))
+	var key [blake2s.Size]byte
+	peer.negotiationKey(&key, WGLabelCipherSuiteSelection)
+	aead, _ := chacha20poly1305.New(key[:])
+	setZero(key[:])
+	return aead.Seal(packet, ZeroNonce[:], id[:], append(packet[:MessageResponseSize:MessageResponseSize], handshake.negotiation.offer...))
+}
+
+var errCipherSuiteSelection = errors.New("selected cipher suite was not offered")
+
+// openCipherSuiteSelection reads the selection at the start of the trailer
+// of a consumed response to an offer, and returns the rest of the trailer.
+// A response without a selection leaves the pinned suite to the session;
+// one selecting a suite that was not offered fails.
+func (peer *Peer) openCipherSuiteSelection(packet, trailer []byte) ([]byte, error) {
+	handshake := &peer.handshake
+	handshake.mutex.Lock()
+	defer handshake.mutex.Unlock()
+	negotiation := &handshake.negotiation
+	if negotiation.offer == nil || len(trailer) < MessageCipherSuiteSelectionSize || handshake.state != handshakeResponseConsumed {
+		return trailer, nil
+	}
+
+	var key [blake2s.Size]byte
+	peer.negotiationKey(&key, WGLabelCipherSuiteSelection)
+	aead, _ := chacha20poly1305.New(key[:])
+	setZero(key[:])
+	selection := trailer[:MessageCipherSuiteSelectionSize]
+	id, err := aead.Open(nil, ZeroNonce[:], selection, append(packet[:MessageResponseSize:MessageResponseSize], negotiation.offer...))
+	if err != nil {
+		return trailer, nil
+	}
+	selected := binary.LittleEndian.Uint32(id)
+	if i := slices.IndexFunc(negotiation.offered, func(s *CipherSuite) bool { return cipherSuiteID(s) == selected }); i >= 0 {
+		negotiation.suite = negotiation.offered[i]
+	} else if standard := LookupCipherSuite(StandardCipherSuite); cipherSuiteID(standard) == selected {
+		negotiation.suite = standard
+	} else {
+		return nil, errCipherSuiteSelection
+	}
+	return trailer[MessageCipherSuiteSelectionSize:], nil
+}
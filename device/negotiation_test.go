@@ -0,0 +1,155 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+// negotiate runs a handshake from initiator to the responder's peer,
+// passing the sealed offer and selection through tamper, and returns the
+// suites of the sessions of both ends.
+func negotiate(t *testing.T, dev1, dev2 *Device, initiator, responder *Peer, tamper func(trailer []byte)) (*CipherSuite, *CipherSuite) {
+	t.Helper()
+	var buf [MaxMessageSize]byte
+	msg1, err := dev1.CreateMessageInitiation(initiator)
+	assertNil(t, err)
+	packet := buf[:MessageInitiationSize]
+	assertNil(t, msg1.marshal(packet))
+	packet = initiator.sealCipherSuiteOffer(packet)
+	if tamper != nil {
+		tamper(packet[MessageInitiationSize:])
+	}
+	if dev2.ConsumeMessageInitiation(msg1) != responder {
+		t.Fatal("handshake failed at initiation message")
+	}
+	responder.openCipherSuiteOffer(packet[:MessageInitiationSize], packet[MessageInitiationSize:])
+
+	msg2, err := dev2.CreateMessageResponse(responder)
+	assertNil(t, err)
+	packet = buf[:MessageResponseSize]
+	assertNil(t, msg2.marshal(packet))
+	packet = responder.sealCipherSuiteSelection(packet)
+	if tamper != nil {
+		tamper(packet[MessageResponseSize:])
+	}
+	if dev1.ConsumeMessageResponse(msg2) != initiator {
+		t.Fatal("handshake failed at response message")
+	}
+	trailer, err := initiator.openCipherSuiteSelection(packet[:MessageResponseSize], packet[MessageResponseSize:])
+	if err != nil || len(trailer) != 0 {
+		t.Fatalf("opening selection left %x: %v", trailer, err)
+	}
+
+	assertNil(t, initiator.BeginSymmetricSession())
+	assertNil(t, responder.BeginSymmetricSession())
+	return initiator.keypairs.Current().suite, responder.keypairs.next.Load().suite
+}
+
+func TestCipherSuiteNegotiation(t *testing.T) {
+	dev1 := randDevice(t)
+	dev2 := randDevice(t)
+	defer dev1.Close()
+	defer dev2.Close()
+	peer1, err := dev2.NewPeer(dev1.staticIdentity.privateKey.publicKey())
+	assertNil(t, err)
+	peer2, err := dev1.NewPeer(dev2.staticIdentity.privateKey.publicKey())
+	assertNil(t, err)
+	peer1.Start()
+	peer2.Start()
+
+	wide, standard := LookupCipherSuite(testWideCipherSuite), LookupCipherSuite(StandardCipherSuite)
+	for _, tt := range []struct {
+		name      string
+		offer     []string // of the initiator
+		answer    []string // of the responder
+		pinned    string
+		tamper    func([]byte)
+		initiator *CipherSuite // of the initiator's session
+		responder *CipherSuite // of the responder's
+	}{
+		{"first common", []string{testWideCipherSuite, testCipherSuite}, []string{testCipherSuite, testWideCipherSuite}, StandardCipherSuite, nil, wide, wide},
+		{"none common", []string{testWideCipherSuite}, []string{testCipherSuite}, testCipherSuite, nil, standard, standard},
+		{"no answer", []string{testCipherSuite}, nil, testWideCipherSuite, nil, wide, wide},
+		{"no offer", nil, []string{testCipherSuite}, testWideCipherSuite, nil, wide, wide},
+		{"tampered", []string{testCipherSuite}, []string{testCipherSuite}, StandardCipherSuite, func(b []byte) {
+			if len(b) > 0 {
+				b[0] ^= 1
+			}
+		}, standard, standard},
+	} {
+		assertNil(t, peer2.SetCipherSuiteOffer(tt.offer))
+		assertNil(t, peer1.SetCipherSuiteOffer(tt.answer))
+		assertNil(t, peer1.SetCipherSuite(tt.pinned))
+		assertNil(t, peer2.SetCipherSuite(tt.pinned))
+		initiator, responder := negotiate(t, dev1, dev2, peer2, peer1, tt.tamper)
+		if initiator != tt.initiator || responder != tt.responder {
+			t.Errorf("%s: sessions use %s and %s, want %s and %s", tt.name, initiator.Name, responder.Name, tt.initiator.Name, tt.responder.Name)
+		}
+		// Let the next initiation get a newer timestamp.
+		time.Sleep(50 * time.Millisecond)
+	}
+	// Retries alternate between initiations with and without an offer.
+	assertNil(t, peer2.SetCipherSuiteOffer([]string{testCipherSuite}))
+	for attempts, want := range []int{MessageInitiationOfferSize, MessageInitiationSize, MessageInitiationOfferSize} {
+		peer2.timers.handshakeAttempts.Store(uint32(attempts))
+		msg, err := dev1.CreateMessageInitiation(peer2)
+		assertNil(t, err)
+		packet := make([]byte, MessageInitiationSize, MaxMessageSize)
+		assertNil(t, msg.marshal(packet))
+		if n := len(peer2.sealCipherSuiteOffer(packet)); n != want {
+			t.Errorf("attempt %d is %d bytes, want %d", attempts, n, want)
+		}
+	}
+}
+
+func TestPeerCipherSuiteOffer(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	offer := []string{testWideCipherSuite, testCipherSuite}
+	for i := range pair {
+		peerKey := pair[i^1].dev.staticIdentity.publicKey
+		if err := pair[i].dev.Configure(Config{Peers: []PeerConfig{{PublicKey: peerKey, CipherSuiteOffer: &offer}}}); err != nil {
+			t.Fatal(err)
+		}
+		offer = offer[1:]
+	}
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+	for i := range pair {
+		peer := pair[i].dev.LookupPeer(pair[i^1].dev.staticIdentity.publicKey)
+		if active := peer.ActiveCipherSuite(); active != testCipherSuite {
+			t.Errorf("active suite of %d = %q, want %q", i, active, testCipherSuite)
+		}
+	}
+	status := pair[0].dev.Status()
+	if got := status.Peers[0].CipherSuiteOffer; len(got) != 2 || got[0] != testWideCipherSuite {
+		t.Errorf("status offer = %q", got)
+	}
+	cfg, err := pair[0].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line := "cipher_suite_offer=" + testWideCipherSuite + "," + testCipherSuite + "\n"; !strings.Contains(cfg, line) {
+		t.Errorf("get output lacks %q:\n%s", line, cfg)
+	}
+
+	peerKey := pair[1].dev.staticIdentity.publicKey
+	for _, value := range []string{"nope", testCipherSuite + "," + testCipherSuite, strings.Repeat(StandardCipherSuite+",", MaxCipherSuiteOffer) + testCipherSuite} {
+		if err := pair[0].dev.IpcSet("public_key=" + hex.EncodeToString(peerKey[:]) + "\ncipher_suite_offer=" + value + "\n"); err == nil {
+			t.Errorf("set cipher suite offer %q", value)
+		}
+	}
+	if err := pair[0].dev.IpcSet("public_key=" + hex.EncodeToString(peerKey[:]) + "\ncipher_suite_offer=\n"); err != nil {
+		t.Fatal(err)
+	}
+	if offer := pair[0].dev.LookupPeer(peerKey).CipherSuiteOffer(); offer != nil {
+		t.Errorf("offer after clearing = %q", offer)
+	}
+}
@@ -0,0 +1,246 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+/* Nested tunnels
+ *
+ * Layered setups run a device inside the tunnel of another in the same
+ * process, so that the datagrams of the inner device travel as inner
+ * packets of the outer one. Each one the outer device receives goes
+ * through its TUN device only for the system to deliver it to the socket of the
+ * inner device. A device given a nested address, the address it has in the
+ * tunnel of the outer device, instead registers its listening port at that
+ * address, and any device in the process hands the UDP packets it decrypts
+ * carrying transport messages to that address and port directly to it, in
+ * memory, as if they arrived on its bind from the source of the packet.
+ * Their UDP checksum is not verified: the packets were authenticated by the
+ * outer device, and the messages are authenticated again by the inner one.
+ *
+ * Handshake messages, fragments, and transport messages arriving while the
+ * inner device is slow to take them still go through the TUN device.
+ */
+
+// nestedQueueSize is the number of datagrams handed to a device that it has
+// not received yet, beyond which they go through the TUN device again.
+const nestedQueueSize = 1024
+
+var nestedReceivers struct {
+	sync.RWMutex
+	count  atomic.Int32 // len(byAddr), read without the lock
+	byAddr map[netip.AddrPort]*nestedReceiver
+}
+
+var nestedBuffers = sync.Pool{New: func() any { return new([MaxMessageSize]byte) }}
+
+type deviceNested struct {
+	addr     netip.Addr      // guarded by net
+	receiver *nestedReceiver // registered with the bind open; guarded by net
+	handoffs atomic.Uint64   // datagrams received from other devices in memory
+}
+
+type nestedReceiver struct {
+	device *Device
+	bind   conn.Bind
+	queue  chan nestedDatagram
+	closed chan struct{}
+}
+
+type nestedDatagram struct {
+	buffer   *[MaxMessageSize]byte
+	size     int
+	endpoint conn.Endpoint
+}
+
+// SetNestedAddress sets the address the device has in the tunnel of another
+// device in the process, which then hands the transport messages it
+// receives for the listening port of the device at that address to it
+// directly. The zero Addr disables it. It rebinds the device.
+func (device *Device) SetNestedAddress(addr netip.Addr) error {
+	return device.setNestedAddress(context.Background(), addr)
+}
+
+func (device *Device) setNestedAddress(ctx context.Context, addr netip.Addr) error {
+	addr = addr.Unmap()
+	device.net.Lock()
+	device.nested.addr = addr
+	device.net.Unlock()
+	if err := device.bindUpdate(ctx); err != nil {
+		return err
+	}
+	device.net.RLock()
+	defer device.net.RUnlock()
+	if addr.IsValid() && device.isUp() && device.nested.receiver == nil {
+		return fmt.Errorf("another device is registered at %v", netip.AddrPortFrom(addr, device.net.port))
+	}
+	return nil
+}
+
+// NestedAddress returns the address set with SetNestedAddress.
+func (device *Device) NestedAddress() netip.Addr {
+	device.net.RLock()
+	defer device.net.RUnlock()
+	return device.nested.addr
+}
+
+// NestedHandoffs returns the number of datagrams the device received from
+// other devices in memory.
+func (device *Device) NestedHandoffs() uint64 {
+	return device.nested.handoffs.Load()
+}
+
+// registerNestedLocked registers the open bind of the device at its nested
+// address, if any, and returns the function receiving the datagrams handed
+// to it. It must be called with device.net held.
+func (device *Device) registerNestedLocked() conn.ReceiveFunc {
+	if !device.nested.addr.IsValid() {
+		return nil
+	}
+	key := netip.AddrPortFrom(device.nested.addr, device.net.port)
+	nestedReceivers.Lock()
+	defer nestedReceivers.Unlock()
+	if nestedReceivers.byAddr[key] != nil {
+		device.log.Errorf("Failed to register nested address: another device is registered at %v", key)
+		return nil
+	}
+	if nestedReceivers.byAddr == nil {
+		nestedReceivers.byAddr = make(map[netip.AddrPort]*nestedReceiver)
+	}
+	r := &nestedReceiver{
+		device: device,
+		bind:   device.net.bind,
+		queue:  make(chan nestedDatagram, nestedQueueSize),
+		closed: make(chan struct{}),
+	}
+	nestedReceivers.byAddr[key] = r
+	nestedReceivers.count.Store(int32(len(nestedReceivers.byAddr)))
+	device.nested.receiver = r
+	return r.receive
+}
+
+// unregisterNestedLocked undoes registerNestedLocked, stopping its receive
+// function. It must be called with device.net held.
+func (device *Device) unregisterNestedLocked() {
+	r := device.nested.receiver
+	if r == nil {
+		return
+	}
+	device.nested.receiver = nil
+	nestedReceivers.Lock()
+	for key, registered := range nestedReceivers.byAddr {
+		if registered == r {
+			delete(nestedReceivers.byAddr, key)
+		}
+	}
+	nestedReceivers.count.Store(int32(len(nestedReceivers.byAddr)))
+	nestedReceivers.Unlock()
+	close(r.closed)
+}
+
+func (r *nestedReceiver) receive(bufs [][]byte, sizes []int, eps []conn.Endpoint) (n int, err error) {
+	var d nestedDatagram
+	select {
+	case d = <-r.queue:
+	case <-r.closed:
+		return 0, net.ErrClosed
+	}
+	for more := true; more; {
+		sizes[n], eps[n] = copy(bufs[n], d.buffer[:d.size]), d.endpoint
+		nestedBuffers.Put(d.buffer)
+		n++
+		more = false
+		if n < len(bufs) {
+			select {
+			case d = <-r.queue:
+				more = true
+			default:
+			}
+		}
+	}
+	r.device.nested.handoffs.Add(uint64(n))
+	return n, nil
+}
+
+// handOffNested hands packet, an IP packet the device decrypted, to the
+// device registered at its destination if it is a UDP packet carrying a
+// transport message, and reports whether it did.
+func (device *Device) handOffNested(packet []byte) bool {
+	if nestedReceivers.count.Load() == 0 {
+		return false
+	}
+	src, dst, payload, ok := parseUDP(packet)
+	if !ok || len(payload) < MessageTransportSize || binary.LittleEndian.Uint32(payload) != MessageTransportType {
+		return false
+	}
+	nestedReceivers.RLock()
+	r := nestedReceivers.byAddr[dst]
+	nestedReceivers.RUnlock()
+	if r == nil || r.device == device {
+		return false
+	}
+	ep, err := r.bind.ParseEndpoint(src.String())
+	if err != nil {
+		return false
+	}
+	buffer := nestedBuffers.Get().(*[MaxMessageSize]byte)
+	d := nestedDatagram{buffer: buffer, size: copy(buffer[:], payload), endpoint: ep}
+	select {
+	case <-r.closed:
+	case r.queue <- d:
+		return true
+	default:
+	}
+	nestedBuffers.Put(buffer)
+	return false
+}
+
+// parseUDP returns the addresses and payload of packet if it is an
+// unfragmented UDP packet whose length the IP header was checked against.
+func parseUDP(packet []byte) (src, dst netip.AddrPort, payload []byte, ok bool) {
+	var udp []byte
+	var srcAddr, dstAddr netip.Addr
+	switch packet[0] >> 4 {
+	case 4:
+		ihl := int(packet[0]&0x0f) * 4
+		fragment := binary.BigEndian.Uint16(packet[6:]) & 0x3fff // more fragments, offset
+		if packet[9] != 17 || fragment != 0 || ihl < 20 || len(packet) < ihl {
+			return
+		}
+		srcAddr = netip.AddrFrom4([4]byte(packet[IPv4offsetSrc:]))
+		dstAddr = netip.AddrFrom4([4]byte(packet[IPv4offsetDst:]))
+		udp = packet[ihl:]
+	case 6:
+		if packet[6] != 17 {
+			return
+		}
+		srcAddr = netip.AddrFrom16([16]byte(packet[IPv6offsetSrc:]))
+		dstAddr = netip.AddrFrom16([16]byte(packet[IPv6offsetDst:]))
+		udp = packet[40:]
+	default:
+		return
+	}
+	if len(udp) < 8 {
+		return
+	}
+	length := int(binary.BigEndian.Uint16(udp[4:]))
+	if length < 8 || length > len(udp) {
+		return
+	}
+	src = netip.AddrPortFrom(srcAddr, binary.BigEndian.Uint16(udp[0:]))
+	dst = netip.AddrPortFrom(dstAddr, binary.BigEndian.Uint16(udp[2:]))
+	return src, dst, udp[8:length], true
+}
@@ -0,0 +1,118 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+// divertBind passes the datagrams sent through it to out instead of sending
+// them once diverting.
+type divertBind struct {
+	conn.Bind
+	diverting *atomic.Bool
+	out       chan []byte
+}
+
+func (b divertBind) Send(bufs [][]byte, ep conn.Endpoint) error {
+	if !b.diverting.Load() {
+		return b.Bind.Send(bufs, ep)
+	}
+	for _, buf := range bufs {
+		b.out <- bytes.Clone(buf)
+	}
+	return nil
+}
+
+// udpPacket returns an IPv4 packet carrying payload from src to dst.
+func udpPacket(src, dst netip.AddrPort, payload []byte) []byte {
+	packet := make([]byte, 28+len(payload))
+	packet[0] = 0x45
+	binary.BigEndian.PutUint16(packet[2:], uint16(len(packet)))
+	packet[8] = 64
+	packet[9] = 17
+	s, d := src.Addr().As4(), dst.Addr().As4()
+	copy(packet[12:], s[:])
+	copy(packet[16:], d[:])
+	binary.BigEndian.PutUint16(packet[10:], internetChecksum(packet[:20], 0))
+	binary.BigEndian.PutUint16(packet[20:], src.Port())
+	binary.BigEndian.PutUint16(packet[22:], dst.Port())
+	binary.BigEndian.PutUint16(packet[24:], uint16(8+len(payload)))
+	copy(packet[28:], payload)
+	return packet
+}
+
+func TestNestedHandoff(t *testing.T) {
+	goroutineLeakCheck(t)
+	outer := genTestPair(t, false)
+	var diverting atomic.Bool
+	diverted := make(chan []byte, 16)
+	binds := bindtest.NewChannelBinds()
+	inner := genTestPairBinds(t, [2]conn.Bind{binds[0], divertBind{binds[1], &diverting, diverted}})
+
+	// The first inner device has the address of the first outer device in
+	// the tunnel of the outer pair.
+	if err := inner[0].dev.SetNestedAddress(outer[0].ip); err != nil {
+		t.Fatal(err)
+	}
+	inner.Send(t, Ping, nil)
+
+	// A transport message of the second inner device, sent through the
+	// outer tunnel, reaches the first without its TUN device.
+	diverting.Store(true)
+	ping := tuntest.Ping(inner[0].ip, inner[1].ip)
+	inner[1].tun.Outbound <- ping
+	var msg []byte
+	select {
+	case msg = <-diverted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("inner device sent nothing")
+	}
+	src := netip.AddrPortFrom(outer[1].ip, inner[1].dev.net.port)
+	dst := netip.AddrPortFrom(outer[0].ip, inner[0].dev.net.port)
+	outer[1].tun.Outbound <- udpPacket(src, dst, msg)
+	select {
+	case got := <-inner[0].tun.Inbound:
+		if !bytes.Equal(got, ping) {
+			t.Error("ping did not transit correctly")
+		}
+	case got := <-outer[0].tun.Inbound:
+		t.Fatalf("transport message written to the outer TUN device: %x", got)
+	case <-time.After(5 * time.Second):
+		t.Fatal("ping did not transit")
+	}
+	if n := inner[0].dev.NestedHandoffs(); n != 1 {
+		t.Errorf("%d nested handoffs, want 1", n)
+	}
+
+	// Other UDP packets go through the TUN device.
+	other := udpPacket(src, dst, []byte("not a transport message"))
+	outer[1].tun.Outbound <- other
+	select {
+	case got := <-outer[0].tun.Inbound:
+		if !bytes.Equal(got, other) {
+			t.Error("UDP packet did not transit correctly")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("UDP packet did not transit")
+	}
+
+	if err := inner[0].dev.SetNestedAddress(netip.Addr{}); err != nil {
+		t.Fatal(err)
+	}
+	if outer[0].dev.handOffNested(udpPacket(src, dst, msg)) {
+		t.Error("handed off with the nested address cleared")
+	}
+}
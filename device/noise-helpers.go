@@ -6,10 +6,9 @@
 package device
 
 import (
-	"crypto/hmac"
 	"crypto/rand"
 	"crypto/subtle"
-	"errors"
+	"fmt"
 	"hash"
 
 	"golang.org/x/crypto/blake2s"
@@ -21,45 +20,130 @@ import (
  * https://tools.ietf.org/html/rfc5869
  */
 
+// A hashState is a reusable state of a handshake hash. Data passes through
+// its own buffers, so that hashing does not make the caller's memory escape
+// to the heap.
+type hashState struct {
+	hash  hash.Hash
+	owner *handshakeHash
+	buf   [maxHandshakeHashBlockSize]byte
+	sum   [HandshakeHashSize]byte
+}
+
+func (st *hashState) put() {
+	st.hash.Reset()
+	setZero(st.buf[:])
+	setZero(st.sum[:])
+	st.owner.states.Put(st)
+}
+
+func (st *hashState) write(data []byte) {
+	for len(data) > 0 {
+		n := copy(st.buf[:], data)
+		st.hash.Write(st.buf[:n])
+		data = data[n:]
+	}
+}
+
+// writePad writes key, zero-padded to a block and XORed with pad.
+func (st *hashState) writePad(key *[maxHandshakeHashBlockSize]byte, pad byte) {
+	block := st.buf[:st.hash.BlockSize()]
+	for i := range block {
+		block[i] = key[i] ^ pad
+	}
+	st.hash.Write(block)
+}
+
+func (st *hashState) final(dst *[HandshakeHashSize]byte) {
+	st.hash.Sum(st.sum[:0])
+	*dst = st.sum
+	st.hash.Reset()
+}
+
+// sum computes the hash of in0 and in1.
+func (h *handshakeHash) sum(dst *[HandshakeHashSize]byte, in0, in1 []byte) {
+	st := h.states.Get().(*hashState)
+	st.write(in0)
+	st.write(in1)
+	st.final(dst)
+	st.put()
+}
+
+// hmac computes the HMAC of in0 and in1 with a pooled state, as crypto/hmac
+// allocates two hash states per call.
+func (h *handshakeHash) hmac(sum *[HandshakeHashSize]byte, key, in0, in1 []byte) {
+	var block [maxHandshakeHashBlockSize]byte
+	if len(key) > h.blockSize {
+		var digest [HandshakeHashSize]byte
+		h.sum(&digest, key, nil)
+		copy(block[:], digest[:])
+		setZero(digest[:])
+	} else {
+		copy(block[:], key)
+	}
+	st := h.states.Get().(*hashState)
+	st.writePad(&block, 0x36)
+	st.write(in0)
+	st.write(in1)
+	st.final(sum)
+	st.writePad(&block, 0x5c)
+	st.write(sum[:])
+	st.final(sum)
+	st.put()
+	setZero(block[:])
+}
+
+func (h *handshakeHash) kdf1(t0 *[HandshakeHashSize]byte, key, input []byte) {
+	h.hmac(t0, key, input, nil)
+	h.hmac(t0, t0[:], []byte{0x1}, nil)
+}
+
+func (h *handshakeHash) kdf2(t0, t1 *[HandshakeHashSize]byte, key, input []byte) {
+	var prk [HandshakeHashSize]byte
+	h.hmac(&prk, key, input, nil)
+	h.hmac(t0, prk[:], []byte{0x1}, nil)
+	h.hmac(t1, prk[:], t0[:], []byte{0x2})
+	setZero(prk[:])
+}
+
+func (h *handshakeHash) kdf3(t0, t1, t2 *[HandshakeHashSize]byte, key, input []byte) {
+	var prk [HandshakeHashSize]byte
+	h.hmac(&prk, key, input, nil)
+	h.hmac(t0, prk[:], []byte{0x1}, nil)
+	h.hmac(t1, prk[:], t0[:], []byte{0x2})
+	h.hmac(t2, prk[:], t1[:], []byte{0x3})
+	setZero(prk[:])
+}
+
+func (h *handshakeHash) mixKey(dst, c *[HandshakeHashSize]byte, data []byte) {
+	h.kdf1(dst, c[:], data)
+}
+
+func (h *handshakeHash) mixHash(dst, prev *[HandshakeHashSize]byte, data []byte) {
+	h.sum(dst, prev[:], data)
+}
+
+// HMAC1, HMAC2, KDF1, KDF2 and KDF3 are those of BLAKE2s, the hash of
+// standard WireGuard.
+
 func HMAC1(sum *[blake2s.Size]byte, key, in0 []byte) {
-	mac := hmac.New(func() hash.Hash {
-		h, _ := blake2s.New256(nil)
-		return h
-	}, key)
-	mac.Write(in0)
-	mac.Sum(sum[:0])
+	blake2sHandshakeHash.hmac(sum, key, in0, nil)
 }
 
 func HMAC2(sum *[blake2s.Size]byte, key, in0, in1 []byte) {
-	mac := hmac.New(func() hash.Hash {
-		h, _ := blake2s.New256(nil)
-		return h
-	}, key)
-	mac.Write(in0)
-	mac.Write(in1)
-	mac.Sum(sum[:0])
+	blake2sHandshakeHash.hmac(sum, key, in0, in1)
 }
 
 func KDF1(t0 *[blake2s.Size]byte, key, input []byte) {
-	HMAC1(t0, key, input)
-	HMAC1(t0, t0[:], []byte{0x1})
+	blake2sHandshakeHash.kdf1(t0, key, input)
 }
 
 func KDF2(t0, t1 *[blake2s.Size]byte, key, input []byte) {
-	var prk [blake2s.Size]byte
-	HMAC1(&prk, key, input)
-	HMAC1(t0, prk[:], []byte{0x1})
-	HMAC2(t1, prk[:], t0[:], []byte{0x2})
-	setZero(prk[:])
+	blake2sHandshakeHash.kdf2(t0, t1, key, input)
 }
 
 func KDF3(t0, t1, t2 *[blake2s.Size]byte, key, input []byte) {
-	var prk [blake2s.Size]byte
-	HMAC1(&prk, key, input)
-	HMAC1(t0, prk[:], []byte{0x1})
-	HMAC2(t1, prk[:], t0[:], []byte{0x2})
-	HMAC2(t2, prk[:], t1[:], []byte{0x3})
-	setZero(prk[:])
+	blake2sHandshakeHash.kdf3(t0, t1, t2, key, input)
 }
 
 func isZero(val []byte) bool {
@@ -95,7 +179,7 @@ func (sk *NoisePrivateKey) publicKey() (pk NoisePublicKey) {
 	return
 }
 
-var errInvalidPublicKey = errors.New("invalid public key")
+var errInvalidPublicKey = fmt.Errorf("%w: public key of low order", ErrInvalidKey)
 
 func (sk *NoisePrivateKey) sharedSecret(pk NoisePublicKey) (ss [NoisePublicKeySize]byte, err error) {
 	apk := (*[NoisePublicKeySize]byte)(&pk)
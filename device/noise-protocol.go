@@ -9,6 +9,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"log/slog"
 	"sync"
 	"time"
 
@@ -67,6 +68,7 @@ const (
 	MessageTransportHeaderSize = 16                                            // size of data preceding content in transport message
 	MessageTransportSize       = MessageTransportHeaderSize + poly1305.TagSize // size of empty transport
 	MessageKeepaliveSize       = MessageTransportSize                          // size of keepalive
+	MinMessageTransportSize    = MessageTransportHeaderSize + MinTagSize       // size of empty transport with the shortest tag
 	MessageHandshakeSize       = MessageInitiationSize                         // size of largest handshake related message
 )
 
@@ -118,6 +120,22 @@ type MessageCookieReply struct {
 
 var errMessageLengthMismatch = errors.New("message length mismatch")
 
+func (msg *MessageInitiation) marshal(b []byte) error {
+	if len(b) != MessageInitiationSize {
+		return errMessageLengthMismatch
+	}
+
+	binary.LittleEndian.PutUint32(b, msg.Type)
+	binary.LittleEndian.PutUint32(b[4:], msg.Sender)
+	copy(b[8:], msg.Ephemeral[:])
+	copy(b[8+len(msg.Ephemeral):], msg.Static[:])
+	copy(b[8+len(msg.Ephemeral)+len(msg.Static):], msg.Timestamp[:])
+	copy(b[8+len(msg.Ephemeral)+len(msg.Static)+len(msg.Timestamp):], msg.MAC1[:])
+	copy(b[8+len(msg.Ephemeral)+len(msg.Static)+len(msg.Timestamp)+len(msg.MAC1):], msg.MAC2[:])
+
+	return nil
+}
+
 func (msg *MessageInitiation) unmarshal(b []byte) error {
 	if len(b) != MessageInitiationSize {
 		return errMessageLengthMismatch
@@ -134,6 +152,22 @@ func (msg *MessageInitiation) unmarshal(b []byte) error {
 	return nil
 }
 
+func (msg *MessageResponse) marshal(b []byte) error {
+	if len(b) != MessageResponseSize {
+		return errMessageLengthMismatch
+	}
+
+	binary.LittleEndian.PutUint32(b, msg.Type)
+	binary.LittleEndian.PutUint32(b[4:], msg.Sender)
+	binary.LittleEndian.PutUint32(b[8:], msg.Receiver)
+	copy(b[12:], msg.Ephemeral[:])
+	copy(b[12+len(msg.Ephemeral):], msg.Empty[:])
+	copy(b[12+len(msg.Ephemeral)+len(msg.Empty):], msg.MAC1[:])
+	copy(b[12+len(msg.Ephemeral)+len(msg.Empty)+len(msg.MAC1):], msg.MAC2[:])
+
+	return nil
+}
+
 func (msg *MessageResponse) unmarshal(b []byte) error {
 	if len(b) != MessageResponseSize {
 		return errMessageLengthMismatch
@@ -169,13 +203,20 @@ type Handshake struct {
 	hash                      [blake2s.Size]byte       // hash value
 	chainKey                  [blake2s.Size]byte       // chain key
 	presharedKey              NoisePresharedKey        // psk
+	pskRotator                PSKRotator               // derives the psk of handshakes instead, if its root is set
+	psk                       NoisePresharedKey        // psk of the handshake in progress
 	localEphemeral            NoisePrivateKey          // ephemeral secret key
 	localIndex                uint32                   // used to clear hash-table
 	remoteIndex               uint32                   // index for sending
 	remoteStatic              NoisePublicKey           // long term key
 	remoteEphemeral           NoisePublicKey           // ephemeral public key
 	precomputedStaticStatic   [NoisePublicKeySize]byte // precomputed shared secret
+	channelBinding            [blake2s.Size]byte       // out-of-band transcript binding (zero if unused)
+	hasher                    *handshakeHash           // hash the handshake in progress started with
+	negotiation               cipherSuiteNegotiation   // of the suite of the session being established
+	hybrid                    pqHybridHandshake        // ML-KEM exchange of the session being established
 	lastTimestamp             tai64n.Timestamp
+	lastStaleTimestamp        tai64n.Timestamp // of the last initiation accepted older than lastTimestamp
 	lastInitiationConsumption time.Time
 	lastSentHandshake         time.Time
 }
@@ -191,11 +232,7 @@ func mixKey(dst, c *[blake2s.Size]byte, data []byte) {
 }
 
 func mixHash(dst, h *[blake2s.Size]byte, data []byte) {
-	hash, _ := blake2s.New256(nil)
-	hash.Write(h[:])
-	hash.Write(data)
-	hash.Sum(dst[:0])
-	hash.Reset()
+	blake2sHandshakeHash.mixHash(dst, h, data)
 }
 
 func (h *Handshake) Clear() {
@@ -203,16 +240,29 @@ func (h *Handshake) Clear() {
 	setZero(h.remoteEphemeral[:])
 	setZero(h.chainKey[:])
 	setZero(h.hash[:])
+	setZero(h.psk[:])
 	h.localIndex = 0
+	h.negotiation = cipherSuiteNegotiation{}
+	h.hybrid = pqHybridHandshake{}
 	h.state = handshakeZeroed
 }
 
 func (h *Handshake) mixHash(data []byte) {
-	mixHash(&h.hash, &h.hash, data)
+	h.handshakeHash().mixHash(&h.hash, &h.hash, data)
 }
 
 func (h *Handshake) mixKey(data []byte) {
-	mixKey(&h.chainKey, &h.chainKey, data)
+	h.handshakeHash().mixKey(&h.chainKey, &h.chainKey, data)
+}
+
+// mixChannelBinding mixes the configured channel binding, if any, into the
+// transcript once the initiator's static key is known to both sides, so that
+// the encrypted timestamp and everything after it only authenticate when both
+// peers were configured with the same binding value.
+func (h *Handshake) mixChannelBinding() {
+	if !isZero(h.channelBinding[:]) {
+		h.mixHash(h.channelBinding[:])
+	}
 }
 
 /* Do basic precomputations
@@ -232,8 +282,9 @@ func (device *Device) CreateMessageInitiation(peer *Peer) (*MessageInitiation, e
 
 	// create ephemeral key
 	var err error
-	handshake.hash = InitialHash
-	handshake.chainKey = InitialChainKey
+	handshake.hash = device.staticIdentity.initialHash
+	handshake.chainKey = device.staticIdentity.initialChainKey
+	handshake.hasher = device.handshakeHash()
 	handshake.localEphemeral, err = newPrivateKey()
 	if err != nil {
 		return nil, err
@@ -241,10 +292,13 @@ func (device *Device) CreateMessageInitiation(peer *Peer) (*MessageInitiation, e
 
 	handshake.mixHash(handshake.remoteStatic[:])
 
-	msg := MessageInitiation{
-		Type:      MessageInitiationType,
-		Ephemeral: handshake.localEphemeral.publicKey(),
+	msgType, err := peer.hybridInitiationType()
+	if err != nil {
+		return nil, err
 	}
+	msg := device.GetMessageInitiation()
+	msg.Type = msgType
+	msg.Ephemeral = handshake.localEphemeral.publicKey()
 
 	handshake.mixKey(msg.Ephemeral[:])
 	handshake.mixHash(msg.Ephemeral[:])
@@ -252,10 +306,11 @@ func (device *Device) CreateMessageInitiation(peer *Peer) (*MessageInitiation, e
 	// encrypt static key
 	ss, err := handshake.localEphemeral.sharedSecret(handshake.remoteStatic)
 	if err != nil {
+		device.PutMessageInitiation(msg)
 		return nil, err
 	}
 	var key [chacha20poly1305.KeySize]byte
-	KDF2(
+	handshake.hasher.kdf2(
 		&handshake.chainKey,
 		&key,
 		handshake.chainKey[:],
@@ -264,18 +319,21 @@ func (device *Device) CreateMessageInitiation(peer *Peer) (*MessageInitiation, e
 	aead, _ := chacha20poly1305.New(key[:])
 	aead.Seal(msg.Static[:0], ZeroNonce[:], device.staticIdentity.publicKey[:], handshake.hash[:])
 	handshake.mixHash(msg.Static[:])
+	handshake.mixChannelBinding()
 
 	// encrypt timestamp
 	if isZero(handshake.precomputedStaticStatic[:]) {
+		device.PutMessageInitiation(msg)
 		return nil, errInvalidPublicKey
 	}
-	KDF2(
+	handshake.hasher.kdf2(
 		&handshake.chainKey,
 		&key,
 		handshake.chainKey[:],
 		handshake.precomputedStaticStatic[:],
 	)
 	timestamp := tai64n.Now()
+	handshake.setPSK(timestamp.Time())
 	aead, _ = chacha20poly1305.New(key[:])
 	aead.Seal(msg.Timestamp[:0], ZeroNonce[:], timestamp[:], handshake.hash[:])
 
@@ -283,52 +341,65 @@ func (device *Device) CreateMessageInitiation(peer *Peer) (*MessageInitiation, e
 	device.indexTable.Delete(handshake.localIndex)
 	msg.Sender, err = device.indexTable.NewIndexForHandshake(peer, handshake)
 	if err != nil {
+		device.PutMessageInitiation(msg)
 		return nil, err
 	}
 	handshake.localIndex = msg.Sender
 
 	handshake.mixHash(msg.Timestamp[:])
 	handshake.state = handshakeInitiationCreated
-	return &msg, nil
+	return msg, nil
 }
 
 func (device *Device) ConsumeMessageInitiation(msg *MessageInitiation) *Peer {
+	peer, _, _ := device.consumeMessageInitiation(msg, false)
+	return peer
+}
+
+// consumeMessageInitiation is ConsumeMessageInitiation for an initiation
+// whose MAC2 is valid if fresh. If the initiation is rejected for want of a
+// cookie round trip only, as allowed for untrusted timestamps by the
+// TimestampPolicy, it also reports that a cookie reply should be sent. If
+// it is accepted with an untrusted timestamp, it reports that too, as the
+// initiation may then be a replay.
+func (device *Device) consumeMessageInitiation(msg *MessageInitiation, fresh bool) (peer *Peer, wantCookie, untrusted bool) {
 	var (
 		hash     [blake2s.Size]byte
 		chainKey [blake2s.Size]byte
 	)
 
-	if msg.Type != MessageInitiationType {
-		return nil
+	if msg.Type != MessageInitiationType && msg.Type != MessageInitiationHybridType {
+		return nil, false, false
 	}
 
 	device.staticIdentity.RLock()
 	defer device.staticIdentity.RUnlock()
+	hasher := device.handshakeHash()
 
-	mixHash(&hash, &InitialHash, device.staticIdentity.publicKey[:])
-	mixHash(&hash, &hash, msg.Ephemeral[:])
-	mixKey(&chainKey, &InitialChainKey, msg.Ephemeral[:])
+	hasher.mixHash(&hash, &device.staticIdentity.initialHash, device.staticIdentity.publicKey[:])
+	hasher.mixHash(&hash, &hash, msg.Ephemeral[:])
+	hasher.mixKey(&chainKey, &device.staticIdentity.initialChainKey, msg.Ephemeral[:])
 
 	// decrypt static key
 	var peerPK NoisePublicKey
 	var key [chacha20poly1305.KeySize]byte
 	ss, err := device.staticIdentity.privateKey.sharedSecret(msg.Ephemeral)
 	if err != nil {
-		return nil
+		return nil, false, false
 	}
-	KDF2(&chainKey, &key, chainKey[:], ss[:])
+	hasher.kdf2(&chainKey, &key, chainKey[:], ss[:])
 	aead, _ := chacha20poly1305.New(key[:])
 	_, err = aead.Open(peerPK[:0], ZeroNonce[:], msg.Static[:], hash[:])
 	if err != nil {
-		return nil
+		return nil, false, false
 	}
-	mixHash(&hash, &hash, msg.Static[:])
+	hasher.mixHash(&hash, &hash, msg.Static[:])
 
 	// lookup peer
 
-	peer := device.LookupPeer(peerPK)
+	peer = device.LookupPeer(peerPK)
 	if peer == nil || !peer.isRunning.Load() {
-		return nil
+		return nil, false, false
 	}
 
 	handshake := &peer.handshake
@@ -341,9 +412,12 @@ func (device *Device) ConsumeMessageInitiation(msg *MessageInitiation) *Peer {
 
 	if isZero(handshake.precomputedStaticStatic[:]) {
 		handshake.mutex.RUnlock()
-		return nil
+		return nil, false, false
+	}
+	if !isZero(handshake.channelBinding[:]) {
+		hasher.mixHash(&hash, &hash, handshake.channelBinding[:])
 	}
-	KDF2(
+	hasher.kdf2(
 		&chainKey,
 		&key,
 		chainKey[:],
@@ -353,34 +427,61 @@ func (device *Device) ConsumeMessageInitiation(msg *MessageInitiation) *Peer {
 	_, err = aead.Open(timestamp[:0], ZeroNonce[:], msg.Timestamp[:], hash[:])
 	if err != nil {
 		handshake.mutex.RUnlock()
-		return nil
+		return nil, false, false
 	}
-	mixHash(&hash, &hash, msg.Timestamp[:])
+	hasher.mixHash(&hash, &hash, msg.Timestamp[:])
 
 	// protect against replay & flood
 
-	replay := !timestamp.After(handshake.lastTimestamp)
+	distrust := device.distrustTimestamp(timestamp, handshake.lastTimestamp)
 	flood := time.Since(handshake.lastInitiationConsumption) <= HandshakeInitationRate
+	var skew error
+	if handshake.rotating() {
+		skew = handshake.pskRotator.checkSkew(timestamp.Time(), time.Now())
+	}
 	handshake.mutex.RUnlock()
-	if replay {
-		device.log.Verbosef("%v - ConsumeMessageInitiation: handshake replay @ %v", peer, timestamp)
-		return nil
+	if skew != nil {
+		device.log.handshake.verboseAttrs([]slog.Attr{peerAttr(peer), errorAttr(skew)}, "%v - ConsumeMessageInitiation: %v", peer, skew)
+		return nil, false, false
+	}
+	if distrust != "" {
+		if !fresh {
+			device.log.handshake.verboseAttrs([]slog.Attr{peerAttr(peer)}, "%v - ConsumeMessageInitiation: untrusted timestamp %v (%s)", peer, timestamp, distrust)
+			return nil, device.TimestampPolicy().AcceptStale, false
+		}
 	}
 	if flood {
-		device.log.Verbosef("%v - ConsumeMessageInitiation: handshake flood", peer)
-		return nil
+		device.log.handshake.verboseAttrs([]slog.Attr{peerAttr(peer)}, "%v - ConsumeMessageInitiation: handshake flood", peer)
+		return nil, false, false
+	}
+	if !peer.admitMetadata() {
+		return nil, false, false
 	}
 
 	// update handshake state
 
 	handshake.mutex.Lock()
 
+	// A MAC2 does not make a replay fresh, so untrusted timestamps are
+	// still refused if they repeat the last trusted one or are no newer than
+	// the last untrusted one accepted.
+	if distrust != "" && (timestamp == handshake.lastTimestamp || !timestamp.After(handshake.lastStaleTimestamp)) {
+		handshake.mutex.Unlock()
+		device.log.handshake.verboseAttrs([]slog.Attr{peerAttr(peer)}, "%v - ConsumeMessageInitiation: replayed timestamp %v", peer, timestamp)
+		return nil, false, false
+	}
+
 	handshake.hash = hash
 	handshake.chainKey = chainKey
+	handshake.hasher = hasher
 	handshake.remoteIndex = msg.Sender
 	handshake.remoteEphemeral = msg.Ephemeral
-	if timestamp.After(handshake.lastTimestamp) {
+	handshake.setPSK(timestamp.Time())
+	advanced := timestamp.After(handshake.lastTimestamp)
+	if advanced {
 		handshake.lastTimestamp = timestamp
+	} else {
+		handshake.lastStaleTimestamp = timestamp
 	}
 	now := time.Now()
 	if now.After(handshake.lastInitiationConsumption) {
@@ -390,10 +491,17 @@ func (device *Device) ConsumeMessageInitiation(msg *MessageInitiation) *Peer {
 
 	handshake.mutex.Unlock()
 
+	if distrust != "" {
+		device.log.handshake.verboseAttrs([]slog.Attr{peerAttr(peer)}, "%v - ConsumeMessageInitiation: accepting untrusted timestamp %v (%s) after cookie round trip", peer, timestamp, distrust)
+	}
+	if advanced {
+		device.storeHandshakeTimestamp(peer, timestamp)
+	}
+
 	setZero(hash[:])
 	setZero(chainKey[:])
 
-	return peer
+	return peer, false, distrust != ""
 }
 
 func (device *Device) CreateMessageResponse(peer *Peer) (*MessageResponse, error) {
@@ -414,8 +522,8 @@ func (device *Device) CreateMessageResponse(peer *Peer) (*MessageResponse, error
 		return nil, err
 	}
 
-	var msg MessageResponse
-	msg.Type = MessageResponseType
+	msg := device.GetMessageResponse()
+	msg.Type = handshake.responseType()
 	msg.Sender = handshake.localIndex
 	msg.Receiver = handshake.remoteIndex
 
@@ -423,6 +531,7 @@ func (device *Device) CreateMessageResponse(peer *Peer) (*MessageResponse, error
 
 	handshake.localEphemeral, err = newPrivateKey()
 	if err != nil {
+		device.PutMessageResponse(msg)
 		return nil, err
 	}
 	msg.Ephemeral = handshake.localEphemeral.publicKey()
@@ -431,11 +540,13 @@ func (device *Device) CreateMessageResponse(peer *Peer) (*MessageResponse, error
 
 	ss, err := handshake.localEphemeral.sharedSecret(handshake.remoteEphemeral)
 	if err != nil {
+		device.PutMessageResponse(msg)
 		return nil, err
 	}
 	handshake.mixKey(ss[:])
 	ss, err = handshake.localEphemeral.sharedSecret(handshake.remoteStatic)
 	if err != nil {
+		device.PutMessageResponse(msg)
 		return nil, err
 	}
 	handshake.mixKey(ss[:])
@@ -445,12 +556,12 @@ func (device *Device) CreateMessageResponse(peer *Peer) (*MessageResponse, error
 	var tau [blake2s.Size]byte
 	var key [chacha20poly1305.KeySize]byte
 
-	KDF3(
+	handshake.handshakeHash().kdf3(
 		&handshake.chainKey,
 		&tau,
 		&key,
 		handshake.chainKey[:],
-		handshake.presharedKey[:],
+		handshake.psk[:],
 	)
 
 	handshake.mixHash(tau[:])
@@ -461,11 +572,11 @@ func (device *Device) CreateMessageResponse(peer *Peer) (*MessageResponse, error
 
 	handshake.state = handshakeResponseCreated
 
-	return &msg, nil
+	return msg, nil
 }
 
 func (device *Device) ConsumeMessageResponse(msg *MessageResponse) *Peer {
-	if msg.Type != MessageResponseType {
+	if msg.Type != MessageResponseType && msg.Type != MessageResponseHybridType {
 		return nil
 	}
 
@@ -499,35 +610,36 @@ func (device *Device) ConsumeMessageResponse(msg *MessageResponse) *Peer {
 
 		// finish 3-way DH
 
-		mixHash(&hash, &handshake.hash, msg.Ephemeral[:])
-		mixKey(&chainKey, &handshake.chainKey, msg.Ephemeral[:])
+		hasher := handshake.handshakeHash()
+		hasher.mixHash(&hash, &handshake.hash, msg.Ephemeral[:])
+		hasher.mixKey(&chainKey, &handshake.chainKey, msg.Ephemeral[:])
 
 		ss, err := handshake.localEphemeral.sharedSecret(msg.Ephemeral)
 		if err != nil {
 			return false
 		}
-		mixKey(&chainKey, &chainKey, ss[:])
+		hasher.mixKey(&chainKey, &chainKey, ss[:])
 		setZero(ss[:])
 
 		ss, err = device.staticIdentity.privateKey.sharedSecret(msg.Ephemeral)
 		if err != nil {
 			return false
 		}
-		mixKey(&chainKey, &chainKey, ss[:])
+		hasher.mixKey(&chainKey, &chainKey, ss[:])
 		setZero(ss[:])
 
 		// add preshared key (psk)
 
 		var tau [blake2s.Size]byte
 		var key [chacha20poly1305.KeySize]byte
-		KDF3(
+		hasher.kdf3(
 			&chainKey,
 			&tau,
 			&key,
 			chainKey[:],
-			handshake.presharedKey[:],
+			handshake.psk[:],
 		)
-		mixHash(&hash, &hash, tau[:])
+		hasher.mixHash(&hash, &hash, tau[:])
 
 		// authenticate transcript
 
@@ -536,11 +648,11 @@ func (device *Device) ConsumeMessageResponse(msg *MessageResponse) *Peer {
 		if err != nil {
 			return false
 		}
-		mixHash(&hash, &hash, msg.Empty[:])
+		hasher.mixHash(&hash, &hash, msg.Empty[:])
 		return true
 	}()
 
-	if !ok {
+	if !ok || !lookup.peer.admitMetadata() {
 		return nil
 	}
 
@@ -577,7 +689,7 @@ func (peer *Peer) BeginSymmetricSession() error {
 	var recvKey [chacha20poly1305.KeySize]byte
 
 	if handshake.state == handshakeResponseConsumed {
-		KDF2(
+		handshake.handshakeHash().kdf2(
 			&sendKey,
 			&recvKey,
 			handshake.chainKey[:],
@@ -585,7 +697,7 @@ func (peer *Peer) BeginSymmetricSession() error {
 		)
 		isInitiator = true
 	} else if handshake.state == handshakeResponseCreated {
-		KDF2(
+		handshake.handshakeHash().kdf2(
 			&recvKey,
 			&sendKey,
 			handshake.chainKey[:],
@@ -601,17 +713,38 @@ func (peer *Peer) BeginSymmetricSession() error {
 	setZero(handshake.chainKey[:])
 	setZero(handshake.hash[:]) // Doesn't necessarily need to be zeroed. Could be used for something interesting down the line.
 	setZero(handshake.localEphemeral[:])
+	setZero(handshake.psk[:])
 	peer.handshake.state = handshakeZeroed
 
 	// create AEAD instances
 
 	keypair := new(Keypair)
-	keypair.send, _ = chacha20poly1305.New(sendKey[:])
-	keypair.receive, _ = chacha20poly1305.New(recvKey[:])
+	keypair.suite = handshake.negotiation.suite
+	if keypair.suite == nil {
+		keypair.suite = peer.cipherSuite()
+	}
+	handshake.negotiation = cipherSuiteNegotiation{}
+	keypair.hybrid = handshake.hybrid.mixed
+	handshake.hybrid = pqHybridHandshake{}
+	keypair.lease = device.replication.newLease(&sendKey, &recvKey)
+	var sendErr, recvErr error
+	keypair.send, sendErr = device.newSessionAEAD(keypair.suite, sendKey[:])
+	keypair.receive, recvErr = device.newSessionAEAD(keypair.suite, recvKey[:])
+	if sendErr == nil && recvErr == nil {
+		device.recordTranscriptSession(peer, keypair.suite, handshake.localIndex, handshake.remoteIndex, isInitiator, &sendKey, &recvKey)
+		device.startKeystream(keypair, &sendKey)
+	}
 
 	setZero(sendKey[:])
 	setZero(recvKey[:])
 
+	if err := errors.Join(sendErr, recvErr); err != nil {
+		return fmt.Errorf("failed to create %s keypair: %w", keypair.suite.Name, err)
+	}
+	if peer.eagerKeyErasure.Load() {
+		keypair.makeErasable()
+	}
+
 	keypair.created = time.Now()
 	keypair.replayFilter.Reset()
 	keypair.isInitiator = isInitiator
@@ -642,7 +775,9 @@ func (peer *Peer) BeginSymmetricSession() error {
 			keypairs.previous = current
 		}
 		device.DeleteKeypair(previous)
+		keypairs.previous.stopKeystream()
 		keypairs.current = keypair
+		peer.emitKeypairRotatedLocked(current)
 	} else {
 		keypairs.next.Store(keypair)
 		device.DeleteKeypair(next)
@@ -667,7 +802,9 @@ func (peer *Peer) ReceivedWithKeypair(receivedKeypair *Keypair) bool {
 	old := keypairs.previous
 	keypairs.previous = keypairs.current
 	peer.device.DeleteKeypair(old)
+	keypairs.previous.stopKeystream()
 	keypairs.current = keypairs.next.Load()
 	keypairs.next.Store(nil)
+	peer.emitKeypairRotatedLocked(keypairs.previous)
 	return true
 }
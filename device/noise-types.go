@@ -8,7 +8,7 @@ package device
 import (
 	"crypto/subtle"
 	"encoding/hex"
-	"errors"
+	"fmt"
 )
 
 const (
@@ -27,10 +27,10 @@ type (
 func loadExactHex(dst []byte, src string) error {
 	slice, err := hex.DecodeString(src)
 	if err != nil {
-		return err
+		return fmt.Errorf("%w: %w", ErrInvalidKey, err)
 	}
 	if len(slice) != len(dst) {
-		return errors.New("hex string does not fit the slice")
+		return fmt.Errorf("%w: hex string does not fit the slice", ErrInvalidKey)
 	}
 	copy(dst, slice)
 	return nil
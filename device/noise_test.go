@@ -7,10 +7,15 @@ package device
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/binary"
 	"testing"
+	"time"
+	"unsafe"
 
+	"golang.org/x/crypto/chacha20poly1305"
 	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/tai64n"
 	"golang.zx2c4.com/wireguard/tun/tuntest"
 )
 
@@ -32,7 +37,7 @@ func TestCurveWrappers(t *testing.T) {
 	}
 }
 
-func randDevice(t *testing.T) *Device {
+func randDevice(t testing.TB) *Device {
 	sk, err := newPrivateKey()
 	if err != nil {
 		t.Fatal(err)
@@ -177,3 +182,314 @@ func TestNoiseHandshake(t *testing.T) {
 		assertEqual(t, out, testMsg)
 	}()
 }
+
+func TestNoiseHandshakeChannelBinding(t *testing.T) {
+	dev1 := randDevice(t)
+	dev2 := randDevice(t)
+
+	defer dev1.Close()
+	defer dev2.Close()
+
+	peer1, err := dev2.NewPeer(dev1.staticIdentity.privateKey.publicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer2, err := dev1.NewPeer(dev2.staticIdentity.privateKey.publicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer1.Start()
+	peer2.Start()
+
+	binding := [32]byte{1, 2, 3, 4}
+	peer1.SetChannelBinding(binding)
+
+	// Only the responder is configured with a binding.
+	msg1, err := dev1.CreateMessageInitiation(peer2)
+	assertNil(t, err)
+	if dev2.ConsumeMessageInitiation(msg1) != nil {
+		t.Fatal("initiation without channel binding was accepted")
+	}
+
+	// Both sides use different bindings.
+	peer2.SetChannelBinding([32]byte{4, 3, 2, 1})
+	msg1, err = dev1.CreateMessageInitiation(peer2)
+	assertNil(t, err)
+	if dev2.ConsumeMessageInitiation(msg1) != nil {
+		t.Fatal("initiation with mismatched channel binding was accepted")
+	}
+
+	// Both sides agree.
+	peer2.SetChannelBinding(binding)
+	msg1, err = dev1.CreateMessageInitiation(peer2)
+	assertNil(t, err)
+	if dev2.ConsumeMessageInitiation(msg1) == nil {
+		t.Fatal("initiation with matching channel binding was rejected")
+	}
+	msg2, err := dev2.CreateMessageResponse(peer1)
+	assertNil(t, err)
+	if dev1.ConsumeMessageResponse(msg2) == nil {
+		t.Fatal("response with matching channel binding was rejected")
+	}
+	assertEqual(t, peer1.handshake.chainKey[:], peer2.handshake.chainKey[:])
+}
+
+func TestNoiseHandshakeProtocolIdentifier(t *testing.T) {
+	dev1 := randDevice(t)
+	dev2 := randDevice(t)
+
+	defer dev1.Close()
+	defer dev2.Close()
+
+	peer1, err := dev2.NewPeer(dev1.staticIdentity.privateKey.publicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer2, err := dev1.NewPeer(dev2.staticIdentity.privateKey.publicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer1.Start()
+	peer2.Start()
+
+	dev1.SetProtocolIdentifier("", "private network v1")
+	msg1, err := dev1.CreateMessageInitiation(peer2)
+	assertNil(t, err)
+	if dev2.ConsumeMessageInitiation(msg1) != nil {
+		t.Fatal("initiation with non-standard identifier accepted by standard device")
+	}
+
+	dev2.SetProtocolIdentifier("", "private network v1")
+	msg1, err = dev1.CreateMessageInitiation(peer2)
+	assertNil(t, err)
+	if dev2.ConsumeMessageInitiation(msg1) == nil {
+		t.Fatal("initiation with matching identifier was rejected")
+	}
+
+	uapi, err := dev2.IpcGet()
+	assertNil(t, err)
+	if !bytes.Contains([]byte(uapi), []byte("noise_identifier=private network v1\n")) {
+		t.Errorf("UAPI get does not report the non-standard identifier:\n%s", uapi)
+	}
+
+	dev2.SetProtocolIdentifier("", "")
+	if dev2.staticIdentity.initialHash != InitialHash || dev2.staticIdentity.initialChainKey != InitialChainKey {
+		t.Error("resetting the identifier did not restore the standard initial state")
+	}
+}
+
+func TestEagerKeyErasure(t *testing.T) {
+	dev := randDevice(t)
+	defer dev.Close()
+
+	sk, err := newPrivateKey()
+	assertNil(t, err)
+	peer, err := dev.NewPeer(sk.publicKey())
+	assertNil(t, err)
+
+	previous, current := &Keypair{localIndex: 1}, &Keypair{localIndex: 2}
+	dev.indexTable.table[previous.localIndex] = IndexTableEntry{peer: peer, keypair: previous}
+	peer.keypairs.previous, peer.keypairs.current = previous, current
+
+	peer.erasePreviousKeypair(previous)
+	if peer.keypairs.previous != previous {
+		t.Fatal("previous keypair erased by a packet on the previous keypair")
+	}
+	peer.erasePreviousKeypair(current)
+	if peer.keypairs.previous != nil {
+		t.Fatal("previous keypair not erased by a packet on the current keypair")
+	}
+	if _, ok := dev.indexTable.table[previous.localIndex]; ok {
+		t.Fatal("previous keypair still present in index table")
+	}
+
+	// The keys of an erasable keypair are zeroed, and it seals nothing
+	// under the zeroed key.
+	suites := []*CipherSuite{LookupCipherSuite(StandardCipherSuite)}
+	if suite := LookupCipherSuite("ChaCha20DoublePoly1305"); suite != nil {
+		suites = append(suites, suite)
+	}
+	for _, suite := range suites {
+		previous = &Keypair{suite: suite, localIndex: 3}
+		var keys [][]byte
+		for _, aead := range []*AEADSuite{&previous.send, &previous.receive} {
+			key := make([]byte, chacha20poly1305.KeySize)
+			rand.Read(key)
+			*aead, err = suite.New(key)
+			assertNil(t, err)
+			keys = append(keys, aeadKeyBytes(*aead))
+			if !bytes.Equal(keys[len(keys)-1], key) {
+				t.Fatalf("%s: key bytes %x, want %x", suite.Name, keys[len(keys)-1], key)
+			}
+		}
+		previous.makeErasable()
+		peer.keypairs.previous = previous
+		peer.erasePreviousKeypair(current)
+		for _, key := range keys {
+			if !isZero(key) {
+				t.Errorf("%s: key %x not zeroed", suite.Name, key)
+			}
+		}
+		nonce := make([]byte, previous.send.NonceSize())
+		if sealed := previous.send.Seal(nil, nonce, []byte("secret"), nil); len(sealed) != 6+suite.Overhead() || !isZero(sealed) {
+			t.Errorf("%s: sealed %x after erasure", suite.Name, sealed)
+		}
+		if _, err := previous.receive.Open(nil, nonce, make([]byte, suite.Overhead()), nil); err == nil {
+			t.Errorf("%s: opened a message after erasure", suite.Name)
+		}
+	}
+
+	// The keys of AES-256-GCM cannot be found, so it is only discarded.
+	aead, err := LookupCipherSuite(AES256GCMCipherSuite).New(make([]byte, chacha20poly1305.KeySize))
+	assertNil(t, err)
+	if erasable := newErasableAEAD(aead); erasable != aead {
+		t.Error("AES-256-GCM made erasable")
+	}
+}
+
+func TestExperimentalAuditTrail(t *testing.T) {
+	dev := randDevice(t)
+	defer dev.Close()
+
+	uapi, err := dev.IpcGet()
+	assertNil(t, err)
+	if bytes.Contains([]byte(uapi), []byte("experimental=")) || bytes.Contains([]byte(uapi), []byte("audit=")) {
+		t.Fatalf("standard device reports experimental state:\n%s", uapi)
+	}
+
+	assertNil(t, dev.IpcSet("noise_identifier=research build\n"))
+	uapi, err = dev.IpcGet()
+	assertNil(t, err)
+	if !bytes.HasPrefix([]byte(uapi), []byte("experimental=true\n")) {
+		t.Errorf("experimental device is not flagged:\n%s", uapi)
+	}
+	if !dev.Status().Experimental {
+		t.Error("experimental device is not flagged in status")
+	}
+
+	assertNil(t, dev.IpcSet("noise_identifier=\n"))
+	entries, dropped := dev.AuditTrail()
+	if len(entries) != 2 || dropped != 0 {
+		t.Fatalf("audit trail has %d entries, %d dropped; want 2, 0", len(entries), dropped)
+	}
+	if e := entries[0]; e.Caller != "api" || e.Setting != "noise_identifier" || e.Value != "research build" {
+		t.Errorf("unexpected audit entry: %+v", e)
+	}
+	uapi, err = dev.IpcGet()
+	assertNil(t, err)
+	if bytes.Contains([]byte(uapi), []byte("experimental=")) {
+		t.Errorf("device reset to standard settings is still flagged:\n%s", uapi)
+	}
+	if !bytes.HasPrefix([]byte(uapi), []byte("audit=")) || bytes.Count([]byte(uapi), []byte("audit=")) != 2 {
+		t.Errorf("audit trail not reported by get:\n%s", uapi)
+	}
+}
+
+func TestMessageMarshal(t *testing.T) {
+	dev := randDevice(t)
+	defer dev.Close()
+
+	var initiation MessageInitiation
+	var response MessageResponse
+	for _, b := range [][]byte{
+		(*[MessageInitiationSize]byte)(unsafe.Pointer(&initiation))[:],
+		(*[MessageResponseSize]byte)(unsafe.Pointer(&response))[:],
+	} {
+		for i := range b {
+			b[i] = byte(i)
+		}
+	}
+
+	var buf [MessageInitiationSize]byte
+	assertNil(t, initiation.marshal(buf[:]))
+	msg := dev.GetMessageInitiation()
+	assertNil(t, msg.unmarshal(buf[:]))
+	if *msg != initiation {
+		t.Errorf("initiation changed by marshalling: %+v", msg)
+	}
+	dev.PutMessageInitiation(msg)
+	if msg := dev.GetMessageInitiation(); *msg != (MessageInitiation{}) {
+		t.Error("pooled initiation not cleared")
+	}
+
+	assertNil(t, response.marshal(buf[:MessageResponseSize]))
+	msg2 := dev.GetMessageResponse()
+	assertNil(t, msg2.unmarshal(buf[:MessageResponseSize]))
+	if *msg2 != response {
+		t.Errorf("response changed by marshalling: %+v", msg2)
+	}
+	if err := response.marshal(buf[:]); err == nil {
+		t.Error("response marshalled into a buffer of the wrong size")
+	}
+}
+
+// BenchmarkHandshakeFlood measures a responder consuming initiations and
+// answering them, as when flooded with handshake attempts.
+func BenchmarkHandshakeFlood(b *testing.B) {
+	dev1 := randDevice(b)
+	dev2 := randDevice(b)
+	defer dev1.Close()
+	defer dev2.Close()
+
+	peer1, err := dev2.NewPeer(dev1.staticIdentity.privateKey.publicKey())
+	if err != nil {
+		b.Fatal(err)
+	}
+	peer2, err := dev1.NewPeer(dev2.staticIdentity.privateKey.publicKey())
+	if err != nil {
+		b.Fatal(err)
+	}
+	peer1.Start()
+	peer2.Start()
+
+	initiation, err := dev1.CreateMessageInitiation(peer2)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var packet [MessageInitiationSize]byte
+	initiation.marshal(packet[:])
+	dev1.PutMessageInitiation(initiation)
+
+	b.Run("valid", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			msg := dev2.GetMessageInitiation()
+			if err := msg.unmarshal(packet[:]); err != nil {
+				b.Fatal(err)
+			}
+			if dev2.ConsumeMessageInitiation(msg) == nil {
+				b.Fatal("initiation rejected")
+			}
+			dev2.PutMessageInitiation(msg)
+			response, err := dev2.CreateMessageResponse(peer1)
+			if err != nil {
+				b.Fatal(err)
+			}
+			var buf [MessageResponseSize]byte
+			response.marshal(buf[:])
+			dev2.PutMessageResponse(response)
+
+			// Let the same initiation be accepted again.
+			peer1.handshake.mutex.Lock()
+			peer1.handshake.lastTimestamp = tai64n.Timestamp{}
+			peer1.handshake.lastInitiationConsumption = time.Time{}
+			peer1.handshake.mutex.Unlock()
+		}
+	})
+
+	b.Run("invalid", func(b *testing.B) {
+		invalid := packet
+		invalid[8+NoisePublicKeySize] ^= 1 // corrupt the encrypted static key
+		b.ReportAllocs()
+		for range b.N {
+			msg := dev2.GetMessageInitiation()
+			if err := msg.unmarshal(invalid[:]); err != nil {
+				b.Fatal(err)
+			}
+			if dev2.ConsumeMessageInitiation(msg) != nil {
+				b.Fatal("invalid initiation accepted")
+			}
+			dev2.PutMessageInitiation(msg)
+		}
+	})
+}
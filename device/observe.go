@@ -0,0 +1,127 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
+
+/* Observers are read-only consumers of device state, attached through the
+ * UAPI "observe=1" operation. It is only served on the observer socket (see
+ * IpcHandleObserver), which accepts nothing else and may thus be opened to
+ * monitoring without granting the rights of the control socket. After the
+ * operation is acknowledged, the connection no longer accepts commands;
+ * instead the device writes a stream of blocks, each a series of key=value
+ * lines terminated by a blank line:
+ *
+ *	snapshot=<unix nanoseconds>    followed by the "get" output with
+ *	                               private and preshared keys removed,
+ *	                               sent every ObserveInterval
+ *
+ *	event=<name>                   followed by public_key=<hex>, the
+ *	                               peer's name=<name> if it has one, and
+ *	                               any lines specific to the event, sent
+ *	                               as state changes happen
+ *
+ * Event blocks are dropped rather than delaying the device if an observer
+ * falls behind; the next snapshot carries the current state regardless.
+ */
+
+const observerQueueSize = 64 // event blocks buffered per observer
+
+func (device *Device) addObserver() chan []byte {
+	c := make(chan []byte, observerQueueSize)
+	device.observers.Lock()
+	defer device.observers.Unlock()
+	if device.observers.chans == nil {
+		device.observers.chans = make(map[chan []byte]struct{})
+	}
+	device.observers.chans[c] = struct{}{}
+	device.observers.count.Store(int32(len(device.observers.chans)))
+	return c
+}
+
+func (device *Device) removeObserver(c chan []byte) {
+	device.observers.Lock()
+	defer device.observers.Unlock()
+	delete(device.observers.chans, c)
+	device.observers.count.Store(int32(len(device.observers.chans)))
+}
+
+// notifyObservers sends an event block concerning peer to every attached
+// observer, with fields as additional key=value lines.
+func (device *Device) notifyObservers(event string, peer *Peer, fields ...string) {
+	if device.observers.count.Load() == 0 {
+		return
+	}
+	block := fmt.Appendf(nil, "event=%s\npublic_key=%x\n", event, peer.handshake.remoteStatic[:])
+	if name := peer.Name(); name != "" {
+		block = fmt.Appendf(block, "name=%s\n", name)
+	}
+	for _, field := range fields {
+		block = append(block, field...)
+		block = append(block, '\n')
+	}
+	block = append(block, '\n')
+	device.observers.Lock()
+	defer device.observers.Unlock()
+	for c := range device.observers.chans {
+		select {
+		case c <- block:
+		default:
+		}
+	}
+}
+
+// ipcObserve streams snapshots and events to w until r is closed, a write
+// fails, or the device is closed. Anything read from r is discarded.
+func (device *Device) ipcObserve(w io.Writer, r io.Reader) {
+	events := device.addObserver()
+	defer device.removeObserver(events)
+
+	hangup := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, r)
+		close(hangup)
+	}()
+
+	buf := new(bytes.Buffer)
+	snapshot := func() error {
+		buf.Reset()
+		fmt.Fprintf(buf, "snapshot=%d\n", time.Now().UnixNano())
+		if err := device.ipcGetOperation(buf, true, ipcPage{}); err != nil {
+			return err
+		}
+		buf.WriteByte('\n')
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+
+	ticker := time.NewTicker(ObserveInterval)
+	defer ticker.Stop()
+	if snapshot() != nil {
+		return
+	}
+	for {
+		select {
+		case <-hangup:
+			return
+		case <-device.closed:
+			return
+		case <-ticker.C:
+			if snapshot() != nil {
+				return
+			}
+		case block := <-events:
+			if _, err := w.Write(block); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/ipc"
+)
+
+func TestIpcObserve(t *testing.T) {
+	dev := randDevice(t)
+	defer dev.Close()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go dev.IpcHandleObserver(server)
+
+	if _, err := client.Write([]byte("observe=1\n\n")); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(client)
+	readBlock := func() []string {
+		var lines []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\n" {
+				return lines
+			}
+			lines = append(lines, strings.TrimSuffix(line, "\n"))
+		}
+	}
+
+	if ack := readBlock(); len(ack) != 1 || ack[0] != "errno=0" {
+		t.Fatalf("unexpected acknowledgement: %q", ack)
+	}
+	snapshot := readBlock()
+	if len(snapshot) == 0 || !strings.HasPrefix(snapshot[0], "snapshot=") {
+		t.Fatalf("first block is not a snapshot: %q", snapshot)
+	}
+	for _, line := range snapshot {
+		if strings.HasPrefix(line, "private_key=") || strings.HasPrefix(line, "preshared_key=") {
+			t.Errorf("snapshot contains secret: %q", line)
+		}
+	}
+
+	sk, err := newPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk := sk.publicKey()
+	if err := dev.IpcSet(fmt.Sprintf("public_key=%x\npreshared_key=%x\n", pk[:], pk[:])); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("public_key=%x", pk[:])
+	sawEvent, sawPeer := false, false
+	for !sawEvent || !sawPeer {
+		block := readBlock()
+		switch {
+		case len(block) == 2 && block[0] == "event=peer_added":
+			if block[1] != want {
+				t.Errorf("peer_added event for %q, want %q", block[1], want)
+			}
+			sawEvent = true
+		case strings.HasPrefix(block[0], "snapshot="):
+			for _, line := range block {
+				if strings.HasPrefix(line, "preshared_key=") {
+					t.Errorf("snapshot contains secret: %q", line)
+				}
+				if line == want {
+					sawPeer = true
+				}
+			}
+		}
+	}
+
+	// Commands are no longer accepted once observing.
+	if _, err := client.Write([]byte("get=1\n\n")); err != nil {
+		t.Fatal(err)
+	}
+	if block := readBlock(); strings.HasPrefix(block[0], "errno=") {
+		t.Errorf("observer connection answered a command: %q", block)
+	}
+}
+
+func TestIpcObserveSocket(t *testing.T) {
+	dev := randDevice(t)
+	defer dev.Close()
+
+	// The control socket does not serve observers.
+	client, server := net.Pipe()
+	defer client.Close()
+	go dev.IpcHandle(server)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Write([]byte("observe=1\n\n")); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(client)
+	if line, err := r.ReadString('\n'); err != nil || line != fmt.Sprintf("errno=%d\n", ipc.IpcErrorInvalid) {
+		t.Errorf("observe on the control socket answered %q, %v", line, err)
+	}
+
+	// The observer socket serves nothing else.
+	client, server = net.Pipe()
+	defer client.Close()
+	go dev.IpcHandleObserver(server)
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Write([]byte("get=1\n\n")); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := client.Read(make([]byte, 1)); err == nil {
+		t.Errorf("get on the observer socket answered %d bytes", n)
+	}
+}
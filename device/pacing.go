@@ -0,0 +1,248 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+/* Output pacing
+ *
+ * Batches read from the TUN device, with GSO in particular, leave the bind as
+ * bursts of back-to-back datagrams at line rate, which overflow the shallow
+ * buffers in front of slower links and add latency for competing flows. A
+ * peer with pacing enabled spreads its transport packets out at a pacing
+ * rate as its sequential sender hands them to the bind, sending at most
+ * PacingQuantum worth of data at a time, so that a sender running ahead of
+ * the rate backs up into its queues instead.
+ *
+ * The pacing rate is either configured, or derived from the bottleneck
+ * bandwidth estimated in the manner of BBR: a peer pacing automatically asks
+ * the other end with a delivery request control message to report, in
+ * delivery report control messages sent every DeliveryReportInterval while
+ * data arrives, how many bytes it received and when. The maximum delivery
+ * rate among the last PacingSamples reports is the estimate, leaving out
+ * rates below it measured while the sender had nothing queued, as those tell
+ * of the sender rather than of the path. As in BBR, the pacing rate is a high
+ * multiple of the estimate while the estimate keeps growing, and then cycles
+ * through gains over it, probing for more bandwidth and draining the queue
+ * the probe built. Until a first estimate, such as with peers that do not
+ * understand delivery requests, nothing is paced.
+ */
+
+const (
+	ControlDeliveryRequestType = 0x05
+	ControlDeliveryReportType  = 0x06
+)
+
+const (
+	ControlDeliveryReportSize = 1 + 8 + 8 // type, bytes received, receiver clock in nanoseconds
+)
+
+// PacingAuto as a pacing rate paces at the estimated bottleneck bandwidth.
+const PacingAuto = -1
+
+// pacingGains are the successive factors, in percent, applied to the
+// estimated bottleneck bandwidth for each delivery report, like the pacing
+// gain cycle of BBR's bandwidth probing. Until the estimate stops growing,
+// the factor is pacingStartupGain.
+var pacingGains = [...]int64{125, 75, 100, 100, 100, 100, 100, 100}
+
+const pacingStartupGain = 289 // 2/ln(2), as in BBR's startup
+
+type peerPacer struct {
+	setting atomic.Int64 // rate in bits per second, PacingAuto, or zero if disabled
+	rate    atomic.Int64 // current pacing rate in bytes per second; zero if not pacing
+	limited atomic.Bool  // the sender had data queued since the last report
+
+	// Only accessed by RoutineSequentialSender.
+	next        time.Time // when the next data may be sent
+	lastRequest time.Time
+
+	estimate struct {
+		sync.Mutex
+		samples   [PacingSamples]int64 // delivery rates in bytes per second
+		nsamples  int
+		lastBytes uint64
+		lastClock uint64
+		bandwidth int64 // in bytes per second
+		cycle     int
+
+		// Startup lasts until the estimate fails to grow by a quarter
+		// in three reports.
+		full          bool
+		fullBandwidth int64
+		fullCount     int
+	}
+
+	// Only accessed by RoutineSequentialReceiver.
+	reportsUntil time.Time // when to stop reporting deliveries to the peer
+	lastReport   time.Time
+	reportEpoch  time.Time // origin of the clock in delivery reports
+}
+
+// SetPacingRate sets the rate in bits per second at which transport packets
+// are sent to the peer. PacingAuto paces at the estimated bottleneck
+// bandwidth, and zero disables pacing.
+func (peer *Peer) SetPacingRate(rate int64) error {
+	if rate != 0 && rate != PacingAuto && rate < MinPacingRate {
+		return fmt.Errorf("pacing rate of %d bits per second is below minimum of %d", rate, MinPacingRate)
+	}
+	pacer := &peer.pacer
+	pacer.setting.Store(rate)
+	e := &pacer.estimate
+	e.Lock()
+	e.nsamples, e.lastBytes, e.lastClock, e.bandwidth, e.cycle = 0, 0, 0, 0, 0
+	e.full, e.fullBandwidth, e.fullCount = false, 0, 0
+	e.Unlock()
+	if rate == PacingAuto {
+		pacer.rate.Store(0)
+	} else {
+		pacer.rate.Store(rate / 8)
+	}
+	return nil
+}
+
+// PacingRate returns the rate set with SetPacingRate.
+func (peer *Peer) PacingRate() int64 {
+	return peer.pacer.setting.Load()
+}
+
+// PacingEstimate returns the estimated bottleneck bandwidth to the peer in
+// bits per second, or zero if there is no estimate.
+func (peer *Peer) PacingEstimate() int64 {
+	peer.pacer.estimate.Lock()
+	defer peer.pacer.estimate.Unlock()
+	return peer.pacer.estimate.bandwidth * 8
+}
+
+// sendPaced sends bufs like sendBuffersTo, or SendBuffers if endpoint is
+// nil, waiting as needed to keep to the pacing rate. If backlog, more data
+// is queued for the peer.
+func (peer *Peer) sendPaced(bufs [][]byte, endpoint conn.Endpoint, backlog bool) error {
+	pacer := &peer.pacer
+	if pacer.setting.Load() == PacingAuto {
+		if backlog {
+			pacer.limited.Store(true)
+		}
+		if time.Since(pacer.lastRequest) >= DeliveryReportLifetime/2 {
+			// Not sent from this goroutine, which would have to
+			// dequeue the request itself.
+			pacer.lastRequest = time.Now()
+			go peer.sendControl([]byte{ControlDeliveryRequestType})
+		}
+	}
+	rate := pacer.rate.Load()
+	if rate == 0 {
+		return peer.sendUnpaced(bufs, endpoint)
+	}
+	mtu := int(peer.device.tun.mtu.Load()) + MessageTransportHeaderSize + peer.cipherSuite().Overhead() + peer.device.BindOverhead()
+	quantum := max(int(rate*int64(PacingQuantum)/int64(time.Second)), 2*mtu)
+	for len(bufs) > 0 {
+		n, size := 0, 0
+		for n < len(bufs) && (n == 0 || size+len(bufs[n]) <= quantum) {
+			size += len(bufs[n])
+			n++
+		}
+		now := time.Now()
+		if wait := pacer.next.Sub(now); wait > 0 {
+			pacer.limited.Store(true)
+			time.Sleep(wait)
+		} else {
+			pacer.next = now
+		}
+		pacer.next = pacer.next.Add(time.Duration(int64(size) * int64(time.Second) / rate))
+		if err := peer.sendUnpaced(bufs[:n], endpoint); err != nil {
+			return err
+		}
+		bufs = bufs[n:]
+	}
+	return nil
+}
+
+func (peer *Peer) sendUnpaced(bufs [][]byte, endpoint conn.Endpoint) error {
+	if endpoint != nil {
+		return peer.sendBuffersTo(bufs, endpoint)
+	}
+	return peer.SendBuffers(bufs)
+}
+
+// handleDeliveryRequest starts reporting deliveries to the peer.
+func (peer *Peer) handleDeliveryRequest() {
+	peer.pacer.reportsUntil = time.Now().Add(DeliveryReportLifetime)
+}
+
+// reportDeliveries sends the peer a delivery report if it asked for them
+// and the last one was sent at least DeliveryReportInterval ago.
+func (peer *Peer) reportDeliveries() {
+	pacer := &peer.pacer
+	now := time.Now()
+	if now.After(pacer.reportsUntil) || now.Sub(pacer.lastReport) < DeliveryReportInterval {
+		return
+	}
+	pacer.lastReport = now
+	if pacer.reportEpoch.IsZero() {
+		pacer.reportEpoch = now
+	}
+	var report [ControlDeliveryReportSize]byte
+	report[0] = ControlDeliveryReportType
+	binary.LittleEndian.PutUint64(report[1:], peer.rxBytes.Load())
+	binary.LittleEndian.PutUint64(report[9:], uint64(now.Sub(pacer.reportEpoch)))
+	peer.sendControl(report[:])
+}
+
+// handleDeliveryReport updates the bandwidth estimate and pacing rate of the
+// peer with a delivery report.
+func (peer *Peer) handleDeliveryReport(msg []byte) {
+	pacer := &peer.pacer
+	if pacer.setting.Load() != PacingAuto {
+		return
+	}
+	bytes := binary.LittleEndian.Uint64(msg[1:])
+	clock := binary.LittleEndian.Uint64(msg[9:])
+	limited := pacer.limited.Swap(false)
+
+	e := &pacer.estimate
+	e.Lock()
+	defer e.Unlock()
+	elapsed, delivered := clock-e.lastClock, bytes-e.lastBytes
+	first := e.lastBytes == 0
+	e.lastBytes, e.lastClock = bytes, clock
+	if first || int64(elapsed) <= 0 || int64(delivered) < 0 {
+		return
+	}
+	sample := int64(delivered) * int64(time.Second) / int64(elapsed)
+	if !limited && (e.bandwidth == 0 || sample <= e.bandwidth) {
+		// The sender had nothing queued, so the sample says little
+		// of the bottleneck.
+		return
+	}
+	e.samples[e.nsamples%PacingSamples] = sample
+	e.nsamples++
+	e.bandwidth = 0
+	for _, s := range e.samples[:min(e.nsamples, PacingSamples)] {
+		e.bandwidth = max(e.bandwidth, s)
+	}
+	gain := int64(pacingStartupGain)
+	if !e.full {
+		if e.bandwidth >= e.fullBandwidth*5/4 {
+			e.fullBandwidth, e.fullCount = e.bandwidth, 0
+		} else if e.fullCount++; e.fullCount >= 3 {
+			e.full = true
+		}
+	}
+	if e.full {
+		e.cycle = (e.cycle + 1) % len(pacingGains)
+		gain = pacingGains[e.cycle]
+	}
+	pacer.rate.Store(max(e.bandwidth*gain/100, MinPacingRate/8))
+}
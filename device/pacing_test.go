@@ -0,0 +1,129 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func TestPacingRate(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+	sender := pair[1].dev.LookupPeer(pair[0].dev.staticIdentity.publicKey)
+
+	if err := sender.SetPacingRate(MinPacingRate - 1); err == nil {
+		t.Error("pacing rate below minimum accepted")
+	}
+	pub := pair[0].dev.staticIdentity.publicKey
+	if err := pair[1].dev.IpcSet(uapiCfg("public_key", fmt.Sprintf("%x", pub[:]), "pacing_rate", "200000")); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := pair[1].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(cfg, "pacing_rate=200000\n") {
+		t.Errorf("get output lacks pacing rate:\n%s", cfg)
+	}
+
+	// A burst of 100 pings, over 6 kB on the wire, takes well over 100ms
+	// at 25 kB/s, even after the first quantum.
+	const burst = 100
+	msg := tuntest.Ping(pair[0].ip, pair[1].ip)
+	start := time.Now()
+	go func() {
+		for range burst {
+			pair[1].tun.Outbound <- msg
+		}
+	}()
+	for range burst {
+		select {
+		case <-pair[0].tun.Inbound:
+		case <-time.After(5 * time.Second):
+			t.Fatal("ping not received")
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("burst delivered in %v despite pacing", elapsed)
+	}
+
+	if err := sender.SetPacingRate(0); err != nil {
+		t.Fatal(err)
+	}
+	pair.Send(t, Ping, nil)
+}
+
+func TestPacingEstimate(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+	sender := pair[1].dev.LookupPeer(pair[0].dev.staticIdentity.publicKey)
+	if err := sender.SetPacingRate(PacingAuto); err != nil {
+		t.Fatal(err)
+	}
+
+	// The receiver reports deliveries once asked to.
+	msg := tuntest.Ping(pair[0].ip, pair[1].ip)
+	waitFor(t, func() bool {
+		pair[1].tun.Outbound <- msg
+		<-pair[0].tun.Inbound
+		sender.pacer.estimate.Lock()
+		defer sender.pacer.estimate.Unlock()
+		return sender.pacer.estimate.lastBytes != 0
+	})
+	time.Sleep(50 * time.Millisecond) // let reports in flight arrive
+	if err := sender.SetPacingRate(PacingAuto); err != nil {
+		t.Fatal(err)
+	}
+
+	report := func(bytes uint64, clock time.Duration, limited bool) {
+		var msg [ControlDeliveryReportSize]byte
+		msg[0] = ControlDeliveryReportType
+		binary.LittleEndian.PutUint64(msg[1:], bytes)
+		binary.LittleEndian.PutUint64(msg[9:], uint64(clock))
+		sender.pacer.limited.Store(limited)
+		sender.handleDeliveryReport(msg[:])
+	}
+	report(1000, time.Second, true)
+	if rate := sender.pacer.rate.Load(); rate != 0 {
+		t.Errorf("pacing at %d B/s after first report", rate)
+	}
+	// 1 MB/s delivered while the sender had data queued.
+	report(101000, 1100*time.Millisecond, true)
+	if estimate := sender.PacingEstimate(); estimate != 8_000_000 {
+		t.Errorf("estimate = %d bit/s, want 8000000", estimate)
+	}
+	if rate := sender.pacer.rate.Load(); rate != 1_000_000*pacingStartupGain/100 {
+		t.Errorf("startup pacing rate = %d B/s", rate)
+	}
+	// A lower rate while the sender had nothing queued is ignored.
+	report(111000, 1200*time.Millisecond, false)
+	if estimate := sender.PacingEstimate(); estimate != 8_000_000 {
+		t.Errorf("estimate after idle report = %d bit/s, want 8000000", estimate)
+	}
+	// Without growth, startup ends and the gain cycle starts.
+	for i := range 3 {
+		report(211000+uint64(i)*100000, time.Duration(1300+100*i)*time.Millisecond, true)
+	}
+	if rate := sender.pacer.rate.Load(); rate != 1_000_000*pacingGains[1]/100 {
+		t.Errorf("pacing rate after startup = %d B/s", rate)
+	}
+
+	cfg, err := pair[1].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(cfg, "pacing_rate=auto\npacing_estimate=8000000\n") {
+		t.Errorf("get output lacks pacing estimate:\n%s", cfg)
+	}
+}
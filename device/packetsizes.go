@@ -0,0 +1,221 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/* Packet sizes and MTU misconfiguration
+ *
+ * Each peer counts the packets sent to it by size, along with those of the
+ * full MTU of the TUN device. An MTU set larger than the path to the peer
+ * allows typically shows as full-size packets that never arrive: TCP
+ * retransmits those segments, and routers or the far end may answer with
+ * ICMP "fragmentation needed" or "packet too big" messages. The peer is
+ * suspected of MTU misconfiguration once, within MTUWatchWindow, at least
+ * MTUWatchMinPackets full-size packets were sent and MTUWatchRetransmitPercent
+ * of them were TCP retransmissions, or any ICMP too-big message crossed the
+ * tunnel; the suspicion is lifted after a window of full-size traffic without
+ * either. Becoming suspected sends observers an mtu_suspected event.
+ */
+
+// packetSizeBuckets holds the upper bounds of the packet size histogram,
+// which has a last bucket for larger packets.
+var packetSizeBuckets = [...]int{128, 256, 512, 1024, 1280, 1420}
+
+// A PacketSizeBucket counts the packets of at most Max bytes that are larger
+// than those of the previous bucket. The last bucket has no Max.
+type PacketSizeBucket struct {
+	Max   int // zero for the last bucket
+	Count uint64
+}
+
+// PacketSizeStats describes the sizes of the packets sent to a peer.
+type PacketSizeStats struct {
+	Buckets      []PacketSizeBucket
+	FullSize     uint64 // packets of the TUN device's MTU
+	Retransmits  uint64 // full-size TCP segments sent again
+	TooBig       uint64 // ICMP fragmentation needed and packet too big messages through the tunnel
+	MTUSuspected bool
+}
+
+type tcpSegment struct {
+	flow uint64
+	seq  uint32
+}
+
+type packetSizes struct {
+	buckets     [len(packetSizeBuckets) + 1]atomic.Uint64
+	fullSize    atomic.Uint64
+	retransmits atomic.Uint64
+	tooBig      atomic.Uint64
+	suspected   atomic.Bool
+
+	mu       sync.Mutex
+	segments [MTUWatchSegments]tcpSegment // full-size TCP segments sent last
+	next     int
+	window   struct {
+		start                      time.Time
+		full, retransmits, tooBigs uint64
+	}
+}
+
+// countSentPacket records a packet read from the TUN device for the peer.
+func (peer *Peer) countSentPacket(packet []byte) {
+	sizes := &peer.sizes
+	i := 0
+	for i < len(packetSizeBuckets) && len(packet) > packetSizeBuckets[i] {
+		i++
+	}
+	sizes.buckets[i].Add(1)
+
+	full := len(packet) >= int(peer.device.tun.mtu.Load())
+	tooBig := isICMPTooBig(packet)
+	if !full && !tooBig {
+		return
+	}
+	retransmit := false
+	if full {
+		sizes.fullSize.Add(1)
+		if flow, seq, ok := tcpSegmentOf(packet); ok {
+			retransmit = sizes.noteSegment(flow, seq)
+		}
+	}
+	peer.noteMTUSignals(full, retransmit, tooBig)
+}
+
+// countReceivedPacket records a packet received from the peer, whose ICMP
+// too-big messages are signs of MTU misconfiguration as well.
+func (peer *Peer) countReceivedPacket(packet []byte) {
+	if isICMPTooBig(packet) {
+		peer.noteMTUSignals(false, false, true)
+	}
+}
+
+// noteSegment records a full-size TCP segment, reporting whether it was
+// recently sent already.
+func (sizes *packetSizes) noteSegment(flow uint64, seq uint32) bool {
+	segment := tcpSegment{flow, seq}
+	sizes.mu.Lock()
+	defer sizes.mu.Unlock()
+	for _, s := range sizes.segments {
+		if s == segment {
+			return true
+		}
+	}
+	sizes.segments[sizes.next] = segment
+	sizes.next = (sizes.next + 1) % len(sizes.segments)
+	return false
+}
+
+func (peer *Peer) noteMTUSignals(full, retransmit, tooBig bool) {
+	sizes := &peer.sizes
+	if retransmit {
+		sizes.retransmits.Add(1)
+	}
+	if tooBig {
+		sizes.tooBig.Add(1)
+	}
+
+	sizes.mu.Lock()
+	w := &sizes.window
+	if now := time.Now(); now.Sub(w.start) > MTUWatchWindow {
+		if w.full >= MTUWatchMinPackets && w.retransmits == 0 && w.tooBigs == 0 {
+			sizes.suspected.Store(false)
+		}
+		w.start, w.full, w.retransmits, w.tooBigs = now, 0, 0, 0
+	}
+	if full {
+		w.full++
+	}
+	if retransmit {
+		w.retransmits++
+	}
+	if tooBig {
+		w.tooBigs++
+	}
+	suspect := w.full > 0 && w.tooBigs > 0 ||
+		w.full >= MTUWatchMinPackets && w.retransmits*100 >= w.full*MTUWatchRetransmitPercent
+	retransmits, tooBigs := w.retransmits, w.tooBigs
+	sizes.mu.Unlock()
+
+	if suspect && sizes.suspected.CompareAndSwap(false, true) {
+		mtu := peer.device.tun.mtu.Load()
+		peer.device.log.transport.Verbosef("%v - Suspected MTU misconfiguration: %d retransmits and %d ICMP too-big messages at MTU %d", peer, retransmits, tooBigs, mtu)
+		peer.device.notifyObservers("mtu_suspected", peer,
+			fmt.Sprintf("mtu=%d", mtu),
+			fmt.Sprintf("full_size_retransmits=%d", retransmits),
+			fmt.Sprintf("icmp_too_big=%d", tooBigs))
+	}
+}
+
+// PacketSizes returns statistics of the sizes of packets sent to the peer.
+func (peer *Peer) PacketSizes() PacketSizeStats {
+	sizes := &peer.sizes
+	stats := PacketSizeStats{
+		Buckets:      make([]PacketSizeBucket, len(sizes.buckets)),
+		FullSize:     sizes.fullSize.Load(),
+		Retransmits:  sizes.retransmits.Load(),
+		TooBig:       sizes.tooBig.Load(),
+		MTUSuspected: sizes.suspected.Load(),
+	}
+	for i := range sizes.buckets {
+		if i < len(packetSizeBuckets) {
+			stats.Buckets[i].Max = packetSizeBuckets[i]
+		}
+		stats.Buckets[i].Count = sizes.buckets[i].Load()
+	}
+	return stats
+}
+
+// tcpSegmentOf returns a hash of the flow of a TCP packet and its sequence
+// number.
+func tcpSegmentOf(packet []byte) (flow uint64, seq uint32, ok bool) {
+	var addrs, tcp []byte
+	switch packet[0] >> 4 {
+	case 4:
+		ihl := int(packet[0]&0xf) * 4
+		if len(packet) < 20 || packet[9] != 6 || ihl < 20 || len(packet) < ihl+8 {
+			return 0, 0, false
+		}
+		addrs, tcp = packet[12:20], packet[ihl:]
+	case 6:
+		if len(packet) < 48 || packet[6] != 6 {
+			return 0, 0, false
+		}
+		addrs, tcp = packet[8:40], packet[40:]
+	default:
+		return 0, 0, false
+	}
+	// FNV-1a over the addresses and ports.
+	flow = 14695981039346656037
+	for _, b := range addrs {
+		flow = (flow ^ uint64(b)) * 1099511628211
+	}
+	for _, b := range tcp[:4] {
+		flow = (flow ^ uint64(b)) * 1099511628211
+	}
+	return flow, binary.BigEndian.Uint32(tcp[4:8]), true
+}
+
+// isICMPTooBig reports whether packet is an ICMP fragmentation needed or
+// ICMPv6 packet too big message.
+func isICMPTooBig(packet []byte) bool {
+	switch packet[0] >> 4 {
+	case 4:
+		ihl := int(packet[0]&0xf) * 4
+		return len(packet) >= 20 && packet[9] == 1 && ihl >= 20 && len(packet) >= ihl+2 &&
+			packet[ihl] == 3 && packet[ihl+1] == 4
+	case 6:
+		return len(packet) >= 41 && packet[6] == 58 && packet[40] == 2
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestPacketSizes(t *testing.T) {
+	dev := randDevice(t)
+	defer dev.Close()
+	mtu := int(dev.tun.mtu.Load())
+	sk, err := newPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := dev.NewPeer(sk.publicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	observer := dev.addObserver()
+	defer dev.removeObserver(observer)
+
+	tcp := func(size int, seq uint32) []byte {
+		packet := make([]byte, size)
+		packet[0], packet[9] = 0x45, 6
+		binary.BigEndian.PutUint16(packet[20:], 443)
+		binary.BigEndian.PutUint32(packet[24:], seq)
+		return packet
+	}
+	peer.countSentPacket(tcp(100, 0))
+	peer.countSentPacket(tcp(1000, 0))
+	for seq := range uint32(MTUWatchMinPackets) {
+		peer.countSentPacket(tcp(mtu, seq*1000))
+	}
+	if peer.PacketSizes().MTUSuspected {
+		t.Fatal("suspected MTU misconfiguration without retransmits")
+	}
+	peer.countSentPacket(tcp(mtu, 1000))
+	if peer.PacketSizes().MTUSuspected {
+		t.Fatal("suspected MTU misconfiguration after a single retransmit")
+	}
+	peer.countSentPacket(tcp(mtu, 2000))
+
+	stats := peer.PacketSizes()
+	if !stats.MTUSuspected || stats.FullSize != MTUWatchMinPackets+2 || stats.Retransmits != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+	select {
+	case block := <-observer:
+		if !strings.HasPrefix(string(block), "event=mtu_suspected\n") || !strings.Contains(string(block), "full_size_retransmits=2\n") {
+			t.Errorf("unexpected event:\n%s", block)
+		}
+	default:
+		t.Error("no mtu_suspected event")
+	}
+	cfg, err := dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "packet_sizes=128:1,256:0,512:0,1024:1,1280:0,1420:34,inf:0\nfull_size_packets=34\nfull_size_retransmits=2\nicmp_too_big=0\nmtu_suspected=true\n"; !strings.Contains(cfg, want) {
+		t.Errorf("get output lacks %q:\n%s", want, cfg)
+	}
+}
+
+func TestPacketSizesICMPTooBig(t *testing.T) {
+	dev := randDevice(t)
+	defer dev.Close()
+	sk, err := newPrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer, err := dev.NewPeer(sk.publicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	full := make([]byte, dev.tun.mtu.Load())
+	full[0] = 0x60
+	peer.countSentPacket(full)
+	icmp := make([]byte, 48)
+	icmp[0], icmp[6], icmp[40] = 0x60, 58, 2
+	peer.countReceivedPacket(icmp)
+	if stats := peer.PacketSizes(); !stats.MTUSuspected || stats.TooBig != 1 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
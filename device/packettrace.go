@@ -0,0 +1,201 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.zx2c4.com/wireguard/ipc"
+)
+
+/* Packet trace
+ *
+ * Counters tell how many packets were dropped, but not which ones, when,
+ * or what happened around them, which is what reports of intermittent loss
+ * call for. With a packet trace policy, the device keeps a flight recorder:
+ * a ring of the latest Size decision records, one for every Sample-th
+ * packet reaching its final decision, whether written to the TUN device or
+ * sent, or dropped and why, with its peer, sizes and time. The UAPI
+ * "get=packet_trace" operation dumps the ring, oldest record first, as
+ * lines
+ *
+ *	packet=<unix nanoseconds>:<in|out>:<reason>:<public key>:<size>:<inner size>
+ *
+ * where the public key is that of the peer, empty if none is known, size is
+ * that of the datagram, and inner size that of the padded inner packet,
+ * either zero if not known. Keepalives and control messages are not traced.
+ */
+
+// A PacketReason is the decision taken on a traced packet.
+type PacketReason int
+
+const (
+	PacketDelivered        PacketReason = iota // written to the TUN device
+	PacketHandedOff                            // handed to a nested device
+	PacketSent                                 // sent to the peer
+	PacketNoRoute                              // no peer has the destination in its allowed IPs
+	PacketUnreachable                          // answered with an ICMP error for the peer's unreachable path
+	PacketPeerDown                             // the peer is not running
+	PacketSendFailed                           // the bind failed to send it
+	PacketUnknownIndex                         // no session has its receiver index
+	PacketExpiredSession                       // its session is too old to accept packets
+	PacketQuarantined                          // its peer is quarantined
+	PacketRateLimited                          // its peer exceeded its inbound limit
+	PacketDecryptFailed                        // it failed to decrypt
+	PacketReplay                               // the replay filter rejected it
+	PacketStale                                // it exceeded the peer's maximum queue age
+	PacketMalformed                            // its inner packet is invalid
+	PacketDisallowedSource                     // its inner source is not in the peer's allowed IPs
+	numPacketReasons
+)
+
+var packetReasonNames = [numPacketReasons]string{
+	PacketDelivered:        "delivered",
+	PacketHandedOff:        "handed_off",
+	PacketSent:             "sent",
+	PacketNoRoute:          "no_route",
+	PacketUnreachable:      "unreachable",
+	PacketPeerDown:         "peer_down",
+	PacketSendFailed:       "send_failed",
+	PacketUnknownIndex:     "unknown_index",
+	PacketExpiredSession:   "expired_session",
+	PacketQuarantined:      "quarantined",
+	PacketRateLimited:      "rate_limited",
+	PacketDecryptFailed:    "decrypt_failed",
+	PacketReplay:           "replay",
+	PacketStale:            "stale",
+	PacketMalformed:        "malformed",
+	PacketDisallowedSource: "disallowed_source",
+}
+
+func (r PacketReason) String() string {
+	if r < 0 || r >= numPacketReasons {
+		return fmt.Sprintf("PacketReason(%d)", int(r))
+	}
+	return packetReasonNames[r]
+}
+
+// Dropped reports whether the packet was dropped.
+func (r PacketReason) Dropped() bool {
+	return r > PacketSent
+}
+
+// A PacketRecord records the decision taken on a packet.
+type PacketRecord struct {
+	Time      time.Time
+	Outbound  bool // read from the TUN device, rather than received from the bind
+	Reason    PacketReason
+	Peer      NoisePublicKey // zero if no peer is known
+	Size      int            // of the datagram, if known
+	InnerSize int            // of the padded inner packet, if known
+}
+
+// A PacketTracePolicy sets which packets the device records decisions for.
+type PacketTracePolicy struct {
+	Size   int // records kept; zero disables the trace
+	Sample int // one in this many packets is recorded; zero for every packet
+}
+
+type packetTrace struct {
+	policy atomic.Pointer[PacketTracePolicy] // nil if disabled
+	seen   atomic.Uint64                     // packets decided on while enabled
+
+	mu   sync.Mutex
+	ring []PacketRecord
+	next int // where the next record goes once the ring is full
+}
+
+// SetPacketTracePolicy sets the policy of the packet trace, discarding the
+// records kept so far.
+func (device *Device) SetPacketTracePolicy(policy PacketTracePolicy) error {
+	if policy.Size < 0 || policy.Size > MaxPacketTraceSize {
+		return fmt.Errorf("packet trace size %d out of range", policy.Size)
+	}
+	if policy.Sample < 0 {
+		return fmt.Errorf("negative packet trace sample")
+	}
+	if policy.Sample == 0 {
+		policy.Sample = 1
+	}
+	t := &device.packetTrace
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if policy.Size == 0 {
+		t.policy.Store(nil)
+		t.ring, t.next = nil, 0
+		return nil
+	}
+	t.ring, t.next = make([]PacketRecord, 0, policy.Size), 0
+	t.policy.Store(&policy)
+	return nil
+}
+
+// PacketTracePolicy returns the policy set with SetPacketTracePolicy.
+func (device *Device) PacketTracePolicy() PacketTracePolicy {
+	if policy := device.packetTrace.policy.Load(); policy != nil {
+		return *policy
+	}
+	return PacketTracePolicy{}
+}
+
+// PacketTrace returns the records of the packet trace, oldest first.
+func (device *Device) PacketTrace() []PacketRecord {
+	t := &device.packetTrace
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append(append([]PacketRecord(nil), t.ring[t.next:]...), t.ring[:t.next]...)
+}
+
+// tracing reports whether the packet trace is enabled.
+func (device *Device) tracing() bool {
+	return device.packetTrace.policy.Load() != nil
+}
+
+// tracePacket records the decision taken on a packet of peer, which may be
+// nil, if the packet trace samples it.
+func (device *Device) tracePacket(outbound bool, reason PacketReason, peer *Peer, size, innerSize int) {
+	t := &device.packetTrace
+	policy := t.policy.Load()
+	if policy == nil || t.seen.Add(1)%uint64(policy.Sample) != 0 {
+		return
+	}
+	r := PacketRecord{Time: time.Now(), Outbound: outbound, Reason: reason, Size: size, InnerSize: innerSize}
+	if peer != nil {
+		r.Peer = peer.handshake.remoteStatic
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.ring) < cap(t.ring) {
+		t.ring = append(t.ring, r)
+		return
+	}
+	if len(t.ring) == 0 {
+		return
+	}
+	t.ring[t.next] = r
+	t.next = (t.next + 1) % len(t.ring)
+}
+
+func (device *Device) ipcPacketTrace(w io.Writer) error {
+	for _, r := range device.PacketTrace() {
+		dir := "in"
+		if r.Outbound {
+			dir = "out"
+		}
+		var peer string
+		if !r.Peer.IsZero() {
+			peer = fmt.Sprintf("%x", r.Peer[:])
+		}
+		if _, err := fmt.Fprintf(w, "packet=%d:%s:%v:%s:%d:%d\n", r.Time.UnixNano(), dir, r.Reason, peer, r.Size, r.InnerSize); err != nil {
+			return ipcErrorf(ipc.IpcErrorIO, "failed to write output: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func TestPacketTrace(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	if err := pair[0].dev.IpcSet(uapiCfg("packet_trace_size", "16")); err != nil {
+		t.Fatal(err)
+	}
+	if err := pair[1].dev.Configure(Config{PacketTrace: &PacketTracePolicy{Size: 16}}); err != nil {
+		t.Fatal(err)
+	}
+	pair.Send(t, Ping, nil)
+
+	// A packet without a route is dropped by the sender.
+	pair[1].tun.Outbound <- tuntest.Ping(netip.MustParseAddr("1.0.0.9"), pair[1].ip)
+
+	find := func(d *Device, reason PacketReason) *PacketRecord {
+		for _, r := range d.PacketTrace() {
+			if r.Reason == reason {
+				return &r
+			}
+		}
+		return nil
+	}
+	waitFor(t, func() bool {
+		return find(pair[1].dev, PacketSent) != nil && find(pair[1].dev, PacketNoRoute) != nil
+	})
+	pub0, pub1 := pair[0].dev.staticIdentity.publicKey, pair[1].dev.staticIdentity.publicKey
+	if r := find(pair[1].dev, PacketSent); !r.Outbound || r.Peer != pub0 || r.Size <= r.InnerSize || r.InnerSize == 0 {
+		t.Errorf("unexpected record of the sent packet: %+v", *r)
+	}
+	if r := find(pair[1].dev, PacketNoRoute); !r.Outbound || !r.Peer.IsZero() || !r.Reason.Dropped() {
+		t.Errorf("unexpected record of the packet without a route: %+v", *r)
+	}
+	if r := find(pair[0].dev, PacketDelivered); r == nil || r.Outbound || r.Peer != pub1 {
+		t.Errorf("unexpected record of the delivered packet: %+v", r)
+	}
+}
+
+func TestPacketTraceRing(t *testing.T) {
+	dev := randDevice(t)
+	defer dev.Close()
+	if err := dev.SetPacketTracePolicy(PacketTracePolicy{Size: 4, Sample: 2}); err != nil {
+		t.Fatal(err)
+	}
+	for size := 1; size <= 12; size++ {
+		dev.tracePacket(false, PacketReplay, nil, size, 0)
+	}
+	records := dev.PacketTrace()
+	if len(records) != 4 {
+		t.Fatalf("%d records, want 4", len(records))
+	}
+	for i, r := range records {
+		if want := 6 + 2*i; r.Size != want {
+			t.Errorf("record %d of size %d, want %d", i, r.Size, want)
+		}
+	}
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go dev.IpcHandle(server)
+	if _, err := client.Write([]byte("get=packet_trace\n\n")); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(client)
+	for i := range records {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := fmt.Sprintf("packet=%d:in:replay::%d:0\n", records[i].Time.UnixNano(), records[i].Size)
+		if line != want {
+			t.Errorf("line %d is %q, want %q", i, line, want)
+		}
+	}
+	if line, err := r.ReadString('\n'); err != nil || line != "errno=0\n" {
+		t.Errorf("trace not terminated: %q, %v", line, err)
+	}
+
+	if err := dev.SetPacketTracePolicy(PacketTracePolicy{}); err != nil {
+		t.Fatal(err)
+	}
+	dev.tracePacket(false, PacketReplay, nil, 1, 0)
+	if records := dev.PacketTrace(); len(records) != 0 || dev.tracing() {
+		t.Errorf("trace disabled, yet %d records", len(records))
+	}
+	if err := dev.SetPacketTracePolicy(PacketTracePolicy{Size: MaxPacketTraceSize + 1}); err == nil {
+		t.Error("oversized packet trace accepted")
+	}
+	if !strings.Contains(PacketDisallowedSource.String(), "disallowed") || PacketSent.Dropped() {
+		t.Error("unexpected reason names")
+	}
+}
@@ -0,0 +1,93 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+/* Handshake padding
+ *
+ * Handshake initiations and responses have fixed sizes of 148 and 92 bytes,
+ * which are enough for a censor to recognize WireGuard by. With handshake
+ * padding set, the device pads both to the configured size, and accepts
+ * both padded and unpadded ones:
+ *
+ *   initiation (148 bytes) | random bytes
+ *   response (92 bytes) | ChaCha20Poly1305(key, ZeroNonce, data | 0x80 | zeros, response)
+ *
+ * The padding of a response is the trailer carrying response data, with
+ * its payload padded by ISO/IEC 7816-4, so that it looks as random as the
+ * rest of the message whether any data is attached or not. Messages that
+ * do not fit the size are padded minimally. Both ends must agree: a stock
+ * peer drops padded messages, and a device with padding set cannot tell
+ * the response data of an unpadded trailer from padding. Padding is off by
+ * default. A cipher suite offer or selection, if any, stays right after the
+ * message, ahead of the padding.
+ */
+
+// SetHandshakePadding sets the size handshake initiations and responses are
+// padded to, from MessageInitiationSize to MaxHandshakePadding, or zero to
+// send them unpadded and accept only unpadded ones.
+func (device *Device) SetHandshakePadding(size int) error {
+	if size != 0 && (size < MessageInitiationSize || size > MaxHandshakePadding) {
+		return fmt.Errorf("handshake padding %d out of range", size)
+	}
+	device.handshakePadding.Store(int32(size))
+	return nil
+}
+
+// HandshakePadding returns the size set with SetHandshakePadding.
+func (device *Device) HandshakePadding() int {
+	return int(device.handshakePadding.Load())
+}
+
+// padInitiation pads an initiation in buf[:n], with its cipher suite offer
+// if it carries one, with random bytes, if padding is set, and returns the
+// packet to send.
+func (device *Device) padInitiation(buf []byte, n int) []byte {
+	size := device.HandshakePadding()
+	if size <= n {
+		return buf[:n]
+	}
+	packet := buf[:size]
+	rand.Read(packet[n:])
+	return packet
+}
+
+// responseDataPadding returns the padding the payload of the response data
+// trailer of a response of n bytes so far is to be extended with, or nil if
+// padding is not set.
+func (device *Device) responseDataPadding(n int, data []byte) []byte {
+	size := device.HandshakePadding()
+	if size == 0 {
+		return nil
+	}
+	n = max(size-n-MessageResponseDataOverhead-len(data), 1)
+	padding := make([]byte, n)
+	padding[0] = 0x80
+	return padding
+}
+
+// unpadResponseData removes the padding from the payload of a response data
+// trailer, if padding is set.
+func (device *Device) unpadResponseData(data []byte) ([]byte, error) {
+	if device.HandshakePadding() == 0 {
+		return data, nil
+	}
+	for i := len(data) - 1; i >= 0; i-- {
+		switch data[i] {
+		case 0:
+			continue
+		case 0x80:
+			return data[:i], nil
+		}
+		break
+	}
+	return nil, errors.New("invalid response data padding")
+}
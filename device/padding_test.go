@@ -0,0 +1,94 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+)
+
+func TestHandshakePadding(t *testing.T) {
+	goroutineLeakCheck(t)
+	var capture packetCapture
+	binds := bindtest.NewChannelBinds()
+	pair := genTestPairBinds(t, [2]conn.Bind{captureBind{binds[0], &capture}, captureBind{binds[1], &capture}})
+	for i := range pair {
+		if err := pair[i].dev.IpcSet("handshake_padding=400\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pair[0].dev.IpcSet("handshake_padding=100\n"); err == nil {
+		t.Error("padding below the initiation size accepted")
+	}
+	if err := pair[0].dev.SetHandshakePadding(MaxHandshakePadding + 1); err == nil {
+		t.Error("padding beyond maximum accepted")
+	}
+	responder := pair[0].dev.LookupPeer(pair[1].dev.staticIdentity.publicKey)
+	initiator := pair[1].dev.LookupPeer(pair[0].dev.staticIdentity.publicKey)
+	if err := responder.SetResponseData([]byte("10.0.0.2/32\x80\x00")); err != nil {
+		t.Fatal(err)
+	}
+	if err := initiator.SetCipherSuiteOffer([]string{StandardCipherSuite}); err != nil {
+		t.Fatal(err)
+	}
+
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+
+	sizes := make(map[uint32][]int)
+	for _, packet := range capture.snapshot() {
+		msgType := binary.LittleEndian.Uint32(packet)
+		sizes[msgType] = append(sizes[msgType], len(packet))
+	}
+	for _, msgType := range []uint32{MessageInitiationType, MessageResponseType} {
+		if len(sizes[msgType]) == 0 {
+			t.Errorf("no messages of type %d sent", msgType)
+		}
+		for _, size := range sizes[msgType] {
+			if size != 400 {
+				t.Errorf("message of type %d sent with %d bytes", msgType, size)
+			}
+		}
+	}
+	// Response data ending like padding survives it.
+	if data := initiator.ResponseData(); !bytes.Equal(data, []byte("10.0.0.2/32\x80\x00")) {
+		t.Errorf("response data = %q", data)
+	}
+	if status := pair[0].dev.Status(); status.HandshakePadding != 400 {
+		t.Errorf("status handshake padding = %d", status.HandshakePadding)
+	}
+}
+
+func TestResponseDataPadding(t *testing.T) {
+	device := new(Device)
+	data := []byte("data")
+	if padding := device.responseDataPadding(MessageResponseSize, data); padding != nil {
+		t.Errorf("padded without padding set: %x", padding)
+	}
+	if unpadded, err := device.unpadResponseData(data); err != nil || !bytes.Equal(unpadded, data) {
+		t.Errorf("unpadded without padding set: %q, %v", unpadded, err)
+	}
+
+	device.SetHandshakePadding(MessageInitiationSize)
+	padding := device.responseDataPadding(MessageResponseSize, data)
+	if len(padding) != MessageInitiationSize-MessageResponseSize-MessageResponseDataOverhead-len(data) || padding[0] != 0x80 {
+		t.Errorf("padding = %x", padding)
+	}
+	if padding := device.responseDataPadding(MessageResponseSize, make([]byte, MaxResponseDataSize)); len(padding) != 1 {
+		t.Errorf("padding of data beyond the size = %x", padding)
+	}
+	unpadded, err := device.unpadResponseData(append(data, padding...))
+	if err != nil || !bytes.Equal(unpadded, data) {
+		t.Errorf("unpadded = %q, %v", unpadded, err)
+	}
+	if _, err := device.unpadResponseData([]byte("data\x00\x00")); err == nil {
+		t.Error("unpadded data without padding marker")
+	}
+}
@@ -0,0 +1,318 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+/* Latency-aware endpoint switching
+ *
+ * With a probe interval set, the peer's current endpoint and its previous
+ * endpoints (see endpoints.go) are probed with echo requests sent directly
+ * to each of them, and a smoothed round-trip time is kept per endpoint. The
+ * peer switches to a candidate once it has answered at least MinImprovement
+ * percent faster than the current endpoint for the whole Dwell time, or
+ * immediately if the current endpoint stops answering. Packets arriving from
+ * a candidate do not roam the peer while switching is enabled, so the policy
+ * alone decides between known paths and two similar ones do not flap.
+ *
+ * Every switch is logged and sent to observers as an endpoint_switched event
+ * carrying the endpoints and round-trip times before and after; the former
+ * is zero if the current endpoint stopped answering.
+ */
+
+// A SwitchPolicy tunes latency-aware endpoint switching for a peer.
+type SwitchPolicy struct {
+	ProbeInterval  time.Duration // time between probe rounds; zero disables switching
+	MinImprovement int           // percentage by which a candidate's round-trip time must be lower
+	Dwell          time.Duration // how long a candidate must stay better before switching to it
+}
+
+type peerPathSwitch struct {
+	sync.Mutex
+	enabled     atomic.Bool // policy.ProbeInterval is nonzero, readable without the mutex
+	policy      SwitchPolicy
+	probing     bool
+	rtt         map[string]time.Duration // smoothed round-trip time by endpoint destination
+	better      string                   // candidate currently beating the endpoint
+	betterSince time.Time
+}
+
+// SetSwitchPolicy sets the endpoint switching policy of the peer. A zero
+// ProbeInterval disables switching; a zero MinImprovement or Dwell selects
+// DefaultSwitchMinImprovement or DefaultSwitchDwell.
+func (peer *Peer) SetSwitchPolicy(policy SwitchPolicy) error {
+	if policy.ProbeInterval != 0 && policy.ProbeInterval < MinSwitchProbeInterval {
+		return fmt.Errorf("probe interval below minimum of %v", MinSwitchProbeInterval)
+	}
+	if policy.MinImprovement < 0 || policy.MinImprovement >= 100 {
+		return fmt.Errorf("minimum improvement of %d%% out of range", policy.MinImprovement)
+	}
+	if policy.Dwell < 0 {
+		return fmt.Errorf("negative dwell time")
+	}
+	if policy.MinImprovement == 0 {
+		policy.MinImprovement = DefaultSwitchMinImprovement
+	}
+	if policy.Dwell == 0 {
+		policy.Dwell = DefaultSwitchDwell
+	}
+
+	ps := &peer.pathSwitch
+	ps.Lock()
+	ps.policy = policy
+	ps.enabled.Store(policy.ProbeInterval != 0)
+	if policy.ProbeInterval == 0 {
+		ps.rtt = nil
+		ps.better = ""
+	}
+	ps.Unlock()
+
+	if policy.ProbeInterval == 0 {
+		peer.timers.probePaths.Del()
+	} else if peer.timersActive() {
+		peer.timers.probePaths.Mod(policy.ProbeInterval)
+	}
+	return nil
+}
+
+// SwitchPolicy returns the policy set with SetSwitchPolicy.
+func (peer *Peer) SwitchPolicy() SwitchPolicy {
+	peer.pathSwitch.Lock()
+	defer peer.pathSwitch.Unlock()
+	return peer.pathSwitch.policy
+}
+
+// EndpointRTTs returns the smoothed round-trip time measured to each probed
+// endpoint of the peer.
+func (peer *Peer) EndpointRTTs() map[string]time.Duration {
+	peer.pathSwitch.Lock()
+	defer peer.pathSwitch.Unlock()
+	rtts := make(map[string]time.Duration, len(peer.pathSwitch.rtt))
+	for dst, rtt := range peer.pathSwitch.rtt {
+		rtts[dst] = rtt
+	}
+	return rtts
+}
+
+// keepsEndpointHistory reports whether previous endpoints are recorded, for
+// either fallback or switching.
+func (peer *Peer) keepsEndpointHistory() bool {
+	return peer.endpointFallback.Load() || peer.pathSwitch.enabled.Load()
+}
+
+// isSwitchCandidateLocked reports whether roaming to endpoint should be left
+// to the switching policy. It must be called with peer.endpoint held.
+func (peer *Peer) isSwitchCandidateLocked(endpoint conn.Endpoint) bool {
+	if !peer.pathSwitch.enabled.Load() {
+		return false
+	}
+	dst := endpoint.DstToString()
+	for _, r := range peer.endpoint.history {
+		if r.val.DstToString() == dst {
+			return true
+		}
+	}
+	return false
+}
+
+// startPathSwitching arms the probe timer after a handshake if switching is
+// enabled and no probe round is scheduled.
+func (peer *Peer) startPathSwitching() {
+	if !peer.pathSwitch.enabled.Load() || !peer.timersActive() || peer.timers.probePaths.IsPending() {
+		return
+	}
+	peer.pathSwitch.Lock()
+	interval := peer.pathSwitch.policy.ProbeInterval
+	peer.pathSwitch.Unlock()
+	if interval != 0 {
+		peer.timers.probePaths.Mod(interval)
+	}
+}
+
+func expiredProbePaths(peer *Peer) {
+	ps := &peer.pathSwitch
+	ps.Lock()
+	if ps.probing || ps.policy.ProbeInterval == 0 {
+		ps.Unlock()
+		return
+	}
+	ps.probing = true
+	ps.Unlock()
+
+	// Probes wait up to PingTimeout for replies, which must not hold up
+	// stopping the timer.
+	go func() {
+		peer.probePaths()
+		ps.Lock()
+		ps.probing = false
+		interval := ps.policy.ProbeInterval
+		ps.Unlock()
+		if interval != 0 && peer.timersActive() {
+			peer.timers.probePaths.Mod(interval)
+		}
+	}()
+}
+
+type probeResult struct {
+	endpoint conn.Endpoint
+	rtt      time.Duration
+	ok       bool
+}
+
+// probePaths measures every endpoint of the peer and switches endpoints if
+// the policy calls for it.
+func (peer *Peer) probePaths() {
+	now := time.Now()
+	peer.endpoint.Lock()
+	current := peer.endpoint.val
+	var endpoints []conn.Endpoint
+	if current != nil {
+		endpoints = append(endpoints, current)
+		for _, r := range peer.endpoint.history {
+			if now.Sub(r.lastUsed) <= EndpointHistoryLifetime {
+				endpoints = append(endpoints, r.val)
+			}
+		}
+	}
+	peer.endpoint.Unlock()
+	if len(endpoints) < 2 {
+		return
+	}
+
+	results := make([]probeResult, len(endpoints))
+	var wg sync.WaitGroup
+	for i, ep := range endpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rtt, err := peer.ping(PingTimeout, ep)
+			results[i] = probeResult{endpoint: ep, rtt: rtt, ok: err == nil}
+		}()
+	}
+	wg.Wait()
+
+	// Candidates that answer remain eligible beyond EndpointHistoryLifetime.
+	peer.endpoint.Lock()
+	for i := range peer.endpoint.history {
+		for _, r := range results[1:] {
+			if r.ok && peer.endpoint.history[i].val == r.endpoint {
+				peer.endpoint.history[i].lastUsed = now
+			}
+		}
+	}
+	peer.endpoint.Unlock()
+
+	peer.pathSwitch.Lock()
+	d := peer.pathSwitch.updateLocked(results, now)
+	peer.pathSwitch.Unlock()
+	if d != nil {
+		peer.switchEndpoint(current, d, now)
+	}
+}
+
+// A switchDecision describes a switch from the current endpoint, whose
+// round-trip time is zero if it did not answer, to a faster candidate.
+type switchDecision struct {
+	to             conn.Endpoint
+	fromRTT, toRTT time.Duration
+}
+
+// updateLocked folds a probe round into the smoothed round-trip times and
+// applies the policy. The first result is for the current endpoint. It
+// returns the switch to make, if any.
+func (ps *peerPathSwitch) updateLocked(results []probeResult, now time.Time) *switchDecision {
+	if ps.rtt == nil {
+		ps.rtt = make(map[string]time.Duration)
+	}
+	seen := make(map[string]bool, len(results))
+	for _, r := range results {
+		dst := r.endpoint.DstToString()
+		seen[dst] = true
+		if !r.ok {
+			delete(ps.rtt, dst)
+			continue
+		}
+		if srtt, ok := ps.rtt[dst]; ok {
+			ps.rtt[dst] = (7*srtt + r.rtt) / 8
+		} else {
+			ps.rtt[dst] = r.rtt
+		}
+	}
+	for dst := range ps.rtt {
+		if !seen[dst] {
+			delete(ps.rtt, dst)
+		}
+	}
+
+	currentRTT, currentOK := ps.rtt[results[0].endpoint.DstToString()]
+	var best conn.Endpoint
+	var bestRTT time.Duration
+	for _, r := range results[1:] {
+		if rtt, ok := ps.rtt[r.endpoint.DstToString()]; ok && (best == nil || rtt < bestRTT) {
+			best, bestRTT = r.endpoint, rtt
+		}
+	}
+
+	switch {
+	case best == nil:
+	case !currentOK:
+		ps.better = ""
+		return &switchDecision{to: best, toRTT: bestRTT}
+	case bestRTT*100 <= currentRTT*time.Duration(100-ps.policy.MinImprovement):
+		if ps.better != best.DstToString() {
+			ps.better = best.DstToString()
+			ps.betterSince = now
+		}
+		if now.Sub(ps.betterSince) >= ps.policy.Dwell {
+			ps.better = ""
+			return &switchDecision{to: best, fromRTT: currentRTT, toRTT: bestRTT}
+		}
+		return nil
+	}
+	ps.better = ""
+	return nil
+}
+
+// switchEndpoint makes the switch described by d, unless the peer has moved
+// away from current in the meantime.
+func (peer *Peer) switchEndpoint(current conn.Endpoint, d *switchDecision, now time.Time) {
+	peer.endpoint.Lock()
+	if peer.endpoint.val != current {
+		peer.endpoint.Unlock()
+		return
+	}
+	history := peer.endpoint.history[:0]
+	for _, r := range peer.endpoint.history {
+		if r.val != d.to {
+			history = append(history, r)
+		}
+	}
+	peer.endpoint.history = history
+	peer.rememberEndpointLocked(current, now)
+	peer.endpoint.val = d.to
+	peer.emitEndpointChangedLocked(current)
+	peer.endpoint.Unlock()
+
+	from, to := current.DstToString(), d.to.DstToString()
+	if d.fromRTT != 0 {
+		peer.device.log.conn.verboseAttrs([]slog.Attr{peerAttr(peer), endpointAttr(to)}, "%v - Switching endpoint from %v (rtt %v) to %v (rtt %v)", peer, from, d.fromRTT, to, d.toRTT)
+	} else {
+		peer.device.log.conn.verboseAttrs([]slog.Attr{peerAttr(peer), endpointAttr(to)}, "%v - Switching endpoint from unresponsive %v to %v (rtt %v)", peer, from, to, d.toRTT)
+	}
+	peer.device.notifyObservers("endpoint_switched", peer,
+		"from_endpoint="+from,
+		fmt.Sprintf("from_rtt_nsec=%d", d.fromRTT.Nanoseconds()),
+		"to_endpoint="+to,
+		fmt.Sprintf("to_rtt_nsec=%d", d.toRTT.Nanoseconds()))
+}
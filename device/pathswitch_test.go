@@ -0,0 +1,138 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+)
+
+func TestSwitchHysteresis(t *testing.T) {
+	current, candidate := bindtest.ChannelEndpoint(1), bindtest.ChannelEndpoint(2)
+	ps := peerPathSwitch{policy: SwitchPolicy{ProbeInterval: time.Second, MinImprovement: 20, Dwell: 10 * time.Second}}
+	round := func(now time.Time, cur, cand time.Duration, curOK bool) *switchDecision {
+		return ps.updateLocked([]probeResult{
+			{endpoint: current, rtt: cur, ok: curOK},
+			{endpoint: candidate, rtt: cand, ok: true},
+		}, now)
+	}
+
+	start := time.Now()
+	if d := round(start, 100*time.Millisecond, 90*time.Millisecond, true); d != nil {
+		t.Fatal("switched for an improvement below the threshold")
+	}
+	// Reset the smoothed times to a 50% improvement.
+	ps.rtt = nil
+	if d := round(start, 100*time.Millisecond, 50*time.Millisecond, true); d != nil {
+		t.Fatal("switched before the dwell time")
+	}
+	if d := round(start.Add(5*time.Second), 100*time.Millisecond, 50*time.Millisecond, true); d != nil {
+		t.Fatal("switched before the dwell time")
+	}
+	d := round(start.Add(10*time.Second), 100*time.Millisecond, 50*time.Millisecond, true)
+	if d == nil || d.to != candidate || d.fromRTT != 100*time.Millisecond || d.toRTT != 50*time.Millisecond {
+		t.Fatalf("unexpected decision after the dwell time: %+v", d)
+	}
+
+	// A candidate that stops being better restarts the dwell time.
+	ps.rtt = nil
+	round(start, 100*time.Millisecond, 50*time.Millisecond, true)
+	ps.rtt = nil
+	round(start.Add(5*time.Second), 100*time.Millisecond, 100*time.Millisecond, true)
+	ps.rtt = nil
+	if d := round(start.Add(10*time.Second), 100*time.Millisecond, 50*time.Millisecond, true); d != nil {
+		t.Fatal("dwell time not restarted")
+	}
+
+	ps.rtt = nil
+	d = round(start, 0, 90*time.Millisecond, false)
+	if d == nil || d.to != candidate || d.fromRTT != 0 {
+		t.Fatalf("no failover from an unresponsive endpoint: %+v", d)
+	}
+}
+
+func TestSwitchPolicy(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pub1 := pair[1].dev.staticIdentity.publicKey
+	for _, bad := range [][2]string{
+		{"switch_min_improvement", "100"},
+		{"switch_probe_interval", "nope"},
+	} {
+		if err := pair[0].dev.IpcSet(uapiCfg("public_key", hex.EncodeToString(pub1[:]), bad[0], bad[1])); err == nil {
+			t.Errorf("%s=%s accepted", bad[0], bad[1])
+		}
+	}
+	if err := pair[0].dev.IpcSet(uapiCfg(
+		"public_key", hex.EncodeToString(pub1[:]),
+		"switch_probe_interval", "60",
+		"switch_dwell", "120",
+	)); err != nil {
+		t.Fatal(err)
+	}
+	pair.Send(t, Ping, nil)
+
+	peer := pair[0].dev.LookupPeer(pub1)
+	want := SwitchPolicy{ProbeInterval: time.Minute, MinImprovement: DefaultSwitchMinImprovement, Dwell: 2 * time.Minute}
+	if policy := peer.SwitchPolicy(); policy != want {
+		t.Errorf("policy = %+v, want %+v", policy, want)
+	}
+
+	// Both channels of the test bind reach the other device.
+	peer.endpoint.Lock()
+	current := peer.endpoint.val
+	candidate := bindtest.ChannelEndpoint(1)
+	if current == candidate {
+		candidate = bindtest.ChannelEndpoint(3)
+	}
+	peer.rememberEndpointLocked(candidate, time.Now())
+	peer.endpoint.Unlock()
+
+	peer.SetEndpointFromPacket(candidate)
+	if peer.endpoint.val != current {
+		t.Fatal("roamed to a switching candidate")
+	}
+
+	peer.probePaths()
+	rtts := peer.EndpointRTTs()
+	if len(rtts) != 2 || rtts[current.DstToString()] == 0 || rtts[candidate.DstToString()] == 0 {
+		t.Fatalf("unexpected round-trip times: %v", rtts)
+	}
+	if peer.endpoint.val != current {
+		t.Fatal("switched endpoints after a single probe round")
+	}
+	cfg, err := pair[0].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(cfg, "switch_dwell=120\n") || !strings.Contains(cfg, "endpoint_rtt="+candidate.DstToString()+" ") {
+		t.Errorf("switching state missing from get output:\n%s", cfg)
+	}
+
+	events := pair[0].dev.addObserver()
+	defer pair[0].dev.removeObserver(events)
+	peer.switchEndpoint(current, &switchDecision{to: candidate, fromRTT: 2 * time.Millisecond, toRTT: time.Millisecond}, time.Now())
+	if peer.endpoint.val != candidate {
+		t.Fatal("endpoint not switched")
+	}
+	if prev := peer.PreviousEndpoints(); len(prev) != 1 || prev[0] != current.DstToString() {
+		t.Errorf("unexpected previous endpoints after switch: %v", prev)
+	}
+	select {
+	case block := <-events:
+		if !bytes.HasPrefix(block, []byte("event=endpoint_switched\n")) || !bytes.Contains(block, []byte("from_rtt_nsec=2000000\nto_endpoint="+candidate.DstToString()+"\n")) {
+			t.Errorf("unexpected event block:\n%s", block)
+		}
+	default:
+		t.Error("no endpoint_switched event")
+	}
+	pair.Send(t, Pong, nil)
+}
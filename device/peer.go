@@ -7,10 +7,12 @@ package device
 
 import (
 	"container/list"
-	"errors"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"golang.zx2c4.com/wireguard/conn"
 )
@@ -30,6 +32,7 @@ type Peer struct {
 		val            conn.Endpoint
 		clearSrcOnTx   bool // signal to val.ClearSrc() prior to next packet transmission
 		disableRoaming bool
+		history        []endpointRecord // previous endpoints, for endpoint fallback or switching
 	}
 
 	timers struct {
@@ -38,6 +41,7 @@ type Peer struct {
 		newHandshake            *Timer
 		zeroKeyMaterial         *Timer
 		persistentKeepalive     *Timer
+		probePaths              *Timer
 		handshakeAttempts       atomic.Uint32
 		needAnotherKeepalive    atomic.Bool
 		sentLastMinuteHandshake atomic.Bool
@@ -47,21 +51,54 @@ type Peer struct {
 		sync.Mutex // protects against concurrent Start/Stop
 	}
 
+	ipcMutex sync.Mutex // serializes UAPI set operations on this peer
+
 	queue struct {
-		staged   chan *QueueOutboundElementsContainer // staged packets before a handshake is available
-		outbound *autodrainingOutboundQueue           // sequential ordering of udp transmission
-		inbound  *autodrainingInboundQueue            // sequential ordering of tun writing
+		staged   stagedQueue                // staged packets before a handshake is available
+		outbound *autodrainingOutboundQueue // sequential ordering of udp transmission
+		inbound  *autodrainingInboundQueue  // sequential ordering of tun writing
 	}
 
 	cookieGenerator             CookieGenerator
+	mac1PublicKey               NoisePublicKey // if nonzero, keys MAC1 and cookie replies instead of the peer's public key; guarded by handshake.mutex
 	trieEntries                 list.List
 	persistentKeepaliveInterval atomic.Uint32
+	mssClampMTU                 atomic.Uint32 // if nonzero, clamp TCP MSS of traffic with this peer to fit
+	eagerKeyErasure             atomic.Bool   // discard the previous keypair as soon as its successor is confirmed, see keypair.go
+	endpointFallback            atomic.Bool   // retry previous endpoints when transmission fails
+	name                        atomic.Pointer[string]
+	metadata                    atomic.Pointer[[]byte]         // opaque blob attached by the embedder, such as an identity attestation
+	metadataRejections          atomic.Uint64                  // handshakes dropped because the metadata verifier failed
+	maxQueueAge                 atomic.Int64                   // nanoseconds packets may wait in queues; zero for no limit
+	staleDrops                  atomic.Uint64                  // packets discarded for exceeding maxQueueAge
+	suite                       atomic.Pointer[CipherSuite]    // cipher suite of new sessions; nil for the standard one
+	suiteOffer                  atomic.Pointer[[]*CipherSuite] // suites negotiated in handshakes, in order of preference; nil to not negotiate
+	pqMode                      atomic.Int32                   // PQMode
+	pings                       peerPings
+	handshakeWait               peerHandshakeWait
+	pathSwitch                  peerPathSwitch
+	quarantine                  peerQuarantine
+	inboundLimit                peerInboundLimit
+	encryptionShare             encryptionShare
+	pacer                       peerPacer
+	sizes                       packetSizes
+	reorder                     peerReorder
+	icmpErrors                  peerICMPErrors
+	crashes                     atomic.Uint64 // panics recovered by workers processing its packets
+	assignment                  peerAssignment
+	acceptAssignment            atomic.Bool // request an address assignment from the peer after each handshake
+
+	responseData struct {
+		send     atomic.Pointer[[]byte] // attached to handshake responses we send
+		received atomic.Pointer[[]byte] // from the last handshake response received
+	}
 }
 
 func (device *Device) NewPeer(pk NoisePublicKey) (*Peer, error) {
 	if device.isClosed() {
-		return nil, errors.New("device closed")
+		return nil, ErrDeviceClosed
 	}
+	lastTimestamp := device.loadHandshakeTimestamp(pk)
 
 	// lock resources
 	device.staticIdentity.RLock()
@@ -71,23 +108,24 @@ func (device *Device) NewPeer(pk NoisePublicKey) (*Peer, error) {
 	defer device.peers.Unlock()
 
 	// check if over limit
-	if len(device.peers.keyMap) >= MaxPeers {
-		return nil, errors.New("too many peers")
+	if len(device.peers.keyMap) >= device.limits.MaxPeers {
+		return nil, ErrTooManyPeers
 	}
 
 	// create peer
 	peer := new(Peer)
 
 	peer.cookieGenerator.Init(pk)
+	peer.cookieGenerator.setMAC(device.CookieMAC())
+	peer.encryptionShare.index = -1
 	peer.device = device
 	peer.queue.outbound = newAutodrainingOutboundQueue(device)
 	peer.queue.inbound = newAutodrainingInboundQueue(device)
-	peer.queue.staged = make(chan *QueueOutboundElementsContainer, QueueStagedSize)
 
 	// map public key
 	_, ok := device.peers.keyMap[pk]
 	if ok {
-		return nil, errors.New("adding existing peer")
+		return nil, ErrPeerExists
 	}
 
 	// pre-compute DH
@@ -95,6 +133,7 @@ func (device *Device) NewPeer(pk NoisePublicKey) (*Peer, error) {
 	handshake.mutex.Lock()
 	handshake.precomputedStaticStatic, _ = device.staticIdentity.privateKey.sharedSecret(pk)
 	handshake.remoteStatic = pk
+	handshake.lastTimestamp = lastTimestamp
 	handshake.mutex.Unlock()
 
 	// reset endpoint
@@ -109,6 +148,7 @@ func (device *Device) NewPeer(pk NoisePublicKey) (*Peer, error) {
 
 	// add
 	device.peers.keyMap[pk] = peer
+	device.notifyObservers("peer_added", peer)
 
 	return peer, nil
 }
@@ -125,7 +165,7 @@ func (peer *Peer) SendBuffers(buffers [][]byte) error {
 	endpoint := peer.endpoint.val
 	if endpoint == nil {
 		peer.endpoint.Unlock()
-		return errors.New("no known endpoint for peer")
+		return ErrNoEndpoint
 	}
 	if peer.endpoint.clearSrcOnTx {
 		endpoint.ClearSrc()
@@ -133,17 +173,70 @@ func (peer *Peer) SendBuffers(buffers [][]byte) error {
 	}
 	peer.endpoint.Unlock()
 
-	err := peer.device.net.bind.Send(buffers, endpoint)
+	err := peer.device.sendTo(buffers, endpoint)
+	if err != nil && peer.endpointFallback.Load() {
+		err = peer.sendToPreviousEndpoints(buffers, endpoint, err)
+	}
 	if err == nil {
-		var totalLen uint64
-		for _, b := range buffers {
-			totalLen += uint64(len(b))
-		}
-		peer.txBytes.Add(totalLen)
+		peer.countTx(buffers)
 	}
 	return err
 }
 
+// sendBuffersTo sends buffers to endpoint rather than the peer's current
+// endpoint, without falling back to previous endpoints.
+func (peer *Peer) sendBuffersTo(buffers [][]byte, endpoint conn.Endpoint) error {
+	peer.device.net.RLock()
+	defer peer.device.net.RUnlock()
+
+	if peer.device.isClosed() {
+		return nil
+	}
+	err := peer.device.sendTo(buffers, endpoint)
+	if err == nil {
+		peer.countTx(buffers)
+	}
+	return err
+}
+
+func (peer *Peer) countTx(buffers [][]byte) {
+	var totalLen uint64
+	for _, b := range buffers {
+		totalLen += uint64(len(b))
+	}
+	peer.txBytes.Add(totalLen)
+}
+
+// Name returns the display name assigned to the peer, or "" if none.
+func (peer *Peer) Name() string {
+	if name := peer.name.Load(); name != nil {
+		return *name
+	}
+	return ""
+}
+
+// SetName assigns a display name to the peer, for use by monitoring tools in
+// place of its public key. The empty string clears it.
+func (peer *Peer) SetName(name string) error {
+	if len(name) > MaxPeerNameLength {
+		return fmt.Errorf("%w: too long", ErrInvalidName)
+	}
+	if !utf8.ValidString(name) {
+		return fmt.Errorf("%w: not valid UTF-8", ErrInvalidName)
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) {
+			return fmt.Errorf("%w: contains unprintable characters", ErrInvalidName)
+		}
+	}
+	if name == "" {
+		peer.name.Store(nil)
+	} else {
+		peer.name.Store(&name)
+	}
+	return nil
+}
+
 func (peer *Peer) String() string {
 	// The awful goo that follows is identical to:
 	//
@@ -276,6 +369,41 @@ func (peer *Peer) Stop() {
 	peer.ZeroAndFlushAll()
 }
 
+// SetChannelBinding sets a value, typically a TLS exporter output from the
+// protocol used to enrol this peer's public key, that is mixed into every
+// subsequent handshake transcript with the peer. Handshakes only complete if
+// both sides use the same value, so a key substituted during enrolment is
+// rejected by the tunnel itself. The zero value disables channel binding,
+// which is required for interoperability with standard WireGuard.
+func (peer *Peer) SetChannelBinding(binding [32]byte) {
+	peer.handshake.mutex.Lock()
+	peer.handshake.channelBinding = binding
+	peer.handshake.mutex.Unlock()
+}
+
+// SetMAC1PublicKey sets the public key that MACs of handshake messages to the
+// peer are computed with, and that cookie replies from it are decrypted
+// with, in place of the peer's own public key. This lets a front end, such as
+// a load balancer holding the given key, check MAC1 and reply with cookies
+// before forwarding handshakes to the peer, while the Noise handshake itself
+// still authenticates the peer's key. The zero value restores the default.
+func (peer *Peer) SetMAC1PublicKey(pk NoisePublicKey) {
+	peer.handshake.mutex.Lock()
+	defer peer.handshake.mutex.Unlock()
+	peer.mac1PublicKey = pk
+	if pk.IsZero() {
+		pk = peer.handshake.remoteStatic
+	}
+	peer.cookieGenerator.Init(pk)
+}
+
+// MAC1PublicKey returns the key set with SetMAC1PublicKey.
+func (peer *Peer) MAC1PublicKey() NoisePublicKey {
+	peer.handshake.mutex.RLock()
+	defer peer.handshake.mutex.RUnlock()
+	return peer.mac1PublicKey
+}
+
 func (peer *Peer) SetEndpointFromPacket(endpoint conn.Endpoint) {
 	peer.endpoint.Lock()
 	defer peer.endpoint.Unlock()
@@ -283,7 +411,17 @@ func (peer *Peer) SetEndpointFromPacket(endpoint conn.Endpoint) {
 		return
 	}
 	peer.endpoint.clearSrcOnTx = false
+	old := peer.endpoint.val
+	if old != nil && old.DstToString() != endpoint.DstToString() {
+		if peer.isSwitchCandidateLocked(endpoint) {
+			return
+		}
+		if peer.keepsEndpointHistory() {
+			peer.rememberEndpointLocked(old, time.Now())
+		}
+	}
 	peer.endpoint.val = endpoint
+	peer.emitEndpointChangedLocked(old)
 }
 
 func (peer *Peer) markEndpointSrcForClearing() {
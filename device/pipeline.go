@@ -0,0 +1,242 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+/* Pipeline introspection
+ *
+ * Packets go through a pipeline of stages connected by queues: the TUN
+ * reader, the encryption workers and each peer's sequential sender on the
+ * way out, the UDP receivers, the decryption workers and each peer's
+ * sequential receiver on the way in, and the handshake workers. Each stage
+ * counts the packets it processes and the goroutines running it, and, while
+ * a pipeline observer is attached, the time they spend busy with them;
+ * PipelineStats snapshots the counters along with the length of each queue.
+ *
+ * The UAPI "pipeline=1" operation attaches an observer. After it is
+ * acknowledged, the device writes a block every PipelineInterval, a series
+ * of key=value lines terminated by a blank line, until the connection is
+ * closed:
+ *
+ *	pipeline=<unix nanoseconds>
+ *	interval_nsec=<nanoseconds since the previous block>
+ *	queue=<name>:<length>:<capacity>
+ *	peer_queue=<public key>:<staged>:<outbound>:<inbound>
+ *	stage=<name>:<goroutines>:<packets>:<packets per second>:<utilization>
+ *
+ * Queue lengths are in batches of packets, except for staged packets. Peer
+ * queues are listed only for peers with queued packets. Rates and
+ * utilizations, the percentage of the interval the goroutines of a stage
+ * were busy, cover the interval; they are zero in the first block.
+ */
+
+type pipelineStage int
+
+const (
+	stageTUNRead pipelineStage = iota
+	stageEncryption
+	stageSend
+	stageReceive
+	stageDecryption
+	stageTUNWrite
+	stageHandshake
+	numPipelineStages
+)
+
+var pipelineStageNames = [numPipelineStages]string{
+	stageTUNRead:    "tun_read",
+	stageEncryption: "encryption",
+	stageSend:       "send",
+	stageReceive:    "receive",
+	stageDecryption: "decryption",
+	stageTUNWrite:   "tun_write",
+	stageHandshake:  "handshake",
+}
+
+func (s pipelineStage) String() string {
+	return pipelineStageNames[s]
+}
+
+type stageCounters struct {
+	goroutines atomic.Int32
+	packets    atomic.Uint64
+	busy       atomic.Int64 // nanoseconds, counted while observed
+}
+
+type devicePipeline struct {
+	observers atomic.Int32
+	stages    [numPipelineStages]stageCounters
+}
+
+// enter accounts for a goroutine starting to run stage.
+func (p *devicePipeline) enter(stage pipelineStage) {
+	p.stages[stage].goroutines.Add(1)
+}
+
+// exit accounts for a goroutine running stage stopping.
+func (p *devicePipeline) exit(stage pipelineStage) {
+	p.stages[stage].goroutines.Add(-1)
+}
+
+// begin returns the time a stage starts processing packets at, or the zero
+// Time if no pipeline observer is attached.
+func (p *devicePipeline) begin() time.Time {
+	if p.observers.Load() == 0 {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// done accounts for packets processed by stage since start, as returned by
+// begin.
+func (p *devicePipeline) done(stage pipelineStage, packets int, start time.Time) {
+	s := &p.stages[stage]
+	s.packets.Add(uint64(packets))
+	if !start.IsZero() {
+		s.busy.Add(int64(time.Since(start)))
+	}
+}
+
+// PipelineStats is a snapshot of the queues and stages of the pipeline
+// packets go through.
+type PipelineStats struct {
+	Time   time.Time
+	Queues []QueueStats     // queues shared by all peers
+	Peers  []PeerQueueStats // queues of peers with queued packets
+	Stages []StageStats
+}
+
+// QueueStats describes a queue of batches of packets.
+type QueueStats struct {
+	Name     string
+	Length   int
+	Capacity int
+}
+
+// PeerQueueStats describes the queues of a peer.
+type PeerQueueStats struct {
+	PublicKey NoisePublicKey
+	Staged    int // packets awaiting a session
+	Outbound  int // batches awaiting transmission, in order
+	Inbound   int // batches awaiting the TUN device, in order
+}
+
+// StageStats describes a stage of the pipeline.
+type StageStats struct {
+	Name       string
+	Goroutines int           // running the stage
+	Packets    uint64        // processed by the stage
+	Busy       time.Duration // spent processing packets while observed
+}
+
+// PipelineStats returns a snapshot of the queues and stages of the device.
+func (device *Device) PipelineStats() PipelineStats {
+	stats := PipelineStats{
+		Time: time.Now(),
+		Queues: []QueueStats{
+			{"encryption", len(device.queue.encryption.c), cap(device.queue.encryption.c)},
+			{"decryption", len(device.queue.decryption.c), cap(device.queue.decryption.c)},
+			{"handshake", len(device.queue.handshake.c), cap(device.queue.handshake.c)},
+		},
+		Stages: make([]StageStats, numPipelineStages),
+	}
+	for stage := range numPipelineStages {
+		s := &device.pipeline.stages[stage]
+		stats.Stages[stage] = StageStats{
+			Name:       stage.String(),
+			Goroutines: int(s.goroutines.Load()),
+			Packets:    s.packets.Load(),
+			Busy:       time.Duration(s.busy.Load()),
+		}
+	}
+	device.peers.RLock()
+	for pk, peer := range device.peers.keyMap {
+		q := PeerQueueStats{
+			PublicKey: pk,
+			Staged:    peer.queue.staged.len(),
+			Outbound:  len(peer.queue.outbound.c),
+			Inbound:   len(peer.queue.inbound.c),
+		}
+		if q.Staged != 0 || q.Outbound != 0 || q.Inbound != 0 {
+			stats.Peers = append(stats.Peers, q)
+		}
+	}
+	device.peers.RUnlock()
+	slices.SortFunc(stats.Peers, func(a, b PeerQueueStats) int { return bytes.Compare(a.PublicKey[:], b.PublicKey[:]) })
+	return stats
+}
+
+// writePipelineBlock writes stats to w as a block of a pipeline=1 stream,
+// with rates relative to prev unless it is nil.
+func writePipelineBlock(w io.Writer, stats, prev *PipelineStats) error {
+	buf := new(bytes.Buffer)
+	var interval time.Duration
+	if prev != nil {
+		interval = stats.Time.Sub(prev.Time)
+	}
+	fmt.Fprintf(buf, "pipeline=%d\ninterval_nsec=%d\n", stats.Time.UnixNano(), interval)
+	for _, q := range stats.Queues {
+		fmt.Fprintf(buf, "queue=%s:%d:%d\n", q.Name, q.Length, q.Capacity)
+	}
+	for _, q := range stats.Peers {
+		fmt.Fprintf(buf, "peer_queue=%x:%d:%d:%d\n", q.PublicKey[:], q.Staged, q.Outbound, q.Inbound)
+	}
+	for i, s := range stats.Stages {
+		var rate, utilization float64
+		if prev != nil && interval > 0 {
+			rate = float64(s.Packets-prev.Stages[i].Packets) / interval.Seconds()
+			if s.Goroutines > 0 {
+				utilization = 100 * float64(s.Busy-prev.Stages[i].Busy) / float64(interval*time.Duration(s.Goroutines))
+			}
+		}
+		fmt.Fprintf(buf, "stage=%s:%d:%d:%.0f:%.1f\n", s.Name, s.Goroutines, s.Packets, rate, min(utilization, 100))
+	}
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ipcPipeline streams pipeline blocks to w until r is closed, a write fails,
+// or the device is closed. Anything read from r is discarded.
+func (device *Device) ipcPipeline(w io.Writer, r io.Reader) {
+	device.pipeline.observers.Add(1)
+	defer device.pipeline.observers.Add(-1)
+
+	hangup := make(chan struct{})
+	go func() {
+		io.Copy(io.Discard, r)
+		close(hangup)
+	}()
+
+	ticker := time.NewTicker(PipelineInterval)
+	defer ticker.Stop()
+	stats := device.PipelineStats()
+	if writePipelineBlock(w, &stats, nil) != nil {
+		return
+	}
+	for {
+		select {
+		case <-hangup:
+			return
+		case <-device.closed:
+			return
+		case <-ticker.C:
+			prev := stats
+			stats = device.PipelineStats()
+			if writePipelineBlock(w, &stats, &prev) != nil {
+				return
+			}
+		}
+	}
+}
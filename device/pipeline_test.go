@@ -0,0 +1,112 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipelineStats(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pair[0].dev.pipeline.observers.Add(1)
+	defer pair[0].dev.pipeline.observers.Add(-1)
+	pair.Send(t, Ping, nil)
+
+	stages := func(d *Device) map[string]StageStats {
+		m := make(map[string]StageStats)
+		for _, s := range d.PipelineStats().Stages {
+			m[s.Name] = s
+		}
+		return m
+	}
+	// Stages account for packets once done with them, which may be after
+	// they are delivered.
+	waitFor(t, func() bool {
+		sender, receiver := stages(pair[1].dev), stages(pair[0].dev)
+		return sender["send"].Packets != 0 && receiver["tun_write"].Packets != 0
+	})
+	sender, receiver := stages(pair[1].dev), stages(pair[0].dev)
+	for _, name := range []string{"tun_read", "encryption", "send"} {
+		if sender[name].Packets == 0 {
+			t.Errorf("sender stage %s processed no packets", name)
+		}
+		if sender[name].Busy != 0 {
+			t.Errorf("unobserved sender stage %s timed", name)
+		}
+	}
+	for _, name := range []string{"receive", "decryption", "tun_write"} {
+		if s := receiver[name]; s.Packets == 0 || s.Busy == 0 {
+			t.Errorf("receiver stage %s: %d packets, busy %v", name, s.Packets, s.Busy)
+		}
+	}
+	if s := receiver["handshake"]; s.Packets == 0 || s.Goroutines == 0 {
+		t.Errorf("handshake stage: %d packets, %d goroutines", s.Packets, s.Goroutines)
+	}
+}
+
+func TestIpcPipeline(t *testing.T) {
+	dev := randDevice(t)
+	defer dev.Close()
+
+	client, server := net.Pipe()
+	defer client.Close()
+	go dev.IpcHandle(server)
+
+	if _, err := client.Write([]byte("pipeline=1\n\n")); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(client)
+	readBlock := func() []string {
+		var lines []string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\n" {
+				return lines
+			}
+			lines = append(lines, strings.TrimSuffix(line, "\n"))
+		}
+	}
+
+	if ack := readBlock(); len(ack) != 1 || ack[0] != "errno=0" {
+		t.Fatalf("unexpected acknowledgement: %q", ack)
+	}
+	for i := range 2 {
+		block := readBlock()
+		if len(block) < 2 || !strings.HasPrefix(block[0], "pipeline=") || !strings.HasPrefix(block[1], "interval_nsec=") {
+			t.Fatalf("block %d has no header: %q", i, block)
+		}
+		if i == 0 && block[1] != "interval_nsec=0" {
+			t.Errorf("first block has an interval: %q", block[1])
+		}
+		var queues, stages int
+		for _, line := range block[2:] {
+			switch {
+			case strings.HasPrefix(line, "queue="):
+				queues++
+			case strings.HasPrefix(line, "stage="):
+				if n := strings.Count(line, ":"); n != 4 {
+					t.Errorf("stage line with %d fields: %q", n+1, line)
+				}
+				stages++
+			}
+		}
+		if queues != 3 || stages != int(numPipelineStages) {
+			t.Errorf("block %d has %d queues and %d stages", i, queues, stages)
+		}
+	}
+	if dev.pipeline.observers.Load() != 1 {
+		t.Error("pipeline observer not counted")
+	}
+}
@@ -0,0 +1,26 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+// Poly1795VerifyBatch is like Poly1305VerifyBatch for the experimental
+// Poly1795 MAC and its 24-byte tags.
+func Poly1795VerifyBatch(entries []MACBatchEntry, ok []bool) bool {
+	var mac poly1795MAC
+	all := true
+	for i := range entries {
+		e := &entries[i]
+		mac.init(e.Key)
+		mac.Write(e.Message)
+		valid := mac.Verify(e.Tag)
+		if ok != nil {
+			ok[i] = valid
+		}
+		all = all && valid
+	}
+	return all
+}
@@ -0,0 +1,20 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+func poly1795SumTo(tag []byte, msg []byte, key *[32]byte) {
+	Poly1795Sum((*[24]byte)(tag), msg, key)
+}
+
+func init() {
+	batchMACs = append(batchMACs, batchMAC{"Poly1795", 24, poly1795SumTo, Poly1795VerifyBatch, func(e MACBatchEntry) bool {
+		var sum [24]byte
+		Poly1795Sum(&sum, e.Message, e.Key)
+		return string(sum[:]) == string(e.Tag)
+	}})
+}
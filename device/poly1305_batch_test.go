@@ -0,0 +1,115 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/poly1305"
+)
+
+// genMACBatch returns n entries of size-byte messages with random keys and
+// correct tags computed by sum.
+func genMACBatch(n, size, tagSize int, sum func(tag []byte, msg []byte, key *[32]byte)) []MACBatchEntry {
+	entries := make([]MACBatchEntry, n)
+	for i := range entries {
+		key := new([32]byte)
+		rand.Read(key[:])
+		msg := make([]byte, size)
+		rand.Read(msg)
+		tag := make([]byte, tagSize)
+		sum(tag, msg, key)
+		entries[i] = MACBatchEntry{Key: key, Message: msg, Tag: tag}
+	}
+	return entries
+}
+
+func poly1305SumTo(tag []byte, msg []byte, key *[32]byte) {
+	poly1305.Sum((*[16]byte)(tag), msg, key)
+}
+
+// A batchMAC is a MAC with batch verification, and how to compute and
+// verify its tags one at a time.
+type batchMAC struct {
+	name    string
+	tagSize int
+	sum     func([]byte, []byte, *[32]byte)
+	verify  func([]MACBatchEntry, []bool) bool
+	single  func(MACBatchEntry) bool
+}
+
+// batchMACs are the MACs with batch verification in the build; files gated
+// by build tags add theirs.
+var batchMACs = []batchMAC{
+	{"Poly1305", 16, poly1305SumTo, Poly1305VerifyBatch, func(e MACBatchEntry) bool {
+		mac := poly1305.New(e.Key)
+		mac.Write(e.Message)
+		return mac.Verify(e.Tag)
+	}},
+}
+
+func TestVerifyBatch(t *testing.T) {
+	for _, tc := range batchMACs {
+		t.Run(tc.name, func(t *testing.T) {
+			var entries []MACBatchEntry
+			for _, size := range []int{0, 1, 15, 16, 23, 24, 25, 100, 1420} {
+				entries = append(entries, genMACBatch(1, size, tc.tagSize, tc.sum)...)
+			}
+			ok := make([]bool, len(entries))
+			if !tc.verify(entries, ok) {
+				t.Fatalf("valid batch rejected: %v", ok)
+			}
+
+			entries[2].Tag[0] ^= 1
+			entries[5].Message = append([]byte(nil), entries[5].Message...)
+			entries[5].Message[0] ^= 1
+			entries[7].Tag = entries[7].Tag[:len(entries[7].Tag)-1]
+			if tc.verify(entries, ok) {
+				t.Fatal("corrupted batch accepted")
+			}
+			for i, valid := range ok {
+				if want := i != 2 && i != 5 && i != 7; valid != want {
+					t.Errorf("entry %d: verified %v, want %v", i, valid, want)
+				}
+			}
+			if tc.verify(entries, nil) {
+				t.Error("corrupted batch accepted without results")
+			}
+		})
+	}
+}
+
+func BenchmarkVerifyBatch(b *testing.B) {
+	const batch = 64
+	for _, tc := range batchMACs {
+		for _, size := range []int{64, 1420} {
+			entries := genMACBatch(batch, size, tc.tagSize, tc.sum)
+			b.Run(fmt.Sprintf("%s/size=%d/single", tc.name, size), func(b *testing.B) {
+				b.SetBytes(batch * int64(size))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					for _, e := range entries {
+						if !tc.single(e) {
+							b.Fatal("verification failed")
+						}
+					}
+				}
+			})
+			b.Run(fmt.Sprintf("%s/size=%d/batch", tc.name, size), func(b *testing.B) {
+				ok := make([]bool, batch)
+				b.SetBytes(batch * int64(size))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if !tc.verify(entries, ok) {
+						b.Fatal("verification failed")
+					}
+				}
+			})
+		}
+	}
+}
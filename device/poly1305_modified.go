@@ -1,3 +1,5 @@
+//go:build wg_experimental
+
 // Package poly1305_modified is a copy of golang.org/x/crypto/poly1305 for modification and benchmarking.
 // This is the original implementation, unmodified.
 // Copyright (c) The Go Authors. All rights reserved.
@@ -7,7 +9,10 @@
 package device
 
 import (
+	"crypto/subtle"
 	"encoding/binary"
+	"hash"
+
 	"golang.org/x/crypto/poly1305"
 )
 
@@ -15,32 +20,102 @@ const (
 	TagSize = 16
 )
 
-// Experimental: Poly1795, a PolyMAC with 179-bit accumulator and modulus 2^179-5
+func init() {
+	registerCryptoPrimitive(CryptoPrimitive{Name: "Poly1305", Variant: "unmodified copy", Use: "benchmarking", Source: CryptoSourceInPackage, Origin: "poly1305_modified.go"})
+	registerCryptoPrimitive(CryptoPrimitive{Name: "Poly1795", Variant: "179-bit accumulator, modulus 2^174-5, 24-byte tag", Use: "experimental", Source: CryptoSourceInPackage, Origin: "poly1305_modified.go"})
+	registerCryptoPrimitive(CryptoPrimitive{Name: "DoublePoly1305", Variant: "two Poly1305 keys, 32-byte tag", Use: "ChaCha20DoublePoly1305 transport data", Source: CryptoSourceInPackage, Origin: "poly1305_modified.go"})
+}
+
+// Experimental: Poly1795, a PolyMAC with 179-bit accumulator and modulus 2^174-5
 // This is NOT standard Poly1305 and is for benchmarking/experimentation only.
+//
+// The accumulator is kept in six 29-bit limbs, so the carry out of the top
+// limb is folded back in times 5 and the arithmetic is exact modulo 2^174-5.
+// Blocks are added word by word at the limb positions. Runs of four or two
+// blocks are processed with precomputed powers of r, evaluating
+// h = (h+m1)*r^4 + m2*r^3 + m3*r^2 + m4*r with a single reduction instead of
+// four dependent multiply-and-reduce steps, as optimized Poly1305
+// implementations do.
+//
+// The tag is the frozen accumulator in 24 little-endian bytes. Only the
+// first 16 of them get the pad from the key added, as four 32-bit words
+// each modulo 2^32; the last 8 bytes are the top of the accumulator as it
+// is, with no pad.
+
+const poly1795Mask = 0x1fffffff
 
 type poly1795MAC struct {
-	r [6]uint32
-	h [6]uint32
-	pad [4]uint32
-	buffer [24]byte // 24 bytes = 192 bits
-	bufUsed int
+	r         [6]uint32
+	s         [6]uint32    // 5*r, folding the limbs above 2^174 back in
+	rPow      [3][6]uint32 // r^2, r^3 and r^4, once powers is set
+	sPow      [3][6]uint32 // 5 times each of rPow
+	powers    bool
+	vec       bool         // use the vectorized four-block step
+	rVec      [6][4]uint64 // r^4, r^3, r^2 and r in lanes, once powers is set with vec
+	sVec      [6][4]uint64 // 5 times each of rVec
+	h         [6]uint32
+	pad       [4]uint32
+	buffer    [24]byte // 24 bytes = 192 bits
+	bufUsed   int
 	finalized bool
 }
 
 func newPoly1795MAC(key *[32]byte) *poly1795MAC {
 	var m poly1795MAC
+	m.init(key)
+	return &m
+}
+
+// init resets m to the initial state for key, so that one MAC can be reused
+// across many messages.
+func (m *poly1795MAC) init(key *[32]byte) {
+	*m = poly1795MAC{}
 	// Use 6 limbs of 29 bits each for r
-	m.r[0] = binary.LittleEndian.Uint32(key[0:4]) & 0x1fffffff
-	m.r[1] = (binary.LittleEndian.Uint32(key[3:7]) >> 3) & 0x1fffffff
-	m.r[2] = (binary.LittleEndian.Uint32(key[6:10]) >> 6) & 0x1fffffff
-	m.r[3] = (binary.LittleEndian.Uint32(key[9:13]) >> 9) & 0x1fffffff
-	m.r[4] = (binary.LittleEndian.Uint32(key[12:16]) >> 12) & 0x1fffffff
-	m.r[5] = (binary.LittleEndian.Uint32(key[15:19]) >> 15) & 0x1fffffff
+	m.r[0] = binary.LittleEndian.Uint32(key[0:4]) & poly1795Mask
+	m.r[1] = (binary.LittleEndian.Uint32(key[3:7]) >> 3) & poly1795Mask
+	m.r[2] = (binary.LittleEndian.Uint32(key[6:10]) >> 6) & poly1795Mask
+	m.r[3] = (binary.LittleEndian.Uint32(key[9:13]) >> 9) & poly1795Mask
+	m.r[4] = (binary.LittleEndian.Uint32(key[12:16]) >> 12) & poly1795Mask
+	m.r[5] = (binary.LittleEndian.Uint32(key[15:19]) >> 15) & poly1795Mask
 	m.pad[0] = binary.LittleEndian.Uint32(key[20:24])
 	m.pad[1] = binary.LittleEndian.Uint32(key[24:28])
 	m.pad[2] = binary.LittleEndian.Uint32(key[28:32])
 	m.pad[3] = binary.LittleEndian.Uint32(key[16:20])
-	return &m
+	for i := range m.r {
+		m.s[i] = 5 * m.r[i]
+	}
+	m.vec = poly1795HasAVX2
+}
+
+// computePowers fills in rPow and sPow. It costs three multiplications, so
+// it is only done once a message is long enough to use them.
+func (m *poly1795MAC) computePowers() {
+	var lo, hi [6]uint64
+	poly1795MulAcc(&lo, &hi, &m.r, &m.r, &m.s)
+	poly1795Reduce(&m.rPow[0], &lo, &hi)
+	for k := 1; k < 3; k++ {
+		for i := range m.sPow[k-1] {
+			m.sPow[k-1][i] = 5 * m.rPow[k-1][i]
+		}
+		lo, hi = [6]uint64{}, [6]uint64{}
+		poly1795MulAcc(&lo, &hi, &m.rPow[k-1], &m.r, &m.s)
+		poly1795Reduce(&m.rPow[k], &lo, &hi)
+	}
+	for i := range m.sPow[2] {
+		m.sPow[2][i] = 5 * m.rPow[2][i]
+	}
+	if m.vec {
+		m.computeVecPowers()
+	}
+	m.powers = true
+}
+
+// pow returns r^k and 5*r^k for k from 1 to 4.
+func (m *poly1795MAC) pow(k int) (r, s *[6]uint32) {
+	if k == 1 {
+		return &m.r, &m.s
+	}
+	return &m.rPow[k-2], &m.sPow[k-2]
 }
 
 func (m *poly1795MAC) Write(p []byte) (n int, err error) {
@@ -56,12 +131,29 @@ func (m *poly1795MAC) Write(p []byte) (n int, err error) {
 			return n, nil
 		}
 		copy(m.buffer[m.bufUsed:], p[:remaining])
-		m.processBlock(m.buffer[:], false)
+		m.processBlock(m.buffer[:], 0)
 		p = p[remaining:]
 		m.bufUsed = 0
 	}
+	if len(p) >= 4*24 && !m.powers {
+		m.computePowers()
+	}
+	if m.powers {
+		for len(p) >= 4*24 {
+			if m.vec {
+				m.processBlocksVec(p[:4*24])
+			} else {
+				m.processBlocks(p[:4*24])
+			}
+			p = p[4*24:]
+		}
+		if len(p) >= 2*24 {
+			m.processBlocks(p[:2*24])
+			p = p[2*24:]
+		}
+	}
 	for len(p) >= 24 {
-		m.processBlock(p[:24], false)
+		m.processBlock(p[:24], 0)
 		p = p[24:]
 	}
 	if len(p) > 0 {
@@ -71,37 +163,125 @@ func (m *poly1795MAC) Write(p []byte) (n int, err error) {
 	return n, nil
 }
 
-func (m *poly1795MAC) processBlock(block []byte, isFinal bool) {
-	var t [6]uint32
-	for i := 0; i < 6; i++ {
-		if i*4 < len(block) {
-			t[i] = binary.LittleEndian.Uint32(block[i*4:])
-		} else {
-			t[i] = 0
-		}
-	}
-	if isFinal {
-		t[m.bufUsed/4] |= 1 << ((m.bufUsed % 4) * 8)
-	}
-	for i := 0; i < 6; i++ {
-		m.h[i] += t[i]
+// poly1795Words reads a 24-byte block as the six words added at the limbs.
+func poly1795Words(block []byte) (t [6]uint32) {
+	for i := range t {
+		t[i] = binary.LittleEndian.Uint32(block[i*4:])
 	}
-	// (h * r) mod (2^179 - 5)
-	hr := [6]uint64{}
+	return t
+}
+
+// poly1795Add returns h + t carried into 29-bit limbs, with the bottom limb
+// slightly over after folding in the top carry.
+func poly1795Add(h, t *[6]uint32) (a [6]uint32) {
+	var c uint64
+	for i := range a {
+		x := uint64(h[i]) + uint64(t[i]) + c
+		a[i] = uint32(x & poly1795Mask)
+		c = x >> 29
+	}
+	a[0] += uint32(5 * c)
+	return a
+}
+
+// poly1795MulAcc adds a*r to the accumulator, given s = 5*r. Each column of
+// the product is split at 29 bits into lo and hi, which leaves room to sum
+// four products before reducing.
+func poly1795MulAcc(lo, hi *[6]uint64, a, r, s *[6]uint32) {
 	for i := 0; i < 6; i++ {
+		var x uint64
 		for j := 0; j <= i; j++ {
-			hr[i] += uint64(m.h[j]) * uint64(m.r[i-j])
+			x += uint64(a[j]) * uint64(r[i-j])
 		}
 		for j := i + 1; j < 6; j++ {
-			hr[i] += uint64(m.h[j]) * uint64(5*m.r[i+6-j])
+			x += uint64(a[j]) * uint64(s[i+6-j])
 		}
+		lo[i] += x & poly1795Mask
+		hi[i] += x >> 29
 	}
+}
+
+// poly1795Reduce carries the accumulator into the 29-bit limbs of h.
+func poly1795Reduce(h *[6]uint32, lo, hi *[6]uint64) {
+	var c uint64
 	for i := 0; i < 6; i++ {
-		m.h[i] = uint32(hr[i] & 0x1fffffff)
-		if i < 5 {
-			hr[i+1] += hr[i] >> 29
+		x := lo[i] + c
+		if i == 0 {
+			x += 5 * hi[5]
+		} else {
+			x += hi[i-1]
+		}
+		h[i] = uint32(x & poly1795Mask)
+		c = x >> 29
+	}
+	x := uint64(h[0]) + 5*c
+	h[0] = uint32(x & poly1795Mask)
+	h[1] += uint32(x >> 29)
+}
+
+// processBlock adds a 24-byte block to the MAC. A final block shorter than
+// that is passed zero-padded, with final the length of its message bytes,
+// which are followed by a 1 byte; final is zero for a full block.
+func (m *poly1795MAC) processBlock(block []byte, final int) {
+	t := poly1795Words(block)
+	if final > 0 {
+		t[final/4] |= 1 << ((final % 4) * 8)
+	}
+	// (h * r) mod (2^174 - 5)
+	a := poly1795Add(&m.h, &t)
+	var lo, hi [6]uint64
+	poly1795MulAcc(&lo, &hi, &a, &m.r, &m.s)
+	poly1795Reduce(&m.h, &lo, &hi)
+}
+
+// processBlocks processes two or four full blocks, multiplying the i-th of n
+// blocks by r^(n-i).
+func (m *poly1795MAC) processBlocks(blocks []byte) {
+	n := len(blocks) / 24
+	var lo, hi [6]uint64
+	for i := 0; i < n; i++ {
+		t := poly1795Words(blocks[i*24:])
+		var a [6]uint32
+		if i == 0 {
+			a = poly1795Add(&m.h, &t)
+		} else {
+			a = poly1795Add(&[6]uint32{}, &t)
 		}
+		r, s := m.pow(n - i)
+		poly1795MulAcc(&lo, &hi, &a, r, s)
+	}
+	poly1795Reduce(&m.h, &lo, &hi)
+}
+
+// poly1795Freeze returns the accumulator h fully reduced modulo 2^174-5, in
+// 29-bit limbs. Its time does not depend on h: the carries run through every
+// limb twice, whatever their values, which leaves h below 2^174, and p is
+// subtracted once into g and kept with a mask made of the carry out of g,
+// not a branch.
+func poly1795Freeze(h *[6]uint32) (f [6]uint32) {
+	f = *h
+	for range 2 {
+		var c uint32
+		for i := range f {
+			f[i] += c
+			c = f[i] >> 29
+			f[i] &= poly1795Mask
+		}
+		f[0] += 5 * c
+	}
+	// g = f + 5 - 2^174 = f - p, carrying out of 2^174 if and only if f >= p.
+	var g [6]uint32
+	c := uint32(5)
+	for i := range g {
+		g[i] = f[i] + c
+		c = g[i] >> 29
+		g[i] &= poly1795Mask
+	}
+	mask := -c
+	for i := range f {
+		f[i] ^= (f[i] ^ g[i]) & mask
 	}
+	return f
 }
 
 func (m *poly1795MAC) Sum(out []byte) []byte {
@@ -112,40 +292,17 @@ func (m *poly1795MAC) Sum(out []byte) []byte {
 		for i := m.bufUsed; i < 24; i++ {
 			m.buffer[i] = 0
 		}
-		m.processBlock(m.buffer[:], true)
+		m.processBlock(m.buffer[:], m.bufUsed)
 	}
 	m.finalized = true
-	var f [6]uint32
-	var c uint32
-	for i := 0; i < 6; i++ {
-		f[i] = m.h[i]
-	}
-	for i := 1; i < 6; i++ {
-		f[i] += f[i-1] >> 29
-		f[i-1] &= 0x1fffffff
-	}
-	f[0] += 5 * (f[5] >> 29)
-	f[5] &= 0x1fffffff
-	// compute h + -p
-	g := [6]uint32{}
-	g[0] = f[0] + 5
-	c = g[0] >> 29
-	g[0] &= 0x1fffffff
-	for i := 1; i < 6; i++ {
-		g[i] = f[i] + c
-		c = g[i] >> 29
-		g[i] &= 0x1fffffff
-	}
-	mask := (c ^ 1) - 1
-	for i := 0; i < 6; i++ {
-		f[i] = (f[i] &^ mask) | (g[i] & mask)
-	}
+	f := poly1795Freeze(&m.h)
 	// serialize (output 24 bytes)
 	var tag [24]byte
 	for i := 0; i < 6; i++ {
 		binary.LittleEndian.PutUint32(tag[i*4:], f[i])
 	}
-	// add pad (first 16 bytes only, for compatibility)
+	// add pad to the first 16 bytes only, for compatibility; the last 8 are
+	// the accumulator as it is
 	var t uint32
 	for i := 0; i < 4; i++ {
 		t = binary.LittleEndian.Uint32(tag[i*4:]) + m.pad[i]
@@ -154,6 +311,14 @@ func (m *poly1795MAC) Sum(out []byte) []byte {
 	return append(out, tag[:]...)
 }
 
+// Verify reports in constant time whether expected is the 24-byte tag of
+// the data written. Like Sum, it finalizes the MAC.
+func (m *poly1795MAC) Verify(expected []byte) bool {
+	var sum [24]byte
+	m.Sum(sum[:0])
+	return subtle.ConstantTimeCompare(sum[:], expected) == 1
+}
+
 // Poly1795Sum computes the experimental 179-bit MAC
 func Poly1795Sum(out *[24]byte, m []byte, key *[32]byte) {
 	mac := newPoly1795MAC(key)
@@ -162,13 +327,81 @@ func Poly1795Sum(out *[24]byte, m []byte, key *[32]byte) {
 	copy(out[:], result)
 }
 
+// Poly1795Verify reports in constant time whether tag is the Poly1795 tag of
+// m under key.
+func Poly1795Verify(tag *[24]byte, m []byte, key *[32]byte) bool {
+	var mac poly1795MAC
+	mac.init(key)
+	mac.Write(m)
+	return mac.Verify(tag[:])
+}
+
+// Poly1795Size and Poly1795BlockSize are the sizes of Poly1795 tags and of
+// the blocks it processes.
+const (
+	Poly1795Size      = 24
+	Poly1795BlockSize = 24
+)
+
+// A Poly1795MAC computes a Poly1795 tag incrementally, with the methods of
+// a hash.Hash: the message may be written in any number of pieces, and
+// Sum and Verify compute the tag of what was written so far, leaving the
+// MAC as it was. The key of a one-time MAC must authenticate a single
+// message, so Reset, which starts a new message under the same key, is
+// meant for benchmarks and tests.
+type Poly1795MAC struct {
+	mac poly1795MAC
+}
+
+var _ hash.Hash = (*Poly1795MAC)(nil)
+
+// New1795 returns a Poly1795MAC using the given 32-byte one-time key.
+func New1795(key *[32]byte) *Poly1795MAC {
+	m := new(Poly1795MAC)
+	m.mac.init(key)
+	return m
+}
+
+// Size returns the tag size of 24 bytes.
+func (m *Poly1795MAC) Size() int { return Poly1795Size }
+
+// BlockSize returns the block size of 24 bytes. A message written in
+// multiples of it is never buffered.
+func (m *Poly1795MAC) BlockSize() int { return Poly1795BlockSize }
+
+// Write adds p to the message. It never returns an error.
+func (m *Poly1795MAC) Write(p []byte) (n int, err error) {
+	return m.mac.Write(p)
+}
+
+// Sum appends the tag of the message written so far to b.
+func (m *Poly1795MAC) Sum(b []byte) []byte {
+	mac := m.mac
+	return mac.Sum(b)
+}
+
+// Verify reports in constant time whether expected is the tag of the
+// message written so far.
+func (m *Poly1795MAC) Verify(expected []byte) bool {
+	mac := m.mac
+	return mac.Verify(expected)
+}
+
+// Reset starts a new message under the same key, keeping the powers of r
+// computed for the last one.
+func (m *Poly1795MAC) Reset() {
+	m.mac.h = [6]uint32{}
+	m.mac.buffer = [24]byte{}
+	m.mac.bufUsed = 0
+}
+
 // Restore the original Poly1305 copy with minimal modification for comparison
 type poly1305MAC struct {
-	r [5]uint32
-	h [5]uint32
-	pad [4]uint32
-	buffer [16]byte
-	bufUsed int
+	r         [5]uint32
+	h         [5]uint32
+	pad       [4]uint32
+	buffer    [16]byte
+	bufUsed   int
 	finalized bool
 }
 
@@ -199,12 +432,12 @@ func (m *poly1305MAC) Write(p []byte) (n int, err error) {
 			return n, nil
 		}
 		copy(m.buffer[m.bufUsed:], p[:remaining])
-		m.processBlock(m.buffer[:], false)
+		m.processBlock(m.buffer[:], 0)
 		p = p[remaining:]
 		m.bufUsed = 0
 	}
 	for len(p) >= 16 {
-		m.processBlock(p[:16], false)
+		m.processBlock(p[:16], 0)
 		p = p[16:]
 	}
 	if len(p) > 0 {
@@ -214,14 +447,16 @@ func (m *poly1305MAC) Write(p []byte) (n int, err error) {
 	return n, nil
 }
 
-func (m *poly1305MAC) processBlock(block []byte, isFinal bool) {
+// processBlock adds a 16-byte block to the MAC, taking final as the
+// poly1795MAC method of the same name does.
+func (m *poly1305MAC) processBlock(block []byte, final int) {
 	var t [5]uint32
 	for i := 0; i < 4; i++ {
 		t[i] = binary.LittleEndian.Uint32(block[i*4:])
 	}
 	t[4] = 0
-	if isFinal {
-		t[m.bufUsed/4] |= 1 << ((m.bufUsed % 4) * 8)
+	if final > 0 {
+		t[final/4] |= 1 << ((final % 4) * 8)
 	}
 	for i := 0; i < 5; i++ {
 		m.h[i] += t[i]
@@ -252,7 +487,7 @@ func (m *poly1305MAC) Sum(out []byte) []byte {
 		for i := m.bufUsed; i < 16; i++ {
 			m.buffer[i] = 0
 		}
-		m.processBlock(m.buffer[:], true)
+		m.processBlock(m.buffer[:], m.bufUsed)
 	}
 	m.finalized = true
 	var f [5]uint32
@@ -325,4 +560,53 @@ func DoublePoly1305(out *[32]byte, m []byte, key *[64]byte) {
 	poly1305.Sum(&tag2, m, (*[32]byte)(key[32:]))
 	copy(out[:16], tag1[:])
 	copy(out[16:], tag2[:])
-} 
\ No newline at end of file
+}
+
+// doublePoly1305Chunk is how much of a write is fed to one MAC before
+// switching to the other, small enough that the data is still in cache.
+const doublePoly1305Chunk = 512
+
+// A DoublePoly1305MAC computes a DoublePoly1305 tag incrementally, so that
+// a message can be written in pieces such as a header and a payload. Like
+// poly1305.MAC, it panics if written to after Sum or Verify.
+type DoublePoly1305MAC struct {
+	macs [2]*poly1305.MAC
+}
+
+// NewDoublePoly1305 returns a DoublePoly1305MAC using the two 32-byte
+// one-time keys in key.
+func NewDoublePoly1305(key *[64]byte) *DoublePoly1305MAC {
+	return &DoublePoly1305MAC{macs: [2]*poly1305.MAC{
+		poly1305.New((*[32]byte)(key[:32])),
+		poly1305.New((*[32]byte)(key[32:])),
+	}}
+}
+
+// Size returns the tag size of 32 bytes.
+func (d *DoublePoly1305MAC) Size() int { return 2 * poly1305.TagSize }
+
+// Write adds p to both MACs, alternating between them in chunks.
+func (d *DoublePoly1305MAC) Write(p []byte) (n int, err error) {
+	n = len(p)
+	for len(p) > 0 {
+		chunk := p[:min(len(p), doublePoly1305Chunk)]
+		d.macs[0].Write(chunk)
+		d.macs[1].Write(chunk)
+		p = p[len(chunk):]
+	}
+	return n, nil
+}
+
+// Sum appends the 32-byte tag to b.
+func (d *DoublePoly1305MAC) Sum(b []byte) []byte {
+	b = d.macs[0].Sum(b)
+	return d.macs[1].Sum(b)
+}
+
+// Verify reports in constant time whether expected is the tag of the data
+// written.
+func (d *DoublePoly1305MAC) Verify(expected []byte) bool {
+	var sum [2 * poly1305.TagSize]byte
+	d.Sum(sum[:0])
+	return subtle.ConstantTimeCompare(sum[:], expected) == 1
+}
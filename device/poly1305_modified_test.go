@@ -1,104 +1,360 @@
+//go:build wg_experimental
+
 package device
 
 import (
 	"crypto/rand"
+	"encoding/binary"
 	"fmt"
+	"math/big"
 	"testing"
-	"time"
 
 	"golang.org/x/crypto/poly1305"
 )
 
-func TestPoly1305ModifiedOutputAndSpeed(t *testing.T) {
+func TestPoly1305ModifiedOutput(t *testing.T) {
 	var key [32]byte
 	var msg [1024]byte
 	_, _ = rand.Read(key[:])
 	_, _ = rand.Read(msg[:])
 
+	// The modified MAC is a different function from the original.
 	var outOrig, outMod [16]byte
-
-	// Check output difference
 	poly1305.Sum(&outOrig, msg[:], &key)
 	SumModified(&outMod, msg[:], &key)
+	if outMod == outOrig {
+		t.Fatalf("modified Poly1305 matches the original: %x", outMod)
+	}
+}
 
-	fmt.Printf("Original Poly1305: %x\n", outOrig)
-	fmt.Printf("Modified Poly1305: %x\n", outMod)
-	fmt.Printf("Diff (first byte): %d\n", int(outMod[0])-int(outOrig[0]))
+func TestDoublePoly1305Output(t *testing.T) {
+	var key [64]byte
+	var msg [1024]byte
+	_, _ = rand.Read(key[:])
+	_, _ = rand.Read(msg[:])
 
-	// Time original
-	iters := 100000
-	start := time.Now()
-	for i := 0; i < iters; i++ {
-		poly1305.Sum(&outOrig, msg[:], &key)
-	}
-	elapsedOrig := time.Since(start)
+	var outDouble [32]byte
+	DoublePoly1305(&outDouble, msg[:], &key)
 
-	// Time modified
-	start = time.Now()
-	for i := 0; i < iters; i++ {
-		SumModified(&outMod, msg[:], &key)
+	// Change message and check difference
+	msg2 := make([]byte, len(msg))
+	copy(msg2, msg[:])
+	msg2[0] ^= 0xFF
+	var outDouble2 [32]byte
+	DoublePoly1305(&outDouble2, msg2, &key)
+	if string(outDouble[:]) == string(outDouble2[:]) {
+		t.Fatalf("DoublePoly1305 should differ for different messages")
 	}
-	elapsedMod := time.Since(start)
+}
 
-	fmt.Printf("Original Poly1305 time: %v for %d iterations\n", elapsedOrig, iters)
-	fmt.Printf("Modified Poly1305 time: %v for %d iterations\n", elapsedMod, iters)
+// poly1795Reference computes Poly1795 with math/big, one block at a time.
+func poly1795Reference(msg []byte, key *[32]byte) [24]byte {
+	mac := newPoly1795MAC(key)
+	p := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 174), big.NewInt(5))
+	limbs := func(words [6]uint32) *big.Int {
+		x := new(big.Int)
+		for i := 5; i >= 0; i-- {
+			x.Lsh(x, 29).Add(x, big.NewInt(int64(words[i])))
+		}
+		return x
+	}
+	r := limbs(mac.r)
+	h := new(big.Int)
+	for len(msg) > 0 {
+		var block [24]byte
+		n := copy(block[:], msg)
+		msg = msg[n:]
+		t := poly1795Words(block[:])
+		if n < 24 {
+			t[n/4] |= 1 << ((n % 4) * 8)
+		}
+		h.Add(h, limbs(t)).Mul(h, r).Mod(h, p)
+	}
+	var tag [24]byte
+	for i := 0; i < 6; i++ {
+		limb := new(big.Int).Rsh(h, uint(29*i))
+		binary.LittleEndian.PutUint32(tag[i*4:], uint32(limb.Uint64()&0x1fffffff))
+	}
+	for i := 0; i < 4; i++ {
+		binary.LittleEndian.PutUint32(tag[i*4:], binary.LittleEndian.Uint32(tag[i*4:])+mac.pad[i])
+	}
+	return tag
 }
 
-func TestPoly1795OutputAndSpeed(t *testing.T) {
+func TestPoly1795Reference(t *testing.T) {
 	var key [32]byte
-	var msg [1024]byte
-	_, _ = rand.Read(key[:])
-	_, _ = rand.Read(msg[:])
+	msg := make([]byte, 400)
+	for size := 0; size <= len(msg); size++ {
+		rand.Read(key[:])
+		rand.Read(msg[:size])
+		want := poly1795Reference(msg[:size], &key)
 
-	var out1795 [24]byte
+		var got [24]byte
+		Poly1795Sum(&got, msg[:size], &key)
+		if got != want {
+			t.Fatalf("size %d: got %x, want %x", size, got, want)
+		}
 
-	// Output
-	Poly1795Sum(&out1795, msg[:], &key)
-	fmt.Printf("Poly1795 Output: %x\n", out1795)
+		mac := newPoly1795MAC(&key)
+		for p := msg[:size]; len(p) > 0; {
+			n := min(len(p), 1+size%53)
+			mac.Write(p[:n])
+			p = p[n:]
+		}
+		if chunked := mac.Sum(nil); string(chunked) != string(want[:]) {
+			t.Fatalf("size %d in chunks: got %x, want %x", size, chunked, want)
+		}
+	}
 
-	// Time Poly1795
-	iters := 100000
-	start := time.Now()
-	for i := 0; i < iters; i++ {
-		Poly1795Sum(&out1795, msg[:], &key)
+	// Saturated limbs stress the carries between blocks.
+	for i := range key {
+		key[i] = 0xff
+	}
+	for i := range msg {
+		msg[i] = 0xff
+	}
+	var got [24]byte
+	Poly1795Sum(&got, msg, &key)
+	if want := poly1795Reference(msg, &key); got != want {
+		t.Fatalf("saturated: got %x, want %x", got, want)
 	}
-	elapsed1795 := time.Since(start)
+}
 
-	fmt.Printf("Poly1795 time: %v for %d iterations\n", elapsed1795, iters)
+func BenchmarkPolyMACs(b *testing.B) {
+	var key [32]byte
+	rand.Read(key[:])
+	for _, size := range []int{64, 1420, 8192} {
+		msg := make([]byte, size)
+		rand.Read(msg)
+		b.Run(fmt.Sprintf("Poly1305/size=%d", size), func(b *testing.B) {
+			var out [16]byte
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				poly1305.Sum(&out, msg, &key)
+			}
+		})
+		b.Run(fmt.Sprintf("Poly1305Scalar/size=%d", size), func(b *testing.B) {
+			var out [16]byte
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				SumModified(&out, msg, &key)
+			}
+		})
+		b.Run(fmt.Sprintf("Poly1795/size=%d", size), func(b *testing.B) {
+			var out [24]byte
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				Poly1795Sum(&out, msg, &key)
+			}
+		})
+		b.Run(fmt.Sprintf("Poly1795Scalar/size=%d", size), func(b *testing.B) {
+			var out [24]byte
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				poly1795SumScalar(&out, msg, &key)
+			}
+		})
+		b.Run(fmt.Sprintf("Poly1795Vec/size=%d", size), func(b *testing.B) {
+			var out [24]byte
+			b.SetBytes(int64(size))
+			for i := 0; i < b.N; i++ {
+				Poly1795SumVec(&out, msg, &key)
+			}
+		})
+	}
 }
 
-func TestDoublePoly1305OutputAndSpeed(t *testing.T) {
+func TestDoublePoly1305MAC(t *testing.T) {
 	var key [64]byte
-	var msg [1024]byte
-	_, _ = rand.Read(key[:])
-	_, _ = rand.Read(msg[:])
+	msg := make([]byte, 3000)
+	rand.Read(key[:])
+	rand.Read(msg)
+	for _, size := range []int{0, 1, 16, 100, 513, 1420, 3000} {
+		var want [32]byte
+		DoublePoly1305(&want, msg[:size], &key)
 
-	var outDouble [32]byte
+		for _, split := range []int{0, size / 3, size} {
+			mac := NewDoublePoly1305(&key)
+			mac.Write(msg[:split])
+			mac.Write(msg[split:size])
+			if got := mac.Sum(nil); string(got) != string(want[:]) {
+				t.Errorf("size %d split at %d: got %x, want %x", size, split, got, want)
+			}
+		}
 
-	// Output
-	DoublePoly1305(&outDouble, msg[:], &key)
-	fmt.Printf("DoublePoly1305 Output: %x\n", outDouble)
-	if len(outDouble) != 32 {
-		t.Fatalf("DoublePoly1305 output length is not 32 bytes")
+		mac := NewDoublePoly1305(&key)
+		mac.Write(msg[:size])
+		if !mac.Verify(want[:]) {
+			t.Errorf("size %d: valid tag rejected", size)
+		}
+		for _, bad := range [][]byte{want[:16], append([]byte{want[0] ^ 1}, want[1:]...), append(want[:16:16], want[17:]...)} {
+			mac := NewDoublePoly1305(&key)
+			mac.Write(msg[:size])
+			if mac.Verify(bad) {
+				t.Errorf("size %d: invalid tag %x accepted", size, bad)
+			}
+		}
 	}
+}
 
-	// Change message and check difference
-	msg2 := make([]byte, len(msg))
-	copy(msg2, msg[:])
-	msg2[0] ^= 0xFF
-	var outDouble2 [32]byte
-	DoublePoly1305(&outDouble2, msg2, &key)
-	if string(outDouble[:]) == string(outDouble2[:]) {
-		t.Fatalf("DoublePoly1305 should differ for different messages")
+func TestPoly1795Freeze(t *testing.T) {
+	p := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 174), big.NewInt(5))
+	value := func(limbs [6]uint32) *big.Int {
+		x := new(big.Int)
+		for i := 5; i >= 0; i-- {
+			x.Lsh(x, 29).Add(x, big.NewInt(int64(limbs[i])))
+		}
+		return x
+	}
+	all := func(limb uint32) (h [6]uint32) {
+		for i := range h {
+			h[i] = limb
+		}
+		return
+	}
+	pLimbs := all(poly1795Mask)
+	pLimbs[0] -= 4
+	accumulators := [][6]uint32{
+		{}, pLimbs, all(poly1795Mask), all(poly1795Mask + 1), all(1<<30 - 1),
+		// A carry into a saturated limb after the top one is folded in.
+		{poly1795Mask, poly1795Mask, 0, 0, 0, 1 << 29},
+		{poly1795Mask - 4, poly1795Mask + 1, poly1795Mask, poly1795Mask, poly1795Mask, poly1795Mask},
+	}
+	for i := range 1000 {
+		var h [6]uint32
+		var b [24]byte
+		rand.Read(b[:])
+		for j := range h {
+			h[j] = binary.LittleEndian.Uint32(b[j*4:]) >> (2 + i%3)
+		}
+		accumulators = append(accumulators, h)
+	}
+	for _, h := range accumulators {
+		f := poly1795Freeze(&h)
+		for _, limb := range f {
+			if limb > poly1795Mask {
+				t.Fatalf("%x froze into non-canonical limbs %x", h, f)
+			}
+		}
+		if want := new(big.Int).Mod(value(h), p); value(f).Cmp(want) != 0 {
+			t.Fatalf("%x froze into %v, want %v", h, value(f), want)
+		}
+	}
+}
+
+func TestPoly1795Verify(t *testing.T) {
+	var key [32]byte
+	msg := make([]byte, 3000)
+	rand.Read(key[:])
+	rand.Read(msg)
+	for _, size := range []int{0, 1, 24, 100, 1420, 3000} {
+		var want [24]byte
+		Poly1795Sum(&want, msg[:size], &key)
+		if !Poly1795Verify(&want, msg[:size], &key) {
+			t.Errorf("size %d: valid tag rejected", size)
+		}
+		bad := want
+		bad[23] ^= 1
+		if Poly1795Verify(&bad, msg[:size], &key) {
+			t.Errorf("size %d: invalid tag %x accepted", size, bad)
+		}
+
+		for _, split := range []int{0, size / 3, size} {
+			mac := newPoly1795MAC(&key)
+			mac.Write(msg[:split])
+			mac.Write(msg[split:size])
+			if !mac.Verify(want[:]) {
+				t.Errorf("size %d split at %d: valid tag rejected", size, split)
+			}
+		}
+		mac := newPoly1795MAC(&key)
+		mac.Write(msg[:size])
+		if mac.Verify(want[:16]) {
+			t.Errorf("size %d: truncated tag accepted", size)
+		}
+	}
+}
+
+func TestNew1795Chunks(t *testing.T) {
+	var key [32]byte
+	msg := make([]byte, 500)
+	rand.Read(key[:])
+	rand.Read(msg)
+	for _, size := range []int{0, 1, 23, 24, 25, 47, 48, 95, 96, 97, 200, 500} {
+		var want [Poly1795Size]byte
+		Poly1795Sum(&want, msg[:size], &key)
+
+		// Every split into three pieces, across partial, two-block and
+		// four-block runs.
+		for i := 0; i <= size; i += 1 + size/40 {
+			for j := i; j <= size; j += 1 + size/40 {
+				mac := New1795(&key)
+				mac.Write(msg[:i])
+				mac.Write(msg[i:j])
+				mac.Write(msg[j:size])
+				if got := mac.Sum(nil); string(got) != string(want[:]) {
+					t.Fatalf("size %d split at %d and %d: got %x, want %x", size, i, j, got, want)
+				}
+			}
+		}
+
+		// Byte by byte, and in pieces of each length up to two blocks.
+		for n := 1; n <= 2*Poly1795BlockSize; n++ {
+			mac := New1795(&key)
+			for p := msg[:size]; len(p) > 0; p = p[min(n, len(p)):] {
+				mac.Write(p[:min(n, len(p))])
+			}
+			if got := mac.Sum(nil); string(got) != string(want[:]) {
+				t.Fatalf("size %d in %d-byte pieces: got %x, want %x", size, n, got, want)
+			}
+		}
+	}
+}
+
+func TestNew1795Writer(t *testing.T) {
+	var key [32]byte
+	msg := make([]byte, 300)
+	rand.Read(key[:])
+	rand.Read(msg)
+	var want [Poly1795Size]byte
+	Poly1795Sum(&want, msg, &key)
+
+	mac := New1795(&key)
+	if mac.Size() != Poly1795Size || mac.BlockSize() != Poly1795BlockSize {
+		t.Errorf("size %d, block size %d", mac.Size(), mac.BlockSize())
+	}
+	// Writes take all of p, keep none of it, and never fail.
+	piece := make([]byte, 100)
+	for p := msg; len(p) > 0; p = p[len(piece):] {
+		piece = piece[:min(len(piece), len(p))]
+		copy(piece, p)
+		if n, err := mac.Write(piece); n != len(piece) || err != nil {
+			t.Fatalf("write of %d bytes: %d, %v", len(piece), n, err)
+		}
+		clear(piece)
+		if n, err := mac.Write(nil); n != 0 || err != nil {
+			t.Fatalf("empty write: %d, %v", n, err)
+		}
 	}
 
-	// Time DoublePoly1305
-	iters := 100000
-	start := time.Now()
-	for i := 0; i < iters; i++ {
-		DoublePoly1305(&outDouble, msg[:], &key)
+	// Sum appends to its argument and leaves the MAC as it was.
+	prefix := []byte("prefix")
+	if got := mac.Sum(prefix); string(got[:len(prefix)]) != "prefix" || string(got[len(prefix):]) != string(want[:]) {
+		t.Fatalf("sum = %x, want %x after the prefix", got, want)
+	}
+	if got := mac.Sum(nil); string(got) != string(want[:]) || !mac.Verify(want[:]) {
+		t.Fatalf("second sum = %x, want %x", got, want)
+	}
+	mac.Write(msg[:1])
+	var longer [Poly1795Size]byte
+	Poly1795Sum(&longer, append(msg[:len(msg):len(msg)], msg[0]), &key)
+	if got := mac.Sum(nil); string(got) != string(longer[:]) {
+		t.Errorf("sum after writing on = %x, want %x", got, longer)
 	}
-	elapsedDouble := time.Since(start)
 
-	fmt.Printf("DoublePoly1305 time: %v for %d iterations\n", elapsedDouble, iters)
-} 
\ No newline at end of file
+	mac.Reset()
+	mac.Write(msg)
+	if got := mac.Sum(nil); string(got) != string(want[:]) {
+		t.Errorf("sum after reset = %x, want %x", got, want)
+	}
+}
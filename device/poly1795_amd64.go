@@ -0,0 +1,27 @@
+//go:build wg_experimental && amd64 && !purego
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import "golang.org/x/sys/cpu"
+
+// poly1795HasAVX2 selects the vectorized four-block step of Poly1795.
+var poly1795HasAVX2 = cpu.X86.HasAVX2
+
+// poly1795MulAcc4AVX2 is poly1795MulAcc4Generic in AVX2 assembly, with
+// each block in a lane of the vector registers.
+//
+//go:noescape
+func poly1795MulAcc4AVX2(lo, hi *[6]uint64, h *[6]uint32, blocks *[4 * 24]byte, r, s *[6][4]uint64)
+
+func poly1795MulAcc4(lo, hi *[6]uint64, h *[6]uint32, blocks *[4 * 24]byte, r, s *[6][4]uint64) {
+	if poly1795HasAVX2 {
+		poly1795MulAcc4AVX2(lo, hi, h, blocks, r, s)
+		return
+	}
+	poly1795MulAcc4Generic(lo, hi, h, blocks, r, s)
+}
@@ -0,0 +1,255 @@
+//go:build wg_experimental && amd64 && !purego
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+#include "textflag.h"
+
+// Offsets of the first word of each of the four blocks.
+DATA poly1795GatherIndex<>+0x00(SB)/4, $0
+DATA poly1795GatherIndex<>+0x04(SB)/4, $24
+DATA poly1795GatherIndex<>+0x08(SB)/4, $48
+DATA poly1795GatherIndex<>+0x0c(SB)/4, $72
+GLOBL poly1795GatherIndex<>(SB), RODATA|NOPTR, $16
+
+// Lane k of each register holds a value of block k. Y0-Y5 are the limbs of
+// the four blocks, the first with h added; Y6 is the carry between limbs;
+// Y8 sums the column being computed; Y15 is the 29-bit limb mask.
+
+// func poly1795MulAcc4AVX2(lo, hi *[6]uint64, h *[6]uint32, blocks *[4 * 24]byte, r, s *[6][4]uint64)
+TEXT ·poly1795MulAcc4AVX2(SB), NOSPLIT, $0-48
+	MOVQ lo+0(FP), AX
+	MOVQ hi+8(FP), BX
+	MOVQ h+16(FP), CX
+	MOVQ blocks+24(FP), SI
+	MOVQ r+32(FP), DX
+	MOVQ s+40(FP), DI
+
+	MOVQ         $0x1fffffff, R8
+	VMOVQ        R8, X15
+	VPBROADCASTQ X15, Y15
+	VMOVDQU      poly1795GatherIndex<>(SB), X14
+
+	// Read the words of the blocks and carry h plus them into 29-bit limbs,
+	// as poly1795Add does for each block.
+	VPXOR Y6, Y6, Y6
+
+	VPCMPEQD   X13, X13, X13
+	VPGATHERDD X13, 0(SI)(X14*1), X0
+	VPMOVZXDQ  X0, Y0
+	VMOVD      0(CX), X12
+	VPADDQ     Y12, Y0, Y0
+	VPADDQ     Y6, Y0, Y0
+	VPSRLQ     $29, Y0, Y6
+	VPAND      Y15, Y0, Y0
+
+	VPCMPEQD   X13, X13, X13
+	VPGATHERDD X13, 4(SI)(X14*1), X1
+	VPMOVZXDQ  X1, Y1
+	VMOVD      4(CX), X12
+	VPADDQ     Y12, Y1, Y1
+	VPADDQ     Y6, Y1, Y1
+	VPSRLQ     $29, Y1, Y6
+	VPAND      Y15, Y1, Y1
+
+	VPCMPEQD   X13, X13, X13
+	VPGATHERDD X13, 8(SI)(X14*1), X2
+	VPMOVZXDQ  X2, Y2
+	VMOVD      8(CX), X12
+	VPADDQ     Y12, Y2, Y2
+	VPADDQ     Y6, Y2, Y2
+	VPSRLQ     $29, Y2, Y6
+	VPAND      Y15, Y2, Y2
+
+	VPCMPEQD   X13, X13, X13
+	VPGATHERDD X13, 12(SI)(X14*1), X3
+	VPMOVZXDQ  X3, Y3
+	VMOVD      12(CX), X12
+	VPADDQ     Y12, Y3, Y3
+	VPADDQ     Y6, Y3, Y3
+	VPSRLQ     $29, Y3, Y6
+	VPAND      Y15, Y3, Y3
+
+	VPCMPEQD   X13, X13, X13
+	VPGATHERDD X13, 16(SI)(X14*1), X4
+	VPMOVZXDQ  X4, Y4
+	VMOVD      16(CX), X12
+	VPADDQ     Y12, Y4, Y4
+	VPADDQ     Y6, Y4, Y4
+	VPSRLQ     $29, Y4, Y6
+	VPAND      Y15, Y4, Y4
+
+	VPCMPEQD   X13, X13, X13
+	VPGATHERDD X13, 20(SI)(X14*1), X5
+	VPMOVZXDQ  X5, Y5
+	VMOVD      20(CX), X12
+	VPADDQ     Y12, Y5, Y5
+	VPADDQ     Y6, Y5, Y5
+	VPSRLQ     $29, Y5, Y6
+	VPAND      Y15, Y5, Y5
+
+	VPSLLQ $2, Y6, Y7
+	VPADDQ Y6, Y7, Y7
+	VPADDQ Y7, Y0, Y0
+
+	// Compute each column of the products with the powers of r, as
+	// poly1795MulAcc does, and add its low and high halves over the lanes.
+	VPMULUDQ     0(DX), Y0, Y8
+	VPMULUDQ     160(DI), Y1, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     128(DI), Y2, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     96(DI), Y3, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     64(DI), Y4, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     32(DI), Y5, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPAND        Y15, Y8, Y10
+	VEXTRACTI128 $1, Y10, X11
+	VPADDQ       X11, X10, X10
+	VPSHUFD      $0x4e, X10, X11
+	VPADDQ       X11, X10, X10
+	VMOVQ        X10, R9
+	ADDQ         R9, 0(AX)
+	VPSRLQ       $29, Y8, Y10
+	VEXTRACTI128 $1, Y10, X11
+	VPADDQ       X11, X10, X10
+	VPSHUFD      $0x4e, X10, X11
+	VPADDQ       X11, X10, X10
+	VMOVQ        X10, R9
+	ADDQ         R9, 0(BX)
+
+	VPMULUDQ     32(DX), Y0, Y8
+	VPMULUDQ     0(DX), Y1, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     160(DI), Y2, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     128(DI), Y3, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     96(DI), Y4, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     64(DI), Y5, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPAND        Y15, Y8, Y10
+	VEXTRACTI128 $1, Y10, X11
+	VPADDQ       X11, X10, X10
+	VPSHUFD      $0x4e, X10, X11
+	VPADDQ       X11, X10, X10
+	VMOVQ        X10, R9
+	ADDQ         R9, 8(AX)
+	VPSRLQ       $29, Y8, Y10
+	VEXTRACTI128 $1, Y10, X11
+	VPADDQ       X11, X10, X10
+	VPSHUFD      $0x4e, X10, X11
+	VPADDQ       X11, X10, X10
+	VMOVQ        X10, R9
+	ADDQ         R9, 8(BX)
+
+	VPMULUDQ     64(DX), Y0, Y8
+	VPMULUDQ     32(DX), Y1, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     0(DX), Y2, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     160(DI), Y3, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     128(DI), Y4, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     96(DI), Y5, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPAND        Y15, Y8, Y10
+	VEXTRACTI128 $1, Y10, X11
+	VPADDQ       X11, X10, X10
+	VPSHUFD      $0x4e, X10, X11
+	VPADDQ       X11, X10, X10
+	VMOVQ        X10, R9
+	ADDQ         R9, 16(AX)
+	VPSRLQ       $29, Y8, Y10
+	VEXTRACTI128 $1, Y10, X11
+	VPADDQ       X11, X10, X10
+	VPSHUFD      $0x4e, X10, X11
+	VPADDQ       X11, X10, X10
+	VMOVQ        X10, R9
+	ADDQ         R9, 16(BX)
+
+	VPMULUDQ     96(DX), Y0, Y8
+	VPMULUDQ     64(DX), Y1, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     32(DX), Y2, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     0(DX), Y3, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     160(DI), Y4, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     128(DI), Y5, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPAND        Y15, Y8, Y10
+	VEXTRACTI128 $1, Y10, X11
+	VPADDQ       X11, X10, X10
+	VPSHUFD      $0x4e, X10, X11
+	VPADDQ       X11, X10, X10
+	VMOVQ        X10, R9
+	ADDQ         R9, 24(AX)
+	VPSRLQ       $29, Y8, Y10
+	VEXTRACTI128 $1, Y10, X11
+	VPADDQ       X11, X10, X10
+	VPSHUFD      $0x4e, X10, X11
+	VPADDQ       X11, X10, X10
+	VMOVQ        X10, R9
+	ADDQ         R9, 24(BX)
+
+	VPMULUDQ     128(DX), Y0, Y8
+	VPMULUDQ     96(DX), Y1, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     64(DX), Y2, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     32(DX), Y3, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     0(DX), Y4, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     160(DI), Y5, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPAND        Y15, Y8, Y10
+	VEXTRACTI128 $1, Y10, X11
+	VPADDQ       X11, X10, X10
+	VPSHUFD      $0x4e, X10, X11
+	VPADDQ       X11, X10, X10
+	VMOVQ        X10, R9
+	ADDQ         R9, 32(AX)
+	VPSRLQ       $29, Y8, Y10
+	VEXTRACTI128 $1, Y10, X11
+	VPADDQ       X11, X10, X10
+	VPSHUFD      $0x4e, X10, X11
+	VPADDQ       X11, X10, X10
+	VMOVQ        X10, R9
+	ADDQ         R9, 32(BX)
+
+	VPMULUDQ     160(DX), Y0, Y8
+	VPMULUDQ     128(DX), Y1, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     96(DX), Y2, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     64(DX), Y3, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     32(DX), Y4, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPMULUDQ     0(DX), Y5, Y9
+	VPADDQ       Y9, Y8, Y8
+	VPAND        Y15, Y8, Y10
+	VEXTRACTI128 $1, Y10, X11
+	VPADDQ       X11, X10, X10
+	VPSHUFD      $0x4e, X10, X11
+	VPADDQ       X11, X10, X10
+	VMOVQ        X10, R9
+	ADDQ         R9, 40(AX)
+	VPSRLQ       $29, Y8, Y10
+	VEXTRACTI128 $1, Y10, X11
+	VPADDQ       X11, X10, X10
+	VPSHUFD      $0x4e, X10, X11
+	VPADDQ       X11, X10, X10
+	VMOVQ        X10, R9
+	ADDQ         R9, 40(BX)
+	VZEROUPPER
+	RET
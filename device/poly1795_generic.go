@@ -0,0 +1,15 @@
+//go:build wg_experimental && (!amd64 || purego)
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+// poly1795HasAVX2 selects the vectorized four-block step of Poly1795.
+const poly1795HasAVX2 = false
+
+func poly1795MulAcc4(lo, hi *[6]uint64, h *[6]uint32, blocks *[4 * 24]byte, r, s *[6][4]uint64) {
+	poly1795MulAcc4Generic(lo, hi, h, blocks, r, s)
+}
@@ -0,0 +1,71 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+/* Vectorized Poly1795
+ *
+ * The four-block step of Poly1795 multiplies the four blocks by r^4, r^3,
+ * r^2 and r, which are independent of each other until their products are
+ * summed. The vectorized step computes them side by side, block k in lane k
+ * of each vector, with the powers of r laid out to match: limb j of r^(4-k)
+ * in lane k of the j-th vector. The amd64 assembly does so with AVX2, four
+ * 32x32-bit multiplications per instruction, and a portable version in Go
+ * stands in for it on other CPUs, and in builds with the purego tag. Both
+ * give exactly the sums of the scalar step, so the tags do not depend on
+ * which ran.
+ */
+
+// Poly1795SumVec is Poly1795Sum with the vectorized four-block step, in AVX2
+// assembly on amd64 CPUs with AVX2 and in portable code otherwise.
+// Poly1795Sum and the other users of Poly1795 select it on CPUs with AVX2.
+func Poly1795SumVec(out *[24]byte, m []byte, key *[32]byte) {
+	var mac poly1795MAC
+	mac.init(key)
+	mac.vec = true
+	mac.Write(m)
+	mac.Sum(out[:0])
+}
+
+// computeVecPowers lays out r^4, r^3, r^2 and r, and five times each, in
+// the lanes of the vectorized step.
+func (m *poly1795MAC) computeVecPowers() {
+	for k := 0; k < 4; k++ {
+		r, s := m.pow(4 - k)
+		for j := range r {
+			m.rVec[j][k], m.sVec[j][k] = uint64(r[j]), uint64(s[j])
+		}
+	}
+}
+
+// processBlocksVec processes four full blocks with the vectorized step.
+func (m *poly1795MAC) processBlocksVec(blocks []byte) {
+	var lo, hi [6]uint64
+	poly1795MulAcc4(&lo, &hi, &m.h, (*[4 * 24]byte)(blocks), &m.rVec, &m.sVec)
+	poly1795Reduce(&m.h, &lo, &hi)
+}
+
+// poly1795MulAcc4Generic adds to lo and hi the columns of
+// (h+m1)*r^4 + m2*r^3 + m3*r^2 + m4*r for the four blocks m1 to m4, given
+// the powers of r in lanes: r[j][k] is limb j of r^(4-k), and s[j][k] five
+// times it.
+func poly1795MulAcc4Generic(lo, hi *[6]uint64, h *[6]uint32, blocks *[4 * 24]byte, r, s *[6][4]uint64) {
+	for k := 0; k < 4; k++ {
+		t := poly1795Words(blocks[k*24:])
+		var a [6]uint32
+		if k == 0 {
+			a = poly1795Add(h, &t)
+		} else {
+			a = poly1795Add(&[6]uint32{}, &t)
+		}
+		var rk, sk [6]uint32
+		for j := range rk {
+			rk[j], sk[j] = uint32(r[j][k]), uint32(s[j][k])
+		}
+		poly1795MulAcc(lo, hi, &a, &rk, &sk)
+	}
+}
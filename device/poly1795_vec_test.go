@@ -0,0 +1,104 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"crypto/rand"
+	"testing"
+)
+
+// poly1795SumScalar is Poly1795Sum without the vectorized step.
+func poly1795SumScalar(out *[24]byte, m []byte, key *[32]byte) {
+	var mac poly1795MAC
+	mac.init(key)
+	mac.vec = false
+	mac.Write(m)
+	mac.Sum(out[:0])
+}
+
+func TestPoly1795SumVec(t *testing.T) {
+	var key [32]byte
+	msg := make([]byte, 1000)
+	for size := 0; size <= len(msg); size += 1 + size/50 {
+		rand.Read(key[:])
+		rand.Read(msg[:size])
+		want := poly1795Reference(msg[:size], &key)
+		var vec, scalar [24]byte
+		Poly1795SumVec(&vec, msg[:size], &key)
+		poly1795SumScalar(&scalar, msg[:size], &key)
+		if vec != want || scalar != want {
+			t.Fatalf("size %d: vectorized %x, scalar %x, want %x", size, vec, scalar, want)
+		}
+	}
+
+	for i := range key {
+		key[i] = 0xff
+	}
+	for i := range msg {
+		msg[i] = 0xff
+	}
+	var vec [24]byte
+	Poly1795SumVec(&vec, msg, &key)
+	if want := poly1795Reference(msg, &key); vec != want {
+		t.Fatalf("saturated: got %x, want %x", vec, want)
+	}
+}
+
+func TestPoly1795MulAcc4(t *testing.T) {
+	if !poly1795HasAVX2 {
+		t.Log("no AVX2, testing the portable step against itself")
+	}
+	var mac poly1795MAC
+	for i := 0; i < 1000; i++ {
+		var key [32]byte
+		var blocks [4 * 24]byte
+		var h [6]uint32
+		if i%2 == 0 {
+			rand.Read(key[:])
+			rand.Read(blocks[:])
+			for j := range h {
+				var b [4]byte
+				rand.Read(b[:])
+				h[j] = uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+				h[j] &= poly1795Mask
+			}
+		} else {
+			// Saturated limbs give the largest columns.
+			for j := range key {
+				key[j] = 0xff
+			}
+			for j := range blocks {
+				blocks[j] = 0xff
+			}
+			for j := range h {
+				h[j] = poly1795Mask
+			}
+			h[1] += 1 << 4 // as poly1795Reduce may leave it
+		}
+		mac.init(&key)
+		mac.vec = true
+		mac.computePowers()
+
+		var lo, hi, wantLo, wantHi [6]uint64
+		poly1795MulAcc4(&lo, &hi, &h, &blocks, &mac.rVec, &mac.sVec)
+		poly1795MulAcc4Generic(&wantLo, &wantHi, &h, &blocks, &mac.rVec, &mac.sVec)
+		if lo != wantLo || hi != wantHi {
+			t.Fatalf("columns %x %x, want %x %x", lo, hi, wantLo, wantHi)
+		}
+
+		// The portable step matches the scalar one.
+		mac.h = h
+		mac.processBlocks(blocks[:])
+		scalar := mac.h
+		mac.h = h
+		mac.processBlocksVec(blocks[:])
+		if mac.h != scalar {
+			t.Fatalf("vectorized step %x, scalar %x", mac.h, scalar)
+		}
+	}
+}
@@ -47,23 +47,29 @@ func (p *WaitPool) Put(x any) {
 }
 
 func (device *Device) PopulatePools() {
-	device.pool.inboundElementsContainer = NewWaitPool(PreallocatedBuffersPerPool, func() any {
+	device.pool.inboundElementsContainer = NewWaitPool(device.limits.PreallocatedBuffersPerPool, func() any {
 		s := make([]*QueueInboundElement, 0, device.BatchSize())
 		return &QueueInboundElementsContainer{elems: s}
 	})
-	device.pool.outboundElementsContainer = NewWaitPool(PreallocatedBuffersPerPool, func() any {
+	device.pool.outboundElementsContainer = NewWaitPool(device.limits.PreallocatedBuffersPerPool, func() any {
 		s := make([]*QueueOutboundElement, 0, device.BatchSize())
 		return &QueueOutboundElementsContainer{elems: s}
 	})
-	device.pool.messageBuffers = NewWaitPool(PreallocatedBuffersPerPool, func() any {
+	device.pool.messageBuffers = NewWaitPool(device.limits.PreallocatedBuffersPerPool, func() any {
 		return new([MaxMessageSize]byte)
 	})
-	device.pool.inboundElements = NewWaitPool(PreallocatedBuffersPerPool, func() any {
+	device.pool.inboundElements = NewWaitPool(device.limits.PreallocatedBuffersPerPool, func() any {
 		return new(QueueInboundElement)
 	})
-	device.pool.outboundElements = NewWaitPool(PreallocatedBuffersPerPool, func() any {
+	device.pool.outboundElements = NewWaitPool(device.limits.PreallocatedBuffersPerPool, func() any {
 		return new(QueueOutboundElement)
 	})
+	device.pool.messageInitiations = NewWaitPool(0, func() any {
+		return new(MessageInitiation)
+	})
+	device.pool.messageResponses = NewWaitPool(0, func() any {
+		return new(MessageResponse)
+	})
 }
 
 func (device *Device) GetInboundElementsContainer() *QueueInboundElementsContainer {
@@ -77,9 +83,20 @@ func (device *Device) PutInboundElementsContainer(c *QueueInboundElementsContain
 		c.elems[i] = nil
 	}
 	c.elems = c.elems[:0]
+	c.dropped = false
 	device.pool.inboundElementsContainer.Put(c)
 }
 
+// putInboundElements returns the elements of c and their buffers, and c
+// itself, to their pools.
+func (device *Device) putInboundElements(c *QueueInboundElementsContainer) {
+	for _, elem := range c.elems {
+		device.PutMessageBuffer(elem.buffer)
+		device.PutInboundElement(elem)
+	}
+	device.PutInboundElementsContainer(c)
+}
+
 func (device *Device) GetOutboundElementsContainer() *QueueOutboundElementsContainer {
 	c := device.pool.outboundElementsContainer.Get().(*QueueOutboundElementsContainer)
 	c.Mutex = sync.Mutex{}
@@ -91,9 +108,21 @@ func (device *Device) PutOutboundElementsContainer(c *QueueOutboundElementsConta
 		c.elems[i] = nil
 	}
 	c.elems = c.elems[:0]
+	c.endpoint = nil
+	c.dropped = false
 	device.pool.outboundElementsContainer.Put(c)
 }
 
+// putOutboundElements returns the elements of c and their buffers, and c
+// itself, to their pools.
+func (device *Device) putOutboundElements(c *QueueOutboundElementsContainer) {
+	for _, elem := range c.elems {
+		device.PutMessageBuffer(elem.buffer)
+		device.PutOutboundElement(elem)
+	}
+	device.PutOutboundElementsContainer(c)
+}
+
 func (device *Device) GetMessageBuffer() *[MaxMessageSize]byte {
 	return device.pool.messageBuffers.Get().(*[MaxMessageSize]byte)
 }
@@ -119,3 +148,21 @@ func (device *Device) PutOutboundElement(elem *QueueOutboundElement) {
 	elem.clearPointers()
 	device.pool.outboundElements.Put(elem)
 }
+
+func (device *Device) GetMessageInitiation() *MessageInitiation {
+	return device.pool.messageInitiations.Get().(*MessageInitiation)
+}
+
+func (device *Device) PutMessageInitiation(msg *MessageInitiation) {
+	*msg = MessageInitiation{}
+	device.pool.messageInitiations.Put(msg)
+}
+
+func (device *Device) GetMessageResponse() *MessageResponse {
+	return device.pool.messageResponses.Get().(*MessageResponse)
+}
+
+func (device *Device) PutMessageResponse(msg *MessageResponse) {
+	*msg = MessageResponse{}
+	device.pool.messageResponses.Put(msg)
+}
@@ -0,0 +1,154 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"golang.zx2c4.com/wireguard/conn"
+)
+
+// A portListener is one open UDP port of the device. The first listener is
+// always device.net.bind on device.net.port; any further ones are clones of
+// it opened on the additional ports configured with listen_ports.
+type portListener struct {
+	port    uint16
+	bind    conn.Bind
+	rxBytes atomic.Uint64
+	txBytes atomic.Uint64
+}
+
+// A portEndpoint is an endpoint learned from a packet received on an
+// additional listener. It remembers the listener so that replies leave from
+// the port the peer contacted.
+type portEndpoint struct {
+	conn.Endpoint
+	listener *portListener
+}
+
+// PortStatus holds traffic statistics for one listening port.
+type PortStatus struct {
+	Port          int
+	ReceiveBytes  int64
+	TransmitBytes int64
+}
+
+// sendTo sends bufs to ep through the listener ep was learned on.
+func (device *Device) sendTo(bufs [][]byte, ep conn.Endpoint) error {
+	l := (*portListener)(nil)
+	bind := device.net.bind
+	if pe, ok := ep.(*portEndpoint); ok {
+		l, bind, ep = pe.listener, pe.listener.bind, pe.Endpoint
+	} else if len(device.net.listeners) != 0 {
+		l = device.net.listeners[0]
+	}
+	err := bind.Send(bufs, ep)
+	device.noteSendResult(err)
+	if err == nil && l != nil {
+		var totalLen uint64
+		for _, b := range bufs {
+			totalLen += uint64(len(b))
+		}
+		l.txBytes.Add(totalLen)
+	}
+	return err
+}
+
+// openExtraListenersLocked opens a clone of the primary bind on each
+// additional configured port. It must be called with device.net held.
+func (device *Device) openExtraListenersLocked(ctx context.Context) (recvFns [][]conn.ReceiveFunc, err error) {
+	netc := &device.net
+	if len(netc.ports) == 0 {
+		return nil, nil
+	}
+	cloner, ok := netc.bind.(conn.BindCloner)
+	if !ok {
+		return nil, errors.New("bind does not support multiple listen ports")
+	}
+	for _, port := range netc.ports {
+		if port == netc.port {
+			continue
+		}
+		bind := cloner.Clone()
+		if device.ICMPErrorPolicy() != ICMPErrorsIgnore {
+			if err := device.reportICMPErrors(bind); err != nil {
+				return recvFns, fmt.Errorf("port %d: %w", port, err)
+			}
+		}
+		fns, actualPort, err := conn.OpenContext(ctx, bind, port)
+		if err != nil {
+			return recvFns, fmt.Errorf("port %d: %w", port, err)
+		}
+		l := &portListener{port: actualPort, bind: bind}
+		netc.listeners = append(netc.listeners, l)
+		recvFns = append(recvFns, fns)
+		if netc.fwmark != 0 {
+			if err := bind.SetMark(netc.fwmark); err != nil {
+				return recvFns, err
+			}
+		}
+	}
+	return recvFns, nil
+}
+
+// parseListenPorts parses a comma-separated listen_ports value. The first port
+// becomes the primary listen_port and may be zero for a random port.
+func parseListenPorts(value string) ([]uint16, error) {
+	fields := strings.Split(value, ",")
+	if len(fields) > MaxListenPorts {
+		return nil, fmt.Errorf("more than %d ports", MaxListenPorts)
+	}
+	ports := make([]uint16, 0, len(fields))
+	for i, field := range fields {
+		port, err := strconv.ParseUint(strings.TrimSpace(field), 10, 16)
+		if err != nil {
+			return nil, err
+		}
+		if i != 0 && port == 0 {
+			return nil, errors.New("additional ports must not be zero")
+		}
+		for _, p := range ports {
+			if p == uint16(port) {
+				return nil, fmt.Errorf("duplicate port %d", port)
+			}
+		}
+		ports = append(ports, uint16(port))
+	}
+	return ports, nil
+}
+
+// setListenPorts replaces the primary and additional listening ports and
+// rebinds.
+func (device *Device) setListenPorts(ctx context.Context, ports []uint16) error {
+	device.net.Lock()
+	device.net.port = ports[0]
+	device.net.ports = append([]uint16(nil), ports[1:]...)
+	device.net.Unlock()
+	return device.bindUpdate(ctx)
+}
+
+// portStatusLocked returns the per-port statistics of the open listeners, or
+// nil if the device only listens on a single port. It must be called with
+// device.net held.
+func (device *Device) portStatusLocked() []PortStatus {
+	if len(device.net.listeners) < 2 {
+		return nil
+	}
+	status := make([]PortStatus, 0, len(device.net.listeners))
+	for _, l := range device.net.listeners {
+		status = append(status, PortStatus{
+			Port:          int(l.port),
+			ReceiveBytes:  int64(l.rxBytes.Load()),
+			TransmitBytes: int64(l.txBytes.Load()),
+		})
+	}
+	return status
+}
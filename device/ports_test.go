@@ -0,0 +1,56 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"fmt"
+	"net"
+	"testing"
+)
+
+func TestListenPorts(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, true)
+
+	// Find a free port for the second listener.
+	c, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	extra := c.LocalAddr().(*net.UDPAddr).Port
+	c.Close()
+
+	dev0 := pair[0].dev
+	primary := int(dev0.net.port)
+	if err := dev0.IpcSet(uapiCfg("listen_ports", fmt.Sprintf("%d,%d", primary, extra))); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev0.IpcSet(uapiCfg("listen_ports", fmt.Sprintf("%d,%d", extra, extra))); err == nil {
+		t.Error("duplicate listen_ports accepted")
+	}
+
+	// Point the other device at the additional port.
+	pub0 := dev0.staticIdentity.publicKey
+	if err := pair[1].dev.IpcSet(uapiCfg(
+		"public_key", fmt.Sprintf("%x", pub0[:]),
+		"endpoint", fmt.Sprintf("127.0.0.1:%d", extra),
+	)); err != nil {
+		t.Fatal(err)
+	}
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+
+	ps := dev0.Status().ListenPorts
+	if len(ps) != 2 || ps[0].Port != primary || ps[1].Port != extra {
+		t.Fatalf("unexpected listen ports: %+v", ps)
+	}
+	if ps[1].ReceiveBytes == 0 || ps[1].TransmitBytes == 0 {
+		t.Errorf("no traffic on additional port: %+v", ps[1])
+	}
+	if ps[0].TransmitBytes != 0 {
+		t.Errorf("replies left from primary port: %+v", ps[0])
+	}
+}
@@ -0,0 +1,267 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"crypto/mlkem"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+/* Post-quantum hybrid handshakes mix the shared secret of an ML-KEM-768
+ * encapsulation into the chaining key alongside the Curve25519 results, so
+ * that the keys of a session stay secret to an attacker who records the
+ * handshake and later breaks X25519, as long as ML-KEM holds. A peer in
+ * hybrid mode sends hybrid initiations, which carry an ephemeral ML-KEM
+ * encapsulation key, and a responder with the initiator in hybrid mode
+ * answers them with hybrid responses, which carry a ciphertext
+ * encapsulated to it:
+ *
+ *   initiation (148 bytes, type 6) | ChaCha20Poly1305(initiation key, ZeroNonce, encapsulation key, initiation)
+ *   response (92 bytes, type 7) | ChaCha20Poly1305(response key, ZeroNonce, ciphertext, response)
+ *
+ * followed by the trailers of cipher suite negotiation and response data, if
+ * any. Apart from their type, the first bytes are those of standard messages,
+ * with their MACs computed over them alone. The initiation key is derived
+ * from the chaining key after the initiation and the response key from the
+ * final one, after which both sides mix in the shared secret.
+ *
+ * A responder with the initiator not in hybrid mode answers hybrid
+ * initiations with standard responses, and sessions are then established
+ * without ML-KEM. Stock peers drop hybrid initiations, so retransmissions of
+ * a handshake alternate between hybrid and standard initiations, as with
+ * cipher suite offers. Hybrid mode thus falls back to the standard handshake
+ * whenever the responder does not take part, including when an attacker
+ * drops hybrid initiations in flight: HybridSession reports whether the
+ * current session is protected.
+ */
+
+const (
+	WGLabelHybridInitiation = "pq hybrid initiation"
+	WGLabelHybridResponse   = "pq hybrid response"
+)
+
+const (
+	MessageInitiationHybridType = 6
+	MessageResponseHybridType   = 7
+
+	MessageHybridInitiationTrailerSize = mlkem.EncapsulationKeySize768 + chacha20poly1305.Overhead  // size of the encapsulation key trailing a hybrid initiation
+	MessageHybridResponseTrailerSize   = mlkem.CiphertextSize768 + chacha20poly1305.Overhead        // size of the ciphertext trailing a hybrid response
+	MessageInitiationHybridSize        = MessageInitiationSize + MessageHybridInitiationTrailerSize // size of a hybrid initiation without offer
+	MessageResponseHybridSize          = MessageResponseSize + MessageHybridResponseTrailerSize     // size of a hybrid response without selection or data
+)
+
+// maxHandshakeMessageSize is the size of the largest handshake message sent.
+const maxHandshakeMessageSize = max(
+	MaxHandshakePadding,
+	MessageInitiationHybridSize+MessageCipherSuiteOfferSize,
+	MessageResponseHybridSize+MessageCipherSuiteSelectionSize+MessageResponseDataOverhead+MaxResponseDataSize,
+)
+
+// A PQMode is whether handshakes with a peer are post-quantum hybrid ones.
+type PQMode int
+
+const (
+	PQModeOff    PQMode = iota // standard handshakes only
+	PQModeHybrid               // hybrid handshakes, falling back to standard ones
+	numPQModes
+)
+
+var pqModeNames = [numPQModes]string{"off", "hybrid"}
+
+func (mode PQMode) String() string {
+	if mode >= 0 && mode < numPQModes {
+		return pqModeNames[mode]
+	}
+	return fmt.Sprintf("PQMode(%d)", int(mode))
+}
+
+// ParsePQMode returns the mode named s, as printed by PQMode.String.
+func ParsePQMode(s string) (PQMode, error) {
+	for mode, name := range pqModeNames {
+		if s == name {
+			return PQMode(mode), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown post-quantum mode %q", s)
+}
+
+// pqHybridHandshake is the state of the ML-KEM exchange of the handshake in
+// progress.
+type pqHybridHandshake struct {
+	decapsulationKey *mlkem.DecapsulationKey768 // of the hybrid initiation sent, on the initiator's side
+	encapsulationKey *mlkem.EncapsulationKey768 // of the hybrid initiation answered, on the responder's side
+	mixed            bool                       // whether a shared secret is mixed into the chaining key
+}
+
+// SetPQMode sets whether handshakes with the peer are hybrid ones, from the
+// next handshake on.
+func (peer *Peer) SetPQMode(mode PQMode) error {
+	if mode < 0 || mode >= numPQModes {
+		return fmt.Errorf("invalid post-quantum mode %v", mode)
+	}
+	peer.pqMode.Store(int32(mode))
+	return nil
+}
+
+// PQMode returns the mode set with SetPQMode.
+func (peer *Peer) PQMode() PQMode {
+	return PQMode(peer.pqMode.Load())
+}
+
+// HybridSession reports whether the keys of the current session were
+// derived with an ML-KEM shared secret.
+func (peer *Peer) HybridSession() bool {
+	keypair := peer.keypairs.Current()
+	return keypair != nil && keypair.hybrid
+}
+
+// hybridInitiationType returns the type of the initiation being created,
+// and generates its ML-KEM key if it is a hybrid one: if the peer is in
+// hybrid mode, for the first of each two attempts of a handshake, so that
+// stock peers answer the others. The handshake must be locked.
+func (peer *Peer) hybridInitiationType() (uint32, error) {
+	handshake := &peer.handshake
+	handshake.hybrid = pqHybridHandshake{}
+	if peer.PQMode() != PQModeHybrid || peer.timers.handshakeAttempts.Load()%2 != 0 {
+		return MessageInitiationType, nil
+	}
+	dk, err := mlkem.GenerateKey768()
+	if err != nil {
+		return 0, err
+	}
+	handshake.hybrid.decapsulationKey = dk
+	return MessageInitiationHybridType, nil
+}
+
+// sealHybridInitiation appends the encapsulation key of a hybrid initiation
+// created but not yet sent.
+func (peer *Peer) sealHybridInitiation(packet []byte) []byte {
+	handshake := &peer.handshake
+	handshake.mutex.RLock()
+	defer handshake.mutex.RUnlock()
+	dk := handshake.hybrid.decapsulationKey
+	if dk == nil || handshake.state != handshakeInitiationCreated {
+		return packet
+	}
+
+	var key [blake2s.Size]byte
+	handshake.handshakeHash().kdf1(&key, handshake.chainKey[:], []byte(WGLabelHybridInitiation))
+	aead, _ := chacha20poly1305.New(key[:])
+	setZero(key[:])
+	return aead.Seal(packet, ZeroNonce[:], dk.EncapsulationKey().Bytes(), packet)
+}
+
+var (
+	errHybridInitiation = errors.New("invalid ML-KEM encapsulation key")
+	errHybridResponse   = errors.New("invalid ML-KEM ciphertext")
+	errHybridUnexpected = errors.New("hybrid response to a standard initiation")
+)
+
+// openHybridInitiation reads the encapsulation key trailing a consumed
+// initiation of type msgType, if it is a hybrid one and the peer is in
+// hybrid mode, and returns the rest of the trailer. The response to an
+// initiation whose key was read is a hybrid one.
+func (peer *Peer) openHybridInitiation(msgType uint32, packet, trailer []byte) ([]byte, error) {
+	handshake := &peer.handshake
+	handshake.mutex.Lock()
+	defer handshake.mutex.Unlock()
+	handshake.hybrid = pqHybridHandshake{}
+	if msgType != MessageInitiationHybridType || handshake.state != handshakeInitiationConsumed {
+		return trailer, nil
+	}
+	sealed, rest := trailer[:MessageHybridInitiationTrailerSize], trailer[MessageHybridInitiationTrailerSize:]
+	if peer.PQMode() != PQModeHybrid {
+		return rest, nil
+	}
+
+	var key [blake2s.Size]byte
+	handshake.handshakeHash().kdf1(&key, handshake.chainKey[:], []byte(WGLabelHybridInitiation))
+	aead, _ := chacha20poly1305.New(key[:])
+	setZero(key[:])
+	b, err := aead.Open(nil, ZeroNonce[:], sealed, packet)
+	if err != nil {
+		return nil, errHybridInitiation
+	}
+	ek, err := mlkem.NewEncapsulationKey768(b)
+	if err != nil {
+		return nil, errHybridInitiation
+	}
+	handshake.hybrid.encapsulationKey = ek
+	return rest, nil
+}
+
+// responseType returns the type of the response being created. The
+// handshake must be locked.
+func (h *Handshake) responseType() uint32 {
+	if h.hybrid.encapsulationKey != nil {
+		return MessageResponseHybridType
+	}
+	return MessageResponseType
+}
+
+// sealHybridResponse appends a ciphertext encapsulated to the key of the
+// initiation answered to a hybrid response created but not yet turned into
+// a session, and mixes its shared secret into the chaining key.
+func (peer *Peer) sealHybridResponse(packet []byte) []byte {
+	handshake := &peer.handshake
+	handshake.mutex.Lock()
+	defer handshake.mutex.Unlock()
+	ek := handshake.hybrid.encapsulationKey
+	if ek == nil || handshake.state != handshakeResponseCreated {
+		return packet
+	}
+	handshake.hybrid.encapsulationKey = nil
+
+	ss, ciphertext := ek.Encapsulate()
+	var key [blake2s.Size]byte
+	handshake.handshakeHash().kdf1(&key, handshake.chainKey[:], []byte(WGLabelHybridResponse))
+	aead, _ := chacha20poly1305.New(key[:])
+	setZero(key[:])
+	packet = aead.Seal(packet, ZeroNonce[:], ciphertext, packet[:MessageResponseSize])
+	handshake.mixKey(ss)
+	setZero(ss)
+	handshake.hybrid.mixed = true
+	return packet
+}
+
+// openHybridResponse reads the ciphertext trailing a consumed response of
+// type msgType, if it is a hybrid one, mixes its shared secret into the
+// chaining key, and returns the rest of the trailer. A standard response to
+// a hybrid initiation leaves the session without ML-KEM.
+func (peer *Peer) openHybridResponse(msgType uint32, packet, trailer []byte) ([]byte, error) {
+	handshake := &peer.handshake
+	handshake.mutex.Lock()
+	defer handshake.mutex.Unlock()
+	dk := handshake.hybrid.decapsulationKey
+	handshake.hybrid.decapsulationKey = nil
+	if msgType != MessageResponseHybridType || handshake.state != handshakeResponseConsumed {
+		return trailer, nil
+	}
+	if dk == nil {
+		return nil, errHybridUnexpected
+	}
+
+	var key [blake2s.Size]byte
+	handshake.handshakeHash().kdf1(&key, handshake.chainKey[:], []byte(WGLabelHybridResponse))
+	aead, _ := chacha20poly1305.New(key[:])
+	setZero(key[:])
+	ciphertext, err := aead.Open(nil, ZeroNonce[:], trailer[:MessageHybridResponseTrailerSize], packet)
+	if err != nil {
+		return nil, errHybridResponse
+	}
+	ss, err := dk.Decapsulate(ciphertext)
+	if err != nil {
+		return nil, errHybridResponse
+	}
+	handshake.mixKey(ss)
+	setZero(ss)
+	handshake.hybrid.mixed = true
+	return trailer[MessageHybridResponseTrailerSize:], nil
+}
@@ -0,0 +1,166 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+// hybridHandshake runs a handshake from initiator to the responder's peer,
+// passing the initiation and response through tamper, and returns the error
+// of the end that rejected it, if any.
+func hybridHandshake(t *testing.T, dev1, dev2 *Device, initiator, responder *Peer, tamper func(msgType uint32, packet []byte)) error {
+	t.Helper()
+	var buf [MaxMessageSize]byte
+	msg1, err := dev1.CreateMessageInitiation(initiator)
+	assertNil(t, err)
+	packet := buf[:MessageInitiationSize]
+	assertNil(t, msg1.marshal(packet))
+	packet = initiator.sealHybridInitiation(packet)
+	if tamper != nil {
+		tamper(msg1.Type, packet)
+	}
+	if dev2.ConsumeMessageInitiation(msg1) != responder {
+		t.Fatal("handshake failed at initiation message")
+	}
+	if _, err := responder.openHybridInitiation(msg1.Type, packet[:MessageInitiationSize], packet[MessageInitiationSize:]); err != nil {
+		return err
+	}
+
+	msg2, err := dev2.CreateMessageResponse(responder)
+	assertNil(t, err)
+	packet = buf[:MessageResponseSize]
+	assertNil(t, msg2.marshal(packet))
+	packet = responder.sealHybridResponse(packet)
+	if tamper != nil {
+		tamper(msg2.Type, packet)
+	}
+	if dev1.ConsumeMessageResponse(msg2) != initiator {
+		t.Fatal("handshake failed at response message")
+	}
+	if _, err := initiator.openHybridResponse(msg2.Type, packet[:MessageResponseSize], packet[MessageResponseSize:]); err != nil {
+		return err
+	}
+
+	assertNil(t, initiator.BeginSymmetricSession())
+	assertNil(t, responder.BeginSymmetricSession())
+	sent, received := initiator.keypairs.Current(), responder.keypairs.next.Load()
+	if sent.hybrid != received.hybrid {
+		t.Fatalf("hybrid session on one end only")
+	}
+	var nonce [maxTransportNonceSize]byte
+	ciphertext := sent.send.Seal(nil, nonce[:sent.send.NonceSize()], []byte("hybrid"), nil)
+	if plaintext, err := received.receive.Open(nil, nonce[:received.receive.NonceSize()], ciphertext, nil); err != nil || !bytes.Equal(plaintext, []byte("hybrid")) {
+		t.Fatal("ends of the session derived different keys")
+	}
+	return nil
+}
+
+func TestHybridHandshake(t *testing.T) {
+	dev1 := randDevice(t)
+	dev2 := randDevice(t)
+	defer dev1.Close()
+	defer dev2.Close()
+	peer1, err := dev2.NewPeer(dev1.staticIdentity.privateKey.publicKey())
+	assertNil(t, err)
+	peer2, err := dev1.NewPeer(dev2.staticIdentity.privateKey.publicKey())
+	assertNil(t, err)
+	peer1.Start()
+	peer2.Start()
+
+	flip := func(want uint32, offset int) func(uint32, []byte) {
+		return func(msgType uint32, packet []byte) {
+			if msgType == want {
+				packet[offset] ^= 1
+			}
+		}
+	}
+	for _, tt := range []struct {
+		name      string
+		initiator PQMode
+		responder PQMode
+		tamper    func(uint32, []byte)
+		hybrid    bool
+		err       error
+	}{
+		{"hybrid", PQModeHybrid, PQModeHybrid, nil, true, nil},
+		{"responder off", PQModeHybrid, PQModeOff, nil, false, nil},
+		{"initiator off", PQModeOff, PQModeHybrid, nil, false, nil},
+		{"tampered key", PQModeHybrid, PQModeHybrid, flip(MessageInitiationHybridType, MessageInitiationSize+1), false, errHybridInitiation},
+		{"tampered ciphertext", PQModeHybrid, PQModeHybrid, flip(MessageResponseHybridType, MessageResponseSize+1), false, errHybridResponse},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assertNil(t, peer2.SetPQMode(tt.initiator))
+			assertNil(t, peer1.SetPQMode(tt.responder))
+			if err := hybridHandshake(t, dev1, dev2, peer2, peer1, tt.tamper); err != tt.err {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+			if tt.err == nil && peer2.HybridSession() != tt.hybrid {
+				t.Errorf("hybrid session = %v, want %v", peer2.HybridSession(), tt.hybrid)
+			}
+			// Let the next initiation get a newer timestamp.
+			time.Sleep(50 * time.Millisecond)
+		})
+	}
+
+	// Retries alternate with standard initiations, which stock peers answer.
+	assertNil(t, peer2.SetPQMode(PQModeHybrid))
+	peer2.timers.handshakeAttempts.Store(1)
+	msg, err := dev1.CreateMessageInitiation(peer2)
+	assertNil(t, err)
+	if msg.Type != MessageInitiationType {
+		t.Errorf("retry has type %d", msg.Type)
+	}
+	peer2.timers.handshakeAttempts.Store(0)
+
+	// A hybrid response to a standard initiation is rejected.
+	time.Sleep(50 * time.Millisecond)
+	assertNil(t, peer1.SetPQMode(PQModeHybrid))
+	if err := hybridHandshake(t, dev1, dev2, peer2, peer1, func(msgType uint32, packet []byte) {
+		if msgType == MessageInitiationHybridType {
+			peer2.handshake.hybrid.decapsulationKey = nil
+		}
+	}); err != errHybridUnexpected {
+		t.Errorf("got error %v, want %v", err, errHybridUnexpected)
+	}
+}
+
+func TestDevicePQMode(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	peer := pair[0].dev.LookupPeer(pair[1].dev.staticIdentity.publicKey)
+	assertNil(t, peer.SetPQMode(PQModeHybrid))
+	mode := PQModeHybrid
+	if err := pair[1].dev.Configure(Config{Peers: []PeerConfig{{PublicKey: pair[0].dev.staticIdentity.publicKey, PQMode: &mode}}}); err != nil {
+		t.Fatal(err)
+	}
+	peer.ExpireCurrentKeypairs()
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+	if !peer.HybridSession() {
+		t.Fatal("session is not hybrid")
+	}
+
+	status := pair[0].dev.Status()
+	if len(status.Peers) != 1 || status.Peers[0].PQMode != PQModeHybrid || !status.Peers[0].HybridSession {
+		t.Errorf("unexpected peer status %+v", status.Peers)
+	}
+	cfg, err := pair[0].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(cfg, "\npq_mode=hybrid\n") || !strings.Contains(cfg, "\nhybrid_session=true\n") {
+		t.Errorf("get output lacks the post-quantum mode:\n%s", cfg)
+	}
+	pk := pair[1].dev.staticIdentity.publicKey
+	if err := pair[0].dev.IpcSet(uapiCfg("public_key", hex.EncodeToString(pk[:]), "pq_mode", "kyber")); err == nil {
+		t.Error("set unknown post-quantum mode")
+	}
+}
@@ -0,0 +1,121 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/blake2s"
+)
+
+/* Preshared key rotation
+ *
+ * A peer with a PSK rotator mixes a preshared key derived from a root secret
+ * shared with the peer into its handshakes, instead of the configured
+ * preshared key. Time is cut into epochs of the rotation interval since the
+ * Unix epoch, and the key of epoch n is
+ *
+ *   HKDF(root, "psk rotation" || n as 64-bit big endian)
+ *
+ * with the HKDF of the handshake. Both ends rotate on their own: the key of
+ * a handshake is that of the epoch of the timestamp of its initiation, which
+ * the responder reads from the initiation, so that handshakes across an
+ * epoch boundary agree on it. Responders reject initiations whose timestamp
+ * is further than the skew tolerance from their clock, which bounds how
+ * long after its epoch a key leaked from either end is of use. Sessions
+ * keep the key they were established with until their regular rekeying.
+ */
+
+const (
+	WGLabelPSKRotation = "psk rotation"
+
+	MinPSKRotationInterval     = 5 * time.Minute
+	DefaultPSKRotationInterval = time.Hour
+	DefaultPSKRotationSkew     = time.Minute
+)
+
+// A PSKRotator derives the preshared keys of a peer from a root secret. The
+// zero PSKRotator does not rotate keys.
+type PSKRotator struct {
+	Root     NoisePresharedKey // secret shared with the peer; zero to not rotate
+	Interval time.Duration     // length of an epoch; zero for DefaultPSKRotationInterval
+	MaxSkew  time.Duration     // of timestamps of initiations from the local clock; zero for DefaultPSKRotationSkew
+}
+
+// Epoch returns the epoch of t.
+func (r *PSKRotator) Epoch(t time.Time) uint64 {
+	return uint64(max(t.UnixNano(), 0) / int64(r.Interval))
+}
+
+// Key returns the preshared key of the epoch.
+func (r *PSKRotator) Key(epoch uint64) NoisePresharedKey {
+	var input [len(WGLabelPSKRotation) + 8]byte
+	copy(input[:], WGLabelPSKRotation)
+	binary.BigEndian.PutUint64(input[len(WGLabelPSKRotation):], epoch)
+	var key [blake2s.Size]byte
+	KDF1(&key, r.Root[:], input[:])
+	return NoisePresharedKey(key)
+}
+
+// checkSkew returns an error if the timestamp of an initiation at t is too
+// far from now to be accepted.
+func (r *PSKRotator) checkSkew(t, now time.Time) error {
+	skew := now.Sub(t)
+	if skew < 0 {
+		skew = -skew
+	}
+	if skew > r.MaxSkew {
+		return fmt.Errorf("clock skew of %v beyond PSK rotation tolerance", skew.Round(time.Second))
+	}
+	return nil
+}
+
+// SetPSKRotator sets the rotator of the preshared keys of the peer, from the
+// next handshake on. A rotator with a zero root turns rotation off, and
+// handshakes use the configured preshared key again.
+func (peer *Peer) SetPSKRotator(r PSKRotator) error {
+	if r.Interval == 0 {
+		r.Interval = DefaultPSKRotationInterval
+	}
+	if r.MaxSkew == 0 {
+		r.MaxSkew = DefaultPSKRotationSkew
+	}
+	if r.Interval < MinPSKRotationInterval {
+		return fmt.Errorf("rotation interval below minimum of %v", MinPSKRotationInterval)
+	}
+	if r.MaxSkew < 0 || r.MaxSkew >= r.Interval/2 {
+		return fmt.Errorf("skew tolerance of %v not within half the rotation interval", r.MaxSkew)
+	}
+	peer.handshake.mutex.Lock()
+	defer peer.handshake.mutex.Unlock()
+	peer.handshake.pskRotator = r
+	return nil
+}
+
+// PSKRotator returns the rotator set with SetPSKRotator.
+func (peer *Peer) PSKRotator() PSKRotator {
+	peer.handshake.mutex.RLock()
+	defer peer.handshake.mutex.RUnlock()
+	return peer.handshake.pskRotator
+}
+
+// rotating reports whether the handshake derives its preshared key with a
+// rotator. The handshake must be locked.
+func (h *Handshake) rotating() bool {
+	return !isZero(h.pskRotator.Root[:])
+}
+
+// setPSK sets the preshared key of the handshake in progress, whose
+// initiation has its timestamp at t. The handshake must be locked.
+func (h *Handshake) setPSK(t time.Time) {
+	if h.rotating() {
+		h.psk = h.pskRotator.Key(h.pskRotator.Epoch(t))
+	} else {
+		h.psk = h.presharedKey
+	}
+}
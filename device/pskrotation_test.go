@@ -0,0 +1,135 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPSKRotator(t *testing.T) {
+	r := PSKRotator{Root: NoisePresharedKey{1}, Interval: time.Hour}
+	start := time.Unix(1700000000, 0).Truncate(time.Hour)
+	if r.Epoch(start) != r.Epoch(start.Add(time.Hour-time.Nanosecond)) || r.Epoch(start)+1 != r.Epoch(start.Add(time.Hour)) {
+		t.Error("epochs do not follow the interval")
+	}
+	if r.Epoch(time.Unix(-1, 0)) != 0 {
+		t.Error("epoch of time before the Unix epoch is not zero")
+	}
+	if r.Key(1) != r.Key(1) || r.Key(1) == r.Key(2) {
+		t.Error("keys are not derived from the epoch")
+	}
+	other := PSKRotator{Root: NoisePresharedKey{2}, Interval: time.Hour}
+	if other.Key(1) == r.Key(1) {
+		t.Error("keys are not derived from the root")
+	}
+
+	r.MaxSkew = time.Minute
+	if r.checkSkew(start.Add(-time.Minute), start) != nil || r.checkSkew(start.Add(time.Minute), start) != nil {
+		t.Error("timestamp within the skew tolerance rejected")
+	}
+	if r.checkSkew(start.Add(-2*time.Minute), start) == nil || r.checkSkew(start.Add(2*time.Minute), start) == nil {
+		t.Error("timestamp beyond the skew tolerance accepted")
+	}
+
+	var peer Peer
+	assertNil(t, peer.SetPSKRotator(PSKRotator{Root: r.Root}))
+	if set := peer.PSKRotator(); set.Interval != DefaultPSKRotationInterval || set.MaxSkew != DefaultPSKRotationSkew {
+		t.Errorf("defaults not applied: %+v", set)
+	}
+	if peer.SetPSKRotator(PSKRotator{Interval: time.Minute}) == nil {
+		t.Error("set interval below the minimum")
+	}
+	if peer.SetPSKRotator(PSKRotator{Interval: 10 * time.Minute, MaxSkew: 5 * time.Minute}) == nil {
+		t.Error("set skew tolerance of half the interval")
+	}
+}
+
+func TestPSKRotationHandshake(t *testing.T) {
+	dev1 := randDevice(t)
+	dev2 := randDevice(t)
+	defer dev1.Close()
+	defer dev2.Close()
+	peer1, err := dev2.NewPeer(dev1.staticIdentity.privateKey.publicKey())
+	assertNil(t, err)
+	peer2, err := dev1.NewPeer(dev2.staticIdentity.privateKey.publicKey())
+	assertNil(t, err)
+	peer1.Start()
+	peer2.Start()
+
+	// The rotated keys replace the configured ones, which differ.
+	peer2.handshake.presharedKey = NoisePresharedKey{1}
+	rotator := PSKRotator{Root: NoisePresharedKey{2}}
+	assertNil(t, peer1.SetPSKRotator(rotator))
+	assertNil(t, peer2.SetPSKRotator(rotator))
+	rotator = peer1.PSKRotator()
+
+	handshake := func() bool {
+		msg1, err := dev1.CreateMessageInitiation(peer2)
+		assertNil(t, err)
+		if dev2.ConsumeMessageInitiation(msg1) != peer1 {
+			t.Fatal("handshake failed at initiation message")
+		}
+		msg2, err := dev2.CreateMessageResponse(peer1)
+		assertNil(t, err)
+		defer time.Sleep(50 * time.Millisecond) // for the next initiation to get a newer timestamp
+		return dev1.ConsumeMessageResponse(msg2) == peer2
+	}
+	if !handshake() {
+		t.Fatal("handshake with rotated keys failed")
+	}
+	if epoch := rotator.Epoch(time.Now()); peer2.handshake.psk != rotator.Key(epoch) && peer2.handshake.psk != rotator.Key(epoch-1) {
+		t.Error("handshake did not use the key of the current epoch")
+	}
+
+	assertNil(t, peer1.SetPSKRotator(PSKRotator{Root: NoisePresharedKey{3}}))
+	if handshake() {
+		t.Fatal("handshake with different roots succeeded")
+	}
+
+	// Turning rotation off returns to the configured keys.
+	assertNil(t, peer1.SetPSKRotator(PSKRotator{}))
+	assertNil(t, peer2.SetPSKRotator(PSKRotator{}))
+	peer1.handshake.presharedKey = NoisePresharedKey{1}
+	if !handshake() {
+		t.Fatal("handshake with configured keys failed")
+	}
+}
+
+func TestDevicePSKRotation(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	root := NoisePresharedKey{4}
+	hexRoot := hex.EncodeToString(root[:])
+	for i := range pair {
+		pk := pair[i^1].dev.staticIdentity.publicKey
+		if err := pair[i].dev.IpcSet(uapiCfg("public_key", hex.EncodeToString(pk[:]), "psk_rotation_root", hexRoot, "psk_rotation_interval", "600")); err != nil {
+			t.Fatal(err)
+		}
+		pair[i].dev.LookupPeer(pk).ExpireCurrentKeypairs()
+	}
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+
+	status := pair[0].dev.Status()
+	if len(status.Peers) != 1 || status.Peers[0].PSKRotator != (PSKRotator{Root: root, Interval: 10 * time.Minute, MaxSkew: DefaultPSKRotationSkew}) {
+		t.Errorf("unexpected peer status %+v", status.Peers)
+	}
+	cfg, err := pair[0].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "psk_rotation_root=" + hexRoot + "\npsk_rotation_interval=600\npsk_rotation_skew=60\n"
+	if !strings.Contains(cfg, want) {
+		t.Errorf("get output lacks the rotation:\n%s", cfg)
+	}
+	pk := pair[1].dev.staticIdentity.publicKey
+	if err := pair[0].dev.IpcSet(uapiCfg("public_key", hex.EncodeToString(pk[:]), "psk_rotation_skew", "300")); err == nil {
+		t.Error("set skew tolerance of half the interval")
+	}
+}
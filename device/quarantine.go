@@ -0,0 +1,156 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+/* Degraded-peer quarantine
+ *
+ * Every peer counts the transport packets it sends that fail decryption, hit
+ * the replay filter, or carry a malformed or spoofed inner packet. Only the
+ * last are held against it: anyone can send garbage or replay captured
+ * packets with a peer's receiver index, but an inner packet decrypts only if
+ * the peer's key sealed it. With a quarantine policy set, a peer sending
+ * MaxErrors malformed packets within one Window is quarantined for Cooldown:
+ * its transport packets are dropped before decryption, so that a
+ * misbehaving or compromised peer spraying invalid packets at line rate
+ * costs the device little more than an index lookup per packet. Its
+ * handshakes are still answered and packets to it are still sent, so that
+ * the peer keeps its session and endpoint and recovers as soon as the
+ * cooldown ends.
+ *
+ * Entering and leaving quarantine is logged and sent to observers as
+ * peer_quarantined and peer_released events; the former carries the
+ * malformed packets counted in the window that triggered it.
+ */
+
+// A QuarantinePolicy sets when peers are quarantined for sending malformed
+// packets.
+type QuarantinePolicy struct {
+	MaxErrors int           // malformed packets within Window that quarantine a peer; zero disables quarantine
+	Window    time.Duration // interval over which malformed packets are counted
+	Cooldown  time.Duration // how long a quarantined peer's packets are dropped
+}
+
+type peerQuarantine struct {
+	until            atomic.Int64 // unix nanoseconds when the quarantine ends; zero if not quarantined
+	decryptFailures  atomic.Uint64
+	replayHits       atomic.Uint64
+	malformedPackets atomic.Uint64
+
+	// The window is only accessed by RoutineSequentialReceiver.
+	windowStart time.Time
+	window      int // malformed packets counted in the window
+}
+
+// packetErrors counts the invalid packets of a peer.
+type packetErrors struct {
+	decryptFailures  int
+	replayHits       int
+	malformedPackets int
+}
+
+func (e packetErrors) total() int {
+	return e.decryptFailures + e.replayHits + e.malformedPackets
+}
+
+// SetQuarantinePolicy sets the quarantine policy of the device. A zero
+// MaxErrors disables quarantine, releasing quarantined peers as their
+// cooldowns end; a zero Window or Cooldown selects DefaultQuarantineWindow
+// or DefaultQuarantineCooldown.
+func (device *Device) SetQuarantinePolicy(policy QuarantinePolicy) error {
+	if policy.MaxErrors < 0 {
+		return fmt.Errorf("negative maximum errors")
+	}
+	if policy.Window < 0 || policy.Cooldown < 0 {
+		return fmt.Errorf("negative quarantine window or cooldown")
+	}
+	if policy.MaxErrors == 0 {
+		device.quarantine.Store(nil)
+		return nil
+	}
+	if policy.Window == 0 {
+		policy.Window = DefaultQuarantineWindow
+	}
+	if policy.Cooldown == 0 {
+		policy.Cooldown = DefaultQuarantineCooldown
+	}
+	device.quarantine.Store(&policy)
+	return nil
+}
+
+// QuarantinePolicy returns the policy set with SetQuarantinePolicy.
+func (device *Device) QuarantinePolicy() QuarantinePolicy {
+	if policy := device.quarantine.Load(); policy != nil {
+		return *policy
+	}
+	return QuarantinePolicy{}
+}
+
+// QuarantinedUntil returns when the peer's quarantine ends, or the zero time
+// if it is not quarantined.
+func (peer *Peer) QuarantinedUntil() time.Time {
+	if nano := peer.quarantine.until.Load(); nano != 0 && time.Now().UnixNano() < nano {
+		return time.Unix(0, nano)
+	}
+	return time.Time{}
+}
+
+// quarantined reports whether the peer's packets are to be dropped, and
+// releases the peer once its cooldown has ended.
+func (peer *Peer) quarantined() bool {
+	nano := peer.quarantine.until.Load()
+	if nano == 0 {
+		return false
+	}
+	if time.Now().UnixNano() < nano {
+		return true
+	}
+	if peer.quarantine.until.CompareAndSwap(nano, 0) {
+		peer.device.log.transport.Verbosef("%v - Released from quarantine", peer)
+		peer.device.notifyObservers("peer_released", peer)
+	}
+	return false
+}
+
+// countPacketErrors records the invalid packets of one received batch and
+// quarantines the peer if its malformed packets exceed the device's policy.
+func (peer *Peer) countPacketErrors(errs packetErrors) {
+	q := &peer.quarantine
+	q.decryptFailures.Add(uint64(errs.decryptFailures))
+	q.replayHits.Add(uint64(errs.replayHits))
+	q.malformedPackets.Add(uint64(errs.malformedPackets))
+
+	policy := peer.device.quarantine.Load()
+	if policy == nil {
+		q.window = 0
+		return
+	}
+	if errs.malformedPackets == 0 {
+		return
+	}
+	now := time.Now()
+	if now.Sub(q.windowStart) >= policy.Window {
+		q.windowStart = now
+		q.window = 0
+	}
+	q.window += errs.malformedPackets
+	if q.window < policy.MaxErrors {
+		return
+	}
+
+	window := q.window
+	q.window = 0
+	q.until.Store(now.Add(policy.Cooldown).UnixNano())
+	peer.device.log.transport.Errorf("%v - Quarantined for %v after %d malformed packets", peer, policy.Cooldown, window)
+	peer.device.notifyObservers("peer_quarantined", peer,
+		fmt.Sprintf("malformed_packets=%d", window),
+		fmt.Sprintf("cooldown_sec=%d", int(policy.Cooldown/time.Second)))
+}
@@ -0,0 +1,134 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func TestQuarantine(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+	dev := pair[0].dev
+	peer := dev.LookupPeer(pair[1].dev.staticIdentity.publicKey)
+	sender := pair[1].dev.LookupPeer(dev.staticIdentity.publicKey)
+
+	const cooldown = 500 * time.Millisecond
+	if err := dev.SetQuarantinePolicy(QuarantinePolicy{MaxErrors: 4, Cooldown: cooldown}); err != nil {
+		t.Fatal(err)
+	}
+	if policy := dev.QuarantinePolicy(); policy.Window != DefaultQuarantineWindow {
+		t.Errorf("policy = %+v", policy)
+	}
+	events := dev.addObserver()
+	defer dev.removeObserver(events)
+
+	// Transport packets that fail to decrypt are counted but do not
+	// quarantine the peer, as anyone can send them.
+	keypair := sender.keypairs.Current()
+	garbage := make([][]byte, 4)
+	for i := range garbage {
+		packet := make([]byte, MessageTransportSize+16)
+		binary.LittleEndian.PutUint32(packet, MessageTransportType)
+		binary.LittleEndian.PutUint32(packet[MessageTransportOffsetReceiver:], keypair.remoteIndex)
+		binary.LittleEndian.PutUint64(packet[MessageTransportOffsetCounter:], 1<<32+uint64(i))
+		garbage[i] = packet
+	}
+	if err := sender.SendBuffers(garbage); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return peer.quarantine.decryptFailures.Load() == 4 })
+	if !peer.QuarantinedUntil().IsZero() {
+		t.Fatal("peer quarantined for packets that failed to decrypt")
+	}
+
+	// Packets with a disallowed source address are malformed and quarantine
+	// the peer.
+	for i := range 4 {
+		pair[1].tun.Outbound <- tuntest.Ping(pair[0].ip, netip.AddrFrom4([4]byte{1, 0, 0, byte(99 + i)}))
+	}
+	waitFor(t, func() bool { return !peer.QuarantinedUntil().IsZero() })
+	if n := peer.quarantine.malformedPackets.Load(); n != 4 {
+		t.Errorf("counted %d malformed packets, want 4", n)
+	}
+	select {
+	case block := <-events:
+		if !bytes.HasPrefix(block, []byte("event=peer_quarantined\n")) || !bytes.Contains(block, []byte("malformed_packets=4\n")) {
+			t.Errorf("unexpected event %q", block)
+		}
+	case <-time.After(time.Second):
+		t.Error("no peer_quarantined event")
+	}
+
+	cfg, err := dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"quarantine_max_errors=4", "decrypt_failures=4", "malformed_packets=4", "quarantined_until_sec="} {
+		if !strings.Contains(cfg, line) {
+			t.Errorf("get output lacks %s:\n%s", line, cfg)
+		}
+	}
+	if status := dev.Status(); status.Quarantine.MaxErrors != 4 || status.Peers[0].MalformedPackets != 4 || status.Peers[0].QuarantinedUntil.IsZero() {
+		t.Errorf("status does not report the quarantine: %+v", status)
+	}
+
+	// Handshakes of the quarantined peer are still answered.
+	sender.handshake.mutex.Lock()
+	sender.handshake.lastSentHandshake = time.Now().Add(-(RekeyTimeout + time.Second))
+	sender.handshake.mutex.Unlock()
+	if err := sender.SendHandshakeInitiation(false); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return sender.keypairs.Current() != keypair })
+
+	// Traffic of the quarantined peer is dropped until the cooldown ends.
+	msg := tuntest.Ping(pair[0].ip, pair[1].ip)
+	pair[1].tun.Outbound <- msg
+	select {
+	case <-pair[0].tun.Inbound:
+		t.Error("quarantined peer's packet was received")
+	case <-time.After(cooldown / 4):
+	}
+	time.Sleep(cooldown)
+	pair.Send(t, Ping, nil)
+	if !peer.QuarantinedUntil().IsZero() {
+		t.Error("peer still quarantined after cooldown")
+	}
+	select {
+	case block := <-events:
+		if !bytes.HasPrefix(block, []byte("event=peer_released\n")) {
+			t.Errorf("unexpected event %q", block)
+		}
+	case <-time.After(time.Second):
+		t.Error("no peer_released event")
+	}
+
+	if err := dev.IpcSet("quarantine_max_errors=0\n"); err != nil {
+		t.Fatal(err)
+	}
+	if policy := dev.QuarantinePolicy(); policy.MaxErrors != 0 {
+		t.Errorf("quarantine still enabled: %+v", policy)
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after five seconds.
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+	}
+}
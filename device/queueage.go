@@ -0,0 +1,58 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"errors"
+	"time"
+)
+
+/* Maximum queue age
+ *
+ * For real-time traffic, such as audio frames, a packet delivered late is
+ * worse than one not delivered at all. A peer with a maximum queue age
+ * discards, and counts, the packets that waited longer than that since
+ * being read from the TUN device, whether staged awaiting a session or in
+ * the encryption and transmission queues, and those that waited longer
+ * since being received, in the decryption queues, instead of passing them
+ * on. Outgoing packets are checked as staged packets are sent and again
+ * before transmission, and incoming ones once they passed the replay
+ * filter, so that they count as received. Keepalives and control messages
+ * are never discarded.
+ */
+
+// SetMaxQueueAge sets the longest outgoing and incoming packets of the peer
+// may wait in the queues of the device before they are discarded. Zero
+// means no limit.
+func (peer *Peer) SetMaxQueueAge(age time.Duration) error {
+	if age < 0 {
+		return errors.New("negative maximum queue age")
+	}
+	peer.maxQueueAge.Store(int64(age))
+	return nil
+}
+
+// MaxQueueAge returns the age set with SetMaxQueueAge.
+func (peer *Peer) MaxQueueAge() time.Duration {
+	return time.Duration(peer.maxQueueAge.Load())
+}
+
+// StaleDrops returns the number of packets of the peer discarded for
+// exceeding its maximum queue age.
+func (peer *Peer) StaleDrops() uint64 {
+	return peer.staleDrops.Load()
+}
+
+// stale reports whether a packet queued at queued is too old to pass on at
+// now, counting it if so. Packets with no queuing time are never stale.
+func (peer *Peer) stale(queued, now time.Time) bool {
+	age := peer.maxQueueAge.Load()
+	if age == 0 || queued.IsZero() || now.Sub(queued) <= time.Duration(age) {
+		return false
+	}
+	peer.staleDrops.Add(1)
+	return true
+}
@@ -0,0 +1,77 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func TestMaxQueueAge(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pub0 := pair[0].dev.staticIdentity.publicKey
+	if err := pair[1].dev.IpcSet(uapiCfg(
+		"public_key", hex.EncodeToString(pub0[:]),
+		"max_queue_age_ms", "50",
+	)); err != nil {
+		t.Fatal(err)
+	}
+	peer := pair[1].dev.LookupPeer(pub0)
+	if age := peer.MaxQueueAge(); age != 50*time.Millisecond {
+		t.Fatalf("maximum queue age %v, want 50ms", age)
+	}
+	pair.Send(t, Ping, nil)
+
+	// A packet staged longer ago than the maximum age is discarded once a
+	// session is available for it.
+	dev := pair[1].dev
+	ping := tuntest.Ping(pair[0].ip, pair[1].ip)
+	elem := dev.NewOutboundElement()
+	elem.packet = append(elem.buffer[:MessageTransportHeaderSize], ping...)[MessageTransportHeaderSize:]
+	elem.queued = time.Now().Add(-time.Second)
+	elems := dev.GetOutboundElementsContainer()
+	elems.elems = append(elems.elems, elem)
+	peer.StagePackets(elems)
+	peer.SendStagedPackets()
+	if n := peer.StaleDrops(); n != 1 {
+		t.Errorf("%d stale drops, want 1", n)
+	}
+	select {
+	case <-pair[0].tun.Inbound:
+		t.Error("stale packet delivered")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Fresh packets still go through.
+	pair.Send(t, Ping, nil)
+	if n := peer.StaleDrops(); n != 1 {
+		t.Errorf("%d stale drops after a fresh packet, want 1", n)
+	}
+	cfg, err := dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"max_queue_age_ms=50\n", "stale_drops=1\n"} {
+		if !strings.Contains(cfg, line) {
+			t.Errorf("get output lacks %q:\n%s", line, cfg)
+		}
+	}
+
+	if peer.stale(time.Time{}, time.Now()) {
+		t.Error("packet without queuing time stale")
+	}
+	if err := peer.SetMaxQueueAge(0); err != nil {
+		t.Fatal(err)
+	}
+	if peer.stale(time.Now().Add(-time.Hour), time.Now()) {
+		t.Error("packet stale without a maximum queue age")
+	}
+}
@@ -8,11 +8,12 @@ package device
 import (
 	"encoding/binary"
 	"errors"
+	"log/slog"
 	"net"
 	"sync"
 	"time"
 
-	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/blake2s"
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
 	"golang.zx2c4.com/wireguard/conn"
@@ -21,6 +22,7 @@ import (
 type QueueHandshakeElement struct {
 	msgType  uint32
 	packet   []byte
+	trailer  []byte // cipher suite offer, selection, response data or padding following the message
 	endpoint conn.Endpoint
 	buffer   *[MaxMessageSize]byte
 }
@@ -31,11 +33,13 @@ type QueueInboundElement struct {
 	counter  uint64
 	keypair  *Keypair
 	endpoint conn.Endpoint
+	received time.Time // if the peer has a maximum queue age
 }
 
 type QueueInboundElementsContainer struct {
 	sync.Mutex
-	elems []*QueueInboundElement
+	elems   []*QueueInboundElement
+	dropped bool // by a decryption worker that crashed on it
 }
 
 // clearPointers clears elem fields that contain pointers.
@@ -70,15 +74,24 @@ func (peer *Peer) keepKeyFreshReceiving() {
  * IPv4 and IPv6 (separately)
  */
 func (device *Device) RoutineReceiveIncoming(maxBatchSize int, recv conn.ReceiveFunc) {
+	device.routineReceiveIncoming(nil, maxBatchSize, recv)
+}
+
+// routineReceiveIncoming is RoutineReceiveIncoming for the listener l, which
+// is credited with the received bytes. Endpoints of packets arriving on an
+// additional listener are tagged so that replies are sent from it.
+func (device *Device) routineReceiveIncoming(l *portListener, maxBatchSize int, recv conn.ReceiveFunc) {
 	recvName := recv.PrettyName()
 	defer func() {
-		device.log.Verbosef("Routine: receive incoming %s - stopped", recvName)
+		device.log.conn.Verbosef("Routine: receive incoming %s - stopped", recvName)
 		device.queue.decryption.wg.Done()
 		device.queue.handshake.wg.Done()
 		device.net.stopping.Done()
 	}()
 
-	device.log.Verbosef("Routine: receive incoming %s - started", recvName)
+	device.log.conn.Verbosef("Routine: receive incoming %s - started", recvName)
+	device.pipeline.enter(stageReceive)
+	defer device.pipeline.exit(stageReceive)
 
 	// receive datagrams until conn is closed
 
@@ -91,7 +104,13 @@ func (device *Device) RoutineReceiveIncoming(maxBatchSize int, recv conn.Receive
 		endpoints   = make([]conn.Endpoint, maxBatchSize)
 		deathSpiral int
 		elemsByPeer = make(map[*Peer]*QueueInboundElementsContainer, maxBatchSize)
+
+		tagEndpoints = l != nil && l.bind != device.net.bind
+		binding      = coreBinding{device: device, role: coreRoleUDP}
+		inline       bool
+		nonce        [maxTransportNonceSize]byte
 	)
+	defer binding.release()
 
 	for i := range bufsArrs {
 		bufsArrs[i] = device.GetMessageBuffer()
@@ -107,12 +126,13 @@ func (device *Device) RoutineReceiveIncoming(maxBatchSize int, recv conn.Receive
 	}()
 
 	for {
+		inline = binding.update()
 		count, err = recv(bufs, sizes, endpoints)
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
 				return
 			}
-			device.log.Verbosef("Failed to receive %s packet: %v", recvName, err)
+			device.log.conn.verboseAttrs([]slog.Attr{errorAttr(err)}, "Failed to receive %s packet: %v", recvName, err)
 			if neterr, ok := err.(net.Error); ok && !neterr.Temporary() {
 				return
 			}
@@ -124,8 +144,21 @@ func (device *Device) RoutineReceiveIncoming(maxBatchSize int, recv conn.Receive
 			return
 		}
 		deathSpiral = 0
+		start := device.pipeline.begin()
+		var now time.Time
+		binding.count(sizes[:count])
+
+		if l != nil {
+			for i, size := range sizes[:count] {
+				l.rxBytes.Add(uint64(size))
+				if tagEndpoints && endpoints[i] != nil {
+					endpoints[i] = &portEndpoint{Endpoint: endpoints[i], listener: l}
+				}
+			}
+		}
 
 		// handle each packet in the batch
+		padding := device.HandshakePadding()
 		for i, size := range sizes[:count] {
 			if size < MinMessageSize {
 				continue
@@ -144,7 +177,7 @@ func (device *Device) RoutineReceiveIncoming(maxBatchSize int, recv conn.Receive
 
 				// check size
 
-				if len(packet) < MessageTransportSize {
+				if len(packet) < MinMessageTransportSize {
 					continue
 				}
 
@@ -156,23 +189,43 @@ func (device *Device) RoutineReceiveIncoming(maxBatchSize int, recv conn.Receive
 				value := device.indexTable.Lookup(receiver)
 				keypair := value.keypair
 				if keypair == nil {
+					device.tracePacket(false, PacketUnknownIndex, nil, size, 0)
 					continue
 				}
 
 				// check keypair expiry
 
+				peer := value.peer
 				if keypair.created.Add(RejectAfterTime).Before(time.Now()) {
+					device.tracePacket(false, PacketExpiredSession, peer, size, 0)
+					continue
+				}
+
+				// drop packets of quarantined peers before decrypting them
+
+				if peer.quarantined() {
+					device.tracePacket(false, PacketQuarantined, peer, size, 0)
+					continue
+				}
+				if !peer.admitInbound() {
+					device.tracePacket(false, PacketRateLimited, peer, size, 0)
 					continue
 				}
 
 				// create work element
-				peer := value.peer
 				elem := device.GetInboundElement()
 				elem.packet = packet
 				elem.buffer = bufsArrs[i]
 				elem.keypair = keypair
 				elem.endpoint = endpoints[i]
 				elem.counter = 0
+				elem.received = time.Time{}
+				if peer.maxQueueAge.Load() != 0 {
+					if now.IsZero() {
+						now = time.Now()
+					}
+					elem.received = now
+				}
 
 				elemsForPeer, ok := elemsByPeer[peer]
 				if !ok {
@@ -188,12 +241,33 @@ func (device *Device) RoutineReceiveIncoming(maxBatchSize int, recv conn.Receive
 			// otherwise it is a fixed size & handshake related packet
 
 			case MessageInitiationType:
-				if len(packet) != MessageInitiationSize {
+				if len(packet) != MessageInitiationSize && len(packet) != MessageInitiationOfferSize &&
+					(padding == 0 || len(packet) < MessageInitiationSize || len(packet) > padding) {
+					continue
+				}
+				if !device.knock.admit(endpoints[i].DstIP()) {
+					continue
+				}
+
+			case MessageInitiationHybridType:
+				if len(packet) != MessageInitiationHybridSize && len(packet) != MessageInitiationHybridSize+MessageCipherSuiteOfferSize {
+					continue
+				}
+				if !device.knock.admit(endpoints[i].DstIP()) {
 					continue
 				}
 
 			case MessageResponseType:
-				if len(packet) != MessageResponseSize {
+				if len(packet) != MessageResponseSize &&
+					(len(packet) < MessageResponseSize+MessageResponseDataOverhead ||
+						len(packet) > max(MessageResponseSelectionSize+MessageResponseDataOverhead+MaxResponseDataSize, padding)) {
+					continue
+				}
+
+			case MessageResponseHybridType:
+				if len(packet) != MessageResponseHybridSize &&
+					(len(packet) < MessageResponseHybridSize+MessageResponseDataOverhead ||
+						len(packet) > MessageResponseHybridSize+MessageCipherSuiteSelectionSize+MessageResponseDataOverhead+MaxResponseDataSize) {
 					continue
 				}
 
@@ -202,16 +276,32 @@ func (device *Device) RoutineReceiveIncoming(maxBatchSize int, recv conn.Receive
 					continue
 				}
 
+			case MessageKnockType:
+				if device.knock.consumeKnock(packet, endpoints[i].DstIP()) {
+					endpoint := endpoints[i].DstToString()
+					device.log.conn.verboseAttrs([]slog.Attr{endpointAttr(endpoint)}, "Accepted knock from %s", endpoint)
+				}
+				continue
+
 			default:
-				device.log.Verbosef("Received message with unknown type")
+				device.log.conn.Verbosef("Received message with unknown type")
 				continue
 			}
 
+			var trailer []byte
+			switch msgType {
+			case MessageInitiationType, MessageInitiationHybridType:
+				packet, trailer = packet[:MessageInitiationSize], packet[MessageInitiationSize:]
+			case MessageResponseType, MessageResponseHybridType:
+				packet, trailer = packet[:MessageResponseSize], packet[MessageResponseSize:]
+			}
+
 			select {
 			case device.queue.handshake.c <- QueueHandshakeElement{
 				msgType:  msgType,
 				buffer:   bufsArrs[i],
 				packet:   packet,
+				trailer:  trailer,
 				endpoint: endpoints[i],
 			}:
 				bufsArrs[i] = device.GetMessageBuffer()
@@ -222,7 +312,13 @@ func (device *Device) RoutineReceiveIncoming(maxBatchSize int, recv conn.Receive
 		for peer, elemsContainer := range elemsByPeer {
 			if peer.isRunning.Load() {
 				peer.queue.inbound.c <- elemsContainer
-				device.queue.decryption.c <- elemsContainer
+				if inline {
+					// decrypt on this goroutine's core
+					device.decryptElems(elemsContainer, &nonce)
+				} else {
+					device.queue.decryption.c <- elemsContainer
+					device.workers.decryption.grow(len(device.queue.decryption.c))
+				}
 			} else {
 				for _, elem := range elemsContainer.elems {
 					device.PutMessageBuffer(elem.buffer)
@@ -232,37 +328,68 @@ func (device *Device) RoutineReceiveIncoming(maxBatchSize int, recv conn.Receive
 			}
 			delete(elemsByPeer, peer)
 		}
+		device.pipeline.done(stageReceive, count, start)
 	}
 }
 
 func (device *Device) RoutineDecryption(id int) {
-	var nonce [chacha20poly1305.NonceSize]byte
-
-	defer device.log.Verbosef("Routine: decryption worker %d - stopped", id)
-	device.log.Verbosef("Routine: decryption worker %d - started", id)
-
-	for elemsContainer := range device.queue.decryption.c {
-		for _, elem := range elemsContainer.elems {
-			// split message into fields
-			counter := elem.packet[MessageTransportOffsetCounter:MessageTransportOffsetContent]
-			content := elem.packet[MessageTransportOffsetContent:]
-
-			// decrypt and release to consumer
-			var err error
-			elem.counter = binary.LittleEndian.Uint64(counter)
-			// copy counter to nonce
-			binary.LittleEndian.PutUint64(nonce[0x4:0xc], elem.counter)
-			elem.packet, err = elem.keypair.receive.Open(
-				content[:0],
-				nonce[:],
-				content,
-				nil,
-			)
-			if err != nil {
-				elem.packet = nil
-			}
+	var nonce [maxTransportNonceSize]byte
+
+	defer device.log.transport.Verbosef("Routine: decryption worker %d - stopped", id)
+	device.log.transport.Verbosef("Routine: decryption worker %d - started", id)
+	device.pipeline.enter(stageDecryption)
+	defer device.pipeline.exit(stageDecryption)
+
+	idle := time.NewTimer(WorkerIdleTimeout)
+	defer idle.Stop()
+	for {
+		elemsContainer, ok := nextWork(&device.workers.decryption, device.queue.decryption.c, idle)
+		if !ok {
+			return
+		}
+		device.decryptElems(elemsContainer, &nonce)
+	}
+}
+
+func (device *Device) decryptElems(elemsContainer *QueueInboundElementsContainer, nonce *[maxTransportNonceSize]byte) {
+	var current *QueueInboundElement
+	defer elemsContainer.Unlock()
+	defer device.pipeline.done(stageDecryption, len(elemsContainer.elems), device.pipeline.begin())
+	defer func() {
+		if r := recover(); r != nil {
+			elemsContainer.dropped = true
+			device.workerCrashed("decryption worker", r, device.keypairPeer(current.keypair))
+		}
+	}()
+	for _, elem := range elemsContainer.elems {
+		current = elem
+
+		// split message into fields
+		counter := elem.packet[MessageTransportOffsetCounter:MessageTransportOffsetContent]
+		content := elem.packet[MessageTransportOffsetContent:]
+
+		// digest the packet for the transcript before it is decrypted in place
+		var digest [blake2s.Size]byte
+		size := len(elem.packet)
+		t := device.transcript.Load()
+		if t != nil {
+			digest = blake2s.Sum256(elem.packet)
+		}
+
+		// decrypt and release to consumer
+		var err error
+		elem.counter = binary.LittleEndian.Uint64(counter)
+		elem.packet, err = elem.keypair.receive.Open(
+			content[:0],
+			elem.keypair.transportNonce(nonce, elem.counter, false),
+			content,
+			nil,
+		)
+		if err != nil {
+			elem.packet = nil
+		} else if t != nil {
+			t.recordPacket(transcriptReceive, elem.keypair.localIndex, elem.counter, size, &digest)
 		}
-		elemsContainer.Unlock()
 	}
 }
 
@@ -270,169 +397,233 @@ func (device *Device) RoutineDecryption(id int) {
  */
 func (device *Device) RoutineHandshake(id int) {
 	defer func() {
-		device.log.Verbosef("Routine: handshake worker %d - stopped", id)
+		device.log.handshake.Verbosef("Routine: handshake worker %d - stopped", id)
 		device.queue.encryption.wg.Done()
 	}()
-	device.log.Verbosef("Routine: handshake worker %d - started", id)
+	device.log.handshake.Verbosef("Routine: handshake worker %d - started", id)
+	device.pipeline.enter(stageHandshake)
+	defer device.pipeline.exit(stageHandshake)
 
 	for elem := range device.queue.handshake.c {
+		start := device.pipeline.begin()
+		device.handleHandshake(elem)
+		device.pipeline.done(stageHandshake, 1, start)
+	}
+}
 
-		// handle cookie fields and ratelimiting
+func (device *Device) handleHandshake(elem QueueHandshakeElement) {
+	defer func() {
+		if r := recover(); r != nil {
+			device.PutMessageBuffer(elem.buffer)
+			device.workerCrashed("handshake worker", r, nil)
+		}
+	}()
 
-		switch elem.msgType {
+	// handle cookie fields and ratelimiting
 
-		case MessageCookieReplyType:
+	switch elem.msgType {
 
-			// unmarshal packet
+	case MessageCookieReplyType:
 
-			var reply MessageCookieReply
-			err := reply.unmarshal(elem.packet)
-			if err != nil {
-				device.log.Verbosef("Failed to decode cookie reply")
-				goto skip
-			}
+		// unmarshal packet
 
-			// lookup peer from index
+		var reply MessageCookieReply
+		err := reply.unmarshal(elem.packet)
+		if err != nil {
+			device.log.handshake.Verbosef("Failed to decode cookie reply")
+			goto skip
+		}
 
-			entry := device.indexTable.Lookup(reply.Receiver)
+		// lookup peer from index
 
-			if entry.peer == nil {
-				goto skip
-			}
+		entry := device.indexTable.Lookup(reply.Receiver)
 
-			// consume reply
+		if entry.peer == nil {
+			goto skip
+		}
 
-			if peer := entry.peer; peer.isRunning.Load() {
-				device.log.Verbosef("Receiving cookie response from %s", elem.endpoint.DstToString())
-				if !peer.cookieGenerator.ConsumeReply(&reply) {
-					device.log.Verbosef("Could not decrypt invalid cookie response")
-				}
+		// consume reply
+
+		if peer := entry.peer; peer.isRunning.Load() {
+			endpoint := elem.endpoint.DstToString()
+			device.log.handshake.verboseAttrs([]slog.Attr{peerAttr(peer), endpointAttr(endpoint)}, "Receiving cookie response from %s", endpoint)
+			if !peer.cookieGenerator.ConsumeReply(&reply) {
+				device.log.handshake.Verbosef("Could not decrypt invalid cookie response")
+			} else {
+				device.cookieStats.repliesReceived.Add(1)
+				peer.countCookieReply()
 			}
+		}
 
-			goto skip
+		goto skip
 
-		case MessageInitiationType, MessageResponseType:
+	case MessageInitiationType, MessageResponseType, MessageInitiationHybridType, MessageResponseHybridType:
 
-			// check mac fields and maybe ratelimit
+		// check mac fields and maybe ratelimit
 
-			if !device.cookieChecker.CheckMAC1(elem.packet) {
-				device.log.Verbosef("Received packet with invalid mac1")
-				goto skip
+		if !device.cookieChecker.CheckMAC1(elem.packet) {
+			device.log.handshake.Verbosef("Received packet with invalid mac1")
+			if elem.msgType == MessageResponseType || elem.msgType == MessageResponseHybridType {
+				device.countInvalidResponse(elem.packet)
 			}
+			goto skip
+		}
 
-			// endpoints destination address is the source of the datagram
+		// endpoints destination address is the source of the datagram
 
-			if device.IsUnderLoad() {
+		if device.IsUnderLoad() {
 
-				// verify MAC2 field
+			// verify MAC2 field
 
-				if !device.cookieChecker.CheckMAC2(elem.packet, elem.endpoint.DstToBytes()) {
-					device.SendHandshakeCookie(&elem)
-					goto skip
-				}
+			if !device.cookieChecker.CheckMAC2(elem.packet, elem.endpoint.DstToBytes()) {
+				device.SendHandshakeCookie(&elem)
+				goto skip
+			}
+			device.cookieStats.validMAC2.Add(1)
 
-				// check ratelimiter
+			// check ratelimiter
 
-				if !device.rate.limiter.Allow(elem.endpoint.DstIP()) {
-					goto skip
-				}
+			if !device.rate.limiter.Allow(elem.endpoint.DstIP()) {
+				device.cookieStats.rateLimited.Add(1)
+				goto skip
 			}
-
-		default:
-			device.log.Errorf("Invalid packet ended up in the handshake queue")
-			goto skip
 		}
 
-		// handle handshake initiation/response content
+	default:
+		device.log.handshake.Errorf("Invalid packet ended up in the handshake queue")
+		goto skip
+	}
 
-		switch elem.msgType {
-		case MessageInitiationType:
+	// handle handshake initiation/response content
 
-			// unmarshal
+	switch elem.msgType {
+	case MessageInitiationType, MessageInitiationHybridType:
 
-			var msg MessageInitiation
-			err := msg.unmarshal(elem.packet)
-			if err != nil {
-				device.log.Errorf("Failed to decode initiation message")
-				goto skip
-			}
+		// unmarshal
 
-			// consume initiation
+		msg := device.GetMessageInitiation()
+		err := msg.unmarshal(elem.packet)
+		if err != nil {
+			device.PutMessageInitiation(msg)
+			device.log.handshake.Errorf("Failed to decode initiation message")
+			goto skip
+		}
 
-			peer := device.ConsumeMessageInitiation(&msg)
-			if peer == nil {
-				device.log.Verbosef("Received invalid initiation message from %s", elem.endpoint.DstToString())
-				goto skip
+		// consume initiation
+
+		peer, wantCookie, untrusted := device.consumeMessageInitiation(msg, device.initiationIsFresh(&elem))
+		device.PutMessageInitiation(msg)
+		if peer == nil {
+			endpoint := elem.endpoint.DstToString()
+			device.log.handshake.verboseAttrs([]slog.Attr{endpointAttr(endpoint)}, "Received invalid initiation message from %s", endpoint)
+			if wantCookie {
+				device.SendHandshakeCookie(&elem)
 			}
+			goto skip
+		}
 
-			// update timers
+		// update timers
 
-			peer.timersAnyAuthenticatedPacketTraversal()
-			peer.timersAnyAuthenticatedPacketReceived()
+		peer.timersAnyAuthenticatedPacketTraversal()
+		peer.timersAnyAuthenticatedPacketReceived()
 
-			// update endpoint
+		// update endpoint, unless the timestamp is not trusted and the
+		// initiation may be a replay: it is then answered where it came
+		// from, and the peer roams there once a data packet confirms the
+		// session
+		if !untrusted {
 			peer.SetEndpointFromPacket(elem.endpoint)
+			device.knock.refresh(elem.endpoint.DstIP())
+		}
 
-			device.log.Verbosef("%v - Received handshake initiation", peer)
-			peer.rxBytes.Add(uint64(len(elem.packet)))
+		device.log.handshake.verboseAttrs([]slog.Attr{peerAttr(peer), endpointAttr(elem.endpoint.DstToString())}, "%v - Received handshake initiation", peer)
+		peer.rxBytes.Add(uint64(len(elem.packet) + len(elem.trailer)))
+		trailer, err := peer.openHybridInitiation(elem.msgType, elem.packet, elem.trailer)
+		if err != nil {
+			device.log.handshake.verboseAttrs([]slog.Attr{peerAttr(peer), errorAttr(err)}, "%v - Discarding initiation: %v", peer, err)
+			goto skip
+		}
+		peer.openCipherSuiteOffer(elem.packet, trailer)
 
+		if untrusted {
+			peer.sendHandshakeResponse(elem.endpoint)
+		} else {
 			peer.SendHandshakeResponse()
+		}
 
-		case MessageResponseType:
+	case MessageResponseType, MessageResponseHybridType:
 
-			// unmarshal
+		// unmarshal
 
-			var msg MessageResponse
-			err := msg.unmarshal(elem.packet)
-			if err != nil {
-				device.log.Errorf("Failed to decode response message")
-				goto skip
-			}
+		msg := device.GetMessageResponse()
+		err := msg.unmarshal(elem.packet)
+		if err != nil {
+			device.PutMessageResponse(msg)
+			device.log.handshake.Errorf("Failed to decode response message")
+			goto skip
+		}
 
-			// consume response
+		// consume response
 
-			peer := device.ConsumeMessageResponse(&msg)
-			if peer == nil {
-				device.log.Verbosef("Received invalid response message from %s", elem.endpoint.DstToString())
-				goto skip
-			}
+		peer := device.ConsumeMessageResponse(msg)
+		device.PutMessageResponse(msg)
+		if peer == nil {
+			endpoint := elem.endpoint.DstToString()
+			device.log.handshake.verboseAttrs([]slog.Attr{endpointAttr(endpoint)}, "Received invalid response message from %s", endpoint)
+			device.countInvalidResponse(elem.packet)
+			goto skip
+		}
 
-			// update endpoint
-			peer.SetEndpointFromPacket(elem.endpoint)
+		// update endpoint
+		peer.SetEndpointFromPacket(elem.endpoint)
 
-			device.log.Verbosef("%v - Received handshake response", peer)
-			peer.rxBytes.Add(uint64(len(elem.packet)))
+		device.log.handshake.verboseAttrs([]slog.Attr{peerAttr(peer), endpointAttr(elem.endpoint.DstToString())}, "%v - Received handshake response", peer)
+		peer.rxBytes.Add(uint64(len(elem.packet) + len(elem.trailer)))
 
-			// update timers
+		trailer, err := peer.openHybridResponse(elem.msgType, elem.packet, elem.trailer)
+		if err == nil {
+			trailer, err = peer.openCipherSuiteSelection(elem.packet, trailer)
+		}
+		if err != nil {
+			device.log.handshake.verboseAttrs([]slog.Attr{peerAttr(peer), errorAttr(err)}, "%v - Discarding response: %v", peer, err)
+			goto skip
+		}
+		if err := peer.openResponseData(elem.packet, trailer); err != nil {
+			device.log.handshake.verboseAttrs([]slog.Attr{peerAttr(peer), errorAttr(err)}, "%v - Discarding invalid response data: %v", peer, err)
+		}
 
-			peer.timersAnyAuthenticatedPacketTraversal()
-			peer.timersAnyAuthenticatedPacketReceived()
+		// update timers
 
-			// derive keypair
+		peer.timersAnyAuthenticatedPacketTraversal()
+		peer.timersAnyAuthenticatedPacketReceived()
 
-			err = peer.BeginSymmetricSession()
+		// derive keypair
 
-			if err != nil {
-				device.log.Errorf("%v - Failed to derive keypair: %v", peer, err)
-				goto skip
-			}
+		err = peer.BeginSymmetricSession()
 
-			peer.timersSessionDerived()
-			peer.timersHandshakeComplete()
-			peer.SendKeepalive()
+		if err != nil {
+			device.log.handshake.errorAttrs([]slog.Attr{peerAttr(peer), errorAttr(err)}, "%v - Failed to derive keypair: %v", peer, err)
+			goto skip
 		}
-	skip:
-		device.PutMessageBuffer(elem.buffer)
+
+		peer.timersSessionDerived()
+		peer.timersHandshakeComplete()
+		peer.SendKeepalive()
+		peer.requestAssignment()
 	}
+skip:
+	device.PutMessageBuffer(elem.buffer)
 }
 
 func (peer *Peer) RoutineSequentialReceiver(maxBatchSize int) {
 	device := peer.device
 	defer func() {
-		device.log.Verbosef("%v - Routine: sequential receiver - stopped", peer)
+		device.log.transport.Verbosef("%v - Routine: sequential receiver - stopped", peer)
 		peer.stopping.Done()
 	}()
-	device.log.Verbosef("%v - Routine: sequential receiver - started", peer)
+	device.log.transport.Verbosef("%v - Routine: sequential receiver - started", peer)
+	device.pipeline.enter(stageTUNWrite)
+	defer device.pipeline.exit(stageTUNWrite)
 
 	bufs := make([][]byte, 0, maxBatchSize)
 
@@ -440,97 +631,181 @@ func (peer *Peer) RoutineSequentialReceiver(maxBatchSize int) {
 		if elemsContainer == nil {
 			return
 		}
-		elemsContainer.Lock()
-		validTailPacket := -1
-		dataPacketReceived := false
-		rxBytesLen := uint64(0)
-		for i, elem := range elemsContainer.elems {
-			if elem.packet == nil {
-				// decryption failed
-				continue
+		peer.receiveElems(elemsContainer, bufs[:0])
+	}
+}
+
+func (peer *Peer) receiveElems(elemsContainer *QueueInboundElementsContainer, bufs [][]byte) {
+	device := peer.device
+	released := false
+	defer func() {
+		if r := recover(); r != nil {
+			if !released {
+				device.putInboundElements(elemsContainer)
 			}
+			device.workerCrashed("sequential receiver", r, peer)
+		}
+	}()
 
-			if !elem.keypair.replayFilter.ValidateCounter(elem.counter, RejectAfterMessages) {
+	elemsContainer.Lock()
+	defer device.pipeline.done(stageTUNWrite, len(elemsContainer.elems), device.pipeline.begin())
+	if elemsContainer.dropped {
+		released = true
+		device.putInboundElements(elemsContainer)
+		return
+	}
+	var now time.Time
+	if peer.maxQueueAge.Load() != 0 {
+		now = time.Now()
+	}
+	validTailPacket := -1
+	dataPacketReceived := false
+	rxBytesLen := uint64(0)
+	var errs packetErrors
+	decrypted, decryptedBytes := 0, uint64(0)
+	for i, elem := range elemsContainer.elems {
+		if elem.packet == nil {
+			// decryption failed
+			errs.decryptFailures++
+			device.tracePacket(false, PacketDecryptFailed, peer, 0, 0)
+			continue
+		}
+		decrypted++
+		padded := len(elem.packet)
+		size := padded + elem.keypair.transportOverhead()
+		decryptedBytes += uint64(size)
+
+		last := elem.keypair.replayFilter.Last()
+		accepted := elem.keypair.replayFilter.ValidateCounter(elem.counter, RejectAfterMessages)
+		peer.reorder.count(elem.counter, last, accepted)
+		if !accepted {
+			errs.replayHits++
+			device.tracePacket(false, PacketReplay, peer, size, padded)
+			continue
+		}
+		if lease := elem.keypair.lease; lease != nil && elem.counter >= lease.received.Load() {
+			lease.received.Store(elem.counter + 1)
+		}
+
+		validTailPacket = i
+		if peer.ReceivedWithKeypair(elem.keypair) {
+			peer.SetEndpointFromPacket(elem.endpoint)
+			peer.timersHandshakeComplete()
+			peer.SendStagedPackets()
+			peer.requestAssignment()
+		}
+		rxBytesLen += uint64(size)
+
+		if len(elem.packet) == 0 {
+			device.log.transport.Verbosef("%v - Receiving keepalive packet", peer)
+			continue
+		}
+		if elem.packet[0]>>4 == 0 {
+			peer.handleControl(elem.packet)
+			continue
+		}
+		if peer.stale(elem.received, now) {
+			device.tracePacket(false, PacketStale, peer, size, padded)
+			continue
+		}
+		dataPacketReceived = true
+
+		switch elem.packet[0] >> 4 {
+		case 4:
+			if len(elem.packet) < ipv4.HeaderLen {
+				errs.malformedPackets++
+				device.tracePacket(false, PacketMalformed, peer, size, padded)
 				continue
 			}
-
-			validTailPacket = i
-			if peer.ReceivedWithKeypair(elem.keypair) {
-				peer.SetEndpointFromPacket(elem.endpoint)
-				peer.timersHandshakeComplete()
-				peer.SendStagedPackets()
+			field := elem.packet[IPv4offsetTotalLength : IPv4offsetTotalLength+2]
+			length := binary.BigEndian.Uint16(field)
+			if int(length) > len(elem.packet) || int(length) < ipv4.HeaderLen {
+				errs.malformedPackets++
+				device.tracePacket(false, PacketMalformed, peer, size, padded)
+				continue
 			}
-			rxBytesLen += uint64(len(elem.packet) + MinMessageSize)
-
-			if len(elem.packet) == 0 {
-				device.log.Verbosef("%v - Receiving keepalive packet", peer)
+			elem.packet = elem.packet[:length]
+			src := elem.packet[IPv4offsetSrc : IPv4offsetSrc+net.IPv4len]
+			if device.allowedips.Lookup(src) != peer {
+				device.log.transport.Verbosef("IPv4 packet with disallowed source address from %v", peer)
+				errs.malformedPackets++
+				device.tracePacket(false, PacketDisallowedSource, peer, size, padded)
 				continue
 			}
-			dataPacketReceived = true
-
-			switch elem.packet[0] >> 4 {
-			case 4:
-				if len(elem.packet) < ipv4.HeaderLen {
-					continue
-				}
-				field := elem.packet[IPv4offsetTotalLength : IPv4offsetTotalLength+2]
-				length := binary.BigEndian.Uint16(field)
-				if int(length) > len(elem.packet) || int(length) < ipv4.HeaderLen {
-					continue
-				}
-				elem.packet = elem.packet[:length]
-				src := elem.packet[IPv4offsetSrc : IPv4offsetSrc+net.IPv4len]
-				if device.allowedips.Lookup(src) != peer {
-					device.log.Verbosef("IPv4 packet with disallowed source address from %v", peer)
-					continue
-				}
-
-			case 6:
-				if len(elem.packet) < ipv6.HeaderLen {
-					continue
-				}
-				field := elem.packet[IPv6offsetPayloadLength : IPv6offsetPayloadLength+2]
-				length := binary.BigEndian.Uint16(field)
-				length += ipv6.HeaderLen
-				if int(length) > len(elem.packet) {
-					continue
-				}
-				elem.packet = elem.packet[:length]
-				src := elem.packet[IPv6offsetSrc : IPv6offsetSrc+net.IPv6len]
-				if device.allowedips.Lookup(src) != peer {
-					device.log.Verbosef("IPv6 packet with disallowed source address from %v", peer)
-					continue
-				}
 
-			default:
-				device.log.Verbosef("Packet with invalid IP version from %v", peer)
+		case 6:
+			if len(elem.packet) < ipv6.HeaderLen {
+				errs.malformedPackets++
+				device.tracePacket(false, PacketMalformed, peer, size, padded)
+				continue
+			}
+			field := elem.packet[IPv6offsetPayloadLength : IPv6offsetPayloadLength+2]
+			length := binary.BigEndian.Uint16(field)
+			length += ipv6.HeaderLen
+			if int(length) > len(elem.packet) {
+				errs.malformedPackets++
+				device.tracePacket(false, PacketMalformed, peer, size, padded)
+				continue
+			}
+			elem.packet = elem.packet[:length]
+			src := elem.packet[IPv6offsetSrc : IPv6offsetSrc+net.IPv6len]
+			if device.allowedips.Lookup(src) != peer {
+				device.log.transport.Verbosef("IPv6 packet with disallowed source address from %v", peer)
+				errs.malformedPackets++
+				device.tracePacket(false, PacketDisallowedSource, peer, size, padded)
 				continue
 			}
 
-			bufs = append(bufs, elem.buffer[:MessageTransportOffsetContent+len(elem.packet)])
+		default:
+			device.log.transport.Verbosef("Packet with invalid IP version from %v", peer)
+			errs.malformedPackets++
+			device.tracePacket(false, PacketMalformed, peer, size, padded)
+			continue
 		}
 
-		peer.rxBytes.Add(rxBytesLen)
-		if validTailPacket >= 0 {
-			peer.SetEndpointFromPacket(elemsContainer.elems[validTailPacket].endpoint)
-			peer.keepKeyFreshReceiving()
-			peer.timersAnyAuthenticatedPacketTraversal()
-			peer.timersAnyAuthenticatedPacketReceived()
+		peer.countReceivedPacket(elem.packet)
+		if mtu := peer.mssClampMTU.Load(); mtu != 0 {
+			clampMSS(elem.packet, mtu)
 		}
-		if dataPacketReceived {
-			peer.timersDataReceived()
+		if device.handOffNested(elem.packet) {
+			device.tracePacket(false, PacketHandedOff, peer, size, padded)
+			continue
 		}
-		if len(bufs) > 0 {
-			_, err := device.tun.device.Write(bufs, MessageTransportOffsetContent)
-			if err != nil && !device.isClosed() {
-				device.log.Errorf("Failed to write packets to TUN device: %v", err)
-			}
+
+		device.tracePacket(false, PacketDelivered, peer, size, padded)
+		bufs = append(bufs, elem.buffer[:MessageTransportOffsetContent+len(elem.packet)])
+	}
+
+	peer.rxBytes.Add(rxBytesLen)
+	peer.chargeInbound(decrypted, decryptedBytes, errs.decryptFailures)
+	if errs.decryptFailures > 0 && device.subscribed() {
+		peer.emitDecryptFailure(elemsContainer.elems, errs.decryptFailures)
+	}
+	if dataPacketReceived {
+		peer.reportDeliveries()
+	}
+	if errs.total() > 0 {
+		peer.countPacketErrors(errs)
+	}
+	if validTailPacket >= 0 {
+		if peer.eagerKeyErasure.Load() {
+			peer.erasePreviousKeypair(elemsContainer.elems[validTailPacket].keypair)
 		}
-		for _, elem := range elemsContainer.elems {
-			device.PutMessageBuffer(elem.buffer)
-			device.PutInboundElement(elem)
+		peer.SetEndpointFromPacket(elemsContainer.elems[validTailPacket].endpoint)
+		peer.pathReachable()
+		peer.keepKeyFreshReceiving()
+		peer.timersAnyAuthenticatedPacketTraversal()
+		peer.timersAnyAuthenticatedPacketReceived()
+	}
+	if dataPacketReceived {
+		peer.timersDataReceived()
+	}
+	if len(bufs) > 0 {
+		_, err := device.tun.device.Write(bufs, MessageTransportOffsetContent)
+		if err != nil && !device.isClosed() {
+			device.log.tun.Errorf("Failed to write packets to TUN device: %v", err)
 		}
-		bufs = bufs[:0]
-		device.PutInboundElementsContainer(elemsContainer)
 	}
+	released = true
+	device.putInboundElements(elemsContainer)
 }
@@ -0,0 +1,156 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"fmt"
+	"math/bits"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"golang.zx2c4.com/wireguard/replay"
+)
+
+/* Receive window telemetry
+ *
+ * The replay filter of a session accepts a transport packet whose counter is
+ * past the highest one received so far, the edge of its window, or behind
+ * the edge by at most replay.WindowSize and not received before. Paths that
+ * reorder packets further than that lose them as replays, which shows only
+ * as a replay_hits count indistinguishable from actual replays. Each peer's
+ * sequential receiver therefore measures every packet it validates against
+ * the edge: packets that advance it by more than one counter, leaving a gap
+ * for late packets to fill, are counted by how far ahead they land, and
+ * packets behind it by how far behind, whether they were accepted late or
+ * rejected as out of the window. Duplicates within the window are counted
+ * apart. Distances are counted in power-of-two buckets, so that the tail of
+ * the histogram behind the edge tells how close reordering on the path
+ * comes to the window.
+ */
+
+// reorderBuckets is the number of buckets of the distance histograms; bucket
+// i counts distances from 1<<i to 1<<(i+1)-1, and the last bucket all
+// larger ones.
+const reorderBuckets = 16
+
+// A ReorderBucket counts the packets at most Max counters from the edge of
+// the replay window that are further than those of the previous bucket. The
+// last bucket has no Max.
+type ReorderBucket struct {
+	Max   uint64 // zero for the last bucket
+	Count uint64
+}
+
+// ReorderStats describes how far the counters of the transport packets
+// received from a peer were from the edge of their session's replay window.
+type ReorderStats struct {
+	WindowSize  uint64          // counters behind the edge the replay window accepts
+	Late        uint64          // packets accepted behind the edge
+	Duplicates  uint64          // packets rejected as received before
+	OutOfWindow uint64          // packets rejected as too far behind the edge
+	Gaps        uint64          // packets advancing the edge by more than one counter
+	MaxBehind   uint64          // furthest a packet was behind the edge
+	MaxAhead    uint64          // furthest a packet advanced the edge
+	Behind      []ReorderBucket // distances of late and out-of-window packets
+	Ahead       []ReorderBucket // distances of packets leaving a gap
+}
+
+type peerReorder struct {
+	late        atomic.Uint64
+	duplicates  atomic.Uint64
+	outOfWindow atomic.Uint64
+	gaps        atomic.Uint64
+	maxBehind   atomic.Uint64 // stored only by the sequential receiver
+	maxAhead    atomic.Uint64 // stored only by the sequential receiver
+	behind      [reorderBuckets]atomic.Uint64
+	ahead       [reorderBuckets]atomic.Uint64
+}
+
+func reorderBucket(distance uint64) int {
+	return min(bits.Len64(distance)-1, reorderBuckets-1)
+}
+
+// count classifies a packet with counter validated against a replay window
+// whose edge was at last, given whether the filter accepted it.
+func (r *peerReorder) count(counter, last uint64, accepted bool) {
+	switch {
+	case counter > last:
+		// Rejected only beyond the session's message limit.
+		if !accepted || counter-last == 1 {
+			return
+		}
+		distance := counter - last
+		r.gaps.Add(1)
+		r.ahead[reorderBucket(distance)].Add(1)
+		if distance > r.maxAhead.Load() {
+			r.maxAhead.Store(distance)
+		}
+	case counter == last:
+		// The first packet of a session, or the last one again.
+		if !accepted {
+			r.duplicates.Add(1)
+		}
+	default:
+		distance := last - counter
+		switch {
+		case distance > replay.WindowSize:
+			r.outOfWindow.Add(1)
+		case accepted:
+			r.late.Add(1)
+		default:
+			r.duplicates.Add(1)
+			return
+		}
+		r.behind[reorderBucket(distance)].Add(1)
+		if distance > r.maxBehind.Load() {
+			r.maxBehind.Store(distance)
+		}
+	}
+}
+
+// ReorderStats returns statistics of the distances of the packets received
+// from the peer to the edge of the replay window.
+func (peer *Peer) ReorderStats() ReorderStats {
+	r := &peer.reorder
+	stats := ReorderStats{
+		WindowSize:  replay.WindowSize,
+		Late:        r.late.Load(),
+		Duplicates:  r.duplicates.Load(),
+		OutOfWindow: r.outOfWindow.Load(),
+		Gaps:        r.gaps.Load(),
+		MaxBehind:   r.maxBehind.Load(),
+		MaxAhead:    r.maxAhead.Load(),
+		Behind:      make([]ReorderBucket, reorderBuckets),
+		Ahead:       make([]ReorderBucket, reorderBuckets),
+	}
+	for i := range reorderBuckets {
+		if i < reorderBuckets-1 {
+			stats.Behind[i].Max = 1<<(i+1) - 1
+			stats.Ahead[i].Max = 1<<(i+1) - 1
+		}
+		stats.Behind[i].Count = r.behind[i].Load()
+		stats.Ahead[i].Count = r.ahead[i].Load()
+	}
+	return stats
+}
+
+// formatReorderBuckets formats the buckets of a distance histogram that
+// counted any packets as max:count pairs for the UAPI.
+func formatReorderBuckets(buckets []ReorderBucket) string {
+	var counts []string
+	for _, b := range buckets {
+		if b.Count == 0 {
+			continue
+		}
+		max := "inf"
+		if b.Max != 0 {
+			max = strconv.FormatUint(b.Max, 10)
+		}
+		counts = append(counts, fmt.Sprintf("%s:%d", max, b.Count))
+	}
+	return strings.Join(counts, ",")
+}
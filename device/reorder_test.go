@@ -0,0 +1,54 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"testing"
+
+	"golang.zx2c4.com/wireguard/replay"
+)
+
+func TestReorderStats(t *testing.T) {
+	var filter replay.Filter
+	peer := new(Peer)
+	receive := func(counter uint64) {
+		last := filter.Last()
+		peer.reorder.count(counter, last, filter.ValidateCounter(counter, RejectAfterMessages))
+	}
+	for counter := range uint64(10) {
+		receive(counter)
+	}
+	receive(20)                       // 10 ahead, leaving a gap
+	receive(15)                       // 5 behind, late
+	receive(15)                       // duplicate
+	receive(20)                       // duplicate at the edge
+	receive(20 + replay.WindowSize)   // edge moves far ahead
+	receive(16)                       // out of the window
+	receive(RejectAfterMessages + 30) // beyond the limit, not counted
+
+	stats := peer.ReorderStats()
+	if stats.WindowSize != replay.WindowSize {
+		t.Errorf("window size = %d", stats.WindowSize)
+	}
+	if stats.Late != 1 || stats.Duplicates != 2 || stats.OutOfWindow != 1 || stats.Gaps != 2 {
+		t.Errorf("stats = %+v", stats)
+	}
+	if stats.MaxBehind != replay.WindowSize+4 || stats.MaxAhead != replay.WindowSize {
+		t.Errorf("max behind = %d, max ahead = %d", stats.MaxBehind, stats.MaxAhead)
+	}
+	if b := stats.Behind[reorderBucket(5)]; b.Max != 7 || b.Count != 1 {
+		t.Errorf("late bucket = %+v", b)
+	}
+	if b := stats.Ahead[reorderBucket(10)]; b.Max != 15 || b.Count != 1 {
+		t.Errorf("gap bucket = %+v", b)
+	}
+	if got, want := formatReorderBuckets(stats.Behind), "7:1,8191:1"; got != want {
+		t.Errorf("behind = %q, want %q", got, want)
+	}
+	if last := stats.Behind[reorderBuckets-1]; last.Max != 0 {
+		t.Errorf("last bucket max = %d", last.Max)
+	}
+}
@@ -0,0 +1,601 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+/* Keypair state replication
+ *
+ * A primary device can stream the sessions it derives to a standby device,
+ * configured with the same private key and peers, over a stream such as a
+ * TCP or unix socket connection. Should the primary fail, the standby takes
+ * over those sessions, so that peers keep their tunnels without handshaking
+ * again once they roam to the standby's address.
+ *
+ * The stream is authenticated and encrypted with keys derived from a secret
+ * shared by both devices and fresh nonces from each. Every ReplicationInterval,
+ * and whenever a session runs low on its lease, the primary sends the state
+ * of the sessions derived since it started replicating: their keys, indices,
+ * endpoint, the highest counter received, and the send counter up to which
+ * it wants to send. The standby records the state, then acknowledges each
+ * session with the send counter it will itself start from on taking over.
+ *
+ * Taking over with a send counter the primary used would reuse nonces, so
+ * the primary fences itself: it only sends with a replicated session below
+ * the counter last acknowledged, and once the stream ends it never extends
+ * its leases again, handshaking anew when a lease runs out. A standby that
+ * takes over drops the stream, so whether or not the primary is still alive,
+ * the counters of the two never overlap. Counters received by the primary
+ * after its last update may be replayed to the standby once.
+ *
+ * Sessions derived before replication started are not replicated; peers
+ * handshake again with the standby after they expire.
+ */
+
+const (
+	replicationUpdateType = 1 // session states, from primary to standby
+	replicationAckType    = 2 // acknowledged send counters, from standby to primary
+
+	replicationSlotMask = 0x03 // slot of the session in the peer's keypairs
+	replicationHasKeys  = 0x80 // the record carries the session's keys
+
+	replicationNonceSize = 32
+	replicationLabel     = "wireguard-go replication v1"
+)
+
+const (
+	slotCurrent = iota
+	slotPrevious
+	slotNext
+)
+
+// A keypairLease is the replication state of a keypair derived while the
+// device replicated to a standby.
+type keypairLease struct {
+	generation uint64 // replication the keypair was derived during
+	sendKey    [chacha20poly1305.KeySize]byte
+	recvKey    [chacha20poly1305.KeySize]byte
+	requested  atomic.Uint64 // send counter last asked of the standby
+	ceiling    atomic.Uint64 // send counter acknowledged by the standby
+	received   atomic.Uint64 // highest counter received plus one, or zero
+	known      atomic.Bool   // the standby acknowledged the keys
+}
+
+type replicaPrimary struct {
+	generation uint64
+	wake       chan struct{}
+}
+
+type replication struct {
+	mu         sync.Mutex
+	primary    atomic.Pointer[replicaPrimary] // nil unless replicating to a standby
+	standby    io.Closer                      // stream from the primary, if receiving replication
+	replicas   map[uint32]*replica            // sessions of the primary by index, if a standby
+	generation uint64
+}
+
+// A replicaRecord is the fixed-size part of a session's state on the wire.
+type replicaRecord struct {
+	Flags       uint8
+	PublicKey   NoisePublicKey
+	LocalIndex  uint32
+	RemoteIndex uint32
+	Initiator   bool
+	Created     int64 // Unix time in nanoseconds
+	Ceiling     uint64
+	Received    uint64
+}
+
+type replicaAck struct {
+	Index   uint32
+	Ceiling uint64
+}
+
+// A replica is a session of the primary, as recorded by a standby.
+type replica struct {
+	replicaRecord
+	endpoint string
+	suite    string
+	sendKey  [chacha20poly1305.KeySize]byte
+	recvKey  [chacha20poly1305.KeySize]byte
+}
+
+// newLease returns the lease of a keypair with the given keys, or nil if the
+// device is not replicating.
+func (r *replication) newLease(sendKey, recvKey *[chacha20poly1305.KeySize]byte) *keypairLease {
+	primary := r.primary.Load()
+	if primary == nil {
+		return nil
+	}
+	return &keypairLease{generation: primary.generation, sendKey: *sendKey, recvKey: *recvKey}
+}
+
+// renew asks the standby for a longer lease of keypair, reporting false if
+// the lease can no longer be extended.
+func (r *replication) renew(keypair *Keypair) bool {
+	primary := r.primary.Load()
+	if primary == nil || primary.generation != keypair.lease.generation {
+		return false
+	}
+	select {
+	case primary.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// sendLimit returns the send counter the keypair may not reach.
+func (keypair *Keypair) sendLimit() uint64 {
+	if keypair.lease == nil {
+		return RejectAfterMessages
+	}
+	return min(keypair.lease.ceiling.Load(), RejectAfterMessages)
+}
+
+// Replicate streams the sessions the device derives from now on to a standby
+// device calling ReceiveReplication on the other end of conn, with the same
+// secret. It returns once conn or the device fails, closing conn.
+func (device *Device) Replicate(conn io.ReadWriteCloser, secret [32]byte) error {
+	defer conn.Close()
+	stream, err := newReplicationStream(conn, secret, true)
+	if err != nil {
+		return err
+	}
+
+	r := &device.replication
+	r.mu.Lock()
+	if r.primary.Load() != nil || r.standby != nil {
+		r.mu.Unlock()
+		return errors.New("device is already replicating")
+	}
+	r.generation++
+	primary := &replicaPrimary{generation: r.generation, wake: make(chan struct{}, 1)}
+	r.primary.Store(primary)
+	r.mu.Unlock()
+	defer r.primary.Store(nil)
+	device.log.Verbosef("Replicating sessions to standby")
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- device.receiveReplicationAcks(stream, primary.generation)
+	}()
+	ticker := time.NewTicker(ReplicationInterval)
+	defer ticker.Stop()
+	for {
+		if err := stream.write(device.replicationUpdate(primary.generation)); err != nil {
+			return err
+		}
+		select {
+		case <-ticker.C:
+		case <-primary.wake:
+		case err := <-errs:
+			return err
+		case <-device.closed:
+			return ErrDeviceClosed
+		}
+	}
+}
+
+// replicationUpdate returns an update frame with the state of the keypairs
+// derived during the given replication.
+func (device *Device) replicationUpdate(generation uint64) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(replicationUpdateType)
+
+	device.peers.RLock()
+	defer device.peers.RUnlock()
+	for pk, peer := range device.peers.keyMap {
+		peer.keypairs.RLock()
+		slots := [...]*Keypair{
+			slotCurrent:  peer.keypairs.current,
+			slotPrevious: peer.keypairs.previous,
+			slotNext:     peer.keypairs.next.Load(),
+		}
+		peer.keypairs.RUnlock()
+
+		var endpoint string
+		peer.endpoint.Lock()
+		if peer.endpoint.val != nil {
+			endpoint = peer.endpoint.val.DstToString()
+		}
+		peer.endpoint.Unlock()
+
+		for slot, keypair := range slots {
+			if keypair == nil || keypair.lease == nil || keypair.lease.generation != generation {
+				continue
+			}
+			lease := keypair.lease
+			want := min(keypair.sendNonce.Load(), RejectAfterMessages-ReplicationLease) + ReplicationLease
+			if want > lease.requested.Load() {
+				lease.requested.Store(want)
+			}
+			record := replicaRecord{
+				Flags:       uint8(slot),
+				PublicKey:   pk,
+				LocalIndex:  keypair.localIndex,
+				RemoteIndex: keypair.remoteIndex,
+				Initiator:   keypair.isInitiator,
+				Created:     keypair.created.UnixNano(),
+				Ceiling:     lease.requested.Load(),
+				Received:    lease.received.Load(),
+			}
+			known := lease.known.Load()
+			if !known {
+				record.Flags |= replicationHasKeys
+			}
+			binary.Write(&buf, binary.LittleEndian, &record)
+			writeReplicationString(&buf, endpoint)
+			if !known {
+				writeReplicationString(&buf, keypair.suite.Name)
+				buf.Write(lease.sendKey[:])
+				buf.Write(lease.recvKey[:])
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+// receiveReplicationAcks extends the leases of the keypairs derived during
+// the given replication as the standby acknowledges them.
+func (device *Device) receiveReplicationAcks(stream *replicationStream, generation uint64) error {
+	for {
+		frame, err := stream.read()
+		if err != nil {
+			return err
+		}
+		if len(frame) == 0 || frame[0] != replicationAckType {
+			return errors.New("invalid replication acknowledgement")
+		}
+		reader := bytes.NewReader(frame[1:])
+		for reader.Len() > 0 {
+			var ack replicaAck
+			if err := binary.Read(reader, binary.LittleEndian, &ack); err != nil {
+				return fmt.Errorf("invalid replication acknowledgement: %w", err)
+			}
+			entry := device.indexTable.Lookup(ack.Index)
+			keypair := entry.keypair
+			if keypair == nil || keypair.lease == nil || keypair.lease.generation != generation {
+				continue
+			}
+			lease := keypair.lease
+			lease.known.Store(true)
+			if ack.Ceiling > lease.ceiling.Load() && ack.Ceiling <= lease.requested.Load() {
+				lease.ceiling.Store(ack.Ceiling)
+				entry.peer.SendStagedPackets()
+			}
+		}
+	}
+}
+
+// ReceiveReplication records the sessions streamed by a primary device
+// calling Replicate on the other end of conn, with the same secret, for
+// TakeOver to install. It returns once conn fails, closing conn, or with
+// ErrTakenOver once the device took over.
+func (device *Device) ReceiveReplication(conn io.ReadWriteCloser, secret [32]byte) error {
+	defer conn.Close()
+	stream, err := newReplicationStream(conn, secret, false)
+	if err != nil {
+		return err
+	}
+
+	r := &device.replication
+	r.mu.Lock()
+	if r.primary.Load() != nil || r.standby != nil {
+		r.mu.Unlock()
+		return errors.New("device is already replicating")
+	}
+	r.standby = conn
+	r.replicas = nil
+	r.mu.Unlock()
+	device.log.Verbosef("Receiving sessions from primary")
+
+	takenOver := func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		return r.standby != conn
+	}
+	defer func() {
+		r.mu.Lock()
+		if r.standby == conn {
+			r.standby = nil
+		}
+		r.mu.Unlock()
+	}()
+
+	for {
+		frame, err := stream.read()
+		if err != nil {
+			if takenOver() {
+				return ErrTakenOver
+			}
+			return err
+		}
+		update, err := parseReplicationUpdate(frame)
+		if err != nil {
+			return err
+		}
+
+		// Record the counters before acknowledging them, so that taking over
+		// never starts below a counter the primary may use.
+		var ack bytes.Buffer
+		ack.WriteByte(replicationAckType)
+		r.mu.Lock()
+		if r.standby != conn {
+			r.mu.Unlock()
+			return ErrTakenOver
+		}
+		replicas := make(map[uint32]*replica, len(update))
+		for _, rep := range update {
+			old := r.replicas[rep.LocalIndex]
+			if rep.Flags&replicationHasKeys == 0 {
+				if old == nil {
+					continue // keys not received yet
+				}
+				rep.suite, rep.sendKey, rep.recvKey = old.suite, old.sendKey, old.recvKey
+			}
+			if old != nil {
+				rep.Ceiling = max(rep.Ceiling, old.Ceiling)
+			}
+			replicas[rep.LocalIndex] = rep
+			binary.Write(&ack, binary.LittleEndian, &replicaAck{rep.LocalIndex, rep.Ceiling})
+		}
+		r.replicas = replicas
+		r.mu.Unlock()
+
+		if err := stream.write(ack.Bytes()); err != nil {
+			if takenOver() {
+				return ErrTakenOver
+			}
+			return err
+		}
+	}
+}
+
+func parseReplicationUpdate(frame []byte) ([]*replica, error) {
+	if len(frame) == 0 || frame[0] != replicationUpdateType {
+		return nil, errors.New("invalid replication update")
+	}
+	reader := bytes.NewReader(frame[1:])
+	var update []*replica
+	for reader.Len() > 0 {
+		rep := new(replica)
+		err := binary.Read(reader, binary.LittleEndian, &rep.replicaRecord)
+		if err == nil {
+			rep.endpoint, err = readReplicationString(reader)
+		}
+		if err == nil && rep.Flags&replicationHasKeys != 0 {
+			rep.suite, err = readReplicationString(reader)
+			if err == nil {
+				_, err = io.ReadFull(reader, rep.sendKey[:])
+			}
+			if err == nil {
+				_, err = io.ReadFull(reader, rep.recvKey[:])
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid replication update: %w", err)
+		}
+		if rep.Flags&replicationSlotMask > slotNext {
+			return nil, errors.New("invalid replication update: unknown keypair slot")
+		}
+		update = append(update, rep)
+	}
+	return update, nil
+}
+
+func writeReplicationString(buf *bytes.Buffer, s string) {
+	n := min(len(s), 255)
+	buf.WriteByte(byte(n))
+	buf.WriteString(s[:n])
+}
+
+func readReplicationString(reader *bytes.Reader) (string, error) {
+	n, err := reader.ReadByte()
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(reader, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// TakeOver makes a standby device active in place of its primary: it stops
+// receiving replication and installs the sessions recorded from the primary,
+// returning how many it installed.
+func (device *Device) TakeOver() int {
+	r := &device.replication
+	r.mu.Lock()
+	if r.standby != nil {
+		r.standby.Close()
+		r.standby = nil
+	}
+	replicas := r.replicas
+	r.replicas = nil
+	r.mu.Unlock()
+
+	installed := make(map[*Peer]bool)
+	for _, rep := range replicas {
+		peer := device.LookupPeer(rep.PublicKey)
+		if peer == nil {
+			device.log.Errorf("Failed to take over session %d: %v", rep.LocalIndex, ErrPeerNotFound)
+			continue
+		}
+		if err := peer.installReplica(rep); err != nil {
+			device.log.Errorf("%v - Failed to take over session %d: %v", peer, rep.LocalIndex, err)
+			continue
+		}
+		installed[peer] = true
+	}
+	for peer := range installed {
+		device.log.Verbosef("%v - Took over sessions from primary", peer)
+		peer.SendKeepalive()
+	}
+	return len(installed)
+}
+
+func (peer *Peer) installReplica(rep *replica) error {
+	device := peer.device
+	suite := LookupCipherSuite(rep.suite)
+	if suite == nil {
+		return unknownCipherSuiteError(rep.suite)
+	}
+	keypair := new(Keypair)
+	keypair.suite = suite
+	var sendErr, recvErr error
+	keypair.send, sendErr = device.newSessionAEAD(suite, rep.sendKey[:])
+	keypair.receive, recvErr = device.newSessionAEAD(suite, rep.recvKey[:])
+	if sendErr == nil && recvErr == nil {
+		device.recordTranscriptSession(peer, suite, rep.LocalIndex, rep.RemoteIndex, rep.Initiator, &rep.sendKey, &rep.recvKey)
+	}
+	setZero(rep.sendKey[:])
+	setZero(rep.recvKey[:])
+	if err := errors.Join(sendErr, recvErr); err != nil {
+		return err
+	}
+	if peer.eagerKeyErasure.Load() {
+		keypair.makeErasable()
+	}
+	keypair.created = time.Unix(0, rep.Created)
+	keypair.isInitiator = rep.Initiator
+	keypair.localIndex = rep.LocalIndex
+	keypair.remoteIndex = rep.RemoteIndex
+	keypair.sendNonce.Store(rep.Ceiling)
+	if rep.Received != 0 {
+		keypair.replayFilter.Advance(rep.Received - 1)
+	}
+
+	if rep.endpoint != "" {
+		endpoint, err := device.net.bind.ParseEndpoint(rep.endpoint)
+		if err != nil {
+			return err
+		}
+		peer.endpoint.Lock()
+		old := peer.endpoint.val
+		peer.endpoint.val = endpoint
+		peer.emitEndpointChangedLocked(old)
+		peer.endpoint.Unlock()
+	}
+
+	if !device.indexTable.insertKeypair(keypair.localIndex, peer, keypair) {
+		return errors.New("index in use")
+	}
+	keypairs := &peer.keypairs
+	keypairs.Lock()
+	switch rep.Flags & replicationSlotMask {
+	case slotCurrent:
+		device.DeleteKeypair(keypairs.current)
+		keypairs.current = keypair
+	case slotPrevious:
+		device.DeleteKeypair(keypairs.previous)
+		keypairs.previous = keypair
+	case slotNext:
+		device.DeleteKeypair(keypairs.next.Swap(keypair))
+	}
+	keypairs.Unlock()
+	peer.timersSessionDerived()
+	return nil
+}
+
+// A replicationStream carries authenticated and encrypted frames between a
+// primary and a standby.
+type replicationStream struct {
+	rw          io.ReadWriter
+	send, recv  cipher.AEAD
+	sendCounter uint64
+	recvCounter uint64
+}
+
+// newReplicationStream exchanges nonces over rw and derives the keys of the
+// stream from them and secret.
+func newReplicationStream(rw io.ReadWriter, secret [32]byte, primary bool) (*replicationStream, error) {
+	var local, remote [replicationNonceSize]byte
+	if _, err := rand.Read(local[:]); err != nil {
+		return nil, err
+	}
+	written := make(chan error, 1)
+	go func() {
+		_, err := rw.Write(local[:])
+		written <- err
+	}()
+	if _, err := io.ReadFull(rw, remote[:]); err != nil {
+		return nil, err
+	}
+	if err := <-written; err != nil {
+		return nil, err
+	}
+
+	input := []byte(replicationLabel)
+	if primary {
+		input = append(append(input, local[:]...), remote[:]...)
+	} else {
+		input = append(append(input, remote[:]...), local[:]...)
+	}
+	var toStandby, toPrimary [chacha20poly1305.KeySize]byte
+	KDF2(&toStandby, &toPrimary, secret[:], input)
+	defer setZero(toStandby[:])
+	defer setZero(toPrimary[:])
+	if !primary {
+		toStandby, toPrimary = toPrimary, toStandby
+	}
+	stream := &replicationStream{rw: rw}
+	stream.send, _ = chacha20poly1305.New(toStandby[:])
+	stream.recv, _ = chacha20poly1305.New(toPrimary[:])
+	return stream, nil
+}
+
+func (stream *replicationStream) nonce(counter uint64) []byte {
+	var nonce [chacha20poly1305.NonceSize]byte
+	binary.LittleEndian.PutUint64(nonce[4:], counter)
+	return nonce[:]
+}
+
+func (stream *replicationStream) write(frame []byte) error {
+	if len(frame)+chacha20poly1305.Overhead > MaxReplicationFrame {
+		return errors.New("replication frame too large")
+	}
+	buf := make([]byte, 4, 4+len(frame)+chacha20poly1305.Overhead)
+	binary.LittleEndian.PutUint32(buf, uint32(len(frame)+chacha20poly1305.Overhead))
+	buf = stream.send.Seal(buf, stream.nonce(stream.sendCounter), frame, buf[:4])
+	stream.sendCounter++
+	_, err := stream.rw.Write(buf)
+	return err
+}
+
+func (stream *replicationStream) read() ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(stream.rw, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[:])
+	if size < chacha20poly1305.Overhead || size > MaxReplicationFrame {
+		return nil, errors.New("invalid replication frame size")
+	}
+	buf := make([]byte, size)
+	if _, err := io.ReadFull(stream.rw, buf); err != nil {
+		return nil, err
+	}
+	frame, err := stream.recv.Open(buf[:0], stream.nonce(stream.recvCounter), buf, header[:])
+	if err != nil {
+		return nil, errors.New("replication frame failed to authenticate")
+	}
+	stream.recvCounter++
+	return frame, nil
+}
@@ -0,0 +1,117 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/hex"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func TestReplicationFailover(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, true)
+
+	// The standby has the identity and peer of pair[0].
+	standbyTUN := tuntest.NewChannelTUN()
+	standby := NewDevice(standbyTUN.TUN(), conn.NewDefaultBind(), NewLogger(LogLevelVerbose, "standby: "))
+	t.Cleanup(standby.Close)
+	sk := pair[0].dev.staticIdentity.privateKey
+	pk := pair[1].dev.staticIdentity.publicKey
+	if err := standby.IpcSet(uapiCfg(
+		"private_key", hex.EncodeToString(sk[:]),
+		"listen_port", "0",
+		"public_key", hex.EncodeToString(pk[:]),
+		"allowed_ip", "1.0.0.2/32",
+	)); err != nil {
+		t.Fatal(err)
+	}
+	if err := standby.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	secret := [32]byte{1, 2, 3}
+	primaryConn, standbyConn := net.Pipe()
+	primaryErr, standbyErr := make(chan error, 1), make(chan error, 1)
+	go func() { primaryErr <- pair[0].dev.Replicate(primaryConn, secret) }()
+	go func() { standbyErr <- standby.ReceiveReplication(standbyConn, secret) }()
+	waitFor(t, func() bool { return pair[0].dev.replication.primary.Load() != nil })
+
+	// Sessions derived from now on are replicated, and the primary sends on
+	// them once the standby acknowledged its lease.
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+	peer0 := pair[0].dev.LookupPeer(pk)
+	peer1 := pair[1].dev.LookupPeer(pair[0].dev.staticIdentity.publicKey)
+	primaryKeypair, peerKeypair := peer0.keypairs.Current(), peer1.keypairs.Current()
+	if primaryKeypair.lease == nil {
+		t.Fatal("session not replicated")
+	}
+	waitFor(t, func() bool {
+		standby.replication.mu.Lock()
+		defer standby.replication.mu.Unlock()
+		return standby.replication.replicas[primaryKeypair.localIndex] != nil
+	})
+
+	if n := standby.TakeOver(); n != 1 {
+		t.Fatalf("took over sessions of %d peers, want 1", n)
+	}
+	if err := <-standbyErr; !errors.Is(err, ErrTakenOver) {
+		t.Errorf("standby replication ended with %v", err)
+	}
+	if err := <-primaryErr; err == nil {
+		t.Error("primary replication ended without error")
+	}
+
+	// The primary is fenced below the counters the standby starts from.
+	standbyKeypair := standby.LookupPeer(pk).keypairs.Current()
+	if standbyKeypair == nil || standbyKeypair.localIndex != primaryKeypair.localIndex {
+		t.Fatal("session not installed on standby")
+	}
+	if limit, start := primaryKeypair.sendLimit(), standbyKeypair.sendNonce.Load(); limit > start {
+		t.Errorf("primary may send up to counter %d, standby starts from %d", limit, start)
+	}
+	pair[0].dev.Close()
+
+	// The peer roams to the standby and keeps its session.
+	receive := func(tun *tuntest.ChannelTUN, want string) {
+		t.Helper()
+		select {
+		case <-tun.Inbound:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s not received", want)
+		}
+	}
+	standbyTUN.Outbound <- tuntest.Ping(pair[1].ip, pair[0].ip)
+	receive(pair[1].tun, "ping from standby")
+	pair[1].tun.Outbound <- tuntest.Ping(pair[0].ip, pair[1].ip)
+	receive(standbyTUN, "ping to standby")
+	if peer1.keypairs.Current() != peerKeypair {
+		t.Error("peer handshook again with standby")
+	}
+}
+
+func TestReplicationSecret(t *testing.T) {
+	goroutineLeakCheck(t)
+	dev1, dev2 := randDevice(t), randDevice(t)
+	defer dev1.Close()
+	defer dev2.Close()
+
+	conn1, conn2 := net.Pipe()
+	errs := make(chan error, 1)
+	go func() { errs <- dev1.Replicate(conn1, [32]byte{1}) }()
+	if err := dev2.ReceiveReplication(conn2, [32]byte{2}); err == nil {
+		t.Error("replication with a different secret accepted")
+	}
+	if err := <-errs; err == nil {
+		t.Error("primary kept replicating to standby with a different secret")
+	}
+}
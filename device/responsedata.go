@@ -0,0 +1,128 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"errors"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+/* Response data lets a responder attach a small encrypted payload, such as
+ * an assigned inner address, to its handshake response:
+ *
+ *   response (92 bytes) | ChaCha20Poly1305(key, ZeroNonce, data, response)
+ *
+ * The key is derived from the final handshake chaining key, so only the
+ * initiator of that handshake can read the payload, and the response itself
+ * is the associated data. The trailer lies outside the MACs; a corrupted one
+ * is discarded without failing the handshake.
+ *
+ * Initiators always accept the trailer, but peers that do not implement it
+ * drop responses that carry one. Response data is therefore only attached
+ * to responses to initiations that carried a cipher suite offer, which
+ * stock peers never send: an initiator that wants response data needs an
+ * offer configured, if only of the suite it is pinned to. Responses to the
+ * others are sent without it, and their initiator's ResponseData is
+ * cleared.
+ */
+
+const WGLabelResponseData = "response data"
+
+const MessageResponseDataOverhead = chacha20poly1305.Overhead
+
+// SetResponseData sets the payload attached to handshake responses sent to
+// the peer. An empty payload disables response data.
+func (peer *Peer) SetResponseData(data []byte) error {
+	if len(data) > MaxResponseDataSize {
+		return errors.New("response data too long")
+	}
+	if len(data) == 0 {
+		peer.responseData.send.Store(nil)
+		return nil
+	}
+	data = append([]byte(nil), data...)
+	peer.responseData.send.Store(&data)
+	return nil
+}
+
+// ResponseData returns the payload most recently received in a handshake
+// response from the peer, or nil if there is none.
+func (peer *Peer) ResponseData() []byte {
+	if data := peer.responseData.received.Load(); data != nil {
+		return append([]byte(nil), (*data)...)
+	}
+	return nil
+}
+
+func (peer *Peer) responseDataKey(key *[blake2s.Size]byte) {
+	peer.handshake.handshakeHash().kdf1(key, peer.handshake.chainKey[:], []byte(WGLabelResponseData))
+}
+
+// sealResponseData appends the configured response data, if any and the
+// initiation answered carried an offer, to a response created but not yet
+// turned into a session, padded if handshake padding is set. The response
+// may already carry its cipher suite selection, which the data follows.
+func (peer *Peer) sealResponseData(packet []byte) []byte {
+	var key [blake2s.Size]byte
+	peer.handshake.mutex.RLock()
+	if peer.handshake.state != handshakeResponseCreated {
+		peer.handshake.mutex.RUnlock()
+		return packet
+	}
+	var data []byte
+	if configured := peer.responseData.send.Load(); configured != nil && peer.handshake.negotiation.offer != nil {
+		data = *configured
+	}
+	if padding := peer.device.responseDataPadding(len(packet), data); padding != nil {
+		data = append(append([]byte(nil), data...), padding...)
+	}
+	if data == nil {
+		peer.handshake.mutex.RUnlock()
+		return packet
+	}
+	peer.responseDataKey(&key)
+	peer.handshake.mutex.RUnlock()
+
+	aead, _ := chacha20poly1305.New(key[:])
+	setZero(key[:])
+	return aead.Seal(packet, ZeroNonce[:], data, packet[:MessageResponseSize])
+}
+
+// openResponseData decrypts the trailer of a consumed response and stores
+// the payload. An empty trailer clears the previously received payload.
+func (peer *Peer) openResponseData(packet, trailer []byte) error {
+	if len(trailer) == 0 {
+		peer.responseData.received.Store(nil)
+		return nil
+	}
+
+	var key [blake2s.Size]byte
+	peer.handshake.mutex.RLock()
+	if peer.handshake.state != handshakeResponseConsumed {
+		peer.handshake.mutex.RUnlock()
+		return errors.New("handshake not in response consumed state")
+	}
+	peer.responseDataKey(&key)
+	peer.handshake.mutex.RUnlock()
+
+	aead, _ := chacha20poly1305.New(key[:])
+	setZero(key[:])
+	data, err := aead.Open(nil, ZeroNonce[:], trailer, packet)
+	if err == nil {
+		data, err = peer.device.unpadResponseData(data)
+	}
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		peer.responseData.received.Store(nil)
+		return nil
+	}
+	peer.responseData.received.Store(&data)
+	return nil
+}
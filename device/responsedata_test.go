@@ -0,0 +1,69 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+func TestResponseData(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	data := []byte("address=10.0.0.7/32")
+
+	// The second device responds to the handshake started by the first.
+	pub0 := pair[0].dev.staticIdentity.publicKey
+	if err := pair[1].dev.IpcSet(uapiCfg(
+		"public_key", hex.EncodeToString(pub0[:]),
+		"response_data", hex.EncodeToString(data),
+	)); err != nil {
+		t.Fatal(err)
+	}
+	if err := pair[1].dev.IpcSet(uapiCfg(
+		"public_key", hex.EncodeToString(pub0[:]),
+		"response_data", hex.EncodeToString(make([]byte, MaxResponseDataSize+1)),
+	)); err == nil {
+		t.Error("oversized response data accepted")
+	}
+	pair.Send(t, Pong, nil)
+
+	// Without an offer, the initiator may be a stock peer, which would
+	// drop the response.
+	pub1 := pair[1].dev.staticIdentity.publicKey
+	peer := pair[0].dev.LookupPeer(pub1)
+	if got := peer.ResponseData(); got != nil {
+		t.Fatalf("received response data %q without an offer", got)
+	}
+
+	pair = genTestPair(t, false)
+	pub0 = pair[0].dev.staticIdentity.publicKey
+	if err := pair[1].dev.IpcSet(uapiCfg(
+		"public_key", hex.EncodeToString(pub0[:]),
+		"response_data", hex.EncodeToString(data),
+	)); err != nil {
+		t.Fatal(err)
+	}
+	pub1 = pair[1].dev.staticIdentity.publicKey
+	peer = pair[0].dev.LookupPeer(pub1)
+	if err := peer.SetCipherSuiteOffer([]string{StandardCipherSuite}); err != nil {
+		t.Fatal(err)
+	}
+	pair.Send(t, Pong, nil)
+	if got := peer.ResponseData(); !bytes.Equal(got, data) {
+		t.Fatalf("received response data %q, want %q", got, data)
+	}
+	cfg, err := pair[0].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(cfg, "received_response_data="+hex.EncodeToString(data)+"\n") {
+		t.Errorf("get output lacks received response data:\n%s", cfg)
+	}
+	pair.Send(t, Ping, nil)
+}
@@ -0,0 +1,145 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+/* Crypto self-test
+ *
+ * Transport data runs through assembly, such as that of ChaCha20-Poly1305,
+ * AES-GCM and Poly1795, picked at run time by the features of the CPU. A
+ * miscompiled or mis-selected backend need not crash: it may encrypt with
+ * the wrong keystream or compute the wrong tags, which breaks
+ * interoperability at best and confidentiality or integrity at worst.
+ * Cipher suites may therefore carry a known-answer test, which the device
+ * runs for every suite it uses: its own, and those its peers are pinned to
+ * or negotiate. The tests of the suites of this package seal inputs of
+ * sizes on either side of the lengths at which backends switch to wider
+ * code paths, through SealBatch, and compare a BLAKE2s digest of the
+ * results with that of the portable implementation, which the vectors were
+ * generated with; they also open what they sealed, and check that a
+ * corrupted tag fails to open. The suites of the modified primitives test
+ * ChaCha20_24 and Poly1795 on their own as well, comparing their
+ * multi-block paths with the single-block ones.
+ *
+ * NewDevice runs the self-test so that a failure is logged as soon as the
+ * device exists. Up runs it again, for suites configured since, and fails
+ * with ErrCryptoSelfTest if any suite fails, and suites that fail are
+ * refused when set or offered. As the outcome depends only on the binary
+ * and the CPU, each suite's test runs once per process.
+ */
+
+// selfTestSizes are the sizes of the plaintexts of known-answer tests.
+var selfTestSizes = []int{0, 1, 16, 63, 64, 65, 129, 255, 256, 257, 383, 512, 1420}
+
+// selfTestInput returns n bytes counting up from first, which known-answer
+// tests take their keys, nonces and messages from. The AEAD of a suite's
+// SelfTest is keyed with the 32 bytes from zero.
+func selfTestInput(n int, first byte) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = first + byte(i)
+	}
+	return b
+}
+
+// aeadSelfTest returns the SelfTest of a suite whose sealed outputs have
+// the BLAKE2s-256 digest given in hex.
+func aeadSelfTest(digest string) func(aead AEADSuite) error {
+	return func(aead AEADSuite) error {
+		nonce := selfTestInput(aead.NonceSize(), 0x40)
+		additionalData := selfTestInput(13, 0x80)
+		entries := make([]SealBatchEntry, len(selfTestSizes))
+		for i, size := range selfTestSizes {
+			entries[i] = SealBatchEntry{Nonce: nonce, Plaintext: selfTestInput(size, byte(i)), AdditionalData: additionalData}
+		}
+		SealBatch(aead, entries)
+
+		h, _ := blake2s.New256(nil)
+		for _, e := range entries {
+			if len(e.Sealed) != len(e.Plaintext)+aead.Overhead() {
+				return fmt.Errorf("sealed %d bytes into %d", len(e.Plaintext), len(e.Sealed))
+			}
+			h.Write(e.Sealed)
+			opened, err := aead.Open(nil, nonce, e.Sealed, additionalData)
+			if err != nil || !bytes.Equal(opened, e.Plaintext) {
+				return fmt.Errorf("failed to open %d bytes sealed", len(e.Plaintext))
+			}
+			corrupted := bytes.Clone(e.Sealed)
+			corrupted[len(corrupted)-1] ^= 1
+			if _, err := aead.Open(nil, nonce, corrupted, additionalData); err == nil {
+				return fmt.Errorf("opened %d bytes with a corrupted tag", len(e.Plaintext))
+			}
+		}
+		if got := hex.EncodeToString(h.Sum(nil)); got != digest {
+			return fmt.Errorf("sealed outputs with digest %s, want %s", got, digest)
+		}
+		return nil
+	}
+}
+
+// selfTestResults maps suites to the errors of their SelfTest.
+var selfTestResults sync.Map
+
+// selfTest runs the known-answer test of the suite, if it has one and it has
+// not run yet, and returns an error wrapping ErrCryptoSelfTest if it failed.
+func (suite *CipherSuite) selfTest() error {
+	if suite.SelfTest == nil {
+		return nil
+	}
+	result, ok := selfTestResults.Load(suite)
+	if !ok {
+		aead, err := suite.New(selfTestInput(chacha20poly1305.KeySize, 0))
+		if err == nil {
+			err = suite.SelfTest(aead)
+		}
+		if err != nil {
+			err = fmt.Errorf("%w: %s: %v", ErrCryptoSelfTest, suite.Name, err)
+		}
+		result, _ = selfTestResults.LoadOrStore(suite, &err)
+	}
+	return *result.(*error)
+}
+
+// RunCryptoSelfTest runs the known-answer tests of the cipher suites the
+// device and its peers use, and returns an error wrapping ErrCryptoSelfTest
+// for each suite that failed. A device whose self-test fails does not come
+// up.
+func (device *Device) RunCryptoSelfTest() error {
+	suites := []*CipherSuite{device.cipherSuite()}
+	device.peers.RLock()
+	for _, peer := range device.peers.keyMap {
+		if suite := peer.suite.Load(); suite != nil {
+			suites = append(suites, suite)
+		}
+		if offer := peer.suiteOffer.Load(); offer != nil {
+			suites = append(suites, *offer...)
+		}
+	}
+	device.peers.RUnlock()
+
+	var errs []error
+	tested := make(map[*CipherSuite]bool)
+	for _, suite := range suites {
+		if tested[suite] {
+			continue
+		}
+		tested[suite] = true
+		if err := suite.selfTest(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
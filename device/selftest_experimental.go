@@ -0,0 +1,68 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	"golang.org/x/crypto/blake2s"
+)
+
+// chacha20x24SelfTest checks the ChaCha20_24 keystream the cipher produces,
+// four blocks at a time with NEON, against that of the block function and
+// the digest of its first blocks.
+func chacha20x24SelfTest() error {
+	key := (*[chachaKeySize]byte)(selfTestInput(chachaKeySize, 0))
+	nonce := (*[chachaNonceSize]byte)(selfTestInput(chachaNonceSize, 0x40))
+	c, err := NewChaCha20x24Cipher(key[:], nonce[:])
+	if err != nil {
+		return err
+	}
+	stream := make([]byte, 9*64+7)
+	c.XORKeyStream(stream, stream)
+	for i := 0; i*64 < len(stream); i++ {
+		var block [64]byte
+		chachaBlock24(key, nonce, uint32(i), &block)
+		if !bytes.HasPrefix(block[:], stream[i*64:min(len(stream), (i+1)*64)]) {
+			return fmt.Errorf("ChaCha20_24 keystream block %d unlike that of the block function", i)
+		}
+	}
+	const digest = "784835ff7bafdcb9be850ef2e3450e2ff9be514232d52fe7389f28e867c7bab2"
+	if got := blake2s.Sum256(stream); hex.EncodeToString(got[:]) != digest {
+		return fmt.Errorf("ChaCha20_24 keystream with digest %x, want %s", got, digest)
+	}
+	return nil
+}
+
+// poly1795SelfTest checks the Poly1795 tags of the scalar step against
+// those of the vectorized one and the digest of both.
+func poly1795SelfTest() error {
+	key := (*[32]byte)(selfTestInput(32, 0x20))
+	h, _ := blake2s.New256(nil)
+	for i, size := range selfTestSizes {
+		message := selfTestInput(size, byte(i))
+		var mac poly1795MAC
+		mac.init(key)
+		mac.vec = false
+		mac.Write(message)
+		var scalar, vec [Poly1795Size]byte
+		mac.Sum(scalar[:0])
+		Poly1795SumVec(&vec, message, key)
+		if scalar != vec {
+			return fmt.Errorf("Poly1795 tag of %d bytes differs between the scalar and vectorized steps", size)
+		}
+		h.Write(scalar[:])
+	}
+	const digest = "34f65d26416c857d649bc5191d7f3cb53c353e5765cfc6f9adaa545834d6b887"
+	if got := hex.EncodeToString(h.Sum(nil)); got != digest {
+		return fmt.Errorf("Poly1795 tags with digest %s, want %s", got, digest)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// testBrokenCipherSuite claims the known answers of the standard suite but
+// seals under a hashed key, as a miscompiled backend might.
+const testBrokenCipherSuite = "TestBrokenChaCha20Poly1305"
+
+func init() {
+	RegisterCipherSuite(CipherSuite{
+		Name:         testBrokenCipherSuite,
+		Experimental: true,
+		New: func(key []byte) (AEADSuite, error) {
+			hashed := blake2s.Sum256(key)
+			return chacha20poly1305.New(hashed[:])
+		},
+		SelfTest: LookupCipherSuite(StandardCipherSuite).SelfTest,
+	})
+}
+
+func TestCipherSuiteSelfTests(t *testing.T) {
+	for _, name := range CipherSuites() {
+		suite := LookupCipherSuite(name)
+		if suite.SelfTest == nil || name == testBrokenCipherSuite {
+			continue
+		}
+		if err := suite.selfTest(); err != nil {
+			t.Errorf("suite %s: %v", name, err)
+		}
+	}
+	if err := LookupCipherSuite(testBrokenCipherSuite).selfTest(); !errors.Is(err, ErrCryptoSelfTest) || !strings.Contains(err.Error(), testBrokenCipherSuite) {
+		t.Errorf("broken suite passed its self-test: %v", err)
+	}
+}
+
+func TestCryptoSelfTestRefusesUp(t *testing.T) {
+	dev := randDevice(t)
+	defer dev.Close()
+	assertNil(t, dev.RunCryptoSelfTest())
+
+	if err := dev.SetCipherSuite(testBrokenCipherSuite); !errors.Is(err, ErrCryptoSelfTest) {
+		t.Fatalf("set a suite failing its self-test: %v", err)
+	}
+	if err := dev.IpcSet("cipher_suite=" + testBrokenCipherSuite + "\n"); !errors.Is(err, ErrCryptoSelfTest) {
+		t.Fatalf("set a suite failing its self-test over UAPI: %v", err)
+	}
+
+	peer, err := dev.NewPeer(NoisePublicKey{1})
+	assertNil(t, err)
+	if err := peer.SetCipherSuite(testBrokenCipherSuite); !errors.Is(err, ErrCryptoSelfTest) {
+		t.Fatalf("pinned a peer to a suite failing its self-test: %v", err)
+	}
+	if err := peer.SetCipherSuiteOffer([]string{StandardCipherSuite, testBrokenCipherSuite}); !errors.Is(err, ErrCryptoSelfTest) {
+		t.Fatalf("offered a suite failing its self-test: %v", err)
+	}
+
+	// A suite in use that fails keeps the device down.
+	dev.suite.Store(LookupCipherSuite(testBrokenCipherSuite))
+	if err := dev.Up(); !errors.Is(err, ErrCryptoSelfTest) {
+		t.Fatalf("brought up with a suite failing its self-test: %v", err)
+	}
+	if dev.isUp() {
+		t.Fatal("device up after its self-test failed")
+	}
+	dev.suite.Store(nil)
+	assertNil(t, dev.RunCryptoSelfTest())
+}
@@ -9,12 +9,13 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
+	"log/slog"
 	"net"
 	"os"
 	"sync"
 	"time"
 
-	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/blake2s"
 	"golang.org/x/net/ipv4"
 	"golang.org/x/net/ipv6"
 	"golang.zx2c4.com/wireguard/conn"
@@ -51,17 +52,21 @@ type QueueOutboundElement struct {
 	nonce   uint64                // nonce for encryption
 	keypair *Keypair              // keypair for encryption
 	peer    *Peer                 // related peer
+	queued  time.Time             // when read from the TUN device, if the peer has a maximum queue age
 }
 
 type QueueOutboundElementsContainer struct {
 	sync.Mutex
-	elems []*QueueOutboundElement
+	elems    []*QueueOutboundElement
+	endpoint conn.Endpoint // overrides the peer's endpoint, for path probes
+	dropped  bool          // by an encryption worker that crashed on it
 }
 
 func (device *Device) NewOutboundElement() *QueueOutboundElement {
 	elem := device.GetOutboundElement()
 	elem.buffer = device.GetMessageBuffer()
 	elem.nonce = 0
+	elem.queued = time.Time{}
 	// keypair and peer were cleared (if necessary) by clearPointers.
 	return elem
 }
@@ -80,18 +85,12 @@ func (elem *QueueOutboundElement) clearPointers() {
 /* Queues a keepalive if no packets are queued for peer
  */
 func (peer *Peer) SendKeepalive() {
-	if len(peer.queue.staged) == 0 && peer.isRunning.Load() {
+	if peer.queue.staged.len() == 0 && peer.isRunning.Load() {
 		elem := peer.device.NewOutboundElement()
 		elemsContainer := peer.device.GetOutboundElementsContainer()
 		elemsContainer.elems = append(elemsContainer.elems, elem)
-		select {
-		case peer.queue.staged <- elemsContainer:
-			peer.device.log.Verbosef("%v - Sending keepalive packet", peer)
-		default:
-			peer.device.PutMessageBuffer(elem.buffer)
-			peer.device.PutOutboundElement(elem)
-			peer.device.PutOutboundElementsContainer(elemsContainer)
-		}
+		peer.StagePackets(elemsContainer)
+		peer.device.log.transport.Verbosef("%v - Sending keepalive packet", peer)
 	}
 	peer.SendStagedPackets()
 }
@@ -116,26 +115,29 @@ func (peer *Peer) SendHandshakeInitiation(isRetry bool) error {
 	peer.handshake.lastSentHandshake = time.Now()
 	peer.handshake.mutex.Unlock()
 
-	peer.device.log.Verbosef("%v - Sending handshake initiation", peer)
+	peer.device.log.handshake.verboseAttrs([]slog.Attr{peerAttr(peer)}, "%v - Sending handshake initiation", peer)
 
 	msg, err := peer.device.CreateMessageInitiation(peer)
 	if err != nil {
-		peer.device.log.Errorf("%v - Failed to create initiation message: %v", peer, err)
+		peer.device.log.handshake.errorAttrs([]slog.Attr{peerAttr(peer), errorAttr(err)}, "%v - Failed to create initiation message: %v", peer, err)
 		return err
 	}
 
-	var buf [MessageInitiationSize]byte
-	writer := bytes.NewBuffer(buf[:0])
-	binary.Write(writer, binary.LittleEndian, msg)
-	packet := writer.Bytes()
+	var buf [maxHandshakeMessageSize]byte
+	packet := buf[:MessageInitiationSize]
+	msg.marshal(packet)
+	peer.device.PutMessageInitiation(msg)
 	peer.cookieGenerator.AddMacs(packet)
+	packet = peer.sealHybridInitiation(packet)
+	packet = peer.sealCipherSuiteOffer(packet)
+	packet = peer.device.padInitiation(buf[:], len(packet))
 
 	peer.timersAnyAuthenticatedPacketTraversal()
 	peer.timersAnyAuthenticatedPacketSent()
 
 	err = peer.SendBuffers([][]byte{packet})
 	if err != nil {
-		peer.device.log.Errorf("%v - Failed to send handshake initiation: %v", peer, err)
+		peer.device.log.handshake.errorAttrs([]slog.Attr{peerAttr(peer), errorAttr(err)}, "%v - Failed to send handshake initiation: %v", peer, err)
 	}
 	peer.timersHandshakeInitiated()
 
@@ -143,27 +145,36 @@ func (peer *Peer) SendHandshakeInitiation(isRetry bool) error {
 }
 
 func (peer *Peer) SendHandshakeResponse() error {
+	return peer.sendHandshakeResponse(nil)
+}
+
+// sendHandshakeResponse is SendHandshakeResponse sending to endpoint, or to
+// the peer's endpoint if endpoint is nil.
+func (peer *Peer) sendHandshakeResponse(endpoint conn.Endpoint) error {
 	peer.handshake.mutex.Lock()
 	peer.handshake.lastSentHandshake = time.Now()
 	peer.handshake.mutex.Unlock()
 
-	peer.device.log.Verbosef("%v - Sending handshake response", peer)
+	peer.device.log.handshake.verboseAttrs([]slog.Attr{peerAttr(peer)}, "%v - Sending handshake response", peer)
 
 	response, err := peer.device.CreateMessageResponse(peer)
 	if err != nil {
-		peer.device.log.Errorf("%v - Failed to create response message: %v", peer, err)
+		peer.device.log.handshake.errorAttrs([]slog.Attr{peerAttr(peer), errorAttr(err)}, "%v - Failed to create response message: %v", peer, err)
 		return err
 	}
 
-	var buf [MessageResponseSize]byte
-	writer := bytes.NewBuffer(buf[:0])
-	binary.Write(writer, binary.LittleEndian, response)
-	packet := writer.Bytes()
+	var buf [maxHandshakeMessageSize]byte
+	packet := buf[:MessageResponseSize]
+	response.marshal(packet)
+	peer.device.PutMessageResponse(response)
 	peer.cookieGenerator.AddMacs(packet)
+	packet = peer.sealHybridResponse(packet)
+	packet = peer.sealCipherSuiteSelection(packet)
+	packet = peer.sealResponseData(packet)
 
 	err = peer.BeginSymmetricSession()
 	if err != nil {
-		peer.device.log.Errorf("%v - Failed to derive keypair: %v", peer, err)
+		peer.device.log.handshake.errorAttrs([]slog.Attr{peerAttr(peer), errorAttr(err)}, "%v - Failed to derive keypair: %v", peer, err)
 		return err
 	}
 
@@ -172,20 +183,25 @@ func (peer *Peer) SendHandshakeResponse() error {
 	peer.timersAnyAuthenticatedPacketSent()
 
 	// TODO: allocation could be avoided
-	err = peer.SendBuffers([][]byte{packet})
+	if endpoint != nil {
+		err = peer.sendBuffersTo([][]byte{packet}, endpoint)
+	} else {
+		err = peer.SendBuffers([][]byte{packet})
+	}
 	if err != nil {
-		peer.device.log.Errorf("%v - Failed to send handshake response: %v", peer, err)
+		peer.device.log.handshake.errorAttrs([]slog.Attr{peerAttr(peer), errorAttr(err)}, "%v - Failed to send handshake response: %v", peer, err)
 	}
 	return err
 }
 
 func (device *Device) SendHandshakeCookie(initiatingElem *QueueHandshakeElement) error {
-	device.log.Verbosef("Sending cookie response for denied handshake message for %v", initiatingElem.endpoint.DstToString())
+	endpoint := initiatingElem.endpoint.DstToString()
+	device.log.handshake.verboseAttrs([]slog.Attr{endpointAttr(endpoint)}, "Sending cookie response for denied handshake message for %v", endpoint)
 
 	sender := binary.LittleEndian.Uint32(initiatingElem.packet[4:8])
 	reply, err := device.cookieChecker.CreateReply(initiatingElem.packet, sender, initiatingElem.endpoint.DstToBytes())
 	if err != nil {
-		device.log.Errorf("Failed to create cookie reply: %v", err)
+		device.log.handshake.errorAttrs([]slog.Attr{errorAttr(err)}, "Failed to create cookie reply: %v", err)
 		return err
 	}
 
@@ -193,7 +209,12 @@ func (device *Device) SendHandshakeCookie(initiatingElem *QueueHandshakeElement)
 	writer := bytes.NewBuffer(buf[:0])
 	binary.Write(writer, binary.LittleEndian, reply)
 	// TODO: allocation could be avoided
-	device.net.bind.Send([][]byte{writer.Bytes()}, initiatingElem.endpoint)
+	device.net.RLock()
+	err = device.sendTo([][]byte{writer.Bytes()}, initiatingElem.endpoint)
+	device.net.RUnlock()
+	if err == nil {
+		device.cookieStats.repliesSent.Add(1)
+	}
 	return nil
 }
 
@@ -203,19 +224,26 @@ func (peer *Peer) keepKeyFreshSending() {
 		return
 	}
 	nonce := keypair.sendNonce.Load()
-	if nonce > RekeyAfterMessages || (keypair.isInitiator && time.Since(keypair.created) > RekeyAfterTime) {
+	forecastRekey := keypair.usage.sample(nonce, time.Now())
+	if forecastRekey && !keypair.usage.forecastRekey {
+		keypair.usage.forecastRekey = true
+		peer.device.log.handshake.Verbosef("%v - Keypair forecast to run out of messages, rekeying early", peer)
+	}
+	if nonce > RekeyAfterMessages || forecastRekey || (keypair.isInitiator && time.Since(keypair.created) > RekeyAfterTime) {
 		peer.SendHandshakeInitiation(false)
 	}
 }
 
 func (device *Device) RoutineReadFromTUN() {
 	defer func() {
-		device.log.Verbosef("Routine: TUN reader - stopped")
+		device.log.tun.Verbosef("Routine: TUN reader - stopped")
 		device.state.stopping.Done()
 		device.queue.encryption.wg.Done()
 	}()
 
-	device.log.Verbosef("Routine: TUN reader - started")
+	device.log.tun.Verbosef("Routine: TUN reader - started")
+	device.pipeline.enter(stageTUNRead)
+	defer device.pipeline.exit(stageTUNRead)
 
 	var (
 		batchSize   = device.BatchSize()
@@ -226,7 +254,9 @@ func (device *Device) RoutineReadFromTUN() {
 		count       = 0
 		sizes       = make([]int, batchSize)
 		offset      = MessageTransportHeaderSize
+		binding     = coreBinding{device: device, role: coreRoleTUN}
 	)
+	defer binding.release()
 
 	for i := range elems {
 		elems[i] = device.NewOutboundElement()
@@ -243,8 +273,19 @@ func (device *Device) RoutineReadFromTUN() {
 	}()
 
 	for {
+		// apply backpressure while the bind cannot keep up
+		congested := device.congested()
+		if congested {
+			device.congestion.backoffs.Add(1)
+			time.Sleep(CongestionBackoff)
+		}
+
 		// read packets
+		binding.update()
 		count, readErr = device.tun.device.Read(bufs, sizes, offset)
+		var now time.Time
+		start := device.pipeline.begin()
+		binding.count(sizes[:count])
 		for i := 0; i < count; i++ {
 			if sizes[i] < 1 {
 				continue
@@ -271,12 +312,30 @@ func (device *Device) RoutineReadFromTUN() {
 				peer = device.allowedips.Lookup(dst)
 
 			default:
-				device.log.Verbosef("Received packet with unknown IP version")
+				device.log.tun.Verbosef("Received packet with unknown IP version")
 			}
 
 			if peer == nil {
+				device.tracePacket(true, PacketNoRoute, nil, 0, sizes[i])
 				continue
 			}
+			if device.replyUnreachable(peer, elem.packet) {
+				device.tracePacket(true, PacketUnreachable, peer, 0, sizes[i])
+				continue
+			}
+			peer.countSentPacket(elem.packet)
+			if mtu := peer.mssClampMTU.Load(); mtu != 0 {
+				clampMSS(elem.packet, mtu)
+			}
+			if peer.maxQueueAge.Load() != 0 {
+				if now.IsZero() {
+					now = time.Now()
+				}
+				elem.queued = now
+			}
+			if congested && markCongestionExperienced(elem.packet) {
+				device.congestion.marked.Add(1)
+			}
 			elemsForPeer, ok := elemsByPeer[peer]
 			if !ok {
 				elemsForPeer = device.GetOutboundElementsContainer()
@@ -293,6 +352,7 @@ func (device *Device) RoutineReadFromTUN() {
 				peer.SendStagedPackets()
 			} else {
 				for _, elem := range elemsForPeer.elems {
+					device.tracePacket(true, PacketPeerDown, peer, 0, len(elem.packet))
 					device.PutMessageBuffer(elem.buffer)
 					device.PutOutboundElement(elem)
 				}
@@ -300,18 +360,19 @@ func (device *Device) RoutineReadFromTUN() {
 			}
 			delete(elemsByPeer, peer)
 		}
+		device.pipeline.done(stageTUNRead, count, start)
 
 		if readErr != nil {
 			if errors.Is(readErr, tun.ErrTooManySegments) {
 				// TODO: record stat for this
 				// This will happen if MSS is surprisingly small (< 576)
 				// coincident with reasonably high throughput.
-				device.log.Verbosef("Dropped some packets from multi-segment read: %v", readErr)
+				device.log.tun.Verbosef("Dropped some packets from multi-segment read: %v", readErr)
 				continue
 			}
 			if !device.isClosed() {
 				if !errors.Is(readErr, os.ErrClosed) {
-					device.log.Errorf("Failed to read packet from TUN device: %v", readErr)
+					device.log.tun.Errorf("Failed to read packet from TUN device: %v", readErr)
 				}
 				go device.Close()
 			}
@@ -320,28 +381,9 @@ func (device *Device) RoutineReadFromTUN() {
 	}
 }
 
-func (peer *Peer) StagePackets(elems *QueueOutboundElementsContainer) {
-	for {
-		select {
-		case peer.queue.staged <- elems:
-			return
-		default:
-		}
-		select {
-		case tooOld := <-peer.queue.staged:
-			for _, elem := range tooOld.elems {
-				peer.device.PutMessageBuffer(elem.buffer)
-				peer.device.PutOutboundElement(elem)
-			}
-			peer.device.PutOutboundElementsContainer(tooOld)
-		default:
-		}
-	}
-}
-
 func (peer *Peer) SendStagedPackets() {
 top:
-	if len(peer.queue.staged) == 0 || !peer.device.isUp() {
+	if peer.queue.staged.len() == 0 || !peer.device.isUp() {
 		return
 	}
 
@@ -350,74 +392,91 @@ top:
 		peer.SendHandshakeInitiation(false)
 		return
 	}
+	if keypair.sendNonce.Load() >= keypair.sendLimit() {
+		// Await a longer lease from the standby, unless fenced off by it.
+		if !peer.device.replication.renew(keypair) {
+			peer.SendHandshakeInitiation(false)
+		}
+		return
+	}
+	limit := keypair.sendLimit()
 
 	for {
 		var elemsContainerOOO *QueueOutboundElementsContainer
-		select {
-		case elemsContainer := <-peer.queue.staged:
-			i := 0
-			for _, elem := range elemsContainer.elems {
-				elem.peer = peer
-				elem.nonce = keypair.sendNonce.Add(1) - 1
+		elemsContainer := peer.queue.staged.pop()
+		if elemsContainer == nil {
+			return
+		}
+		i := 0
+		now := time.Now()
+		for _, elem := range elemsContainer.elems {
+			if peer.stale(elem.queued, now) {
+				peer.device.PutMessageBuffer(elem.buffer)
+				peer.device.PutOutboundElement(elem)
+				continue
+			}
+			elem.peer = peer
+			elem.nonce = keypair.sendNonce.Add(1) - 1
+			if elem.nonce >= limit {
 				if elem.nonce >= RejectAfterMessages {
 					keypair.sendNonce.Store(RejectAfterMessages)
-					if elemsContainerOOO == nil {
-						elemsContainerOOO = peer.device.GetOutboundElementsContainer()
-					}
-					elemsContainerOOO.elems = append(elemsContainerOOO.elems, elem)
-					continue
-				} else {
-					elemsContainer.elems[i] = elem
-					i++
 				}
-
-				elem.keypair = keypair
+				if elemsContainerOOO == nil {
+					elemsContainerOOO = peer.device.GetOutboundElementsContainer()
+				}
+				elemsContainerOOO.elems = append(elemsContainerOOO.elems, elem)
+				continue
+			} else {
+				elemsContainer.elems[i] = elem
+				i++
 			}
-			elemsContainer.Lock()
-			elemsContainer.elems = elemsContainer.elems[:i]
 
-			if elemsContainerOOO != nil {
-				peer.StagePackets(elemsContainerOOO) // XXX: Out of order, but we can't front-load go chans
-			}
+			elem.keypair = keypair
+		}
+		if keypair.lease != nil && keypair.sendNonce.Load()+ReplicationLease/2 > limit {
+			peer.device.replication.renew(keypair)
+		}
+		elemsContainer.Lock()
+		elemsContainer.elems = elemsContainer.elems[:i]
 
-			if len(elemsContainer.elems) == 0 {
-				peer.device.PutOutboundElementsContainer(elemsContainer)
-				goto top
-			}
+		if elemsContainerOOO != nil {
+			peer.StagePackets(elemsContainerOOO) // XXX: Out of order, but we can't front-load go chans
+		}
 
-			// add to parallel and sequential queue
-			if peer.isRunning.Load() {
-				peer.queue.outbound.c <- elemsContainer
-				peer.device.queue.encryption.c <- elemsContainer
-			} else {
-				for _, elem := range elemsContainer.elems {
-					peer.device.PutMessageBuffer(elem.buffer)
-					peer.device.PutOutboundElement(elem)
-				}
-				peer.device.PutOutboundElementsContainer(elemsContainer)
-			}
+		if len(elemsContainer.elems) == 0 {
+			peer.device.PutOutboundElementsContainer(elemsContainer)
+			goto top
+		}
 
-			if elemsContainerOOO != nil {
-				goto top
+		// add to parallel and sequential queue
+		if peer.isRunning.Load() {
+			peer.queue.outbound.c <- elemsContainer
+			peer.device.queueEncryption(peer, elemsContainer)
+		} else {
+			for _, elem := range elemsContainer.elems {
+				peer.device.PutMessageBuffer(elem.buffer)
+				peer.device.PutOutboundElement(elem)
 			}
-		default:
-			return
+			peer.device.PutOutboundElementsContainer(elemsContainer)
+		}
+
+		if elemsContainerOOO != nil {
+			goto top
 		}
 	}
 }
 
 func (peer *Peer) FlushStagedPackets() {
 	for {
-		select {
-		case elemsContainer := <-peer.queue.staged:
-			for _, elem := range elemsContainer.elems {
-				peer.device.PutMessageBuffer(elem.buffer)
-				peer.device.PutOutboundElement(elem)
-			}
-			peer.device.PutOutboundElementsContainer(elemsContainer)
-		default:
+		elemsContainer := peer.queue.staged.pop()
+		if elemsContainer == nil {
 			return
 		}
+		for _, elem := range elemsContainer.elems {
+			peer.device.PutMessageBuffer(elem.buffer)
+			peer.device.PutOutboundElement(elem)
+		}
+		peer.device.PutOutboundElementsContainer(elemsContainer)
 	}
 }
 
@@ -442,106 +501,210 @@ func calculatePaddingSize(packetSize, mtu int) int {
  * Obs. One instance per core
  */
 func (device *Device) RoutineEncryption(id int) {
-	var paddingZeros [PaddingMultiple]byte
-	var nonce [chacha20poly1305.NonceSize]byte
+	var scratch encryptionScratch
+
+	defer device.log.transport.Verbosef("Routine: encryption worker %d - stopped", id)
+	device.log.transport.Verbosef("Routine: encryption worker %d - started", id)
+	device.pipeline.enter(stageEncryption)
+	defer device.pipeline.exit(stageEncryption)
+
+	idle := time.NewTimer(WorkerIdleTimeout)
+	defer idle.Stop()
+	for {
+		elemsContainer, ok := nextWork(&device.workers.encryption, device.queue.encryption.c, idle)
+		if !ok {
+			return
+		}
+		device.encryptElems(elemsContainer, &scratch)
+		device.encryptionTaken()
+	}
+}
+
+// encryptionScratch is the memory an encryption worker reuses from one
+// batch to the next.
+type encryptionScratch struct {
+	paddingZeros [PaddingMultiple]byte
+	nonces       [][maxTransportNonceSize]byte
+	entries      []SealBatchEntry
+	elems        []*QueueOutboundElement // of entries
+}
 
-	defer device.log.Verbosef("Routine: encryption worker %d - stopped", id)
-	device.log.Verbosef("Routine: encryption worker %d - started", id)
+// sealBatch seals the entries collected in scratch with the send AEAD of
+// their keypair, and empties the batch.
+func (scratch *encryptionScratch) sealBatch(keypair *Keypair) {
+	if len(scratch.entries) == 0 {
+		return
+	}
+	SealBatch(keypair.send, scratch.entries)
+	for i, elem := range scratch.elems {
+		elem.packet = scratch.entries[i].Sealed
+	}
+	clear(scratch.entries)
+	clear(scratch.elems)
+	scratch.entries, scratch.elems = scratch.entries[:0], scratch.elems[:0]
+}
 
-	for elemsContainer := range device.queue.encryption.c {
+func (device *Device) encryptElems(elemsContainer *QueueOutboundElementsContainer, scratch *encryptionScratch) {
+	var current *QueueOutboundElement
+	defer elemsContainer.Unlock()
+	defer device.pipeline.done(stageEncryption, len(elemsContainer.elems), device.pipeline.begin())
+	defer func() {
+		if r := recover(); r != nil {
+			elemsContainer.dropped = true
+			scratch.entries, scratch.elems = scratch.entries[:0], scratch.elems[:0]
+			device.workerCrashed("encryption worker", r, current.peer)
+		}
+	}()
+	if len(scratch.nonces) < len(elemsContainer.elems) {
+		scratch.nonces = make([][maxTransportNonceSize]byte, len(elemsContainer.elems))
+	}
+	var keypair *Keypair
+	for i, elem := range elemsContainer.elems {
+		current = elem
+
+		// populate header fields
+		header := elem.buffer[:MessageTransportHeaderSize]
+
+		fieldType := header[0:4]
+		fieldReceiver := header[4:8]
+		fieldNonce := header[8:16]
+
+		binary.LittleEndian.PutUint32(fieldType, MessageTransportType)
+		binary.LittleEndian.PutUint32(fieldReceiver, elem.keypair.remoteIndex)
+		binary.LittleEndian.PutUint64(fieldNonce, elem.nonce)
+
+		// pad content to multiple of 16
+		paddingSize := calculatePaddingSize(len(elem.packet), int(device.tun.mtu.Load()))
+		elem.packet = append(elem.packet, scratch.paddingZeros[:paddingSize]...)
+
+		// encrypt content, in batches of the same keypair
+
+		if sealed, ok := device.sealPrecomputed(elem.keypair, header, elem.nonce, elem.packet); ok {
+			elem.packet = sealed
+			continue
+		}
+		if elem.keypair != keypair {
+			scratch.sealBatch(keypair)
+			keypair = elem.keypair
+		}
+		scratch.entries = append(scratch.entries, SealBatchEntry{
+			Dst:       header,
+			Nonce:     elem.keypair.transportNonce(&scratch.nonces[i], elem.nonce, true),
+			Plaintext: elem.packet,
+		})
+		scratch.elems = append(scratch.elems, elem)
+	}
+	scratch.sealBatch(keypair)
+
+	// release to consumer
+
+	if t := device.transcript.Load(); t != nil {
 		for _, elem := range elemsContainer.elems {
-			// populate header fields
-			header := elem.buffer[:MessageTransportHeaderSize]
-
-			fieldType := header[0:4]
-			fieldReceiver := header[4:8]
-			fieldNonce := header[8:16]
-
-			binary.LittleEndian.PutUint32(fieldType, MessageTransportType)
-			binary.LittleEndian.PutUint32(fieldReceiver, elem.keypair.remoteIndex)
-			binary.LittleEndian.PutUint64(fieldNonce, elem.nonce)
-
-			// pad content to multiple of 16
-			paddingSize := calculatePaddingSize(len(elem.packet), int(device.tun.mtu.Load()))
-			elem.packet = append(elem.packet, paddingZeros[:paddingSize]...)
-
-			// encrypt content and release to consumer
-
-			binary.LittleEndian.PutUint64(nonce[4:], elem.nonce)
-			elem.packet = elem.keypair.send.Seal(
-				header,
-				nonce[:],
-				elem.packet,
-				nil,
-			)
+			digest := blake2s.Sum256(elem.packet)
+			t.recordPacket(transcriptSend, elem.keypair.localIndex, elem.nonce, len(elem.packet), &digest)
 		}
-		elemsContainer.Unlock()
 	}
 }
 
 func (peer *Peer) RoutineSequentialSender(maxBatchSize int) {
 	device := peer.device
 	defer func() {
-		defer device.log.Verbosef("%v - Routine: sequential sender - stopped", peer)
+		defer device.log.transport.Verbosef("%v - Routine: sequential sender - stopped", peer)
 		peer.stopping.Done()
 	}()
-	device.log.Verbosef("%v - Routine: sequential sender - started", peer)
+	device.log.transport.Verbosef("%v - Routine: sequential sender - started", peer)
+	device.pipeline.enter(stageSend)
+	defer device.pipeline.exit(stageSend)
 
 	bufs := make([][]byte, 0, maxBatchSize)
 
 	for elemsContainer := range peer.queue.outbound.c {
-		bufs = bufs[:0]
 		if elemsContainer == nil {
 			return
 		}
-		if !peer.isRunning.Load() {
-			// peer has been stopped; return re-usable elems to the shared pool.
-			// This is an optimization only. It is possible for the peer to be stopped
-			// immediately after this check, in which case, elem will get processed.
-			// The timers and SendBuffers code are resilient to a few stragglers.
-			// TODO: rework peer shutdown order to ensure
-			// that we never accidentally keep timers alive longer than necessary.
-			elemsContainer.Lock()
-			for _, elem := range elemsContainer.elems {
-				device.PutMessageBuffer(elem.buffer)
-				device.PutOutboundElement(elem)
+		peer.sendElems(elemsContainer, bufs[:0])
+	}
+}
+
+func (peer *Peer) sendElems(elemsContainer *QueueOutboundElementsContainer, bufs [][]byte) {
+	device := peer.device
+	released := false
+	defer func() {
+		if r := recover(); r != nil {
+			if !released {
+				device.putOutboundElements(elemsContainer)
 			}
-			device.PutOutboundElementsContainer(elemsContainer)
+			device.workerCrashed("sequential sender", r, peer)
+		}
+	}()
+
+	elemsContainer.Lock()
+	defer device.pipeline.done(stageSend, len(elemsContainer.elems), device.pipeline.begin())
+	if !peer.isRunning.Load() || elemsContainer.dropped {
+		// peer has been stopped; return re-usable elems to the shared pool.
+		// This is an optimization only. It is possible for the peer to be stopped
+		// immediately after this check, in which case, elem will get processed.
+		// The timers and SendBuffers code are resilient to a few stragglers.
+		// TODO: rework peer shutdown order to ensure
+		// that we never accidentally keep timers alive longer than necessary.
+		// Batches dropped by a crashed encryption worker are returned as well.
+		released = true
+		device.putOutboundElements(elemsContainer)
+		return
+	}
+	dataSent := false
+	var now time.Time
+	if peer.maxQueueAge.Load() != 0 {
+		now = time.Now()
+	}
+	for _, elem := range elemsContainer.elems {
+		if peer.stale(elem.queued, now) {
+			device.tracePacket(true, PacketStale, peer, len(elem.packet), len(elem.packet)-elem.keypair.transportOverhead())
 			continue
 		}
-		dataSent := false
-		elemsContainer.Lock()
-		for _, elem := range elemsContainer.elems {
-			if len(elem.packet) != MessageKeepaliveSize {
-				dataSent = true
-			}
-			bufs = append(bufs, elem.packet)
+		if len(elem.packet) != elem.keypair.transportOverhead() {
+			dataSent = true
 		}
+		bufs = append(bufs, elem.packet)
+	}
+	if len(bufs) == 0 {
+		released = true
+		device.putOutboundElements(elemsContainer)
+		return
+	}
 
-		peer.timersAnyAuthenticatedPacketTraversal()
-		peer.timersAnyAuthenticatedPacketSent()
+	peer.timersAnyAuthenticatedPacketTraversal()
+	peer.timersAnyAuthenticatedPacketSent()
 
-		err := peer.SendBuffers(bufs)
-		if dataSent {
-			peer.timersDataSent()
-		}
-		for _, elem := range elemsContainer.elems {
-			device.PutMessageBuffer(elem.buffer)
-			device.PutOutboundElement(elem)
-		}
-		device.PutOutboundElementsContainer(elemsContainer)
+	err := peer.sendPaced(bufs, elemsContainer.endpoint, len(peer.queue.outbound.c) > 0)
+	if dataSent {
+		peer.timersDataSent()
+	}
+	if device.tracing() {
+		reason := PacketSent
 		if err != nil {
-			var errGSO conn.ErrUDPGSODisabled
-			if errors.As(err, &errGSO) {
-				device.log.Verbosef(err.Error())
-				err = errGSO.RetryErr
+			reason = PacketSendFailed
+		}
+		overhead := elemsContainer.elems[0].keypair.transportOverhead()
+		for _, buf := range bufs {
+			if len(buf) != overhead {
+				device.tracePacket(true, reason, peer, len(buf), len(buf)-overhead)
 			}
 		}
-		if err != nil {
-			device.log.Errorf("%v - Failed to send data packets: %v", peer, err)
-			continue
+	}
+	released = true
+	device.putOutboundElements(elemsContainer)
+	if err != nil {
+		var errGSO conn.ErrUDPGSODisabled
+		if errors.As(err, &errGSO) {
+			device.log.conn.Verbosef("%v", err)
+			err = errGSO.RetryErr
 		}
-
-		peer.keepKeyFreshSending()
 	}
+	if err != nil {
+		device.log.transport.Errorf("%v - Failed to send data packets: %v", peer, err)
+		return
+	}
+
+	peer.keepKeyFreshSending()
 }
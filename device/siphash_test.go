@@ -0,0 +1,37 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func TestSipHash128(t *testing.T) {
+	// Vectors of the 128-bit reference implementation, under the key 00..0f
+	// and messages 00..n-1.
+	key := make([]byte, sipHashKeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	for n, want := range map[int]string{
+		0:  "a3817f04ba25a8e66df67214c7550293",
+		1:  "da87c1d86b99af44347659119b22fc45",
+		8:  "3b62a9ba6258f5610f83e264f31497b4",
+		15: "5493e99933b0a8117e08ec0f97cfc3d9",
+		63: "5150d1772f50834a503e069a973fbd7c",
+	} {
+		msg := make([]byte, n)
+		for i := range msg {
+			msg[i] = byte(i)
+		}
+		var out [sipHashSize128]byte
+		sipHash128(&out, key, msg)
+		if got := hex.EncodeToString(out[:]); got != want {
+			t.Errorf("length %d: got %s, want %s", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+/* Staged packet eviction
+ *
+ * Packets to a peer wait in its staged queue until a session is available
+ * to encrypt them with. When staging more packets than the queue holds, its
+ * eviction policy picks the ones dropped: the oldest, favoring fresh data,
+ * which is the default; the newest, favoring what was queued first; or the
+ * ones of lowest priority by their DSCP, oldest first, so that real-time
+ * traffic survives a burst of bulk traffic. Each peer counts the packets
+ * dropped by each policy.
+ */
+
+// A StagedEvictionPolicy selects which packets a full staged queue drops.
+type StagedEvictionPolicy int
+
+const (
+	EvictOldest         StagedEvictionPolicy = iota // drop the packets queued first
+	EvictNewest                                     // drop the packets being staged
+	EvictLowestPriority                             // drop the packets with the lowest DSCP priority, oldest first
+	numEvictionPolicies
+)
+
+var stagedEvictionPolicyNames = [numEvictionPolicies]string{"oldest", "newest", "priority"}
+
+func (p StagedEvictionPolicy) String() string {
+	if p >= 0 && p < numEvictionPolicies {
+		return stagedEvictionPolicyNames[p]
+	}
+	return fmt.Sprintf("StagedEvictionPolicy(%d)", int(p))
+}
+
+// ParseStagedEvictionPolicy returns the policy named s, as printed by
+// StagedEvictionPolicy.String.
+func ParseStagedEvictionPolicy(s string) (StagedEvictionPolicy, error) {
+	for p, name := range stagedEvictionPolicyNames {
+		if s == name {
+			return StagedEvictionPolicy(p), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown staged eviction policy %q", s)
+}
+
+type stagedQueue struct {
+	mu         sync.Mutex
+	containers []*QueueOutboundElementsContainer
+	packets    atomic.Int32 // packets in containers, readable without the mutex
+	size       atomic.Int32 // if nonzero, overrides the QueueStagedSize limit of the device
+	policy     atomic.Int32 // StagedEvictionPolicy
+	drops      [numEvictionPolicies]atomic.Uint64
+}
+
+// len returns the number of staged packets.
+func (q *stagedQueue) len() int {
+	return int(q.packets.Load())
+}
+
+// pop removes and returns the container staged first, or nil if there is
+// none.
+func (q *stagedQueue) pop() *QueueOutboundElementsContainer {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.containers) == 0 {
+		return nil
+	}
+	elems := q.containers[0]
+	q.containers[0] = nil
+	q.containers = q.containers[1:]
+	q.packets.Add(-int32(len(elems.elems)))
+	return elems
+}
+
+// SetStagedQueue sets how many packets may be staged for the peer while
+// awaiting a session, zero selecting the QueueStagedSize of the device's
+// Limits, and which are dropped when staging more.
+func (peer *Peer) SetStagedQueue(size int, policy StagedEvictionPolicy) error {
+	if size < 0 || size > 1<<16 {
+		return fmt.Errorf("staged queue size %d out of range", size)
+	}
+	if policy < 0 || policy >= numEvictionPolicies {
+		return fmt.Errorf("invalid staged eviction policy %d", int(policy))
+	}
+	peer.queue.staged.size.Store(int32(size))
+	peer.queue.staged.policy.Store(int32(policy))
+	return nil
+}
+
+// StagedQueue returns the size and policy set with SetStagedQueue.
+func (peer *Peer) StagedQueue() (size int, policy StagedEvictionPolicy) {
+	return int(peer.queue.staged.size.Load()), StagedEvictionPolicy(peer.queue.staged.policy.Load())
+}
+
+// StagedDrops returns the number of staged packets dropped by policy.
+func (peer *Peer) StagedDrops(policy StagedEvictionPolicy) uint64 {
+	if policy < 0 || policy >= numEvictionPolicies {
+		return 0
+	}
+	return peer.queue.staged.drops[policy].Load()
+}
+
+// StagePackets queues elems for encryption once a session is available,
+// evicting packets by the peer's policy if the staged queue is full.
+func (peer *Peer) StagePackets(elems *QueueOutboundElementsContainer) {
+	q := &peer.queue.staged
+	limit := int(q.size.Load())
+	if limit == 0 {
+		limit = peer.device.limits.QueueStagedSize
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.containers = append(q.containers, elems)
+	q.packets.Add(int32(len(elems.elems)))
+	if excess := q.len() - limit; excess > 0 {
+		q.evictLocked(peer.device, excess)
+	}
+}
+
+// evictLocked drops excess staged packets by the queue's policy.
+func (q *stagedQueue) evictLocked(device *Device, excess int) {
+	type staged struct {
+		elem     **QueueOutboundElement
+		priority int
+	}
+	all := make([]staged, 0, q.len())
+	for _, elems := range q.containers {
+		for i := range elems.elems {
+			all = append(all, staged{&elems.elems[i], 0})
+		}
+	}
+	policy := StagedEvictionPolicy(q.policy.Load())
+	var victims []staged
+	switch policy {
+	case EvictOldest:
+		victims = all[:excess]
+	case EvictNewest:
+		victims = all[len(all)-excess:]
+	case EvictLowestPriority:
+		for i := range all {
+			all[i].priority = stagedPriority((*all[i].elem).packet)
+		}
+		for priority := stagedPriorityLowest; priority <= stagedPriorityHighest && len(victims) < excess; priority++ {
+			for _, s := range all {
+				if s.priority == priority {
+					victims = append(victims, s)
+					if len(victims) == excess {
+						break
+					}
+				}
+			}
+		}
+	}
+	for _, s := range victims {
+		device.PutMessageBuffer((*s.elem).buffer)
+		device.PutOutboundElement(*s.elem)
+		*s.elem = nil
+	}
+	q.drops[policy].Add(uint64(len(victims)))
+	q.packets.Add(-int32(len(victims)))
+
+	kept := q.containers[:0]
+	for _, elems := range q.containers {
+		i := 0
+		for _, elem := range elems.elems {
+			if elem != nil {
+				elems.elems[i] = elem
+				i++
+			}
+		}
+		clear(elems.elems[i:])
+		elems.elems = elems.elems[:i]
+		if i == 0 {
+			device.PutOutboundElementsContainer(elems)
+			continue
+		}
+		kept = append(kept, elems)
+	}
+	clear(q.containers[len(kept):])
+	q.containers = kept
+}
+
+const (
+	stagedPriorityLowest  = -1 // lower effort and CS1
+	stagedPriorityHighest = 8  // keepalives and control messages
+)
+
+// stagedPriority returns the eviction priority of a staged packet: the class
+// selector of its DSCP, except for lower effort (LE) and CS1 traffic, which
+// rank below the default class, and keepalives and control messages, which
+// rank above all.
+func stagedPriority(packet []byte) int {
+	if len(packet) < 2 {
+		return stagedPriorityHighest
+	}
+	var tos byte
+	switch packet[0] >> 4 {
+	case 4:
+		tos = packet[1]
+	case 6:
+		tos = packet[0]<<4 | packet[1]>>4
+	default:
+		return stagedPriorityHighest
+	}
+	dscp := tos >> 2
+	if dscp == 1 || dscp == 8 {
+		return stagedPriorityLowest
+	}
+	return int(dscp >> 3)
+}
@@ -0,0 +1,97 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/hex"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStagedEviction(t *testing.T) {
+	const (
+		be  = 0
+		cs1 = 8
+		ef  = 46
+	)
+	stage := func(peer *Peer, dscps ...byte) {
+		elems := peer.device.GetOutboundElementsContainer()
+		for _, dscp := range dscps {
+			elem := peer.device.NewOutboundElement()
+			elem.packet = elem.buffer[MessageTransportHeaderSize : MessageTransportHeaderSize+20]
+			elem.packet[0], elem.packet[1] = 0x45, dscp<<2
+			elems.elems = append(elems.elems, elem)
+		}
+		peer.StagePackets(elems)
+	}
+	staged := func(peer *Peer) (dscps []byte) {
+		for elems := peer.queue.staged.pop(); elems != nil; elems = peer.queue.staged.pop() {
+			for _, elem := range elems.elems {
+				dscps = append(dscps, elem.packet[1]>>2)
+			}
+		}
+		return
+	}
+
+	for _, tt := range []struct {
+		policy StagedEvictionPolicy
+		want   []byte
+	}{
+		{EvictOldest, []byte{ef, cs1, be, ef}},
+		{EvictNewest, []byte{be, ef, cs1, be}},
+		{EvictLowestPriority, []byte{be, ef, be, ef}},
+	} {
+		dev := randDevice(t)
+		sk, err := newPrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pk := sk.publicKey()
+		if err := dev.IpcSet(uapiCfg("public_key", hex.EncodeToString(pk[:]), "staged_queue_size", "4", "staged_queue_policy", tt.policy.String())); err != nil {
+			t.Fatal(err)
+		}
+		peer := dev.LookupPeer(pk)
+		stage(peer, be, ef)
+		stage(peer, cs1, be)
+		stage(peer, ef)
+		if n := peer.StagedDrops(tt.policy); n != 1 {
+			t.Errorf("%v: %d drops counted, want 1", tt.policy, n)
+		}
+		cfg, err := dev.IpcGet()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "staged_queue_size=4\nstaged_queue_policy=" + tt.policy.String() + "\nstaged_dropped_" + tt.policy.String() + "=1\n"; !strings.Contains(cfg, want) {
+			t.Errorf("get output lacks %q:\n%s", want, cfg)
+		}
+		if got := staged(peer); !slices.Equal(got, tt.want) {
+			t.Errorf("%v: staged DSCPs = %v, want %v", tt.policy, got, tt.want)
+		}
+		if n := peer.queue.staged.len(); n != 0 {
+			t.Errorf("%v: %d packets counted after draining", tt.policy, n)
+		}
+		dev.Close()
+	}
+}
+
+func TestStagedPriority(t *testing.T) {
+	for _, tt := range []struct {
+		packet []byte
+		want   int
+	}{
+		{nil, stagedPriorityHighest},
+		{[]byte{ControlEchoRequestType, 0}, stagedPriorityHighest},
+		{[]byte{0x45, 46 << 2}, 5},
+		{[]byte{0x45, 1 << 2}, stagedPriorityLowest},
+		{[]byte{0x60 | 46>>2, (46 << 2 & 0xf) << 4}, 5},
+		{[]byte{0x60, 0}, 0},
+	} {
+		if got := stagedPriority(tt.packet); got != tt.want {
+			t.Errorf("stagedPriority(%x) = %d, want %d", tt.packet, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.zx2c4.com/wireguard/tai64n"
+)
+
+/* Persistent state
+ *
+ * Some of a device's state is worth keeping across restarts, or sharing
+ * between instances serving the same keys. A device with a StateStore puts
+ * such state to it as records, each of a kind and a peer, reads a peer's
+ * records back when the peer is added, and watches the store for records
+ * put by others. Embedders can back the store with their own database; the
+ * package provides one in memory and one on the file system.
+ *
+ * The device persists the timestamp of the last handshake initiation it
+ * accepted from each peer, so that initiations recorded before a restart
+ * cannot be replayed after it. The record is put on every initiation
+ * accepted, which is at most every HandshakeInitationRate per peer, and a
+ * failure to put it is logged and otherwise ignored.
+ */
+
+// A StateKind names a kind of state record.
+type StateKind string
+
+const (
+	StateHandshakeTimestamp StateKind = "handshake_timestamp" // TAI64N timestamp of the last initiation accepted from the peer
+)
+
+// A StateRecord is an item of a device's persistent state, concerning a peer.
+type StateRecord struct {
+	Kind  StateKind
+	Peer  NoisePublicKey
+	Value []byte
+}
+
+// A StateStore stores state records, one per kind and peer. Its methods may
+// be called concurrently.
+type StateStore interface {
+	// Put stores record, replacing any of the same kind and peer.
+	Put(record StateRecord) error
+	// Get returns the value of the record of kind and peer, and whether
+	// there is one.
+	Get(kind StateKind, peer NoisePublicKey) (value []byte, ok bool, err error)
+	// Watch calls fn with each record of kind put from now on, until stop is
+	// called. Calls to fn are not concurrent with each other, and fn must
+	// not call into the store.
+	Watch(kind StateKind, fn func(StateRecord)) (stop func())
+}
+
+// stateWatchers implements StateStore.Watch for the stores of this package.
+type stateWatchers struct {
+	sync.Mutex
+	next     int
+	watchers map[int]stateWatcher
+}
+
+type stateWatcher struct {
+	kind StateKind
+	fn   func(StateRecord)
+}
+
+func (w *stateWatchers) Watch(kind StateKind, fn func(StateRecord)) (stop func()) {
+	w.Lock()
+	defer w.Unlock()
+	if w.watchers == nil {
+		w.watchers = make(map[int]stateWatcher)
+	}
+	id := w.next
+	w.next++
+	w.watchers[id] = stateWatcher{kind, fn}
+	return func() {
+		w.Lock()
+		defer w.Unlock()
+		delete(w.watchers, id)
+	}
+}
+
+// notifyLocked calls the watchers of the kind of record.
+func (w *stateWatchers) notifyLocked(record StateRecord) {
+	for _, watcher := range w.watchers {
+		if watcher.kind == record.Kind {
+			watcher.fn(record)
+		}
+	}
+}
+
+type stateKey struct {
+	kind StateKind
+	peer NoisePublicKey
+}
+
+// A MemoryStateStore is a StateStore in memory.
+type MemoryStateStore struct {
+	stateWatchers
+	records map[stateKey][]byte
+}
+
+// NewMemoryStateStore returns an empty MemoryStateStore.
+func NewMemoryStateStore() *MemoryStateStore {
+	return &MemoryStateStore{records: make(map[stateKey][]byte)}
+}
+
+func (s *MemoryStateStore) Put(record StateRecord) error {
+	record.Value = append([]byte(nil), record.Value...)
+	s.Lock()
+	defer s.Unlock()
+	s.records[stateKey{record.Kind, record.Peer}] = record.Value
+	s.notifyLocked(record)
+	return nil
+}
+
+func (s *MemoryStateStore) Get(kind StateKind, peer NoisePublicKey) ([]byte, bool, error) {
+	s.Lock()
+	defer s.Unlock()
+	value, ok := s.records[stateKey{kind, peer}]
+	return append([]byte(nil), value...), ok, nil
+}
+
+// A FileStateStore is a StateStore keeping each record in a file of its own,
+// named by the peer's hex public key, in a directory named by its kind.
+// Watchers only see records put with the same FileStateStore.
+type FileStateStore struct {
+	stateWatchers
+	dir string
+}
+
+// NewFileStateStore returns a FileStateStore keeping its records under dir,
+// which is created if missing.
+func NewFileStateStore(dir string) (*FileStateStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStateStore{dir: dir}, nil
+}
+
+func (s *FileStateStore) path(kind StateKind, peer NoisePublicKey) (string, error) {
+	if kind == "" {
+		return "", errors.New("empty state kind")
+	}
+	for _, c := range kind {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '_' {
+			return "", fmt.Errorf("invalid state kind %q", kind)
+		}
+	}
+	return filepath.Join(s.dir, string(kind), hex.EncodeToString(peer[:])), nil
+}
+
+func (s *FileStateStore) Put(record StateRecord) error {
+	path, err := s.path(record.Kind, record.Peer)
+	if err != nil {
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	// Replace the file by renaming, so that it is never seen half written.
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(record.Value)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	s.notifyLocked(record)
+	return nil
+}
+
+func (s *FileStateStore) Get(kind StateKind, peer NoisePublicKey) ([]byte, bool, error) {
+	path, err := s.path(kind, peer)
+	if err != nil {
+		return nil, false, err
+	}
+	value, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// SetStateStore sets the store the device keeps its persistent state in,
+// or none if store is nil. Peers added from then on get their state from
+// it.
+func (device *Device) SetStateStore(store StateStore) {
+	device.stateStore.Lock()
+	defer device.stateStore.Unlock()
+	if device.stateStore.stopWatch != nil {
+		device.stateStore.stopWatch()
+		device.stateStore.stopWatch = nil
+	}
+	if store == nil {
+		device.stateStore.store.Store(nil)
+		return
+	}
+	device.stateStore.store.Store(&store)
+	device.stateStore.stopWatch = store.Watch(StateHandshakeTimestamp, device.watchHandshakeTimestamp)
+}
+
+// StateStore returns the store set with SetStateStore, or nil.
+func (device *Device) StateStore() StateStore {
+	if store := device.stateStore.store.Load(); store != nil {
+		return *store
+	}
+	return nil
+}
+
+// loadHandshakeTimestamp returns the timestamp of the last initiation
+// accepted from the peer with public key pk, as stored in the device's
+// state store, or the zero timestamp.
+func (device *Device) loadHandshakeTimestamp(pk NoisePublicKey) (timestamp tai64n.Timestamp) {
+	store := device.StateStore()
+	if store == nil {
+		return
+	}
+	value, ok, err := store.Get(StateHandshakeTimestamp, pk)
+	if err != nil {
+		device.log.handshake.Errorf("Failed to load handshake timestamp: %v", err)
+		return
+	}
+	if ok && len(value) == len(timestamp) {
+		copy(timestamp[:], value)
+	}
+	return
+}
+
+// storeHandshakeTimestamp puts the timestamp of an initiation accepted from
+// peer to the device's state store.
+func (device *Device) storeHandshakeTimestamp(peer *Peer, timestamp tai64n.Timestamp) {
+	store := device.StateStore()
+	if store == nil {
+		return
+	}
+	record := StateRecord{Kind: StateHandshakeTimestamp, Peer: peer.handshake.remoteStatic, Value: timestamp[:]}
+	if err := store.Put(record); err != nil {
+		device.log.handshake.Errorf("%v - Failed to store handshake timestamp: %v", peer, err)
+	}
+}
+
+// watchHandshakeTimestamp raises the last accepted timestamp of the peer of
+// record to that of record.
+func (device *Device) watchHandshakeTimestamp(record StateRecord) {
+	var timestamp tai64n.Timestamp
+	if len(record.Value) != len(timestamp) {
+		return
+	}
+	copy(timestamp[:], record.Value)
+	peer := device.LookupPeer(record.Peer)
+	if peer == nil {
+		return
+	}
+	peer.handshake.mutex.Lock()
+	if timestamp.After(peer.handshake.lastTimestamp) {
+		peer.handshake.lastTimestamp = timestamp
+	}
+	peer.handshake.mutex.Unlock()
+}
@@ -0,0 +1,108 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/tai64n"
+)
+
+func TestStateStores(t *testing.T) {
+	files, err := NewFileStateStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, store := range map[string]StateStore{
+		"memory": NewMemoryStateStore(),
+		"file":   files,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var peer NoisePublicKey
+			peer[0] = 1
+			if _, ok, err := store.Get(StateHandshakeTimestamp, peer); ok || err != nil {
+				t.Fatalf("get of missing record = %v, %v", ok, err)
+			}
+
+			var watched []StateRecord
+			stop := store.Watch(StateHandshakeTimestamp, func(record StateRecord) {
+				watched = append(watched, record)
+			})
+			for _, value := range []string{"first", "second"} {
+				if err := store.Put(StateRecord{StateHandshakeTimestamp, peer, []byte(value)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := store.Put(StateRecord{"other_kind", peer, []byte("other")}); err != nil {
+				t.Fatal(err)
+			}
+			stop()
+			if err := store.Put(StateRecord{StateHandshakeTimestamp, peer, []byte("third")}); err != nil {
+				t.Fatal(err)
+			}
+			if len(watched) != 2 || string(watched[1].Value) != "second" {
+				t.Errorf("watched %v", watched)
+			}
+
+			value, ok, err := store.Get(StateHandshakeTimestamp, peer)
+			if !ok || err != nil || string(value) != "third" {
+				t.Errorf("get = %q, %v, %v", value, ok, err)
+			}
+			if value, _, _ := store.Get("other_kind", peer); string(value) != "other" {
+				t.Errorf("get of other kind = %q", value)
+			}
+		})
+	}
+	if err := files.Put(StateRecord{Kind: "../escape"}); err == nil {
+		t.Error("file store accepted invalid kind")
+	}
+}
+
+func TestStateStoreHandshakeTimestamp(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	store := NewMemoryStateStore()
+	dev := pair[0].dev
+	dev.SetStateStore(store)
+	pk := pair[1].dev.staticIdentity.publicKey
+
+	// The responder stores the timestamp of the initiation it accepts.
+	pair.Send(t, Ping, nil)
+	peer := dev.LookupPeer(pk)
+	peer.handshake.mutex.RLock()
+	accepted := peer.handshake.lastTimestamp
+	peer.handshake.mutex.RUnlock()
+	value, ok, _ := store.Get(StateHandshakeTimestamp, pk)
+	if !ok || !bytes.Equal(value, accepted[:]) {
+		t.Fatalf("stored timestamp %x, accepted %x", value, accepted)
+	}
+
+	// A timestamp put by another instance raises the peer's.
+	later := tai64n.Now()
+	binary.BigEndian.PutUint64(later[:8], binary.BigEndian.Uint64(later[:8])+3600)
+	store.Put(StateRecord{StateHandshakeTimestamp, pk, later[:]})
+	peer.handshake.mutex.RLock()
+	raised := peer.handshake.lastTimestamp
+	peer.handshake.mutex.RUnlock()
+	if raised != later {
+		t.Errorf("watched timestamp not applied: %x", raised)
+	}
+
+	// A peer added anew gets its timestamp back.
+	if err := dev.IpcSet(uapiCfg("public_key", hex.EncodeToString(pk[:]), "remove", "true")); err != nil {
+		t.Fatal(err)
+	}
+	if err := dev.IpcSet(uapiCfg("public_key", hex.EncodeToString(pk[:]))); err != nil {
+		t.Fatal(err)
+	}
+	peer = dev.LookupPeer(pk)
+	if peer.handshake.lastTimestamp != later {
+		t.Errorf("restored timestamp %x, want %x", peer.handshake.lastTimestamp, later)
+	}
+}
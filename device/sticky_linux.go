@@ -84,7 +84,7 @@ func (device *Device) routineRouteListener(_ conn.Bind, netlinkSock int, netlink
 
 			switch hdr.Type {
 			case unix.RTM_NEWROUTE, unix.RTM_DELROUTE:
-				if hdr.Seq <= MaxPeers && hdr.Seq > 0 {
+				if hdr.Seq <= uint32(device.limits.MaxPeers) && hdr.Seq > 0 {
 					if uint(len(remain)) < uint(hdr.Len) {
 						break
 					}
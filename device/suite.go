@@ -0,0 +1,327 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/poly1305"
+)
+
+/* Transport data is encrypted with the cipher suite of its session. Each
+ * keypair holds its own AEAD instances, created with the suite its peer was
+ * pinned to when the handshake completed, so peers of one device can use
+ * different suites: standard ChaCha20-Poly1305 for interoperable peers and
+ * an experimental suite for research peers. Peers not pinned to a suite use
+ * the device's, standard ChaCha20-Poly1305 unless set otherwise. Both ends
+ * of a session must use the same suite, or its packets fail to authenticate.
+ * The AES256GCM suite, in every build, runs on the AES and carry-less
+ * multiplication instructions where the CPU has them. Builds with the wg_sm4
+ * and wg_gost tags register the SM4GCM and KuznyechikMGM suites for users
+ * bound to national algorithms, and builds with the wg_experimental tag the
+ * ChaCha20x24Poly1795 and ChaCha20DoublePoly1305 suites of the modified
+ * primitives, which other builds contain none of. The XChaCha20Poly1305
+ * suite, in every build, is there to compare large-nonce designs against
+ * that experiment. Suites may add tags shorter than 16 bytes, trading
+ * integrity for bandwidth, and declare the security level that leaves them
+ * with.
+ *
+ * The data plane seals and opens transport data through the AEADSuite
+ * interface, building each nonce from the counter of the message in the
+ * layout the suite's nonce size calls for: the 12-byte nonce of standard
+ * WireGuard, or the 16-byte one of the ChaCha20_24 experiment, laid out as
+ * described in chacha20_nonce.go. Encryption workers seal the packets of a
+ * batch with one SealBatch call, which suites implementing BatchAEADSuite
+ * take in one go and others one packet at a time. Suites may also add the
+ * 24-byte tags of Poly1795 or the 32-byte ones of DoublePoly1305, which take
+ * 8 or 16 bytes more of each datagram than standard ones. MaxTunnelMTU
+ * counts the tag of the device's suite, and a device warns when its MTU
+ * leaves no room for it on an Ethernet path.
+ */
+
+// StandardCipherSuite is the name of the ChaCha20-Poly1305 suite of standard
+// WireGuard, which peers use unless pinned to another suite.
+const StandardCipherSuite = "ChaCha20Poly1305"
+
+// An AEADSuite seals and opens the transport data of a session. Every
+// cipher.AEAD is one; the interface names what the data plane relies on.
+type AEADSuite interface {
+	NonceSize() int // chacha20poly1305.NonceSize or chachaNonceSize
+	Overhead() int  // bytes of authentication tag
+	Seal(dst, nonce, plaintext, additionalData []byte) []byte
+	Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error)
+}
+
+// A SealBatchEntry is one message to seal in a batch.
+type SealBatchEntry struct {
+	Dst            []byte // the sealed message is appended to it, as by Seal
+	Nonce          []byte
+	Plaintext      []byte
+	AdditionalData []byte
+	Sealed         []byte // set by SealBatch
+}
+
+// A BatchAEADSuite is an AEADSuite that seals many messages in one call,
+// such as an assembly backend that sets up its key once and interleaves
+// the keystream and MACs of several messages. SealBatch sets the Sealed
+// field of every entry to what Seal would return for it.
+type BatchAEADSuite interface {
+	AEADSuite
+	SealBatch(entries []SealBatchEntry)
+}
+
+// SealBatch seals every entry with aead: in one call, if it is a
+// BatchAEADSuite, or else one entry at a time.
+func SealBatch(aead AEADSuite, entries []SealBatchEntry) {
+	if batch, ok := aead.(BatchAEADSuite); ok {
+		batch.SealBatch(entries)
+		return
+	}
+	for i := range entries {
+		e := &entries[i]
+		e.Sealed = aead.Seal(e.Dst, e.Nonce, e.Plaintext, e.AdditionalData)
+	}
+}
+
+// A CipherSuite is an AEAD that transport data can be encrypted with. Its
+// instances must take 12-byte nonces, like ChaCha20-Poly1305, or 16-byte
+// ones, like ChaCha20_24, and add a tag of TagSize bytes, which is 32, 24,
+// 16, 12 or 8.
+type CipherSuite struct {
+	Name         string
+	Experimental bool                                // not part of standard WireGuard
+	New          func(key []byte) (AEADSuite, error) // key is chacha20poly1305.KeySize bytes
+	TagSize      int                                 // bytes of authentication tag, poly1305.TagSize if zero
+	SecurityBits int                                 // forging a packet takes about 2^SecurityBits tries, eight times TagSize if zero
+	SelfTest     func(aead AEADSuite) error          // known-answer test of an AEAD of the suite, see RunCryptoSelfTest; none if nil
+
+	// NewRounds is New with the number of ChaCha rounds of the suite's
+	// cipher in place of its own, for suites, such as ChaCha20x24Poly1795,
+	// whose round count the ChaCha rounds experiment can vary; nil for
+	// others. See Device.SetChaChaRounds.
+	NewRounds func(key []byte, rounds int) (AEADSuite, error)
+}
+
+// newAEADSuite adapts an AEAD constructor, such as chacha20poly1305.New, to
+// the New field of a CipherSuite.
+func newAEADSuite(new func(key []byte) (cipher.AEAD, error)) func(key []byte) (AEADSuite, error) {
+	return func(key []byte) (AEADSuite, error) {
+		return new(key)
+	}
+}
+
+// Overhead returns the size of the tag the suite adds to transport data.
+func (suite *CipherSuite) Overhead() int {
+	if suite.TagSize == 0 {
+		return poly1305.TagSize
+	}
+	return suite.TagSize
+}
+
+// Security returns the integrity level of the suite in bits.
+func (suite *CipherSuite) Security() int {
+	if suite.SecurityBits == 0 {
+		return 8 * suite.Overhead()
+	}
+	return suite.SecurityBits
+}
+
+var cipherSuites = struct {
+	sync.RWMutex
+	m map[string]*CipherSuite
+}{m: map[string]*CipherSuite{
+	StandardCipherSuite: {
+		Name:         StandardCipherSuite,
+		New:          newAEADSuite(chacha20poly1305.New),
+		SecurityBits: poly1305SecurityBits,
+		SelfTest:     aeadSelfTest("de821c71c2592e12653eb1d52e82eb24612e2f9c4911bec5545819712ab36f96"),
+	},
+}}
+
+// RegisterCipherSuite makes suite available for pinning peers to. It panics
+// if the name is taken or the AEAD does not fit transport messages, so that
+// it can be called from init functions.
+func RegisterCipherSuite(suite CipherSuite) {
+	if suite.Name == "" || suite.New == nil {
+		panic("device: invalid cipher suite")
+	}
+	aead, err := suite.New(make([]byte, chacha20poly1305.KeySize))
+	if err != nil {
+		panic(fmt.Sprintf("device: cipher suite %s: %v", suite.Name, err))
+	}
+	if size := suite.Overhead(); size != MaxTagSize && size != 24 && size != 16 && size != 12 && size != MinTagSize {
+		panic(fmt.Sprintf("device: cipher suite %s has a %d-byte tag", suite.Name, size))
+	}
+	if size := aead.NonceSize(); (size != chacha20poly1305.NonceSize && size != chachaNonceSize) || aead.Overhead() != suite.Overhead() {
+		panic(fmt.Sprintf("device: cipher suite %s has a %d-byte nonce and %d-byte overhead", suite.Name, aead.NonceSize(), aead.Overhead()))
+	}
+
+	cipherSuites.Lock()
+	defer cipherSuites.Unlock()
+	if _, ok := cipherSuites.m[suite.Name]; ok {
+		panic("device: cipher suite " + suite.Name + " registered twice")
+	}
+	cipherSuites.m[suite.Name] = &suite
+}
+
+// taggedCipherSuites maps the names of the suites only builds with a tag
+// register to that tag, so that builds without it can tell why a name is
+// unknown.
+var taggedCipherSuites = map[string]string{
+	"ChaCha20DoublePoly1305": "wg_experimental",
+	"ChaCha20x24Poly1795":    "wg_experimental",
+	"KuznyechikMGM":          "wg_gost",
+	"SM4GCM":                 "wg_sm4",
+}
+
+// unknownCipherSuiteError returns the error of a name no suite is registered
+// with.
+func unknownCipherSuiteError(name string) error {
+	if tag, ok := taggedCipherSuites[name]; ok {
+		return fmt.Errorf("%w %q: built without the %s tag", ErrUnknownCipherSuite, name, tag)
+	}
+	return fmt.Errorf("%w %q", ErrUnknownCipherSuite, name)
+}
+
+// LookupCipherSuite returns the registered suite with the given name, or nil.
+func LookupCipherSuite(name string) *CipherSuite {
+	cipherSuites.RLock()
+	defer cipherSuites.RUnlock()
+	return cipherSuites.m[name]
+}
+
+// CipherSuites returns the names of all registered suites, sorted.
+func CipherSuites() []string {
+	cipherSuites.RLock()
+	defer cipherSuites.RUnlock()
+	names := make([]string, 0, len(cipherSuites.m))
+	for name := range cipherSuites.m {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// cipherSuite returns the suite of the device's peers not pinned to one.
+func (device *Device) cipherSuite() *CipherSuite {
+	if suite := device.suite.Load(); suite != nil {
+		return suite
+	}
+	return LookupCipherSuite(StandardCipherSuite)
+}
+
+// CipherSuite returns the name of the suite of the device's peers not pinned
+// to one.
+func (device *Device) CipherSuite() string {
+	return device.cipherSuite().Name
+}
+
+// SetCipherSuite sets the suite of the device's peers not pinned to one. The
+// current keypairs of those peers are expired if that changes their suite,
+// so that their next handshakes start sessions with it.
+func (device *Device) SetCipherSuite(name string) error {
+	suite := LookupCipherSuite(name)
+	if suite == nil {
+		return unknownCipherSuiteError(name)
+	}
+	if old := device.cipherSuite(); old == suite {
+		return nil
+	}
+	if err := suite.selfTest(); err != nil {
+		return err
+	}
+	device.peers.RLock()
+	defer device.peers.RUnlock()
+	device.suite.Store(suite)
+	for _, peer := range device.peers.keyMap {
+		if peer.suite.Load() == nil {
+			peer.ExpireCurrentKeypairs()
+		}
+	}
+	device.checkOverhead(int(device.tun.mtu.Load()))
+	return nil
+}
+
+// cipherSuite returns the suite the peer is pinned to, or the device's if it
+// is not pinned to one.
+func (peer *Peer) cipherSuite() *CipherSuite {
+	if suite := peer.suite.Load(); suite != nil {
+		return suite
+	}
+	return peer.device.cipherSuite()
+}
+
+// CipherSuite returns the name of the suite of the peer's new sessions.
+func (peer *Peer) CipherSuite() string {
+	return peer.cipherSuite().Name
+}
+
+// SetCipherSuite pins the peer to the named suite. If that changes the suite,
+// the current keypairs are expired, so that the next handshake starts a
+// session with it.
+func (peer *Peer) SetCipherSuite(name string) error {
+	suite := LookupCipherSuite(name)
+	if suite == nil {
+		return unknownCipherSuiteError(name)
+	}
+	if err := suite.selfTest(); err != nil {
+		return err
+	}
+	old := peer.cipherSuite()
+	peer.suite.Store(suite)
+	if old == suite {
+		return nil
+	}
+	peer.ExpireCurrentKeypairs()
+	return nil
+}
+
+// ActiveCipherSuite returns the name of the suite of the peer's current
+// session, or "" if there is none.
+func (peer *Peer) ActiveCipherSuite() string {
+	if keypair := peer.keypairs.Current(); keypair != nil {
+		return keypair.suite.Name
+	}
+	return ""
+}
+
+// transportOverhead returns the size of a transport message sent with the
+// keypair beyond its padded content.
+func (keypair *Keypair) transportOverhead() int {
+	return MessageTransportHeaderSize + keypair.suite.Overhead()
+}
+
+// maxTransportNonceSize is the size of the longest nonce an AEADSuite may
+// take.
+const maxTransportNonceSize = chachaNonceSize
+
+// transportNonce builds in nonce the size-byte nonce of the transport message
+// with the given counter and receiver index, sent by the initiator of its
+// session if fromInitiator, and returns it.
+func transportNonce(nonce *[maxTransportNonceSize]byte, size int, counter uint64, receiverIndex uint32, fromInitiator bool) []byte {
+	if size == chachaNonceSize {
+		*nonce = chacha24TransportNonce(counter, receiverIndex, fromInitiator)
+		return nonce[:]
+	}
+	clear(nonce[:4])
+	binary.LittleEndian.PutUint64(nonce[4:], counter)
+	return nonce[:chacha20poly1305.NonceSize]
+}
+
+// transportNonce builds in nonce the nonce of the transport message with the
+// given counter, sent with the keypair if sending, or else received, and
+// returns it.
+func (keypair *Keypair) transportNonce(nonce *[maxTransportNonceSize]byte, counter uint64, sending bool) []byte {
+	if sending {
+		return transportNonce(nonce, keypair.send.NonceSize(), counter, keypair.remoteIndex, keypair.isInitiator)
+	}
+	return transportNonce(nonce, keypair.receive.NonceSize(), counter, keypair.localIndex, !keypair.isInitiator)
+}
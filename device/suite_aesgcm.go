@@ -0,0 +1,54 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+)
+
+/* AES256GCM
+ *
+ * The AES256GCM suite encrypts transport data with AES-256-GCM from the
+ * standard library, under the 32-byte session keys and standard 12-byte
+ * transport nonces, so that its throughput can be compared with that of
+ * ChaCha20-Poly1305 and the experimental suites on the same packet path.
+ * crypto/aes and crypto/cipher run it on the AES and carry-less
+ * multiplication instructions of amd64, arm64, ppc64 and s390x CPUs that
+ * have them, and on constant-time but much slower software elsewhere.
+ * GCM forgeries of a message of l blocks succeed with probability about
+ * l/2^128 per try, which for transport messages of the default MTU is
+ * about 2^-121.
+ */
+
+const (
+	AES256GCMCipherSuite = "AES256GCM"
+
+	aesgcmSecurityBits = 121 // integrity level of AES-GCM tags on transport messages of the default MTU
+)
+
+func init() {
+	registerCryptoPrimitive(CryptoPrimitive{Name: "AES-256-GCM", Rounds: 14, Use: "AES256GCM transport data", Source: CryptoSourceStdlib, Origin: "crypto/aes"})
+	RegisterCipherSuite(CipherSuite{
+		Name:         AES256GCMCipherSuite,
+		Experimental: true,
+		SecurityBits: aesgcmSecurityBits,
+		New:          newAEADSuite(newAES256GCM),
+		SelfTest:     aeadSelfTest("c115d0f45534f1c54da71e722ba0c487bf8586c5c3e30b2afcbb873338bbd862"),
+	})
+}
+
+func newAES256GCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, errors.New("aes256gcm: bad key length")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,96 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAES256GCM(t *testing.T) {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	aead, err := LookupCipherSuite(AES256GCMCipherSuite).New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := aes.NewCipher(key)
+	gcm, _ := cipher.NewGCM(block)
+	var nonce [maxTransportNonceSize]byte
+	n := transportNonce(&nonce, aead.NonceSize(), 1, 0, true)
+	plaintext, ad := []byte("transport data"), []byte("header")
+	sealed := aead.Seal(nil, n, plaintext, ad)
+	if !bytes.Equal(sealed, gcm.Seal(nil, n, plaintext, ad)) {
+		t.Fatal("sealed is not AES-256-GCM")
+	}
+	if opened, err := aead.Open(nil, n, sealed, ad); err != nil || !bytes.Equal(opened, plaintext) {
+		t.Fatalf("open: %q, %v", opened, err)
+	}
+	if _, err := newAES256GCM(key[:16]); err == nil {
+		t.Error("created AES256GCM with a 16-byte key")
+	}
+}
+
+func TestDeviceCipherSuite(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+	time.Sleep(50 * time.Millisecond)
+	suite := AES256GCMCipherSuite
+	for i := range pair {
+		if err := pair[i].dev.Configure(Config{CipherSuite: &suite}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Peers not pinned to a suite take the device's with their next session.
+	peer := pair[0].dev.LookupPeer(pair[1].dev.staticIdentity.publicKey)
+	if peer.CipherSuite() != AES256GCMCipherSuite {
+		t.Errorf("peer suite = %q", peer.CipherSuite())
+	}
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+	if active := peer.ActiveCipherSuite(); active != AES256GCMCipherSuite {
+		t.Errorf("active suite = %q, want %q", active, AES256GCMCipherSuite)
+	}
+	status := pair[0].dev.Status()
+	if status.CipherSuite != AES256GCMCipherSuite || !status.Experimental {
+		t.Errorf("status suite %q, experimental %v", status.CipherSuite, status.Experimental)
+	}
+	cfg, err := pair[0].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i := strings.Index(cfg, "\ncipher_suite="+AES256GCMCipherSuite+"\n"); i < 0 || i > strings.Index(cfg, "public_key=") {
+		t.Errorf("get output lacks the device suite:\n%s", cfg)
+	}
+	if entries, _ := pair[0].dev.AuditTrail(); len(entries) != 1 || entries[0].Setting != "cipher_suite" || entries[0].Value != AES256GCMCipherSuite {
+		t.Errorf("unexpected audit trail %+v", entries)
+	}
+
+	// Pinned peers keep their suite.
+	if err := peer.SetCipherSuite(AES256GCMCipherSuite); err != nil {
+		t.Fatal(err)
+	}
+	if err := pair[0].dev.IpcSet("cipher_suite=" + StandardCipherSuite + "\n"); err != nil {
+		t.Fatal(err)
+	}
+	if peer.CipherSuite() != AES256GCMCipherSuite || pair[0].dev.CipherSuite() != StandardCipherSuite {
+		t.Errorf("peer suite %q, device suite %q", peer.CipherSuite(), pair[0].dev.CipherSuite())
+	}
+	if active := peer.ActiveCipherSuite(); active != AES256GCMCipherSuite {
+		t.Errorf("pinned peer lost its session to %q", active)
+	}
+	if err := pair[0].dev.IpcSet("cipher_suite=nope\n"); err == nil {
+		t.Error("set device to unregistered suite")
+	}
+}
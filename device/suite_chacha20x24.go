@@ -0,0 +1,156 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+/* ChaCha20x24Poly1795
+ *
+ * The experimental ChaCha20_24 stream cipher and Poly1795 MAC combined into
+ * an AEAD, after the construction of ChaCha20-Poly1305 in RFC 8439. The
+ * first 32 bytes of keystream block 0 for the key and nonce are the
+ * one-time Poly1795 key; the plaintext is encrypted with the keystream from
+ * block 1 on; and the 24-byte tag is the MAC of the additional data and the
+ * ciphertext, each zero-padded to a multiple of 16 bytes, followed by their
+ * lengths as 64-bit little-endian integers.
+ *
+ * The 16-byte nonce of ChaCha20_24 takes the state word of the last word of
+ * the key, so the block function alone ignores the last 4 bytes of its key.
+ * The AEAD folds them into the first word of the nonce, which keeps distinct
+ * nonces distinct, so that the whole key counts. Its security has not been
+ * analyzed; the ChaCha20x24Poly1795 suite claims no more of it than
+ * standard Poly1305 tags give.
+ */
+
+const (
+	ChaCha20x24Poly1795CipherSuite = "ChaCha20x24Poly1795"
+
+	chacha20x24Poly1795TagSize = 24
+	chacha20x24MaxPlaintext    = (1<<32 - 1) * 64 // the counter of block 0 is taken by the MAC key
+)
+
+func init() {
+	RegisterCipherSuite(CipherSuite{
+		Name:         ChaCha20x24Poly1795CipherSuite,
+		Experimental: true,
+		TagSize:      chacha20x24Poly1795TagSize,
+		SecurityBits: poly1305SecurityBits,
+		New:          newAEADSuite(NewChaCha20x24Poly1795),
+		NewRounds: func(key []byte, rounds int) (AEADSuite, error) {
+			return NewChaCha20x24Poly1795Rounds(key, rounds)
+		},
+		SelfTest: func(aead AEADSuite) error {
+			return errors.Join(chacha20x24SelfTest(), poly1795SelfTest(), aeadSelfTest("93cb7298213ba5ffdd61d3226958effabc651c5bd4f6885e6ab1c35b0f941e30")(aead))
+		},
+	})
+}
+
+var errChaCha20x24Poly1795Open = errors.New("chacha20x24poly1795: message authentication failed")
+
+// ChaCha20x24Poly1795 is the AEAD of ChaCha20_24 and Poly1795, taking
+// 32-byte keys and 16-byte nonces and adding 24-byte tags.
+type ChaCha20x24Poly1795 struct {
+	key    [chachaKeySize]byte
+	rounds int // of the block function
+}
+
+// NewChaCha20x24Poly1795 returns a ChaCha20x24Poly1795 AEAD with the given
+// 32-byte key.
+func NewChaCha20x24Poly1795(key []byte) (cipher.AEAD, error) {
+	return NewChaCha20x24Poly1795Rounds(key, chachaRounds)
+}
+
+// NewChaCha20x24Poly1795Rounds is NewChaCha20x24Poly1795 with the given
+// even and positive number of rounds in place of 24, for both the one-time
+// MAC key and the keystream. AEADs of different round counts do not
+// interoperate.
+func NewChaCha20x24Poly1795Rounds(key []byte, rounds int) (cipher.AEAD, error) {
+	if len(key) != chachaKeySize {
+		return nil, errors.New("chacha20x24poly1795: bad key length")
+	}
+	if rounds <= 0 || rounds%2 != 0 {
+		return nil, errors.New("chacha20x24poly1795: rounds must be even and positive")
+	}
+	a := &ChaCha20x24Poly1795{rounds: rounds}
+	copy(a.key[:], key)
+	return a, nil
+}
+
+func (a *ChaCha20x24Poly1795) NonceSize() int   { return chachaNonceSize }
+func (a *ChaCha20x24Poly1795) Overhead() int    { return chacha20x24Poly1795TagSize }
+func (a *ChaCha20x24Poly1795) keyBytes() []byte { return a.key[:] }
+
+func (a *ChaCha20x24Poly1795) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != chachaNonceSize {
+		panic("chacha20x24poly1795: bad nonce length passed to Seal")
+	}
+	if uint64(len(plaintext)) > chacha20x24MaxPlaintext {
+		panic("chacha20x24poly1795: plaintext too large")
+	}
+	blockNonce, polyKey := a.setup(nonce)
+	ret, out := sliceForAppend(dst, len(plaintext)+chacha20x24Poly1795TagSize)
+	ciphertext := out[:len(plaintext)]
+	a.xorKeyStream(ciphertext, plaintext, &blockNonce)
+	chacha20x24MAC(&polyKey, additionalData, ciphertext).Sum(ciphertext[len(ciphertext):len(ciphertext)])
+	return ret
+}
+
+func (a *ChaCha20x24Poly1795) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != chachaNonceSize {
+		panic("chacha20x24poly1795: bad nonce length passed to Open")
+	}
+	if len(ciphertext) < chacha20x24Poly1795TagSize || uint64(len(ciphertext)-chacha20x24Poly1795TagSize) > chacha20x24MaxPlaintext {
+		return nil, errChaCha20x24Poly1795Open
+	}
+	text, tag := ciphertext[:len(ciphertext)-chacha20x24Poly1795TagSize], ciphertext[len(ciphertext)-chacha20x24Poly1795TagSize:]
+	blockNonce, polyKey := a.setup(nonce)
+	if !chacha20x24MAC(&polyKey, additionalData, text).Verify(tag) {
+		return nil, errChaCha20x24Poly1795Open
+	}
+	ret, out := sliceForAppend(dst, len(text))
+	a.xorKeyStream(out, text, &blockNonce)
+	return ret, nil
+}
+
+// setup returns the nonce of the block function for nonce, with the last
+// word of the key folded in, and the one-time Poly1795 key.
+func (a *ChaCha20x24Poly1795) setup(nonce []byte) (blockNonce [chachaNonceSize]byte, polyKey [32]byte) {
+	copy(blockNonce[:], nonce)
+	subtle.XORBytes(blockNonce[:4], blockNonce[:4], a.key[28:])
+	var block [64]byte
+	chachaBlockN(a.rounds, &a.key, &blockNonce, 0, &block)
+	copy(polyKey[:], block[:])
+	return
+}
+
+// xorKeyStream XORs src with the keystream from block 1 on into dst.
+func (a *ChaCha20x24Poly1795) xorKeyStream(dst, src []byte, blockNonce *[chachaNonceSize]byte) {
+	stream := ChaCha20x24Cipher{key: a.key, nonce: *blockNonce, rounds: a.rounds, counter: 1}
+	stream.XORKeyStream(dst, src)
+}
+
+// chacha20x24MAC returns the Poly1795 MAC of additionalData and ciphertext
+// under polyKey, ready for Sum or Verify.
+func chacha20x24MAC(polyKey *[32]byte, additionalData, ciphertext []byte) *poly1795MAC {
+	mac := newPoly1795MAC(polyKey)
+	var pad [16]byte
+	mac.Write(additionalData)
+	mac.Write(pad[:(16-len(additionalData)%16)%16])
+	mac.Write(ciphertext)
+	mac.Write(pad[:(16-len(ciphertext)%16)%16])
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[0:], uint64(len(additionalData)))
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(ciphertext)))
+	mac.Write(lengths[:])
+	return mac
+}
@@ -0,0 +1,156 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestChaCha20x24Poly1795(t *testing.T) {
+	key := make([]byte, chachaKeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	aead, err := NewChaCha20x24Poly1795(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := make([]byte, chachaNonceSize)
+	nonce[0] = 1
+	for _, size := range []int{0, 1, 63, 64, 65, 1420} {
+		plaintext := bytes.Repeat([]byte{0xa5}, size)
+		ad := []byte("header")[:size%7]
+		sealed := aead.Seal(nil, nonce, plaintext, ad)
+		if len(sealed) != size+aead.Overhead() {
+			t.Fatalf("sealed %d bytes into %d", size, len(sealed))
+		}
+		if size >= 16 && bytes.Equal(sealed[:16], plaintext[:16]) {
+			t.Fatalf("sealed %d bytes unencrypted", size)
+		}
+		// Sealing in place, as the data plane does, gives the same.
+		inPlace := append([]byte(nil), plaintext...)
+		if got := aead.Seal(inPlace[:0], nonce, inPlace, ad); !bytes.Equal(got, sealed) {
+			t.Fatalf("in-place seal of %d bytes differs", size)
+		}
+		opened, err := aead.Open(nil, nonce, sealed, ad)
+		if err != nil || !bytes.Equal(opened, plaintext) {
+			t.Fatalf("open of %d bytes: %v", size, err)
+		}
+
+		for _, corrupt := range []func(sealed, nonce []byte){
+			func(sealed, nonce []byte) { sealed[len(sealed)-1] ^= 1 },
+			func(sealed, nonce []byte) { sealed[0] ^= 1 },
+			func(sealed, nonce []byte) { nonce[15] ^= 1 },
+		} {
+			sealed, nonce := aead.Seal(nil, nonce, plaintext, ad), append([]byte(nil), nonce...)
+			corrupt(sealed, nonce)
+			if _, err := aead.Open(nil, nonce, sealed, ad); err == nil {
+				t.Fatalf("opened %d bytes corrupted", size)
+			}
+		}
+		if _, err := aead.Open(nil, nonce, sealed, append(ad, 0)); err == nil {
+			t.Fatalf("opened %d bytes with other additional data", size)
+		}
+	}
+	if _, err := aead.Open(nil, nonce, make([]byte, aead.Overhead()-1), nil); err == nil {
+		t.Error("opened a message shorter than its tag")
+	}
+
+	// The last word of the key, which the block function ignores, counts.
+	other := append([]byte(nil), key...)
+	other[31] ^= 1
+	otherAEAD, _ := NewChaCha20x24Poly1795(other)
+	if bytes.Equal(aead.Seal(nil, nonce, nil, nil), otherAEAD.Seal(nil, nonce, nil, nil)) {
+		t.Error("the last key word does not change tags")
+	}
+	if _, err := NewChaCha20x24Poly1795(key[:16]); err == nil {
+		t.Error("short key accepted")
+	}
+
+	// AEADs of other round counts open what they seal, and only that.
+	reduced, err := NewChaCha20x24Poly1795Rounds(key, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := []byte("reduced rounds")
+	sealed := reduced.Seal(nil, nonce, plaintext, nil)
+	if opened, err := reduced.Open(nil, nonce, sealed, nil); err != nil || !bytes.Equal(opened, plaintext) {
+		t.Errorf("open with 12 rounds: %v", err)
+	}
+	if _, err := aead.Open(nil, nonce, sealed, nil); err == nil {
+		t.Error("opened with 24 rounds what 12 sealed")
+	}
+	if _, err := NewChaCha20x24Poly1795Rounds(key, 7); err == nil {
+		t.Error("odd rounds accepted")
+	}
+}
+
+func TestChaCha20x24Poly1795Session(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+	time.Sleep(50 * time.Millisecond)
+	suite := ChaCha20x24Poly1795CipherSuite
+	for i := range pair {
+		peerKey := pair[i^1].dev.staticIdentity.publicKey
+		if err := pair[i].dev.Configure(Config{Peers: []PeerConfig{{PublicKey: peerKey, CipherSuite: &suite}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+
+	peer := pair[0].dev.LookupPeer(pair[1].dev.staticIdentity.publicKey)
+	if peer.ActiveCipherSuite() != ChaCha20x24Poly1795CipherSuite {
+		t.Fatalf("active suite = %q", peer.ActiveCipherSuite())
+	}
+	if ps := pair[0].dev.Status().Peers[0]; ps.ActiveTagSize != 24 {
+		t.Errorf("status tag size %d", ps.ActiveTagSize)
+	}
+}
+
+func TestChaChaRoundsSession(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+	time.Sleep(50 * time.Millisecond)
+	for i := range pair {
+		if err := pair[i].dev.IpcSet("cipher_suite=" + ChaCha20x24Poly1795CipherSuite + "\nchacha_rounds=12\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+
+	peer := pair[0].dev.LookupPeer(pair[1].dev.staticIdentity.publicKey)
+	keypair := peer.keypairs.Current()
+	if aead, ok := keypair.send.(*ChaCha20x24Poly1795); !ok || aead.rounds != 12 {
+		t.Fatalf("session of %T, not of 12 rounds", keypair.send)
+	}
+	if status := pair[0].dev.Status(); status.ChaChaRounds != 12 {
+		t.Errorf("status rounds %d", status.ChaChaRounds)
+	}
+
+	// Changing the count expires the sessions of the old one.
+	for i := range pair {
+		if err := pair[i].dev.SetChaChaRounds(0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if keypair.sendNonce.Load() < RejectAfterMessages {
+		t.Error("session of 12 rounds not expired")
+	}
+	time.Sleep(50 * time.Millisecond)
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+	if aead := peer.keypairs.Current().send.(*ChaCha20x24Poly1795); aead.rounds != chachaRounds {
+		t.Errorf("session of %d rounds after resetting them", aead.rounds)
+	}
+}
@@ -0,0 +1,136 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+/* ChaCha20DoublePoly1305
+ *
+ * Standard ChaCha20 and the experimental DoublePoly1305 MAC combined into
+ * an AEAD, after the construction of ChaCha20-Poly1305 in RFC 8439, but
+ * with a 32-byte tag: all 64 bytes of keystream block 0 for the key and
+ * nonce are the two one-time Poly1305 keys; the plaintext is encrypted with
+ * the keystream from block 1 on; and the tag is the pair of MACs, under
+ * either key, of the additional data and the ciphertext, each zero-padded
+ * to a multiple of 16 bytes, followed by their lengths as 64-bit
+ * little-endian integers. Open checks both halves of the tag.
+ *
+ * The first half of the tag is the tag of ChaCha20-Poly1305 for the same
+ * key and nonce, so the suite does not hide what standard sessions would
+ * send. As the two keys are independent, forging a packet takes passing
+ * both MACs, which the suite claims twice the security level of one for.
+ * Transport messages of the suite take 16 bytes more of each datagram than
+ * standard ones.
+ */
+
+const (
+	ChaCha20DoublePoly1305CipherSuite = "ChaCha20DoublePoly1305"
+
+	doublePoly1305TagSize      = 32
+	doublePoly1305MaxPlaintext = (1<<32 - 1) * 64 // the counter of block 0 is taken by the MAC keys
+)
+
+func init() {
+	RegisterCipherSuite(CipherSuite{
+		Name:         ChaCha20DoublePoly1305CipherSuite,
+		Experimental: true,
+		TagSize:      doublePoly1305TagSize,
+		SecurityBits: 2 * poly1305SecurityBits,
+		New:          newAEADSuite(NewChaCha20DoublePoly1305),
+		SelfTest:     aeadSelfTest("43fc5370e38a5a4a8ffec8a0f759c04db13f21b5c572d31614c5109488a35ae9"),
+	})
+}
+
+var errChaCha20DoublePoly1305Open = errors.New("chacha20doublepoly1305: message authentication failed")
+
+// ChaCha20DoublePoly1305 is the AEAD of ChaCha20 and DoublePoly1305, taking
+// 32-byte keys and 12-byte nonces and adding 32-byte tags.
+type ChaCha20DoublePoly1305 struct {
+	key [chacha20poly1305.KeySize]byte
+}
+
+// NewChaCha20DoublePoly1305 returns a ChaCha20DoublePoly1305 AEAD with the
+// given 32-byte key.
+func NewChaCha20DoublePoly1305(key []byte) (cipher.AEAD, error) {
+	if len(key) != chacha20poly1305.KeySize {
+		return nil, errors.New("chacha20doublepoly1305: bad key length")
+	}
+	a := new(ChaCha20DoublePoly1305)
+	copy(a.key[:], key)
+	return a, nil
+}
+
+func (a *ChaCha20DoublePoly1305) NonceSize() int   { return chacha20poly1305.NonceSize }
+func (a *ChaCha20DoublePoly1305) Overhead() int    { return doublePoly1305TagSize }
+func (a *ChaCha20DoublePoly1305) keyBytes() []byte { return a.key[:] }
+
+func (a *ChaCha20DoublePoly1305) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != chacha20poly1305.NonceSize {
+		panic("chacha20doublepoly1305: bad nonce length passed to Seal")
+	}
+	if uint64(len(plaintext)) > doublePoly1305MaxPlaintext {
+		panic("chacha20doublepoly1305: plaintext too large")
+	}
+	stream, polyKey := a.setup(nonce)
+	ret, out := sliceForAppend(dst, len(plaintext)+doublePoly1305TagSize)
+	ciphertext := out[:len(plaintext)]
+	stream.XORKeyStream(ciphertext, plaintext)
+	doublePoly1305MAC(&polyKey, additionalData, ciphertext).Sum(ciphertext[len(ciphertext):len(ciphertext)])
+	setZero(polyKey[:])
+	return ret
+}
+
+func (a *ChaCha20DoublePoly1305) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != chacha20poly1305.NonceSize {
+		panic("chacha20doublepoly1305: bad nonce length passed to Open")
+	}
+	if len(ciphertext) < doublePoly1305TagSize || uint64(len(ciphertext)-doublePoly1305TagSize) > doublePoly1305MaxPlaintext {
+		return nil, errChaCha20DoublePoly1305Open
+	}
+	text, tag := ciphertext[:len(ciphertext)-doublePoly1305TagSize], ciphertext[len(ciphertext)-doublePoly1305TagSize:]
+	stream, polyKey := a.setup(nonce)
+	ok := doublePoly1305MAC(&polyKey, additionalData, text).Verify(tag)
+	setZero(polyKey[:])
+	if !ok {
+		return nil, errChaCha20DoublePoly1305Open
+	}
+	ret, out := sliceForAppend(dst, len(text))
+	stream.XORKeyStream(out, text)
+	return ret, nil
+}
+
+// setup returns the keystream of nonce from block 1 on and the two one-time
+// Poly1305 keys of block 0.
+func (a *ChaCha20DoublePoly1305) setup(nonce []byte) (stream *chacha20.Cipher, polyKey [64]byte) {
+	stream, _ = chacha20.NewUnauthenticatedCipher(a.key[:], nonce)
+	stream.XORKeyStream(polyKey[:], polyKey[:])
+	return
+}
+
+// doublePoly1305MAC returns the DoublePoly1305 MAC of additionalData and
+// ciphertext under polyKey, ready for Sum or Verify.
+func doublePoly1305MAC(polyKey *[64]byte, additionalData, ciphertext []byte) *DoublePoly1305MAC {
+	mac := NewDoublePoly1305(polyKey)
+	var pad [16]byte
+	mac.Write(additionalData)
+	mac.Write(pad[:(16-len(additionalData)%16)%16])
+	mac.Write(ciphertext)
+	mac.Write(pad[:(16-len(ciphertext)%16)%16])
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[0:], uint64(len(additionalData)))
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(ciphertext)))
+	mac.Write(lengths[:])
+	return mac
+}
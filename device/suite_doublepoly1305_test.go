@@ -0,0 +1,106 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func TestChaCha20DoublePoly1305(t *testing.T) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	aead, err := NewChaCha20DoublePoly1305(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	standard, _ := chacha20poly1305.New(key)
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	nonce[4] = 1
+	for _, size := range []int{0, 1, 63, 64, 65, 1420} {
+		plaintext := bytes.Repeat([]byte{0xa5}, size)
+		ad := []byte("header")[:size%7]
+		sealed := aead.Seal(nil, nonce, plaintext, ad)
+		if len(sealed) != size+doublePoly1305TagSize {
+			t.Fatalf("sealed %d bytes into %d", size, len(sealed))
+		}
+		// The ciphertext and first half of the tag are those of ChaCha20-Poly1305.
+		if !bytes.Equal(sealed[:size+16], standard.Seal(nil, nonce, plaintext, ad)) {
+			t.Fatalf("sealed %d bytes unlike ChaCha20-Poly1305", size)
+		}
+		inPlace := append([]byte(nil), plaintext...)
+		if got := aead.Seal(inPlace[:0], nonce, inPlace, ad); !bytes.Equal(got, sealed) {
+			t.Fatalf("in-place seal of %d bytes differs", size)
+		}
+		opened, err := aead.Open(nil, nonce, sealed, ad)
+		if err != nil || !bytes.Equal(opened, plaintext) {
+			t.Fatalf("open of %d bytes: %v", size, err)
+		}
+
+		// Either half of the tag failing to verify fails the open.
+		for _, offset := range []int{size, size + 15, size + 16, size + 31} {
+			corrupt := append([]byte(nil), sealed...)
+			corrupt[offset] ^= 1
+			if _, err := aead.Open(nil, nonce, corrupt, ad); err == nil {
+				t.Fatalf("opened %d bytes with tag byte %d corrupted", size, offset-size)
+			}
+		}
+		if size > 0 {
+			corrupt := append([]byte(nil), sealed...)
+			corrupt[0] ^= 1
+			if _, err := aead.Open(nil, nonce, corrupt, ad); err == nil {
+				t.Fatalf("opened %d bytes corrupted", size)
+			}
+		}
+		if _, err := aead.Open(nil, nonce, sealed, append(ad, 0)); err == nil {
+			t.Fatalf("opened %d bytes with other additional data", size)
+		}
+	}
+	if _, err := aead.Open(nil, nonce, make([]byte, doublePoly1305TagSize-1), nil); err == nil {
+		t.Error("opened a message shorter than its tag")
+	}
+	if _, err := NewChaCha20DoublePoly1305(key[:16]); err == nil {
+		t.Error("short key accepted")
+	}
+}
+
+func TestChaCha20DoublePoly1305Session(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+	time.Sleep(50 * time.Millisecond)
+	if mtu := pair[0].dev.MaxTunnelMTU(ethernetMTU); mtu != DefaultMTU {
+		t.Errorf("standard MTU for an Ethernet path %d", mtu)
+	}
+	for i := range pair {
+		if err := pair[i].dev.IpcSet("cipher_suite=" + ChaCha20DoublePoly1305CipherSuite + "\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+
+	peer := pair[0].dev.LookupPeer(pair[1].dev.staticIdentity.publicKey)
+	if peer.ActiveCipherSuite() != ChaCha20DoublePoly1305CipherSuite {
+		t.Fatalf("active suite = %q", peer.ActiveCipherSuite())
+	}
+	if ps := pair[0].dev.Status().Peers[0]; ps.ActiveTagSize != 32 || ps.ActiveSecurityBits != 2*poly1305SecurityBits {
+		t.Errorf("status tag size %d, security %d bits", ps.ActiveTagSize, ps.ActiveSecurityBits)
+	}
+	if keypair := peer.keypairs.Current(); keypair.transportOverhead() != MessageTransportSize+16 {
+		t.Errorf("transport overhead %d", keypair.transportOverhead())
+	}
+	if mtu := pair[0].dev.MaxTunnelMTU(ethernetMTU); mtu != DefaultMTU-16 {
+		t.Errorf("MTU for an Ethernet path %d with 32-byte tags", mtu)
+	}
+}
@@ -0,0 +1,292 @@
+//go:build wg_gost
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+/* Kuznyechik-MGM transport encryption, built with the wg_gost tag
+ *
+ * For users bound to the Russian national algorithms, the
+ * KuznyechikMGMCipherSuite encrypts transport data with the Kuznyechik block
+ * cipher (GOST R 34.12-2015) in Multilinear Galois Mode (RFC 9058), keyed
+ * with the full 256-bit session keys. MGM takes a 127-bit nonce, which is
+ * the 96-bit transport nonce preceded by zeros. The handshake itself is
+ * unchanged, so the suite protects transport data only.
+ */
+
+// KuznyechikMGMCipherSuite is the name of the Kuznyechik-MGM suite.
+const KuznyechikMGMCipherSuite = "KuznyechikMGM"
+
+func init() {
+	registerCryptoPrimitive(CryptoPrimitive{Name: "Kuznyechik", Rounds: kuznyechikRounds, Use: "KuznyechikMGM transport data", Source: CryptoSourceInPackage, Origin: "suite_gost.go"})
+	registerCryptoPrimitive(CryptoPrimitive{Name: "MGM", Use: "KuznyechikMGM transport data", Source: CryptoSourceInPackage, Origin: "suite_gost.go"})
+	RegisterCipherSuite(CipherSuite{
+		Name:         KuznyechikMGMCipherSuite,
+		Experimental: true,
+		New: func(key []byte) (AEADSuite, error) {
+			block, err := newKuznyechik(key)
+			if err != nil {
+				return nil, err
+			}
+			return &mgm{block: block}, nil
+		},
+		SelfTest: aeadSelfTest("5bf4f0be542f02a07daae3648f2a386c98297923b349eecce58f28cee5dfec85"),
+	})
+}
+
+const (
+	kuznyechikKeySize   = 32
+	kuznyechikBlockSize = 16
+	kuznyechikRounds    = 10
+
+	mgmNonceSize = 12
+	mgmTagSize   = 16
+)
+
+var kuznyechikPi = [256]byte{
+	252, 238, 221, 17, 207, 110, 49, 22, 251, 196, 250, 218, 35, 197, 4, 77,
+	233, 119, 240, 219, 147, 46, 153, 186, 23, 54, 241, 187, 20, 205, 95, 193,
+	249, 24, 101, 90, 226, 92, 239, 33, 129, 28, 60, 66, 139, 1, 142, 79,
+	5, 132, 2, 174, 227, 106, 143, 160, 6, 11, 237, 152, 127, 212, 211, 31,
+	235, 52, 44, 81, 234, 200, 72, 171, 242, 42, 104, 162, 253, 58, 206, 204,
+	181, 112, 14, 86, 8, 12, 118, 18, 191, 114, 19, 71, 156, 183, 93, 135,
+	21, 161, 150, 41, 16, 123, 154, 199, 243, 145, 120, 111, 157, 158, 178, 177,
+	50, 117, 25, 61, 255, 53, 138, 126, 109, 84, 198, 128, 195, 189, 13, 87,
+	223, 245, 36, 169, 62, 168, 67, 201, 215, 121, 214, 246, 124, 34, 185, 3,
+	224, 15, 236, 222, 122, 148, 176, 188, 220, 232, 40, 80, 78, 51, 10, 74,
+	167, 151, 96, 115, 30, 0, 98, 68, 26, 184, 56, 130, 100, 159, 38, 65,
+	173, 69, 70, 146, 39, 94, 85, 47, 140, 163, 165, 125, 105, 213, 149, 59,
+	7, 88, 179, 64, 134, 172, 29, 247, 48, 55, 107, 228, 136, 217, 231, 137,
+	225, 27, 131, 73, 76, 63, 248, 254, 141, 83, 170, 144, 202, 216, 133, 97,
+	32, 113, 103, 164, 45, 43, 9, 91, 203, 155, 37, 208, 190, 229, 108, 82,
+	89, 166, 116, 210, 230, 244, 180, 192, 209, 102, 175, 194, 57, 75, 99, 182,
+}
+
+// kuznyechikL are the coefficients of the linear function of the R
+// transformation, for the bytes from first to last.
+var kuznyechikL = [kuznyechikBlockSize]byte{148, 32, 133, 16, 194, 192, 1, 251, 1, 192, 194, 16, 133, 32, 148, 1}
+
+// kuznyechikLS holds the LS transformation of a block that has its only
+// non-zero byte at each position, so that LS(a) is the sum of the entries
+// of its bytes.
+var kuznyechikLS [kuznyechikBlockSize][256][kuznyechikBlockSize]byte
+
+func init() {
+	for i := range kuznyechikLS {
+		for b := range 256 {
+			var block [kuznyechikBlockSize]byte
+			block[i] = kuznyechikPi[b]
+			kuznyechikLinear(&block)
+			kuznyechikLS[i][b] = block
+		}
+	}
+}
+
+// kuznyechikMul multiplies in GF(2^8) modulo x^8 + x^7 + x^6 + x + 1.
+func kuznyechikMul(a, b byte) byte {
+	var p byte
+	for b != 0 {
+		if b&1 != 0 {
+			p ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0xc3
+		}
+		b >>= 1
+	}
+	return p
+}
+
+// kuznyechikLinear applies the L transformation, sixteen R transformations.
+func kuznyechikLinear(block *[kuznyechikBlockSize]byte) {
+	for range kuznyechikBlockSize {
+		var l byte
+		for i, c := range kuznyechikL {
+			l ^= kuznyechikMul(block[i], c)
+		}
+		copy(block[1:], block[:kuznyechikBlockSize-1])
+		block[0] = l
+	}
+}
+
+type kuznyechik struct {
+	keys [kuznyechikRounds][kuznyechikBlockSize]byte
+}
+
+func newKuznyechik(key []byte) (*kuznyechik, error) {
+	if len(key) != kuznyechikKeySize {
+		return nil, errors.New("invalid Kuznyechik key size")
+	}
+	c := new(kuznyechik)
+	var k1, k2 [kuznyechikBlockSize]byte
+	copy(k1[:], key[:kuznyechikBlockSize])
+	copy(k2[:], key[kuznyechikBlockSize:])
+	c.keys[0], c.keys[1] = k1, k2
+	for i := range 32 {
+		// The Feistel rounds of the key schedule use the constants L(i+1).
+		var constant [kuznyechikBlockSize]byte
+		constant[kuznyechikBlockSize-1] = byte(i + 1)
+		kuznyechikLinear(&constant)
+		subtle.XORBytes(constant[:], constant[:], k1[:])
+		var t [kuznyechikBlockSize]byte
+		kuznyechikLSX(&t, &constant)
+		subtle.XORBytes(t[:], t[:], k2[:])
+		k1, k2 = t, k1
+		if i%8 == 7 {
+			c.keys[2+i/8*2], c.keys[3+i/8*2] = k1, k2
+		}
+	}
+	return c, nil
+}
+
+// kuznyechikLSX sets dst to the LS transformation of src, which has already
+// been added to the round key.
+func kuznyechikLSX(dst, src *[kuznyechikBlockSize]byte) {
+	var lo, hi uint64
+	for i, b := range src {
+		e := &kuznyechikLS[i][b]
+		hi ^= binary.LittleEndian.Uint64(e[:8])
+		lo ^= binary.LittleEndian.Uint64(e[8:])
+	}
+	binary.LittleEndian.PutUint64(dst[:8], hi)
+	binary.LittleEndian.PutUint64(dst[8:], lo)
+}
+
+func (c *kuznyechik) encrypt(dst, src *[kuznyechikBlockSize]byte) {
+	block := *src
+	for _, key := range c.keys[:kuznyechikRounds-1] {
+		subtle.XORBytes(block[:], block[:], key[:])
+		kuznyechikLSX(&block, &block)
+	}
+	subtle.XORBytes(dst[:], block[:], c.keys[kuznyechikRounds-1][:])
+}
+
+// mgm is the Multilinear Galois Mode of RFC 9058 with Kuznyechik, taking
+// transport nonces.
+type mgm struct {
+	block *kuznyechik
+}
+
+func (m *mgm) NonceSize() int { return mgmNonceSize }
+func (m *mgm) Overhead() int  { return mgmTagSize }
+
+type mgmBlock = [kuznyechikBlockSize]byte
+
+// mgmMul multiplies in GF(2^128) modulo x^128 + x^7 + x^2 + x + 1, with
+// blocks as big endian polynomials.
+func mgmMul(a, b *mgmBlock) (hi, lo uint64) {
+	ahi, alo := binary.BigEndian.Uint64(a[:8]), binary.BigEndian.Uint64(a[8:])
+	bhi, blo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	for i := range 128 {
+		carry := hi >> 63
+		hi, lo = hi<<1|lo>>63, lo<<1
+		lo ^= carry * 0x87
+		var bit uint64
+		if i < 64 {
+			bit = bhi >> (63 - i) & 1
+		} else {
+			bit = blo >> (127 - i) & 1
+		}
+		hi ^= ahi & -bit
+		lo ^= alo & -bit
+	}
+	return hi, lo
+}
+
+// mgmState keeps the counters of the encryption and authentication
+// keystreams and the sum of the authenticated blocks.
+type mgmState struct {
+	block      *kuznyechik
+	y, z       mgmBlock
+	sumHi, sum uint64
+}
+
+func (m *mgm) start(nonce []byte) *mgmState {
+	if len(nonce) != mgmNonceSize {
+		panic("mgm: incorrect nonce length given to MGM")
+	}
+	s := &mgmState{block: m.block}
+	var icn mgmBlock
+	copy(icn[kuznyechikBlockSize-mgmNonceSize:], nonce)
+	m.block.encrypt(&s.y, &icn)
+	icn[0] |= 0x80
+	m.block.encrypt(&s.z, &icn)
+	return s
+}
+
+// authenticate adds the blocks of b, the last one padded with zeros.
+func (s *mgmState) authenticate(b []byte) {
+	for len(b) > 0 {
+		var block, h mgmBlock
+		n := copy(block[:], b)
+		b = b[n:]
+		s.block.encrypt(&h, &s.z)
+		binary.BigEndian.PutUint64(s.z[:8], binary.BigEndian.Uint64(s.z[:8])+1)
+		hi, lo := mgmMul(&h, &block)
+		s.sumHi ^= hi
+		s.sum ^= lo
+	}
+}
+
+// crypt adds the keystream to src.
+func (s *mgmState) crypt(dst, src []byte) {
+	for len(src) > 0 {
+		var keystream mgmBlock
+		s.block.encrypt(&keystream, &s.y)
+		binary.BigEndian.PutUint64(s.y[8:], binary.BigEndian.Uint64(s.y[8:])+1)
+		n := subtle.XORBytes(dst, src, keystream[:])
+		dst, src = dst[n:], src[n:]
+	}
+}
+
+func (s *mgmState) tag(adLen, textLen int) (tag mgmBlock) {
+	var lengths mgmBlock
+	binary.BigEndian.PutUint64(lengths[:8], uint64(adLen)*8)
+	binary.BigEndian.PutUint64(lengths[8:], uint64(textLen)*8)
+	s.authenticate(lengths[:])
+	binary.BigEndian.PutUint64(tag[:8], s.sumHi)
+	binary.BigEndian.PutUint64(tag[8:], s.sum)
+	s.block.encrypt(&tag, &tag)
+	return
+}
+
+func (m *mgm) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	s := m.start(nonce)
+	ret, out := sliceForAppend(dst, len(plaintext)+mgmTagSize)
+	s.crypt(out, plaintext)
+	s.authenticate(additionalData)
+	s.authenticate(out[:len(plaintext)])
+	tag := s.tag(len(additionalData), len(plaintext))
+	copy(out[len(plaintext):], tag[:])
+	return ret
+}
+
+var errMGMOpen = errors.New("mgm: message authentication failed")
+
+func (m *mgm) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < mgmTagSize {
+		return nil, errMGMOpen
+	}
+	s := m.start(nonce)
+	text := ciphertext[:len(ciphertext)-mgmTagSize]
+	s.authenticate(additionalData)
+	s.authenticate(text)
+	tag := s.tag(len(additionalData), len(text))
+	if subtle.ConstantTimeCompare(tag[:], ciphertext[len(text):]) != 1 {
+		return nil, errMGMOpen
+	}
+	ret, out := sliceForAppend(dst, len(text))
+	s.crypt(out, text)
+	return ret, nil
+}
@@ -0,0 +1,88 @@
+//go:build wg_gost
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestKuznyechikPi(t *testing.T) {
+	var seen [256]bool
+	for _, b := range kuznyechikPi {
+		if seen[b] {
+			t.Fatalf("%d appears twice in the S-box", b)
+		}
+		seen[b] = true
+	}
+}
+
+func TestKuznyechikBlock(t *testing.T) {
+	// GOST R 34.12-2015, appendix A.1.
+	c, err := newKuznyechik(decodeHex(t, "8899aabbccddeeff0011223344556677fedcba98765432100123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var src, dst [kuznyechikBlockSize]byte
+	copy(src[:], decodeHex(t, "1122334455667700ffeeddccbbaa9988"))
+	c.encrypt(&dst, &src)
+	if want := decodeHex(t, "7f679d90bebc24305a468d42b9d4edcd"); !bytes.Equal(dst[:], want) {
+		t.Errorf("ciphertext = %x, want %x", dst, want)
+	}
+}
+
+func TestKuznyechikMGMCipherSuite(t *testing.T) {
+	suite := LookupCipherSuite(KuznyechikMGMCipherSuite)
+	if suite == nil || !suite.Experimental {
+		t.Fatalf("suite not registered as experimental: %+v", suite)
+	}
+	aead, err := suite.New(decodeHex(t, "8899aabbccddeeff0011223344556677fedcba98765432100123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// RFC 9058, appendix A, for which the high bytes of its nonce are set
+	// directly.
+	ad := decodeHex(t, "0202020202020202010101010101010104040404040404040303030303030303ea0505050505050505")
+	plaintext := decodeHex(t, "1122334455667700ffeeddccbbaa998800112233445566778899aabbcceeff0a"+
+		"112233445566778899aabbcceeff0a002233445566778899aabbcceeff0a0011aabbcc")
+	want := decodeHex(t, "a9757b8147956e9055b8a33de89f42fc8075d2212bf9fd5bd3f7069aadc16b39"+
+		"497ab15915a6ba85936b5d0ea9f6851cc60c14d4d3f883d0ab94420695c76deb2c7552"+
+		"cf5d656f40c34f5c46e8bb0e29fcdb4c")
+	m := aead.(*mgm)
+	s := &mgmState{block: m.block}
+	var icn mgmBlock
+	copy(icn[:], decodeHex(t, "1122334455667700ffeeddccbbaa9988"))
+	m.block.encrypt(&s.y, &icn)
+	icn[0] |= 0x80
+	m.block.encrypt(&s.z, &icn)
+	sealed := make([]byte, len(plaintext)+mgmTagSize)
+	s.crypt(sealed, plaintext)
+	s.authenticate(ad)
+	s.authenticate(sealed[:len(plaintext)])
+	tag := s.tag(len(ad), len(plaintext))
+	copy(sealed[len(plaintext):], tag[:])
+	if !bytes.Equal(sealed, want) {
+		t.Errorf("sealed = %x, want %x", sealed, want)
+	}
+
+	// Transport messages are sealed and opened in place.
+	nonce := make([]byte, mgmNonceSize)
+	nonce[4] = 1
+	packet := append([]byte(nil), plaintext...)
+	sealed = aead.Seal(packet[:0], nonce, packet, ad)
+	opened, err := aead.Open(sealed[:0], nonce, sealed, ad)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Errorf("opened = %x, %v", opened, err)
+	}
+	sealed = aead.Seal(nil, nonce, plaintext, ad)
+	sealed[0] ^= 1
+	if _, err := aead.Open(nil, nonce, sealed, ad); err == nil {
+		t.Error("opened corrupted message")
+	}
+}
@@ -0,0 +1,59 @@
+//go:build wg_sm4
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSM4Block(t *testing.T) {
+	// GB/T 32907-2016, appendix A.
+	key := decodeHex(t, "0123456789abcdeffedcba9876543210")
+	block, err := newSM4(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dst := make([]byte, sm4BlockSize)
+	block.Encrypt(dst, key)
+	if want := decodeHex(t, "681edf34d206965e86b3e94f536e4246"); !bytes.Equal(dst, want) {
+		t.Errorf("ciphertext = %x, want %x", dst, want)
+	}
+	block.Decrypt(dst, dst)
+	if !bytes.Equal(dst, key) {
+		t.Errorf("decrypted = %x, want %x", dst, key)
+	}
+}
+
+func TestSM4GCMCipherSuite(t *testing.T) {
+	// RFC 8998, appendix A.1, with the key as the first half of a session key.
+	suite := LookupCipherSuite(SM4GCMCipherSuite)
+	if suite == nil || !suite.Experimental {
+		t.Fatalf("suite not registered as experimental: %+v", suite)
+	}
+	key := append(decodeHex(t, "0123456789abcdeffedcba9876543210"), make([]byte, 16)...)
+	aead, err := suite.New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce := decodeHex(t, "00001234567800000000abcd")
+	ad := decodeHex(t, "feedfacedeadbeeffeedfacedeadbeefabaddad2")
+	plaintext := decodeHex(t, "aaaaaaaaaaaaaaaabbbbbbbbbbbbbbbbccccccccccccccccdddddddddddddddd"+
+		"eeeeeeeeeeeeeeeeffffffffffffffffeeeeeeeeeeeeeeeeaaaaaaaaaaaaaaaa")
+	want := decodeHex(t, "17f399f08c67d5ee19d0dc9969c4bb7d5fd46fd3756489069157b282bb200735"+
+		"d82710ca5c22f0ccfa7cbf93d496ac15a56834cbcf98c397b4024a2691233b8d"+
+		"83de3541e4c2b58177e065a9bf7b62ec")
+	sealed := aead.Seal(nil, nonce, plaintext, ad)
+	if !bytes.Equal(sealed, want) {
+		t.Errorf("sealed = %x, want %x", sealed, want)
+	}
+	opened, err := aead.Open(nil, nonce, sealed, ad)
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Errorf("opened = %x, %v", opened, err)
+	}
+}
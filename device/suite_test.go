@@ -0,0 +1,319 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/poly1305"
+)
+
+// testCipherSuite is ChaCha20-Poly1305 under a hashed key, which does not
+// interoperate with the standard suite.
+const testCipherSuite = "TestHashedChaCha20Poly1305"
+
+func init() {
+	RegisterCipherSuite(CipherSuite{
+		Name:         testCipherSuite,
+		Experimental: true,
+		New: func(key []byte) (AEADSuite, error) {
+			hashed := blake2s.Sum256(key)
+			return chacha20poly1305.New(hashed[:])
+		},
+	})
+	RegisterCipherSuite(CipherSuite{
+		Name:         testBatchCipherSuite,
+		Experimental: true,
+		New: func(key []byte) (AEADSuite, error) {
+			aead, err := chacha20poly1305.New(key)
+			return batchAEAD{aead}, err
+		},
+	})
+	RegisterCipherSuite(CipherSuite{
+		Name:         testWideCipherSuite,
+		Experimental: true,
+		TagSize:      MaxTagSize,
+		New: func(key []byte) (AEADSuite, error) {
+			aead, err := chacha20poly1305.New(key)
+			return wideAEAD{aead}, err
+		},
+	})
+}
+
+// testWideCipherSuite is ChaCha20-Poly1305 with the 16-byte nonces of the
+// ChaCha20_24 experiment and tags of MaxTagSize bytes.
+const testWideCipherSuite = "TestWideChaCha20Poly1305"
+
+// wideAEAD hashes 16-byte nonces down to 12 bytes and extends tags with
+// bytes of the hash of the Poly1305 tag.
+type wideAEAD struct{ aead cipher.AEAD }
+
+func (wideAEAD) NonceSize() int { return chachaNonceSize }
+func (wideAEAD) Overhead() int  { return MaxTagSize }
+
+func (a wideAEAD) nonce(nonce []byte) []byte {
+	if len(nonce) != chachaNonceSize {
+		panic("wide AEAD: bad nonce length")
+	}
+	hashed := blake2s.Sum256(nonce)
+	return hashed[:chacha20poly1305.NonceSize]
+}
+
+func (a wideAEAD) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	ret := a.aead.Seal(dst, a.nonce(nonce), plaintext, additionalData)
+	ext := blake2s.Sum256(ret[len(ret)-poly1305.TagSize:])
+	return append(ret, ext[:MaxTagSize-poly1305.TagSize]...)
+}
+
+func (a wideAEAD) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	n := a.nonce(nonce)
+	if len(ciphertext) < MaxTagSize {
+		return nil, errors.New("wide AEAD: message too short")
+	}
+	sealed, ext := ciphertext[:len(ciphertext)-MaxTagSize+poly1305.TagSize], ciphertext[len(ciphertext)-MaxTagSize+poly1305.TagSize:]
+	if want := blake2s.Sum256(sealed[len(sealed)-poly1305.TagSize:]); !bytes.Equal(ext, want[:len(ext)]) {
+		return nil, errors.New("wide AEAD: message authentication failed")
+	}
+	return a.aead.Open(dst, n, sealed, additionalData)
+}
+
+// testBatchCipherSuite is ChaCha20-Poly1305 sealing batches in one call,
+// which it counts the messages of in batchSealed.
+const testBatchCipherSuite = "TestBatchChaCha20Poly1305"
+
+var batchSealed atomic.Int64
+
+type batchAEAD struct{ cipher.AEAD }
+
+func (a batchAEAD) SealBatch(entries []SealBatchEntry) {
+	batchSealed.Add(int64(len(entries)))
+	for i := range entries {
+		e := &entries[i]
+		e.Sealed = a.Seal(e.Dst, e.Nonce, e.Plaintext, e.AdditionalData)
+	}
+}
+
+// decodeHex decodes the hex of test vectors.
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestRegisterCipherSuite(t *testing.T) {
+	if names := CipherSuites(); !slices.Contains(names, StandardCipherSuite) || !slices.Contains(names, testCipherSuite) {
+		t.Errorf("registered suites = %v", names)
+	}
+	if LookupCipherSuite("nope") != nil {
+		t.Error("found unregistered suite")
+	}
+	for _, suite := range []CipherSuite{
+		{Name: StandardCipherSuite, New: newAEADSuite(chacha20poly1305.New)},
+		{Name: "RawXChaCha20Poly1305", New: newAEADSuite(chacha20poly1305.NewX)},
+		{Name: "NoConstructor"},
+		{Name: "TagSizeMismatch", New: newAEADSuite(chacha20poly1305.New), TagSize: 8},
+		{Name: "ShortTag", New: newAEADSuite(chacha20poly1305.New), TagSize: 4},
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering %s did not panic", suite.Name)
+				}
+			}()
+			RegisterCipherSuite(suite)
+		}()
+	}
+}
+
+func TestPeerCipherSuite(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+	// Let the next handshake initiation get a newer timestamp.
+	time.Sleep(50 * time.Millisecond)
+
+	suite := testCipherSuite
+	for i := range pair {
+		peerKey := pair[i^1].dev.staticIdentity.publicKey
+		if err := pair[i].dev.Configure(Config{Peers: []PeerConfig{{PublicKey: peerKey, CipherSuite: &suite}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	peer := pair[0].dev.LookupPeer(pair[1].dev.staticIdentity.publicKey)
+	if peer.CipherSuite() != testCipherSuite {
+		t.Errorf("pinned suite = %q", peer.CipherSuite())
+	}
+
+	// The pinned suite takes effect with the next session.
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+	if active := peer.ActiveCipherSuite(); active != testCipherSuite {
+		t.Errorf("active suite = %q, want %q", active, testCipherSuite)
+	}
+	status := pair[0].dev.Status()
+	if !status.Experimental || status.Peers[0].ActiveCipherSuite != testCipherSuite {
+		t.Errorf("status does not report the experimental suite: %+v", status)
+	}
+	cfg, err := pair[0].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"experimental=true", "cipher_suite=" + testCipherSuite, "active_cipher_suite=" + testCipherSuite} {
+		if !strings.Contains(cfg, line+"\n") {
+			t.Errorf("get output lacks %s:\n%s", line, cfg)
+		}
+	}
+	if entries, _ := pair[0].dev.AuditTrail(); len(entries) != 1 || entries[0].Setting != "cipher_suite" {
+		t.Errorf("unexpected audit trail %+v", entries)
+	}
+
+	if err := peer.SetCipherSuite("nope"); err == nil {
+		t.Error("pinned peer to unregistered suite")
+	}
+	if err := peer.SetCipherSuite(StandardCipherSuite); err != nil {
+		t.Fatal(err)
+	}
+	if status := pair[0].dev.Status(); status.Experimental {
+		t.Error("device still experimental with standard suites")
+	}
+}
+
+func TestPeerCipherSuiteUAPI(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	for i := range pair {
+		peerKey := pair[i^1].dev.staticIdentity.publicKey
+		if err := pair[i].dev.IpcSet(fmt.Sprintf("public_key=%x\ncipher_suite=%s\n", peerKey[:], testCipherSuite)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+	peerKey := pair[1].dev.staticIdentity.publicKey
+	peer := pair[0].dev.LookupPeer(peerKey)
+	if active := peer.ActiveCipherSuite(); active != testCipherSuite {
+		t.Errorf("active suite = %q, want %q", active, testCipherSuite)
+	}
+	entries, _ := pair[0].dev.AuditTrail()
+	if len(entries) != 1 || entries[0].Setting != "cipher_suite" || entries[0].Value != fmt.Sprintf("%x %s", peerKey[:], testCipherSuite) {
+		t.Errorf("unexpected audit trail %+v", entries)
+	}
+
+	if err := pair[0].dev.IpcSet(fmt.Sprintf("public_key=%x\ncipher_suite=nope\n", peerKey[:])); err == nil {
+		t.Error("pinned peer to unregistered suite")
+	}
+	if suite := peer.CipherSuite(); suite != testCipherSuite {
+		t.Errorf("pinned suite after failed set = %q", suite)
+	}
+	// Peers that are not created are left alone.
+	var other NoisePublicKey
+	other[0] = 1
+	if err := pair[0].dev.IpcSet(fmt.Sprintf("public_key=%x\nupdate_only=true\ncipher_suite=%s\n", other[:], StandardCipherSuite)); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := pair[0].dev.AuditTrail(); len(entries) != 1 {
+		t.Errorf("audited a peer that was not created: %+v", entries)
+	}
+}
+
+func TestTransportNonce(t *testing.T) {
+	var nonce [maxTransportNonceSize]byte
+	if n := transportNonce(&nonce, chachaNonceSize, 0x0807060504030201, 0x0c0b0a09, true); !bytes.Equal(n, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, chacha24FromInitiator, 0, 0, 0}) {
+		t.Errorf("16-byte nonce = %x", n)
+	}
+	// The standard layout clears what a longer nonce left in the buffer.
+	if n := transportNonce(&nonce, chacha20poly1305.NonceSize, 0x0807060504030201, 0x0c0b0a09, true); !bytes.Equal(n, []byte{0, 0, 0, 0, 1, 2, 3, 4, 5, 6, 7, 8}) {
+		t.Errorf("12-byte nonce = %x", n)
+	}
+}
+
+func TestWideCipherSuiteSession(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	suite := testWideCipherSuite
+	for i := range pair {
+		peerKey := pair[i^1].dev.staticIdentity.publicKey
+		if err := pair[i].dev.Configure(Config{Peers: []PeerConfig{{PublicKey: peerKey, CipherSuite: &suite}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+	peer := pair[0].dev.LookupPeer(pair[1].dev.staticIdentity.publicKey)
+	if active := peer.ActiveCipherSuite(); active != testWideCipherSuite {
+		t.Errorf("active suite = %q, want %q", active, testWideCipherSuite)
+	}
+	cfg, err := pair[0].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if line := fmt.Sprintf("active_tag_size=%d\n", MaxTagSize); !strings.Contains(cfg, line) {
+		t.Errorf("get output lacks %q:\n%s", line, cfg)
+	}
+}
+
+func TestSealBatch(t *testing.T) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	aead, _ := chacha20poly1305.New(key)
+	var nonces [3][chacha20poly1305.NonceSize]byte
+	var entries, want []SealBatchEntry
+	for i := range nonces {
+		nonces[i][4] = byte(i)
+		e := SealBatchEntry{Dst: []byte("header"), Nonce: nonces[i][:], Plaintext: bytes.Repeat([]byte{byte(i)}, 16*i), AdditionalData: []byte{byte(i)}}
+		e.Sealed = aead.Seal(bytes.Clone(e.Dst), e.Nonce, e.Plaintext, e.AdditionalData)
+		want = append(want, e)
+		e.Sealed = nil
+		entries = append(entries, e)
+	}
+	sealed := batchSealed.Load()
+	for _, suite := range []AEADSuite{aead, batchAEAD{aead}} {
+		batch := slices.Clone(entries)
+		for i := range batch {
+			batch[i].Dst = bytes.Clone(batch[i].Dst)
+		}
+		SealBatch(suite, batch)
+		for i := range batch {
+			if !bytes.Equal(batch[i].Sealed, want[i].Sealed) {
+				t.Errorf("%T sealed entry %d unlike Seal", suite, i)
+			}
+		}
+	}
+	if got := batchSealed.Load() - sealed; got != int64(len(entries)) {
+		t.Errorf("batch suite sealed %d entries in batches, want %d", got, len(entries))
+	}
+}
+
+func TestBatchCipherSuiteSession(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	suite := testBatchCipherSuite
+	for i := range pair {
+		peerKey := pair[i^1].dev.staticIdentity.publicKey
+		if err := pair[i].dev.Configure(Config{Peers: []PeerConfig{{PublicKey: peerKey, CipherSuite: &suite}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sealed := batchSealed.Load()
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+	if batchSealed.Load() == sealed {
+		t.Error("encryption workers did not seal in batches")
+	}
+}
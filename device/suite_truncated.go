@@ -0,0 +1,131 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/poly1305"
+)
+
+/* Truncated tags
+ *
+ * On constrained links, such as low-rate radios, the 16-byte tag of every
+ * transport message is a noticeable share of the bandwidth. The
+ * ChaCha20Poly1305-96 and ChaCha20Poly1305-64 suites are ChaCha20-Poly1305
+ * with its tag truncated to 12 and 8 bytes, for experiments trading
+ * integrity for bandwidth. A forgery of a truncated tag succeeds with
+ * probability 2^-96 or 2^-64 per try rather than about 2^-106, which the
+ * suites declare as their security level, and each failed try costs the
+ * receiver a decryption. Truncation to fewer than MinTagSize bytes is not
+ * offered.
+ */
+
+const (
+	TruncatedCipherSuite96 = "ChaCha20Poly1305-96"
+	TruncatedCipherSuite64 = "ChaCha20Poly1305-64"
+
+	poly1305SecurityBits = 106 // integrity level of untruncated Poly1305 tags on transport messages
+)
+
+func init() {
+	for _, tagSize := range []int{12, 8} {
+		name, digest := TruncatedCipherSuite96, "d9749f97ada1b88159509931f181f18f50de5e589be73d75d4f09f05a1ad5512"
+		if tagSize == 8 {
+			name, digest = TruncatedCipherSuite64, "593fa72a917ae4a06409a940c9bb66b73e066a0b4cb6b3fc4a96f8cd022df53f"
+		}
+		RegisterCipherSuite(CipherSuite{
+			Name:         name,
+			Experimental: true,
+			TagSize:      tagSize,
+			SecurityBits: 8 * tagSize,
+			New: func(key []byte) (AEADSuite, error) {
+				return newTruncatedChaCha20Poly1305(key, tagSize)
+			},
+			SelfTest: aeadSelfTest(digest),
+		})
+	}
+}
+
+var errTruncatedOpen = errors.New("chacha20poly1305: message authentication failed")
+
+// truncatedChaCha20Poly1305 is ChaCha20-Poly1305 with the tag truncated to
+// tagSize bytes.
+type truncatedChaCha20Poly1305 struct {
+	key     [chacha20poly1305.KeySize]byte
+	aead    cipher.AEAD
+	tagSize int
+}
+
+func newTruncatedChaCha20Poly1305(key []byte, tagSize int) (cipher.AEAD, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	a := &truncatedChaCha20Poly1305{aead: aead, tagSize: tagSize}
+	copy(a.key[:], key)
+	return a, nil
+}
+
+func (a *truncatedChaCha20Poly1305) NonceSize() int { return chacha20poly1305.NonceSize }
+func (a *truncatedChaCha20Poly1305) Overhead() int  { return a.tagSize }
+
+func (a *truncatedChaCha20Poly1305) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	ret := a.aead.Seal(dst, nonce, plaintext, additionalData)
+	return ret[:len(ret)-poly1305.TagSize+a.tagSize]
+}
+
+func (a *truncatedChaCha20Poly1305) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != chacha20poly1305.NonceSize {
+		panic("chacha20poly1305: bad nonce length passed to Open")
+	}
+	if len(ciphertext) < a.tagSize {
+		return nil, errTruncatedOpen
+	}
+	text, tag := ciphertext[:len(ciphertext)-a.tagSize], ciphertext[len(ciphertext)-a.tagSize:]
+
+	stream, _ := chacha20.NewUnauthenticatedCipher(a.key[:], nonce)
+	var polyKey [32]byte
+	stream.XORKeyStream(polyKey[:], polyKey[:])
+	mac := poly1305.New(&polyKey)
+	var pad [16]byte
+	mac.Write(additionalData)
+	mac.Write(pad[:(16-len(additionalData)%16)%16])
+	mac.Write(text)
+	mac.Write(pad[:(16-len(text)%16)%16])
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[0:], uint64(len(additionalData)))
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(text)))
+	mac.Write(lengths[:])
+	var sum [poly1305.TagSize]byte
+	mac.Sum(sum[:0])
+	if subtle.ConstantTimeCompare(sum[:a.tagSize], tag) != 1 {
+		return nil, errTruncatedOpen
+	}
+
+	ret, out := sliceForAppend(dst, len(text))
+	stream.SetCounter(1)
+	stream.XORKeyStream(out, text)
+	return ret, nil
+}
+
+// sliceForAppend extends in by n bytes, returning the whole slice and the
+// extension.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
@@ -0,0 +1,91 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func TestTruncatedChaCha20Poly1305(t *testing.T) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	nonce[4] = 1
+	full, _ := chacha20poly1305.New(key)
+	for _, name := range []string{TruncatedCipherSuite96, TruncatedCipherSuite64} {
+		suite := LookupCipherSuite(name)
+		aead, err := suite.New(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, size := range []int{0, 1, 15, 16, 17, 1420} {
+			plaintext := bytes.Repeat([]byte{0xa5}, size)
+			ad := []byte("header")[:size%7]
+			sealed := aead.Seal(nil, nonce, plaintext, ad)
+			if want := full.Seal(nil, nonce, plaintext, ad); !bytes.Equal(sealed, want[:size+suite.TagSize]) {
+				t.Fatalf("%s: sealed %d bytes is not the truncated standard seal", name, size)
+			}
+			opened, err := aead.Open(sealed[:0], nonce, sealed, ad)
+			if err != nil || !bytes.Equal(opened, plaintext) {
+				t.Fatalf("%s: open of %d bytes: %v", name, size, err)
+			}
+			sealed = aead.Seal(nil, nonce, plaintext, ad)
+			sealed[len(sealed)-1] ^= 1
+			if _, err := aead.Open(nil, nonce, sealed, ad); err == nil {
+				t.Fatalf("%s: opened %d bytes with a corrupted tag", name, size)
+			}
+		}
+		if _, err := aead.Open(nil, nonce, make([]byte, suite.TagSize-1), nil); err == nil {
+			t.Errorf("%s: opened a message shorter than its tag", name)
+		}
+	}
+	if suite := LookupCipherSuite(StandardCipherSuite); suite.Overhead() != 16 || suite.Security() != poly1305SecurityBits {
+		t.Errorf("standard suite tag size %d, security %d", suite.Overhead(), suite.Security())
+	}
+}
+
+func TestTruncatedCipherSuiteSession(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+	time.Sleep(50 * time.Millisecond)
+	suite := TruncatedCipherSuite64
+	for i := range pair {
+		peerKey := pair[i^1].dev.staticIdentity.publicKey
+		if err := pair[i].dev.Configure(Config{Peers: []PeerConfig{{PublicKey: peerKey, CipherSuite: &suite}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+
+	peer := pair[0].dev.LookupPeer(pair[1].dev.staticIdentity.publicKey)
+	if peer.ActiveCipherSuite() != TruncatedCipherSuite64 {
+		t.Fatalf("active suite = %q", peer.ActiveCipherSuite())
+	}
+	if ps := pair[0].dev.Status().Peers[0]; ps.ActiveTagSize != 8 || ps.ActiveSecurityBits != 64 {
+		t.Errorf("status tag size %d, security %d", ps.ActiveTagSize, ps.ActiveSecurityBits)
+	}
+	cfg, err := pair[0].dev.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"active_tag_size=8", "active_security_bits=64"} {
+		if !strings.Contains(cfg, "\n"+line+"\n") {
+			t.Errorf("get output lacks %s:\n%s", line, cfg)
+		}
+	}
+	if manifest := CryptoManifest(); !strings.Contains(manifest, "cipher_suite="+TruncatedCipherSuite64+"\ntag_size=8\nsecurity_bits=64\n") {
+		t.Errorf("manifest lacks the truncated suite:\n%s", manifest)
+	}
+}
@@ -0,0 +1,91 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"crypto/cipher"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+/* XChaCha20Poly1305
+ *
+ * The XChaCha20Poly1305 suite encrypts transport data with XChaCha20-Poly1305,
+ * whose 24-byte nonce HChaCha20 turns into a subkey and a ChaCha20 nonce,
+ * for comparing a large-nonce design against the 16-byte nonce of the
+ * ChaCha20_24 experiment. Transport messages carry only a 64-bit counter,
+ * so the nonce of each is built from it and a salt of the keypair:
+ *
+ *	bytes 0-15   salt
+ *	bytes 16-23  counter, little endian, as in the transport header
+ *
+ * The salt is the first 16 bytes of the BLAKE2s MAC of xchachaSaltLabel
+ * under the key of the direction, so that it is fresh and unpredictable for
+ * every keypair and both ends derive it without sending it. The suite takes
+ * the standard 12-byte transport nonces from the data plane and builds the
+ * 24-byte one from their counter, so transcripts and replicated keypairs,
+ * which carry keys only, need nothing more.
+ */
+
+const (
+	XChaCha20Poly1305CipherSuite = "XChaCha20Poly1305"
+
+	xchachaSaltSize  = chacha20poly1305.NonceSizeX - 8
+	xchachaSaltLabel = "xchacha20poly1305 transport salt"
+)
+
+func init() {
+	RegisterCipherSuite(CipherSuite{
+		Name:         XChaCha20Poly1305CipherSuite,
+		Experimental: true,
+		SecurityBits: poly1305SecurityBits,
+		New:          newAEADSuite(newXChaCha20Poly1305),
+		SelfTest:     aeadSelfTest("5117855681750d89bfc1726f8402a0093a2214b1e6abdd44cd1711a9a7168f90"),
+	})
+}
+
+// xchacha20Poly1305 is XChaCha20-Poly1305 under the nonces of the
+// XChaCha20Poly1305 suite.
+type xchacha20Poly1305 struct {
+	aead cipher.AEAD
+	salt [xchachaSaltSize]byte
+}
+
+func newXChaCha20Poly1305(key []byte) (cipher.AEAD, error) {
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	a := &xchacha20Poly1305{aead: aead}
+	mac, _ := blake2s.New256(key)
+	mac.Write([]byte(xchachaSaltLabel))
+	copy(a.salt[:], mac.Sum(nil))
+	return a, nil
+}
+
+func (a *xchacha20Poly1305) NonceSize() int { return chacha20poly1305.NonceSize }
+func (a *xchacha20Poly1305) Overhead() int  { return chacha20poly1305.Overhead }
+
+// nonce returns the XChaCha20 nonce of the standard transport nonce n.
+func (a *xchacha20Poly1305) nonce(n []byte) (nonce [chacha20poly1305.NonceSizeX]byte) {
+	if len(n) != chacha20poly1305.NonceSize {
+		panic("xchacha20poly1305: bad nonce length")
+	}
+	copy(nonce[:], a.salt[:])
+	copy(nonce[xchachaSaltSize:], n[4:])
+	return
+}
+
+func (a *xchacha20Poly1305) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	x := a.nonce(nonce)
+	return a.aead.Seal(dst, x[:], plaintext, additionalData)
+}
+
+func (a *xchacha20Poly1305) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	x := a.nonce(nonce)
+	return a.aead.Open(dst, x[:], ciphertext, additionalData)
+}
@@ -0,0 +1,73 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func TestXChaCha20Poly1305(t *testing.T) {
+	key := make([]byte, chacha20poly1305.KeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	aead, err := LookupCipherSuite(XChaCha20Poly1305CipherSuite).New(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nonce [maxTransportNonceSize]byte
+	n := transportNonce(&nonce, aead.NonceSize(), 0x0807060504030201, 0x0c0b0a09, true)
+
+	// The nonce is the salt under the key followed by the counter.
+	mac, _ := blake2s.New256(key)
+	mac.Write([]byte(xchachaSaltLabel))
+	want := append(mac.Sum(nil)[:xchachaSaltSize], 1, 2, 3, 4, 5, 6, 7, 8)
+	x, _ := chacha20poly1305.NewX(key)
+	plaintext, ad := []byte("transport data"), []byte("header")
+	sealed := aead.Seal(nil, n, plaintext, ad)
+	if !bytes.Equal(sealed, x.Seal(nil, want, plaintext, ad)) {
+		t.Fatal("sealed is not XChaCha20-Poly1305 under the salted nonce")
+	}
+	if opened, err := aead.Open(nil, n, sealed, ad); err != nil || !bytes.Equal(opened, plaintext) {
+		t.Fatalf("open: %q, %v", opened, err)
+	}
+	sealed[0] ^= 1
+	if _, err := aead.Open(nil, n, sealed, ad); err == nil {
+		t.Error("opened a corrupted message")
+	}
+
+	// Each key has its own salt.
+	key[0] ^= 1
+	other, _ := newXChaCha20Poly1305(key)
+	if other.(*xchacha20Poly1305).salt == aead.(*xchacha20Poly1305).salt {
+		t.Error("two keys share a salt")
+	}
+}
+
+func TestXChaCha20Poly1305Session(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+	time.Sleep(50 * time.Millisecond)
+	suite := XChaCha20Poly1305CipherSuite
+	for i := range pair {
+		peerKey := pair[i^1].dev.staticIdentity.publicKey
+		if err := pair[i].dev.Configure(Config{Peers: []PeerConfig{{PublicKey: peerKey, CipherSuite: &suite}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pair.Send(t, Ping, nil)
+	pair.Send(t, Pong, nil)
+	peer := pair[0].dev.LookupPeer(pair[1].dev.staticIdentity.publicKey)
+	if active := peer.ActiveCipherSuite(); active != XChaCha20Poly1305CipherSuite {
+		t.Errorf("active suite = %q, want %q", active, XChaCha20Poly1305CipherSuite)
+	}
+}
@@ -0,0 +1,92 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"fmt"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tai64n"
+)
+
+/* Handshake timestamp trust
+ *
+ * Handshake initiations carry the initiator's clock as a TAI64N timestamp,
+ * and a device only accepts an initiation whose timestamp is newer than that
+ * of the last one it accepted from the peer, so that recorded initiations
+ * cannot be replayed. A peer whose clock went back, or that once sent an
+ * initiation from a clock running far ahead, is thus ignored until its clock
+ * passes the timestamp recorded for it.
+ *
+ * A timestamp policy bounds how far from the local clock a trusted timestamp
+ * may be, and optionally accepts initiations with untrusted timestamps after
+ * a cookie round trip: such initiations are answered with a cookie reply,
+ * and accepted once retried with a valid MAC2. A MAC2 only shows that the
+ * sender received a cookie reply at its address within the last
+ * CookieRefreshTime, which anyone who knows the public key of the device
+ * can do, so it does not prove that the initiation is fresh. An untrusted
+ * timestamp is therefore still refused if it equals the last trusted one or
+ * is no newer than the last untrusted one accepted, and an initiation
+ * accepted with one is answered at the address it came from without moving
+ * the peer's endpoint there: the peer roams only once a data packet confirms
+ * the session, which a replayed initiation cannot lead to.
+ */
+
+// A TimestampPolicy sets which timestamps of handshake initiations are
+// trusted. The zero policy trusts any timestamp newer than the last one
+// accepted from the peer.
+type TimestampPolicy struct {
+	MaxSkew     time.Duration // if nonzero, timestamps further from the local clock are not trusted
+	AcceptStale bool          // accept initiations with untrusted timestamps after a cookie round trip
+}
+
+// SetTimestampPolicy sets the handshake timestamp policy of the device.
+func (device *Device) SetTimestampPolicy(policy TimestampPolicy) error {
+	if policy.MaxSkew < 0 {
+		return fmt.Errorf("negative maximum timestamp skew")
+	}
+	if policy == (TimestampPolicy{}) {
+		device.timestamps.Store(nil)
+		return nil
+	}
+	device.timestamps.Store(&policy)
+	return nil
+}
+
+// TimestampPolicy returns the policy set with SetTimestampPolicy.
+func (device *Device) TimestampPolicy() TimestampPolicy {
+	if policy := device.timestamps.Load(); policy != nil {
+		return *policy
+	}
+	return TimestampPolicy{}
+}
+
+// distrustTimestamp returns why the timestamp of an initiation from a peer
+// whose last accepted initiation had the timestamp last is not trusted, or
+// the empty string if it is.
+func (device *Device) distrustTimestamp(timestamp, last tai64n.Timestamp) string {
+	if !timestamp.After(last) {
+		return "replay"
+	}
+	if policy := device.timestamps.Load(); policy != nil && policy.MaxSkew != 0 {
+		skew := time.Since(timestamp.Time())
+		if skew < 0 {
+			skew = -skew
+		}
+		if skew > policy.MaxSkew {
+			return fmt.Sprintf("clock skew of %v", skew.Round(time.Second))
+		}
+	}
+	return ""
+}
+
+// initiationIsFresh reports whether stale timestamps are accepted and the
+// initiation in elem carries a valid MAC2. That does not rule out a replay,
+// which consumeMessageInitiation checks for.
+func (device *Device) initiationIsFresh(elem *QueueHandshakeElement) bool {
+	policy := device.timestamps.Load()
+	return policy != nil && policy.AcceptStale && device.cookieChecker.CheckMAC2(elem.packet, elem.endpoint.DstToBytes())
+}
@@ -0,0 +1,158 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/tai64n"
+)
+
+// shiftTimestamp returns t moved by d, at a precision of seconds.
+func shiftTimestamp(t tai64n.Timestamp, d time.Duration) tai64n.Timestamp {
+	binary.BigEndian.PutUint64(t[:], binary.BigEndian.Uint64(t[:])+uint64(int64(d/time.Second)))
+	return t
+}
+
+func TestDistrustTimestamp(t *testing.T) {
+	dev := randDevice(t)
+	defer dev.Close()
+	now := tai64n.Now()
+	past, future := shiftTimestamp(now, -time.Hour), shiftTimestamp(now, time.Hour)
+
+	if reason := dev.distrustTimestamp(now, now); reason != "replay" {
+		t.Errorf("distrust of repeated timestamp = %q", reason)
+	}
+	if reason := dev.distrustTimestamp(future, past); reason != "" {
+		t.Errorf("distrust without maximum skew = %q", reason)
+	}
+	if err := dev.SetTimestampPolicy(TimestampPolicy{MaxSkew: time.Minute}); err != nil {
+		t.Fatal(err)
+	}
+	if reason := dev.distrustTimestamp(future, past); !strings.HasPrefix(reason, "clock skew") {
+		t.Errorf("distrust of skewed timestamp = %q", reason)
+	}
+	if reason := dev.distrustTimestamp(now, past); reason != "" {
+		t.Errorf("distrust of current timestamp = %q", reason)
+	}
+	if err := dev.SetTimestampPolicy(TimestampPolicy{MaxSkew: -time.Second}); err == nil {
+		t.Error("negative maximum skew accepted")
+	}
+}
+
+func TestAcceptStaleTimestamp(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+	responder := pair[0].dev
+	peer := responder.LookupPeer(pair[1].dev.staticIdentity.publicKey)
+	initiator := pair[1].dev.LookupPeer(responder.staticIdentity.publicKey)
+
+	// The responder once accepted an initiation from a clock an hour ahead.
+	peer.handshake.mutex.Lock()
+	peer.handshake.lastTimestamp = shiftTimestamp(tai64n.Now(), time.Hour)
+	peer.handshake.mutex.Unlock()
+
+	_, err := initiator.Handshake(500 * time.Millisecond)
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Handshake with stale timestamp = %v, want ErrTimeout", err)
+	}
+
+	if err := responder.IpcSet("timestamp_accept_stale=true\ntimestamp_max_skew=300\n"); err != nil {
+		t.Fatal(err)
+	}
+	if policy := responder.TimestampPolicy(); policy != (TimestampPolicy{MaxSkew: 5 * time.Minute, AcceptStale: true}) {
+		t.Errorf("policy = %+v", policy)
+	}
+	cfg, err := responder.IpcGet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(cfg, "timestamp_max_skew=300\ntimestamp_accept_stale=true\n") {
+		t.Errorf("get output lacks timestamp policy:\n%s", cfg)
+	}
+
+	// The stale initiation is answered with a cookie reply, and its retry
+	// with a MAC2 accepted.
+	_, err = initiator.Handshake(500 * time.Millisecond)
+	if !errors.Is(err, ErrUnderLoad) {
+		t.Fatalf("Handshake without cookie = %v, want ErrUnderLoad", err)
+	}
+	if _, err := initiator.Handshake(5 * time.Second); err != nil {
+		t.Fatalf("Handshake after cookie round trip: %v", err)
+	}
+	pair.Send(t, Ping, nil)
+
+	if err := responder.IpcSet("timestamp_accept_stale=false\ntimestamp_max_skew=0\n"); err != nil {
+		t.Fatal(err)
+	}
+	if responder.timestamps.Load() != nil {
+		t.Errorf("zero policy stored: %+v", responder.TimestampPolicy())
+	}
+}
+
+func TestReplayStaleInitiation(t *testing.T) {
+	goroutineLeakCheck(t)
+	pair := genTestPair(t, false)
+	pair.Send(t, Ping, nil)
+	responder := pair[0].dev
+	peer := responder.LookupPeer(pair[1].dev.staticIdentity.publicKey)
+	initiator := pair[1].dev.LookupPeer(responder.staticIdentity.publicKey)
+	peer.endpoint.Lock()
+	from := peer.endpoint.val
+	peer.endpoint.Unlock()
+
+	peer.handshake.mutex.Lock()
+	peer.handshake.lastTimestamp = shiftTimestamp(tai64n.Now(), time.Hour)
+	peer.handshake.mutex.Unlock()
+	assertNil(t, responder.SetTimestampPolicy(TimestampPolicy{AcceptStale: true}))
+	if _, err := initiator.Handshake(500 * time.Millisecond); !errors.Is(err, ErrUnderLoad) {
+		t.Fatalf("Handshake without cookie = %v, want ErrUnderLoad", err)
+	}
+
+	// With the cookie, the initiator's next initiation carries a valid MAC2.
+	// The initiator is then taken down, so that it completes no session.
+	msg, err := pair[1].dev.CreateMessageInitiation(initiator)
+	assertNil(t, err)
+	packet := make([]byte, MessageInitiationSize)
+	msg.marshal(packet)
+	initiator.cookieGenerator.AddMacs(packet)
+	assertNil(t, pair[1].dev.Down())
+	assertNil(t, responder.IpcSet(fmt.Sprintf("public_key=%x\nendpoint=127.0.0.1:1\n", initiator.device.staticIdentity.publicKey[:])))
+
+	peer.handshake.mutex.RLock()
+	before := peer.handshake.lastInitiationConsumption
+	peer.handshake.mutex.RUnlock()
+	consumed := func() time.Time {
+		buf := responder.GetMessageBuffer()
+		n := copy(buf[:], packet)
+		responder.handleHandshake(QueueHandshakeElement{msgType: MessageInitiationType, packet: buf[:n], endpoint: from, buffer: buf})
+		peer.handshake.mutex.RLock()
+		defer peer.handshake.mutex.RUnlock()
+		return peer.handshake.lastInitiationConsumption
+	}
+	accepted := consumed()
+	if !accepted.After(before) {
+		t.Fatal("initiation with stale timestamp and valid MAC2 not accepted")
+	}
+	peer.endpoint.Lock()
+	endpoint := peer.endpoint.val.DstToString()
+	peer.endpoint.Unlock()
+	if endpoint != "127.0.0.1:1" {
+		t.Errorf("endpoint roamed to %s before the session was confirmed", endpoint)
+	}
+
+	// Replaying the same initiation, past the flood limit, is refused.
+	time.Sleep(2 * HandshakeInitationRate)
+	if replayed := consumed(); !replayed.Equal(accepted) {
+		t.Error("replayed initiation accepted")
+	}
+}
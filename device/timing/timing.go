@@ -0,0 +1,233 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package timing tests whether the verification of MAC tags takes time that
+// depends on secret data, with the method of dudect (Reparaz, Balasch and
+// Verbauwhede, "Dude, is my code constant time?", 2017): it times many
+// verifications of inputs of two classes, interleaved at random, and tests
+// with Welch's t-test whether the times of the two classes have the same
+// mean. A timing leak shows as a t that grows with the number of
+// measurements, while noise that affects both classes alike does not.
+//
+//   - The tag test verifies a fixed message under a fixed key, against its
+//     valid tag or a random one, for leaks of the comparison of tags, such
+//     as an early exit at the first byte that differs.
+//   - The message test verifies the valid tags of the all-zero message or of
+//     random messages under a fixed key, for leaks of the arithmetic of the
+//     MAC, such as a final reduction that branches on the accumulator.
+//
+// As outliers, such as a measurement preempted by the scheduler, hide leaks,
+// the t-test is repeated on the measurements below each of a range of
+// percentiles, and the largest |t| is reported. Like dudect, the tests can
+// only find leaks large enough to measure on the machine they run on; that
+// one finds none does not prove the verification constant time.
+package timing
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math"
+	mrand "math/rand/v2"
+	"runtime"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/poly1305"
+)
+
+// Threshold is the |t| past which a measurement shows a timing leak, that
+// which dudect takes as a definite one.
+const Threshold = 10
+
+// batch is the number of verifications timed together in each measurement,
+// so that a measurement lasts well past the resolution of the clock.
+const batch = 16
+
+// A Target is a MAC whose verification is measured.
+type Target struct {
+	Name    string
+	KeySize int
+	TagSize int
+	Sum     func(tag, key, msg []byte)
+	Verify  func(tag, key, msg []byte) bool
+}
+
+var targets []Target
+
+func register(t Target) {
+	targets = append(targets, t)
+}
+
+func init() {
+	register(Target{
+		Name:    "Poly1305",
+		KeySize: 32,
+		TagSize: poly1305.TagSize,
+		Sum: func(tag, key, msg []byte) {
+			poly1305.Sum((*[poly1305.TagSize]byte)(tag), msg, (*[32]byte)(key))
+		},
+		Verify: func(tag, key, msg []byte) bool {
+			return poly1305.Verify((*[poly1305.TagSize]byte)(tag), msg, (*[32]byte)(key))
+		},
+	})
+}
+
+// Targets returns the MACs of this build: Poly1305 in every build, and
+// Poly1795 and DoublePoly1305 in builds with the wg_experimental tag.
+func Targets() []Target {
+	return slices.Clone(targets)
+}
+
+// Config sets how a test measures.
+type Config struct {
+	Measurements int    // of each test, of batches of verifications
+	MessageSize  int    // bytes
+	Seed         uint64 // of the order of the classes
+}
+
+// A Test builds the inputs of the two classes of a measurement.
+type Test struct {
+	Name string
+	// Inputs fills in the tag and message of each measurement of the
+	// class in classes, for the key.
+	Inputs func(t Target, key []byte, classes []byte, tags, msgs [][]byte)
+}
+
+// Tests returns the tag and message tests.
+func Tests() []Test {
+	return []Test{{"tag", tagInputs}, {"message", messageInputs}}
+}
+
+func tagInputs(t Target, key []byte, classes []byte, tags, msgs [][]byte) {
+	msg := make([]byte, len(msgs[0]))
+	rand.Read(msg)
+	valid := make([]byte, t.TagSize)
+	t.Sum(valid, key, msg)
+	for i, class := range classes {
+		copy(msgs[i], msg)
+		if class == 0 {
+			copy(tags[i], valid)
+		} else {
+			rand.Read(tags[i])
+		}
+	}
+}
+
+func messageInputs(t Target, key []byte, classes []byte, tags, msgs [][]byte) {
+	for i, class := range classes {
+		if class == 0 {
+			clear(msgs[i])
+		} else {
+			rand.Read(msgs[i])
+		}
+		t.Sum(tags[i], key, msgs[i])
+	}
+}
+
+// A Result is the t-test of the times of a test of a target.
+type Result struct {
+	Target       string
+	Test         string
+	Measurements int
+	T            float64 // Welch's t of the crop of the largest |t|
+	Percentile   float64 // below which measurements were kept in that crop
+	Means        [2]time.Duration
+}
+
+// Leaks reports whether r shows a timing leak.
+func (r Result) Leaks() bool {
+	return math.Abs(r.T) > Threshold
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("%s %s: t=%.2f below the %.1fth percentile of %d measurements, means %v and %v",
+		r.Target, r.Test, r.T, r.Percentile*100, r.Measurements, r.Means[0], r.Means[1])
+}
+
+// Measure times the verifications of test on t.
+func Measure(t Target, test Test, cfg Config) Result {
+	if cfg.Measurements < 2 {
+		panic("timing: fewer than two measurements")
+	}
+	rng := mrand.New(mrand.NewPCG(cfg.Seed, uint64(cfg.Measurements)))
+	key := make([]byte, t.KeySize)
+	rand.Read(key)
+	classes := make([]byte, cfg.Measurements)
+	tags := make([][]byte, cfg.Measurements)
+	msgs := make([][]byte, cfg.Measurements)
+	for i := range classes {
+		classes[i] = byte(rng.IntN(2))
+		tags[i] = make([]byte, t.TagSize)
+		msgs[i] = make([]byte, cfg.MessageSize)
+	}
+	test.Inputs(t, key, classes, tags, msgs)
+
+	// Each input is copied to the same buffers before it is timed, so that
+	// where it is in memory is the same for both classes.
+	tag := make([]byte, t.TagSize)
+	msg := make([]byte, cfg.MessageSize)
+	times := make([]float64, cfg.Measurements)
+	var valid int
+	runtime.GC()
+	for i := range times {
+		copy(tag, tags[i])
+		copy(msg, msgs[i])
+		start := time.Now()
+		for range batch {
+			if t.Verify(tag, key, msg) {
+				valid++
+			}
+		}
+		times[i] = float64(time.Since(start))
+	}
+	runtime.KeepAlive(valid)
+
+	// The first measurements warm up caches and branch predictors.
+	warmup := cfg.Measurements / 100
+	r := welch(classes[warmup:], times[warmup:], math.Inf(1))
+	r.Target, r.Test, r.Percentile = t.Name, test.Name, 1
+	sorted := slices.Clone(times[warmup:])
+	slices.Sort(sorted)
+	for k := range 100 {
+		// The percentiles of dudect, closer to each other towards the top.
+		p := 1 - math.Pow(0.5, 10*float64(k+1)/100)
+		crop := welch(classes[warmup:], times[warmup:], sorted[int(p*float64(len(sorted)-1))])
+		if math.Abs(crop.T) > math.Abs(r.T) {
+			r.T, r.Percentile, r.Means = crop.T, p, crop.Means
+		}
+	}
+	r.Measurements = cfg.Measurements - warmup
+	return r
+}
+
+// welch returns Welch's t and the means of the times of the two classes,
+// of the times up to limit, with a Result of zero t if either class has
+// fewer than two.
+func welch(classes []byte, times []float64, limit float64) (r Result) {
+	var n [2]float64
+	var mean, m2 [2]float64
+	for i, x := range times {
+		if x > limit {
+			continue
+		}
+		c := classes[i]
+		n[c]++
+		delta := x - mean[c]
+		mean[c] += delta / n[c]
+		m2[c] += delta * (x - mean[c])
+	}
+	if n[0] < 2 || n[1] < 2 {
+		return
+	}
+	for c := range mean {
+		r.Means[c] = time.Duration(mean[c] / batch)
+	}
+	se := math.Sqrt(m2[0]/(n[0]-1)/n[0] + m2[1]/(n[1]-1)/n[1])
+	if se == 0 {
+		return
+	}
+	r.T = (mean[0] - mean[1]) / se
+	return
+}
@@ -0,0 +1,37 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package timing
+
+import "golang.zx2c4.com/wireguard/device"
+
+func init() {
+	register(Target{
+		Name:    "Poly1795",
+		KeySize: 32,
+		TagSize: device.Poly1795Size,
+		Sum: func(tag, key, msg []byte) {
+			device.Poly1795Sum((*[device.Poly1795Size]byte)(tag), msg, (*[32]byte)(key))
+		},
+		Verify: func(tag, key, msg []byte) bool {
+			return device.Poly1795Verify((*[device.Poly1795Size]byte)(tag), msg, (*[32]byte)(key))
+		},
+	})
+	register(Target{
+		Name:    "DoublePoly1305",
+		KeySize: 64,
+		TagSize: 32,
+		Sum: func(tag, key, msg []byte) {
+			device.DoublePoly1305((*[32]byte)(tag), msg, (*[64]byte)(key))
+		},
+		Verify: func(tag, key, msg []byte) bool {
+			mac := device.NewDoublePoly1305((*[64]byte)(key))
+			mac.Write(msg)
+			return mac.Verify(tag)
+		},
+	})
+}
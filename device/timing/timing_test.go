@@ -0,0 +1,87 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package timing
+
+import (
+	"math"
+	"testing"
+
+	"golang.org/x/crypto/poly1305"
+)
+
+var testConfig = Config{Measurements: 20000, MessageSize: 64, Seed: 1}
+
+// attempts is how many times a test is measured before a leak fails it, as
+// a leak shows in every measurement and a burst of noise seldom twice.
+const attempts = 3
+
+// measure returns the first result of test on target that shows no leak,
+// or the last one.
+func measure(target Target, test Test) (r Result) {
+	for range attempts {
+		if r = Measure(target, test, testConfig); !r.Leaks() {
+			break
+		}
+	}
+	return
+}
+
+func TestWelch(t *testing.T) {
+	classes := []byte{0, 0, 0, 0, 1, 1, 1, 1}
+	times := []float64{1, 2, 3, 4, 3, 4, 5, 6}
+	// Means 2.5 and 4.5, variances 5/3: t = -2 / sqrt(5/6).
+	if r := welch(classes, times, math.Inf(1)); math.Abs(r.T+2/math.Sqrt(5.0/6)) > 1e-9 {
+		t.Errorf("t = %v", r.T)
+	}
+	if r := welch(classes, times, 3); r.T != 0 {
+		t.Errorf("t of one measurement of class 1 = %v", r.T)
+	}
+}
+
+// leaky is Poly1305 with a comparison of tags that stops at the first byte
+// that differs, which the tag test must find.
+var leaky = Target{
+	Name:    "Poly1305 with an early exit",
+	KeySize: 32,
+	TagSize: 256,
+	Sum: func(tag, key, msg []byte) {
+		clear(tag)
+		poly1305.Sum((*[poly1305.TagSize]byte)(tag), msg, (*[32]byte)(key))
+	},
+	Verify: func(tag, key, msg []byte) bool {
+		var sum [256]byte
+		poly1305.Sum((*[poly1305.TagSize]byte)(sum[:]), msg, (*[32]byte)(key))
+		for i := range sum {
+			if sum[i] != tag[i] {
+				return false
+			}
+		}
+		return true
+	},
+}
+
+func TestLeakFound(t *testing.T) {
+	r := Measure(leaky, Tests()[0], testConfig)
+	t.Log(r)
+	if !r.Leaks() {
+		t.Errorf("no leak found in an early exit: %v", r)
+	}
+}
+
+func TestTargets(t *testing.T) {
+	if testing.Short() {
+		t.Skip("timing measurements in short mode")
+	}
+	for _, target := range Targets() {
+		for _, test := range Tests() {
+			r := measure(target, test)
+			t.Log(r)
+			if r.Leaks() {
+				t.Errorf("timing leak in %d attempts: %v", attempts, r)
+			}
+		}
+	}
+}
@@ -0,0 +1,361 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/blake2s"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+/* Session transcripts
+ *
+ * For research runs whose traffic is to be checked against other
+ * implementations of a cipher suite, a device can record a transcript of
+ * the sessions it establishes over a bounded window: the keys, indices and
+ * suite of each session, and for each transport packet sent or received
+ * with one of them its direction, counter, size and a BLAKE2s digest of
+ * its bytes on the wire. Payloads are not recorded. Replaying a capture of
+ * the same traffic against the transcript, VerifyTranscript finds each
+ * packet by its digest, opens it with the recorded key under a chosen
+ * suite, and seals the result again, so that the MAC and ciphertext of
+ * every packet are recomputed offline.
+ *
+ * A transcript holds every key of its sessions in the clear, and with a
+ * capture of their traffic decrypts all of it. It is for test traffic
+ * only. Sessions established before the transcript started are not in it,
+ * nor are their packets.
+ *
+ * The transcript is a text file of lines, each a key and its value:
+ *
+ *	transcript=1
+ *	start=<RFC 3339 time>
+ *	window=<duration>
+ *	session=<local index> <remote index> <initiator|responder> <peer public key> <suite> <sending key> <receiving key> <ns since start>
+ *	packet=<send|receive> <local index> <ns since start> <counter> <size> <digest>
+ *	end=<window|packets|stopped|closed> <ns since start>
+ *
+ * with indices, counters and sizes in decimal and keys and digests in hex.
+ * Packets refer to the last session line of their local index before
+ * them; as encryption and decryption run in parallel, they are not
+ * necessarily in order of their times.
+ */
+
+const transcriptVersion = 1
+
+type transcriptDirection string
+
+const (
+	transcriptSend    transcriptDirection = "send"
+	transcriptReceive transcriptDirection = "receive"
+)
+
+type transcript struct {
+	sync.Mutex
+	w        *bufio.Writer
+	start    time.Time
+	timer    *time.Timer
+	sessions map[uint32]bool // local indices of the sessions recorded
+	packets  int
+	ended    bool
+	err      error // first error writing the transcript
+}
+
+// RecordTranscript starts recording a transcript of the sessions the device
+// establishes and of their packets to w, for window. Only one transcript is
+// recorded at a time, until StopTranscript is called, even once its window
+// has ended.
+func (device *Device) RecordTranscript(w io.Writer, window time.Duration) error {
+	if window <= 0 || window > MaxTranscriptWindow {
+		return fmt.Errorf("transcript window %v out of range", window)
+	}
+	t := &transcript{w: bufio.NewWriter(w), start: time.Now(), sessions: make(map[uint32]bool)}
+	t.Lock()
+	defer t.Unlock()
+	if !device.transcript.CompareAndSwap(nil, t) {
+		return errors.New("a transcript is already being recorded")
+	}
+	t.printfLocked("transcript=%d\nstart=%s\nwindow=%v\n", transcriptVersion, t.start.UTC().Format(time.RFC3339Nano), window)
+	t.timer = time.AfterFunc(window, func() { t.end("window") })
+	return nil
+}
+
+// StopTranscript ends the transcript being recorded, if any, flushes it, and
+// returns the first error writing it.
+func (device *Device) StopTranscript() error {
+	t := device.transcript.Swap(nil)
+	if t == nil {
+		return nil
+	}
+	t.end("stopped")
+	t.Lock()
+	defer t.Unlock()
+	return t.err
+}
+
+// endTranscript ends the transcript being recorded, if any, leaving it to be
+// collected by StopTranscript.
+func (device *Device) endTranscript(reason string) {
+	if t := device.transcript.Load(); t != nil {
+		t.end(reason)
+	}
+}
+
+func (t *transcript) printfLocked(format string, args ...any) {
+	if t.err == nil {
+		_, t.err = fmt.Fprintf(t.w, format, args...)
+	}
+}
+
+func (t *transcript) end(reason string) {
+	t.Lock()
+	defer t.Unlock()
+	t.endLocked(reason)
+}
+
+func (t *transcript) endLocked(reason string) {
+	if t.ended {
+		return
+	}
+	t.ended = true
+	t.timer.Stop()
+	t.printfLocked("end=%s %d\n", reason, time.Since(t.start))
+	if err := t.w.Flush(); t.err == nil {
+		t.err = err
+	}
+}
+
+// recordTranscriptSession records a session established with peer in the
+// transcript being recorded, if any.
+func (device *Device) recordTranscriptSession(peer *Peer, suite *CipherSuite, localIndex, remoteIndex uint32, isInitiator bool, sendKey, recvKey *[chacha20poly1305.KeySize]byte) {
+	t := device.transcript.Load()
+	if t == nil {
+		return
+	}
+	role := "responder"
+	if isInitiator {
+		role = "initiator"
+	}
+	t.Lock()
+	defer t.Unlock()
+	if t.ended {
+		return
+	}
+	t.sessions[localIndex] = true
+	t.printfLocked("session=%d %d %s %x %s %x %x %d\n", localIndex, remoteIndex, role, peer.handshake.remoteStatic[:], suite.Name, sendKey[:], recvKey[:], time.Since(t.start))
+}
+
+// recordPacket records a transport packet of size bytes on the wire with
+// digest, sent or received with the session of localIndex.
+func (t *transcript) recordPacket(dir transcriptDirection, localIndex uint32, counter uint64, size int, digest *[blake2s.Size]byte) {
+	t.Lock()
+	defer t.Unlock()
+	if t.ended || !t.sessions[localIndex] {
+		return
+	}
+	t.printfLocked("packet=%s %d %d %d %d %x\n", dir, localIndex, time.Since(t.start), counter, size, digest[:])
+	t.packets++
+	if t.packets >= MaxTranscriptPackets {
+		t.endLocked("packets")
+	}
+}
+
+// TranscriptVerification is the outcome of verifying a transcript.
+type TranscriptVerification struct {
+	Sessions int    // sessions recorded
+	Packets  int    // packets recorded
+	End      string // why the transcript ended, or empty if it has no end
+	Verified int    // packets that opened and sealed again to the same ciphertext
+
+	Missing              int // packets not among those captured
+	Mismatched           int // packets whose captured header disagrees with their record
+	MACFailures          int // packets that failed to open
+	CiphertextMismatches int // packets that opened but sealed to a different ciphertext
+}
+
+// OK reports whether every packet recorded was verified.
+func (v TranscriptVerification) OK() bool {
+	return v.Verified == v.Packets
+}
+
+type transcriptSession struct {
+	remoteIndex uint32
+	isInitiator bool
+	send, recv  AEADSuite
+}
+
+// VerifyTranscript verifies the transcript read from r against the captured
+// packets, recomputing the MAC and ciphertext of each packet recorded under
+// the cipher suite named suite, or under that of its session if suite is
+// empty. Captured packets that are not recorded are ignored.
+func VerifyTranscript(r io.Reader, suite string, packets [][]byte) (TranscriptVerification, error) {
+	var v TranscriptVerification
+	var override *CipherSuite
+	if suite != "" {
+		if override = LookupCipherSuite(suite); override == nil {
+			return v, unknownCipherSuiteError(suite)
+		}
+	}
+	captured := make(map[[blake2s.Size]byte][]byte, len(packets))
+	for _, packet := range packets {
+		captured[blake2s.Sum256(packet)] = packet
+	}
+	sessions := make(map[uint32]*transcriptSession)
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			return v, fmt.Errorf("transcript line %d: missing '='", line)
+		}
+		var err error
+		switch key {
+		case "transcript":
+			if line != 1 || value != strconv.Itoa(transcriptVersion) {
+				err = fmt.Errorf("unsupported transcript %q", value)
+			}
+		case "start", "window":
+		case "session":
+			err = parseTranscriptSession(sessions, value, override)
+			v.Sessions++
+		case "packet":
+			err = verifyTranscriptPacket(&v, sessions, captured, value)
+			v.Packets++
+		case "end":
+			v.End, _, _ = strings.Cut(value, " ")
+		default:
+			err = fmt.Errorf("unknown key %q", key)
+		}
+		if err == nil && line == 1 && key != "transcript" {
+			err = errors.New("not a transcript")
+		}
+		if err != nil {
+			return v, fmt.Errorf("transcript line %d: %w", line, err)
+		}
+	}
+	return v, scanner.Err()
+}
+
+func parseTranscriptSession(sessions map[uint32]*transcriptSession, value string, override *CipherSuite) error {
+	fields := strings.Fields(value)
+	if len(fields) != 8 {
+		return errors.New("invalid session")
+	}
+	localIndex, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return err
+	}
+	remoteIndex, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return err
+	}
+	if fields[2] != "initiator" && fields[2] != "responder" {
+		return fmt.Errorf("invalid session role %q", fields[2])
+	}
+	suite := override
+	if suite == nil {
+		if suite = LookupCipherSuite(fields[4]); suite == nil {
+			return unknownCipherSuiteError(fields[4])
+		}
+	}
+	var sendKey, recvKey [chacha20poly1305.KeySize]byte
+	if err := decodeTranscriptKey(sendKey[:], fields[5]); err != nil {
+		return err
+	}
+	if err := decodeTranscriptKey(recvKey[:], fields[6]); err != nil {
+		return err
+	}
+	session := &transcriptSession{remoteIndex: uint32(remoteIndex), isInitiator: fields[2] == "initiator"}
+	var sendErr, recvErr error
+	session.send, sendErr = suite.New(sendKey[:])
+	session.recv, recvErr = suite.New(recvKey[:])
+	if err := errors.Join(sendErr, recvErr); err != nil {
+		return err
+	}
+	sessions[uint32(localIndex)] = session
+	return nil
+}
+
+func decodeTranscriptKey(dst []byte, s string) error {
+	if hex.DecodedLen(len(s)) != len(dst) {
+		return errors.New("invalid key length")
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
+
+func verifyTranscriptPacket(v *TranscriptVerification, sessions map[uint32]*transcriptSession, captured map[[blake2s.Size]byte][]byte, value string) error {
+	fields := strings.Fields(value)
+	if len(fields) != 6 {
+		return errors.New("invalid packet")
+	}
+	dir := transcriptDirection(fields[0])
+	if dir != transcriptSend && dir != transcriptReceive {
+		return fmt.Errorf("invalid direction %q", fields[0])
+	}
+	localIndex, err := strconv.ParseUint(fields[1], 10, 32)
+	if err != nil {
+		return err
+	}
+	session := sessions[uint32(localIndex)]
+	if session == nil {
+		return fmt.Errorf("packet of unknown session %d", localIndex)
+	}
+	counter, err := strconv.ParseUint(fields[3], 10, 64)
+	if err != nil {
+		return err
+	}
+	size, err := strconv.Atoi(fields[4])
+	if err != nil {
+		return err
+	}
+	var digest [blake2s.Size]byte
+	if err := decodeTranscriptKey(digest[:], fields[5]); err != nil {
+		return err
+	}
+
+	packet, ok := captured[digest]
+	if !ok {
+		v.Missing++
+		return nil
+	}
+	aead, receiver, fromInitiator := session.send, session.remoteIndex, session.isInitiator
+	if dir == transcriptReceive {
+		aead, receiver, fromInitiator = session.recv, uint32(localIndex), !session.isInitiator
+	}
+	if len(packet) != size || len(packet) < MessageTransportHeaderSize ||
+		binary.LittleEndian.Uint32(packet[:4]) != MessageTransportType ||
+		binary.LittleEndian.Uint32(packet[MessageTransportOffsetReceiver:]) != receiver ||
+		binary.LittleEndian.Uint64(packet[MessageTransportOffsetCounter:]) != counter {
+		v.Mismatched++
+		return nil
+	}
+	var buf [maxTransportNonceSize]byte
+	nonce := transportNonce(&buf, aead.NonceSize(), counter, receiver, fromInitiator)
+	content := packet[MessageTransportOffsetContent:]
+	plaintext, err := aead.Open(nil, nonce, content, nil)
+	if err != nil {
+		v.MACFailures++
+		return nil
+	}
+	if !bytes.Equal(aead.Seal(nil, nonce, plaintext, nil), content) {
+		v.CiphertextMismatches++
+		return nil
+	}
+	v.Verified++
+	return nil
+}
@@ -0,0 +1,141 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+)
+
+// captureBind is a bind keeping a copy of every packet sent with it.
+type captureBind struct {
+	conn.Bind
+	capture *packetCapture
+}
+
+type packetCapture struct {
+	sync.Mutex
+	packets [][]byte
+}
+
+func (b captureBind) Send(bufs [][]byte, ep conn.Endpoint) error {
+	b.capture.Lock()
+	for _, buf := range bufs {
+		b.capture.packets = append(b.capture.packets, bytes.Clone(buf))
+	}
+	b.capture.Unlock()
+	return b.Bind.Send(bufs, ep)
+}
+
+func (c *packetCapture) snapshot() [][]byte {
+	c.Lock()
+	defer c.Unlock()
+	return append([][]byte(nil), c.packets...)
+}
+
+func TestTranscript(t *testing.T) {
+	goroutineLeakCheck(t)
+	var capture packetCapture
+	binds := bindtest.NewChannelBinds()
+	pair := genTestPairBinds(t, [2]conn.Bind{captureBind{binds[0], &capture}, captureBind{binds[1], &capture}})
+
+	var transcript bytes.Buffer
+	if err := pair[0].dev.RecordTranscript(&transcript, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := pair[0].dev.RecordTranscript(&transcript, time.Minute); err == nil {
+		t.Error("second transcript started")
+	}
+	for range 3 {
+		pair.Send(t, Ping, nil)
+		pair.Send(t, Pong, nil)
+	}
+	if err := pair[0].dev.StopTranscript(); err != nil {
+		t.Fatal(err)
+	}
+	packets := capture.snapshot()
+
+	v, err := VerifyTranscript(bytes.NewReader(transcript.Bytes()), "", packets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Sessions != 1 || v.Packets < 6 || v.End != "stopped" || !v.OK() {
+		t.Errorf("verification = %+v\n%s", v, transcript.String())
+	}
+
+	// Under another suite, no packet opens.
+	v, err = VerifyTranscript(bytes.NewReader(transcript.Bytes()), TruncatedCipherSuite96, packets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.MACFailures != v.Packets || v.Verified != 0 {
+		t.Errorf("verification under %s = %+v", TruncatedCipherSuite96, v)
+	}
+
+	// Without the capture, every packet is missing.
+	v, err = VerifyTranscript(bytes.NewReader(transcript.Bytes()), "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Missing != v.Packets {
+		t.Errorf("verification without capture = %+v", v)
+	}
+
+	// A capture whose packets were altered no longer verifies.
+	for i, packet := range packets {
+		packets[i] = bytes.Clone(packet)
+		packets[i][len(packet)-1] ^= 1
+	}
+	v, err = VerifyTranscript(bytes.NewReader(transcript.Bytes()), "", packets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Missing != v.Packets {
+		t.Errorf("verification of altered capture = %+v", v)
+	}
+
+	if _, err := VerifyTranscript(strings.NewReader("start=now\n"), "", nil); err == nil {
+		t.Error("verified a file that is not a transcript")
+	}
+	if _, err := VerifyTranscript(bytes.NewReader(transcript.Bytes()), "NoSuchSuite", nil); err == nil {
+		t.Error("verified under an unknown suite")
+	}
+}
+
+func TestTranscriptWindow(t *testing.T) {
+	pair := genTestPair(t, false)
+	if err := pair[0].dev.RecordTranscript(new(bytes.Buffer), 2*MaxTranscriptWindow); err == nil {
+		t.Error("window beyond maximum accepted")
+	}
+
+	var transcript bytes.Buffer
+	if err := pair[0].dev.RecordTranscript(&transcript, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool {
+		t := pair[0].dev.transcript.Load()
+		t.Lock()
+		defer t.Unlock()
+		return t.ended
+	})
+	pair.Send(t, Ping, nil)
+	if err := pair[0].dev.StopTranscript(); err != nil {
+		t.Fatal(err)
+	}
+	v, err := VerifyTranscript(&transcript, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Sessions != 0 || v.Packets != 0 || v.End != "window" {
+		t.Errorf("verification = %+v", v)
+	}
+}
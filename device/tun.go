@@ -8,23 +8,58 @@ package device
 import (
 	"fmt"
 
+	"golang.org/x/crypto/poly1305"
+	"golang.org/x/net/ipv6"
+	"golang.zx2c4.com/wireguard/conn"
 	"golang.zx2c4.com/wireguard/tun"
 )
 
 const DefaultMTU = 1420
 
+// ethernetMTU is the path MTU the TUN device's MTU is checked against when
+// the bind or the cipher suite adds bytes to each datagram.
+const ethernetMTU = 1500
+
+// BindOverhead returns the number of bytes the bind adds to each datagram,
+// if it is a conn.BindOverhead, such as the records of a conn.DTLSBind.
+func (device *Device) BindOverhead() int {
+	if b, ok := device.net.bind.(conn.BindOverhead); ok {
+		return b.Overhead()
+	}
+	return 0
+}
+
+// MaxTunnelMTU returns the largest MTU of the TUN device whose packets fit
+// a path of pathMTU bytes once sent to a peer over IPv6, including the
+// bytes the bind adds and the tag of the device's cipher suite. It is
+// DefaultMTU for a 1500-byte path, a bind adding none and a suite adding
+// 16-byte tags.
+func (device *Device) MaxTunnelMTU(pathMTU int) int {
+	return pathMTU - ipv6.HeaderLen - 8 - MessageTransportHeaderSize - device.cipherSuite().Overhead() - device.BindOverhead()
+}
+
+// checkOverhead warns when the bind's overhead or a tag longer than
+// standard makes packets of the TUN device's MTU too large for an Ethernet
+// path.
+func (device *Device) checkOverhead(mtu int) {
+	overhead := device.BindOverhead() + device.cipherSuite().Overhead() - poly1305.TagSize
+	if max := device.MaxTunnelMTU(ethernetMTU); overhead > 0 && mtu > max {
+		device.log.tun.Verbosef("MTU %v too large for a %v-byte path with the %v bytes the bind and cipher suite add to each datagram; at most %v fits", mtu, ethernetMTU, overhead, max)
+	}
+}
+
 func (device *Device) RoutineTUNEventReader() {
-	device.log.Verbosef("Routine: event worker - started")
+	device.log.tun.Verbosef("Routine: event worker - started")
 
 	for event := range device.tun.device.Events() {
 		if event&tun.EventMTUUpdate != 0 {
 			mtu, err := device.tun.device.MTU()
 			if err != nil {
-				device.log.Errorf("Failed to load updated MTU of device: %v", err)
+				device.log.tun.Errorf("Failed to load updated MTU of device: %v", err)
 				continue
 			}
 			if mtu < 0 {
-				device.log.Errorf("MTU not updated to negative value: %v", mtu)
+				device.log.tun.Errorf("MTU not updated to negative value: %v", mtu)
 				continue
 			}
 			var tooLarge string
@@ -34,20 +69,21 @@ func (device *Device) RoutineTUNEventReader() {
 			}
 			old := device.tun.mtu.Swap(int32(mtu))
 			if int(old) != mtu {
-				device.log.Verbosef("MTU updated: %v%s", mtu, tooLarge)
+				device.log.tun.Verbosef("MTU updated: %v%s", mtu, tooLarge)
+				device.checkOverhead(mtu)
 			}
 		}
 
 		if event&tun.EventUp != 0 {
-			device.log.Verbosef("Interface up requested")
+			device.log.tun.Verbosef("Interface up requested")
 			device.Up()
 		}
 
 		if event&tun.EventDown != 0 {
-			device.log.Verbosef("Interface down requested")
+			device.log.tun.Verbosef("Interface down requested")
 			device.Down()
 		}
 	}
 
-	device.log.Verbosef("Routine: event worker - stopped")
+	device.log.tun.Verbosef("Routine: event worker - stopped")
 }
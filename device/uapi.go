@@ -8,16 +8,21 @@ package device
 import (
 	"bufio"
 	"bytes"
+	"context"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net"
 	"net/netip"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"golang.zx2c4.com/wireguard/conn"
 	"golang.zx2c4.com/wireguard/ipc"
 )
 
@@ -49,6 +54,61 @@ var byteBufferPool = &sync.Pool{
 // IpcGetOperation implements the WireGuard configuration protocol "get" operation.
 // See https://www.wireguard.com/xplatform/#configuration-protocol for details.
 func (device *Device) IpcGetOperation(w io.Writer) error {
+	return device.ipcGetOperation(w, false, ipcPage{})
+}
+
+// IpcGetPageOperation implements the paginated "get" operation. It lists up
+// to pageSize peers whose public keys follow cursor, in order. The first
+// page, with an empty cursor, starts with the device's values, and every
+// page but the last ends with a next_page key holding the cursor of the next
+// one. Each page is a consistent snapshot of its peers, taken while holding
+// the device's locks only as long as it takes to list them.
+func (device *Device) IpcGetPageOperation(w io.Writer, cursor string, pageSize int) error {
+	if pageSize < 1 || pageSize > MaxPageSize {
+		return ipcErrorf(ipc.IpcErrorInvalid, "invalid page size %d, must be between 1 and %d", pageSize, MaxPageSize)
+	}
+	page := ipcPage{size: pageSize}
+	if cursor != "" {
+		page.after = new(NoisePublicKey)
+		if err := page.after.FromHex(cursor); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid page cursor: %w", err)
+		}
+	}
+	return device.ipcGetOperation(w, false, page)
+}
+
+// An ipcPage selects the peers listed by a get operation.
+type ipcPage struct {
+	after *NoisePublicKey // list peers with greater public keys, without the device's values; nil for the first page
+	size  int             // maximum number of peers listed; zero for all of them
+}
+
+// pagePeersLocked returns the peers of page, and the cursor of the next page
+// if any. The peers are sorted by public key, unless page lists all of them.
+func (device *Device) pagePeersLocked(page ipcPage) (peers []*Peer, next *NoisePublicKey) {
+	keys := make([]NoisePublicKey, 0, len(device.peers.keyMap))
+	for pk := range device.peers.keyMap {
+		if page.after == nil || bytes.Compare(pk[:], page.after[:]) > 0 {
+			keys = append(keys, pk)
+		}
+	}
+	if page.size != 0 {
+		slices.SortFunc(keys, func(a, b NoisePublicKey) int { return bytes.Compare(a[:], b[:]) })
+		if len(keys) > page.size {
+			keys = keys[:page.size]
+			next = &keys[page.size-1]
+		}
+	}
+	peers = make([]*Peer, len(keys))
+	for i, pk := range keys {
+		peers[i] = device.peers.keyMap[pk]
+	}
+	return peers, next
+}
+
+// ipcGetOperation implements IpcGetOperation and IpcGetPageOperation. If
+// redact is set, private and preshared keys are omitted from the output.
+func (device *Device) ipcGetOperation(w io.Writer, redact bool, page ipcPage) error {
 	device.ipcMutex.RLock()
 	defer device.ipcMutex.RUnlock()
 
@@ -83,25 +143,178 @@ func (device *Device) IpcGetOperation(w io.Writer) error {
 		device.peers.RLock()
 		defer device.peers.RUnlock()
 
-		// serialize device related values
+		// serialize device related values, on the first page only
+		if page.after == nil {
+			if device.isExperimentalLocked() {
+				sendf("experimental=true")
+			}
+			entries, dropped := device.AuditTrail()
+			for _, e := range entries {
+				sendf("audit=%s %s %s=%s", e.Time.UTC().Format(time.RFC3339Nano), e.Caller, e.Setting, e.Value)
+			}
+			if dropped != 0 {
+				sendf("audit_dropped=%d", dropped)
+			}
 
-		if !device.staticIdentity.privateKey.IsZero() {
-			keyf("private_key", (*[32]byte)(&device.staticIdentity.privateKey))
-		}
+			if !redact && !device.staticIdentity.privateKey.IsZero() {
+				keyf("private_key", (*[32]byte)(&device.staticIdentity.privateKey))
+			}
 
-		if device.net.port != 0 {
-			sendf("listen_port=%d", device.net.port)
-		}
+			if !redact {
+				if secret := device.KnockSecret(); !isZero(secret[:]) {
+					keyf("knock_secret", &secret)
+				}
+			}
+
+			if device.net.port != 0 {
+				sendf("listen_port=%d", device.net.port)
+			}
+			if len(device.net.ports) != 0 {
+				ports := make([]string, 0, len(device.net.ports)+1)
+				ports = append(ports, strconv.FormatUint(uint64(device.net.port), 10))
+				for _, port := range device.net.ports {
+					ports = append(ports, strconv.FormatUint(uint64(port), 10))
+				}
+				sendf("listen_ports=%s", strings.Join(ports, ","))
+			}
+			for _, ps := range device.portStatusLocked() {
+				sendf("port_rx_bytes=%d:%d", ps.Port, ps.ReceiveBytes)
+				sendf("port_tx_bytes=%d:%d", ps.Port, ps.TransmitBytes)
+			}
+
+			if device.net.fwmark != 0 {
+				sendf("fwmark=%d", device.net.fwmark)
+			}
+
+			if device.staticIdentity.construction != NoiseConstruction {
+				sendf("noise_construction=%s", device.staticIdentity.construction)
+			}
+			if device.staticIdentity.identifier != WGIdentifier {
+				sendf("noise_identifier=%s", device.staticIdentity.identifier)
+			}
+			if h := device.handshakeHash(); h != blake2sHandshakeHash {
+				sendf("handshake_hash=%s", h.Name())
+			}
+			if overhead := device.BindOverhead(); overhead != 0 {
+				sendf("bind_overhead=%d", overhead)
+			}
+			if padding := device.HandshakePadding(); padding != 0 {
+				sendf("handshake_padding=%d", padding)
+			}
+			if suite := device.CipherSuite(); suite != StandardCipherSuite {
+				sendf("cipher_suite=%s", suite)
+			}
+			if mac := device.CookieMAC(); mac != CookieMACBLAKE2s {
+				sendf("cookie_mac=%s", mac)
+			}
+			if slots := device.KeystreamPrecompute(); slots != 0 {
+				sendf("keystream_precompute=%d", slots)
+			}
+			if rounds := device.ChaChaRounds(); rounds != 0 {
+				sendf("chacha_rounds=%d", rounds)
+			}
+			if device.ChaChaRoundsForce() {
+				sendf("chacha_rounds_force=true")
+			}
+			for _, name := range LogSubsystems() {
+				if s := device.log.subsystem(name); s.level.Level() != s.initial {
+					sendf("log_level=%s:%s", name, logLevelNames[device.LogLevel(name)])
+				}
+			}
+			if stats := device.KeystreamStats(); !stats.isZero() {
+				sendf("keystream_hits=%d", stats.Hits)
+				sendf("keystream_misses=%d", stats.Misses)
+			}
+
+			if stats := device.CookieStats(); !stats.isZero() {
+				if stats.UnderLoad {
+					sendf("under_load=true")
+				}
+				sendf("cookie_replies_sent=%d", stats.RepliesSent)
+				sendf("cookie_valid_mac2=%d", stats.ValidMAC2)
+				sendf("cookie_rate_limited=%d", stats.RateLimited)
+				sendf("cookie_replies_received=%d", stats.RepliesReceived)
+				for _, tr := range stats.Transitions {
+					state := "leave"
+					if tr.UnderLoad {
+						state = "enter"
+					}
+					sendf("under_load_transition=%s %s", tr.Time.UTC().Format(time.RFC3339Nano), state)
+				}
+				if stats.DroppedTransitions != 0 {
+					sendf("under_load_transitions_dropped=%d", stats.DroppedTransitions)
+				}
+			}
+
+			if stats := device.CongestionStats(); !stats.isZero() {
+				if stats.Congested {
+					sendf("congested=true")
+				}
+				sendf("congestion_send_failures=%d", stats.SendFailures)
+				sendf("congestion_backoffs=%d", stats.Backoffs)
+				sendf("congestion_marked=%d", stats.Marked)
+			}
+			if crashes := device.WorkerCrashes(); crashes != 0 {
+				sendf("worker_crashes=%d", crashes)
+			}
+			if scheduled := device.EncryptionScheduled(); scheduled != 0 {
+				sendf("encryption_scheduled=%d", scheduled)
+			}
+			if device.CoreAffinity() {
+				sendf("core_affinity=true")
+			}
+			for _, core := range device.CoreStats() {
+				sendf("core_stats=%s:%d:%d:%d", core.Role, core.CPU, core.Packets, core.Bytes)
+			}
+			if policy := device.ICMPErrorPolicy(); policy != ICMPErrorsIgnore {
+				sendf("icmp_errors=%v", policy)
+			}
+			if addr := device.nested.addr; addr.IsValid() {
+				sendf("nested_address=%v", addr)
+			}
+			if n := device.NestedHandoffs(); n != 0 {
+				sendf("nested_handoffs=%d", n)
+			}
 
-		if device.net.fwmark != 0 {
-			sendf("fwmark=%d", device.net.fwmark)
+			if policy := device.PacketTracePolicy(); policy.Size != 0 {
+				sendf("packet_trace_size=%d", policy.Size)
+				sendf("packet_trace_sample=%d", policy.Sample)
+			}
+			if policy := device.QuarantinePolicy(); policy.MaxErrors != 0 {
+				sendf("quarantine_max_errors=%d", policy.MaxErrors)
+				sendf("quarantine_window=%d", int(policy.Window/time.Second))
+				sendf("quarantine_cooldown=%d", int(policy.Cooldown/time.Second))
+			}
+			if policy := device.TimestampPolicy(); policy != (TimestampPolicy{}) {
+				sendf("timestamp_max_skew=%d", int(policy.MaxSkew/time.Second))
+				sendf("timestamp_accept_stale=%t", policy.AcceptStale)
+			}
+			if shard := device.IndexShard(); shard.Bits != 0 {
+				sendf("index_shard=%v", shard)
+			}
 		}
 
-		for _, peer := range device.peers.keyMap {
+		peers, next := device.pagePeersLocked(page)
+		for _, peer := range peers {
 			// Serialize peer state.
 			peer.handshake.mutex.RLock()
 			keyf("public_key", (*[32]byte)(&peer.handshake.remoteStatic))
-			keyf("preshared_key", (*[32]byte)(&peer.handshake.presharedKey))
+			if !redact {
+				keyf("preshared_key", (*[32]byte)(&peer.handshake.presharedKey))
+			}
+			if rotator := &peer.handshake.pskRotator; peer.handshake.rotating() {
+				if !redact {
+					keyf("psk_rotation_root", (*[32]byte)(&rotator.Root))
+				}
+				sendf("psk_rotation_interval=%d", int64(rotator.Interval/time.Second))
+				sendf("psk_rotation_skew=%d", int64(rotator.MaxSkew/time.Second))
+			}
+			if !isZero(peer.handshake.channelBinding[:]) {
+				keyf("channel_binding", &peer.handshake.channelBinding)
+			}
+			if !peer.mac1PublicKey.IsZero() {
+				keyf("mac1_public_key", (*[32]byte)(&peer.mac1PublicKey))
+			}
 			peer.handshake.mutex.RUnlock()
 			sendf("protocol_version=1")
 			peer.endpoint.Lock()
@@ -119,12 +332,190 @@ func (device *Device) IpcGetOperation(w io.Writer) error {
 			sendf("tx_bytes=%d", peer.txBytes.Load())
 			sendf("rx_bytes=%d", peer.rxBytes.Load())
 			sendf("persistent_keepalive_interval=%d", peer.persistentKeepaliveInterval.Load())
+			if mtu := peer.mssClampMTU.Load(); mtu != 0 {
+				sendf("mss_clamp_mtu=%d", mtu)
+			}
+			if age := peer.MaxQueueAge(); age != 0 {
+				sendf("max_queue_age_ms=%d", age/time.Millisecond)
+			}
+			if n := peer.StaleDrops(); n != 0 {
+				sendf("stale_drops=%d", n)
+			}
+			switch rate := peer.PacingRate(); rate {
+			case 0:
+			case PacingAuto:
+				sendf("pacing_rate=auto")
+			default:
+				sendf("pacing_rate=%d", rate)
+			}
+			if estimate := peer.PacingEstimate(); estimate != 0 {
+				sendf("pacing_estimate=%d", estimate)
+			}
+			if size, policy := peer.StagedQueue(); size != 0 || policy != EvictOldest {
+				sendf("staged_queue_size=%d", size)
+				sendf("staged_queue_policy=%v", policy)
+			}
+			for policy := range numEvictionPolicies {
+				if n := peer.StagedDrops(policy); n != 0 {
+					sendf("staged_dropped_%v=%d", policy, n)
+				}
+			}
+			sizes := peer.PacketSizes()
+			if slices.ContainsFunc(sizes.Buckets, func(b PacketSizeBucket) bool { return b.Count != 0 }) {
+				counts := make([]string, len(sizes.Buckets))
+				for i, b := range sizes.Buckets {
+					max := "inf"
+					if b.Max != 0 {
+						max = strconv.Itoa(b.Max)
+					}
+					counts[i] = fmt.Sprintf("%s:%d", max, b.Count)
+				}
+				sendf("packet_sizes=%s", strings.Join(counts, ","))
+			}
+			if sizes.FullSize != 0 || sizes.TooBig != 0 {
+				sendf("full_size_packets=%d", sizes.FullSize)
+				sendf("full_size_retransmits=%d", sizes.Retransmits)
+				sendf("icmp_too_big=%d", sizes.TooBig)
+				if sizes.MTUSuspected {
+					sendf("mtu_suspected=true")
+				}
+			}
+			if forecast := peer.KeyUsageForecast(); forecast.Rate > 0 {
+				sendf("key_send_rate=%.0f", forecast.Rate)
+				sendf("key_exhaustion_forecast=%d", int64(forecast.TimeToLimit/time.Second))
+				sendf("key_rekey_margin=%d", int64(forecast.Margin/time.Second))
+			}
+			if limit := peer.InboundLimit(); limit != (InboundLimit{}) {
+				sendf("inbound_packet_rate=%d", limit.PacketRate)
+				sendf("inbound_byte_rate=%d", limit.ByteRate)
+				sendf("inbound_failed_rate=%d", limit.FailedRate)
+			}
+			if rate, failed := peer.InboundLimited(); rate != 0 || failed != 0 {
+				sendf("inbound_rate_limited=%d", rate)
+				sendf("inbound_failed_limited=%d", failed)
+			}
+			if weight := peer.EncryptionWeight(); weight != DefaultEncryptionWeight {
+				sendf("encryption_weight=%d", weight)
+			}
+			if crashes := peer.WorkerCrashes(); crashes != 0 {
+				sendf("worker_crashes=%d", crashes)
+			}
+			if peer.eagerKeyErasure.Load() {
+				sendf("eager_key_erasure=true")
+			}
+			if peer.endpointFallback.Load() {
+				sendf("endpoint_fallback=true")
+				for _, ep := range peer.PreviousEndpoints() {
+					sendf("previous_endpoint=%s", ep)
+				}
+			}
+			if policy := peer.SwitchPolicy(); policy.ProbeInterval != 0 {
+				sendf("switch_probe_interval=%d", int(policy.ProbeInterval/time.Second))
+				sendf("switch_min_improvement=%d", policy.MinImprovement)
+				sendf("switch_dwell=%d", int(policy.Dwell/time.Second))
+				if !peer.endpointFallback.Load() {
+					for _, ep := range peer.PreviousEndpoints() {
+						sendf("previous_endpoint=%s", ep)
+					}
+				}
+				rtts := peer.EndpointRTTs()
+				for _, ep := range slices.Sorted(maps.Keys(rtts)) {
+					sendf("endpoint_rtt=%s %d", ep, rtts[ep].Nanoseconds())
+				}
+			}
+			if name := peer.Name(); name != "" {
+				sendf("name=%s", name)
+			}
+			if suite, active := peer.CipherSuite(), peer.ActiveCipherSuite(); suite != StandardCipherSuite || (active != "" && active != StandardCipherSuite) {
+				sendf("cipher_suite=%s", suite)
+				if active != "" {
+					sendf("active_cipher_suite=%s", active)
+				}
+				if keypair := peer.keypairs.Current(); keypair != nil {
+					sendf("active_tag_size=%d", keypair.suite.Overhead())
+					sendf("active_security_bits=%d", keypair.suite.Security())
+				}
+			}
+			if offer := peer.CipherSuiteOffer(); offer != nil {
+				sendf("cipher_suite_offer=%s", strings.Join(offer, ","))
+			}
+			if mode := peer.PQMode(); mode != PQModeOff {
+				sendf("pq_mode=%s", mode)
+			}
+			if peer.HybridSession() {
+				sendf("hybrid_session=true")
+			}
+			if n := peer.quarantine.decryptFailures.Load(); n != 0 {
+				sendf("decrypt_failures=%d", n)
+			}
+			if n := peer.quarantine.replayHits.Load(); n != 0 {
+				sendf("replay_hits=%d", n)
+			}
+			if reorder := peer.ReorderStats(); reorder.Late != 0 || reorder.OutOfWindow != 0 || reorder.Gaps != 0 {
+				sendf("reorder_late=%d", reorder.Late)
+				sendf("reorder_out_of_window=%d", reorder.OutOfWindow)
+				sendf("reorder_duplicates=%d", reorder.Duplicates)
+				sendf("reorder_gaps=%d", reorder.Gaps)
+				sendf("reorder_max_behind=%d", reorder.MaxBehind)
+				sendf("reorder_max_ahead=%d", reorder.MaxAhead)
+				sendf("reorder_behind=%s", formatReorderBuckets(reorder.Behind))
+				sendf("reorder_ahead=%s", formatReorderBuckets(reorder.Ahead))
+			}
+			if n := peer.quarantine.malformedPackets.Load(); n != 0 {
+				sendf("malformed_packets=%d", n)
+			}
+			if until := peer.QuarantinedUntil(); !until.IsZero() {
+				sendf("quarantined_until_sec=%d", until.Unix())
+			}
+			if n := peer.ICMPErrors(); n != 0 {
+				sendf("icmp_errors_received=%d", n)
+			}
+			if until := peer.PathUnreachableUntil(); !until.IsZero() {
+				sendf("path_unreachable_until_sec=%d", until.Unix())
+			}
+			if metadata := peer.metadata.Load(); metadata != nil {
+				sendf("metadata=%x", *metadata)
+			}
+			if n := peer.metadataRejections.Load(); n != 0 {
+				sendf("metadata_rejections=%d", n)
+			}
+			if data := peer.responseData.send.Load(); data != nil {
+				sendf("response_data=%x", *data)
+			}
+			if data := peer.responseData.received.Load(); data != nil {
+				sendf("received_response_data=%x", *data)
+			}
+			peer.assignment.Lock()
+			for _, prefix := range peer.assignment.offer.Addresses {
+				sendf("assign_address=%s", prefix)
+			}
+			for _, ip := range peer.assignment.offer.DNS {
+				sendf("assign_dns=%s", ip)
+			}
+			if peer.acceptAssignment.Load() {
+				sendf("accept_assignment=true")
+			}
+			for _, prefix := range peer.assignment.accepted {
+				sendf("accept_assignment_subnet=%s", prefix)
+			}
+			if a := peer.assignment.received; a != nil {
+				for _, prefix := range a.Addresses {
+					sendf("assigned_address=%s", prefix)
+				}
+				for _, ip := range a.DNS {
+					sendf("assigned_dns=%s", ip)
+				}
+			}
+			peer.assignment.Unlock()
 
 			device.allowedips.EntriesForPeer(peer, func(prefix netip.Prefix) bool {
 				sendf("allowed_ip=%s", prefix.String())
 				return true
 			})
 		}
+		if next != nil {
+			keyf("next_page", (*[32]byte)(next))
+		}
 	}()
 
 	// send lines (does not require resource locks)
@@ -138,8 +529,32 @@ func (device *Device) IpcGetOperation(w io.Writer) error {
 // IpcSetOperation implements the WireGuard configuration protocol "set" operation.
 // See https://www.wireguard.com/xplatform/#configuration-protocol for details.
 func (device *Device) IpcSetOperation(r io.Reader) (err error) {
-	device.ipcMutex.Lock()
-	defer device.ipcMutex.Unlock()
+	return device.IpcSetOperationContext(context.Background(), r)
+}
+
+// IpcSetOperationContext is IpcSetOperation, giving up when ctx is done. The
+// lines read until then stay applied, as they do when a later line fails.
+func (device *Device) IpcSetOperationContext(ctx context.Context, r io.Reader) (err error) {
+	return device.ipcSetOperation(ctx, r, "api")
+}
+
+// ipcSetOperation is IpcSetOperationContext on behalf of caller, who is
+// named in the audit trail.
+func (device *Device) ipcSetOperation(ctx context.Context, r io.Reader, caller string) (err error) {
+	scanner := bufio.NewScanner(r)
+	more := scanner.Scan()
+
+	// Device keys may only precede the first public_key line, so the first line
+	// tells us whether this operation touches device-wide state. Operations that
+	// only touch peers share the IPC lock with gets and other peer operations,
+	// and serialize on the affected peers' own locks instead.
+	if more && strings.HasPrefix(scanner.Text(), "public_key=") {
+		device.ipcMutex.RLock()
+		defer device.ipcMutex.RUnlock()
+	} else {
+		device.ipcMutex.Lock()
+		defer device.ipcMutex.Unlock()
+	}
 
 	defer func() {
 		if err != nil {
@@ -148,10 +563,13 @@ func (device *Device) IpcSetOperation(r io.Reader) (err error) {
 	}()
 
 	peer := new(ipcSetPeer)
+	defer peer.release()
 	deviceConfig := true
 
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
+	for ; more; more = scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return ipcErrorf(ipc.IpcErrorIO, "set operation given up: %w", err)
+		}
 		line := scanner.Text()
 		if line == "" {
 			// Blank line means terminate operation.
@@ -178,9 +596,9 @@ func (device *Device) IpcSetOperation(r io.Reader) (err error) {
 
 		var err error
 		if deviceConfig {
-			err = device.handleDeviceLine(key, value)
+			err = device.handleDeviceLine(ctx, key, value, caller)
 		} else {
-			err = device.handlePeerLine(peer, key, value)
+			err = device.handlePeerLine(ctx, peer, key, value, caller)
 		}
 		if err != nil {
 			return err
@@ -194,7 +612,7 @@ func (device *Device) IpcSetOperation(r io.Reader) (err error) {
 	return nil
 }
 
-func (device *Device) handleDeviceLine(key, value string) error {
+func (device *Device) handleDeviceLine(ctx context.Context, key, value, caller string) error {
 	switch key {
 	case "private_key":
 		var sk NoisePrivateKey
@@ -218,10 +636,29 @@ func (device *Device) handleDeviceLine(key, value string) error {
 		device.net.port = uint16(port)
 		device.net.Unlock()
 
-		if err := device.BindUpdate(); err != nil {
+		if err := device.bindUpdate(ctx); err != nil {
 			return ipcErrorf(ipc.IpcErrorPortInUse, "failed to set listen_port: %w", err)
 		}
 
+	case "knock_secret":
+		var secret [32]byte
+		if err := loadExactHex(secret[:], value); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set knock_secret: %w", err)
+		}
+		device.log.Verbosef("UAPI: Updating knock secret")
+		device.SetKnockSecret(secret)
+
+	case "listen_ports":
+		ports, err := parseListenPorts(value)
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to parse listen_ports: %w", err)
+		}
+
+		device.log.Verbosef("UAPI: Updating listen ports")
+		if err := device.setListenPorts(ctx, ports); err != nil {
+			return ipcErrorf(ipc.IpcErrorPortInUse, "failed to set listen_ports: %w", err)
+		}
+
 	case "fwmark":
 		mark, err := strconv.ParseUint(value, 10, 32)
 		if err != nil {
@@ -233,6 +670,216 @@ func (device *Device) handleDeviceLine(key, value string) error {
 			return ipcErrorf(ipc.IpcErrorPortInUse, "failed to update fwmark: %w", err)
 		}
 
+	case "noise_construction":
+		device.log.Verbosef("UAPI: Updating Noise construction")
+		device.staticIdentity.RLock()
+		identifier := device.staticIdentity.identifier
+		device.staticIdentity.RUnlock()
+		device.SetProtocolIdentifier(value, identifier)
+		device.recordAudit(caller, key, value)
+
+	case "noise_identifier":
+		device.log.Verbosef("UAPI: Updating Noise identifier")
+		device.staticIdentity.RLock()
+		construction := device.staticIdentity.construction
+		device.staticIdentity.RUnlock()
+		device.SetProtocolIdentifier(construction, value)
+		device.recordAudit(caller, key, value)
+
+	case "handshake_hash":
+		device.log.Verbosef("UAPI: Updating handshake hash")
+		if err := device.SetHandshakeHash(value); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set handshake_hash: %w", err)
+		}
+		device.recordAudit(caller, key, value)
+
+	case "quarantine_max_errors", "quarantine_window", "quarantine_cooldown":
+		device.log.Verbosef("UAPI: Updating quarantine policy")
+
+		n, err := strconv.ParseUint(value, 10, 31)
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set %s: %w", key, err)
+		}
+		policy := device.QuarantinePolicy()
+		switch key {
+		case "quarantine_max_errors":
+			policy.MaxErrors = int(n)
+		case "quarantine_window":
+			policy.Window = time.Duration(n) * time.Second
+		case "quarantine_cooldown":
+			policy.Cooldown = time.Duration(n) * time.Second
+		}
+		if err := device.SetQuarantinePolicy(policy); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set %s: %w", key, err)
+		}
+
+	case "packet_trace_size", "packet_trace_sample":
+		device.log.Verbosef("UAPI: Updating packet trace policy")
+
+		n, err := strconv.ParseUint(value, 10, 31)
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set %s: %w", key, err)
+		}
+		policy := device.PacketTracePolicy()
+		switch key {
+		case "packet_trace_size":
+			policy.Size = int(n)
+		case "packet_trace_sample":
+			policy.Sample = int(n)
+		}
+		if err := device.SetPacketTracePolicy(policy); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set %s: %w", key, err)
+		}
+
+	case "timestamp_max_skew":
+		device.log.Verbosef("UAPI: Updating timestamp policy")
+
+		n, err := strconv.ParseUint(value, 10, 31)
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set timestamp_max_skew: %w", err)
+		}
+		policy := device.TimestampPolicy()
+		policy.MaxSkew = time.Duration(n) * time.Second
+		device.SetTimestampPolicy(policy)
+
+	case "timestamp_accept_stale":
+		device.log.Verbosef("UAPI: Updating timestamp policy")
+
+		policy := device.TimestampPolicy()
+		switch value {
+		case "true":
+			policy.AcceptStale = true
+		case "false":
+			policy.AcceptStale = false
+		default:
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set timestamp_accept_stale, invalid value: %v", value)
+		}
+		device.SetTimestampPolicy(policy)
+
+	case "index_shard":
+		device.log.Verbosef("UAPI: Updating index shard")
+
+		shard, err := ParseIndexShard(value)
+		if err == nil {
+			err = device.SetIndexShard(shard)
+		}
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set index_shard: %w", err)
+		}
+
+	case "handshake_padding":
+		device.log.Verbosef("UAPI: Updating handshake padding")
+
+		size, err := strconv.ParseUint(value, 10, 31)
+		if err == nil {
+			err = device.SetHandshakePadding(int(size))
+		}
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set handshake_padding: %w", err)
+		}
+
+	case "cipher_suite":
+		device.log.Verbosef("UAPI: Updating cipher suite")
+		if err := device.SetCipherSuite(value); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set cipher_suite: %w", err)
+		}
+		device.recordAudit(caller, key, value)
+
+	case "cookie_mac":
+		device.log.Verbosef("UAPI: Updating cookie MAC")
+
+		mac, err := ParseCookieMAC(value)
+		if err == nil {
+			err = device.SetCookieMAC(mac)
+		}
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set cookie_mac: %w", err)
+		}
+		device.recordAudit(caller, key, value)
+
+	case "keystream_precompute":
+		device.log.Verbosef("UAPI: Updating keystream precomputation")
+
+		slots, err := strconv.ParseUint(value, 10, 31)
+		if err == nil {
+			err = device.SetKeystreamPrecompute(int(slots))
+		}
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set keystream_precompute: %w", err)
+		}
+
+	case "chacha_rounds":
+		device.log.Verbosef("UAPI: Updating ChaCha rounds")
+
+		rounds, err := strconv.ParseUint(value, 10, 31)
+		if err == nil {
+			err = device.SetChaChaRounds(int(rounds))
+		}
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set chacha_rounds: %w", err)
+		}
+		device.recordAudit(caller, key, value)
+
+	case "chacha_rounds_force":
+		device.log.Verbosef("UAPI: Updating ChaCha rounds force")
+
+		switch value {
+		case "true":
+			device.SetChaChaRoundsForce(true)
+		case "false":
+			device.SetChaChaRoundsForce(false)
+		default:
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set chacha_rounds_force, invalid value: %v", value)
+		}
+		device.recordAudit(caller, key, value)
+
+	case "core_affinity":
+		device.log.Verbosef("UAPI: Updating core affinity")
+
+		switch value {
+		case "true":
+			device.SetCoreAffinity(true)
+		case "false":
+			device.SetCoreAffinity(false)
+		default:
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set core_affinity, invalid value: %v", value)
+		}
+
+	case "log_level":
+		device.log.Verbosef("UAPI: Updating log level")
+
+		subsystem, level, err := parseLogLevelSetting(value)
+		if err == nil {
+			err = device.SetLogLevel(subsystem, level)
+		}
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set log_level: %w", err)
+		}
+
+	case "icmp_errors":
+		device.log.Verbosef("UAPI: Updating ICMP error policy")
+
+		policy, err := ParseICMPErrorPolicy(value)
+		if err == nil {
+			err = device.SetICMPErrorPolicy(policy)
+		}
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set icmp_errors: %w", err)
+		}
+
+	case "nested_address":
+		var addr netip.Addr
+		if value != "" {
+			var err error
+			if addr, err = netip.ParseAddr(value); err != nil {
+				return ipcErrorf(ipc.IpcErrorInvalid, "failed to set nested_address: %w", err)
+			}
+		}
+		device.log.Verbosef("UAPI: Updating nested address")
+		if err := device.setNestedAddress(ctx, addr); err != nil {
+			return ipcErrorf(ipc.IpcErrorPortInUse, "failed to set nested_address: %w", err)
+		}
+
 	case "replace_peers":
 		if value != "true" {
 			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set replace_peers, invalid value: %v", value)
@@ -253,12 +900,28 @@ type ipcSetPeer struct {
 	dummy   bool // dummy reports whether this peer is a temporary, placeholder peer
 	created bool // new reports whether this is a newly created peer
 	pkaOn   bool // pkaOn reports whether the peer had the persistent keepalive turn on
+
+	locked            *Peer          // peer whose ipcMutex is held, if any
+	replaceAllowedIPs bool           // replace_allowed_ips was requested
+	allowedIPs        []netip.Prefix // allowed_ip lines pending for this peer
+}
+
+// release drops the per-peer IPC lock taken by handlePublicKeyLine, if any.
+func (peer *ipcSetPeer) release() {
+	if peer.locked != nil {
+		peer.locked.ipcMutex.Unlock()
+		peer.locked = nil
+	}
 }
 
 func (peer *ipcSetPeer) handlePostConfig() {
+	defer peer.release()
 	if peer.Peer == nil || peer.dummy {
 		return
 	}
+	if peer.replaceAllowedIPs || len(peer.allowedIPs) > 0 {
+		peer.device.allowedips.UpdateForPeer(peer.Peer, peer.replaceAllowedIPs, peer.allowedIPs)
+	}
 	if peer.created {
 		peer.endpoint.disableRoaming = peer.device.net.brokenRoaming && peer.endpoint.val != nil
 	}
@@ -278,7 +941,12 @@ func (device *Device) handlePublicKeyLine(peer *ipcSetPeer, value string) error
 	if err != nil {
 		return ipcErrorf(ipc.IpcErrorInvalid, "failed to get peer by public key: %w", err)
 	}
+	return device.beginPeerConfig(peer, publicKey)
+}
 
+// beginPeerConfig loads or creates the peer with the given public key and
+// takes its IPC lock for the remainder of its configuration.
+func (device *Device) beginPeerConfig(peer *ipcSetPeer, publicKey NoisePublicKey) (err error) {
 	// Ignore peer with the same public key as this device.
 	device.staticIdentity.RLock()
 	peer.dummy = device.staticIdentity.publicKey.Equals(publicKey)
@@ -294,14 +962,24 @@ func (device *Device) handlePublicKeyLine(peer *ipcSetPeer, value string) error
 	if peer.created {
 		peer.Peer, err = device.NewPeer(publicKey)
 		if err != nil {
-			return ipcErrorf(ipc.IpcErrorInvalid, "failed to create new peer: %w", err)
+			// A concurrent peer-only operation may have created it first.
+			if peer.Peer = device.LookupPeer(publicKey); peer.Peer == nil {
+				return ipcErrorf(ipc.IpcErrorInvalid, "failed to create new peer: %w", err)
+			}
+			peer.created = false
+		} else {
+			device.log.Verbosef("%v - UAPI: Created", peer.Peer)
 		}
-		device.log.Verbosef("%v - UAPI: Created", peer.Peer)
 	}
+
+	peer.Peer.ipcMutex.Lock()
+	peer.locked = peer.Peer
+	peer.replaceAllowedIPs = false
+	peer.allowedIPs = peer.allowedIPs[:0]
 	return nil
 }
 
-func (device *Device) handlePeerLine(peer *ipcSetPeer, key, value string) error {
+func (device *Device) handlePeerLine(ctx context.Context, peer *ipcSetPeer, key, value, caller string) error {
 	switch key {
 	case "update_only":
 		// allow disabling of creation
@@ -337,15 +1015,66 @@ func (device *Device) handlePeerLine(peer *ipcSetPeer, key, value string) error
 			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set preshared key: %w", err)
 		}
 
+	case "psk_rotation_root":
+		device.log.Verbosef("%v - UAPI: Updating preshared key rotation", peer.Peer)
+
+		rotator := peer.PSKRotator()
+		if err := rotator.Root.FromHex(value); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set %s: %w", key, err)
+		}
+		if err := peer.SetPSKRotator(rotator); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set %s: %w", key, err)
+		}
+
+	case "psk_rotation_interval", "psk_rotation_skew":
+		device.log.Verbosef("%v - UAPI: Updating preshared key rotation", peer.Peer)
+
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set %s: %w", key, err)
+		}
+		rotator := peer.PSKRotator()
+		switch key {
+		case "psk_rotation_interval":
+			rotator.Interval = time.Duration(n) * time.Second
+		case "psk_rotation_skew":
+			rotator.MaxSkew = time.Duration(n) * time.Second
+		}
+		if err := peer.SetPSKRotator(rotator); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set %s: %w", key, err)
+		}
+
+	case "channel_binding":
+		device.log.Verbosef("%v - UAPI: Updating channel binding", peer.Peer)
+
+		var binding [32]byte
+		if err := loadExactHex(binding[:], value); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set channel binding: %w", err)
+		}
+		peer.SetChannelBinding(binding)
+
+	case "mac1_public_key":
+		device.log.Verbosef("%v - UAPI: Updating MAC1 public key", peer.Peer)
+
+		var pk NoisePublicKey
+		if err := pk.FromHex(value); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set MAC1 public key: %w", err)
+		}
+		peer.SetMAC1PublicKey(pk)
+
 	case "endpoint":
 		device.log.Verbosef("%v - UAPI: Updating endpoint", peer.Peer)
-		endpoint, err := device.net.bind.ParseEndpoint(value)
+		endpoint, err := conn.ParseEndpointContext(ctx, device.net.bind, value)
 		if err != nil {
 			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set endpoint %v: %w", value, err)
 		}
 		peer.endpoint.Lock()
 		defer peer.endpoint.Unlock()
+		old := peer.endpoint.val
 		peer.endpoint.val = endpoint
+		if !peer.dummy {
+			peer.emitEndpointChangedLocked(old)
+		}
 
 	case "persistent_keepalive_interval":
 		device.log.Verbosef("%v - UAPI: Updating persistent keepalive interval", peer.Peer)
@@ -360,6 +1089,276 @@ func (device *Device) handlePeerLine(peer *ipcSetPeer, key, value string) error
 		// Send immediate keepalive if we're turning it on and before it wasn't on.
 		peer.pkaOn = old == 0 && secs != 0
 
+	case "mss_clamp_mtu":
+		device.log.Verbosef("%v - UAPI: Updating MSS clamping MTU", peer.Peer)
+
+		mtu, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set MSS clamping MTU: %w", err)
+		}
+		if mtu != 0 && mtu < MinMSSClampMTU {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set MSS clamping MTU: %d is below minimum of %d", mtu, MinMSSClampMTU)
+		}
+		peer.mssClampMTU.Store(uint32(mtu))
+
+	case "max_queue_age_ms":
+		device.log.Verbosef("%v - UAPI: Updating maximum queue age", peer.Peer)
+
+		ms, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set maximum queue age: %w", err)
+		}
+		peer.SetMaxQueueAge(time.Duration(ms) * time.Millisecond)
+
+	case "pacing_rate":
+		device.log.Verbosef("%v - UAPI: Updating pacing rate", peer.Peer)
+
+		rate := int64(PacingAuto)
+		if value != "auto" {
+			n, err := strconv.ParseUint(value, 10, 63)
+			if err != nil {
+				return ipcErrorf(ipc.IpcErrorInvalid, "failed to set pacing rate: %w", err)
+			}
+			rate = int64(n)
+		}
+		if err := peer.SetPacingRate(rate); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set pacing rate: %w", err)
+		}
+
+	case "staged_queue_size":
+		device.log.Verbosef("%v - UAPI: Updating staged queue size", peer.Peer)
+
+		size, err := strconv.ParseUint(value, 10, 31)
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set staged queue size: %w", err)
+		}
+		_, policy := peer.StagedQueue()
+		if err := peer.SetStagedQueue(int(size), policy); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set staged queue size: %w", err)
+		}
+
+	case "staged_queue_policy":
+		device.log.Verbosef("%v - UAPI: Updating staged queue policy", peer.Peer)
+
+		policy, err := ParseStagedEvictionPolicy(value)
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set staged queue policy: %w", err)
+		}
+		size, _ := peer.StagedQueue()
+		if err := peer.SetStagedQueue(size, policy); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set staged queue policy: %w", err)
+		}
+
+	case "inbound_packet_rate", "inbound_byte_rate", "inbound_failed_rate":
+		device.log.Verbosef("%v - UAPI: Updating inbound limit", peer.Peer)
+
+		n, err := strconv.ParseUint(value, 10, 63)
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set %s: %w", key, err)
+		}
+		limit := peer.InboundLimit()
+		switch key {
+		case "inbound_packet_rate":
+			limit.PacketRate = int(n)
+		case "inbound_byte_rate":
+			limit.ByteRate = int64(n)
+		case "inbound_failed_rate":
+			limit.FailedRate = int(n)
+		}
+		if err := peer.SetInboundLimit(limit); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set %s: %w", key, err)
+		}
+
+	case "encryption_weight":
+		device.log.Verbosef("%v - UAPI: Updating encryption weight", peer.Peer)
+
+		weight, err := strconv.ParseUint(value, 10, 31)
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set encryption weight: %w", err)
+		}
+		if err := peer.SetEncryptionWeight(int(weight)); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set encryption weight: %w", err)
+		}
+
+	case "name":
+		device.log.Verbosef("%v - UAPI: Updating name", peer.Peer)
+		if err := peer.SetName(value); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set name %q: %w", value, err)
+		}
+
+	case "eager_key_erasure":
+		// Zeroes the keys of the previous keypair when discarding it only
+		// for sessions begun with the key set and suites whose keys can be
+		// found: the standard one and those of this package; see keypair.go.
+		device.log.Verbosef("%v - UAPI: Updating eager key erasure", peer.Peer)
+
+		switch value {
+		case "true":
+			peer.eagerKeyErasure.Store(true)
+		case "false":
+			peer.eagerKeyErasure.Store(false)
+		default:
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set eager key erasure, invalid value: %v", value)
+		}
+
+	case "response_data":
+		device.log.Verbosef("%v - UAPI: Updating response data", peer.Peer)
+
+		data, err := hex.DecodeString(value)
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set response data: %w", err)
+		}
+		if err := peer.SetResponseData(data); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set response data: %w", err)
+		}
+
+	case "cipher_suite":
+		device.log.Verbosef("%v - UAPI: Updating cipher suite", peer.Peer)
+		if LookupCipherSuite(value) == nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set cipher suite: %w", unknownCipherSuiteError(value))
+		}
+		if !peer.dummy {
+			if err := peer.SetCipherSuite(value); err != nil {
+				return ipcErrorf(ipc.IpcErrorInvalid, "failed to set cipher suite: %w", err)
+			}
+			device.recordAudit(caller, key, fmt.Sprintf("%x %s", peer.handshake.remoteStatic[:], value))
+		}
+
+	case "cipher_suite_offer":
+		device.log.Verbosef("%v - UAPI: Updating cipher suite offer", peer.Peer)
+
+		var names []string
+		if value != "" {
+			names = strings.Split(value, ",")
+		}
+		if err := peer.SetCipherSuiteOffer(names); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set cipher suite offer: %w", err)
+		}
+
+	case "pq_mode":
+		device.log.Verbosef("%v - UAPI: Updating post-quantum mode", peer.Peer)
+
+		mode, err := ParsePQMode(value)
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set pq_mode: %w", err)
+		}
+		peer.SetPQMode(mode)
+
+	case "metadata":
+		device.log.Verbosef("%v - UAPI: Updating metadata", peer.Peer)
+
+		metadata, err := hex.DecodeString(value)
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set metadata: %w", err)
+		}
+		if peer.dummy {
+			return nil
+		}
+		if err := peer.SetMetadata(metadata); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set metadata: %w", err)
+		}
+
+	case "assign_address":
+		device.log.Verbosef("%v - UAPI: Adding assigned address", peer.Peer)
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set assigned address: %w", err)
+		}
+		if peer.dummy {
+			return nil
+		}
+		peer.addAssignedAddress(prefix)
+		peer.allowedIPs = append(peer.allowedIPs, hostPrefix(prefix))
+
+	case "assign_dns":
+		device.log.Verbosef("%v - UAPI: Adding assigned DNS server", peer.Peer)
+		ip, err := netip.ParseAddr(value)
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set assigned DNS server: %w", err)
+		}
+		if peer.dummy {
+			return nil
+		}
+		peer.AssignDNS(ip)
+
+	case "replace_assignment":
+		device.log.Verbosef("%v - UAPI: Removing assignment", peer.Peer)
+		if value != "true" {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to replace assignment, invalid value: %v", value)
+		}
+		if peer.dummy {
+			return nil
+		}
+		peer.ClearAssignment()
+
+	case "accept_assignment":
+		device.log.Verbosef("%v - UAPI: Updating accept assignment", peer.Peer)
+
+		switch value {
+		case "true":
+			peer.setAcceptAssignment(true)
+		case "false":
+			peer.setAcceptAssignment(false)
+		default:
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set accept assignment, invalid value: %v", value)
+		}
+
+	case "accept_assignment_subnet":
+		device.log.Verbosef("%v - UAPI: Adding accepted assignment subnet", peer.Peer)
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set accepted assignment subnet: %w", err)
+		}
+		if peer.dummy {
+			return nil
+		}
+		peer.AcceptAssignmentSubnet(prefix)
+
+	case "replace_accept_assignment_subnets":
+		device.log.Verbosef("%v - UAPI: Removing all accepted assignment subnets", peer.Peer)
+		if value != "true" {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to replace accepted assignment subnets, invalid value: %v", value)
+		}
+		if peer.dummy {
+			return nil
+		}
+		peer.ClearAcceptedAssignmentSubnets()
+
+	case "endpoint_fallback":
+		device.log.Verbosef("%v - UAPI: Updating endpoint fallback", peer.Peer)
+
+		switch value {
+		case "true":
+			peer.setEndpointFallback(true)
+		case "false":
+			peer.setEndpointFallback(false)
+		default:
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set endpoint fallback, invalid value: %v", value)
+		}
+
+	case "switch_probe_interval", "switch_min_improvement", "switch_dwell":
+		device.log.Verbosef("%v - UAPI: Updating endpoint switching policy", peer.Peer)
+
+		n, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set %s: %w", key, err)
+		}
+		if peer.dummy {
+			return nil
+		}
+		policy := peer.SwitchPolicy()
+		switch key {
+		case "switch_probe_interval":
+			policy.ProbeInterval = time.Duration(n) * time.Second
+		case "switch_min_improvement":
+			policy.MinImprovement = int(n)
+		case "switch_dwell":
+			policy.Dwell = time.Duration(n) * time.Second
+		}
+		if err := peer.SetSwitchPolicy(policy); err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "failed to set %s: %w", key, err)
+		}
+
 	case "replace_allowed_ips":
 		device.log.Verbosef("%v - UAPI: Removing all allowedips", peer.Peer)
 		if value != "true" {
@@ -368,7 +1367,8 @@ func (device *Device) handlePeerLine(peer *ipcSetPeer, key, value string) error
 		if peer.dummy {
 			return nil
 		}
-		device.allowedips.RemoveByPeer(peer.Peer)
+		peer.replaceAllowedIPs = true
+		peer.allowedIPs = peer.allowedIPs[:0]
 
 	case "allowed_ip":
 		device.log.Verbosef("%v - UAPI: Adding allowedip", peer.Peer)
@@ -379,7 +1379,7 @@ func (device *Device) handlePeerLine(peer *ipcSetPeer, key, value string) error
 		if peer.dummy {
 			return nil
 		}
-		device.allowedips.Insert(prefix, peer.Peer)
+		peer.allowedIPs = append(peer.allowedIPs, prefix)
 
 	case "protocol_version":
 		if value != "1" {
@@ -405,7 +1405,96 @@ func (device *Device) IpcSet(uapiConf string) error {
 	return device.IpcSetOperation(strings.NewReader(uapiConf))
 }
 
+// IpcSetContext is IpcSet, giving up when ctx is done.
+func (device *Device) IpcSetContext(ctx context.Context, uapiConf string) error {
+	return device.IpcSetOperationContext(ctx, strings.NewReader(uapiConf))
+}
+
+// ipcPingPeer measures the round-trip time to the peer with the given
+// hex-encoded public key, and writes it as rtt_nsec=<nanoseconds>.
+func (device *Device) ipcPingPeer(w io.Writer, key string) error {
+	var publicKey NoisePublicKey
+	if err := publicKey.FromHex(key); err != nil {
+		return ipcErrorf(ipc.IpcErrorInvalid, "failed to get peer by public key: %w", err)
+	}
+	peer := device.LookupPeer(publicKey)
+	if peer == nil {
+		return ipcErrorf(ipc.IpcErrorInvalid, "%w: %x", ErrPeerNotFound, publicKey[:])
+	}
+	rtt, err := peer.Ping(PingTimeout)
+	if err != nil {
+		return ipcErrorf(ipc.IpcErrorIO, "failed to ping %v: %w", peer, err)
+	}
+	if _, err := fmt.Fprintf(w, "rtt_nsec=%d\n", rtt.Nanoseconds()); err != nil {
+		return ipcErrorf(ipc.IpcErrorIO, "failed to write output: %w", err)
+	}
+	return nil
+}
+
+// ipcHandshakePeer performs a handshake with the peer given by args, a
+// hex-encoded public key optionally followed by a space and a timeout in
+// seconds. It writes how long the handshake took as handshake_nsec=, or why
+// it failed as handshake_error= along with what was received meanwhile.
+func (device *Device) ipcHandshakePeer(w io.Writer, args string) error {
+	key, secs, hasTimeout := strings.Cut(args, " ")
+	timeout := HandshakeTimeout
+	if hasTimeout {
+		n, err := strconv.ParseUint(secs, 10, 16)
+		if err != nil || n == 0 {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid handshake timeout: %q", secs)
+		}
+		timeout = time.Duration(n) * time.Second
+	}
+	var publicKey NoisePublicKey
+	if err := publicKey.FromHex(key); err != nil {
+		return ipcErrorf(ipc.IpcErrorInvalid, "failed to get peer by public key: %w", err)
+	}
+	peer := device.LookupPeer(publicKey)
+	if peer == nil {
+		return ipcErrorf(ipc.IpcErrorInvalid, "%w: %x", ErrPeerNotFound, publicKey[:])
+	}
+	took, err := peer.Handshake(timeout)
+	var herr *HandshakeError
+	if errors.As(err, &herr) {
+		fmt.Fprintf(w, "handshake_error=%s\ncookie_replies=%d\ninvalid_responses=%d\n", herr.Reason, herr.CookieReplies, herr.InvalidResponses)
+	}
+	if err != nil {
+		return ipcErrorf(ipc.IpcErrorIO, "failed to handshake with %v: %w", peer, err)
+	}
+	if _, err := fmt.Fprintf(w, "handshake_nsec=%d\n", took.Nanoseconds()); err != nil {
+		return ipcErrorf(ipc.IpcErrorIO, "failed to write output: %w", err)
+	}
+	return nil
+}
+
+// ipcGetPage handles the get_page operation, whose args are a page cursor,
+// empty for the first page, optionally followed by &page_size=N.
+func (device *Device) ipcGetPage(w io.Writer, args string) error {
+	cursor, opt, hasOpt := strings.Cut(args, "&")
+	pageSize := DefaultPageSize
+	if hasOpt {
+		size, ok := strings.CutPrefix(opt, "page_size=")
+		n, err := strconv.Atoi(size)
+		if !ok || err != nil {
+			return ipcErrorf(ipc.IpcErrorInvalid, "invalid get_page option: %q", opt)
+		}
+		pageSize = n
+	}
+	return device.IpcGetPageOperation(w, cursor, pageSize)
+}
+
 func (device *Device) IpcHandle(socket net.Conn) {
+	device.ipcHandle(socket, false)
+}
+
+// IpcHandleObserver serves a connection accepted on the observer socket,
+// which only admits the read-only observe operation, so that it can be
+// made accessible to those not entitled to configure the device.
+func (device *Device) IpcHandleObserver(socket net.Conn) {
+	device.ipcHandle(socket, true)
+}
+
+func (device *Device) ipcHandle(socket net.Conn, observer bool) {
 	defer socket.Close()
 
 	buffered := func(s io.ReadWriter) *bufio.ReadWriter {
@@ -421,9 +1510,13 @@ func (device *Device) IpcHandle(socket net.Conn) {
 		}
 
 		// handle operation
+		if observer && op != "observe=1\n" {
+			device.log.Errorf("UAPI operation not permitted on the observer socket: %v", op)
+			return
+		}
 		switch op {
 		case "set=1\n":
-			err = device.IpcSetOperation(buffered.Reader)
+			err = device.ipcSetOperation(context.Background(), buffered.Reader, uapiCaller(socket))
 		case "get=1\n":
 			var nextByte byte
 			nextByte, err = buffered.ReadByte()
@@ -435,7 +1528,111 @@ func (device *Device) IpcHandle(socket net.Conn) {
 				break
 			}
 			err = device.IpcGetOperation(buffered.Writer)
+		case "benchmark=1\n":
+			var nextByte byte
+			nextByte, err = buffered.ReadByte()
+			if err != nil {
+				return
+			}
+			if nextByte != '\n' {
+				err = ipcErrorf(ipc.IpcErrorInvalid, "trailing character in UAPI benchmark: %q", nextByte)
+				break
+			}
+			err = device.ipcBenchmark(buffered.Writer)
+		case "get=crypto_manifest\n":
+			var nextByte byte
+			nextByte, err = buffered.ReadByte()
+			if err != nil {
+				return
+			}
+			if nextByte != '\n' {
+				err = ipcErrorf(ipc.IpcErrorInvalid, "trailing character in UAPI get: %q", nextByte)
+				break
+			}
+			err = ipcCryptoManifest(buffered.Writer)
+		case "get=packet_trace\n":
+			var nextByte byte
+			nextByte, err = buffered.ReadByte()
+			if err != nil {
+				return
+			}
+			if nextByte != '\n' {
+				err = ipcErrorf(ipc.IpcErrorInvalid, "trailing character in UAPI get: %q", nextByte)
+				break
+			}
+			err = device.ipcPacketTrace(buffered.Writer)
+		case "pipeline=1\n":
+			var nextByte byte
+			nextByte, err = buffered.ReadByte()
+			if err != nil {
+				return
+			}
+			if nextByte != '\n' {
+				err = ipcErrorf(ipc.IpcErrorInvalid, "trailing character in UAPI pipeline: %q", nextByte)
+				break
+			}
+			fmt.Fprintf(buffered, "errno=0\n\n")
+			buffered.Flush()
+			device.ipcPipeline(socket, buffered.Reader)
+			return
+		case "observe=1\n":
+			var nextByte byte
+			nextByte, err = buffered.ReadByte()
+			if err != nil {
+				return
+			}
+			if nextByte != '\n' {
+				err = ipcErrorf(ipc.IpcErrorInvalid, "trailing character in UAPI observe: %q", nextByte)
+				break
+			}
+			if !observer {
+				err = ipcErrorf(ipc.IpcErrorInvalid, "UAPI observe is only served on the observer socket")
+				break
+			}
+			fmt.Fprintf(buffered, "errno=0\n\n")
+			buffered.Flush()
+			device.ipcObserve(socket, buffered.Reader)
+			return
 		default:
+			if key, ok := strings.CutPrefix(op, "ping_peer="); ok {
+				var nextByte byte
+				nextByte, err = buffered.ReadByte()
+				if err != nil {
+					return
+				}
+				if nextByte != '\n' {
+					err = ipcErrorf(ipc.IpcErrorInvalid, "trailing character in UAPI ping_peer: %q", nextByte)
+					break
+				}
+				err = device.ipcPingPeer(buffered.Writer, strings.TrimSuffix(key, "\n"))
+				break
+			}
+			if args, ok := strings.CutPrefix(op, "handshake_peer="); ok {
+				var nextByte byte
+				nextByte, err = buffered.ReadByte()
+				if err != nil {
+					return
+				}
+				if nextByte != '\n' {
+					err = ipcErrorf(ipc.IpcErrorInvalid, "trailing character in UAPI handshake_peer: %q", nextByte)
+					break
+				}
+				err = device.ipcHandshakePeer(buffered.Writer, strings.TrimSuffix(args, "\n"))
+				break
+			}
+			if args, ok := strings.CutPrefix(op, "get_page="); ok {
+				var nextByte byte
+				nextByte, err = buffered.ReadByte()
+				if err != nil {
+					return
+				}
+				if nextByte != '\n' {
+					err = ipcErrorf(ipc.IpcErrorInvalid, "trailing character in UAPI get_page: %q", nextByte)
+					break
+				}
+				err = device.ipcGetPage(buffered.Writer, strings.TrimSuffix(args, "\n"))
+				break
+			}
 			device.log.Errorf("invalid UAPI operation: %v", op)
 			return
 		}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import "net"
+
+// uapiCaller identifies the other end of a UAPI connection, for the audit
+// trail.
+func uapiCaller(socket net.Conn) string {
+	return uapiCallerAddr(socket)
+}
@@ -0,0 +1,31 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// uapiCaller identifies the process on the other end of a UAPI connection,
+// for the audit trail.
+func uapiCaller(socket net.Conn) string {
+	if uc, ok := socket.(*net.UnixConn); ok {
+		if rc, err := uc.SyscallConn(); err == nil {
+			var cred *unix.Ucred
+			var credErr error
+			rc.Control(func(fd uintptr) {
+				cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+			})
+			if credErr == nil && cred != nil {
+				return fmt.Sprintf("uid=%d pid=%d", cred.Uid, cred.Pid)
+			}
+		}
+	}
+	return uapiCallerAddr(socket)
+}
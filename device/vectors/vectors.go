@@ -0,0 +1,219 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package vectors generates and verifies test vectors of the experimental
+// primitives of the device package: the ChaCha20_24 stream cipher and the
+// Poly1795 and DoublePoly1305 MACs, so that other implementations of them
+// can check that they interoperate.
+//
+// Generate derives the keys, nonces and messages of a set of vectors from a
+// seed, with the ChaCha8 generator of math/rand/v2, whose output is fixed
+// for a seed, so that a seed always gives the same vectors. Each set covers
+// the message sizes around the blocks of the primitive and of its
+// multi-block code paths, and inputs chosen to reach edge cases, such as
+// all-ones keys and messages and block counters near the end of the
+// keystream. Files hold a set of vectors of one algorithm as JSON, with
+// byte strings in hex, and Verify checks a set against the primitives of
+// this build.
+//
+// The primitives are only compiled into builds with the wg_experimental
+// tag; other builds know no algorithms.
+package vectors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"slices"
+)
+
+// Algorithm names.
+const (
+	ChaCha20x24    = "ChaCha20_24"
+	Poly1795       = "Poly1795"
+	DoublePoly1305 = "DoublePoly1305"
+)
+
+// DefaultSeed is the seed of the vectors the repository ships.
+const DefaultSeed = 1
+
+// Hex is a byte string that encodes to JSON as a hex string.
+type Hex []byte
+
+// MarshalText encodes h in lowercase hex.
+func (h Hex) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(h)), nil
+}
+
+// UnmarshalText decodes h from hex.
+func (h *Hex) UnmarshalText(text []byte) error {
+	b, err := hex.DecodeString(string(text))
+	if err != nil {
+		return err
+	}
+	*h = b
+	return nil
+}
+
+// A Vector is one input of an algorithm and its output. For ChaCha20_24,
+// the output is the message XORed with the keystream of the key and nonce
+// from block Counter on, so that an all-zero message gives the keystream
+// itself; for the MACs, it is the tag of the message under the key.
+type Vector struct {
+	ID      int    `json:"id"`
+	Comment string `json:"comment,omitempty"`
+	Key     Hex    `json:"key"`
+	Nonce   Hex    `json:"nonce,omitempty"`
+	Counter uint32 `json:"counter,omitempty"`
+	Message Hex    `json:"message"`
+	Output  Hex    `json:"output"`
+}
+
+// A File is a set of vectors of one algorithm.
+type File struct {
+	Algorithm   string   `json:"algorithm"`
+	Description string   `json:"description"`
+	Seed        uint64   `json:"seed"`
+	Vectors     []Vector `json:"vectors"`
+}
+
+// An algorithm computes the outputs of vectors of one primitive.
+type algorithm struct {
+	name        string
+	description string
+	keySize     int
+	nonceSize   int
+	blockSize   int // bytes the primitive processes at a time
+	wideBlocks  int // blocks of the widest multi-block code path
+	output      func(v *Vector) ([]byte, error)
+	edgeCases   func(rng *rand.Rand) []Vector
+}
+
+var algorithms = map[string]*algorithm{}
+
+func register(a *algorithm) {
+	algorithms[a.name] = a
+}
+
+// Algorithms returns the names of the algorithms of this build, sorted.
+func Algorithms() []string {
+	names := make([]string, 0, len(algorithms))
+	for name := range algorithms {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func lookup(name string) (*algorithm, error) {
+	a, ok := algorithms[name]
+	if !ok {
+		if len(algorithms) == 0 {
+			return nil, fmt.Errorf("unknown algorithm %q: built without the wg_experimental tag", name)
+		}
+		return nil, fmt.Errorf("unknown algorithm %q", name)
+	}
+	return a, nil
+}
+
+// sizes returns the message sizes of the vectors of a: the empty message,
+// and those one byte either side of one, two and the widest code path's
+// number of blocks, and of a packet.
+func (a *algorithm) sizes() []int {
+	sizes := []int{0, 1}
+	for _, blocks := range []int{1, 2, a.wideBlocks, 2 * a.wideBlocks} {
+		n := blocks * a.blockSize
+		sizes = append(sizes, n-1, n, n+1)
+	}
+	sizes = append(sizes, 1420)
+	slices.Sort(sizes)
+	return slices.Compact(sizes)
+}
+
+// Generate returns the vectors of the algorithm with the given seed.
+func Generate(name string, seed uint64) (*File, error) {
+	a, err := lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	var chachaSeed [32]byte
+	binary.LittleEndian.PutUint64(chachaSeed[:], seed)
+	copy(chachaSeed[8:], name)
+	rng := rand.New(rand.NewChaCha8(chachaSeed))
+
+	f := &File{Algorithm: a.name, Description: a.description, Seed: seed}
+	for _, size := range a.sizes() {
+		v := Vector{Key: randomBytes(rng, a.keySize), Message: randomBytes(rng, size), Comment: fmt.Sprintf("%d-byte message", size)}
+		if a.nonceSize > 0 {
+			v.Nonce = randomBytes(rng, a.nonceSize)
+		}
+		f.Vectors = append(f.Vectors, v)
+	}
+	f.Vectors = append(f.Vectors, a.edgeCases(rng)...)
+	for i := range f.Vectors {
+		v := &f.Vectors[i]
+		v.ID = i + 1
+		if v.Output, err = a.output(v); err != nil {
+			return nil, fmt.Errorf("vector %d of %s: %w", v.ID, a.name, err)
+		}
+	}
+	return f, nil
+}
+
+// Verify checks the outputs of the vectors of f against those the
+// primitives of this build compute.
+func Verify(f *File) error {
+	a, err := lookup(f.Algorithm)
+	if err != nil {
+		return err
+	}
+	if len(f.Vectors) == 0 {
+		return fmt.Errorf("no vectors of %s", f.Algorithm)
+	}
+	for i := range f.Vectors {
+		v := &f.Vectors[i]
+		if len(v.Key) != a.keySize || len(v.Nonce) != a.nonceSize {
+			return fmt.Errorf("vector %d of %s: %d-byte key and %d-byte nonce, want %d and %d", v.ID, a.name, len(v.Key), len(v.Nonce), a.keySize, a.nonceSize)
+		}
+		out, err := a.output(v)
+		if err != nil {
+			return fmt.Errorf("vector %d of %s: %w", v.ID, a.name, err)
+		}
+		if !bytes.Equal(out, v.Output) {
+			return fmt.Errorf("vector %d of %s: output %x, want %x", v.ID, a.name, out, []byte(v.Output))
+		}
+	}
+	return nil
+}
+
+// Write writes f as indented JSON.
+func Write(w io.Writer, f *File) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(f)
+}
+
+// Read reads a file written by Write.
+func Read(r io.Reader) (*File, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	var f File
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("invalid vectors: %w", err)
+	}
+	return &f, nil
+}
+
+func randomBytes(rng *rand.Rand, n int) Hex {
+	b := make(Hex, n)
+	for i := range b {
+		b[i] = byte(rng.Uint32())
+	}
+	return b
+}
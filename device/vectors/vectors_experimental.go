@@ -0,0 +1,115 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package vectors
+
+import (
+	"bytes"
+	"errors"
+	"math/rand/v2"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+func init() {
+	register(&algorithm{
+		name: ChaCha20x24,
+		description: "ChaCha with 24 rounds of the modified quarter round, a 32-byte key, a 16-byte nonce in state words 11 to 14 " +
+			"and a 32-bit block counter in word 15; the nonce takes the place of the last word of the key, whose last 4 bytes " +
+			"thus do not affect the keystream. Output is the message XORed with the keystream from block counter on.",
+		keySize:    32,
+		nonceSize:  16,
+		blockSize:  64,
+		wideBlocks: 4,
+		output:     chacha20x24Output,
+		edgeCases:  chacha20x24EdgeCases,
+	})
+	register(&algorithm{
+		name: Poly1795,
+		description: "The Poly1795 MAC of the device package, over 24-byte blocks with a 32-byte one-time key. " +
+			"Output is the 24-byte tag.",
+		keySize:    32,
+		blockSize:  24,
+		wideBlocks: 4,
+		output: func(v *Vector) ([]byte, error) {
+			var out [device.Poly1795Size]byte
+			device.Poly1795Sum(&out, v.Message, (*[32]byte)(v.Key))
+			return out[:], nil
+		},
+		edgeCases: macEdgeCases(32, 24, 4),
+	})
+	register(&algorithm{
+		name: DoublePoly1305,
+		description: "Two Poly1305 MACs of the message, under the first and last 32 bytes of a 64-byte one-time key. " +
+			"Output is the 32-byte concatenation of the two tags.",
+		keySize:    64,
+		blockSize:  16,
+		wideBlocks: 32,
+		output: func(v *Vector) ([]byte, error) {
+			var out [32]byte
+			device.DoublePoly1305(&out, v.Message, (*[64]byte)(v.Key))
+			return out[:], nil
+		},
+		edgeCases: func(rng *rand.Rand) []Vector {
+			vs := macEdgeCases(64, 16, 32)(rng)
+			half := randomBytes(rng, 32)
+			return append(vs, Vector{
+				Comment: "both halves of the key equal, and so both halves of the tag",
+				Key:     append(bytes.Clone(half), half...),
+				Message: randomBytes(rng, 100),
+			})
+		},
+	})
+}
+
+func chacha20x24Output(v *Vector) ([]byte, error) {
+	if blocks := (len(v.Message) + 63) / 64; uint64(v.Counter)+uint64(blocks) > 1<<32 {
+		return nil, errors.New("message runs past the last block of the keystream")
+	}
+	c, err := device.NewChaCha20x24Cipher(v.Key, v.Nonce)
+	if err != nil {
+		return nil, err
+	}
+	c.SetCounter(v.Counter)
+	out := make([]byte, len(v.Message))
+	c.XORKeyStream(out, v.Message)
+	return out, nil
+}
+
+func chacha20x24EdgeCases(rng *rand.Rand) []Vector {
+	key := randomBytes(rng, 32)
+	otherTail := bytes.Clone(key)
+	copy(otherTail[28:], randomBytes(rng, 4))
+	nonce := randomBytes(rng, 16)
+	return []Vector{
+		{Comment: "keystream of the all-zero key and nonce", Key: make(Hex, 32), Nonce: make(Hex, 16), Message: make(Hex, 2*64)},
+		{Comment: "keystream of the all-ones key and nonce", Key: ones(32), Nonce: ones(16), Message: make(Hex, 64)},
+		{Comment: "keystream from block 1", Key: key, Nonce: nonce, Counter: 1, Message: make(Hex, 3*64+5)},
+		{Comment: "same keystream as the one before, the key differing in its last 4 bytes only", Key: otherTail, Nonce: nonce, Counter: 1, Message: make(Hex, 3*64+5)},
+		{Comment: "the last four blocks of the keystream", Key: key, Nonce: nonce, Counter: 1<<32 - 4, Message: randomBytes(rng, 4*64)},
+		{Comment: "part of the last block of the keystream", Key: key, Nonce: nonce, Counter: 1<<32 - 1, Message: randomBytes(rng, 33)},
+	}
+}
+
+// macEdgeCases returns the edge cases of a MAC with keys of keySize bytes
+// and blocks of blockSize, processed wideBlocks at a time at most.
+func macEdgeCases(keySize, blockSize, wideBlocks int) func(rng *rand.Rand) []Vector {
+	return func(rng *rand.Rand) []Vector {
+		wide := wideBlocks * blockSize
+		return []Vector{
+			{Comment: "all-zero key", Key: make(Hex, keySize), Message: randomBytes(rng, 3*blockSize)},
+			{Comment: "all-zero message", Key: randomBytes(rng, keySize), Message: make(Hex, wide+blockSize)},
+			{Comment: "all-ones key and message", Key: ones(keySize), Message: ones(wide)},
+			{Comment: "all-ones key and message, ending in a partial block", Key: ones(keySize), Message: ones(wide + blockSize/2)},
+			{Comment: "all-ones message of a packet", Key: randomBytes(rng, keySize), Message: ones(1420)},
+		}
+	}
+}
+
+func ones(n int) Hex {
+	return bytes.Repeat(Hex{0xff}, n)
+}
@@ -0,0 +1,95 @@
+//go:build wg_experimental
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package vectors
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestShippedVectors checks that the vectors of testdata are those Generate
+// writes with the default seed, and that they verify, so that changes to the
+// primitives that change their outputs do not go unnoticed.
+func TestShippedVectors(t *testing.T) {
+	for _, name := range Algorithms() {
+		f, err := Generate(name, DefaultSeed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := Write(&buf, f); err != nil {
+			t.Fatal(err)
+		}
+		shipped, err := os.ReadFile(filepath.Join("testdata", strings.ToLower(name)+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), shipped) {
+			t.Errorf("vectors of %s differ from those shipped", name)
+		}
+		read, err := Read(bytes.NewReader(shipped))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Verify(read); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	for _, name := range Algorithms() {
+		a, _ := Generate(name, 2)
+		b, _ := Generate(name, 2)
+		c, _ := Generate(name, 3)
+		if len(a.Vectors) < 15 || !bytes.Equal(a.Vectors[5].Output, b.Vectors[5].Output) {
+			t.Fatalf("vectors of %s not deterministic", name)
+		}
+		if bytes.Equal(a.Vectors[5].Key, c.Vectors[5].Key) {
+			t.Errorf("vectors of %s the same for other seeds", name)
+		}
+		for i, v := range a.Vectors {
+			if v.ID != i+1 {
+				t.Errorf("vector %d of %s numbered %d", i+1, name, v.ID)
+			}
+		}
+
+		// Verify catches any wrong byte.
+		v := &a.Vectors[len(a.Vectors)-1]
+		v.Output[len(v.Output)-1] ^= 1
+		if err := Verify(a); err == nil || !strings.Contains(err.Error(), "vector") {
+			t.Errorf("verified a wrong vector of %s: %v", name, err)
+		}
+		a.Vectors[0].Key = a.Vectors[0].Key[1:]
+		if err := Verify(a); err == nil {
+			t.Errorf("verified a vector of %s with a short key", name)
+		}
+	}
+}
+
+func TestChaCha20x24KeyTail(t *testing.T) {
+	f, err := Generate(ChaCha20x24, DefaultSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tail []Vector
+	for _, v := range f.Vectors {
+		if v.Counter == 1 {
+			tail = append(tail, v)
+		}
+	}
+	if len(tail) != 2 || bytes.Equal(tail[0].Key, tail[1].Key) || !bytes.Equal(tail[0].Output, tail[1].Output) {
+		t.Error("keys differing in their last 4 bytes give different keystream")
+	}
+	if err := Verify(&File{Algorithm: ChaCha20x24, Vectors: []Vector{{Key: make(Hex, 32), Nonce: make(Hex, 16), Counter: 1<<32 - 1, Message: make(Hex, 65)}}}); err == nil {
+		t.Error("verified a vector running past the last block")
+	}
+}
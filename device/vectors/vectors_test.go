@@ -0,0 +1,54 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package vectors
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadWrite(t *testing.T) {
+	f := &File{Algorithm: "Example", Seed: 7, Vectors: []Vector{
+		{ID: 1, Key: Hex{0x00, 0xff}, Message: Hex{}, Output: Hex{0xab}},
+		{ID: 2, Key: Hex{0x01}, Nonce: Hex{0x02}, Counter: 3, Message: Hex{0x04}, Output: Hex{0x05}},
+	}}
+	var buf bytes.Buffer
+	if err := Write(&buf, f); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"key": "00ff"`) {
+		t.Errorf("key not in hex:\n%s", buf.String())
+	}
+	got, err := Read(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Algorithm != f.Algorithm || got.Seed != f.Seed || len(got.Vectors) != 2 ||
+		!bytes.Equal(got.Vectors[0].Key, f.Vectors[0].Key) || got.Vectors[1].Counter != 3 || !bytes.Equal(got.Vectors[1].Nonce, f.Vectors[1].Nonce) {
+		t.Errorf("read %+v, wrote %+v", got, f)
+	}
+
+	for _, bad := range []string{`{"algorithm": "Example", "vectors": [{"key": "0g"}]}`, `{"algorithm": "Example", "extra": 1}`} {
+		if _, err := Read(strings.NewReader(bad)); err == nil {
+			t.Errorf("read %s", bad)
+		}
+	}
+}
+
+func TestUnknownAlgorithm(t *testing.T) {
+	if _, err := Generate("Poly1306", DefaultSeed); err == nil {
+		t.Error("generated vectors of an unknown algorithm")
+	}
+	if err := Verify(&File{Algorithm: "Poly1306"}); err == nil {
+		t.Error("verified vectors of an unknown algorithm")
+	}
+	if len(Algorithms()) == 0 {
+		if _, err := Generate(Poly1795, DefaultSeed); err == nil || !strings.Contains(err.Error(), "wg_experimental") {
+			t.Errorf("unexpected error without the experimental primitives: %v", err)
+		}
+	}
+}
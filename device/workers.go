@@ -0,0 +1,84 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package device
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+/* The encryption and decryption queues are each served by a number of
+ * worker goroutines that varies between Limits.MinCryptoWorkers and
+ * Limits.MaxCryptoWorkers. Producers add a worker when they find a
+ * backlog at least as long as the number of workers, and workers beyond
+ * the minimum exit after WorkerIdleTimeout without work, so that a mostly
+ * idle device does not keep a goroutine per CPU waking up for every packet.
+ */
+
+type workerScaler struct {
+	min, max int32
+	running  atomic.Int32
+	lastID   atomic.Int32
+	start    func(id int)
+}
+
+func (w *workerScaler) init(min, max int, start func(id int)) {
+	w.min, w.max = int32(min), int32(max)
+	w.start = start
+	for i := 0; i < min; i++ {
+		w.running.Add(1)
+		go start(int(w.lastID.Add(1)))
+	}
+}
+
+// grow starts another worker if queued, the current length of the queue,
+// indicates that the existing ones are not keeping up.
+func (w *workerScaler) grow(queued int) {
+	n := w.running.Load()
+	if int32(queued) < n || n >= w.max {
+		return
+	}
+	if w.running.CompareAndSwap(n, n+1) {
+		go w.start(int(w.lastID.Add(1)))
+	}
+}
+
+// retire reports whether a worker that has been idle may exit, and if so
+// accounts for its exit.
+func (w *workerScaler) retire() bool {
+	for {
+		n := w.running.Load()
+		if n <= w.min {
+			return false
+		}
+		if w.running.CompareAndSwap(n, n-1) {
+			return true
+		}
+	}
+}
+
+// nextWork receives from c on behalf of a worker of w. It returns false when
+// c is closed, or when the worker has been idle long enough to exit.
+func nextWork[T any](w *workerScaler, c chan T, idle *time.Timer) (T, bool) {
+	select {
+	case v, ok := <-c:
+		return v, ok
+	default:
+	}
+	for {
+		idle.Reset(WorkerIdleTimeout)
+		select {
+		case v, ok := <-c:
+			idle.Stop()
+			return v, ok
+		case <-idle.C:
+			if w.retire() {
+				var zero T
+				return zero, false
+			}
+		}
+	}
+}
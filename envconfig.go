@@ -0,0 +1,248 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+/* Configuration from the environment
+ *
+ * Immutable container deployments have no one to run wg(8) against the
+ * UAPI socket once the process is up, so the device may instead be
+ * configured at start from environment variables:
+ *
+ *	WG_PRIVATE_KEY                    base64 private key
+ *	WG_LISTEN_PORT                    UDP port to listen on
+ *	WG_FWMARK                         firewall mark of outgoing packets
+ *	WG_CIPHER_SUITE                   suite of peers that name none
+ *	WG_PEER_<id>_PUBLIC_KEY           base64 public key, required
+ *	WG_PEER_<id>_PRESHARED_KEY        base64 preshared key
+ *	WG_PEER_<id>_ENDPOINT             host:port, resolved at start
+ *	WG_PEER_<id>_ALLOWED_IPS          comma-separated prefixes or addresses
+ *	WG_PEER_<id>_PERSISTENT_KEEPALIVE interval in seconds
+ *	WG_PEER_<id>_NAME                 name of the peer
+ *	WG_PEER_<id>_CIPHER_SUITE         suite of the peer
+ *
+ * where <id> is any run of letters and digits, which orders the peers. Any
+ * of these may instead be given as the path of a file holding the value,
+ * such as a mounted secret, in the variable with a _FILE suffix; trailing
+ * whitespace of the file is ignored. The peers given replace all peers of
+ * the device. Every invalid or unknown variable is reported, without its
+ * value, and fails the start.
+ */
+
+const (
+	ENV_WG_PRIVATE_KEY  = "WG_PRIVATE_KEY"
+	ENV_WG_LISTEN_PORT  = "WG_LISTEN_PORT"
+	ENV_WG_FWMARK       = "WG_FWMARK"
+	ENV_WG_CIPHER_SUITE = "WG_CIPHER_SUITE"
+	ENV_WG_PEER_PREFIX  = "WG_PEER_"
+
+	envFileSuffix = "_FILE"
+)
+
+// envConfig returns the device configuration described by environ, a list of
+// environment variables in the form of os.Environ, and whether it describes
+// any.
+func envConfig(environ []string) (device.Config, bool, error) {
+	vars := make(map[string]string)
+	var errs []error
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		base, isFile := strings.CutSuffix(name, envFileSuffix)
+		if !isEnvConfigVar(base) {
+			continue
+		}
+		if isFile {
+			b, err := os.ReadFile(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", name, err))
+				continue
+			}
+			value = strings.TrimRight(string(b), " \t\r\n")
+		}
+		if _, ok := vars[base]; ok {
+			errs = append(errs, fmt.Errorf("%s: given both directly and in a file", base))
+			continue
+		}
+		vars[base] = value
+	}
+	if len(vars) == 0 && len(errs) == 0 {
+		return device.Config{}, false, nil
+	}
+
+	var cfg device.Config
+	fail := func(name string, err error) {
+		errs = append(errs, fmt.Errorf("%s: %w", name, err))
+	}
+	if value, ok := vars[ENV_WG_PRIVATE_KEY]; ok {
+		var sk device.NoisePrivateKey
+		if err := parseEnvKey(sk[:], value); err != nil {
+			fail(ENV_WG_PRIVATE_KEY, err)
+		}
+		cfg.PrivateKey = &sk
+	}
+	if value, ok := vars[ENV_WG_LISTEN_PORT]; ok {
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			fail(ENV_WG_LISTEN_PORT, errors.New("invalid port"))
+		}
+		cfg.ListenPort = new(int)
+		*cfg.ListenPort = int(port)
+	}
+	if value, ok := vars[ENV_WG_FWMARK]; ok {
+		mark, err := strconv.ParseUint(value, 0, 32)
+		if err != nil {
+			fail(ENV_WG_FWMARK, errors.New("invalid firewall mark"))
+		}
+		cfg.FirewallMark = new(int)
+		*cfg.FirewallMark = int(mark)
+	}
+	var suite *string
+	if value, ok := vars[ENV_WG_CIPHER_SUITE]; ok {
+		if err := checkEnvCipherSuite(value); err != nil {
+			fail(ENV_WG_CIPHER_SUITE, err)
+		}
+		suite = &value
+	}
+
+	peers := make(map[string]*device.PeerConfig)
+	var ids []string
+	for _, name := range slices.Sorted(maps.Keys(vars)) {
+		value := vars[name]
+		rest, ok := strings.CutPrefix(name, ENV_WG_PEER_PREFIX)
+		if !ok {
+			continue
+		}
+		id, field, ok := strings.Cut(rest, "_")
+		if !ok || !isEnvPeerID(id) {
+			fail(name, errors.New("unknown variable"))
+			continue
+		}
+		pc := peers[id]
+		if pc == nil {
+			pc = &device.PeerConfig{ReplaceAllowedIPs: true, CipherSuite: suite}
+			peers[id] = pc
+			ids = append(ids, id)
+		}
+		if err := setEnvPeerField(pc, field, value); err != nil {
+			fail(name, err)
+		}
+	}
+	slices.Sort(ids)
+	cfg.ReplacePeers = true
+	for _, id := range ids {
+		pc := peers[id]
+		if pc.PublicKey.IsZero() {
+			fail(ENV_WG_PEER_PREFIX+id+"_PUBLIC_KEY", errors.New("missing"))
+		}
+		cfg.Peers = append(cfg.Peers, *pc)
+	}
+	return cfg, true, errors.Join(errs...)
+}
+
+// isEnvConfigVar reports whether name is one of the variables envConfig
+// reads, without its file suffix.
+func isEnvConfigVar(name string) bool {
+	switch name {
+	case ENV_WG_PRIVATE_KEY, ENV_WG_LISTEN_PORT, ENV_WG_FWMARK, ENV_WG_CIPHER_SUITE:
+		return true
+	}
+	return strings.HasPrefix(name, ENV_WG_PEER_PREFIX)
+}
+
+func isEnvPeerID(id string) bool {
+	if id == "" {
+		return false
+	}
+	for _, c := range id {
+		if (c < '0' || c > '9') && (c < 'A' || c > 'Z') && (c < 'a' || c > 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+func setEnvPeerField(pc *device.PeerConfig, field, value string) error {
+	switch field {
+	case "PUBLIC_KEY":
+		return parseEnvKey(pc.PublicKey[:], value)
+	case "PRESHARED_KEY":
+		pc.PresharedKey = new(device.NoisePresharedKey)
+		return parseEnvKey(pc.PresharedKey[:], value)
+	case "ENDPOINT":
+		addr, err := net.ResolveUDPAddr("udp", value)
+		if err != nil {
+			return fmt.Errorf("failed to resolve endpoint %q: %w", value, err)
+		}
+		endpoint := addr.AddrPort()
+		endpoint = netip.AddrPortFrom(endpoint.Addr().Unmap(), endpoint.Port())
+		pc.Endpoint = &endpoint
+	case "ALLOWED_IPS":
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field == "" {
+				continue
+			}
+			prefix, err := netip.ParsePrefix(field)
+			if err != nil {
+				addr, err2 := netip.ParseAddr(field)
+				if err2 != nil {
+					return err
+				}
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+			pc.AllowedIPs = append(pc.AllowedIPs, prefix)
+		}
+	case "PERSISTENT_KEEPALIVE":
+		secs, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return errors.New("invalid keepalive interval")
+		}
+		interval := time.Duration(secs) * time.Second
+		pc.PersistentKeepaliveInterval = &interval
+	case "NAME":
+		pc.Name = &value
+	case "CIPHER_SUITE":
+		if err := checkEnvCipherSuite(value); err != nil {
+			return err
+		}
+		pc.CipherSuite = &value
+	default:
+		return errors.New("unknown variable")
+	}
+	return nil
+}
+
+// parseEnvKey decodes a base64 key into dst. Its errors do not include the
+// value, which may be secret.
+func parseEnvKey(dst []byte, value string) error {
+	b, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(b) != len(dst) {
+		return errors.New("invalid key")
+	}
+	copy(dst, b)
+	return nil
+}
+
+func checkEnvCipherSuite(name string) error {
+	if device.LookupCipherSuite(name) == nil {
+		return fmt.Errorf("%w %q", device.ErrUnknownCipherSuite, name)
+	}
+	return nil
+}
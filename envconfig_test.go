@@ -0,0 +1,106 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+const (
+	testEnvPrivateKey = "yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk="
+	testEnvPublicKey  = "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+)
+
+func TestEnvConfig(t *testing.T) {
+	secret := filepath.Join(t.TempDir(), "private_key")
+	if err := os.WriteFile(secret, []byte(testEnvPrivateKey+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, ok, err := envConfig([]string{
+		"HOME=/root",
+		"WG_TUN_FD=3",
+		"WG_PRIVATE_KEY_FILE=" + secret,
+		"WG_LISTEN_PORT=51820",
+		"WG_FWMARK=0x10",
+		"WG_CIPHER_SUITE=" + device.StandardCipherSuite,
+		"WG_PEER_1_PUBLIC_KEY=" + testEnvPublicKey,
+		"WG_PEER_1_ENDPOINT=192.0.2.1:51820",
+		"WG_PEER_1_ALLOWED_IPS=10.0.0.0/24, fd00::1",
+		"WG_PEER_1_PERSISTENT_KEEPALIVE=25",
+		"WG_PEER_1_NAME=gateway",
+	})
+	if err != nil || !ok {
+		t.Fatalf("envConfig = %v, %v", ok, err)
+	}
+	if cfg.PrivateKey == nil || *cfg.ListenPort != 51820 || *cfg.FirewallMark != 0x10 || !cfg.ReplacePeers {
+		t.Errorf("unexpected device configuration %+v", cfg)
+	}
+	var sk device.NoisePrivateKey
+	parseEnvKey(sk[:], testEnvPrivateKey)
+	if *cfg.PrivateKey != sk {
+		t.Error("private key not read from its file")
+	}
+	if len(cfg.Peers) != 1 {
+		t.Fatalf("got %d peers", len(cfg.Peers))
+	}
+	p := cfg.Peers[0]
+	wantIPs := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/24"), netip.MustParsePrefix("fd00::1/128")}
+	if p.PublicKey.IsZero() || *p.Endpoint != netip.MustParseAddrPort("192.0.2.1:51820") || !slices.Equal(p.AllowedIPs, wantIPs) ||
+		*p.PersistentKeepaliveInterval != 25*time.Second || *p.Name != "gateway" || *p.CipherSuite != device.StandardCipherSuite {
+		t.Errorf("unexpected peer configuration %+v", p)
+	}
+
+	if _, ok, err := envConfig([]string{"HOME=/root", "WG_TUN_NAME_FILE=/tmp/name"}); ok || err != nil {
+		t.Errorf("configuration found in unrelated environment: %v", err)
+	}
+}
+
+func TestEnvConfigErrors(t *testing.T) {
+	_, ok, err := envConfig([]string{
+		"WG_PRIVATE_KEY=" + testEnvPrivateKey,
+		"WG_PRIVATE_KEY_FILE=/nonexistent",
+		"WG_LISTEN_PORT=70000",
+		"WG_PEER_1_PRESHARED_KEY=c2VjcmV0",
+		"WG_PEER_1_CIPHER_SUITE=nope",
+		"WG_PEER_1_ALOWED_IPS=10.0.0.0/24",
+		"WG_PEER_x-y_PUBLIC_KEY=" + testEnvPublicKey,
+	})
+	if !ok || err == nil {
+		t.Fatalf("envConfig = %v, %v", ok, err)
+	}
+	msg := err.Error()
+	for _, want := range []string{
+		"WG_PRIVATE_KEY_FILE:",
+		"WG_LISTEN_PORT: invalid port",
+		"WG_PEER_1_PRESHARED_KEY: invalid key",
+		"WG_PEER_1_CIPHER_SUITE:",
+		"WG_PEER_1_ALOWED_IPS: unknown variable",
+		"WG_PEER_x-y_PUBLIC_KEY: unknown variable",
+		"WG_PEER_1_PUBLIC_KEY: missing",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("errors lack %q:\n%s", want, msg)
+		}
+	}
+	if strings.Contains(msg, "c2VjcmV0") {
+		t.Errorf("errors reveal a secret:\n%s", msg)
+	}
+
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "key")
+	os.WriteFile(secret, []byte(testEnvPrivateKey), 0o600)
+	if _, _, err := envConfig([]string{"WG_PRIVATE_KEY=" + testEnvPrivateKey, "WG_PRIVATE_KEY_FILE=" + secret}); err == nil || !strings.Contains(err.Error(), "both") {
+		t.Errorf("key given twice accepted: %v", err)
+	}
+}
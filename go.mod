@@ -1,6 +1,6 @@
 module golang.zx2c4.com/wireguard
 
-go 1.23.1
+go 1.24.0
 
 require (
 	golang.org/x/crypto v0.37.0
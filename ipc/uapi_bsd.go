@@ -54,6 +54,16 @@ func (l *UAPIListener) Addr() net.Addr {
 }
 
 func UAPIListen(name string, file *os.File) (net.Listener, error) {
+	return listenSocket(sockPath(name), file)
+}
+
+// ObserveListen wraps the observer socket of the named interface, as opened
+// by ObserveOpen, in a listener.
+func ObserveListen(name string, file *os.File) (net.Listener, error) {
+	return listenSocket(observeSockPath(name), file)
+}
+
+func listenSocket(socketPath string, file *os.File) (net.Listener, error) {
 	// wrap file in listener
 
 	listener, err := net.FileListener(file)
@@ -71,8 +81,6 @@ func UAPIListen(name string, file *os.File) (net.Listener, error) {
 		unixListener.SetUnlinkOnClose(true)
 	}
 
-	socketPath := sockPath(name)
-
 	// watch for deletion of socket
 
 	uapi.kqueueFd, err = unix.Kqueue()
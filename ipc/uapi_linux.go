@@ -51,6 +51,16 @@ func (l *UAPIListener) Addr() net.Addr {
 }
 
 func UAPIListen(name string, file *os.File) (net.Listener, error) {
+	return listenSocket(sockPath(name), file)
+}
+
+// ObserveListen wraps the observer socket of the named interface, as opened
+// by ObserveOpen, in a listener.
+func ObserveListen(name string, file *os.File) (net.Listener, error) {
+	return listenSocket(observeSockPath(name), file)
+}
+
+func listenSocket(socketPath string, file *os.File) (net.Listener, error) {
 	// wrap file in listener
 
 	listener, err := net.FileListener(file)
@@ -70,8 +80,6 @@ func UAPIListen(name string, file *os.File) (net.Listener, error) {
 
 	// watch for deletion of socket
 
-	socketPath := sockPath(name)
-
 	uapi.inotifyFd, err = unix.InotifyInit()
 	if err != nil {
 		return nil, err
@@ -28,16 +28,47 @@ const (
 // flag in wireguard-android.
 var socketDirectory = "/var/run/wireguard"
 
+// ObserveSocketMode is the mode of the observer socket, which grants
+// read-only access to device state without keys; the control socket is only
+// accessible to its owner.
+var ObserveSocketMode os.FileMode = 0o660
+
 func sockPath(iface string) string {
 	return fmt.Sprintf("%s/%s.sock", socketDirectory, iface)
 }
 
+func observeSockPath(iface string) string {
+	return fmt.Sprintf("%s/%s.observe.sock", socketDirectory, iface)
+}
+
 func UAPIOpen(name string) (*os.File, error) {
+	listener, err := listenUnix(sockPath(name))
+	if err != nil {
+		return nil, err
+	}
+	return listener.File()
+}
+
+// ObserveOpen creates the observer socket of the named interface, on which
+// only the read-only observe operation is served.
+func ObserveOpen(name string) (*os.File, error) {
+	socketPath := observeSockPath(name)
+	listener, err := listenUnix(socketPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(socketPath, ObserveSocketMode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener.File()
+}
+
+func listenUnix(socketPath string) (*net.UnixListener, error) {
 	if err := os.MkdirAll(socketDirectory, 0o755); err != nil {
 		return nil, err
 	}
 
-	socketPath := sockPath(name)
 	addr, err := net.ResolveUnixAddr("unix", socketPath)
 	if err != nil {
 		return nil, err
@@ -48,7 +79,7 @@ func UAPIOpen(name string) (*os.File, error) {
 
 	listener, err := net.ListenUnix("unix", addr)
 	if err == nil {
-		return listener.File()
+		return listener, nil
 	}
 
 	// Test socket, if not in use cleanup and try again.
@@ -58,9 +89,5 @@ func UAPIOpen(name string) (*os.File, error) {
 	if err := os.Remove(socketPath); err != nil {
 		return nil, err
 	}
-	listener, err = net.ListenUnix("unix", addr)
-	if err != nil {
-		return nil, err
-	}
-	return listener.File()
+	return net.ListenUnix("unix", addr)
 }
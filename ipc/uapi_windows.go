@@ -51,18 +51,37 @@ func (l *UAPIListener) Addr() net.Addr {
 
 var UAPISecurityDescriptor *windows.SECURITY_DESCRIPTOR
 
+// ObserveSecurityDescriptor guards the observer pipe, which grants
+// read-only access to device state without keys. Besides administrators, it
+// admits members of Network Configuration Operators without elevation.
+var ObserveSecurityDescriptor *windows.SECURITY_DESCRIPTOR
+
 func init() {
 	var err error
 	UAPISecurityDescriptor, err = windows.SecurityDescriptorFromString("O:SYD:P(A;;GA;;;SY)(A;;GA;;;BA)S:(ML;;NWNRNX;;;HI)")
 	if err != nil {
 		panic(err)
 	}
+	ObserveSecurityDescriptor, err = windows.SecurityDescriptorFromString("O:SYD:P(A;;GA;;;SY)(A;;GA;;;BA)(A;;GRGW;;;NO)")
+	if err != nil {
+		panic(err)
+	}
 }
 
 func UAPIListen(name string) (net.Listener, error) {
+	return listenPipe(`\\.\pipe\ProtectedPrefix\Administrators\WireGuard\`+name, UAPISecurityDescriptor)
+}
+
+// ObserveListen listens on the observer pipe of the named interface, on
+// which only the read-only observe operation is served.
+func ObserveListen(name string) (net.Listener, error) {
+	return listenPipe(`\\.\pipe\ProtectedPrefix\Administrators\WireGuard\`+name+`.observe`, ObserveSecurityDescriptor)
+}
+
+func listenPipe(path string, sd *windows.SECURITY_DESCRIPTOR) (net.Listener, error) {
 	listener, err := (&namedpipe.ListenConfig{
-		SecurityDescriptor: UAPISecurityDescriptor,
-	}).Listen(`\\.\pipe\ProtectedPrefix\Administrators\WireGuard\` + name)
+		SecurityDescriptor: sd,
+	}).Listen(path)
 	if err != nil {
 		return nil, err
 	}
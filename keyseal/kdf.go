@@ -0,0 +1,334 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package keyseal
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/salsa20/salsa"
+)
+
+/* The KDFs are implemented here rather than taken from x/crypto, whose
+ * argon2.IDKey and scrypt.Key return the derived key in a fresh slice of the
+ * Go heap and leave their working memory, from which the key can be
+ * recomputed, to the garbage collector. These write the key into the buffer
+ * they are given, which is in secure memory, and wipe their working memory
+ * before they return. Their outputs are checked against x/crypto in tests.
+ */
+
+const (
+	argon2Version    = 0x13
+	argon2id         = 2
+	argon2SyncPoints = 4
+	argon2BlockWords = 128 // of the 1 KiB blocks
+)
+
+type argon2Block [argon2BlockWords]uint64
+
+// argon2IDKey derives len(dst) bytes into dst with argon2id (RFC 9106).
+func argon2IDKey(dst, password, salt []byte, time, memory uint32, threads uint8) {
+	lanes := uint32(threads)
+	var h0 [blake2b.Size + 8]byte
+	defer clear(h0[:])
+	b2, _ := blake2b.New512(nil)
+	var params [24]byte
+	binary.LittleEndian.PutUint32(params[0:], lanes)
+	binary.LittleEndian.PutUint32(params[4:], uint32(len(dst)))
+	binary.LittleEndian.PutUint32(params[8:], memory)
+	binary.LittleEndian.PutUint32(params[12:], time)
+	binary.LittleEndian.PutUint32(params[16:], argon2Version)
+	binary.LittleEndian.PutUint32(params[20:], argon2id)
+	b2.Write(params[:])
+	for _, input := range [][]byte{password, salt, nil, nil} { // secret and associated data are empty
+		var n [4]byte
+		binary.LittleEndian.PutUint32(n[:], uint32(len(input)))
+		b2.Write(n[:])
+		b2.Write(input)
+	}
+	b2.Sum(h0[:0])
+
+	memory = memory / (argon2SyncPoints * lanes) * (argon2SyncPoints * lanes)
+	memory = max(memory, 2*argon2SyncPoints*lanes)
+	laneLength := memory / lanes
+	B := make([]argon2Block, memory)
+	defer clear(B)
+
+	var block [argon2BlockWords * 8]byte
+	defer clear(block[:])
+	for lane := range lanes {
+		binary.LittleEndian.PutUint32(h0[blake2b.Size+4:], lane)
+		for i := range uint32(2) {
+			binary.LittleEndian.PutUint32(h0[blake2b.Size:], i)
+			blake2bLong(block[:], h0[:])
+			for j := range B[lane*laneLength+i] {
+				B[lane*laneLength+i][j] = binary.LittleEndian.Uint64(block[j*8:])
+			}
+		}
+	}
+
+	for pass := range time {
+		for slice := range uint32(argon2SyncPoints) {
+			var wg sync.WaitGroup
+			for lane := range lanes {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					argon2Segment(B, pass, slice, lane, time, memory, lanes)
+				}()
+			}
+			wg.Wait()
+		}
+	}
+
+	last := &B[memory-1]
+	for lane := range lanes - 1 {
+		for i, v := range B[lane*laneLength+laneLength-1] {
+			last[i] ^= v
+		}
+	}
+	for i, v := range last {
+		binary.LittleEndian.PutUint64(block[i*8:], v)
+	}
+	blake2bLong(dst, block[:])
+}
+
+// argon2Segment fills in one segment of a lane in one pass, with the data
+// independent addressing of argon2i in the first half of the first pass and
+// the data dependent one of argon2d after it.
+func argon2Segment(B []argon2Block, pass, slice, lane, time, memory, lanes uint32) {
+	laneLength := memory / lanes
+	segmentLength := laneLength / argon2SyncPoints
+	independent := pass == 0 && slice < argon2SyncPoints/2
+
+	var addresses, in, zero argon2Block
+	defer clear(addresses[:])
+	if independent {
+		in[0], in[1], in[2] = uint64(pass), uint64(lane), uint64(slice)
+		in[3], in[4], in[5] = uint64(memory), uint64(time), argon2id
+	}
+	nextAddresses := func() {
+		in[6]++
+		argon2Compress(&addresses, &in, &zero, false)
+		argon2Compress(&addresses, &addresses, &zero, false)
+	}
+
+	index := uint32(0)
+	if pass == 0 && slice == 0 {
+		index = 2 // the first two blocks of each lane are filled in already
+		if independent {
+			nextAddresses()
+		}
+	}
+	offset := lane*laneLength + slice*segmentLength + index
+	for ; index < segmentLength; index, offset = index+1, offset+1 {
+		prev := offset - 1
+		if index == 0 && slice == 0 {
+			prev += laneLength // the last block of the lane
+		}
+		var random uint64
+		if independent {
+			if index%argon2BlockWords == 0 {
+				nextAddresses()
+			}
+			random = addresses[index%argon2BlockWords]
+		} else {
+			random = B[prev][0]
+		}
+
+		refLane := uint32(random>>32) % lanes
+		if pass == 0 && slice == 0 {
+			refLane = lane
+		}
+		area, start := 3*segmentLength, ((slice+1)%argon2SyncPoints)*segmentLength
+		if lane == refLane {
+			area += index
+		}
+		if pass == 0 {
+			area, start = slice*segmentLength, 0
+			if slice == 0 || lane == refLane {
+				area += index
+			}
+		}
+		if index == 0 || lane == refLane {
+			area--
+		}
+		p := random & 0xffffffff
+		p = p * p >> 32
+		p = p * uint64(area) >> 32
+		ref := refLane*laneLength + uint32((uint64(start)+uint64(area)-(p+1))%uint64(laneLength))
+
+		argon2Compress(&B[offset], &B[prev], &B[ref], pass > 0)
+	}
+}
+
+// argon2Compress sets out to the compression G of x and y, or XORs it into
+// out if xor is set, as argon2 does from the second pass on.
+func argon2Compress(out, x, y *argon2Block, xor bool) {
+	var r, t argon2Block
+	defer clear(t[:])
+	defer clear(r[:])
+	for i := range r {
+		r[i] = x[i] ^ y[i]
+	}
+	t = r
+	for i := 0; i < argon2BlockWords; i += 16 {
+		blamka(&t[i], &t[i+1], &t[i+2], &t[i+3], &t[i+4], &t[i+5], &t[i+6], &t[i+7],
+			&t[i+8], &t[i+9], &t[i+10], &t[i+11], &t[i+12], &t[i+13], &t[i+14], &t[i+15])
+	}
+	for i := 0; i < 16; i += 2 {
+		blamka(&t[i], &t[i+1], &t[16+i], &t[16+i+1], &t[32+i], &t[32+i+1], &t[48+i], &t[48+i+1],
+			&t[64+i], &t[64+i+1], &t[80+i], &t[80+i+1], &t[96+i], &t[96+i+1], &t[112+i], &t[112+i+1])
+	}
+	for i := range out {
+		if xor {
+			out[i] ^= r[i] ^ t[i]
+		} else {
+			out[i] = r[i] ^ t[i]
+		}
+	}
+}
+
+// blamka is the permutation P of argon2 on 16 words, the BLAKE2b round with
+// its additions replaced by a+b+2*lo(a)*lo(b).
+func blamka(v0, v1, v2, v3, v4, v5, v6, v7, v8, v9, v10, v11, v12, v13, v14, v15 *uint64) {
+	g := func(a, b, c, d *uint64) {
+		fBlaMka := func(x, y uint64) uint64 { return x + y + 2*uint64(uint32(x))*uint64(uint32(y)) }
+		*a = fBlaMka(*a, *b)
+		*d ^= *a
+		*d = *d>>32 | *d<<32
+		*c = fBlaMka(*c, *d)
+		*b ^= *c
+		*b = *b>>24 | *b<<40
+		*a = fBlaMka(*a, *b)
+		*d ^= *a
+		*d = *d>>16 | *d<<48
+		*c = fBlaMka(*c, *d)
+		*b ^= *c
+		*b = *b>>63 | *b<<1
+	}
+	g(v0, v4, v8, v12)
+	g(v1, v5, v9, v13)
+	g(v2, v6, v10, v14)
+	g(v3, v7, v11, v15)
+	g(v0, v5, v10, v15)
+	g(v1, v6, v11, v12)
+	g(v2, v7, v8, v13)
+	g(v3, v4, v9, v14)
+}
+
+// blake2bLong is the variable-length hash H' of argon2, writing len(out)
+// bytes of the hash of in into out.
+func blake2bLong(out, in []byte) {
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(len(out)))
+	if len(out) <= blake2b.Size {
+		b2, _ := blake2b.New(len(out), nil)
+		b2.Write(n[:])
+		b2.Write(in)
+		b2.Sum(out[:0])
+		return
+	}
+	var v [blake2b.Size]byte
+	defer clear(v[:])
+	b2, _ := blake2b.New512(nil)
+	b2.Write(n[:])
+	b2.Write(in)
+	b2.Sum(v[:0])
+	for {
+		copy(out, v[:32])
+		out = out[32:]
+		if len(out) <= blake2b.Size {
+			break
+		}
+		b2.Reset()
+		b2.Write(v[:])
+		b2.Sum(v[:0])
+	}
+	b2, _ = blake2b.New(len(out), nil)
+	b2.Write(v[:])
+	b2.Sum(out[:0])
+}
+
+// scryptKey derives len(dst) bytes into dst with scrypt (RFC 7914). N must
+// be a power of two greater than 1.
+func scryptKey(dst, password, salt []byte, N, r, p int) {
+	blockSize := 128 * r
+	b := make([]byte, p*blockSize)
+	v := make([]byte, N*blockSize)
+	xy := make([]byte, 2*blockSize)
+	defer clear(b)
+	defer clear(v)
+	defer clear(xy)
+
+	pbkdf2SHA256(b, password, salt)
+	for i := range p {
+		scryptMix(b[i*blockSize:(i+1)*blockSize], r, N, v, xy)
+	}
+	pbkdf2SHA256(dst, password, b)
+}
+
+// scryptMix is ROMix of scrypt, replacing the block b in place.
+func scryptMix(b []byte, r, N int, v, xy []byte) {
+	blockSize := 128 * r
+	x, y := xy[:blockSize], xy[blockSize:]
+	copy(x, b)
+	for i := range N {
+		copy(v[i*blockSize:], x)
+		scryptBlockMix(x, y, r)
+		x, y = y, x
+	}
+	for range N {
+		j := int(binary.LittleEndian.Uint64(x[blockSize-64:]) & uint64(N-1))
+		for k, w := range v[j*blockSize : (j+1)*blockSize] {
+			x[k] ^= w
+		}
+		scryptBlockMix(x, y, r)
+		x, y = y, x
+	}
+	copy(b, x)
+}
+
+// scryptBlockMix is BlockMix of scrypt with Salsa20/8, from in to out.
+func scryptBlockMix(in, out []byte, r int) {
+	var t [64]byte
+	defer clear(t[:])
+	copy(t[:], in[(2*r-1)*64:])
+	for i := range 2 * r {
+		for k := range t {
+			t[k] ^= in[i*64+k]
+		}
+		salsa.Core208(&t, &t)
+		// Even blocks go to the first half of out, odd ones to the second.
+		copy(out[(i/2+(i%2)*r)*64:], t[:])
+	}
+}
+
+// pbkdf2SHA256 derives len(dst) bytes into dst with PBKDF2-HMAC-SHA256 at
+// one iteration, the only count scrypt uses.
+func pbkdf2SHA256(dst, password, salt []byte) {
+	mac := hmac.New(sha256.New, password)
+	var counter [4]byte
+	var t [sha256.Size]byte
+	defer clear(t[:])
+	for i := uint32(1); len(dst) > 0; i++ {
+		mac.Reset()
+		mac.Write(salt)
+		binary.BigEndian.PutUint32(counter[:], i)
+		mac.Write(counter[:])
+		if len(dst) >= sha256.Size {
+			// Sum appends in place, as dst has room for it.
+			mac.Sum(dst[:0])
+			dst = dst[sha256.Size:]
+			continue
+		}
+		mac.Sum(t[:0])
+		dst = dst[copy(dst, t[:]):]
+	}
+}
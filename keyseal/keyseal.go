@@ -0,0 +1,217 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package keyseal stores WireGuard keys encrypted at rest under a passphrase.
+//
+// A sealed key is a base64 string, suitable for a configuration file, that
+// holds a 32-byte key encrypted with ChaCha20-Poly1305 under a key derived
+// from the passphrase with argon2id or scrypt. Opened keys, and the keys
+// derived while opening them, live only in locked memory outside the Go heap
+// until Destroy is called. Where memory cannot be locked, or the limit of
+// locked memory is reached, sealing and opening fail with ErrInsecureMemory
+// rather than keep keys in memory that may be swapped.
+package keyseal
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"runtime"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.zx2c4.com/wireguard/device"
+)
+
+const (
+	KeySize = 32
+
+	magic      = "wgk1"
+	saltSize   = 16
+	paramsSize = 9
+	headerSize = len(magic) + 1 + paramsSize + saltSize + chacha20poly1305.NonceSize
+	sealedSize = headerSize + KeySize + chacha20poly1305.Overhead
+)
+
+// A KDF selects how the encryption key is derived from the passphrase.
+type KDF uint8
+
+const (
+	Argon2id KDF = 1 // argon2id, 3 passes over 64 MiB using 4 threads
+	Scrypt   KDF = 2 // scrypt with N=2^17, r=8, p=1
+)
+
+var (
+	ErrMalformed = errors.New("keyseal: malformed sealed key")
+	ErrIncorrect = errors.New("keyseal: incorrect passphrase or corrupted sealed key")
+
+	// ErrInsecureMemory is wrapped by the errors of Seal and Open when
+	// memory to hold keys cannot be locked.
+	ErrInsecureMemory = errors.New("keyseal: memory cannot be locked")
+)
+
+// A PassphraseFunc supplies the passphrase for opening a sealed key, for
+// instance by prompting the user or querying an OS keychain. The returned
+// slice is zeroed after use.
+type PassphraseFunc func() ([]byte, error)
+
+// Passphrase returns a PassphraseFunc that supplies a copy of p.
+func Passphrase(p []byte) PassphraseFunc {
+	return func() ([]byte, error) {
+		return append([]byte(nil), p...), nil
+	}
+}
+
+// A Key is an opened key held in locked memory.
+type Key struct {
+	b []byte
+}
+
+func newKey() (*Key, error) {
+	b, err := allocSecure(KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("keyseal: allocating secure memory: %w", err)
+	}
+	k := &Key{b: b}
+	runtime.SetFinalizer(k, (*Key).Destroy)
+	return k, nil
+}
+
+// Destroy zeroes and releases the key. It is safe to call more than once.
+func (k *Key) Destroy() {
+	if k.b != nil {
+		freeSecure(k.b)
+		k.b = nil
+		runtime.SetFinalizer(k, nil)
+	}
+}
+
+// SetPrivateKey installs the key as the private key of dev. The device keeps
+// its own copy, which is outside the control of this package.
+func (k *Key) SetPrivateKey(dev *device.Device) error {
+	if k.b == nil {
+		return errors.New("keyseal: key destroyed")
+	}
+	return dev.SetPrivateKey(*(*device.NoisePrivateKey)(k.b))
+}
+
+// UseBytes calls fn with the raw key. The slice must not be retained after fn
+// returns.
+func (k *Key) UseBytes(fn func(key []byte)) {
+	fn(k.b)
+}
+
+func (kdf KDF) params() ([]byte, error) {
+	p := make([]byte, paramsSize)
+	switch kdf {
+	case Argon2id:
+		binary.LittleEndian.PutUint32(p[0:], 3)
+		binary.LittleEndian.PutUint32(p[4:], 64*1024)
+		p[8] = 4
+	case Scrypt:
+		p[0] = 17
+		binary.LittleEndian.PutUint32(p[1:], 8)
+		binary.LittleEndian.PutUint32(p[5:], 1)
+	default:
+		return nil, fmt.Errorf("keyseal: unknown KDF %d", kdf)
+	}
+	return p, nil
+}
+
+// deriveKey derives the sealing key into dst according to the KDF identifier
+// and parameters recorded in a sealed key header.
+func deriveKey(dst []byte, kdf KDF, params, salt, passphrase []byte) error {
+	switch kdf {
+	case Argon2id:
+		time, memory, threads := binary.LittleEndian.Uint32(params[0:]), binary.LittleEndian.Uint32(params[4:]), params[8]
+		if time == 0 || time > 64 || memory < 8*uint32(threads) || memory > 4*1024*1024 || threads == 0 {
+			return ErrMalformed
+		}
+		argon2IDKey(dst, passphrase, salt, time, memory, threads)
+	case Scrypt:
+		logN, r, p := params[0], binary.LittleEndian.Uint32(params[1:]), binary.LittleEndian.Uint32(params[5:])
+		if logN < 10 || logN > 22 || r == 0 || r > 32 || p == 0 || p > 16 {
+			return ErrMalformed
+		}
+		scryptKey(dst, passphrase, salt, 1<<logN, int(r), int(p))
+	default:
+		return ErrMalformed
+	}
+	return nil
+}
+
+// Seal encrypts key under passphrase, returning the sealed form.
+func Seal(key []byte, passphrase []byte, kdf KDF) (string, error) {
+	if len(key) != KeySize {
+		return "", errors.New("keyseal: key must be 32 bytes")
+	}
+	params, err := kdf.params()
+	if err != nil {
+		return "", err
+	}
+	out := make([]byte, headerSize, sealedSize)
+	copy(out, magic)
+	out[len(magic)] = byte(kdf)
+	copy(out[len(magic)+1:], params)
+	if _, err := rand.Read(out[len(magic)+1+paramsSize : headerSize]); err != nil {
+		return "", err
+	}
+	salt := out[len(magic)+1+paramsSize : len(magic)+1+paramsSize+saltSize]
+	nonce := out[headerSize-chacha20poly1305.NonceSize : headerSize]
+
+	sealingKey, err := allocSecure(chacha20poly1305.KeySize)
+	if err != nil {
+		return "", fmt.Errorf("keyseal: allocating secure memory: %w", err)
+	}
+	defer freeSecure(sealingKey)
+	if err := deriveKey(sealingKey, kdf, params, salt, passphrase); err != nil {
+		return "", err
+	}
+	aead, _ := chacha20poly1305.New(sealingKey)
+	out = aead.Seal(out, nonce, key, out[:headerSize])
+	return base64.StdEncoding.EncodeToString(out), nil
+}
+
+// Open decrypts a sealed key, obtaining the passphrase from passphrase. The
+// caller must call Destroy on the result when done with it.
+func Open(sealed string, passphrase PassphraseFunc) (*Key, error) {
+	blob, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(blob) != sealedSize || string(blob[:len(magic)]) != magic {
+		return nil, ErrMalformed
+	}
+	kdf := KDF(blob[len(magic)])
+	params := blob[len(magic)+1 : len(magic)+1+paramsSize]
+	salt := blob[len(magic)+1+paramsSize : len(magic)+1+paramsSize+saltSize]
+	nonce := blob[headerSize-chacha20poly1305.NonceSize : headerSize]
+
+	pass, err := passphrase()
+	if err != nil {
+		return nil, fmt.Errorf("keyseal: obtaining passphrase: %w", err)
+	}
+	defer clear(pass)
+
+	sealingKey, err := allocSecure(chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, fmt.Errorf("keyseal: allocating secure memory: %w", err)
+	}
+	defer freeSecure(sealingKey)
+	if err := deriveKey(sealingKey, kdf, params, salt, pass); err != nil {
+		return nil, err
+	}
+
+	key, err := newKey()
+	if err != nil {
+		return nil, err
+	}
+	aead, _ := chacha20poly1305.New(sealingKey)
+	// key.b has exactly KeySize capacity, so Open decrypts in place without
+	// allocating a plaintext copy on the heap.
+	if _, err := aead.Open(key.b[:0], nonce, blob[headerSize:], blob[:headerSize]); err != nil {
+		key.Destroy()
+		return nil, ErrIncorrect
+	}
+	return key, nil
+}
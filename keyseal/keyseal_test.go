@@ -0,0 +1,81 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package keyseal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+func TestSealOpen(t *testing.T) {
+	secret := make([]byte, KeySize)
+	rand.Read(secret)
+	for _, kdf := range []KDF{Argon2id, Scrypt} {
+		sealed, err := Seal(secret, []byte("correct horse"), kdf)
+		if err != nil {
+			t.Fatalf("kdf %d: %v", kdf, err)
+		}
+
+		key, err := Open(sealed, Passphrase([]byte("correct horse")))
+		if err != nil {
+			t.Fatalf("kdf %d: %v", kdf, err)
+		}
+		key.UseBytes(func(b []byte) {
+			if !bytes.Equal(b, secret) {
+				t.Errorf("kdf %d: opened key differs", kdf)
+			}
+		})
+		key.Destroy()
+		key.Destroy()
+
+		if _, err := Open(sealed, Passphrase([]byte("battery staple"))); !errors.Is(err, ErrIncorrect) {
+			t.Errorf("kdf %d: wrong passphrase gave %v", kdf, err)
+		}
+	}
+
+	if _, err := Open("d2drMQ==", Passphrase(nil)); !errors.Is(err, ErrMalformed) {
+		t.Errorf("truncated sealed key gave %v", err)
+	}
+	if _, err := Seal(secret[:16], nil, Argon2id); err == nil {
+		t.Error("short key was sealed")
+	}
+}
+
+func TestKDFs(t *testing.T) {
+	password, salt := []byte("correct horse"), []byte("0123456789abcdef")
+	for _, p := range []struct {
+		time, memory uint32
+		threads      uint8
+		keyLen       int
+	}{{1, 8, 1, 32}, {3, 64, 4, 32}, {2, 100, 3, 16}, {1, 32, 2, 100}, {2, 256, 1, 64}} {
+		t.Run(fmt.Sprintf("argon2id-%d-%d-%d-%d", p.time, p.memory, p.threads, p.keyLen), func(t *testing.T) {
+			dst := make([]byte, p.keyLen)
+			argon2IDKey(dst, password, salt, p.time, p.memory, p.threads)
+			if want := argon2.IDKey(password, salt, p.time, p.memory, p.threads, uint32(p.keyLen)); !bytes.Equal(dst, want) {
+				t.Errorf("key %x, want %x", dst, want)
+			}
+		})
+	}
+	for _, p := range []struct{ N, r, p, keyLen int }{{2, 1, 1, 32}, {1024, 8, 1, 32}, {16, 2, 3, 50}, {64, 3, 2, 16}} {
+		t.Run(fmt.Sprintf("scrypt-%d-%d-%d-%d", p.N, p.r, p.p, p.keyLen), func(t *testing.T) {
+			dst := make([]byte, p.keyLen)
+			scryptKey(dst, password, salt, p.N, p.r, p.p)
+			want, err := scrypt.Key(password, salt, p.N, p.r, p.p, p.keyLen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(dst, want) {
+				t.Errorf("key %x, want %x", dst, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+//go:build wasm
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package keyseal
+
+// allocSecure fails on platforms without a way to lock memory, rather than
+// fall back to the Go heap, which may be written to swap.
+func allocSecure(size int) ([]byte, error) {
+	return nil, ErrInsecureMemory
+}
+
+func freeSecure(b []byte) {
+	clear(b)
+}
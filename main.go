@@ -1,4 +1,4 @@
-//go:build !windows
+//go:build !windows && !wasm
 
 /* SPDX-License-Identifier: MIT
  *
@@ -29,6 +29,7 @@ const (
 const (
 	ENV_WG_TUN_FD             = "WG_TUN_FD"
 	ENV_WG_UAPI_FD            = "WG_UAPI_FD"
+	ENV_WG_OBSERVE_FD         = "WG_OBSERVE_FD"
 	ENV_WG_PROCESS_FOREGROUND = "WG_PROCESS_FOREGROUND"
 )
 
@@ -109,6 +110,14 @@ func main() {
 		return device.LogLevelError
 	}()
 
+	// read configuration from the environment, failing before anything is set up
+
+	envCfg, haveEnvCfg, err := envConfig(os.Environ())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration in environment:\n%v\n", err)
+		os.Exit(ExitSetupFailed)
+	}
+
 	// open TUN device (or use supplied fd)
 
 	tdev, err := func() (tun.Device, error) {
@@ -140,10 +149,15 @@ func main() {
 		}
 	}
 
-	logger := device.NewLogger(
-		logLevel,
-		fmt.Sprintf("(%s) ", interfaceName),
-	)
+	var logger *device.Logger
+	if os.Getenv("LOG_FORMAT") == "json" {
+		logger = device.NewJSONLogger(os.Stdout, logLevel)
+	} else {
+		logger = device.NewLogger(
+			logLevel,
+			fmt.Sprintf("(%s) ", interfaceName),
+		)
+	}
 
 	logger.Verbosef("Starting wireguard-go version %s", Version)
 
@@ -174,12 +188,36 @@ func main() {
 		os.Exit(ExitSetupFailed)
 		return
 	}
+
+	// open observer socket (or use supplied fd)
+
+	fileObserve, err := func() (*os.File, error) {
+		observeFdStr := os.Getenv(ENV_WG_OBSERVE_FD)
+		if observeFdStr == "" {
+			return ipc.ObserveOpen(interfaceName)
+		}
+
+		// use supplied fd
+
+		fd, err := strconv.ParseUint(observeFdStr, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+
+		return os.NewFile(uintptr(fd), ""), nil
+	}()
+	if err != nil {
+		logger.Errorf("Observer socket listen error: %v", err)
+		os.Exit(ExitSetupFailed)
+		return
+	}
 	// daemonize the process
 
 	if !foreground {
 		env := os.Environ()
 		env = append(env, fmt.Sprintf("%s=3", ENV_WG_TUN_FD))
 		env = append(env, fmt.Sprintf("%s=4", ENV_WG_UAPI_FD))
+		env = append(env, fmt.Sprintf("%s=5", ENV_WG_OBSERVE_FD))
 		env = append(env, fmt.Sprintf("%s=1", ENV_WG_PROCESS_FOREGROUND))
 		files := [3]*os.File{}
 		if os.Getenv("LOG_LEVEL") != "" && logLevel != device.LogLevelSilent {
@@ -198,6 +236,7 @@ func main() {
 				files[2], // stderr
 				tdev.File(),
 				fileUAPI,
+				fileObserve,
 			},
 			Dir: ".",
 			Env: env,
@@ -226,6 +265,15 @@ func main() {
 
 	logger.Verbosef("Device started")
 
+	if haveEnvCfg {
+		if err := device.Configure(envCfg); err != nil {
+			logger.Errorf("Failed to apply configuration from environment: %v", err)
+			device.Close()
+			os.Exit(ExitSetupFailed)
+		}
+		logger.Verbosef("Configuration from environment applied")
+	}
+
 	errs := make(chan error)
 	term := make(chan os.Signal, 1)
 
@@ -248,6 +296,25 @@ func main() {
 
 	logger.Verbosef("UAPI listener started")
 
+	observe, err := ipc.ObserveListen(interfaceName, fileObserve)
+	if err != nil {
+		logger.Errorf("Failed to listen on observer socket: %v", err)
+		os.Exit(ExitSetupFailed)
+	}
+
+	go func() {
+		for {
+			conn, err := observe.Accept()
+			if err != nil {
+				errs <- err
+				return
+			}
+			go device.IpcHandleObserver(conn)
+		}
+	}()
+
+	logger.Verbosef("Observer listener started")
+
 	// wait for program to terminate
 
 	signal.Notify(term, unix.SIGTERM)
@@ -262,6 +329,7 @@ func main() {
 	// clean up
 
 	uapi.Close()
+	observe.Close()
 	device.Close()
 
 	logger.Verbosef("Shutting down")
@@ -0,0 +1,19 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// There are no TUN devices or UAPI sockets on wasm, so the device is only
+// available embedded, with a conn.HostBind and a tun/netstack TUN.
+func main() {
+	fmt.Fprintf(os.Stderr, "wireguard-go v%s cannot run as a program on %s/%s; embed package device instead\n", Version, runtime.GOOS, runtime.GOARCH)
+	os.Exit(1)
+}
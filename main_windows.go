@@ -38,6 +38,12 @@ func main() {
 	)
 	logger.Verbosef("Starting wireguard-go version %s", Version)
 
+	envCfg, haveEnvCfg, err := envConfig(os.Environ())
+	if err != nil {
+		logger.Errorf("Invalid configuration in environment:\n%v", err)
+		os.Exit(ExitSetupFailed)
+	}
+
 	tun, err := tun.CreateTUN(interfaceName, 0)
 	if err == nil {
 		realInterfaceName, err2 := tun.Name()
@@ -50,6 +56,13 @@ func main() {
 	}
 
 	device := device.NewDevice(tun, conn.NewDefaultBind(), logger)
+	if haveEnvCfg {
+		if err := device.Configure(envCfg); err != nil {
+			logger.Errorf("Failed to apply configuration from environment: %v", err)
+			device.Close()
+			os.Exit(ExitSetupFailed)
+		}
+	}
 	err = device.Up()
 	if err != nil {
 		logger.Errorf("Failed to bring up device: %v", err)
@@ -78,6 +91,24 @@ func main() {
 	}()
 	logger.Verbosef("UAPI listener started")
 
+	observe, err := ipc.ObserveListen(interfaceName)
+	if err != nil {
+		logger.Errorf("Failed to listen on observer pipe: %v", err)
+		os.Exit(ExitSetupFailed)
+	}
+
+	go func() {
+		for {
+			conn, err := observe.Accept()
+			if err != nil {
+				errs <- err
+				return
+			}
+			go device.IpcHandleObserver(conn)
+		}
+	}()
+	logger.Verbosef("Observer listener started")
+
 	// wait for program to terminate
 
 	signal.Notify(term, os.Interrupt)
@@ -93,6 +124,7 @@ func main() {
 	// clean up
 
 	uapi.Close()
+	observe.Close()
 	device.Close()
 
 	logger.Verbosef("Shutting down")
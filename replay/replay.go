@@ -17,6 +17,10 @@ const (
 	bitMask     = blockBits - 1
 )
 
+// WindowSize is how far behind the highest counter accepted a Filter still
+// accepts counters it has not seen.
+const WindowSize = windowSize
+
 // A Filter rejects replayed messages by checking if message counter value is
 // within a sliding window of previously received messages.
 // The zero value for Filter is an empty filter ready to use.
@@ -32,6 +36,12 @@ func (f *Filter) Reset() {
 	f.ring[0] = 0
 }
 
+// Last returns the highest counter accepted since the filter was reset or
+// advanced.
+func (f *Filter) Last() uint64 {
+	return f.last
+}
+
 // ValidateCounter checks if the counter should be accepted.
 // Overlimit counters (>= limit) are always rejected.
 func (f *Filter) ValidateCounter(counter, limit uint64) bool {
@@ -60,3 +70,13 @@ func (f *Filter) ValidateCounter(counter, limit uint64) bool {
 	f.ring[indexBlock] = new
 	return old != new
 }
+
+// Advance makes the filter reject counter and every counter before it, as if
+// all of them had been received, while accepting those after it.
+func (f *Filter) Advance(counter uint64) {
+	f.last = counter
+	for i := range f.ring {
+		f.ring[i] = ^block(0)
+	}
+	f.ring[(counter>>blockBitLog)&blockMask] = ^block(0) >> (bitMask - counter&bitMask)
+}
@@ -117,3 +117,20 @@ func TestReplay(t *testing.T) {
 	T(0, true)
 	T(windowSize+1, true)
 }
+
+func TestAdvance(t *testing.T) {
+	for _, counter := range []uint64{0, 63, 64, 1000, windowSize * 3} {
+		var filter Filter
+		filter.Advance(counter)
+		for _, n := range []uint64{0, counter / 2, counter} {
+			if filter.ValidateCounter(n, RejectAfterMessages) {
+				t.Errorf("Advance(%d): counter %d accepted", counter, n)
+			}
+		}
+		for n := counter + 1; n < counter+blockBits*2; n++ {
+			if !filter.ValidateCounter(n, RejectAfterMessages) {
+				t.Errorf("Advance(%d): counter %d rejected", counter, n)
+			}
+		}
+	}
+}
@@ -1,12 +1,14 @@
 package tun
 
 import (
+	"bytes"
 	"encoding/binary"
 	"math/bits"
 )
 
-// TODO: Explore SIMD and/or other assembly optimizations.
-func checksumNoFold(b []byte, initial uint64) uint64 {
+// checksumNoFoldGeneric is checksumNoFold without assembly, which amd64
+// uses for long buffers where AVX2 is available.
+func checksumNoFoldGeneric(b []byte, initial uint64) uint64 {
 	tmp := make([]byte, 8)
 	binary.NativeEndian.PutUint64(tmp, initial)
 	ac := binary.BigEndian.Uint64(tmp)
@@ -84,7 +86,10 @@ func checksumNoFold(b []byte, initial uint64) uint64 {
 }
 
 func checksum(b []byte, initial uint64) uint16 {
-	ac := checksumNoFold(b, initial)
+	return foldChecksum(checksumNoFold(b, initial))
+}
+
+func foldChecksum(ac uint64) uint16 {
 	ac = (ac >> 16) + (ac & 0xffff)
 	ac = (ac >> 16) + (ac & 0xffff)
 	ac = (ac >> 16) + (ac & 0xffff)
@@ -100,3 +105,190 @@ func pseudoHeaderChecksumNoFold(protocol uint8, srcAddr, dstAddr []byte, totalLe
 	binary.BigEndian.PutUint16(tmp, totalLen)
 	return checksumNoFold(tmp, sum)
 }
+
+const (
+	ipProtoTCP = 6
+	ipProtoUDP = 17
+
+	ipv6HopByHop     = 0
+	ipv6Routing      = 43
+	ipv6Fragment     = 44
+	ipv6AuthHeader   = 51
+	ipv6DestOptions  = 60
+	ipv6FragmentSize = 8
+)
+
+// A Fragment identifies an IPv4 or IPv6 packet holding part of a larger
+// datagram.
+type Fragment struct {
+	ID     uint32 // identification of the datagram
+	Offset int    // of the fragment's payload within the datagram, in bytes
+	More   bool   // further fragments follow
+}
+
+// ipPacket is an IPv4 or IPv6 packet split at its transport payload.
+type ipPacket struct {
+	proto      uint8 // of the transport payload
+	payloadAt  int   // offset of the transport payload, or of the fragment of it
+	src, dst   []byte
+	fragment   Fragment
+	fragmented bool // the packet holds only part of its datagram
+}
+
+// parseIP splits pkt, walking IPv6 extension headers up to the transport
+// payload. It fails for malformed packets and for those with a routing
+// header, whose final destination is not the one of the IPv6 header.
+func parseIP(pkt []byte) (p ipPacket, ok bool) {
+	if len(pkt) < 1 {
+		return p, false
+	}
+	switch pkt[0] >> 4 {
+	case 4:
+		p.payloadAt = int(pkt[0]&0x0f) * 4
+		if p.payloadAt < 20 || len(pkt) < p.payloadAt {
+			return p, false
+		}
+		p.proto, p.src, p.dst = pkt[9], pkt[12:16], pkt[16:20]
+		flags := binary.BigEndian.Uint16(pkt[6:])
+		p.fragment = Fragment{
+			ID:     uint32(binary.BigEndian.Uint16(pkt[4:])),
+			Offset: int(flags&0x1fff) * 8,
+			More:   flags&0x2000 != 0,
+		}
+	case 6:
+		if len(pkt) < 40 {
+			return p, false
+		}
+		p.src, p.dst = pkt[8:24], pkt[24:40]
+		p.proto, p.payloadAt = pkt[6], 40
+	walk:
+		for {
+			if len(pkt) < p.payloadAt+2 {
+				break
+			}
+			switch p.proto {
+			case ipv6HopByHop, ipv6DestOptions:
+				p.proto = pkt[p.payloadAt]
+				p.payloadAt += (int(pkt[p.payloadAt+1]) + 1) * 8
+			case ipv6AuthHeader:
+				p.proto = pkt[p.payloadAt]
+				p.payloadAt += (int(pkt[p.payloadAt+1]) + 2) * 4
+			case ipv6Fragment:
+				if len(pkt) < p.payloadAt+ipv6FragmentSize {
+					return p, false
+				}
+				p.proto = pkt[p.payloadAt]
+				field := binary.BigEndian.Uint16(pkt[p.payloadAt+2:])
+				p.fragment = Fragment{
+					ID:     binary.BigEndian.Uint32(pkt[p.payloadAt+4:]),
+					Offset: int(field &^ 7),
+					More:   field&1 != 0,
+				}
+				p.payloadAt += ipv6FragmentSize
+			case ipv6Routing:
+				return p, false
+			default:
+				break walk
+			}
+		}
+		if len(pkt) < p.payloadAt {
+			return p, false
+		}
+	default:
+		return p, false
+	}
+	p.fragmented = p.fragment.Offset != 0 || p.fragment.More
+	return p, true
+}
+
+// ParseFragment reports whether pkt is an IPv4 or IPv6 fragment, and if so,
+// which part of which datagram it holds.
+func ParseFragment(pkt []byte) (Fragment, bool) {
+	p, ok := parseIP(pkt)
+	return p.fragment, ok && p.fragmented
+}
+
+// transportChecksumAt returns the offset of the checksum of a transport
+// header at payloadAt, or -1 if proto has none to compute.
+func transportChecksumAt(proto uint8, payloadAt int) int {
+	switch proto {
+	case ipProtoTCP:
+		return payloadAt + 16
+	case ipProtoUDP:
+		return payloadAt + 6
+	}
+	return -1
+}
+
+// storeTransportChecksum folds sum, the unfolded sum of the pseudo-header
+// and the transport payload, and stores its complement at pkt[csumAt:].
+func storeTransportChecksum(pkt []byte, csumAt int, proto uint8, sum uint64) {
+	csum := ^foldChecksum(sum)
+	if csum == 0 && proto == ipProtoUDP {
+		csum = 0xffff // a zero UDP checksum means none was computed
+	}
+	binary.BigEndian.PutUint16(pkt[csumAt:], csum)
+}
+
+// TransportChecksum computes the TCP or UDP checksum of an unfragmented IPv4
+// or IPv6 packet and stores it in the transport header, standing in for
+// checksum offload on TUN implementations that have no kernel beneath them.
+// It returns the number of bytes summed, or 0 if the packet was left alone
+// because it carries neither protocol, is a fragment, or is malformed.
+// Fragments are left to FragmentChecksum.
+func TransportChecksum(pkt []byte) int {
+	p, ok := parseIP(pkt)
+	if !ok || p.fragmented {
+		return 0
+	}
+	csumAt := transportChecksumAt(p.proto, p.payloadAt)
+	if csumAt < 0 || len(pkt) < csumAt+2 || len(pkt)-p.payloadAt > 0xffff {
+		return 0
+	}
+	pkt[csumAt], pkt[csumAt+1] = 0, 0
+	n := len(pkt) - p.payloadAt
+	psum := pseudoHeaderChecksumNoFold(p.proto, p.src, p.dst, uint16(n))
+	storeTransportChecksum(pkt, csumAt, p.proto, checksumNoFold(pkt[p.payloadAt:], psum))
+	return n
+}
+
+// FragmentChecksum computes the TCP or UDP checksum of a datagram from all
+// of its fragments, in order, and stores it in the transport header of the
+// first. The payloads are summed unfolded across fragments and folded once.
+// It returns the number of bytes summed, or 0 if the fragments were left
+// alone because they carry neither protocol, are not the contiguous
+// fragments of one datagram, or are malformed.
+func FragmentChecksum(fragments [][]byte) int {
+	if len(fragments) == 0 {
+		return 0
+	}
+	first, ok := parseIP(fragments[0])
+	if !ok || first.fragment.Offset != 0 {
+		return 0
+	}
+	csumAt := transportChecksumAt(first.proto, first.payloadAt)
+	if csumAt < 0 || len(fragments[0]) < csumAt+2 {
+		return 0
+	}
+	n := 0
+	for i, pkt := range fragments {
+		p, ok := parseIP(pkt)
+		if !ok || !p.fragmented || p.fragment.ID != first.fragment.ID || p.fragment.Offset != n ||
+			p.fragment.More != (i < len(fragments)-1) || !bytes.Equal(p.src, first.src) || !bytes.Equal(p.dst, first.dst) {
+			return 0
+		}
+		n += len(pkt) - p.payloadAt
+	}
+	if n > 0xffff {
+		return 0
+	}
+	pkt := fragments[0]
+	pkt[csumAt], pkt[csumAt+1] = 0, 0
+	sum := pseudoHeaderChecksumNoFold(first.proto, first.src, first.dst, uint16(n))
+	for _, pkt := range fragments {
+		p, _ := parseIP(pkt)
+		sum = checksumNoFold(pkt[p.payloadAt:], sum)
+	}
+	storeTransportChecksum(fragments[0], csumAt, first.proto, sum)
+	return n
+}
@@ -0,0 +1,42 @@
+//go:build amd64 && !purego
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package tun
+
+import (
+	"math/bits"
+
+	"golang.org/x/sys/cpu"
+)
+
+// checksumHasAVX2 selects the vectorized sum of long buffers.
+var checksumHasAVX2 = cpu.X86.HasAVX2
+
+// checksumVectorMin is the length from which the vectorized sum pays for
+// combining its result with the scalar one.
+const checksumVectorMin = 256
+
+// checksumAVX2 returns the sum of the little-endian 32-bit words of b, whose
+// length is a multiple of 32, in 64-bit lanes that cannot overflow for any
+// buffer that fits in memory.
+//
+//go:noescape
+func checksumAVX2(b []byte) uint64
+
+func checksumNoFold(b []byte, initial uint64) uint64 {
+	if checksumHasAVX2 && len(b) >= checksumVectorMin {
+		// checksumNoFoldGeneric sums native words of byte-swapped
+		// initial, and any sum of aligned words is congruent to the
+		// 16-bit one's complement sum, so the vectorized sum is added
+		// in that order with an end-around carry.
+		n := len(b) &^ 31
+		sum, carry := bits.Add64(bits.ReverseBytes64(initial), checksumAVX2(b[:n]), 0)
+		initial = bits.ReverseBytes64(sum + carry)
+		b = b[n:]
+	}
+	return checksumNoFoldGeneric(b, initial)
+}
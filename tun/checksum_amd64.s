@@ -0,0 +1,41 @@
+//go:build amd64 && !purego
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+#include "textflag.h"
+
+// Each iteration zero-extends eight 32-bit words into 64-bit lanes and adds
+// them to two accumulators, Y0 and Y1, which are summed across lanes at the
+// end.
+
+// func checksumAVX2(b []byte) uint64
+TEXT ·checksumAVX2(SB), NOSPLIT, $0-32
+	MOVQ b_base+0(FP), SI
+	MOVQ b_len+8(FP), CX
+	VPXOR Y0, Y0, Y0
+	VPXOR Y1, Y1, Y1
+
+loop:
+	CMPQ      CX, $32
+	JB        done
+	VPMOVZXDQ (SI), Y2
+	VPMOVZXDQ 16(SI), Y3
+	VPADDQ    Y2, Y0, Y0
+	VPADDQ    Y3, Y1, Y1
+	ADDQ      $32, SI
+	SUBQ      $32, CX
+	JMP       loop
+
+done:
+	VPADDQ       Y1, Y0, Y0
+	VEXTRACTI128 $1, Y0, X1
+	VPADDQ       X1, X0, X0
+	VPSHUFD      $0x4e, X0, X1
+	VPADDQ       X1, X0, X0
+	VMOVQ        X0, AX
+	VZEROUPPER
+	MOVQ         AX, ret+24(FP)
+	RET
@@ -0,0 +1,12 @@
+//go:build !amd64 || purego
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package tun
+
+func checksumNoFold(b []byte, initial uint64) uint64 {
+	return checksumNoFoldGeneric(b, initial)
+}
@@ -5,8 +5,6 @@ import (
 	"fmt"
 	"math/rand"
 	"testing"
-
-	"golang.org/x/sys/unix"
 )
 
 func checksumRef(b []byte, initial uint16) uint16 {
@@ -58,9 +56,9 @@ func TestPseudoHeaderChecksum(t *testing.T) {
 			rng.Read(srcAddr)
 			rng.Read(dstAddr)
 			rng.Read(buf)
-			phSum := pseudoHeaderChecksumNoFold(unix.IPPROTO_TCP, srcAddr, dstAddr, uint16(length))
+			phSum := pseudoHeaderChecksumNoFold(ipProtoTCP, srcAddr, dstAddr, uint16(length))
 			csum := checksum(buf, phSum)
-			phSumRef := pseudoHeaderChecksumRefNoFold(unix.IPPROTO_TCP, srcAddr, dstAddr, uint16(length))
+			phSumRef := pseudoHeaderChecksumRefNoFold(ipProtoTCP, srcAddr, dstAddr, uint16(length))
 			csumRef := checksumRef(buf, phSumRef)
 			if csum != csumRef {
 				t.Error("Expected checksumRef", csumRef, "got", csum)
@@ -96,3 +94,159 @@ func BenchmarkChecksum(b *testing.B) {
 		})
 	}
 }
+
+func TestTransportChecksum(t *testing.T) {
+	payload := make([]byte, 1000)
+	rand.Read(payload)
+	for _, tc := range []struct {
+		name   string
+		isV6   bool
+		proto  uint8
+		hdrLen int
+	}{
+		{"tcp4", false, ipProtoTCP, 20},
+		{"udp4", false, ipProtoUDP, 8},
+		{"tcp6", true, ipProtoTCP, 20},
+		{"udp6", true, ipProtoUDP, 8},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var pkt []byte
+			var src, dst []byte
+			l4Len := tc.hdrLen + len(payload)
+			if tc.isV6 {
+				pkt = make([]byte, 40)
+				pkt[0] = 0x60
+				binary.BigEndian.PutUint16(pkt[4:], uint16(l4Len))
+				pkt[6] = tc.proto
+				src, dst = pkt[8:24], pkt[24:40]
+			} else {
+				pkt = make([]byte, 20)
+				pkt[0] = 0x45
+				binary.BigEndian.PutUint16(pkt[2:], uint16(20+l4Len))
+				pkt[9] = tc.proto
+				src, dst = pkt[12:16], pkt[16:20]
+			}
+			rand.Read(src)
+			rand.Read(dst)
+			iphLen := len(pkt)
+			pkt = append(pkt, make([]byte, tc.hdrLen)...)
+			pkt = append(pkt, payload...)
+			if tc.proto == ipProtoTCP {
+				pkt[iphLen+12] = 5 << 4
+			}
+
+			if n := TransportChecksum(pkt); n != l4Len {
+				t.Fatalf("TransportChecksum summed %d bytes, want %d", n, l4Len)
+			}
+			sum := pseudoHeaderChecksumRefNoFold(tc.proto, src, dst, uint16(l4Len))
+			if got := checksumRef(pkt[iphLen:], sum); got != 0xffff {
+				t.Errorf("checksum does not verify: folded sum %#04x", got)
+			}
+		})
+	}
+
+	fragment := make([]byte, 40)
+	fragment[0], fragment[6], fragment[9] = 0x45, 0x20, ipProtoUDP
+	if TransportChecksum(fragment) != 0 {
+		t.Error("checksum computed for IPv4 fragment")
+	}
+}
+
+func TestTransportChecksumExtensionHeaders(t *testing.T) {
+	payload := make([]byte, 1000)
+	rand.Read(payload)
+	pkt := make([]byte, 40+16+8)
+	pkt[0], pkt[6] = 0x60, 0 // hop-by-hop options
+	binary.BigEndian.PutUint16(pkt[4:], uint16(16+8+len(payload)))
+	rand.Read(pkt[8:40])
+	pkt[40] = 60         // destination options follow
+	pkt[48] = ipProtoUDP // the UDP header follows
+	pkt = append(pkt, payload...)
+
+	l4Len := 8 + len(payload)
+	if n := TransportChecksum(pkt); n != l4Len {
+		t.Fatalf("TransportChecksum summed %d bytes, want %d", n, l4Len)
+	}
+	sum := pseudoHeaderChecksumRefNoFold(ipProtoUDP, pkt[8:24], pkt[24:40], uint16(l4Len))
+	if got := checksumRef(pkt[56:], sum); got != 0xffff {
+		t.Errorf("checksum does not verify: folded sum %#04x", got)
+	}
+
+	pkt[40] = ipv6Routing
+	if TransportChecksum(pkt) != 0 {
+		t.Error("checksum computed past a routing header")
+	}
+}
+
+func TestFragmentChecksum(t *testing.T) {
+	datagram := make([]byte, 8+3000)
+	rand.Read(datagram[8:])
+	src, dst := make([]byte, 16), make([]byte, 16)
+	rand.Read(src)
+	rand.Read(dst)
+
+	for _, isV6 := range []bool{false, true} {
+		var fragments [][]byte
+		for offset := 0; offset < len(datagram); offset += 1232 {
+			chunk := datagram[offset:min(offset+1232, len(datagram))]
+			more := offset+len(chunk) < len(datagram)
+			var pkt []byte
+			if isV6 {
+				pkt = make([]byte, 48)
+				pkt[0], pkt[6] = 0x60, ipv6Fragment
+				binary.BigEndian.PutUint16(pkt[4:], uint16(8+len(chunk)))
+				copy(pkt[8:], src)
+				copy(pkt[24:], dst)
+				pkt[40] = ipProtoUDP
+				field := uint16(offset)
+				if more {
+					field |= 1
+				}
+				binary.BigEndian.PutUint16(pkt[42:], field)
+				binary.BigEndian.PutUint32(pkt[44:], 0xdeadbeef)
+			} else {
+				pkt = make([]byte, 20)
+				pkt[0], pkt[9] = 0x45, ipProtoUDP
+				binary.BigEndian.PutUint16(pkt[2:], uint16(20+len(chunk)))
+				binary.BigEndian.PutUint16(pkt[4:], 0xbeef)
+				flags := uint16(offset / 8)
+				if more {
+					flags |= 0x2000
+				}
+				binary.BigEndian.PutUint16(pkt[6:], flags)
+				copy(pkt[12:], src[:4])
+				copy(pkt[16:], dst[:4])
+			}
+			fragments = append(fragments, append(pkt, chunk...))
+		}
+
+		for i, pkt := range fragments {
+			frag, ok := ParseFragment(pkt)
+			if !ok || frag.Offset != i*1232 || frag.More != (i < len(fragments)-1) {
+				t.Errorf("fragment %d parsed as %+v, %v", i, frag, ok)
+			}
+			if TransportChecksum(pkt) != 0 {
+				t.Errorf("checksum computed for fragment %d", i)
+			}
+		}
+		if FragmentChecksum(fragments[1:]) != 0 || FragmentChecksum([][]byte{fragments[0], fragments[2]}) != 0 {
+			t.Error("checksum computed for incomplete fragments")
+		}
+		if n := FragmentChecksum(fragments); n != len(datagram) {
+			t.Fatalf("FragmentChecksum summed %d bytes, want %d", n, len(datagram))
+		}
+		var reassembled []byte
+		iphLen := 20
+		addrLen := 4
+		if isV6 {
+			iphLen, addrLen = 48, 16
+		}
+		for _, pkt := range fragments {
+			reassembled = append(reassembled, pkt[iphLen:]...)
+		}
+		sum := pseudoHeaderChecksumRefNoFold(ipProtoUDP, src[:addrLen], dst[:addrLen], uint16(len(reassembled)))
+		if got := checksumRef(reassembled, sum); got != 0xffff {
+			t.Errorf("checksum does not verify: folded sum %#04x", got)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package netstack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv6"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+/* IPv6 router
+ *
+ * A Net serving several peers can act as their IPv6 router, so that client
+ * operating systems autoconfigure addresses through the tunnel without a
+ * router advertisement daemon of their own. With the router enabled, the
+ * Net answers router solicitations from link-local addresses with a router
+ * advertisement carrying a /64 prefix of its own for each client, delegated
+ * from the configured pool in order and renewed by each solicitation, along
+ * with the MTU and DNS servers. It answers neighbor solicitations for its
+ * router address too. Other neighbor discovery messages go to the stack.
+ *
+ * Replies are unicast to the soliciting address, since the device cannot
+ * route multicast to a peer, so each peer's allowed IPs must include its
+ * link-local address, and its delegated prefix to route traffic to the
+ * addresses it configures; OnDelegate is called with each new delegation
+ * so that the embedder can add it. Delegations not renewed within the
+ * lifetime may be reclaimed for other clients.
+ */
+
+// DefaultRouterLifetime is the router and prefix lifetime advertised unless
+// configured otherwise.
+const DefaultRouterLifetime = 30 * time.Minute
+
+// maxRouterLifetime is the longest router lifetime an advertisement can
+// carry, per RFC 4861.
+const maxRouterLifetime = 9000 * time.Second
+
+// RouterConfig configures the IPv6 router of a Net.
+type RouterConfig struct {
+	// Pool is the prefix the /64 prefixes of clients are delegated from.
+	Pool netip.Prefix
+
+	// Address is the link-local address advertisements are sent from;
+	// fe80::1 if not valid.
+	Address netip.Addr
+
+	// Lifetime is the router lifetime and the valid and preferred
+	// lifetimes of prefixes advertised; DefaultRouterLifetime if zero.
+	Lifetime time.Duration
+
+	// DNS is advertised in a recursive DNS server option; the IPv6 DNS
+	// servers of the Net if nil.
+	DNS []netip.Addr
+
+	// OnDelegate, if not nil, is called with each prefix delegated to a
+	// new client.
+	OnDelegate func(Delegation)
+}
+
+// A Delegation is a prefix delegated to a client of the router.
+type Delegation struct {
+	Client  netip.Addr // link-local address the client solicits from
+	Prefix  netip.Prefix
+	Expires time.Time
+}
+
+type ipv6Router struct {
+	cfg         RouterConfig
+	mtu         int
+	mu          sync.Mutex
+	delegations map[netip.Addr]*Delegation // by client
+}
+
+// EnableRouter makes the Net act as the IPv6 router of its peers.
+func (net *Net) EnableRouter(cfg RouterConfig) error {
+	if !cfg.Pool.Addr().Is6() || cfg.Pool.Bits() > 64 || cfg.Pool.Masked() != cfg.Pool {
+		return fmt.Errorf("invalid delegation pool %v", cfg.Pool)
+	}
+	if !cfg.Address.IsValid() {
+		cfg.Address = netip.MustParseAddr("fe80::1")
+	}
+	if !cfg.Address.Is6() || !cfg.Address.IsLinkLocalUnicast() {
+		return fmt.Errorf("router address %v is not link-local", cfg.Address)
+	}
+	if cfg.Lifetime == 0 {
+		cfg.Lifetime = DefaultRouterLifetime
+	}
+	if cfg.Lifetime < time.Second || cfg.Lifetime > maxRouterLifetime {
+		return fmt.Errorf("router lifetime %v out of range", cfg.Lifetime)
+	}
+	if cfg.DNS == nil {
+		for _, addr := range net.dnsServers {
+			if addr.Is6() {
+				cfg.DNS = append(cfg.DNS, addr)
+			}
+		}
+	}
+	for _, addr := range cfg.DNS {
+		if !addr.Is6() {
+			return fmt.Errorf("DNS server %v is not an IPv6 address", addr)
+		}
+	}
+
+	tun := (*netTun)(net)
+	if tun.router.Load() != nil {
+		return errors.New("router already enabled")
+	}
+	tcpipErr := tun.stack.AddProtocolAddress(1, tcpip.ProtocolAddress{
+		Protocol:          ipv6.ProtocolNumber,
+		AddressWithPrefix: tcpip.AddrFrom16(cfg.Address.As16()).WithPrefix(),
+	}, stack.AddressProperties{})
+	if tcpipErr != nil {
+		return fmt.Errorf("AddProtocolAddress(%v): %v", cfg.Address, tcpipErr)
+	}
+	if !tun.hasV6 {
+		tun.stack.AddRoute(tcpip.Route{Destination: header.IPv6EmptySubnet, NIC: 1})
+	}
+	tun.router.Store(&ipv6Router{cfg: cfg, mtu: tun.mtu, delegations: make(map[netip.Addr]*Delegation)})
+	return nil
+}
+
+// Delegations returns the prefixes delegated by the router, ordered by
+// prefix.
+func (net *Net) Delegations() []Delegation {
+	r := (*netTun)(net).router.Load()
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	delegations := make([]Delegation, 0, len(r.delegations))
+	for _, d := range r.delegations {
+		delegations = append(delegations, *d)
+	}
+	r.mu.Unlock()
+	slices.SortFunc(delegations, func(a, b Delegation) int { return a.Prefix.Addr().Compare(b.Prefix.Addr()) })
+	return delegations
+}
+
+// handle answers packet if it is neighbor discovery for the router, and
+// reports whether it was consumed.
+func (r *ipv6Router) handle(packet []byte) (reply []byte, consumed bool) {
+	if len(packet) < header.IPv6MinimumSize+header.ICMPv6MinimumSize || packet[6] != byte(header.ICMPv6ProtocolNumber) {
+		return nil, false
+	}
+	icmp := packet[header.IPv6MinimumSize:]
+	icmp = icmp[:min(len(icmp), int(binary.BigEndian.Uint16(packet[4:])))]
+	src := netip.AddrFrom16([16]byte(packet[8:24]))
+	hopLimit := packet[7]
+	switch header.ICMPv6Type(icmp[0]) {
+	case header.ICMPv6RouterSolicit:
+		// Solicitations from the unspecified address would need a
+		// multicast reply.
+		if hopLimit != 255 || !src.IsLinkLocalUnicast() {
+			return nil, true
+		}
+		return r.advertise(src, time.Now()), true
+	case header.ICMPv6NeighborSolicit:
+		if len(icmp) < header.ICMPv6NeighborSolicitMinimumSize || netip.AddrFrom16([16]byte(icmp[8:24])) != r.cfg.Address {
+			return nil, false
+		}
+		if hopLimit != 255 || !src.IsValid() || src.IsUnspecified() {
+			return nil, true
+		}
+		return r.neighborAdvert(src), true
+	}
+	return nil, false
+}
+
+// delegate returns the delegation of client, renewing it, or delegating a
+// prefix to it if it has none. It reports whether the delegation is new,
+// and false for ok if the pool is exhausted.
+func (r *ipv6Router) delegate(client netip.Addr, now time.Time) (d Delegation, isNew, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	expires := now.Add(r.cfg.Lifetime)
+	if d := r.delegations[client]; d != nil {
+		d.Expires = expires
+		return *d, false, true
+	}
+	taken := make(map[netip.Prefix]netip.Addr, len(r.delegations))
+	for c, d := range r.delegations {
+		taken[d.Prefix] = c
+	}
+	base := r.cfg.Pool.Addr().As16()
+	upper := binary.BigEndian.Uint64(base[:8])
+	count := uint64(1) << min(64-r.cfg.Pool.Bits(), 16)
+	for i := range count {
+		var a [16]byte
+		binary.BigEndian.PutUint64(a[:8], upper+i)
+		prefix := netip.PrefixFrom(netip.AddrFrom16(a), 64)
+		if holder, ok := taken[prefix]; ok {
+			if r.delegations[holder].Expires.After(now) {
+				continue
+			}
+			delete(r.delegations, holder)
+		}
+		d := Delegation{Client: client, Prefix: prefix, Expires: expires}
+		r.delegations[client] = &d
+		return d, true, true
+	}
+	return Delegation{}, false, false
+}
+
+// advertise returns a router advertisement for client, or nil if the pool
+// is exhausted.
+func (r *ipv6Router) advertise(client netip.Addr, now time.Time) []byte {
+	d, isNew, ok := r.delegate(client, now)
+	if !ok {
+		return nil
+	}
+	if isNew && r.cfg.OnDelegate != nil {
+		r.cfg.OnDelegate(d)
+	}
+	lifetime := uint32(r.cfg.Lifetime / time.Second)
+
+	// Router advertisement, then prefix information, MTU, and recursive
+	// DNS server options.
+	size := header.ICMPv6HeaderSize + header.NDPRAMinimumSize + 32 + 8
+	if len(r.cfg.DNS) != 0 {
+		size += 8 + 16*len(r.cfg.DNS)
+	}
+	icmp := make([]byte, size)
+	icmp[0] = byte(header.ICMPv6RouterAdvert)
+	ra := icmp[header.ICMPv6HeaderSize:]
+	ra[0] = 64 // current hop limit
+	binary.BigEndian.PutUint16(ra[2:], uint16(lifetime))
+	opts := ra[header.NDPRAMinimumSize:]
+	opts[0], opts[1] = 3, 4 // prefix information
+	opts[2] = 64
+	opts[3] = 0x40 // autonomous, not on-link: peers are reached through the router
+	binary.BigEndian.PutUint32(opts[4:], lifetime)
+	binary.BigEndian.PutUint32(opts[8:], lifetime)
+	prefix := d.Prefix.Addr().As16()
+	copy(opts[16:32], prefix[:])
+	opts = opts[32:]
+	opts[0], opts[1] = 5, 1 // MTU
+	binary.BigEndian.PutUint32(opts[4:], uint32(r.mtu))
+	if len(r.cfg.DNS) != 0 {
+		opts = opts[8:]
+		opts[0], opts[1] = 25, byte(1+2*len(r.cfg.DNS)) // recursive DNS servers
+		binary.BigEndian.PutUint32(opts[4:], lifetime)
+		for i, addr := range r.cfg.DNS {
+			a := addr.As16()
+			copy(opts[8+16*i:], a[:])
+		}
+	}
+	return r.packet(client, icmp)
+}
+
+// neighborAdvert returns a solicited neighbor advertisement of the router
+// address for dst.
+func (r *ipv6Router) neighborAdvert(dst netip.Addr) []byte {
+	icmp := make([]byte, header.ICMPv6NeighborAdvertMinimumSize)
+	icmp[0] = byte(header.ICMPv6NeighborAdvert)
+	icmp[4] = 0xe0 // router, solicited, override
+	target := r.cfg.Address.As16()
+	copy(icmp[8:], target[:])
+	return r.packet(dst, icmp)
+}
+
+// packet returns an IPv6 packet carrying the ICMPv6 message icmp from the
+// router address to dst, filling in its checksum.
+func (r *ipv6Router) packet(dst netip.Addr, icmp []byte) []byte {
+	src, dst16 := r.cfg.Address.As16(), dst.As16()
+	binary.BigEndian.PutUint16(icmp[2:], header.ICMPv6Checksum(header.ICMPv6ChecksumParams{
+		Header: icmp,
+		Src:    tcpip.AddrFrom16(src),
+		Dst:    tcpip.AddrFrom16(dst16),
+	}))
+	packet := make([]byte, header.IPv6MinimumSize+len(icmp))
+	packet[0] = 0x60
+	binary.BigEndian.PutUint16(packet[4:], uint16(len(icmp)))
+	packet[6] = byte(header.ICMPv6ProtocolNumber)
+	packet[7] = 255 // hop limit of neighbor discovery
+	copy(packet[8:24], src[:])
+	copy(packet[24:40], dst16[:])
+	copy(packet[40:], icmp)
+	return packet
+}
+
+// writeOutbound queues packet to be read from the TUN device, as if the
+// stack had sent it.
+func (tun *netTun) writeOutbound(packet []byte) {
+	var pkts stack.PacketBufferList
+	pkts.PushBack(stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buffer.MakeWithData(packet)}))
+	tun.ep.WritePackets(pkts)
+	pkts.DecRef()
+}
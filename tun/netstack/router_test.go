@@ -0,0 +1,131 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package netstack
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// ndpPacket returns a neighbor discovery message of type typ from src to
+// dst, with target appended if valid.
+func ndpPacket(typ header.ICMPv6Type, src, dst, target netip.Addr) []byte {
+	icmp := make([]byte, 8)
+	icmp[0] = byte(typ)
+	if target.IsValid() {
+		t := target.As16()
+		icmp = append(icmp, t[:]...)
+	}
+	packet := make([]byte, header.IPv6MinimumSize, header.IPv6MinimumSize+len(icmp))
+	packet[0] = 0x60
+	binary.BigEndian.PutUint16(packet[4:], uint16(len(icmp)))
+	packet[6], packet[7] = byte(header.ICMPv6ProtocolNumber), 255
+	s, d := src.As16(), dst.As16()
+	copy(packet[8:], s[:])
+	copy(packet[24:], d[:])
+	return append(packet, icmp...)
+}
+
+func TestRouter(t *testing.T) {
+	tunDev, tnet, err := CreateNetTUN([]netip.Addr{netip.MustParseAddr("fd00::1")}, []netip.Addr{netip.MustParseAddr("fd00::53")}, 1420)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tunDev.Close()
+	if err := tnet.EnableRouter(RouterConfig{Pool: netip.MustParsePrefix("2001:db8::/65")}); err == nil {
+		t.Error("pool longer than /64 accepted")
+	}
+	var delegated []Delegation
+	err = tnet.EnableRouter(RouterConfig{
+		Pool:       netip.MustParsePrefix("2001:db8:0:100::/56"),
+		OnDelegate: func(d Delegation) { delegated = append(delegated, d) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := netip.MustParseAddr("fe80::1")
+	allRouters := netip.MustParseAddr("ff02::2")
+	packets := make(chan []byte, 16)
+	go func() {
+		bufs := [][]byte{make([]byte, 1500)}
+		sizes := make([]int, 1)
+		for {
+			if _, err := tunDev.Read(bufs, sizes, 0); err != nil {
+				close(packets)
+				return
+			}
+			packets <- append([]byte(nil), bufs[0][:sizes[0]]...)
+		}
+	}()
+	// exchange writes packet and returns the reply to dst of type typ,
+	// skipping what the stack sends on its own.
+	exchange := func(packet []byte, typ header.ICMPv6Type, dst netip.Addr) []byte {
+		t.Helper()
+		if _, err := tunDev.Write([][]byte{packet}, 0); err != nil {
+			t.Fatal(err)
+		}
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case reply := <-packets:
+				if len(reply) > header.IPv6MinimumSize && reply[6] == byte(header.ICMPv6ProtocolNumber) &&
+					header.ICMPv6Type(reply[40]) == typ && netip.AddrFrom16([16]byte(reply[24:40])) == dst {
+					return reply
+				}
+			case <-timeout:
+				t.Fatalf("no reply of type %d", typ)
+			}
+		}
+	}
+
+	for i, client := range []netip.Addr{netip.MustParseAddr("fe80::2"), netip.MustParseAddr("fe80::3")} {
+		ra := exchange(ndpPacket(header.ICMPv6RouterSolicit, client, allRouters, netip.Addr{}), header.ICMPv6RouterAdvert, client)
+		if ra[7] != 255 || netip.AddrFrom16([16]byte(ra[8:24])) != router {
+			t.Errorf("advertisement header = %x", ra[:40])
+		}
+		icmp := ra[40:]
+		if sum := header.ICMPv6Checksum(header.ICMPv6ChecksumParams{
+			Header: icmp,
+			Src:    tcpip.AddrFrom16(router.As16()),
+			Dst:    tcpip.AddrFrom16(client.As16()),
+		}); sum != binary.BigEndian.Uint16(icmp[2:]) {
+			t.Errorf("checksum = %04x, want %04x", binary.BigEndian.Uint16(icmp[2:]), sum)
+		}
+		pio := icmp[header.ICMPv6HeaderSize+header.NDPRAMinimumSize:]
+		want := netip.MustParsePrefix("2001:db8:0:100::/64")
+		if i == 1 {
+			want = netip.MustParsePrefix("2001:db8:0:101::/64")
+		}
+		if got := netip.PrefixFrom(netip.AddrFrom16([16]byte(pio[16:32])), int(pio[2])); got != want || pio[3] != 0x40 {
+			t.Errorf("client %v advertised %v with flags %02x, want %v", client, got, pio[3], want)
+		}
+		if mtu := binary.BigEndian.Uint32(pio[32+4:]); mtu != 1420 {
+			t.Errorf("advertised MTU = %d", mtu)
+		}
+		if rdnss := pio[40:]; rdnss[0] != 25 || netip.AddrFrom16([16]byte(rdnss[8:24])) != netip.MustParseAddr("fd00::53") {
+			t.Errorf("recursive DNS server option = %x", rdnss)
+		}
+	}
+	// Soliciting again renews the delegation.
+	exchange(ndpPacket(header.ICMPv6RouterSolicit, netip.MustParseAddr("fe80::2"), allRouters, netip.Addr{}), header.ICMPv6RouterAdvert, netip.MustParseAddr("fe80::2"))
+	if len(delegated) != 2 {
+		t.Errorf("delegated = %v", delegated)
+	}
+	if delegations := tnet.Delegations(); len(delegations) != 2 || delegations[0].Client != netip.MustParseAddr("fe80::2") {
+		t.Errorf("delegations = %v", delegations)
+	}
+
+	na := exchange(ndpPacket(header.ICMPv6NeighborSolicit, netip.MustParseAddr("fe80::2"), router, router), header.ICMPv6NeighborAdvert, netip.MustParseAddr("fe80::2"))
+	if na[44]&0xe0 != 0xe0 || netip.AddrFrom16([16]byte(na[48:64])) != router {
+		t.Errorf("neighbor advertisement = %x", na[40:])
+	}
+}
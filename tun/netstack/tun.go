@@ -19,6 +19,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -48,8 +49,21 @@ type netTun struct {
 	mtu            int
 	dnsServers     []netip.Addr
 	hasV4, hasV6   bool
+	checksummed    atomic.Uint64
+	router         atomic.Pointer[ipv6Router] // nil unless enabled with EnableRouter
+
+	// Outbound fragments are held until the last one of their datagram,
+	// whose transport checksum needs all of them, and queued with the
+	// datagram as ready. Both are only accessed by Read.
+	fragments  []*buffer.View
+	fragmentID uint32
+	ready      []*buffer.View
 }
 
+// maxHeldFragments bounds the fragments held for a datagram, above the
+// number a 64 KiB datagram is split into at the minimum IPv6 MTU.
+const maxHeldFragments = 64
+
 type Net netTun
 
 func CreateNetTUN(localAddresses, dnsServers []netip.Addr, mtu int) (tun.Device, *Net, error) {
@@ -71,6 +85,12 @@ func CreateNetTUN(localAddresses, dnsServers []netip.Addr, mtu int) (tun.Device,
 	if tcpipErr != nil {
 		return nil, nil, fmt.Errorf("could not enable TCP SACK: %v", tcpipErr)
 	}
+	// There is no kernel beneath the stack to verify or compute transport
+	// checksums. Inbound packets have already been authenticated by the
+	// WireGuard session, so verifying them again is skipped; outbound
+	// checksums are filled in by Read, in one pass per packet, or once
+	// all fragments of a datagram too large for the MTU have been read.
+	dev.ep.LinkEPCapabilities |= stack.CapabilityRXChecksumOffload | stack.CapabilityTXChecksumOffload
 	dev.notifyHandle = dev.ep.AddNotify(dev)
 	tcpipErr = dev.stack.CreateNIC(1, dev.ep)
 	if tcpipErr != nil {
@@ -121,19 +141,88 @@ func (tun *netTun) Events() <-chan tun.Event {
 }
 
 func (tun *netTun) Read(buf [][]byte, sizes []int, offset int) (int, error) {
-	view, ok := <-tun.incomingPacket
-	if !ok {
-		return 0, os.ErrClosed
+	view, err := tun.nextPacket()
+	if err != nil {
+		return 0, err
 	}
 
 	n, err := view.Read(buf[0][offset:])
 	if err != nil {
 		return 0, err
 	}
+	tun.checksummed.Add(uint64(transportChecksum(buf[0][offset : offset+n])))
 	sizes[0] = n
 	return 1, nil
 }
 
+// nextPacket returns the next outbound packet, holding fragments back until
+// their datagram's checksum has been computed.
+func (tun *netTun) nextPacket() (*buffer.View, error) {
+	for len(tun.ready) == 0 {
+		view, ok := <-tun.incomingPacket
+		if !ok {
+			return nil, os.ErrClosed
+		}
+		frag, ok := parseFragment(view.AsSlice())
+		if !ok {
+			return view, nil
+		}
+		tun.holdFragment(view, frag)
+	}
+	view := tun.ready[0]
+	tun.ready[0] = nil
+	tun.ready = tun.ready[1:]
+	return view, nil
+}
+
+// holdFragment holds view, a fragment, until the last fragment of its
+// datagram has been read, then computes the datagram's checksum and makes
+// all of them ready. Fragments of a datagram that was not completed, as
+// when its other fragments were dropped, are made ready as they are once
+// the fragments of another arrive.
+func (tun *netTun) holdFragment(view *buffer.View, frag tun.Fragment) {
+	held := tun.fragments
+	if len(held) != 0 && (frag.Offset == 0 || frag.ID != tun.fragmentID || len(held) == maxHeldFragments) {
+		tun.ready = append(tun.ready, held...)
+		held = nil
+	}
+	if len(held) == 0 && frag.Offset != 0 {
+		tun.ready = append(tun.ready, view)
+		tun.fragments = nil
+		return
+	}
+	tun.fragmentID = frag.ID
+	held = append(held, view)
+	if !frag.More {
+		packets := make([][]byte, len(held))
+		for i, view := range held {
+			packets[i] = view.AsSlice()
+		}
+		tun.checksummed.Add(uint64(fragmentChecksum(packets)))
+		tun.ready = append(tun.ready, held...)
+		held = nil
+	}
+	tun.fragments = held
+}
+
+func transportChecksum(packet []byte) int {
+	return tun.TransportChecksum(packet)
+}
+
+func fragmentChecksum(fragments [][]byte) int {
+	return tun.FragmentChecksum(fragments)
+}
+
+func parseFragment(packet []byte) (tun.Fragment, bool) {
+	return tun.ParseFragment(packet)
+}
+
+// ChecksummedBytes returns the number of outbound transport bytes whose
+// checksums were computed in place of the stack.
+func (net *Net) ChecksummedBytes() uint64 {
+	return (*netTun)(net).checksummed.Load()
+}
+
 func (tun *netTun) Write(buf [][]byte, offset int) (int, error) {
 	for _, buf := range buf {
 		packet := buf[offset:]
@@ -146,6 +235,15 @@ func (tun *netTun) Write(buf [][]byte, offset int) (int, error) {
 		case 4:
 			tun.ep.InjectInbound(header.IPv4ProtocolNumber, pkb)
 		case 6:
+			if r := tun.router.Load(); r != nil {
+				if reply, consumed := r.handle(packet); consumed {
+					pkb.DecRef()
+					if reply != nil {
+						tun.writeOutbound(reply)
+					}
+					continue
+				}
+			}
 			tun.ep.InjectInbound(header.IPv6ProtocolNumber, pkb)
 		default:
 			return 0, syscall.EAFNOSUPPORT
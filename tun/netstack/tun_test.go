@@ -0,0 +1,78 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package netstack
+
+import (
+	"net/netip"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/tun"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func TestFragmentedChecksum(t *testing.T) {
+	for _, addrs := range [][2]netip.Addr{
+		{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("10.0.0.2")},
+		{netip.MustParseAddr("fd00::1"), netip.MustParseAddr("fd00::2")},
+	} {
+		local, remote := addrs[0], addrs[1]
+		tunDev, tnet, err := CreateNetTUN([]netip.Addr{local}, nil, 1280)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tunDev.Close()
+		conn, err := tnet.DialUDPAddrPort(netip.AddrPort{}, netip.AddrPortFrom(remote, 9))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		payload := make([]byte, 4000)
+		for i := range payload {
+			payload[i] = byte(i)
+		}
+		written := make(chan error, 1)
+		go func() {
+			_, err := conn.Write(payload)
+			written <- err
+		}()
+
+		var datagram []byte
+		bufs, sizes := [][]byte{make([]byte, 1500)}, make([]int, 1)
+		for {
+			if _, err := tunDev.Read(bufs, sizes, 0); err != nil {
+				t.Fatal(err)
+			}
+			packet := bufs[0][:sizes[0]]
+			frag, ok := tun.ParseFragment(packet)
+			if !ok {
+				t.Fatalf("%v packet is not a fragment", remote)
+			}
+			payloadAt := header.IPv4MinimumSize
+			if remote.Is6() {
+				payloadAt = header.IPv6MinimumSize + header.IPv6FragmentHeaderSize
+			}
+			datagram = append(datagram, packet[payloadAt:]...)
+			if !frag.More {
+				break
+			}
+		}
+		if err := <-written; err != nil {
+			t.Fatal(err)
+		}
+		if len(datagram) != header.UDPMinimumSize+len(payload) {
+			t.Fatalf("%v fragments hold %d bytes", remote, len(datagram))
+		}
+		sum := header.PseudoHeaderChecksum(header.UDPProtocolNumber, tcpip.AddrFromSlice(local.AsSlice()), tcpip.AddrFromSlice(remote.AsSlice()), uint16(len(datagram)))
+		if header.UDP(datagram).Checksum() == 0 || checksum.Checksum(datagram, sum) != 0xffff {
+			t.Errorf("%v datagram checksum does not verify", remote)
+		}
+		if n := tnet.ChecksummedBytes(); n != uint64(len(datagram)) {
+			t.Errorf("%v checksummed %d bytes, want %d", remote, n, len(datagram))
+		}
+	}
+}
@@ -0,0 +1,48 @@
+//go:build linux || darwin || freebsd || openbsd
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package sockettun
+
+import (
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// socketBufferSize is requested for both ends of a socketpair, as some
+// systems otherwise limit datagrams to a few kilobytes.
+const socketBufferSize = 1 << 20
+
+// SocketPair creates a SOCK_DGRAM socketpair and returns a Device with
+// datagram framing on one end, and the other end for passing on to another
+// process, for example through exec.Cmd.ExtraFiles.
+func SocketPair(mtu int) (*Device, *os.File, error) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		return nil, nil, os.NewSyscallError("socketpair", err)
+	}
+	for _, fd := range fds {
+		unix.CloseOnExec(fd)
+		unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_SNDBUF, socketBufferSize)
+		unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, socketBufferSize)
+	}
+	local := os.NewFile(uintptr(fds[0]), "sockettun")
+	remote := os.NewFile(uintptr(fds[1]), "sockettun-remote")
+	c, err := net.FileConn(local)
+	local.Close()
+	if err != nil {
+		remote.Close()
+		return nil, nil, err
+	}
+	dev, err := New(c, FramingDatagram, mtu)
+	if err != nil {
+		remote.Close()
+		return nil, nil, err
+	}
+	return dev, remote, nil
+}
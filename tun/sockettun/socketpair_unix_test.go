@@ -0,0 +1,62 @@
+//go:build linux || darwin || freebsd || openbsd
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package sockettun
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestSocketPair(t *testing.T) {
+	dev, remote, err := SocketPair(1420)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	c, err := net.FileConn(remote)
+	remote.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	buf := make([]byte, maxFrameSize)
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], []byte{0, controlMTU, 0x05, 0x8c}) {
+		t.Errorf("unexpected MTU announcement %x", buf[:n])
+	}
+
+	packets := testPackets(2)
+	for _, packet := range packets {
+		if _, err := c.Write(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, packet := range readPackets(t, dev, len(packets)) {
+		if !bytes.Equal(packet, packets[i]) {
+			t.Errorf("packet %d = %x, want %x", i, packet, packets[i])
+		}
+	}
+
+	if _, err := dev.Write(withOffset(packets), offset); err != nil {
+		t.Fatal(err)
+	}
+	for i, packet := range packets {
+		n, err := c.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf[:n], packet) {
+			t.Errorf("datagram %d = %x, want %x", i, buf[:n], packet)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package sockettun implements a tun.Device carrying raw IP packets over a
+// connection to another process, such as one end of a socketpair or a pipe,
+// so that simulators and user-mode network stacks can be plugged into a
+// device without root privileges, a kernel TUN interface or gVisor.
+//
+// Every frame on the connection is either an IPv4 or IPv6 packet, as told by
+// the version in its first four bits, or a control frame starting with a
+// zero byte followed by its type:
+//
+//	0x00 0x01 <MTU, uint16 big endian>    announces the sender's MTU
+//
+// Both ends announce their MTU when the connection starts and whenever it
+// changes, and the device reports the smaller of the two. Control frames of
+// unknown types and frames that are neither are ignored.
+//
+// With datagram framing, as over a SOCK_DGRAM or SOCK_SEQPACKET socket,
+// every message is one frame. With stream framing, as over a pipe or a
+// SOCK_STREAM socket, every frame is preceded by its length as a big-endian
+// uint16.
+package sockettun
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// Framing selects how frames are delimited on a connection.
+type Framing int
+
+const (
+	FramingDatagram Framing = iota // every message is one frame
+	FramingStream                  // every frame is preceded by its length
+)
+
+const (
+	controlMTU = 1 // control frame announcing the sender's MTU
+
+	maxFrameSize = 0xffff // largest frame, bounded by the length prefix of stream framing
+	batchSize    = 128    // packets read or written per call, like conn.IdealBatchSize
+)
+
+// A Device is a tun.Device exchanging packets with the other end of a
+// connection.
+type Device struct {
+	conn     net.Conn
+	framing  Framing
+	incoming chan frame // received packets, closed when the connection fails
+	events   chan tun.Event
+	closed   chan struct{}
+	pool     sync.Pool
+
+	writeMutex sync.Mutex // serializes frames on the connection
+
+	mu        sync.Mutex
+	mtu       int // local MTU
+	remoteMTU int // MTU announced by the other end; zero until announced
+	readErr   error
+	isClosed  bool
+}
+
+type frame struct {
+	buf *[maxFrameSize]byte
+	n   int
+}
+
+// New returns a Device exchanging packets over c with the given framing,
+// after announcing mtu to the other end. Over an unbuffered connection such
+// as net.Pipe, New thus waits until the other end reads.
+func New(c net.Conn, framing Framing, mtu int) (*Device, error) {
+	if framing != FramingDatagram && framing != FramingStream {
+		return nil, fmt.Errorf("invalid framing %d", framing)
+	}
+	if mtu <= 0 || mtu > maxFrameSize {
+		return nil, fmt.Errorf("invalid MTU %d", mtu)
+	}
+	dev := &Device{
+		conn:     c,
+		framing:  framing,
+		incoming: make(chan frame, batchSize),
+		events:   make(chan tun.Event, 10),
+		closed:   make(chan struct{}),
+		mtu:      mtu,
+	}
+	dev.pool.New = func() any {
+		return new([maxFrameSize]byte)
+	}
+	go dev.routineReceive()
+	if err := dev.announceMTU(mtu); err != nil {
+		dev.Close()
+		return nil, err
+	}
+	dev.events <- tun.EventUp
+	return dev, nil
+}
+
+func (dev *Device) File() *os.File { return nil }
+
+func (dev *Device) Name() (string, error) { return "sockettun", nil }
+
+func (dev *Device) Events() <-chan tun.Event { return dev.events }
+
+func (dev *Device) BatchSize() int { return batchSize }
+
+// MTU returns the smaller of the local MTU and the one announced by the
+// other end.
+func (dev *Device) MTU() (int, error) {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	return dev.effectiveMTULocked(), nil
+}
+
+func (dev *Device) effectiveMTULocked() int {
+	if dev.remoteMTU != 0 && dev.remoteMTU < dev.mtu {
+		return dev.remoteMTU
+	}
+	return dev.mtu
+}
+
+// SetMTU changes the local MTU and announces it to the other end.
+func (dev *Device) SetMTU(mtu int) error {
+	if mtu <= 0 || mtu > maxFrameSize {
+		return fmt.Errorf("invalid MTU %d", mtu)
+	}
+	dev.mu.Lock()
+	old := dev.effectiveMTULocked()
+	dev.mtu = mtu
+	if dev.effectiveMTULocked() != old {
+		dev.sendEventLocked(tun.EventMTUUpdate)
+	}
+	dev.mu.Unlock()
+	return dev.announceMTU(mtu)
+}
+
+func (dev *Device) announceMTU(mtu int) error {
+	frame := make([]byte, 2, 6)
+	frame = append(frame, 0, controlMTU)
+	frame = binary.BigEndian.AppendUint16(frame, uint16(mtu))
+	_, err := dev.writeFrames([][]byte{frame}, 2)
+	return err
+}
+
+func (dev *Device) sendEventLocked(event tun.Event) {
+	if dev.isClosed {
+		return
+	}
+	select {
+	case dev.events <- event:
+	default:
+	}
+}
+
+// Read reads one or more packets, waiting only for the first.
+func (dev *Device) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	n := 0
+	for n < len(bufs) {
+		var packet frame
+		var ok bool
+		if n == 0 {
+			select {
+			case <-dev.closed:
+				return 0, os.ErrClosed
+			case packet, ok = <-dev.incoming:
+			}
+		} else {
+			select {
+			case packet, ok = <-dev.incoming:
+			default:
+				return n, nil
+			}
+		}
+		if !ok {
+			if n > 0 {
+				return n, nil
+			}
+			dev.mu.Lock()
+			defer dev.mu.Unlock()
+			return 0, dev.readErr
+		}
+		size := copy(bufs[n][offset:], packet.buf[:packet.n])
+		dev.pool.Put(packet.buf)
+		if size < packet.n {
+			// Too large for the buffer; a kernel TUN would fail the read.
+			continue
+		}
+		sizes[n] = size
+		n++
+	}
+	return n, nil
+}
+
+// Write writes packets to the other end. With stream framing and an offset
+// of at least two, the length prefix is written into bufs in front of each
+// packet.
+func (dev *Device) Write(bufs [][]byte, offset int) (int, error) {
+	select {
+	case <-dev.closed:
+		return 0, os.ErrClosed
+	default:
+	}
+	if dev.framing == FramingStream && offset < 2 {
+		frames := make([][]byte, len(bufs))
+		for i, buf := range bufs {
+			frames[i] = append(make([]byte, 2, 2+len(buf)-offset), buf[offset:]...)
+		}
+		bufs, offset = frames, 2
+	}
+	return dev.writeFrames(bufs, offset)
+}
+
+// writeFrames writes the frames in bufs[i][offset:], using bufs[i][offset-2:offset]
+// for the length prefix with stream framing.
+func (dev *Device) writeFrames(bufs [][]byte, offset int) (int, error) {
+	dev.writeMutex.Lock()
+	defer dev.writeMutex.Unlock()
+	if dev.framing == FramingDatagram {
+		for i, buf := range bufs {
+			if _, err := dev.conn.Write(buf[offset:]); err != nil {
+				return i, err
+			}
+		}
+		return len(bufs), nil
+	}
+	frames := make(net.Buffers, len(bufs))
+	for i, buf := range bufs {
+		if len(buf)-offset > maxFrameSize {
+			return 0, fmt.Errorf("packet of %d bytes exceeds maximum frame size", len(buf)-offset)
+		}
+		binary.BigEndian.PutUint16(buf[offset-2:], uint16(len(buf)-offset))
+		frames[i] = buf[offset-2:]
+	}
+	if _, err := frames.WriteTo(dev.conn); err != nil {
+		// Frames may have been written partially, so the stream is
+		// no longer usable.
+		dev.conn.Close()
+		return 0, err
+	}
+	return len(bufs), nil
+}
+
+func (dev *Device) routineReceive() {
+	var r *bufio.Reader
+	if dev.framing == FramingStream {
+		r = bufio.NewReaderSize(dev.conn, 2*maxFrameSize)
+	}
+	var err error
+	for {
+		buf := dev.pool.Get().(*[maxFrameSize]byte)
+		var n int
+		if r == nil {
+			n, err = dev.conn.Read(buf[:])
+		} else {
+			var prefix [2]byte
+			if _, err = io.ReadFull(r, prefix[:]); err == nil {
+				n = int(binary.BigEndian.Uint16(prefix[:]))
+				_, err = io.ReadFull(r, buf[:n])
+				if errors.Is(err, io.EOF) {
+					err = io.ErrUnexpectedEOF
+				}
+			}
+		}
+		if err != nil {
+			dev.pool.Put(buf)
+			break
+		}
+		if version := buf[0] >> 4; n == 0 || (version != 4 && version != 6) {
+			if n >= 4 && buf[0] == 0 && buf[1] == controlMTU {
+				dev.handleRemoteMTU(int(binary.BigEndian.Uint16(buf[2:4])))
+			}
+			dev.pool.Put(buf)
+			continue
+		}
+		select {
+		case dev.incoming <- frame{buf, n}:
+		case <-dev.closed:
+			dev.pool.Put(buf)
+		}
+	}
+
+	select {
+	case <-dev.closed:
+		err = os.ErrClosed
+	default:
+	}
+	dev.mu.Lock()
+	dev.readErr = err
+	dev.mu.Unlock()
+	close(dev.incoming)
+}
+
+func (dev *Device) handleRemoteMTU(mtu int) {
+	if mtu == 0 {
+		return
+	}
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	old := dev.effectiveMTULocked()
+	dev.remoteMTU = mtu
+	if dev.effectiveMTULocked() != old {
+		dev.sendEventLocked(tun.EventMTUUpdate)
+	}
+}
+
+// Close closes the connection and the events channel.
+func (dev *Device) Close() error {
+	dev.mu.Lock()
+	defer dev.mu.Unlock()
+	if dev.isClosed {
+		return nil
+	}
+	dev.isClosed = true
+	close(dev.closed)
+	close(dev.events)
+	return dev.conn.Close()
+}
@@ -0,0 +1,153 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package sockettun
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/tun"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+const offset = 16
+
+func readStreamFrame(r io.Reader) ([]byte, error) {
+	var prefix [2]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	frame := make([]byte, binary.BigEndian.Uint16(prefix[:]))
+	_, err := io.ReadFull(r, frame)
+	return frame, err
+}
+
+func streamFrames(frames ...[]byte) []byte {
+	var b []byte
+	for _, frame := range frames {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(frame)))
+		b = append(b, frame...)
+	}
+	return b
+}
+
+func testPackets(n int) [][]byte {
+	packets := make([][]byte, n)
+	for i := range packets {
+		packets[i] = tuntest.Ping(netip.AddrFrom4([4]byte{10, 0, 0, 1}), netip.AddrFrom4([4]byte{10, 0, 0, byte(2 + i)}))
+	}
+	return packets
+}
+
+// readPackets reads n packets from dev, in as many calls as it takes.
+func readPackets(t *testing.T, dev tun.Device, n int) [][]byte {
+	t.Helper()
+	bufs := make([][]byte, dev.BatchSize())
+	for i := range bufs {
+		bufs[i] = make([]byte, offset+maxFrameSize)
+	}
+	sizes := make([]int, len(bufs))
+	var packets [][]byte
+	for len(packets) < n {
+		count, err := dev.Read(bufs, sizes, offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < count; i++ {
+			packets = append(packets, append([]byte(nil), bufs[i][offset:offset+sizes[i]]...))
+		}
+	}
+	return packets
+}
+
+func withOffset(packets [][]byte) [][]byte {
+	bufs := make([][]byte, len(packets))
+	for i, packet := range packets {
+		bufs[i] = append(make([]byte, offset), packet...)
+	}
+	return bufs
+}
+
+func TestStream(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	announced := make(chan []byte, 1)
+	go func() {
+		frame, _ := readStreamFrame(client)
+		announced <- frame
+	}()
+	dev, err := New(server, FramingStream, 1420)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	if frame := <-announced; !bytes.Equal(frame, []byte{0, controlMTU, 0x05, 0x8c}) {
+		t.Errorf("unexpected MTU announcement %x", frame)
+	}
+	if event := <-dev.Events(); event != tun.EventUp {
+		t.Errorf("first event = %v", event)
+	}
+
+	// A smaller MTU announced by the other end takes effect.
+	if _, err := client.Write(streamFrames([]byte{0, controlMTU, 0x05, 0x00})); err != nil {
+		t.Fatal(err)
+	}
+	if event := <-dev.Events(); event != tun.EventMTUUpdate {
+		t.Errorf("event = %v, want MTU update", event)
+	}
+	if mtu, _ := dev.MTU(); mtu != 1280 {
+		t.Errorf("MTU = %d, want 1280", mtu)
+	}
+
+	// Frames that are not IP packets are skipped.
+	packets := testPackets(3)
+	go client.Write(streamFrames(packets[0], []byte{0x10, 1, 2}, packets[1], []byte{0, 0x7f}, packets[2]))
+	for i, packet := range readPackets(t, dev, len(packets)) {
+		if !bytes.Equal(packet, packets[i]) {
+			t.Errorf("packet %d = %x, want %x", i, packet, packets[i])
+		}
+	}
+
+	go func() {
+		if _, err := dev.Write(withOffset(packets), offset); err != nil {
+			t.Error(err)
+		}
+	}()
+	for i, packet := range packets {
+		if frame, err := readStreamFrame(client); err != nil || !bytes.Equal(frame, packet) {
+			t.Errorf("frame %d = %x, %v, want %x", i, frame, err, packet)
+		}
+	}
+	go dev.Write(packets[:1], 0)
+	if frame, err := readStreamFrame(client); err != nil || !bytes.Equal(frame, packets[0]) {
+		t.Errorf("frame written without offset = %x, %v", frame, err)
+	}
+
+	dev.Close()
+	if _, err := dev.Read(make([][]byte, 1), make([]int, 1), 0); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("Read after Close = %v", err)
+	}
+}
+
+func TestRemoteClose(t *testing.T) {
+	client, server := net.Pipe()
+	go readStreamFrame(client)
+	dev, err := New(server, FramingStream, 1420)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	client.Close()
+	if _, err := dev.Read(make([][]byte, 1), make([]int, 1), 0); !errors.Is(err, io.EOF) {
+		t.Errorf("Read after remote close = %v", err)
+	}
+}
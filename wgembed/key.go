@@ -0,0 +1,77 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package wgembed
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// KeyLen is the length in bytes of a Key.
+const KeyLen = 32
+
+// A Key is a Curve25519 private or public key, or a preshared key.
+type Key [KeyLen]byte
+
+// GeneratePrivateKey returns a new random, clamped private key.
+func GeneratePrivateKey() (Key, error) {
+	var k Key
+	if _, err := rand.Read(k[:]); err != nil {
+		return Key{}, err
+	}
+	k[0] &= 248
+	k[31] = (k[31] & 127) | 64
+	return k, nil
+}
+
+// ParseKey parses a key in the base64 form used by wg(8).
+func ParseKey(s string) (Key, error) {
+	var k Key
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return Key{}, err
+	}
+	if len(b) != KeyLen {
+		return Key{}, errors.New("wgembed: key must be 32 bytes")
+	}
+	copy(k[:], b)
+	return k, nil
+}
+
+// PublicKey returns the public key corresponding to the private key k.
+func (k Key) PublicKey() Key {
+	var pk Key
+	curve25519.ScalarBaseMult((*[KeyLen]byte)(&pk), (*[KeyLen]byte)(&k))
+	return pk
+}
+
+// IsZero reports whether k is the all-zero key.
+func (k Key) IsZero() bool {
+	return k == Key{}
+}
+
+// String returns the base64 form of k.
+func (k Key) String() string {
+	return base64.StdEncoding.EncodeToString(k[:])
+}
+
+// MarshalText returns the base64 form of k.
+func (k Key) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText parses a key in the base64 form used by wg(8).
+func (k *Key) UnmarshalText(text []byte) error {
+	parsed, err := ParseKey(string(text))
+	if err != nil {
+		return err
+	}
+	*k = parsed
+	return nil
+}
@@ -0,0 +1,449 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package wgembed
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Peer lists synced from a feed
+ *
+ * Small meshes can be coordinated by publishing the list of their peers at a
+ * URL or in a shared file, as wg-dynamic and similar tools do, rather than by
+ * running a control plane. A PeerSync fetches such a list periodically,
+ * verifies that it was signed with the Ed25519 key of its publisher, and
+ * brings the peers of its device in line with it. A feed is the JSON of a
+ * PeerFeed, holding the JSON of a PeerList and the signature of exactly
+ * those bytes:
+ *
+ *	{"payload": "<base64 of the PeerList JSON>", "signature": "<base64>"}
+ *
+ * Publishers number their lists with increasing serials, and a list with a
+ * lower serial than the last one applied is rejected, so that replaying an
+ * old feed does not bring back removed peers. Feeds are signed, not
+ * encrypted, so they carry no preshared keys.
+ *
+ * Each sync diffs the list against the current state of the device: peers
+ * of the list missing from the device are added, those whose allowed IPs,
+ * persistent keepalive or name differ are updated, and peers of the last
+ * applied list missing from the new one are removed. Peers configured by
+ * other means are left alone until a list names them. Endpoints are only set
+ * for new peers and when the list changes them, so that peers keep the
+ * endpoints they roamed to. The changes are applied in one Configure call,
+ * with removals last, and undone if it fails, so that the device is left
+ * with either the old peers or the new ones.
+ *
+ * Syncs happen every interval, randomized by up to a tenth either way so
+ * that the devices of a mesh do not all fetch at once. After failures the
+ * wait doubles with each, up to a maximum, until a sync succeeds.
+ */
+
+const (
+	DefaultPeerSyncInterval   = time.Minute      // between syncs, unless set otherwise
+	DefaultPeerSyncMaxBackoff = 30 * time.Minute // longest wait after failed syncs, unless set otherwise
+	MaxPeerFeedSize           = 1 << 20          // bytes of the largest feed read
+
+	peerSyncJitter      = 10 // percent by which waits between syncs vary
+	peerSyncHTTPTimeout = 30 * time.Second
+)
+
+// A PeerFeed is a signed PeerList, as published for a PeerSync.
+type PeerFeed struct {
+	Payload   []byte `json:"payload"`   // the JSON of a PeerList
+	Signature []byte `json:"signature"` // the Ed25519 signature of Payload
+}
+
+// A PeerList is the list of peers a PeerSync configures its device with.
+type PeerList struct {
+	Serial uint64          `json:"serial"` // greater than that of every earlier list
+	Peers  []PeerListEntry `json:"peers"`
+}
+
+// A PeerListEntry describes a peer of a PeerList.
+type PeerListEntry struct {
+	PublicKey           Key            `json:"public_key"`
+	Endpoint            netip.AddrPort `json:"endpoint"`
+	AllowedIPs          []netip.Prefix `json:"allowed_ips,omitempty"`
+	PersistentKeepalive int            `json:"persistent_keepalive,omitempty"` // in seconds; zero for none
+	Name                string         `json:"name,omitempty"`
+}
+
+// SignPeerList returns the feed of list signed with key, for publishers.
+func SignPeerList(list *PeerList, key ed25519.PrivateKey) ([]byte, error) {
+	payload, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(PeerFeed{Payload: payload, Signature: ed25519.Sign(key, payload)})
+}
+
+// OpenPeerFeed verifies that feed was signed with key and returns its list.
+func OpenPeerFeed(feed []byte, key ed25519.PublicKey) (*PeerList, error) {
+	var f PeerFeed
+	if err := json.Unmarshal(feed, &f); err != nil {
+		return nil, fmt.Errorf("wgembed: invalid peer feed: %w", err)
+	}
+	if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, f.Payload, f.Signature) {
+		return nil, errors.New("wgembed: peer feed signature invalid")
+	}
+	list := new(PeerList)
+	if err := json.Unmarshal(f.Payload, list); err != nil {
+		return nil, fmt.Errorf("wgembed: invalid peer list: %w", err)
+	}
+	if err := list.validate(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (list *PeerList) validate() error {
+	seen := make(map[Key]bool, len(list.Peers))
+	for _, p := range list.Peers {
+		if p.PublicKey.IsZero() {
+			return errors.New("wgembed: peer list has a peer without a public key")
+		}
+		if seen[p.PublicKey] {
+			return fmt.Errorf("wgembed: peer list has peer %s twice", p.PublicKey)
+		}
+		seen[p.PublicKey] = true
+		if p.PersistentKeepalive < 0 || p.PersistentKeepalive > 65535 {
+			return fmt.Errorf("wgembed: peer %s has invalid persistent keepalive %d", p.PublicKey, p.PersistentKeepalive)
+		}
+		for _, prefix := range p.AllowedIPs {
+			if !prefix.IsValid() {
+				return fmt.Errorf("wgembed: peer %s has an invalid allowed IP", p.PublicKey)
+			}
+		}
+	}
+	return nil
+}
+
+// PeerSyncOptions describes where a PeerSync fetches its feed from and how
+// often.
+type PeerSyncOptions struct {
+	Source     string            // http or https URL, or path of a file
+	PublicKey  ed25519.PublicKey // of the publisher, which signs the feed
+	Interval   time.Duration     // between syncs; DefaultPeerSyncInterval if zero
+	MaxBackoff time.Duration     // longest wait after failed syncs; DefaultPeerSyncMaxBackoff if zero
+	Client     *http.Client      // fetches URLs; if nil, one with a 30 second timeout
+	Logger     *Logger           // receives sync results; if nil, logging is discarded
+}
+
+// PeerSyncStatus is a snapshot of the state of a PeerSync.
+type PeerSyncStatus struct {
+	LastAttempt time.Time
+	LastSuccess time.Time
+	Serial      uint64 // of the last list applied
+	Peers       int    // of the last list applied
+	Failures    int    // since the last success
+	Err         error  // of the last sync, if it failed
+}
+
+// A PeerSync keeps the peers of a Device in line with a signed peer feed.
+type PeerSync struct {
+	dev        *Device
+	source     string
+	publicKey  ed25519.PublicKey
+	interval   time.Duration
+	maxBackoff time.Duration
+	client     *http.Client
+	logger     Logger
+
+	syncMu  sync.Mutex
+	applied map[Key]PeerListEntry // the last list applied, by key
+
+	mu      sync.Mutex
+	status  PeerSyncStatus
+	running bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// NewPeerSync returns a PeerSync of dev with opts. Nothing is fetched until
+// Start or Sync is called.
+func NewPeerSync(dev *Device, opts PeerSyncOptions) (*PeerSync, error) {
+	if dev == nil || opts.Source == "" {
+		return nil, errors.New("wgembed: peer sync without a device or source")
+	}
+	if len(opts.PublicKey) != ed25519.PublicKeySize {
+		return nil, errors.New("wgembed: peer sync public key must be 32 bytes")
+	}
+	s := &PeerSync{
+		dev:        dev,
+		source:     opts.Source,
+		publicKey:  opts.PublicKey,
+		interval:   opts.Interval,
+		maxBackoff: opts.MaxBackoff,
+		client:     opts.Client,
+		logger:     Logger{Verbosef: discardf, Errorf: discardf},
+	}
+	if s.interval <= 0 {
+		s.interval = DefaultPeerSyncInterval
+	}
+	if s.maxBackoff <= 0 {
+		s.maxBackoff = DefaultPeerSyncMaxBackoff
+	}
+	if s.client == nil {
+		s.client = &http.Client{Timeout: peerSyncHTTPTimeout}
+	}
+	if opts.Logger != nil {
+		if opts.Logger.Verbosef != nil {
+			s.logger.Verbosef = opts.Logger.Verbosef
+		}
+		if opts.Logger.Errorf != nil {
+			s.logger.Errorf = opts.Logger.Errorf
+		}
+	}
+	return s, nil
+}
+
+// Start starts syncing in the background, beginning at once.
+func (s *PeerSync) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return errors.New("wgembed: peer sync already started")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.running, s.cancel, s.done = true, cancel, make(chan struct{})
+	go s.run(ctx, s.done)
+	return nil
+}
+
+// Stop stops syncing, waiting for a sync in progress to give up. The peers
+// configured so far stay configured.
+func (s *PeerSync) Stop() {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.running = false
+	s.cancel()
+	done := s.done
+	s.mu.Unlock()
+	<-done
+}
+
+// Status returns the state of the sync.
+func (s *PeerSync) Status() PeerSyncStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+func (s *PeerSync) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	for {
+		s.Sync(ctx)
+		timer := time.NewTimer(s.nextWait())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// nextWait returns how long to wait for the next sync, randomized, and
+// doubled for each failure since the last success.
+func (s *PeerSync) nextWait() time.Duration {
+	s.mu.Lock()
+	failures := s.status.Failures
+	s.mu.Unlock()
+	wait := s.interval
+	for range failures {
+		if wait >= s.maxBackoff/2 {
+			wait = s.maxBackoff
+			break
+		}
+		wait *= 2
+	}
+	spread := wait * peerSyncJitter / 100
+	return wait - spread + rand.N(2*spread+1)
+}
+
+// Sync fetches the feed and applies its list now, rather than at the next
+// interval.
+func (s *PeerSync) Sync(ctx context.Context) error {
+	s.syncMu.Lock()
+	defer s.syncMu.Unlock()
+	list, err := s.fetch(ctx)
+	if err == nil {
+		err = s.apply(list)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.status.LastAttempt = time.Now()
+	s.status.Err = err
+	if err != nil {
+		s.status.Failures++
+		s.logger.Errorf("Peer sync: %v", err)
+		return err
+	}
+	s.status.LastSuccess, s.status.Failures = s.status.LastAttempt, 0
+	s.status.Serial, s.status.Peers = list.Serial, len(list.Peers)
+	return nil
+}
+
+func (s *PeerSync) fetch(ctx context.Context) (*PeerList, error) {
+	var feed []byte
+	if strings.HasPrefix(s.source, "http://") || strings.HasPrefix(s.source, "https://") {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.source, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("wgembed: fetching peer feed: %s", resp.Status)
+		}
+		feed, err = io.ReadAll(io.LimitReader(resp.Body, MaxPeerFeedSize+1))
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		f, err := os.Open(s.source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		feed, err = io.ReadAll(io.LimitReader(f, MaxPeerFeedSize+1))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(feed) > MaxPeerFeedSize {
+		return nil, errors.New("wgembed: peer feed too large")
+	}
+
+	list, err := OpenPeerFeed(feed, s.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	if last := s.Status().Serial; s.applied != nil && list.Serial < last {
+		return nil, fmt.Errorf("wgembed: peer list serial %d is older than %d", list.Serial, last)
+	}
+	return list, nil
+}
+
+// apply brings the peers of the device in line with list, undoing the
+// changes if they fail.
+func (s *PeerSync) apply(list *PeerList) error {
+	status := s.dev.Status()
+	current := make(map[Key]*PeerStatus, len(status.Peers))
+	for i := range status.Peers {
+		current[status.Peers[i].PublicKey] = &status.Peers[i]
+	}
+
+	var cfg, undo Config
+	for _, p := range list.Peers {
+		ps := current[p.PublicKey]
+		prev, known := s.applied[p.PublicKey]
+		pc := PeerConfig{PublicKey: p.PublicKey}
+		changed := ps == nil
+		if p.Endpoint.IsValid() && (ps == nil || !known || prev.Endpoint != p.Endpoint) {
+			if ps == nil || ps.Endpoint != p.Endpoint.String() {
+				pc.Endpoint, changed = &p.Endpoint, true
+			}
+		}
+		if keepalive := time.Duration(p.PersistentKeepalive) * time.Second; ps == nil || ps.PersistentKeepaliveInterval != keepalive {
+			pc.PersistentKeepaliveInterval, changed = &keepalive, true
+		}
+		if ps == nil || ps.Name != p.Name {
+			pc.Name, changed = &p.Name, true
+		}
+		if ps == nil || !samePrefixes(ps.AllowedIPs, p.AllowedIPs) {
+			pc.ReplaceAllowedIPs, pc.AllowedIPs, changed = true, p.AllowedIPs, true
+		}
+		if !changed {
+			continue
+		}
+		cfg.Peers = append(cfg.Peers, pc)
+		if ps == nil {
+			undo.Peers = append(undo.Peers, PeerConfig{PublicKey: p.PublicKey, Remove: true})
+		} else {
+			undo.Peers = append(undo.Peers, restorePeerConfig(ps))
+		}
+	}
+	listed := make(map[Key]PeerListEntry, len(list.Peers))
+	for _, p := range list.Peers {
+		listed[p.PublicKey] = p
+	}
+	for key := range s.applied {
+		if _, ok := listed[key]; !ok && current[key] != nil {
+			cfg.Peers = append(cfg.Peers, PeerConfig{PublicKey: key, Remove: true})
+		}
+	}
+
+	if len(cfg.Peers) != 0 {
+		if err := s.dev.Configure(cfg); err != nil {
+			if undoErr := s.dev.Configure(undo); undoErr != nil {
+				return fmt.Errorf("wgembed: applying peer list %d: %w (undoing it: %v)", list.Serial, err, undoErr)
+			}
+			return fmt.Errorf("wgembed: applying peer list %d: %w", list.Serial, err)
+		}
+		s.logger.Verbosef("Peer sync: applied list %d, %d peers changed", list.Serial, len(cfg.Peers))
+	}
+	s.applied = listed
+	return nil
+}
+
+// restorePeerConfig returns the configuration that restores what a peer list
+// may change of a peer to ps.
+func restorePeerConfig(ps *PeerStatus) PeerConfig {
+	pc := PeerConfig{
+		PublicKey:                   ps.PublicKey,
+		UpdateOnly:                  true,
+		PersistentKeepaliveInterval: &ps.PersistentKeepaliveInterval,
+		Name:                        &ps.Name,
+		ReplaceAllowedIPs:           true,
+		AllowedIPs:                  ps.AllowedIPs,
+	}
+	if endpoint, err := netip.ParseAddrPort(ps.Endpoint); err == nil {
+		pc.Endpoint = &endpoint
+	}
+	return pc
+}
+
+// samePrefixes reports whether a and b hold the same prefixes, in any order.
+func samePrefixes(a, b []netip.Prefix) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	masked := func(prefixes []netip.Prefix) []netip.Prefix {
+		m := make([]netip.Prefix, len(prefixes))
+		for i, prefix := range prefixes {
+			m[i] = prefix.Masked()
+		}
+		slices.SortFunc(m, func(x, y netip.Prefix) int {
+			if c := x.Addr().Compare(y.Addr()); c != 0 {
+				return c
+			}
+			return x.Bits() - y.Bits()
+		})
+		return m
+	}
+	return slices.Equal(masked(a), masked(b))
+}
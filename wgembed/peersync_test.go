@@ -0,0 +1,192 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package wgembed
+
+import (
+	"context"
+	"crypto/ed25519"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func TestPeerSync(t *testing.T) {
+	dev, err := New(Options{TUN: tuntest.NewChannelTUN().TUN(), Bind: bindtest.NewChannelBinds()[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	source := filepath.Join(t.TempDir(), "peers.json")
+	publish := func(list *PeerList, key ed25519.PrivateKey) {
+		t.Helper()
+		feed, err := SignPeerList(list, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(source, feed, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := NewPeerSync(dev, PeerSyncOptions{Source: source, PublicKey: publicKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peers := func() map[Key]PeerStatus {
+		m := make(map[Key]PeerStatus)
+		for _, p := range dev.Status().Peers {
+			m[p.PublicKey] = p
+		}
+		return m
+	}
+
+	var a, b, c, d Key
+	for _, k := range []*Key{&a, &b, &c, &d} {
+		sk, _ := GeneratePrivateKey()
+		*k = sk.PublicKey()
+	}
+	endpoint := netip.MustParseAddrPort("127.0.0.1:1")
+	roamed := netip.MustParseAddrPort("127.0.0.1:2")
+	publish(&PeerList{Serial: 1, Peers: []PeerListEntry{
+		{PublicKey: a, Endpoint: endpoint, AllowedIPs: []netip.Prefix{netip.MustParsePrefix("10.0.0.1/32")}, PersistentKeepalive: 25, Name: "a"},
+		{PublicKey: b, AllowedIPs: []netip.Prefix{netip.MustParsePrefix("10.0.0.2/32")}},
+	}}, privateKey)
+	if err := s.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got := peers()
+	if p := got[a]; len(got) != 2 || p.Endpoint != endpoint.String() || p.Name != "a" || p.PersistentKeepaliveInterval != 25*time.Second || len(p.AllowedIPs) != 1 {
+		t.Fatalf("after list 1: %+v", got)
+	}
+
+	// A peer configured by other means is left alone, and one that roamed
+	// keeps its endpoint while the list does not change it.
+	if err := dev.Configure(Config{Peers: []PeerConfig{{PublicKey: c}, {PublicKey: a, UpdateOnly: true, Endpoint: &roamed}}}); err != nil {
+		t.Fatal(err)
+	}
+	publish(&PeerList{Serial: 2, Peers: []PeerListEntry{
+		{PublicKey: a, Endpoint: endpoint, AllowedIPs: []netip.Prefix{netip.MustParsePrefix("10.0.1.0/24"), netip.MustParsePrefix("10.0.0.1/32")}, Name: "a"},
+	}}, privateKey)
+	if err := s.Sync(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	got = peers()
+	if _, ok := got[b]; ok || len(got) != 2 {
+		t.Fatalf("after list 2: %+v", got)
+	}
+	if p := got[a]; p.Endpoint != roamed.String() || p.PersistentKeepaliveInterval != 0 || len(p.AllowedIPs) != 2 {
+		t.Errorf("after list 2, a is %+v", p)
+	}
+	if status := s.Status(); status.Serial != 2 || status.Peers != 1 || status.Failures != 0 {
+		t.Errorf("status %+v", status)
+	}
+
+	// Replayed, forged and partly invalid lists change nothing.
+	publish(&PeerList{Serial: 1, Peers: []PeerListEntry{{PublicKey: b}}}, privateKey)
+	if err := s.Sync(context.Background()); err == nil {
+		t.Error("older list applied")
+	}
+	_, otherKey, _ := ed25519.GenerateKey(nil)
+	publish(&PeerList{Serial: 3, Peers: []PeerListEntry{{PublicKey: b}}}, otherKey)
+	if err := s.Sync(context.Background()); err == nil {
+		t.Error("list of another key applied")
+	}
+	publish(&PeerList{Serial: 3, Peers: []PeerListEntry{{PublicKey: b}, {PublicKey: b}}}, privateKey)
+	if err := s.Sync(context.Background()); err == nil {
+		t.Error("list with a duplicate peer applied")
+	}
+	publish(&PeerList{Serial: 3, Peers: []PeerListEntry{
+		{PublicKey: d},
+		{PublicKey: a, Name: "renamed"},
+		{PublicKey: b, Name: "\x00"},
+	}}, privateKey)
+	if err := s.Sync(context.Background()); err == nil {
+		t.Error("list with an invalid name applied")
+	}
+	got = peers()
+	if _, ok := got[d]; ok || len(got) != 2 || got[a].Name != "a" || len(got[a].AllowedIPs) != 2 || got[a].Endpoint != roamed.String() {
+		t.Errorf("failed lists changed peers: %+v", got)
+	}
+	if status := s.Status(); status.Serial != 2 || status.Failures != 4 || status.Err == nil {
+		t.Errorf("status %+v", status)
+	}
+}
+
+func TestPeerSyncHTTP(t *testing.T) {
+	dev, err := New(Options{TUN: tuntest.NewChannelTUN().TUN(), Bind: bindtest.NewChannelBinds()[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	publicKey, privateKey, _ := ed25519.GenerateKey(nil)
+	sk, _ := GeneratePrivateKey()
+	feed, _ := SignPeerList(&PeerList{Serial: 1, Peers: []PeerListEntry{{PublicKey: sk.PublicKey()}}}, privateKey)
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(feed)
+	}))
+	defer server.Close()
+
+	s, err := NewPeerSync(dev, PeerSyncOptions{Source: server.URL, PublicKey: publicKey, Interval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	deadline := time.Now().Add(5 * time.Second)
+	for len(dev.Status().Peers) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("feed not applied: %+v", s.Status())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	failing.Store(true)
+	if err := s.Sync(context.Background()); err == nil || s.Status().Failures == 0 {
+		t.Errorf("failed fetch: %v, status %+v", err, s.Status())
+	}
+	s.Stop()
+	if len(dev.Status().Peers) != 1 {
+		t.Error("stopping removed peers")
+	}
+}
+
+func TestPeerSyncBackoff(t *testing.T) {
+	dev, err := New(Options{TUN: tuntest.NewChannelTUN().TUN(), Bind: bindtest.NewChannelBinds()[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	publicKey, _, _ := ed25519.GenerateKey(nil)
+	s, _ := NewPeerSync(dev, PeerSyncOptions{Source: "unused", PublicKey: publicKey, Interval: time.Minute, MaxBackoff: 10 * time.Minute})
+	var waits []time.Duration
+	for failures := range 6 {
+		s.status.Failures = failures
+		waits = append(waits, s.nextWait())
+	}
+	for i, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute} {
+		if waits[i] < want*9/10 || waits[i] > want*11/10 {
+			t.Errorf("wait after %d failures is %v, want about %v", i, waits[i], want)
+		}
+	}
+
+	if _, err := NewPeerSync(dev, PeerSyncOptions{Source: "unused", PublicKey: publicKey[:16]}); err == nil {
+		t.Error("peer sync with a short key created")
+	}
+}
@@ -0,0 +1,352 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package wgembed
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+/* Supervision of dependent tunnels
+ *
+ * Chained topologies run several devices in one process, where the endpoints
+ * of one tunnel are only reachable through another: tunnel B relays through
+ * tunnel A. A Supervisor brings such tunnels up in dependency order and keeps
+ * them there. At every evaluation, which happens every interval and whenever
+ * Evaluate is called, a tunnel is wanted up while all of its dependencies are
+ * healthy. Tunnels no longer wanted are taken down first, dependents before
+ * their dependencies, then tunnels newly wanted are brought up, dependencies
+ * before their dependents. A tunnel takes at least one evaluation to become
+ * healthy after coming up, so the tunnels of a chain come up one evaluation
+ * apart. When the endpoints of a healthy tunnel roam, the tunnels depending
+ * on it are restarted, so that they bind and handshake anew along the new
+ * path. A tunnel whose device is closed stays closed, and its dependents
+ * down.
+ */
+
+const (
+	DefaultSupervisorInterval = 5 * time.Second // between evaluations, unless set otherwise
+	HealthyHandshakeAge       = 3 * time.Minute // how recent a handshake keeps a tunnel healthy by default
+)
+
+// A Tunnel is a device managed by a Supervisor.
+type Tunnel struct {
+	Name      string
+	Device    *Device
+	DependsOn []string // names of the tunnels the endpoints of this one are reached through
+
+	// Healthy reports whether the tunnel, while up, can carry the traffic of
+	// the tunnels depending on it. If nil, a tunnel is healthy while one of
+	// its peers completed a handshake within HealthyHandshakeAge, which a
+	// persistent keepalive ensures.
+	Healthy func(*Status) bool
+}
+
+// SupervisorOptions describes how a Supervisor evaluates its tunnels.
+type SupervisorOptions struct {
+	Interval time.Duration // between evaluations; DefaultSupervisorInterval if zero
+	Logger   *Logger       // receives state changes; if nil, logging is discarded
+}
+
+// A TunnelState is the state of a supervised tunnel.
+type TunnelState int
+
+const (
+	TunnelDown    TunnelState = iota // down, waiting for its dependencies
+	TunnelUp                         // up, not yet healthy
+	TunnelHealthy                    // up and healthy
+	TunnelClosed                     // its device was closed
+)
+
+func (state TunnelState) String() string {
+	switch state {
+	case TunnelDown:
+		return "down"
+	case TunnelUp:
+		return "up"
+	case TunnelHealthy:
+		return "healthy"
+	case TunnelClosed:
+		return "closed"
+	}
+	return fmt.Sprintf("TunnelState(%d)", int(state))
+}
+
+// TunnelStatus is a snapshot of the state of a supervised tunnel.
+type TunnelStatus struct {
+	Name     string
+	State    TunnelState
+	Err      error // of the last attempt to bring the tunnel up or down, if it failed
+	Restarts int   // after dependencies roamed
+}
+
+// A Supervisor brings tunnels up and down in the order of their
+// dependencies.
+type Supervisor struct {
+	tunnels  []*supervisedTunnel // dependencies first
+	interval time.Duration
+	logger   Logger
+
+	mu      sync.Mutex
+	running bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+type supervisedTunnel struct {
+	Tunnel
+	deps      []*supervisedTunnel
+	up        bool
+	state     TunnelState
+	err       error
+	restarts  int
+	endpoints string // of its peers at the last evaluation while healthy
+}
+
+// NewSupervisor returns a Supervisor of tunnels, which must have distinct
+// names and acyclic dependencies among themselves. The tunnels are left as
+// they are until Up is called, and should not be brought up or down by
+// other means while supervised.
+func NewSupervisor(tunnels []Tunnel, opts SupervisorOptions) (*Supervisor, error) {
+	s := &Supervisor{interval: opts.Interval, logger: Logger{Verbosef: discardf, Errorf: discardf}}
+	if s.interval <= 0 {
+		s.interval = DefaultSupervisorInterval
+	}
+	if opts.Logger != nil {
+		if opts.Logger.Verbosef != nil {
+			s.logger.Verbosef = opts.Logger.Verbosef
+		}
+		if opts.Logger.Errorf != nil {
+			s.logger.Errorf = opts.Logger.Errorf
+		}
+	}
+
+	byName := make(map[string]*supervisedTunnel, len(tunnels))
+	for _, tunnel := range tunnels {
+		if tunnel.Name == "" || tunnel.Device == nil {
+			return nil, errors.New("wgembed: tunnel without a name or device")
+		}
+		if byName[tunnel.Name] != nil {
+			return nil, fmt.Errorf("wgembed: tunnel %q given twice", tunnel.Name)
+		}
+		byName[tunnel.Name] = &supervisedTunnel{Tunnel: tunnel}
+	}
+	// Order the tunnels so that dependencies come first, keeping the given
+	// order otherwise.
+	placed := make(map[*supervisedTunnel]bool, len(tunnels))
+	for len(s.tunnels) < len(tunnels) {
+		progress := false
+		for _, tunnel := range tunnels {
+			t := byName[tunnel.Name]
+			if placed[t] {
+				continue
+			}
+			ready := true
+			for _, name := range t.DependsOn {
+				dep := byName[name]
+				if dep == nil {
+					return nil, fmt.Errorf("wgembed: tunnel %q depends on unknown tunnel %q", t.Name, name)
+				}
+				ready = ready && placed[dep]
+			}
+			if !ready {
+				continue
+			}
+			for _, name := range t.DependsOn {
+				t.deps = append(t.deps, byName[name])
+			}
+			placed[t] = true
+			s.tunnels = append(s.tunnels, t)
+			progress = true
+		}
+		if !progress {
+			return nil, errors.New("wgembed: tunnel dependencies form a cycle")
+		}
+	}
+	return s, nil
+}
+
+func discardf(format string, args ...any) {}
+
+// Up starts supervising the tunnels, bringing up those without dependencies
+// at once and the others as their dependencies become healthy.
+func (s *Supervisor) Up() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return errors.New("wgembed: supervisor already up")
+	}
+	s.running = true
+	s.stop, s.done = make(chan struct{}), make(chan struct{})
+	s.evaluateLocked()
+	go s.run(s.stop, s.done)
+	return nil
+}
+
+// Down stops supervising the tunnels and takes those it brought up down,
+// dependents before their dependencies.
+func (s *Supervisor) Down() error {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return nil
+	}
+	s.running = false
+	close(s.stop)
+	done := s.done
+	s.mu.Unlock()
+	<-done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	for _, t := range slices.Backward(s.tunnels) {
+		if t.up {
+			if err := s.takeDownLocked(t, "supervisor going down"); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Evaluate re-evaluates the tunnels at once, rather than at the next
+// interval, such as after an application learns that a path failed.
+func (s *Supervisor) Evaluate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		s.evaluateLocked()
+	}
+}
+
+// Status returns the state of every tunnel, dependencies first.
+func (s *Supervisor) Status() []TunnelStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := make([]TunnelStatus, len(s.tunnels))
+	for i, t := range s.tunnels {
+		status[i] = TunnelStatus{Name: t.Name, State: t.state, Err: t.err, Restarts: t.restarts}
+	}
+	return status
+}
+
+func (s *Supervisor) run(stop, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.Evaluate()
+		}
+	}
+}
+
+func (s *Supervisor) evaluateLocked() {
+	want := make(map[*supervisedTunnel]bool, len(s.tunnels))
+	healthy := make(map[*supervisedTunnel]bool, len(s.tunnels))
+	restart := make(map[*supervisedTunnel]bool)
+	for _, t := range s.tunnels {
+		select {
+		case <-t.Device.Done():
+			if t.state != TunnelClosed {
+				s.logger.Errorf("Tunnel %s: device closed", t.Name)
+			}
+			t.up, t.state = false, TunnelClosed
+			continue
+		default:
+		}
+		// A dependency being restarted is about to be unhealthy, and one
+		// not wanted about to go down.
+		want[t] = true
+		for _, dep := range t.deps {
+			want[t] = want[t] && healthy[dep] && !restart[dep]
+		}
+		if !t.up || !want[t] {
+			continue
+		}
+		status := t.Device.Status()
+		if !t.healthy(status) {
+			t.state = TunnelUp
+			continue
+		}
+		endpoints := peerEndpoints(status)
+		if t.state == TunnelHealthy && t.endpoints != endpoints {
+			s.logger.Verbosef("Tunnel %s: endpoints roamed, restarting dependents", t.Name)
+			for _, d := range s.tunnels {
+				if slices.Contains(d.deps, t) {
+					restart[d] = true
+				}
+			}
+		}
+		t.state, t.endpoints = TunnelHealthy, endpoints
+		healthy[t] = true
+	}
+
+	for _, t := range slices.Backward(s.tunnels) {
+		switch {
+		case !t.up:
+		case !want[t]:
+			s.takeDownLocked(t, "dependency not healthy")
+		case restart[t]:
+			t.restarts++
+			s.takeDownLocked(t, "restarting")
+		}
+	}
+	for _, t := range s.tunnels {
+		if want[t] && !t.up {
+			s.bringUpLocked(t)
+		}
+	}
+}
+
+func (s *Supervisor) bringUpLocked(t *supervisedTunnel) {
+	if t.err = t.Device.Up(); t.err != nil {
+		s.logger.Errorf("Tunnel %s: failed to come up: %v", t.Name, t.err)
+		return
+	}
+	s.logger.Verbosef("Tunnel %s: up", t.Name)
+	t.up, t.state = true, TunnelUp
+}
+
+func (s *Supervisor) takeDownLocked(t *supervisedTunnel, reason string) error {
+	t.up, t.state = false, TunnelDown
+	if t.err = t.Device.Down(); t.err != nil {
+		s.logger.Errorf("Tunnel %s: failed to go down: %v", t.Name, t.err)
+		return fmt.Errorf("tunnel %s: %w", t.Name, t.err)
+	}
+	s.logger.Verbosef("Tunnel %s: down, %s", t.Name, reason)
+	return nil
+}
+
+func (t *supervisedTunnel) healthy(status *Status) bool {
+	if t.Healthy != nil {
+		return t.Healthy(status)
+	}
+	for _, p := range status.Peers {
+		if time.Since(p.LastHandshakeTime) < HealthyHandshakeAge {
+			return true
+		}
+	}
+	return false
+}
+
+// peerEndpoints returns the endpoints of the peers in status, in a form that
+// compares equal only if none roamed.
+func peerEndpoints(status *Status) string {
+	var b strings.Builder
+	for _, p := range status.Peers {
+		fmt.Fprintf(&b, "%s=%s\n", p.PublicKey, p.Endpoint)
+	}
+	return b.String()
+}
@@ -0,0 +1,120 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package wgembed
+
+import (
+	"net/netip"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func TestSupervisor(t *testing.T) {
+	var (
+		devs    [3]*Device
+		healthy [3]atomic.Bool
+		peer    Key
+	)
+	peer, _ = GeneratePrivateKey()
+	pair0, pair1 := bindtest.NewChannelBinds(), bindtest.NewChannelBinds()
+	binds := [3]conn.Bind{pair0[0], pair0[1], pair1[0]}
+	for i := range devs {
+		var err error
+		if devs[i], err = New(Options{TUN: tuntest.NewChannelTUN().TUN(), Bind: binds[i]}); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(devs[i].Close)
+		sk, _ := GeneratePrivateKey()
+		endpoint := netip.MustParseAddrPort("127.0.0.1:1")
+		if err := devs[i].Configure(Config{PrivateKey: &sk, Peers: []PeerConfig{{PublicKey: peer.PublicKey(), Endpoint: &endpoint}}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tunnel := func(i int, name string, deps ...string) Tunnel {
+		return Tunnel{Name: name, Device: devs[i], DependsOn: deps, Healthy: func(*Status) bool { return healthy[i].Load() }}
+	}
+	// Given out of order, to be sorted by dependency.
+	s, err := NewSupervisor([]Tunnel{tunnel(2, "c", "b"), tunnel(0, "a"), tunnel(1, "b", "a")}, SupervisorOptions{Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(what string, want ...TunnelState) {
+		t.Helper()
+		var got []TunnelState
+		for i, status := range s.Status() {
+			if status.Name != []string{"a", "b", "c"}[i] {
+				t.Fatalf("%s: tunnel %d is %s", what, i, status.Name)
+			}
+			got = append(got, status.State)
+		}
+		if !slices.Equal(got, want) {
+			t.Errorf("%s: states %v, want %v", what, got, want)
+		}
+	}
+
+	if err := s.Up(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Down()
+	check("start", TunnelUp, TunnelDown, TunnelDown)
+	healthy[0].Store(true)
+	s.Evaluate()
+	check("a healthy", TunnelHealthy, TunnelUp, TunnelDown)
+	healthy[1].Store(true)
+	healthy[2].Store(true)
+	s.Evaluate()
+	check("b healthy", TunnelHealthy, TunnelHealthy, TunnelUp)
+	s.Evaluate()
+	check("all healthy", TunnelHealthy, TunnelHealthy, TunnelHealthy)
+
+	// When a roams, b restarts, taking c down until it is healthy again.
+	endpoint := netip.MustParseAddrPort("127.0.0.1:2")
+	if err := devs[0].Configure(Config{Peers: []PeerConfig{{PublicKey: peer.PublicKey(), UpdateOnly: true, Endpoint: &endpoint}}}); err != nil {
+		t.Fatal(err)
+	}
+	s.Evaluate()
+	check("a roamed", TunnelHealthy, TunnelUp, TunnelDown)
+	if restarts := s.Status()[1].Restarts; restarts != 1 {
+		t.Errorf("b restarted %d times, want once", restarts)
+	}
+	s.Evaluate()
+	s.Evaluate()
+	check("recovered", TunnelHealthy, TunnelHealthy, TunnelHealthy)
+
+	healthy[0].Store(false)
+	s.Evaluate()
+	check("a unhealthy", TunnelUp, TunnelDown, TunnelDown)
+	devs[0].Close()
+	s.Evaluate()
+	check("a closed", TunnelClosed, TunnelDown, TunnelDown)
+	if err := s.Down(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSupervisorDependencies(t *testing.T) {
+	dev, err := New(Options{TUN: tuntest.NewChannelTUN().TUN(), Bind: bindtest.NewChannelBinds()[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	for _, tunnels := range [][]Tunnel{
+		{{Name: "a", Device: dev, DependsOn: []string{"b"}}, {Name: "b", Device: dev, DependsOn: []string{"a"}}},
+		{{Name: "a", Device: dev, DependsOn: []string{"a"}}},
+		{{Name: "a", Device: dev, DependsOn: []string{"nope"}}},
+		{{Name: "a", Device: dev}, {Name: "a", Device: dev}},
+		{{Name: "a"}},
+	} {
+		if _, err := NewSupervisor(tunnels, SupervisorOptions{}); err == nil {
+			t.Errorf("supervisor of %+v created", tunnels)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package wgembed is the stable interface for programs that run a WireGuard
+// device in-process.
+//
+// The device package exposes implementation details (handshake state,
+// pipeline queues, cipher experiments) that change between releases.
+// Programs that only need to create, configure and inspect a device should
+// use this package instead: its exported identifiers follow semantic
+// versioning, and are only removed or changed incompatibly alongside a
+// change of APIVersion. New fields and functions may be added at any time.
+package wgembed
+
+import (
+	"errors"
+	"net/netip"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// APIVersion is the major version of this package's interface.
+const APIVersion = 1
+
+// Logger receives log output from a Device. Either function may be nil, in
+// which case that level of logging is discarded. The functions must be safe
+// for concurrent use.
+type Logger struct {
+	Verbosef func(format string, args ...any)
+	Errorf   func(format string, args ...any)
+}
+
+// Options describes how to construct a Device.
+type Options struct {
+	// TUN is the interface that carries plaintext packets. It is required,
+	// and is closed when the Device is closed.
+	TUN tun.Device
+
+	// Bind carries encrypted packets. If nil, conn.NewDefaultBind is used.
+	Bind conn.Bind
+
+	// Logger receives log output. If nil, logging is discarded.
+	Logger *Logger
+}
+
+// Config describes changes to a Device. Nil pointer fields are left
+// unchanged.
+type Config struct {
+	PrivateKey   *Key
+	ListenPort   *int
+	ListenPorts  []int // primary port followed by additional ports; overrides ListenPort
+	FirewallMark *int
+	ReplacePeers bool
+	Peers        []PeerConfig
+}
+
+// PeerConfig describes changes to a single peer. Nil pointer fields are left
+// unchanged.
+type PeerConfig struct {
+	PublicKey                   Key
+	Remove                      bool
+	UpdateOnly                  bool
+	PresharedKey                *Key
+	Endpoint                    *netip.AddrPort
+	PersistentKeepaliveInterval *time.Duration
+	Name                        *string
+	ReplaceAllowedIPs           bool
+	AllowedIPs                  []netip.Prefix
+}
+
+// Status is a snapshot of a Device's configuration and statistics.
+type Status struct {
+	PublicKey    Key
+	ListenPort   int
+	FirewallMark int
+	Peers        []PeerStatus
+}
+
+// PeerStatus is a snapshot of a single peer's configuration and statistics.
+type PeerStatus struct {
+	PublicKey                   Key
+	Name                        string
+	Endpoint                    string
+	PersistentKeepaliveInterval time.Duration
+	LastHandshakeTime           time.Time
+	ReceiveBytes                int64
+	TransmitBytes               int64
+	AllowedIPs                  []netip.Prefix
+}
+
+// A Device is a running WireGuard interface.
+type Device struct {
+	dev *device.Device
+}
+
+// New creates a Device. The device is down until Up is called.
+func New(opts Options) (*Device, error) {
+	if opts.TUN == nil {
+		return nil, errors.New("wgembed: Options.TUN is required")
+	}
+	bind := opts.Bind
+	if bind == nil {
+		bind = conn.NewDefaultBind()
+	}
+	logger := &device.Logger{Verbosef: device.DiscardLogf, Errorf: device.DiscardLogf}
+	if opts.Logger != nil {
+		if opts.Logger.Verbosef != nil {
+			logger.Verbosef = opts.Logger.Verbosef
+		}
+		if opts.Logger.Errorf != nil {
+			logger.Errorf = opts.Logger.Errorf
+		}
+	}
+	return &Device{dev: device.NewDevice(opts.TUN, bind, logger)}, nil
+}
+
+// Up brings the device up, binding its listen port.
+func (d *Device) Up() error {
+	return d.dev.Up()
+}
+
+// Down takes the device down, closing its listen port.
+func (d *Device) Down() error {
+	return d.dev.Down()
+}
+
+// Close shuts the device down and releases its resources, including the TUN.
+func (d *Device) Close() {
+	d.dev.Close()
+}
+
+// Done returns a channel that is closed when the device is closed.
+func (d *Device) Done() <-chan struct{} {
+	return d.dev.Wait()
+}
+
+// Configure applies cfg to the device.
+func (d *Device) Configure(cfg Config) error {
+	dc := device.Config{
+		ListenPort:   cfg.ListenPort,
+		ListenPorts:  cfg.ListenPorts,
+		FirewallMark: cfg.FirewallMark,
+		ReplacePeers: cfg.ReplacePeers,
+		Peers:        make([]device.PeerConfig, len(cfg.Peers)),
+	}
+	if cfg.PrivateKey != nil {
+		sk := device.NoisePrivateKey(*cfg.PrivateKey)
+		dc.PrivateKey = &sk
+	}
+	for i, p := range cfg.Peers {
+		dc.Peers[i] = device.PeerConfig{
+			PublicKey:                   device.NoisePublicKey(p.PublicKey),
+			Remove:                      p.Remove,
+			UpdateOnly:                  p.UpdateOnly,
+			Endpoint:                    p.Endpoint,
+			PersistentKeepaliveInterval: p.PersistentKeepaliveInterval,
+			Name:                        p.Name,
+			ReplaceAllowedIPs:           p.ReplaceAllowedIPs,
+			AllowedIPs:                  p.AllowedIPs,
+		}
+		if p.PresharedKey != nil {
+			psk := device.NoisePresharedKey(*p.PresharedKey)
+			dc.Peers[i].PresharedKey = &psk
+		}
+	}
+	return d.dev.Configure(dc)
+}
+
+// Status returns a snapshot of the device's configuration and statistics.
+// Private and preshared keys are not included.
+func (d *Device) Status() *Status {
+	ds := d.dev.Status()
+	s := &Status{
+		PublicKey:    Key(ds.PublicKey),
+		ListenPort:   ds.ListenPort,
+		FirewallMark: ds.FirewallMark,
+		Peers:        make([]PeerStatus, len(ds.Peers)),
+	}
+	for i, p := range ds.Peers {
+		s.Peers[i] = PeerStatus{
+			PublicKey:                   Key(p.PublicKey),
+			Name:                        p.Name,
+			Endpoint:                    p.Endpoint,
+			PersistentKeepaliveInterval: p.PersistentKeepaliveInterval,
+			LastHandshakeTime:           p.LastHandshakeTime,
+			ReceiveBytes:                p.ReceiveBytes,
+			TransmitBytes:               p.TransmitBytes,
+			AllowedIPs:                  p.AllowedIPs,
+		}
+	}
+	return s
+}
+
+// SetUAPI applies a configuration in the text format of the cross-platform
+// userspace configuration protocol, as used by wg(8).
+func (d *Device) SetUAPI(config string) error {
+	return d.dev.IpcSet(config)
+}
+
+// GetUAPI returns the device's configuration in the text format of the
+// cross-platform userspace configuration protocol.
+func (d *Device) GetUAPI() (string, error) {
+	return d.dev.IpcGet()
+}
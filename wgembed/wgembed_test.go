@@ -0,0 +1,101 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package wgembed
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+	"golang.zx2c4.com/wireguard/tun/tuntest"
+)
+
+func TestKeyRoundTrip(t *testing.T) {
+	sk, err := GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseKey(sk.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != sk {
+		t.Errorf("ParseKey(%q) = %v", sk.String(), parsed)
+	}
+	if _, err := ParseKey("AAAA"); err == nil {
+		t.Error("short key was accepted")
+	}
+}
+
+func TestDevicePair(t *testing.T) {
+	binds := bindtest.NewChannelBinds()
+	var (
+		tuns [2]*tuntest.ChannelTUN
+		devs [2]*Device
+		sks  [2]Key
+		ips  = [2]netip.Addr{netip.MustParseAddr("1.0.0.1"), netip.MustParseAddr("1.0.0.2")}
+	)
+	for i := range devs {
+		var err error
+		if sks[i], err = GeneratePrivateKey(); err != nil {
+			t.Fatal(err)
+		}
+		tuns[i] = tuntest.NewChannelTUN()
+		if devs[i], err = New(Options{TUN: tuns[i].TUN(), Bind: binds[i]}); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(devs[i].Close)
+	}
+	for i := range devs {
+		port := 0
+		err := devs[i].Configure(Config{
+			PrivateKey: &sks[i],
+			ListenPort: &port,
+			Peers: []PeerConfig{{
+				PublicKey:  sks[i^1].PublicKey(),
+				AllowedIPs: []netip.Prefix{netip.PrefixFrom(ips[i^1], 32)},
+			}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := devs[i].Up(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range devs {
+		endpoint := netip.AddrPortFrom(netip.MustParseAddr("127.0.0.1"), uint16(devs[i^1].Status().ListenPort))
+		err := devs[i].Configure(Config{Peers: []PeerConfig{{
+			PublicKey:  sks[i^1].PublicKey(),
+			UpdateOnly: true,
+			Endpoint:   &endpoint,
+		}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ping := tuntest.Ping(ips[1], ips[0])
+	tuns[0].Outbound <- ping
+	select {
+	case got := <-tuns[1].Inbound:
+		if !bytes.Equal(got, ping) {
+			t.Error("ping did not transit correctly")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ping did not transit")
+	}
+
+	status := devs[0].Status()
+	if status.PublicKey != sks[0].PublicKey() || len(status.Peers) != 1 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	if p := status.Peers[0]; p.PublicKey != sks[1].PublicKey() || p.LastHandshakeTime.IsZero() {
+		t.Errorf("unexpected peer status: %+v", p)
+	}
+}
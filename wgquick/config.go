@@ -0,0 +1,288 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package wgquick
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.zx2c4.com/wireguard/device"
+)
+
+// Config is a parsed wg-quick(8) configuration file.
+type Config struct {
+	Interface InterfaceConfig
+	Peers     []PeerConfig
+}
+
+// InterfaceConfig is the [Interface] section of a configuration file.
+type InterfaceConfig struct {
+	PrivateKey device.NoisePrivateKey
+	ListenPort *int
+	FwMark     *int
+	Addresses  []netip.Prefix
+	DNS        []netip.Addr
+	DNSSearch  []string
+	MTU        int    // zero selects device.DefaultMTU
+	Table      string // "auto" if empty, "off", or a routing table number
+
+	// Hook commands are recorded but never run by Up or Down; callers that
+	// want them must execute them.
+	PreUp, PostUp, PreDown, PostDown []string
+	SaveConfig                       bool
+}
+
+// PeerConfig is a [Peer] section of a configuration file.
+type PeerConfig struct {
+	PublicKey           device.NoisePublicKey
+	PresharedKey        *device.NoisePresharedKey
+	AllowedIPs          []netip.Prefix
+	Endpoint            string // host:port, resolved by DeviceConfig
+	PersistentKeepalive time.Duration
+}
+
+// Parse reads a configuration file in the format used by wg-quick(8).
+func Parse(r io.Reader) (*Config, error) {
+	cfg := new(Config)
+	var section string
+	var peer *PeerConfig
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			switch section {
+			case "interface":
+			case "peer":
+				cfg.Peers = append(cfg.Peers, PeerConfig{})
+				peer = &cfg.Peers[len(cfg.Peers)-1]
+			default:
+				return nil, fmt.Errorf("line %d: unknown section %q", lineno, section)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", lineno)
+		}
+		key, value = strings.ToLower(strings.TrimSpace(key)), strings.TrimSpace(value)
+
+		var err error
+		switch section {
+		case "interface":
+			err = cfg.Interface.set(key, value)
+		case "peer":
+			err = peer.set(key, value)
+		default:
+			err = fmt.Errorf("%s outside of a section", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineno, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for i := range cfg.Peers {
+		if cfg.Peers[i].PublicKey == (device.NoisePublicKey{}) {
+			return nil, fmt.Errorf("peer %d has no public key", i+1)
+		}
+	}
+	return cfg, nil
+}
+
+func (ic *InterfaceConfig) set(key, value string) error {
+	switch key {
+	case "privatekey":
+		return parseKey((*[32]byte)(&ic.PrivateKey), value)
+	case "listenport":
+		port, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid ListenPort: %w", err)
+		}
+		p := int(port)
+		ic.ListenPort = &p
+	case "fwmark":
+		mark := 0
+		if value != "off" {
+			m, err := strconv.ParseUint(value, 0, 32)
+			if err != nil {
+				return fmt.Errorf("invalid FwMark: %w", err)
+			}
+			mark = int(m)
+		}
+		ic.FwMark = &mark
+	case "address":
+		for _, field := range splitList(value) {
+			prefix, err := parsePrefix(field)
+			if err != nil {
+				return fmt.Errorf("invalid Address: %w", err)
+			}
+			ic.Addresses = append(ic.Addresses, prefix)
+		}
+	case "dns":
+		for _, field := range splitList(value) {
+			if ip, err := netip.ParseAddr(field); err == nil {
+				ic.DNS = append(ic.DNS, ip)
+			} else {
+				ic.DNSSearch = append(ic.DNSSearch, field)
+			}
+		}
+	case "mtu":
+		mtu, err := strconv.ParseUint(value, 10, 16)
+		if err != nil {
+			return fmt.Errorf("invalid MTU: %w", err)
+		}
+		ic.MTU = int(mtu)
+	case "table":
+		if value != "off" && value != "auto" && value != "main" {
+			if _, err := strconv.ParseUint(value, 10, 32); err != nil {
+				return fmt.Errorf("invalid Table: %q", value)
+			}
+		}
+		ic.Table = value
+	case "preup":
+		ic.PreUp = append(ic.PreUp, value)
+	case "postup":
+		ic.PostUp = append(ic.PostUp, value)
+	case "predown":
+		ic.PreDown = append(ic.PreDown, value)
+	case "postdown":
+		ic.PostDown = append(ic.PostDown, value)
+	case "saveconfig":
+		ic.SaveConfig = value == "true"
+	default:
+		return fmt.Errorf("unknown Interface key %q", key)
+	}
+	return nil
+}
+
+func (pc *PeerConfig) set(key, value string) error {
+	switch key {
+	case "publickey":
+		return parseKey((*[32]byte)(&pc.PublicKey), value)
+	case "presharedkey":
+		var psk device.NoisePresharedKey
+		if err := parseKey((*[32]byte)(&psk), value); err != nil {
+			return err
+		}
+		pc.PresharedKey = &psk
+	case "allowedips":
+		for _, field := range splitList(value) {
+			prefix, err := parsePrefix(field)
+			if err != nil {
+				return fmt.Errorf("invalid AllowedIPs: %w", err)
+			}
+			pc.AllowedIPs = append(pc.AllowedIPs, prefix)
+		}
+	case "endpoint":
+		if _, _, err := net.SplitHostPort(value); err != nil {
+			return fmt.Errorf("invalid Endpoint: %w", err)
+		}
+		pc.Endpoint = value
+	case "persistentkeepalive":
+		seconds := uint64(0)
+		if value != "off" {
+			var err error
+			seconds, err = strconv.ParseUint(value, 10, 16)
+			if err != nil {
+				return fmt.Errorf("invalid PersistentKeepalive: %w", err)
+			}
+		}
+		pc.PersistentKeepalive = time.Duration(seconds) * time.Second
+	default:
+		return fmt.Errorf("unknown Peer key %q", key)
+	}
+	return nil
+}
+
+func parseKey(dst *[32]byte, value string) error {
+	b, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(b) != len(dst) {
+		return fmt.Errorf("invalid key %q", value)
+	}
+	copy(dst[:], b)
+	return nil
+}
+
+func splitList(value string) []string {
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// parsePrefix parses an address with an optional prefix length, which
+// defaults to a host prefix as in wg-quick.
+func parsePrefix(s string) (netip.Prefix, error) {
+	if strings.IndexByte(s, '/') >= 0 {
+		return netip.ParsePrefix(s)
+	}
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(ip, ip.BitLen()), nil
+}
+
+// DeviceConfig returns the device configuration described by c, resolving
+// peer endpoints. It replaces all peers of the device.
+func (c *Config) DeviceConfig() (device.Config, error) {
+	sk := c.Interface.PrivateKey
+	dc := device.Config{
+		PrivateKey:   &sk,
+		ListenPort:   c.Interface.ListenPort,
+		FirewallMark: c.Interface.FwMark,
+		ReplacePeers: true,
+		Peers:        make([]device.PeerConfig, 0, len(c.Peers)),
+	}
+	for _, p := range c.Peers {
+		keepalive := p.PersistentKeepalive
+		pc := device.PeerConfig{
+			PublicKey:                   p.PublicKey,
+			PresharedKey:                p.PresharedKey,
+			PersistentKeepaliveInterval: &keepalive,
+			ReplaceAllowedIPs:           true,
+			AllowedIPs:                  p.AllowedIPs,
+		}
+		if p.Endpoint != "" {
+			addr, err := net.ResolveUDPAddr("udp", p.Endpoint)
+			if err != nil {
+				return dc, fmt.Errorf("failed to resolve endpoint %q: %w", p.Endpoint, err)
+			}
+			endpoint := addr.AddrPort()
+			endpoint = netip.AddrPortFrom(endpoint.Addr().Unmap(), endpoint.Port())
+			pc.Endpoint = &endpoint
+		}
+		dc.Peers = append(dc.Peers, pc)
+	}
+	return dc, nil
+}
+
+// TUNMTU returns the MTU to create the TUN device with.
+func (c *Config) TUNMTU() int {
+	if c.Interface.MTU != 0 {
+		return c.Interface.MTU
+	}
+	return device.DefaultMTU
+}
@@ -0,0 +1,134 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package wgquick
+
+import (
+	"net/netip"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = `
+# road warrior
+[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+ListenPort = 51820
+Address = 10.200.100.8/24, fd42::8/64
+DNS = 10.200.100.1, example.com
+MTU = 1380
+PostUp = echo up
+
+[peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+PresharedKey = /UwcSPg38hW/D9Y3tcS1FOV0K1wuURMbS0sesJEP5ak=
+AllowedIPs = 0.0.0.0/0, 10.200.100.0/24, 10.9.0.0/16
+Endpoint = 192.0.2.1:51820
+PersistentKeepalive = 25
+`
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ic := cfg.Interface
+	if *ic.ListenPort != 51820 || ic.MTU != 1380 || cfg.TUNMTU() != 1380 {
+		t.Errorf("unexpected listen port %d or MTU %d", *ic.ListenPort, ic.MTU)
+	}
+	wantAddrs := []netip.Prefix{netip.MustParsePrefix("10.200.100.8/24"), netip.MustParsePrefix("fd42::8/64")}
+	if !slices.Equal(ic.Addresses, wantAddrs) {
+		t.Errorf("addresses = %v, want %v", ic.Addresses, wantAddrs)
+	}
+	if !slices.Equal(ic.DNS, []netip.Addr{netip.MustParseAddr("10.200.100.1")}) || !slices.Equal(ic.DNSSearch, []string{"example.com"}) {
+		t.Errorf("DNS = %v search %v", ic.DNS, ic.DNSSearch)
+	}
+	if !slices.Equal(ic.PostUp, []string{"echo up"}) {
+		t.Errorf("PostUp = %q", ic.PostUp)
+	}
+	if len(cfg.Peers) != 1 {
+		t.Fatalf("got %d peers", len(cfg.Peers))
+	}
+	p := cfg.Peers[0]
+	if p.PresharedKey == nil || p.Endpoint != "192.0.2.1:51820" || p.PersistentKeepalive != 25*time.Second {
+		t.Errorf("unexpected peer %+v", p)
+	}
+
+	dc, err := cfg.DeviceConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dc.Peers) != 1 || dc.Peers[0].Endpoint.String() != "192.0.2.1:51820" || len(dc.Peers[0].AllowedIPs) != 3 {
+		t.Errorf("unexpected device config %+v", dc)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, conf := range []string{
+		"ListenPort = 1",
+		"[Interface]\nListenPort = 70000",
+		"[Interface]\nBogus = 1",
+		"[Interface]\nAddress = 10.0.0.1/33",
+		"[Interface]\nPrivateKey = short",
+		"[Interface]\nTable = nope",
+		"[Peer]\nAllowedIPs = 10.0.0.0/8",
+		"[Peer]\nPublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=\nEndpoint = 192.0.2.1",
+		"[Wat]",
+	} {
+		if _, err := Parse(strings.NewReader(conf)); err == nil {
+			t.Errorf("no error parsing %q", conf)
+		}
+	}
+}
+
+func TestPlan(t *testing.T) {
+	cfg, err := Parse(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Peers = append(cfg.Peers, PeerConfig{
+		AllowedIPs: []netip.Prefix{netip.MustParsePrefix("10.9.1.7/16"), netip.MustParsePrefix("::/0"), netip.MustParsePrefix("fd42::1/128")},
+	})
+
+	plan, err := cfg.plan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRoutes := []netip.Prefix{
+		netip.MustParsePrefix("fd42::1/128"),
+		netip.MustParsePrefix("10.200.100.0/24"),
+		netip.MustParsePrefix("10.9.0.0/16"),
+	}
+	if !slices.Equal(plan.routes, wantRoutes) || plan.table != 0 {
+		t.Errorf("routes = %v in table %d, want %v in main", plan.routes, plan.table, wantRoutes)
+	}
+	wantDefault := []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0"), netip.MustParsePrefix("::/0")}
+	if !slices.Equal(plan.defaultRoutes, wantDefault) || plan.defaultTable != DefaultTable {
+		t.Errorf("default routes = %v in table %d", plan.defaultRoutes, plan.defaultTable)
+	}
+
+	mark := 1234
+	cfg.Interface.FwMark = &mark
+	if plan, _ = cfg.plan(); plan.defaultTable != 1234 {
+		t.Errorf("default table = %d, want the firewall mark", plan.defaultTable)
+	}
+
+	cfg.Interface.Table = "main"
+	if plan, _ = cfg.plan(); len(plan.defaultRoutes) != 0 || len(plan.routes) != 5 {
+		t.Errorf("Table = main: routes %v, default routes %v", plan.routes, plan.defaultRoutes)
+	}
+
+	cfg.Interface.Table = "1000"
+	if plan, _ = cfg.plan(); plan.table != 1000 || len(plan.routes) != 5 {
+		t.Errorf("Table = 1000: routes %v in table %d", plan.routes, plan.table)
+	}
+
+	cfg.Interface.Table = "off"
+	if plan, _ = cfg.plan(); len(plan.routes) != 0 || len(plan.defaultRoutes) != 0 {
+		t.Errorf("Table = off: routes %v, default routes %v", plan.routes, plan.defaultRoutes)
+	}
+}
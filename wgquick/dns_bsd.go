@@ -0,0 +1,14 @@
+//go:build freebsd || openbsd
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package wgquick
+
+import "net/netip"
+
+func (s *bsdSystem) setDNS(servers []netip.Addr, search []string) (func() error, error) {
+	return setResolvconf(s.name, servers, search)
+}
@@ -0,0 +1,15 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package wgquick
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+func (s *bsdSystem) setDNS(servers []netip.Addr, search []string) (func() error, error) {
+	return nil, fmt.Errorf("DNS configuration is %w", errUnsupported)
+}
@@ -0,0 +1,35 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package wgquick
+
+import "golang.org/x/sys/unix"
+
+const (
+	_SIOCAIFADDR     = 0x8044692b
+	_SIOCAIFADDR_IN6 = 0x8088691b
+	_SIOCDIFADDR_IN6 = 0x81206919
+)
+
+type ifaliasreq struct {
+	Name    [unix.IFNAMSIZ]byte
+	Addr    unix.RawSockaddrInet4
+	Dstaddr unix.RawSockaddrInet4
+	Mask    unix.RawSockaddrInet4
+	Vhid    int32
+}
+
+type in6Aliasreq struct {
+	Name       [unix.IFNAMSIZ]byte
+	Addr       unix.RawSockaddrInet6
+	Dstaddr    unix.RawSockaddrInet6
+	Prefixmask unix.RawSockaddrInet6
+	Flags      int32
+	Expire     int64
+	Preferred  int64
+	Vltime     uint32
+	Pltime     uint32
+	Vhid       int32
+}
@@ -0,0 +1,39 @@
+//go:build linux || freebsd || openbsd
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package wgquick
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"os/exec"
+	"strings"
+)
+
+// setResolvconf registers DNS servers and search domains for the interface
+// with resolvconf(8), the same way wg-quick does.
+func setResolvconf(name string, servers []netip.Addr, search []string) (func() error, error) {
+	var conf bytes.Buffer
+	for _, ip := range servers {
+		fmt.Fprintf(&conf, "nameserver %s\n", ip)
+	}
+	if len(search) != 0 {
+		fmt.Fprintf(&conf, "search %s\n", strings.Join(search, " "))
+	}
+	cmd := exec.Command("resolvconf", "-a", name, "-m", "0", "-x")
+	cmd.Stdin = &conf
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return nil, fmt.Errorf("resolvconf: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return func() error {
+		if out, err := exec.Command("resolvconf", "-d", name, "-f").CombinedOutput(); err != nil {
+			return fmt.Errorf("resolvconf: %w: %s", err, bytes.TrimSpace(out))
+		}
+		return nil
+	}, nil
+}
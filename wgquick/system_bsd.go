@@ -0,0 +1,223 @@
+//go:build darwin || freebsd || openbsd
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package wgquick
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"unsafe"
+
+	"golang.org/x/net/route"
+	"golang.org/x/sys/unix"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+/* Addresses are configured with the ioctls used by ifconfig(8), and routes
+ * are written to a routing socket like route(8) does:
+ *
+ *   ifconfig <name> inet <address> <address> alias
+ *   ifconfig <name> inet6 <address> alias
+ *   ifconfig <name> up
+ *   route add -net <allowed ip> -interface <name>
+ */
+
+const nd6InfiniteLifetime = 0xffffffff
+
+type ifreqFlags struct {
+	Name  [unix.IFNAMSIZ]byte
+	Flags uint16
+	_     [14]byte
+}
+
+type in6Ifreq struct {
+	Name [unix.IFNAMSIZ]byte
+	Addr unix.RawSockaddrInet6
+	_    [0x120 - unix.IFNAMSIZ - unix.SizeofSockaddrInet6]byte
+}
+
+type bsdSystem struct {
+	name        string
+	index       int
+	fd4, fd6    int
+	routeSocket int
+	seq         int
+}
+
+func openSystem(tunDevice tun.Device) (system, error) {
+	name, err := tunDevice.Name()
+	if err != nil {
+		return nil, err
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	s := &bsdSystem{name: name, index: iface.Index, fd4: -1, fd6: -1, routeSocket: -1}
+	if s.fd4, err = unix.Socket(unix.AF_INET, unix.SOCK_DGRAM, 0); err != nil {
+		s.close()
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if s.fd6, err = unix.Socket(unix.AF_INET6, unix.SOCK_DGRAM, 0); err != nil {
+		s.close()
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if s.routeSocket, err = unix.Socket(unix.AF_ROUTE, unix.SOCK_RAW, unix.AF_UNSPEC); err != nil {
+		s.close()
+		return nil, os.NewSyscallError("socket", err)
+	}
+	// Only our own replies are of interest, and those are not read.
+	unix.SetsockoptInt(s.routeSocket, unix.SOL_SOCKET, unix.SO_USELOOPBACK, 0)
+	return s, nil
+}
+
+func (s *bsdSystem) close() error {
+	for _, fd := range []int{s.fd4, s.fd6, s.routeSocket} {
+		if fd != -1 {
+			unix.Close(fd)
+		}
+	}
+	return nil
+}
+
+func ioctl(fd int, req uint, arg unsafe.Pointer) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(req), uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (s *bsdSystem) setUp() error {
+	var ifr ifreqFlags
+	copy(ifr.Name[:], s.name)
+	if err := ioctl(s.fd4, unix.SIOCGIFFLAGS, unsafe.Pointer(&ifr)); err != nil {
+		return fmt.Errorf("SIOCGIFFLAGS: %w", err)
+	}
+	ifr.Flags |= unix.IFF_UP
+	if err := ioctl(s.fd4, unix.SIOCSIFFLAGS, unsafe.Pointer(&ifr)); err != nil {
+		return fmt.Errorf("SIOCSIFFLAGS: %w", err)
+	}
+	return nil
+}
+
+func sockaddr4(addr netip.Addr) unix.RawSockaddrInet4 {
+	return unix.RawSockaddrInet4{Len: unix.SizeofSockaddrInet4, Family: unix.AF_INET, Addr: addr.As4()}
+}
+
+func sockaddr6(addr netip.Addr) unix.RawSockaddrInet6 {
+	return unix.RawSockaddrInet6{Len: unix.SizeofSockaddrInet6, Family: unix.AF_INET6, Addr: addr.As16()}
+}
+
+// mask returns the netmask of prefix as an address.
+func mask(prefix netip.Prefix) netip.Addr {
+	b := net.CIDRMask(prefix.Bits(), prefix.Addr().BitLen())
+	addr, _ := netip.AddrFromSlice(b)
+	return addr
+}
+
+func (s *bsdSystem) addAddress(prefix netip.Prefix) (func() error, error) {
+	addr := prefix.Addr()
+	if addr.Is4() {
+		var req ifaliasreq
+		copy(req.Name[:], s.name)
+		req.Addr = sockaddr4(addr)
+		req.Dstaddr = sockaddr4(addr)
+		req.Mask = sockaddr4(mask(prefix))
+		if err := ioctl(s.fd4, _SIOCAIFADDR, unsafe.Pointer(&req)); err != nil {
+			return nil, fmt.Errorf("SIOCAIFADDR: %w", err)
+		}
+		return func() error {
+			if err := ioctl(s.fd4, unix.SIOCDIFADDR, unsafe.Pointer(&req)); err != nil && err != unix.EADDRNOTAVAIL && err != unix.ENXIO {
+				return fmt.Errorf("SIOCDIFADDR: %w", err)
+			}
+			return nil
+		}, nil
+	}
+
+	req := in6Aliasreq{Vltime: nd6InfiniteLifetime, Pltime: nd6InfiniteLifetime}
+	copy(req.Name[:], s.name)
+	req.Addr = sockaddr6(addr)
+	req.Prefixmask = sockaddr6(mask(prefix))
+	if err := ioctl(s.fd6, _SIOCAIFADDR_IN6, unsafe.Pointer(&req)); err != nil {
+		return nil, fmt.Errorf("SIOCAIFADDR_IN6: %w", err)
+	}
+	return func() error {
+		var ifr in6Ifreq
+		copy(ifr.Name[:], s.name)
+		ifr.Addr = sockaddr6(addr)
+		if err := ioctl(s.fd6, _SIOCDIFADDR_IN6, unsafe.Pointer(&ifr)); err != nil && err != unix.EADDRNOTAVAIL && err != unix.ENXIO {
+			return fmt.Errorf("SIOCDIFADDR_IN6: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+func routeAddr(addr netip.Addr) route.Addr {
+	if addr.Is4() {
+		return &route.Inet4Addr{IP: addr.As4()}
+	}
+	return &route.Inet6Addr{IP: addr.As16()}
+}
+
+func (s *bsdSystem) routeMessage(typ int, prefix netip.Prefix) error {
+	s.seq++
+	addrs := make([]route.Addr, unix.RTAX_NETMASK+1)
+	addrs[unix.RTAX_DST] = routeAddr(prefix.Addr())
+	addrs[unix.RTAX_GATEWAY] = &route.LinkAddr{Index: s.index, Name: s.name}
+	flags := unix.RTF_UP | unix.RTF_STATIC
+	if prefix.IsSingleIP() {
+		flags |= unix.RTF_HOST
+		addrs = addrs[:unix.RTAX_NETMASK]
+	} else {
+		addrs[unix.RTAX_NETMASK] = routeAddr(mask(prefix))
+	}
+	msg := route.RouteMessage{
+		Version: unix.RTM_VERSION,
+		Type:    typ,
+		Flags:   flags,
+		ID:      uintptr(os.Getpid()),
+		Seq:     s.seq,
+		Addrs:   addrs,
+	}
+	b, err := msg.Marshal()
+	if err != nil {
+		return err
+	}
+	_, err = unix.Write(s.routeSocket, b)
+	return err
+}
+
+func (s *bsdSystem) addRoute(prefix netip.Prefix, table uint32) (func() error, error) {
+	if table != 0 {
+		return nil, fmt.Errorf("routing tables are %w", errUnsupported)
+	}
+	err := s.routeMessage(unix.RTM_ADD, prefix)
+	if errors.Is(err, unix.EEXIST) {
+		// Already routed to the interface, such as for the subnet of an
+		// interface address.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		err := s.routeMessage(unix.RTM_DELETE, prefix)
+		if errors.Is(err, unix.ESRCH) {
+			return nil
+		}
+		return err
+	}, nil
+}
+
+func (s *bsdSystem) addDefaultRouteRules(v6 bool, table uint32) (func() error, error) {
+	return nil, fmt.Errorf("default routes with Table = auto are %w; use Table = main or off", errUnsupported)
+}
@@ -0,0 +1,241 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package wgquick
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+/* Addresses, routes and policy rules are configured over rtnetlink with the
+ * same messages ip(8) sends for the commands run by wg-quick:
+ *
+ *   ip address add <address> dev <name>
+ *   ip link set up dev <name>
+ *   ip route add <allowed ip> dev <name> [table <table>]
+ *   ip rule add not fwmark <table> table <table>
+ *   ip rule add table main suppress_prefixlength 0
+ */
+
+const sizeofFibRuleHdr = 12
+
+type linuxSystem struct {
+	name  string
+	index int
+	fd    int
+	seq   uint32
+}
+
+func openSystem(tunDevice tun.Device) (system, error) {
+	name, err := tunDevice.Name()
+	if err != nil {
+		return nil, err
+	}
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	return &linuxSystem{name: name, index: iface.Index, fd: fd}, nil
+}
+
+func (s *linuxSystem) close() error {
+	return unix.Close(s.fd)
+}
+
+// request sends a netlink request and waits for its acknowledgement.
+func (s *linuxSystem) request(typ uint16, flags uint16, body []byte) error {
+	s.seq++
+	msg := make([]byte, unix.SizeofNlMsghdr, unix.SizeofNlMsghdr+len(body))
+	binary.NativeEndian.PutUint32(msg[0:4], uint32(unix.SizeofNlMsghdr+len(body)))
+	binary.NativeEndian.PutUint16(msg[4:6], typ)
+	binary.NativeEndian.PutUint16(msg[6:8], flags|unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	binary.NativeEndian.PutUint32(msg[8:12], s.seq)
+	msg = append(msg, body...)
+	if err := unix.Sendto(s.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return os.NewSyscallError("sendto", err)
+	}
+
+	buf := make([]byte, os.Getpagesize())
+	for {
+		n, _, err := unix.Recvfrom(s.fd, buf, 0)
+		if err != nil {
+			return os.NewSyscallError("recvfrom", err)
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if m.Header.Seq != s.seq || m.Header.Type != unix.NLMSG_ERROR {
+				continue
+			}
+			if len(m.Data) < 4 {
+				return errors.New("short netlink error message")
+			}
+			if errno := int32(binary.NativeEndian.Uint32(m.Data[0:4])); errno != 0 {
+				return unix.Errno(-errno)
+			}
+			return nil
+		}
+	}
+}
+
+func appendAttr(b []byte, typ uint16, data []byte) []byte {
+	b = binary.NativeEndian.AppendUint16(b, uint16(unix.SizeofRtAttr+len(data)))
+	b = binary.NativeEndian.AppendUint16(b, typ)
+	b = append(b, data...)
+	for len(b)%unix.NLMSG_ALIGNTO != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func appendAttrUint32(b []byte, typ uint16, v uint32) []byte {
+	return appendAttr(b, typ, binary.NativeEndian.AppendUint32(nil, v))
+}
+
+func family(addr netip.Addr) uint8 {
+	if addr.Is4() {
+		return unix.AF_INET
+	}
+	return unix.AF_INET6
+}
+
+// add sends a creation request and returns a function sending the matching
+// deletion request. Deleting something that is already gone succeeds.
+func (s *linuxSystem) add(newType, delType uint16, body []byte) (func() error, error) {
+	if err := s.request(newType, unix.NLM_F_CREATE|unix.NLM_F_EXCL, body); err != nil {
+		return nil, err
+	}
+	return func() error {
+		err := s.request(delType, 0, body)
+		if errors.Is(err, unix.ESRCH) || errors.Is(err, unix.ENOENT) || errors.Is(err, unix.ENODEV) || errors.Is(err, unix.EADDRNOTAVAIL) {
+			return nil
+		}
+		return err
+	}, nil
+}
+
+func (s *linuxSystem) setUp() error {
+	body := make([]byte, unix.SizeofIfInfomsg)
+	binary.NativeEndian.PutUint32(body[4:8], uint32(s.index))
+	binary.NativeEndian.PutUint32(body[8:12], unix.IFF_UP)
+	binary.NativeEndian.PutUint32(body[12:16], unix.IFF_UP)
+	return s.request(unix.RTM_NEWLINK, 0, body)
+}
+
+func (s *linuxSystem) addAddress(prefix netip.Prefix) (func() error, error) {
+	addr := prefix.Addr()
+	body := []byte{family(addr), uint8(prefix.Bits()), 0, unix.RT_SCOPE_UNIVERSE}
+	body = binary.NativeEndian.AppendUint32(body, uint32(s.index))
+	body = appendAttr(body, unix.IFA_LOCAL, addr.AsSlice())
+	body = appendAttr(body, unix.IFA_ADDRESS, addr.AsSlice())
+	return s.add(unix.RTM_NEWADDR, unix.RTM_DELADDR, body)
+}
+
+func (s *linuxSystem) addRoute(prefix netip.Prefix, table uint32) (func() error, error) {
+	if table == 0 {
+		table = unix.RT_TABLE_MAIN
+	}
+	body := []byte{
+		family(prefix.Addr()), uint8(prefix.Bits()), 0, 0,
+		tableByte(table), unix.RTPROT_BOOT, unix.RT_SCOPE_LINK, unix.RTN_UNICAST,
+		0, 0, 0, 0,
+	}
+	body = appendAttr(body, unix.RTA_DST, prefix.Addr().AsSlice())
+	body = appendAttrUint32(body, unix.RTA_OIF, uint32(s.index))
+	body = appendAttrUint32(body, unix.RTA_TABLE, table)
+	undo, err := s.add(unix.RTM_NEWROUTE, unix.RTM_DELROUTE, body)
+	if errors.Is(err, unix.EEXIST) {
+		// The kernel already routes the prefix to the interface, such as
+		// for the subnet of an interface address.
+		return nil, nil
+	}
+	return undo, err
+}
+
+// tableByte returns the value of the 8-bit table field of a route or rule
+// message, which is RT_TABLE_UNSPEC for tables only representable in the
+// RTA_TABLE or FRA_TABLE attribute.
+func tableByte(table uint32) uint8 {
+	if table > 0xff {
+		return unix.RT_TABLE_UNSPEC
+	}
+	return uint8(table)
+}
+
+func fibRuleHdr(fam uint8, table uint32, flags uint32) []byte {
+	body := make([]byte, sizeofFibRuleHdr)
+	body[0] = fam
+	body[4] = tableByte(table)
+	body[7] = unix.FR_ACT_TO_TBL
+	binary.NativeEndian.PutUint32(body[8:12], flags)
+	return body
+}
+
+func (s *linuxSystem) addDefaultRouteRules(v6 bool, table uint32) (func() error, error) {
+	fam := uint8(unix.AF_INET6)
+	if !v6 {
+		fam = unix.AF_INET
+		// Allow reverse path filtering to account for the mark.
+		if err := os.WriteFile("/proc/sys/net/ipv4/conf/all/src_valid_mark", []byte("1"), 0); err != nil {
+			return nil, fmt.Errorf("failed to set src_valid_mark: %w", err)
+		}
+	}
+
+	// Send everything not marked as tunnel traffic to the tunnel table.
+	toTable := fibRuleHdr(fam, table, unix.FIB_RULE_INVERT)
+	toTable = appendAttrUint32(toTable, unix.FRA_FWMARK, table)
+	toTable = appendAttrUint32(toTable, unix.FRA_TABLE, table)
+	undoToTable, err := s.add(unix.RTM_NEWRULE, unix.RTM_DELRULE, toTable)
+	if err != nil && !errors.Is(err, unix.EEXIST) {
+		return nil, err
+	}
+
+	// Ignore the default route of the main table but keep its others.
+	suppress := fibRuleHdr(fam, unix.RT_TABLE_MAIN, 0)
+	suppress = appendAttrUint32(suppress, unix.FRA_TABLE, unix.RT_TABLE_MAIN)
+	suppress = appendAttrUint32(suppress, unix.FRA_SUPPRESS_PREFIXLEN, 0)
+	undoSuppress, err := s.add(unix.RTM_NEWRULE, unix.RTM_DELRULE, suppress)
+	if err != nil && !errors.Is(err, unix.EEXIST) {
+		if undoToTable != nil {
+			undoToTable()
+		}
+		return nil, err
+	}
+
+	return func() error {
+		var errs []error
+		if undoSuppress != nil {
+			errs = append(errs, undoSuppress())
+		}
+		if undoToTable != nil {
+			errs = append(errs, undoToTable())
+		}
+		return errors.Join(errs...)
+	}, nil
+}
+
+func (s *linuxSystem) setDNS(servers []netip.Addr, search []string) (func() error, error) {
+	return setResolvconf(s.name, servers, search)
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !openbsd && !windows
+
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package wgquick
+
+import "golang.zx2c4.com/wireguard/tun"
+
+func openSystem(tunDevice tun.Device) (system, error) {
+	return nil, errUnsupported
+}
@@ -0,0 +1,220 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package wgquick
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+/* Addresses, routes and DNS servers are configured through the IP Helper
+ * API by interface LUID, like wireguard-windows does.
+ */
+
+var (
+	modiphlpapi = windows.NewLazySystemDLL("iphlpapi.dll")
+
+	procInitializeUnicastIpAddressEntry = modiphlpapi.NewProc("InitializeUnicastIpAddressEntry")
+	procCreateUnicastIpAddressEntry     = modiphlpapi.NewProc("CreateUnicastIpAddressEntry")
+	procDeleteUnicastIpAddressEntry     = modiphlpapi.NewProc("DeleteUnicastIpAddressEntry")
+	procInitializeIpForwardEntry        = modiphlpapi.NewProc("InitializeIpForwardEntry")
+	procCreateIpForwardEntry2           = modiphlpapi.NewProc("CreateIpForwardEntry2")
+	procDeleteIpForwardEntry2           = modiphlpapi.NewProc("DeleteIpForwardEntry2")
+	procConvertInterfaceLuidToGuid      = modiphlpapi.NewProc("ConvertInterfaceLuidToGuid")
+	procSetInterfaceDnsSettings         = modiphlpapi.NewProc("SetInterfaceDnsSettings")
+)
+
+const (
+	ipDadStatePreferred = 4
+
+	dnsInterfaceSettingsVersion1 = 1
+	dnsSettingIPv6               = 0x0001
+	dnsSettingNameserver         = 0x0002
+	dnsSettingSearchList         = 0x0004
+)
+
+type ipAddressPrefix struct {
+	Prefix       windows.RawSockaddrInet6 // SOCKADDR_INET union
+	PrefixLength uint8
+	_            [3]byte
+}
+
+// mibIPforwardRow2 is MIB_IPFORWARD_ROW2 from netioapi.h.
+type mibIPforwardRow2 struct {
+	InterfaceLuid        uint64
+	InterfaceIndex       uint32
+	DestinationPrefix    ipAddressPrefix
+	NextHop              windows.RawSockaddrInet6 // SOCKADDR_INET union
+	SitePrefixLength     uint8
+	ValidLifetime        uint32
+	PreferredLifetime    uint32
+	Metric               uint32
+	Protocol             uint32
+	Loopback             uint8
+	AutoconfigureAddress uint8
+	Publish              uint8
+	Immortal             uint8
+	Age                  uint32
+	Origin               uint32
+}
+
+// dnsInterfaceSettings is DNS_INTERFACE_SETTINGS from netioapi.h.
+type dnsInterfaceSettings struct {
+	Version             uint32
+	Flags               uint64
+	Domain              *uint16
+	NameServer          *uint16
+	SearchList          *uint16
+	RegistrationEnabled uint32
+	RegisterAdapterName uint32
+	EnableLLMNR         uint32
+	QueryAdapterName    uint32
+	ProfileNameServer   *uint16
+}
+
+type windowsSystem struct {
+	luid uint64
+}
+
+func openSystem(tunDevice tun.Device) (system, error) {
+	adapter, ok := tunDevice.(interface{ LUID() uint64 })
+	if !ok {
+		return nil, errors.New("TUN device has no interface LUID")
+	}
+	return &windowsSystem{luid: adapter.LUID()}, nil
+}
+
+func (s *windowsSystem) close() error {
+	return nil
+}
+
+func call(proc *windows.LazyProc, args ...uintptr) error {
+	if err := proc.Find(); err != nil {
+		return err
+	}
+	r, _, _ := proc.Call(args...)
+	if r != 0 {
+		return windows.Errno(r)
+	}
+	return nil
+}
+
+// setSockaddrInet writes addr to a SOCKADDR_INET union.
+func setSockaddrInet(sa *windows.RawSockaddrInet6, addr netip.Addr) {
+	*sa = windows.RawSockaddrInet6{}
+	if addr.Is4() {
+		sa4 := (*windows.RawSockaddrInet4)(unsafe.Pointer(sa))
+		sa4.Family = windows.AF_INET
+		sa4.Addr = addr.As4()
+		return
+	}
+	sa.Family = windows.AF_INET6
+	sa.Addr = addr.As16()
+}
+
+// setUp does nothing, as a Wintun adapter is up while its session is open.
+func (s *windowsSystem) setUp() error {
+	return nil
+}
+
+func (s *windowsSystem) addAddress(prefix netip.Prefix) (func() error, error) {
+	row := new(windows.MibUnicastIpAddressRow)
+	procInitializeUnicastIpAddressEntry.Call(uintptr(unsafe.Pointer(row)))
+	setSockaddrInet(&row.Address, prefix.Addr())
+	row.InterfaceLuid = s.luid
+	row.OnLinkPrefixLength = uint8(prefix.Bits())
+	row.DadState = ipDadStatePreferred
+	if err := call(procCreateUnicastIpAddressEntry, uintptr(unsafe.Pointer(row))); err != nil {
+		return nil, fmt.Errorf("CreateUnicastIpAddressEntry: %w", err)
+	}
+	return func() error {
+		err := call(procDeleteUnicastIpAddressEntry, uintptr(unsafe.Pointer(row)))
+		if err != nil && err != windows.ERROR_NOT_FOUND {
+			return fmt.Errorf("DeleteUnicastIpAddressEntry: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+func (s *windowsSystem) addRoute(prefix netip.Prefix, table uint32) (func() error, error) {
+	if table != 0 {
+		return nil, fmt.Errorf("routing tables are %w", errUnsupported)
+	}
+	row := new(mibIPforwardRow2)
+	procInitializeIpForwardEntry.Call(uintptr(unsafe.Pointer(row)))
+	row.InterfaceLuid = s.luid
+	setSockaddrInet(&row.DestinationPrefix.Prefix, prefix.Addr())
+	row.DestinationPrefix.PrefixLength = uint8(prefix.Bits())
+	if prefix.Addr().Is4() {
+		setSockaddrInet(&row.NextHop, netip.IPv4Unspecified())
+	} else {
+		setSockaddrInet(&row.NextHop, netip.IPv6Unspecified())
+	}
+	err := call(procCreateIpForwardEntry2, uintptr(unsafe.Pointer(row)))
+	if err == windows.ERROR_OBJECT_ALREADY_EXISTS {
+		// Already routed to the interface, such as for the subnet of an
+		// interface address.
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("CreateIpForwardEntry2: %w", err)
+	}
+	return func() error {
+		err := call(procDeleteIpForwardEntry2, uintptr(unsafe.Pointer(row)))
+		if err != nil && err != windows.ERROR_NOT_FOUND {
+			return fmt.Errorf("DeleteIpForwardEntry2: %w", err)
+		}
+		return nil
+	}, nil
+}
+
+func (s *windowsSystem) addDefaultRouteRules(v6 bool, table uint32) (func() error, error) {
+	return nil, fmt.Errorf("default routes with Table = auto are %w; use Table = main or off", errUnsupported)
+}
+
+func (s *windowsSystem) setDNS(servers []netip.Addr, search []string) (func() error, error) {
+	var guid windows.GUID
+	if err := call(procConvertInterfaceLuidToGuid, uintptr(unsafe.Pointer(&s.luid)), uintptr(unsafe.Pointer(&guid))); err != nil {
+		return nil, fmt.Errorf("ConvertInterfaceLuidToGuid: %w", err)
+	}
+	var v4, v6 []string
+	for _, ip := range servers {
+		if ip.Is4() {
+			v4 = append(v4, ip.String())
+		} else {
+			v6 = append(v6, ip.String())
+		}
+	}
+	set := func(flags uint64, servers []string, search []string) error {
+		settings := dnsInterfaceSettings{
+			Version:    dnsInterfaceSettingsVersion1,
+			Flags:      flags | dnsSettingNameserver | dnsSettingSearchList,
+			NameServer: windows.StringToUTF16Ptr(strings.Join(servers, ",")),
+			SearchList: windows.StringToUTF16Ptr(strings.Join(search, ",")),
+		}
+		if err := call(procSetInterfaceDnsSettings, uintptr(unsafe.Pointer(&guid)), uintptr(unsafe.Pointer(&settings))); err != nil {
+			return fmt.Errorf("SetInterfaceDnsSettings: %w", err)
+		}
+		return nil
+	}
+	if err := set(0, v4, search); err != nil {
+		return nil, err
+	}
+	if err := set(dnsSettingIPv6, v6, search); err != nil {
+		set(0, nil, nil)
+		return nil, err
+	}
+	return func() error {
+		return errors.Join(set(0, nil, nil), set(dnsSettingIPv6, nil, nil))
+	}, nil
+}
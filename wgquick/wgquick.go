@@ -0,0 +1,189 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+// Package wgquick brings a WireGuard interface up and down like wg-quick(8),
+// configuring addresses, routes and DNS natively rather than through the ip,
+// route, ifconfig or netsh commands.
+package wgquick
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// DefaultTable is the routing table and firewall mark used for default
+// routes when Table is "auto" and no FwMark is configured, as in wg-quick.
+const DefaultTable = 51820
+
+// A system configures the network stack of one interface. Each add method
+// returns a function that reverts its change.
+type system interface {
+	setUp() error
+	addAddress(prefix netip.Prefix) (func() error, error)
+	addRoute(prefix netip.Prefix, table uint32) (func() error, error)
+	addDefaultRouteRules(v6 bool, table uint32) (func() error, error)
+	setDNS(servers []netip.Addr, search []string) (func() error, error)
+	close() error
+}
+
+var errUnsupported = errors.New("not supported on this platform")
+
+// routePlan lists the routes Up installs for a configuration.
+type routePlan struct {
+	routes []netip.Prefix // routes in table, most specific first
+	table  uint32         // zero for the main table
+
+	// Default routes are installed in their own table, selected with
+	// policy rules so that tunnel traffic itself, marked with the table
+	// number, still uses the main table.
+	defaultRoutes []netip.Prefix
+	defaultTable  uint32
+}
+
+// plan computes the routes for the allowed IPs of every peer.
+func (c *Config) plan() (routePlan, error) {
+	var p routePlan
+	table := c.Interface.Table
+	switch table {
+	case "off":
+		return p, nil
+	case "", "auto", "main":
+	default:
+		t, err := strconv.ParseUint(table, 10, 32)
+		if err != nil {
+			return p, fmt.Errorf("invalid table %q", table)
+		}
+		p.table = uint32(t)
+	}
+
+	for _, peer := range c.Peers {
+		for _, prefix := range peer.AllowedIPs {
+			prefix = prefix.Masked()
+			if prefix.Bits() == 0 && (table == "" || table == "auto") {
+				if !slices.Contains(p.defaultRoutes, prefix) {
+					p.defaultRoutes = append(p.defaultRoutes, prefix)
+				}
+			} else if !slices.Contains(p.routes, prefix) {
+				p.routes = append(p.routes, prefix)
+			}
+		}
+	}
+	slices.SortStableFunc(p.routes, func(a, b netip.Prefix) int {
+		return b.Bits() - a.Bits()
+	})
+	if len(p.defaultRoutes) != 0 {
+		p.defaultTable = DefaultTable
+		if mark := c.Interface.FwMark; mark != nil && *mark != 0 {
+			p.defaultTable = uint32(*mark)
+		}
+	}
+	return p, nil
+}
+
+// Interface is a WireGuard interface brought up by Up.
+type Interface struct {
+	sys  system
+	undo []func() error
+}
+
+// Up configures dev from cfg and then, like wg-quick up, assigns the
+// interface addresses of tunDevice, brings it up, adds a route for every
+// allowed IP and sets the DNS servers. The TUN device should have been
+// created with cfg.TUNMTU(). Hook commands are not run. On failure, the
+// changes made so far are reverted.
+func Up(dev *device.Device, tunDevice tun.Device, cfg *Config) (*Interface, error) {
+	plan, err := cfg.plan()
+	if err != nil {
+		return nil, err
+	}
+	dc, err := cfg.DeviceConfig()
+	if err != nil {
+		return nil, err
+	}
+	if plan.defaultTable != 0 {
+		mark := int(plan.defaultTable)
+		dc.FirewallMark = &mark
+	}
+	if err := dev.Configure(dc); err != nil {
+		return nil, err
+	}
+	if err := dev.Up(); err != nil {
+		return nil, err
+	}
+
+	sys, err := openSystem(tunDevice)
+	if err != nil {
+		return nil, err
+	}
+	iface := &Interface{sys: sys}
+	if err := iface.up(cfg, plan); err != nil {
+		iface.Down()
+		return nil, err
+	}
+	return iface, nil
+}
+
+func (iface *Interface) up(cfg *Config, plan routePlan) error {
+	for _, prefix := range cfg.Interface.Addresses {
+		if err := iface.do(iface.sys.addAddress(prefix)); err != nil {
+			return fmt.Errorf("failed to add address %v: %w", prefix, err)
+		}
+	}
+	if err := iface.sys.setUp(); err != nil {
+		return fmt.Errorf("failed to bring up interface: %w", err)
+	}
+	for _, prefix := range plan.routes {
+		if err := iface.do(iface.sys.addRoute(prefix, plan.table)); err != nil {
+			return fmt.Errorf("failed to add route %v: %w", prefix, err)
+		}
+	}
+	for _, prefix := range plan.defaultRoutes {
+		if err := iface.do(iface.sys.addDefaultRouteRules(prefix.Addr().Is6(), plan.defaultTable)); err != nil {
+			return fmt.Errorf("failed to add policy rules for %v: %w", prefix, err)
+		}
+		if err := iface.do(iface.sys.addRoute(prefix, plan.defaultTable)); err != nil {
+			return fmt.Errorf("failed to add route %v: %w", prefix, err)
+		}
+	}
+	if len(cfg.Interface.DNS) != 0 || len(cfg.Interface.DNSSearch) != 0 {
+		if err := iface.do(iface.sys.setDNS(cfg.Interface.DNS, cfg.Interface.DNSSearch)); err != nil {
+			return fmt.Errorf("failed to set DNS: %w", err)
+		}
+	}
+	return nil
+}
+
+func (iface *Interface) do(undo func() error, err error) error {
+	if err != nil {
+		return err
+	}
+	if undo != nil {
+		iface.undo = append(iface.undo, undo)
+	}
+	return nil
+}
+
+// Down reverts the changes made by Up in reverse order. It does not close
+// the device; addresses and routes that Down cannot remove disappear with
+// the TUN device once it is closed.
+func (iface *Interface) Down() error {
+	var errs []error
+	for i := len(iface.undo) - 1; i >= 0; i-- {
+		if err := iface.undo[i](); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	iface.undo = nil
+	if err := iface.sys.close(); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
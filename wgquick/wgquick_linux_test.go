@@ -0,0 +1,106 @@
+/* SPDX-License-Identifier: MIT
+ *
+ * Copyright (C) 2017-2025 WireGuard LLC. All Rights Reserved.
+ */
+
+package wgquick
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/conn/bindtest"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+func interfaceAddrs(t *testing.T, name string) []netip.Prefix {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prefixes []netip.Prefix
+	for _, addr := range addrs {
+		prefix, err := netip.ParsePrefix(addr.String())
+		if err == nil && !prefix.Addr().IsLinkLocalUnicast() {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	return prefixes
+}
+
+// hasRoute reports whether the main IPv4 table has a route through the
+// interface to dst, which is in the hexadecimal form of /proc/net/route.
+func hasRoute(t *testing.T, name, dst string) bool {
+	b, err := os.ReadFile("/proc/net/route")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[0] == name && fields[1] == dst {
+			return true
+		}
+	}
+	return false
+}
+
+func TestUpDown(t *testing.T) {
+	cfg, err := Parse(strings.NewReader(`
+[Interface]
+PrivateKey = yAnz5TF+lXXJte14tji3zlMNq+hd2rYUIgJBgB3fBmk=
+Address = 10.213.0.1/24, fd99:213::1/64
+
+[Peer]
+PublicKey = xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=
+AllowedIPs = 10.213.0.0/24, 10.214.0.0/16, fd99:214::/48
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tunDevice, err := tun.CreateTUN("wgquicktest", cfg.TUNMTU())
+	if err != nil {
+		t.Skipf("cannot create TUN device: %v", err)
+	}
+	dev := device.NewDevice(tunDevice, bindtest.NewChannelBinds()[0], device.NewLogger(device.LogLevelError, ""))
+	defer dev.Close()
+
+	iface, err := Up(dev, tunDevice, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs := interfaceAddrs(t, "wgquicktest")
+	for _, want := range cfg.Interface.Addresses {
+		found := false
+		for _, addr := range addrs {
+			found = found || addr == want
+		}
+		if !found {
+			t.Errorf("address %v missing from %v", want, addrs)
+		}
+	}
+	if netIface, _ := net.InterfaceByName("wgquicktest"); netIface.Flags&net.FlagUp == 0 {
+		t.Error("interface is not up")
+	}
+
+	if !hasRoute(t, "wgquicktest", "0000D60A") {
+		t.Error("route to 10.214.0.0/16 missing")
+	}
+
+	if err := iface.Down(); err != nil {
+		t.Fatal(err)
+	}
+	if addrs := interfaceAddrs(t, "wgquicktest"); len(addrs) != 0 {
+		t.Errorf("addresses %v left after Down", addrs)
+	}
+	if hasRoute(t, "wgquicktest", "0000D60A") {
+		t.Error("route to 10.214.0.0/16 left after Down")
+	}
+}